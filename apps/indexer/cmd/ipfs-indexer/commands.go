@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/atregu/ipfs-common/configschema"
+
+	"github.com/atregu/ipfs-indexer/internal/aggregator"
+	"github.com/atregu/ipfs-indexer/internal/check"
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/atregu/ipfs-indexer/internal/fetcher"
+	"github.com/atregu/ipfs-indexer/internal/ipfs"
+	"github.com/atregu/ipfs-indexer/internal/logger"
+	"github.com/atregu/ipfs-indexer/internal/parser"
+	"github.com/atregu/ipfs-indexer/internal/share"
+	"github.com/atregu/ipfs-indexer/internal/verify"
+)
+
+// reparseTimeout bounds reading the pinned index of a collection
+const reparseTimeout = 10 * time.Minute
+
+// checkTimeout bounds a full check run including the IPFS node startup
+const checkTimeout = 2 * time.Minute
+
+// runCommand runs a one-shot subcommand
+func runCommand(args []string) error {
+	switch args[0] {
+	case "add-collection":
+		return runAddCollection(args[1:])
+	case "config":
+		return runConfig(args[1:])
+	case "check":
+		return runCheck(args[1:])
+	case "reparse":
+		return runReparse(args[1:])
+	case "preview":
+		return runPreview(args[1:])
+	case "collections":
+		return runCollections(args[1:])
+	case "verify":
+		return runVerify(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// newCommandFlags creates the flag set of a subcommand, which accepts -config
+// and -ignore-unknown-config after the command name as well as before it
+func newCommandFlags(name string) (*flag.FlagSet, *string) {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	path := fs.String("config", *configPath, "Path to configuration file")
+	fs.BoolVar(ignoreUnknownConfig, "ignore-unknown-config", *ignoreUnknownConfig, "Warn about unknown config keys instead of failing")
+	return fs, path
+}
+
+// runConfig prints the recognized configuration keys with their types,
+// defaults and descriptions
+func runConfig(args []string) error {
+	if len(args) != 1 || args[0] != "schema" {
+		return fmt.Errorf("usage: ipfs-indexer config schema")
+	}
+
+	fields, err := config.Schema()
+	if err != nil {
+		return err
+	}
+	return configschema.Write(os.Stdout, fields)
+}
+
+// runAddCollection registers the collections of a share document as pending,
+// bypassing PubSub discovery
+func runAddCollection(args []string) error {
+	fs, path := newCommandFlags("add-collection")
+	fromShare := fs.String("from-share", "", "Share document file or mdn://share/ URI")
+	fs.Parse(args)
+	if *fromShare == "" || fs.NArg() != 0 {
+		return fmt.Errorf("usage: ipfs-indexer add-collection [-config path] --from-share <file|uri>")
+	}
+
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		return err
+	}
+	log := logger.Get()
+
+	id, err := share.Load(*fromShare)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.New(cfg.Database.Path, log)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	result, err := share.Register(db, id, cfg, log)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range result.Created {
+		fmt.Printf("✓ Registered collection %d: %s (version %d)\n", c.ID, c.IPNS, c.Version)
+	}
+	for _, ipns := range result.Known {
+		fmt.Printf("- Already known: %s\n", ipns)
+	}
+	for _, ipns := range result.Refused {
+		fmt.Printf("✗ Refused by the publisher quota: %s\n", ipns)
+	}
+	if len(result.Refused) > 0 {
+		return fmt.Errorf("%d of %d collections refused", len(result.Refused), len(id.Collections))
+	}
+	return nil
+}
+
+// runCheck runs the static checks, then starts the IPFS node briefly, joins the
+// topic and reports peer counts. It exits with status 1 if any check failed.
+func runCheck(args []string) error {
+	fs, path := newCommandFlags("check")
+	peerWait := fs.Duration("peer-wait", 10*time.Second, "How long to wait for peers after joining the topic")
+	fs.Parse(args)
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	runChecks(ctx, *path, check.Options{StartNode: true, PeerWait: *peerWait, IgnoreUnknownKeys: *ignoreUnknownConfig})
+	return nil
+}
+
+// runChecks prints the check report and exits with status 1 if any check failed
+func runChecks(ctx context.Context, path string, opts check.Options) {
+	report := check.Run(ctx, path, opts)
+	report.Print(os.Stdout)
+	if code := report.ExitCode(); code != 0 {
+		os.Exit(code)
+	}
+}
+
+// runReparse re-parses a collection from its pinned index CID without re-downloading it.
+// The indexer must not be running, since the IPFS repository is locked by the daemon.
+func runReparse(args []string) error {
+	fs, path := newCommandFlags("reparse")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ipfs-indexer reparse [-config path] <collection-id>")
+	}
+
+	collectionID, err := strconv.ParseInt(fs.Arg(0), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid collection ID %q: %w", fs.Arg(0), err)
+	}
+
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		return err
+	}
+	log := logger.Get()
+
+	db, err := database.New(cfg.Database.Path, log)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	ipfsClient, err := ipfs.NewClient(&cfg.IPFS.Embedded)
+	if err != nil {
+		return fmt.Errorf("failed to create IPFS client: %w", err)
+	}
+	if err := ipfsClient.Start(); err != nil {
+		return fmt.Errorf("failed to start IPFS node: %w", err)
+	}
+	defer ipfsClient.Close()
+
+	contentParser := parser.NewParser(db, &cfg.Limits, cfg.Fetcher.InsertRetries, log)
+	contentParser.SetClaims(&cfg.Claims)
+	collectionFetcher := fetcher.NewFetcher(ipfsClient, db, contentParser, &cfg.Fetcher, log)
+
+	ctx, cancel := context.WithTimeout(context.Background(), reparseTimeout)
+	defer cancel()
+
+	if err := collectionFetcher.Reparse(ctx, collectionID); err != nil {
+		return fmt.Errorf("failed to reparse collection %d: %w", collectionID, err)
+	}
+
+	fmt.Printf("✓ Collection %d reparsed\n", collectionID)
+	return nil
+}
+
+// runVerify checks the downloaded collections against the pins and content of
+// the IPFS node and exits with status 1 if it found any problem, fixed or not.
+// The indexer must not be running, since the IPFS repository is locked by the daemon.
+func runVerify(args []string) error {
+	fs, path := newCommandFlags("verify")
+	fix := fs.Bool("fix", false, "Re-pin unpinned indexes, reparse miscounted collections and mark unreadable ones unretrievable")
+	concurrency := fs.Int("concurrency", 4, "Number of collections checked at once")
+	timeout := fs.Duration("timeout", 2*time.Minute, "How long reading the index of a collection may take")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		return fmt.Errorf("usage: ipfs-indexer verify [-config path] [-fix] [-concurrency n] [-timeout d]")
+	}
+
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		return err
+	}
+	log := logger.Get()
+
+	db, err := database.New(cfg.Database.Path, log)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	// The aggregate is pinned by the indexer without a collection row
+	var knownPins []string
+	if cfg.Aggregator.Enabled {
+		state, err := aggregator.LoadState(cfg.Aggregator.StatePath)
+		if err != nil {
+			return err
+		}
+		knownPins = append(knownPins, state.RootCID)
+	}
+
+	ipfsClient, err := ipfs.NewClient(&cfg.IPFS.Embedded)
+	if err != nil {
+		return fmt.Errorf("failed to create IPFS client: %w", err)
+	}
+	if err := ipfsClient.Start(); err != nil {
+		return fmt.Errorf("failed to start IPFS node: %w", err)
+	}
+	defer ipfsClient.Close()
+
+	contentParser := parser.NewParser(db, &cfg.Limits, cfg.Fetcher.InsertRetries, log)
+	contentParser.SetClaims(&cfg.Claims)
+	collectionFetcher := fetcher.NewFetcher(ipfsClient, db, contentParser, &cfg.Fetcher, log)
+
+	verifier := verify.New(ipfsClient, db, contentParser, collectionFetcher, log)
+	report, err := verifier.Run(context.Background(), verify.Options{
+		Fix:         *fix,
+		Concurrency: *concurrency,
+		Timeout:     *timeout,
+		KnownPins:   knownPins,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to verify: %w", err)
+	}
+
+	report.Print(os.Stdout)
+	if code := report.ExitCode(); code != 0 {
+		// Deferred cleanup is skipped by os.Exit
+		ipfsClient.Close()
+		db.Close()
+		os.Exit(code)
+	}
+	return nil
+}
+
+// runCollections lists the latest version of every collection with its status
+// and its indexed item count next to the count its announcement declared
+func runCollections(args []string) error {
+	fs, path := newCommandFlags("collections")
+	publisher := fs.String("publisher", "", "Public key or fingerprint of the publisher to list (default: all)")
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		return fmt.Errorf("usage: ipfs-indexer collections [-config path] [-publisher key]")
+	}
+
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.New(cfg.Database.Path, logger.Get())
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	var publisherID int64
+	if *publisher != "" {
+		p, err := db.FindPublisher(*publisher)
+		if err != nil {
+			return err
+		}
+		publisherID = p.ID
+	}
+
+	collections, err := db.ListCollections(publisherID, true)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%-6s %-8s %-11s %9s %9s  %s\n", "ID", "VERSION", "STATUS", "ITEMS", "ANNOUNCED", "IPNS")
+	for _, c := range collections {
+		announced := "-"
+		if c.AnnouncedSize != nil {
+			announced = strconv.Itoa(*c.AnnouncedSize)
+		}
+		fmt.Printf("%-6d %-8d %-11s %9d %9s  %s\n", c.ID, c.Version, c.Status, c.ItemsStored, announced, c.IPNS)
+	}
+	return nil
+}
+
+// runPreview dry-runs the ingestion of a collection: its index is resolved,
+// downloaded up to fetcher.preview_max_bytes and parsed in memory, and only a
+// summary is printed. The indexer must not be running, since the IPFS repository
+// is locked by the daemon; use POST /api/collections/preview instead.
+func runPreview(args []string) error {
+	fs, path := newCommandFlags("preview")
+	publisher := fs.String("publisher", "", "Public key or fingerprint to verify claims against (default: the publisher that announced the name)")
+	asJSON := fs.Bool("json", false, "Print the summary as JSON")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: ipfs-indexer preview [-config path] [-publisher key] [-json] <ipns>")
+	}
+
+	cfg, err := loadConfig(*path)
+	if err != nil {
+		return err
+	}
+	log := logger.Get()
+
+	db, err := database.New(cfg.Database.Path, log)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	ipfsClient, err := ipfs.NewClient(&cfg.IPFS.Embedded)
+	if err != nil {
+		return fmt.Errorf("failed to create IPFS client: %w", err)
+	}
+	if err := ipfsClient.Start(); err != nil {
+		return fmt.Errorf("failed to start IPFS node: %w", err)
+	}
+	defer ipfsClient.Close()
+
+	contentParser := parser.NewParser(db, &cfg.Limits, cfg.Fetcher.InsertRetries, log)
+	contentParser.SetClaims(&cfg.Claims)
+	collectionFetcher := fetcher.NewFetcher(ipfsClient, db, contentParser, &cfg.Fetcher, log)
+
+	result, err := collectionFetcher.Preview(context.Background(), fs.Arg(0), *publisher)
+	if err != nil {
+		return fmt.Errorf("failed to preview %s: %w", fs.Arg(0), err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(result)
+	}
+	printPreview(result)
+	return nil
+}
+
+// printPreview prints the summary of a preview for humans
+func printPreview(r *fetcher.PreviewResult) {
+	fmt.Printf("IPNS:       %s\n", r.IPNS)
+	fmt.Printf("Root CID:   %s\n", r.RootCID)
+	fmt.Printf("Index CID:  %s\n", r.IndexCID)
+	if r.Partial {
+		fmt.Printf("Read:       %d bytes (partial: the index exceeds fetcher.preview_max_bytes)\n", r.Bytes)
+	} else {
+		fmt.Printf("Read:       %d bytes\n", r.Bytes)
+	}
+
+	items := fmt.Sprintf("%d", r.Items)
+	if r.Truncated {
+		items += " (truncated at limits.max_items_per_collection)"
+	}
+	fmt.Printf("Items:      %s\n", items)
+	fmt.Printf("Errors:     %d lines\n", r.Errors)
+
+	fmt.Printf("Schema:     %d\n", r.Schema)
+	if r.Header != nil {
+		fmt.Printf("Header:     visibility=%q license=%q generator=%q\n", r.Header.Visibility, r.Header.License, r.Header.Generator)
+	} else {
+		fmt.Println("Header:     none")
+	}
+
+	exts := make([]string, 0, len(r.Extensions))
+	for ext := range r.Extensions {
+		exts = append(exts, ext)
+	}
+	sort.Slice(exts, func(i, j int) bool {
+		if r.Extensions[exts[i]] != r.Extensions[exts[j]] {
+			return r.Extensions[exts[i]] > r.Extensions[exts[j]]
+		}
+		return exts[i] < exts[j]
+	})
+	counts := make([]string, 0, len(exts))
+	for _, ext := range exts {
+		counts = append(counts, fmt.Sprintf("%s %d", ext, r.Extensions[ext]))
+	}
+	fmt.Printf("Extensions: %s\n", strings.Join(counts, ", "))
+
+	if r.ClaimsVerified {
+		fmt.Printf("Claims:     %d signed, %d verified, %d failed against %s\n", r.Signed, r.Endorsed, r.BadClaims, r.Publisher)
+	} else {
+		fmt.Printf("Claims:     %d signed, not verified: no publisher key (use -publisher)\n", r.Signed)
+	}
+
+	if len(r.Samples) > 0 {
+		fmt.Println("Samples:")
+		for _, name := range r.Samples {
+			fmt.Printf("  %s\n", name)
+		}
+	}
+}
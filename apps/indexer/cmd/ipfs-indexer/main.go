@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/atregu/ipfs-common/stats"
+	"github.com/atregu/ipfs-common/tracing"
+
+	"github.com/atregu/ipfs-indexer/internal/aggregator"
+	"github.com/atregu/ipfs-indexer/internal/api"
+	"github.com/atregu/ipfs-indexer/internal/catchup"
+	"github.com/atregu/ipfs-indexer/internal/check"
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/atregu/ipfs-indexer/internal/fetcher"
+	"github.com/atregu/ipfs-indexer/internal/ipfs"
+	"github.com/atregu/ipfs-indexer/internal/logger"
+	"github.com/atregu/ipfs-indexer/internal/parser"
+	"github.com/atregu/ipfs-indexer/internal/pubsub"
+)
+
+// shutdownTimeout bounds how long each shutdown step waits, such as for active
+// API requests or an announcement being stored
+const shutdownTimeout = 5 * time.Second
+
+var (
+	configPath     = flag.String("config", "config.yaml", "Path to configuration file")
+	validateConfig = flag.Bool("validate-config", false, "Check the config, topic and database without network access and exit")
+
+	ignoreUnknownConfig = flag.Bool("ignore-unknown-config", false, "Warn about unknown config keys instead of failing, e.g. after downgrading")
+)
+
+func main() {
+	flag.Parse()
+
+	// Subcommands run once and exit
+	if flag.NArg() > 0 {
+		if err := runCommand(flag.Args()); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *validateConfig {
+		runChecks(context.Background(), *configPath, check.Options{StaticOnly: true, IgnoreUnknownKeys: *ignoreUnknownConfig})
+		return
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		os.Exit(1)
+	}
+
+	log := logger.Get()
+	log.Info("Starting IPFS Indexer...")
+	for _, warning := range cfg.Warnings() {
+		log.Warn(warning)
+	}
+	log.Infof("Listening for announcements on PubSub topic %q", cfg.Pubsub.Topic)
+
+	// Spans cost nothing unless tracing is enabled
+	var stopTracing func(context.Context) error
+	if cfg.Tracing.Enabled {
+		stopTracing, err = tracing.Setup(context.Background(), "ipfs-indexer")
+		if err != nil {
+			log.Fatalf("Failed to set up tracing: %v", err)
+		}
+		log.Info("Exporting OpenTelemetry traces over OTLP")
+	}
+
+	// Initialize database
+	log.Info("Initializing database...")
+	db, err := database.New(cfg.Database.Path, log)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	// Initialize IPFS client
+	log.Info("Initializing IPFS client...")
+	ipfsClient, err := ipfs.NewClient(&cfg.IPFS.Embedded)
+	if err != nil {
+		log.Fatalf("Failed to create IPFS client: %v", err)
+	}
+
+	// Start IPFS node
+	if err := ipfsClient.Start(); err != nil {
+		log.Fatalf("Failed to start IPFS node: %v", err)
+	}
+
+	// Initialize parser and fetcher, which the HTTP server uses for previews
+	contentParser := parser.NewParser(db, &cfg.Limits, cfg.Fetcher.InsertRetries, log)
+	contentParser.SetClaims(&cfg.Claims)
+	collectionFetcher := fetcher.NewFetcher(ipfsClient, db, contentParser, &cfg.Fetcher, log)
+
+	// Start the HTTP server
+	var server *api.Server
+	if cfg.API.ListenAddr != "" {
+		server = api.NewServer(cfg.API.ListenAddr, &cfg.API, log)
+		if err := server.Register(stats.NewRepoCollector("ipfsindexer", ipfsClient.RepoStat)); err != nil {
+			log.Fatalf("Failed to register metrics: %v", err)
+		}
+		if err := server.Register(stats.NewRuntimeCollector("ipfsindexer")); err != nil {
+			log.Fatalf("Failed to register metrics: %v", err)
+		}
+		if err := server.Register(api.NewCollectionStatusCollector(db)); err != nil {
+			log.Fatalf("Failed to register metrics: %v", err)
+		}
+		if err := server.Register(api.NewReachCollector(db)); err != nil {
+			log.Fatalf("Failed to register metrics: %v", err)
+		}
+		if err := server.Register(api.NewIncompleteCollector(collectionFetcher.IncompleteCount)); err != nil {
+			log.Fatalf("Failed to register metrics: %v", err)
+		}
+		if err := server.Register(api.NewFetchSourceCollector(collectionFetcher.FetchCount)); err != nil {
+			log.Fatalf("Failed to register metrics: %v", err)
+		}
+		server.Mux().Handle("/api/v1/ipfs/repo", stats.RepoHandler(ipfsClient.RepoStat))
+		server.Mux().Handle("/api/v1/status/runtime", stats.RuntimeHandler())
+		server.Mux().Handle("/api/v1/pubsub/reach", api.ReachHandler(db))
+		server.Mux().Handle("/api/v1/pubsub/received", api.ReceivedHandler(db))
+		server.Mux().Handle("/api/collections/preview", api.PreviewHandler(
+			func(ctx context.Context, ipns, publisher string) (any, error) {
+				return collectionFetcher.Preview(ctx, ipns, publisher)
+			}))
+		api.RegisterREST(server.Mux(), db)
+		api.RegisterUI(server.Mux(), &cfg.API)
+		if err := server.Start(); err != nil {
+			log.Fatalf("Failed to start API server: %v", err)
+		}
+	}
+
+	// Start fetcher
+	log.Info("Starting collection fetcher...")
+	if err := collectionFetcher.Start(); err != nil {
+		log.Fatalf("Failed to start fetcher: %v", err)
+	}
+
+	// Initialize PubSub listener
+	log.Info("Initializing PubSub listener...")
+	pubsubListener := pubsub.NewListener(ipfsClient, db, cfg.Pubsub.Topic, &cfg.Limits, log)
+	seen, err := pubsub.NewSeenCache(cfg.Pubsub.Listener.MessageCacheSize,
+		2*time.Duration(cfg.Pubsub.Listener.AnnounceInterval)*time.Second)
+	if err != nil {
+		log.Fatalf("Failed to create message cache: %v", err)
+	}
+	pubsubListener.SetSeenCache(seen)
+	pubsubListener.SetDedupeWindow(time.Duration(cfg.Pubsub.Listener.DedupeWindow) * time.Second)
+	pubsubListener.SetVerification(cfg.Pubsub.Listener.VerificationEnabled)
+	if cfg.Pubsub.Ack.Enabled {
+		if key, err := ipfsClient.SigningKey(); err != nil {
+			log.Warnf("Announcement acks disabled: %v", err)
+		} else {
+			acker := pubsub.NewAcker(ipfsClient, cfg.Pubsub.Topic, key,
+				time.Duration(cfg.Pubsub.Ack.IntervalSeconds)*time.Second, cfg.Pubsub.Ack.MaxPerMinute, log)
+			pubsubListener.SetAcker(acker)
+			log.Infof("Acknowledging stored announcements on PubSub topic %q", acker.Topic())
+		}
+	}
+
+	// The aggregator's own announcements come back through the subscription
+	var catalogAggregator *aggregator.Aggregator
+	if cfg.Aggregator.Enabled {
+		catalogAggregator, err = aggregator.New(ipfsClient, db, &cfg.Aggregator, cfg.Pubsub.Topic, log)
+		if err != nil {
+			log.Fatalf("Failed to create aggregator: %v", err)
+		}
+		pubsubListener.IgnorePublisher(catalogAggregator.PublicKey())
+	}
+
+	if err := pubsubListener.Start(); err != nil {
+		log.Fatalf("Failed to start PubSub listener: %v", err)
+	}
+
+	if catalogAggregator != nil {
+		if err := catalogAggregator.Start(); err != nil {
+			log.Fatalf("Failed to start aggregator: %v", err)
+		}
+	}
+
+	// Catch up on announcements missed while the indexer was down
+	var stopCatchUp func(ctx context.Context) error
+	if cfg.Pubsub.CatchUp.Enabled {
+		key, err := ipfsClient.SigningKey()
+		if err != nil {
+			log.Warnf("Catch-up query disabled: %v", err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			catchup.New(ipfsClient, db, cfg.Pubsub.Topic, key, &cfg.Pubsub.CatchUp, log).Run(ctx)
+		}()
+		stopCatchUp = func(stopCtx context.Context) error {
+			cancel()
+			select {
+			case <-done:
+				return nil
+			case <-stopCtx.Done():
+				return fmt.Errorf("still resolving: %w", stopCtx.Err())
+			}
+		}
+	}
+
+	log.Info("IPFS Indexer is running. Press Ctrl+C to stop.")
+
+	// Wait for interrupt signal
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGINT)
+
+	<-sigChan
+	log.Info("Received shutdown signal, gracefully shutting down...")
+
+	// Stop taking in announcements first and close the database only once
+	// nothing writes to it anymore
+	steps := []shutdownStep{{"PubSub listener", shutdownTimeout, pubsubListener.Stop}}
+	if stopCatchUp != nil {
+		steps = append(steps, shutdownStep{"catch-up", shutdownTimeout, stopCatchUp})
+	}
+	if catalogAggregator != nil {
+		steps = append(steps, shutdownStep{"aggregator", shutdownTimeout, waitFor(func() error {
+			catalogAggregator.Stop()
+			return nil
+		})})
+	}
+	steps = append(steps, shutdownStep{"fetcher", fetcherDrainTimeout, collectionFetcher.Stop})
+	if server != nil {
+		steps = append(steps, shutdownStep{"API server", shutdownTimeout, server.Stop})
+	}
+	steps = append(steps,
+		shutdownStep{"database", shutdownTimeout, db.CloseIdle},
+		shutdownStep{"IPFS node", ipfsCloseTimeout, waitFor(ipfsClient.Close)},
+	)
+	// The last spans are exported once nothing starts new ones
+	if stopTracing != nil {
+		steps = append(steps, shutdownStep{"tracing", shutdownTimeout, stopTracing})
+	}
+
+	if failed := shutdown(log, steps); failed > 0 {
+		log.Warnf("Shutdown complete, %d steps failed", failed)
+		return
+	}
+	log.Info("Shutdown complete")
+}
+
+// loadConfig loads the configuration and initializes the logger
+func loadConfig(path string) (*config.Config, error) {
+	cfg, err := config.LoadWithOptions(path, config.LoadOptions{IgnoreUnknownKeys: *ignoreUnknownConfig})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	if err := logger.Init(cfg.Logging.Level, cfg.Logging.Format, cfg.Logging.Output, cfg.Logging.FilePath); err != nil {
+		return nil, fmt.Errorf("failed to initialize logger: %w", err)
+	}
+
+	return cfg, nil
+}
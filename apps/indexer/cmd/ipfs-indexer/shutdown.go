@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Shutdown step timeouts. Fetches in flight get the longest to finish; the
+// other steps only wait for the work at hand.
+const (
+	fetcherDrainTimeout = 30 * time.Second
+	ipfsCloseTimeout    = 10 * time.Second
+)
+
+// shutdownStep stops one component. stop must return soon after ctx is done.
+type shutdownStep struct {
+	name    string
+	timeout time.Duration
+	stop    func(ctx context.Context) error
+}
+
+// shutdown runs the steps in order, each with its own timeout, and logs how
+// long each took. A failing step does not keep the later ones from running.
+// It returns the number of failed steps.
+func shutdown(log *logrus.Logger, steps []shutdownStep) int {
+	failed := 0
+	for _, step := range steps {
+		start := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), step.timeout)
+		err := step.stop(ctx)
+		cancel()
+
+		elapsed := time.Since(start).Round(time.Millisecond)
+		if err != nil {
+			failed++
+			log.Warnf("Shutdown: %s failed after %v: %v", step.name, elapsed, err)
+			continue
+		}
+		log.Infof("Shutdown: %s stopped in %v", step.name, elapsed)
+	}
+	return failed
+}
+
+// waitFor adapts a stop function without a context: it returns once stop
+// returns, or with an error when ctx is done first
+func waitFor(stop func() error) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		done := make(chan error, 1)
+		go func() { done <- stop() }()
+
+		select {
+		case err := <-done:
+			return err
+		case <-ctx.Done():
+			return fmt.Errorf("still stopping: %w", ctx.Err())
+		}
+	}
+}
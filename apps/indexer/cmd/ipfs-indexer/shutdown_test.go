@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/atregu/ipfs-indexer/internal/fetcher"
+	"github.com/atregu/ipfs-indexer/internal/ipfs"
+	"github.com/atregu/ipfs-indexer/internal/parser"
+)
+
+// stalledIndex serves the first half of an index, then blocks until released
+// or until the fetch is cancelled
+type stalledIndex struct {
+	ctx     context.Context
+	head    io.Reader
+	tail    io.Reader
+	started chan struct{} // Closed when the head was read
+	release chan struct{}
+	once    sync.Once
+}
+
+func (r *stalledIndex) Read(p []byte) (int, error) {
+	if n, err := r.head.Read(p); err != io.EOF {
+		return n, err
+	}
+	r.once.Do(func() { close(r.started) })
+	select {
+	case <-r.release:
+		return r.tail.Read(p)
+	case <-r.ctx.Done():
+		return 0, r.ctx.Err()
+	}
+}
+
+func (r *stalledIndex) Close() error { return nil }
+
+// stalledNode is a fetcher client whose single collection download stalls halfway
+type stalledNode struct {
+	index   []byte
+	started chan struct{}
+	release chan struct{}
+}
+
+func (n *stalledNode) ResolveIPNS(ctx context.Context, name string) (string, error) {
+	return "QmRoot", nil
+}
+
+func (n *stalledNode) ResolveIndexFile(ctx context.Context, rootCID string) (string, error) {
+	return "QmIndex", nil
+}
+
+func (n *stalledNode) Cat(ctx context.Context, cid string) (io.ReadCloser, error) {
+	return nil, fmt.Errorf("unexpected cat of %s", cid)
+}
+
+func (n *stalledNode) Stat(ctx context.Context, cid string) (*ipfs.StatResult, error) {
+	return &ipfs.StatResult{CID: cid, Size: uint64(len(n.index)), Type: ipfs.StatTypeFile}, nil
+}
+
+func (n *stalledNode) CatWithSession(ctx context.Context, cid string, parallelism int) (io.ReadCloser, *ipfs.FetchStats, error) {
+	// Stall after the last complete line of the first half
+	half := bytes.LastIndexByte(n.index[:len(n.index)/2], '\n') + 1
+	return &stalledIndex{
+		ctx:     ctx,
+		head:    strings.NewReader(string(n.index[:half])),
+		tail:    strings.NewReader(string(n.index[half:])),
+		started: n.started,
+		release: n.release,
+	}, &ipfs.FetchStats{}, nil
+}
+
+func (n *stalledNode) Pin(ctx context.Context, cid string) error {
+	return nil
+}
+
+func (n *stalledNode) PutBlock(ctx context.Context, block blocks.Block) error {
+	return fmt.Errorf("unexpected block %s", block.Cid())
+}
+
+// startStack starts a fetcher over a new database holding one pending
+// collection and waits until its download stalls. It returns the steps that
+// shut the stack down.
+func startStack(t *testing.T, log *logrus.Logger, drain time.Duration) (*stalledNode, string, int64, []shutdownStep) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "indexer.db")
+	db, err := database.New(path, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("publisher-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection, err := db.CreateCollection(host.ID, publisher.ID, 1, "k51test", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var index strings.Builder
+	for i := 1; i <= 2000; i++ {
+		fmt.Fprintf(&index, `{"id":%d,"CID":"cid%d","filename":"file%d.mp3","extension":"mp3"}`+"\n", i, i, i)
+	}
+	node := &stalledNode{index: []byte(index.String()), started: make(chan struct{}), release: make(chan struct{})}
+
+	cfg := &config.FetcherConfig{RetryAttempts: 3, RetryIntervalSeconds: 60, ConcurrentDownloads: 1, BlockParallelism: 1}
+	contentParser := parser.NewParser(db, &config.LimitsConfig{}, 0, log)
+	collectionFetcher := fetcher.NewFetcher(node, db, contentParser, cfg, log)
+	if err := collectionFetcher.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-node.started:
+	case <-time.After(10 * time.Second):
+		t.Fatal("fetch did not start")
+	}
+
+	return node, path, collection.ID, []shutdownStep{
+		{"fetcher", drain, collectionFetcher.Stop},
+		{"database", shutdownTimeout, db.CloseIdle},
+	}
+}
+
+// reopen opens the database again after shutdown and returns the collection
+func reopen(t *testing.T, path string, id int64) *database.Collection {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	db, err := database.New(path, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	collection, err := db.GetCollection(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return collection
+}
+
+// assertNoDatabaseErrors fails on any error logged during shutdown
+func assertNoDatabaseErrors(t *testing.T, hook *logtest.Hook) {
+	t.Helper()
+
+	for _, entry := range hook.AllEntries() {
+		if entry.Level <= logrus.ErrorLevel || strings.Contains(entry.Message, "database is closed") {
+			t.Errorf("logged during shutdown: %s: %s", entry.Level, entry.Message)
+		}
+	}
+}
+
+func TestShutdownDrainsFetchInFlight(t *testing.T) {
+	log, hook := logtest.NewNullLogger()
+	log.SetLevel(logrus.DebugLevel)
+	node, path, id, steps := startStack(t, log, 10*time.Second)
+
+	// The download completes while the fetcher drains
+	time.AfterFunc(100*time.Millisecond, func() { close(node.release) })
+	if failed := shutdown(log, steps); failed != 0 {
+		t.Errorf("shutdown: %d steps failed", failed)
+	}
+
+	assertNoDatabaseErrors(t, hook)
+	if c := reopen(t, path, id); c.Status != "downloaded" || c.ItemsStored != 2000 {
+		t.Errorf("collection status %q with %d items, want downloaded with 2000", c.Status, c.ItemsStored)
+	}
+}
+
+func TestShutdownInterruptsStalledFetch(t *testing.T) {
+	log, hook := logtest.NewNullLogger()
+	log.SetLevel(logrus.DebugLevel)
+	_, path, id, steps := startStack(t, log, 100*time.Millisecond)
+
+	// The download never completes: the drain deadline interrupts it
+	if failed := shutdown(log, steps); failed != 1 {
+		t.Errorf("shutdown: %d steps failed, want the fetcher's", failed)
+	}
+
+	assertNoDatabaseErrors(t, hook)
+	c := reopen(t, path, id)
+	if c.Status != "pending" || c.RetryCount != 0 {
+		t.Errorf("collection status %q after %d retries, want pending without a counted attempt", c.Status, c.RetryCount)
+	}
+}
@@ -0,0 +1,387 @@
+package aggregator
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atregu/ipfs-common/fingerprint"
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/atregu/ipfs-indexer/internal/parser"
+	"github.com/atregu/ipfs-indexer/internal/pubsub"
+	"github.com/sirupsen/logrus"
+)
+
+// Node stores and publishes the aggregate (implemented by ipfs.Client)
+type Node interface {
+	AddIndex(ctx context.Context, data []byte) (rootCID, indexCID string, err error)
+	Unpin(ctx context.Context, cid string) error
+	EnsureKey(ctx context.Context, name string) (string, error)
+	PublishIPNS(ctx context.Context, cid, key string, lifetime time.Duration) (string, error)
+	PublishToPubSub(ctx context.Context, topic string, data []byte) error
+}
+
+// recordLifetime is the validity of the IPNS record of the aggregate. The record
+// is republished once half of it has passed.
+const recordLifetime = 24 * time.Hour
+
+// cycleTimeout bounds a single build and publication of the aggregate
+const cycleTimeout = 5 * time.Minute
+
+// State is the last aggregate, kept across restarts so versions keep increasing
+type State struct {
+	Version       int       `json:"version"`
+	RootCID       string    `json:"rootCID,omitempty"`
+	IndexCID      string    `json:"indexCID,omitempty"`
+	Items         int       `json:"items"`
+	IPNS          string    `json:"ipns,omitempty"`
+	PublishedRoot string    `json:"publishedRoot,omitempty"` // Root the IPNS record points at
+	PublishedAt   time.Time `json:"publishedAt,omitempty"`
+}
+
+// Aggregator periodically turns the merged catalog of the configured publishers
+// into a collection index in the publisher format, publishes it under an IPNS
+// key of the indexer's node and announces it like a publisher does
+type Aggregator struct {
+	node   Node
+	db     *database.DB
+	cfg    *config.AggregatorConfig
+	topic  string
+	key    ed25519.PrivateKey
+	state  State
+	log    *logrus.Logger
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates an aggregator announcing on topic. The signing key is read from
+// cfg.KeyPath, or generated there if the file does not exist.
+func New(node Node, db *database.DB, cfg *config.AggregatorConfig, topic string, log *logrus.Logger) (*Aggregator, error) {
+	key, err := loadKey(cfg.KeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	state, err := LoadState(cfg.StatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Aggregator{
+		node:   node,
+		db:     db,
+		cfg:    cfg,
+		topic:  topic,
+		key:    key,
+		state:  *state,
+		log:    log,
+		ctx:    ctx,
+		cancel: cancel,
+	}, nil
+}
+
+// PublicKey returns the base64 public key the aggregate is announced with
+func (a *Aggregator) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(a.key.Public().(ed25519.PublicKey))
+}
+
+// Start begins the background aggregation goroutine
+func (a *Aggregator) Start() error {
+	a.log.Info("Starting aggregator...")
+
+	a.wg.Add(1)
+	go a.worker()
+
+	a.log.Infof("Aggregator started, announcing as %s", fingerprint.Format(a.PublicKey()))
+	return nil
+}
+
+// worker rebuilds the aggregate on start and at every interval
+func (a *Aggregator) worker() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(time.Duration(a.cfg.IntervalSeconds) * time.Second)
+	defer ticker.Stop()
+
+	a.runCycle()
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			a.log.Info("Stopping aggregator worker...")
+			return
+		case <-ticker.C:
+			a.runCycle()
+		}
+	}
+}
+
+// runCycle runs one cycle with a timeout and logs its failure
+func (a *Aggregator) runCycle() {
+	ctx, cancel := context.WithTimeout(a.ctx, cycleTimeout)
+	defer cancel()
+
+	if err := a.cycle(ctx); err != nil && a.ctx.Err() == nil {
+		a.log.Errorf("Failed to publish aggregate: %v", err)
+	}
+}
+
+// cycle builds the aggregate, adds it to the node when it changed, publishes the
+// IPNS record when it points elsewhere or half its lifetime passed, and announces
+// the current version
+func (a *Aggregator) cycle(ctx context.Context) error {
+	data, count, err := a.build()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		a.log.Debug("No catalog items to aggregate")
+		return nil
+	}
+
+	rootCID, indexCID, err := a.node.AddIndex(ctx, data)
+	if err != nil {
+		return err
+	}
+
+	if indexCID != a.state.IndexCID {
+		previous := a.state.RootCID
+		a.state.Version++
+		a.state.RootCID = rootCID
+		a.state.IndexCID = indexCID
+		a.state.Items = count
+		if err := saveState(a.cfg.StatePath, &a.state); err != nil {
+			return err
+		}
+		a.log.Infof("Aggregate version %d: %d items, root %s", a.state.Version, count, rootCID)
+
+		if previous != "" && previous != rootCID {
+			if err := a.node.Unpin(ctx, previous); err != nil {
+				a.log.Warnf("Failed to unpin previous aggregate %s: %v", previous, err)
+			}
+		}
+	}
+
+	ipnsName, err := a.node.EnsureKey(ctx, a.cfg.IPNSKey)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if a.state.PublishedRoot != a.state.RootCID || a.state.IPNS != ipnsName || now.Sub(a.state.PublishedAt) > recordLifetime/2 {
+		if _, err := a.node.PublishIPNS(ctx, a.state.RootCID, a.cfg.IPNSKey, recordLifetime); err != nil {
+			return err
+		}
+		a.state.IPNS = ipnsName
+		a.state.PublishedRoot = a.state.RootCID
+		a.state.PublishedAt = now
+		if err := saveState(a.cfg.StatePath, &a.state); err != nil {
+			return err
+		}
+		a.log.Infof("Published aggregate version %d to IPNS %s", a.state.Version, ipnsName)
+	}
+
+	return a.announce(ctx, now)
+}
+
+// includedPublishers returns the keys of the publishers in aggregator.publishers,
+// resolving fingerprints against the known publishers. A fingerprint of no known
+// publisher is skipped with a warning, as the publisher may still announce; an
+// ambiguous one fails the cycle with the candidates instead of picking one.
+func (a *Aggregator) includedPublishers() ([]string, error) {
+	keys := make([]string, 0, len(a.cfg.Publishers))
+	for _, entry := range a.cfg.Publishers {
+		if !fingerprint.IsFingerprint(entry) {
+			keys = append(keys, entry)
+			continue
+		}
+		publisher, err := a.db.FindPublisher(entry)
+		if errors.Is(err, fingerprint.ErrNotFound) {
+			a.log.Warnf("aggregator.publishers entry %s matches no known publisher yet", entry)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("aggregator.publishers: %w", err)
+		}
+		keys = append(keys, publisher.PublicKey)
+	}
+	return keys, nil
+}
+
+// build writes the catalog of the included publishers as an NDJSON index with
+// sequential IDs, each item naming its original publisher
+func (a *Aggregator) build() ([]byte, int, error) {
+	publishers, err := a.includedPublishers()
+	if err != nil {
+		return nil, 0, err
+	}
+	// Listed publishers that are not known yet must not widen the catalog to all
+	if len(a.cfg.Publishers) > 0 && len(publishers) == 0 {
+		return nil, 0, nil
+	}
+
+	items, truncated, err := a.db.GetCatalogItems(publishers, a.PublicKey(), a.cfg.MaxItems)
+	if err != nil {
+		return nil, 0, err
+	}
+	if truncated {
+		a.log.Warnf("Catalog exceeds aggregator.max_items, publishing the first %d items", a.cfg.MaxItems)
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	for i, item := range items {
+		record := parser.ContentItem{
+			ID:        i + 1,
+			CID:       item.CID,
+			Filename:  item.Filename,
+			Extension: item.Extension,
+			Group:     item.Group,
+			Publisher: item.PublisherKey,
+		}
+		if err := encoder.Encode(&record); err != nil {
+			return nil, 0, fmt.Errorf("failed to encode item %s: %w", item.CID, err)
+		}
+	}
+
+	return buf.Bytes(), len(items), nil
+}
+
+// announce publishes the signed announcement of the current aggregate
+func (a *Aggregator) announce(ctx context.Context, now time.Time) error {
+	size := a.state.Items
+	msg := pubsub.Message{
+		Version:        a.state.Version,
+		IPNS:           a.state.IPNS,
+		CollectionSize: &size,
+		Timestamp:      now.Unix(),
+		RootCID:        a.state.RootCID,
+		IndexCID:       a.state.IndexCID,
+		Visibility:     database.VisibilityPublic,
+	}
+	if err := msg.Sign(a.key); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(&msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal announcement: %w", err)
+	}
+
+	if err := a.node.PublishToPubSub(ctx, a.topic, data); err != nil {
+		return err
+	}
+
+	a.log.Debugf("Announced aggregate version %d on PubSub topic %q", a.state.Version, a.topic)
+	return nil
+}
+
+// Stop gracefully stops the aggregator
+func (a *Aggregator) Stop() {
+	a.log.Info("Stopping aggregator...")
+	a.cancel()
+	a.wg.Wait()
+	a.log.Info("Aggregator stopped")
+}
+
+// loadKey reads the hex-encoded Ed25519 private key at path, generating and
+// saving one with 0600 permissions if the file does not exist
+func loadKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return createKey(path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read aggregator key: %w", err)
+	}
+
+	key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode aggregator key: %w", err)
+	}
+
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid aggregator key size: expected %d, got %d", ed25519.PrivateKeySize, len(key))
+	}
+
+	return ed25519.PrivateKey(key), nil
+}
+
+// createKey generates a key and saves it at path, never replacing an existing file
+func createKey(path string) (ed25519.PrivateKey, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate Ed25519 key: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create aggregator key: %w", err)
+	}
+	_, err = file.WriteString(hex.EncodeToString(key))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write aggregator key: %w", err)
+	}
+
+	return key, nil
+}
+
+// LoadState reads the state at path; a missing file is an empty state
+func LoadState(path string) (*State, error) {
+	state := &State{}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read aggregator state: %w", err)
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to parse aggregator state: %w", err)
+	}
+
+	return state, nil
+}
+
+// saveState writes the state to path through a temporary file
+func saveState(path string, state *State) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal aggregator state: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write aggregator state: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to save aggregator state: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,302 @@
+package aggregator
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-common/fingerprint"
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/atregu/ipfs-indexer/internal/parser"
+	"github.com/atregu/ipfs-indexer/internal/pubsub"
+	"github.com/sirupsen/logrus"
+)
+
+// testIPNS is the IPNS name of the aggregator key in fakeNode
+const testIPNS = "k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8"
+
+// fakeNode records what the aggregator adds, unpins and publishes
+type fakeNode struct {
+	added     [][]byte
+	unpinned  []string
+	published []string
+	messages  [][]byte
+}
+
+func (n *fakeNode) AddIndex(ctx context.Context, data []byte) (string, string, error) {
+	for i, previous := range n.added {
+		if bytes.Equal(previous, data) {
+			return fmt.Sprintf("root%d", i), fmt.Sprintf("index%d", i), nil
+		}
+	}
+	n.added = append(n.added, data)
+	return fmt.Sprintf("root%d", len(n.added)-1), fmt.Sprintf("index%d", len(n.added)-1), nil
+}
+
+func (n *fakeNode) Unpin(ctx context.Context, cid string) error {
+	n.unpinned = append(n.unpinned, cid)
+	return nil
+}
+
+func (n *fakeNode) EnsureKey(ctx context.Context, name string) (string, error) {
+	return testIPNS, nil
+}
+
+func (n *fakeNode) PublishIPNS(ctx context.Context, cid, key string, lifetime time.Duration) (string, error) {
+	n.published = append(n.published, cid)
+	return testIPNS, nil
+}
+
+func (n *fakeNode) PublishToPubSub(ctx context.Context, topic string, data []byte) error {
+	n.messages = append(n.messages, data)
+	return nil
+}
+
+// newTestAggregator creates an aggregator over a database holding one downloaded
+// collection of publisher "publisher-a"
+func newTestAggregator(t *testing.T) (*Aggregator, *fakeNode, *database.DB, *database.Collection) {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	dir := t.TempDir()
+	db, err := database.New(filepath.Join(dir, "test.db"), log)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("publisher-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection, err := db.CreateCollection(host.ID, publisher.ID, 1, "k51a", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateOrUpdateIndexItem("QmA", "song.mp3", "mp3", "Artist/Album", database.ItemFile{}, false, host.ID, publisher.ID, collection.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateCollectionStatus(collection.ID, "downloaded", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.AggregatorConfig{
+		Enabled:         true,
+		IntervalSeconds: 3600,
+		MaxItems:        100,
+		KeyPath:         filepath.Join(dir, "aggregator.key"),
+		IPNSKey:         "aggregator",
+		StatePath:       filepath.Join(dir, "aggregator.json"),
+	}
+	node := &fakeNode{}
+	a, err := New(node, db, cfg, "mdn/collections/announce", log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a, node, db, collection
+}
+
+func TestCyclePublishesParsableAggregate(t *testing.T) {
+	a, node, db, _ := newTestAggregator(t)
+
+	if err := a.cycle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.added) != 1 || len(node.published) != 1 || len(node.messages) != 1 {
+		t.Fatalf("added %d, published %d, announced %d; want 1 each", len(node.added), len(node.published), len(node.messages))
+	}
+
+	var msg pubsub.Message
+	if err := json.Unmarshal(node.messages[0], &msg); err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.Validate(); err != nil {
+		t.Fatalf("announcement is invalid: %v", err)
+	}
+	if err := msg.Verify(); err != nil {
+		t.Fatalf("announcement signature: %v", err)
+	}
+	if msg.PublicKey != a.PublicKey() || msg.Version != 1 || msg.RootCID != "root0" || msg.IndexCID != "index0" ||
+		msg.CollectionSize == nil || *msg.CollectionSize != 1 {
+		t.Errorf("announcement = %+v", msg)
+	}
+
+	// The aggregate is a collection index the indexer itself can parse
+	host, err := db.CreateOrGetHost("other-host")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher(msg.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection, err := db.CreateCollection(host.ID, publisher.ID, msg.Version, msg.IPNS, msg.CollectionSize, msg.Timestamp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	result, err := parser.NewParser(db, &config.LimitsConfig{}, 0, log).ParseAndStore(collection, bytes.NewReader(node.added[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Stored != 1 || result.Errors != 0 {
+		t.Errorf("stored %d items with %d errors, want 1 without errors", result.Stored, result.Errors)
+	}
+
+	var item parser.ContentItem
+	if err := json.Unmarshal(bytes.TrimSpace(node.added[0]), &item); err != nil {
+		t.Fatal(err)
+	}
+	want := parser.ContentItem{ID: 1, CID: "QmA", Filename: "song.mp3", Extension: "mp3", Group: "Artist/Album", Publisher: "publisher-a"}
+	if item != want {
+		t.Errorf("aggregate item = %+v, want %+v", item, want)
+	}
+}
+
+func TestCycleUnchangedCatalog(t *testing.T) {
+	a, node, _, _ := newTestAggregator(t)
+
+	for i := 0; i < 2; i++ {
+		if err := a.cycle(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The record is fresh, so only the announcement is repeated
+	if len(node.published) != 1 || len(node.messages) != 2 || len(node.unpinned) != 0 {
+		t.Errorf("published %d, announced %d, unpinned %d; want 1, 2, 0", len(node.published), len(node.messages), len(node.unpinned))
+	}
+	if a.state.Version != 1 {
+		t.Errorf("version = %d, want 1", a.state.Version)
+	}
+
+	// A stale record is republished
+	a.state.PublishedAt = time.Now().Add(-recordLifetime)
+	if err := a.cycle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.published) != 2 {
+		t.Errorf("published %d times, want 2", len(node.published))
+	}
+}
+
+func TestCycleChangedCatalogBumpsVersion(t *testing.T) {
+	a, node, db, collection := newTestAggregator(t)
+
+	if err := a.cycle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateOrUpdateIndexItem("QmB", "other.mp3", "mp3", "", database.ItemFile{}, false, collection.HostID, collection.PublisherID, collection.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.cycle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if a.state.Version != 2 || a.state.RootCID != "root1" || a.state.Items != 2 {
+		t.Errorf("state = %+v, want version 2 of root1 with 2 items", a.state)
+	}
+	if len(node.unpinned) != 1 || node.unpinned[0] != "root0" {
+		t.Errorf("unpinned %v, want [root0]", node.unpinned)
+	}
+	if len(node.published) != 2 || node.published[1] != "root1" {
+		t.Errorf("published %v, want root1 last", node.published)
+	}
+
+	// The state survives a restart with the same key
+	restarted, err := New(node, db, a.cfg, a.topic, a.log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, want := restarted.state, a.state
+	if got.Version != want.Version || got.PublishedRoot != want.PublishedRoot || !got.PublishedAt.Equal(want.PublishedAt) ||
+		restarted.PublicKey() != a.PublicKey() {
+		t.Errorf("restarted with state %+v and key %s, want %+v and %s", restarted.state, restarted.PublicKey(), a.state, a.PublicKey())
+	}
+}
+
+func TestCycleExcludesOwnCollection(t *testing.T) {
+	a, node, db, collection := newTestAggregator(t)
+
+	own, err := db.CreateOrGetPublisher(a.PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ownCollection, err := db.CreateCollection(collection.HostID, own.ID, 1, testIPNS, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateOrUpdateIndexItem("QmOwn", "own.mp3", "mp3", "", database.ItemFile{}, false, collection.HostID, own.ID, ownCollection.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateCollectionStatus(ownCollection.ID, "downloaded", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := a.cycle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(node.added[0], []byte("QmOwn")) {
+		t.Errorf("aggregate contains the aggregator's own items: %s", node.added[0])
+	}
+}
+
+func TestLoadKeyRejectsInvalidKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aggregator.key")
+	if err := os.WriteFile(path, []byte("abcd"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadKey(path); err == nil {
+		t.Error("loadKey accepted a truncated key")
+	}
+}
+
+func TestIncludedPublishersByFingerprint(t *testing.T) {
+	a, node, db, _ := newTestAggregator(t)
+
+	key, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := base64.StdEncoding.EncodeToString(key)
+	listed, err := db.CreateOrGetPublisher(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fp := fingerprint.Format(publicKey)
+	a.cfg.Publishers = []string{fp[:len(fingerprint.Prefix)+9], "mdn1-0000-0000"}
+	keys, err := a.includedPublishers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != listed.PublicKey {
+		t.Errorf("includedPublishers = %v, want the key of %s only", keys, fp)
+	}
+
+	// Unknown publishers alone must not widen the aggregate to every publisher
+	a.cfg.Publishers = []string{"mdn1-0000-0000"}
+	if err := a.cycle(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.added) != 0 {
+		t.Errorf("aggregate added for unknown listed publishers: %s", node.added[0])
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/atregu/ipfs-indexer/internal/config"
+)
+
+const authRealm = `Basic realm="IPFS Indexer"`
+
+// authenticatedKey marks request contexts whose credentials were checked
+type authenticatedKey struct{}
+
+// Authenticated reports whether AuthMiddleware accepted credentials for r.
+// It is false when no authentication is configured.
+func Authenticated(r *http.Request) bool {
+	ok, _ := r.Context().Value(authenticatedKey{}).(bool)
+	return ok
+}
+
+// AuthMiddleware wraps a handler with basic or bearer token authentication.
+// If neither basic auth credentials nor a bearer token are configured, the
+// handler is returned unchanged.
+func AuthMiddleware(cfg *config.APIConfig, next http.Handler) http.Handler {
+	basicEnabled := cfg.BasicAuth.Username != "" && cfg.BasicAuth.Password != ""
+	bearerEnabled := cfg.BearerToken != ""
+
+	if !basicEnabled && !bearerEnabled {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (basicEnabled && checkBasicAuth(r, cfg.BasicAuth.Username, cfg.BasicAuth.Password)) ||
+			(bearerEnabled && checkBearerToken(r, cfg.BearerToken)) {
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authenticatedKey{}, true)))
+			return
+		}
+
+		if basicEnabled {
+			w.Header().Set("WWW-Authenticate", authRealm)
+		} else {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+		}
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// checkBasicAuth validates the Authorization: Basic header in constant time
+func checkBasicAuth(r *http.Request, username, password string) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+
+	userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(username)) == 1
+	passMatch := subtle.ConstantTimeCompare([]byte(pass), []byte(password)) == 1
+
+	return userMatch && passMatch
+}
+
+// checkBearerToken validates the Authorization: Bearer header in constant time
+func checkBearerToken(r *http.Request, token string) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+
+	provided := strings.TrimPrefix(header, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(token)) == 1
+}
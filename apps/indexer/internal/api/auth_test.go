@@ -0,0 +1,79 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/atregu/ipfs-indexer/internal/config"
+)
+
+func TestBasicAuth(t *testing.T) {
+	cfg := &config.APIConfig{BasicAuth: config.BasicAuthConfig{Username: "admin", Password: "secret"}}
+	handler := AuthMiddleware(cfg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !Authenticated(r) {
+			t.Error("request passed without being marked authenticated")
+		}
+	}))
+
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		status   int
+	}{
+		{"correct credentials", "admin", "secret", http.StatusOK},
+		{"wrong password", "admin", "wrong", http.StatusUnauthorized},
+		{"wrong user", "root", "secret", http.StatusUnauthorized},
+		{"no credentials", "", "", http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/stats", nil)
+		if tt.user != "" {
+			req.SetBasicAuth(tt.user, tt.password)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		challenge := rec.Header().Get("WWW-Authenticate")
+		if tt.status == http.StatusUnauthorized && challenge != `Basic realm="IPFS Indexer"` {
+			t.Errorf("%s: WWW-Authenticate = %q, want the IPFS Indexer basic realm", tt.name, challenge)
+		}
+		if tt.status == http.StatusOK && challenge != "" {
+			t.Errorf("%s: WWW-Authenticate = %q on success", tt.name, challenge)
+		}
+	}
+}
+
+func TestServerAuthenticatesEveryRoute(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	cfg := &config.APIConfig{BearerToken: "secret"}
+	server := NewServer("", cfg, log)
+	// A route added without a wrapper of its own
+	server.Mux().HandleFunc("/api/v1/new", func(w http.ResponseWriter, r *http.Request) {})
+
+	for _, path := range []string{"/metrics", "/api/v1/new"} {
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("%s without credentials: status = %d, want 401", path, rec.Code)
+		}
+
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec = httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s with the token: status = %d, want 200", path, rec.Code)
+		}
+	}
+}
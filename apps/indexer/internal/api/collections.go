@@ -0,0 +1,133 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/atregu/ipfs-indexer/internal/database"
+)
+
+// GroupEntry is one directory group of GET /api/collections/{id}/groups
+type GroupEntry struct {
+	Group string `json:"group"` // Empty for items directly in the publisher's root
+	Items int    `json:"items"`
+}
+
+// ItemEntry is one item of GET /api/collections/{id}/items
+type ItemEntry struct {
+	ID        int64  `json:"id"`
+	CID       string `json:"cid"`
+	Filename  string `json:"filename"`
+	Extension string `json:"extension"`
+	Group     string `json:"group"`
+	Endorsed  bool   `json:"endorsed"`           // The publisher's claim over the item was verified
+	Size      *int64 `json:"size,omitempty"`     // Omitted for items of publishers that do not record it
+	ModTime   *int64 `json:"mtime,omitempty"`    // Unix time
+	MimeType  string `json:"mimeType,omitempty"` // e.g. "audio/mpeg"
+}
+
+// CollectionGroupsHandler serves the directory groups of a collection with their
+// item counts at GET /api/collections/{id}/groups
+func CollectionGroupsHandler(db *database.DB) http.Handler {
+	return readOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		collection, ok := loadCollection(w, r, db)
+		if !ok {
+			return
+		}
+
+		groups, err := db.GetCollectionGroups(collection.ID)
+		if err != nil {
+			http.Error(w, "failed to load groups", http.StatusInternalServerError)
+			return
+		}
+
+		entries := make([]GroupEntry, 0, len(groups))
+		for _, g := range groups {
+			entries = append(entries, GroupEntry{Group: g.Group, Items: g.Items})
+		}
+		writeJSON(w, entries)
+	}))
+}
+
+// CollectionItemsHandler serves the items of a collection at GET /api/collections/{id}/items.
+// group=G limits the items to group G (group= selects items without a group), and
+// recursive=true also includes groups nested below G.
+func CollectionItemsHandler(db *database.DB) http.Handler {
+	return readOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		collection, ok := loadCollection(w, r, db)
+		if !ok {
+			return
+		}
+
+		query := r.URL.Query()
+		var group *string
+		if query.Has("group") {
+			g := query.Get("group")
+			group = &g
+		}
+		recursive := false
+		if v := query.Get("recursive"); v != "" {
+			var err error
+			if recursive, err = strconv.ParseBool(v); err != nil {
+				http.Error(w, fmt.Sprintf("invalid recursive %q", v), http.StatusBadRequest)
+				return
+			}
+		}
+
+		items, err := db.GetCollectionItems(collection.ID, group, recursive)
+		if err != nil {
+			http.Error(w, "failed to load items", http.StatusInternalServerError)
+			return
+		}
+
+		entries := make([]ItemEntry, 0, len(items))
+		for _, item := range items {
+			entries = append(entries, itemEntry(item))
+		}
+		writeJSON(w, entries)
+	}))
+}
+
+// itemEntry converts a stored item to its JSON form
+func itemEntry(item *database.IndexItem) ItemEntry {
+	entry := ItemEntry{
+		ID:        item.ID,
+		CID:       item.CID,
+		Filename:  item.Filename,
+		Extension: item.Extension,
+		Group:     item.Group,
+		Endorsed:  item.Endorsed,
+		Size:      item.File.Size,
+		ModTime:   item.File.ModTime,
+	}
+	if item.File.MimeType != nil {
+		entry.MimeType = *item.File.MimeType
+	}
+	return entry
+}
+
+// loadCollection returns the collection named by the {id} path value. Unlisted
+// collections are only visible to authenticated callers. On failure it writes the
+// error response and returns false.
+func loadCollection(w http.ResponseWriter, r *http.Request, db *database.DB) (*database.Collection, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || id <= 0 {
+		http.Error(w, "invalid collection id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	collection, err := db.GetCollection(id)
+	if errors.Is(err, database.ErrNotFound) ||
+		(err == nil && collection.Visibility == database.VisibilityUnlisted && !Authenticated(r)) {
+		http.Error(w, "collection not found", http.StatusNotFound)
+		return nil, false
+	}
+	if err != nil {
+		http.Error(w, "failed to load collection", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return collection, true
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+)
+
+// addGroupedCollection creates a collection with items in nested groups and returns its ID
+func addGroupedCollection(t *testing.T, db *database.DB, ipns, visibility string) int64 {
+	t.Helper()
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("publisher-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection, err := db.CreateCollection(host.ID, publisher.ID, 1, ipns, nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetCollectionMeta(publisher.ID, ipns, visibility, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for cid, group := range map[string]string{
+		"cid-intro": "Artist/Album",
+		"cid-disc1": "Artist/Album/Disc 1",
+		"cid-disc2": "Artist/Album/Disc 2",
+		"cid-loose": "",
+	} {
+		endorsed := cid == "cid-intro"
+		if err := db.CreateOrUpdateIndexItem(cid, cid+".flac", "flac", group, database.ItemFile{}, endorsed, host.ID, publisher.ID, collection.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return collection.ID
+}
+
+func TestCollectionGroupsAndItems(t *testing.T) {
+	db := newTestDB(t)
+	id := addGroupedCollection(t, db, "k51grouped", database.VisibilityPublic)
+
+	cfg := &config.APIConfig{BearerToken: "secret", UIEnabled: true}
+	handler := newTestHandler(cfg, db)
+
+	get := func(method, path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get(http.MethodGet, fmt.Sprintf("/api/collections/%d/groups", id))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("groups: status %d", rec.Code)
+	}
+	var groups []GroupEntry
+	if err := json.NewDecoder(rec.Body).Decode(&groups); err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 4 || groups[2].Group != "Artist/Album/Disc 1" || groups[2].Items != 1 {
+		t.Errorf("groups = %+v, want four groups including Artist/Album/Disc 1", groups)
+	}
+
+	items := []struct {
+		query string
+		want  int
+	}{
+		{"", 4},
+		{"?group=", 1},
+		{"?group=Artist/Album", 1},
+		{"?group=Artist/Album&recursive=true", 3},
+		{"?group=Artist/Album/Disc%201", 1},
+	}
+	for _, tt := range items {
+		rec := get(http.MethodGet, fmt.Sprintf("/api/collections/%d/items%s", id, tt.query))
+		if rec.Code != http.StatusOK {
+			t.Errorf("items%s: status %d", tt.query, rec.Code)
+			continue
+		}
+		var entries []ItemEntry
+		if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != tt.want {
+			t.Errorf("items%s: %d entries, want %d", tt.query, len(entries), tt.want)
+		}
+		if tt.query == "?group=Artist/Album" && len(entries) == 1 && !entries[0].Endorsed {
+			t.Errorf("items%s: %s not reported as endorsed", tt.query, entries[0].CID)
+		}
+	}
+
+	failures := []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, fmt.Sprintf("/api/collections/%d/items?recursive=maybe", id), http.StatusBadRequest},
+		{http.MethodGet, "/api/collections/abc/groups", http.StatusBadRequest},
+		{http.MethodGet, "/api/collections/999/groups", http.StatusNotFound},
+		{http.MethodPost, fmt.Sprintf("/api/collections/%d/items", id), http.StatusMethodNotAllowed},
+	}
+	for _, tt := range failures {
+		if rec := get(tt.method, tt.path); rec.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, rec.Code, tt.status)
+		}
+	}
+}
+
+func TestUnlistedCollectionGroupsWithoutAuth(t *testing.T) {
+	db := newTestDB(t)
+	id := addGroupedCollection(t, db, "k51hidden", database.VisibilityUnlisted)
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetPathValue("id", fmt.Sprint(id))
+	rec := httptest.NewRecorder()
+	CollectionGroupsHandler(db).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"container/list"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/atregu/ipfs-indexer/internal/database"
+)
+
+// Bounds of GET /api/facets. Counts may be up to facetCacheTTL old: filter
+// sidebars do not need exact numbers, and counting a large catalog on every
+// keystroke would.
+const (
+	facetLimit        = 50 // Values returned per facet
+	maxFacetQuery     = 200
+	facetCacheSize    = 256
+	facetCacheTTL     = 30 * time.Second
+	facetCacheControl = "max-age=30"
+)
+
+// FacetEntry is one value of a facet with its item count
+type FacetEntry struct {
+	Value string `json:"value"`
+	Items int    `json:"items"`
+}
+
+// FacetsResponse is the body of GET /api/facets
+type FacetsResponse struct {
+	Query      string       `json:"query"`
+	Extensions []FacetEntry `json:"extensions"`
+	Groups     []FacetEntry `json:"groups"` // Directory groups; "" counts items without a group
+}
+
+// FacetsHandler serves the item counts per extension and per group at
+// GET /api/facets?q=text&publisher_id=N, for building filter sidebars; like
+// /api/collections, publisher=KEY names the publisher by key or fingerprint
+// instead. Counts cover the latest downloaded version of each collection, like
+// the catalog, limited to filenames containing q if given. Authenticated callers
+// may add include_unlisted=true to also count unlisted collections. Responses
+// are cached for facetCacheTTL.
+func FacetsHandler(db *database.DB) http.Handler {
+	cache := newFacetCache(facetCacheSize, facetCacheTTL)
+
+	return readOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		includeUnlisted, err := parseIncludeUnlisted(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if includeUnlisted && !Authenticated(r) {
+			http.Error(w, "include_unlisted requires authentication", http.StatusForbidden)
+			return
+		}
+
+		query := r.URL.Query().Get("q")
+		if len(query) > maxFacetQuery {
+			http.Error(w, fmt.Sprintf("q is longer than %d bytes", maxFacetQuery), http.StatusBadRequest)
+			return
+		}
+
+		publisherID, status, err := publisherFilter(db, r)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		key := fmt.Sprintf("%d|%t|%s", publisherID, includeUnlisted, query)
+		response, ok := cache.get(key)
+		if !ok {
+			facets, err := db.GetFacets(query, publisherID, includeUnlisted, facetLimit)
+			if err != nil {
+				http.Error(w, "failed to load facets", http.StatusInternalServerError)
+				return
+			}
+			response = &FacetsResponse{
+				Query:      query,
+				Extensions: facetEntries(facets.Extensions),
+				Groups:     facetEntries(facets.Groups),
+			}
+			cache.put(key, response)
+		}
+
+		w.Header().Set("Cache-Control", facetCacheControl)
+		writeJSON(w, response)
+	}))
+}
+
+// facetEntries converts facet counts to their JSON form
+func facetEntries(counts []*database.FacetCount) []FacetEntry {
+	entries := make([]FacetEntry, 0, len(counts))
+	for _, c := range counts {
+		entries = append(entries, FacetEntry{Value: c.Value, Items: c.Items})
+	}
+	return entries
+}
+
+// facetCache keeps the most recently used facet responses for ttl. It holds at
+// most size entries, dropping the least recently used.
+type facetCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // Entries, most recently used first
+	entries map[string]*list.Element
+	now     func() time.Time // Replaced by tests
+}
+
+// facetCacheEntry is a cached response and when it was computed
+type facetCacheEntry struct {
+	key      string
+	response *FacetsResponse
+	stored   time.Time
+}
+
+func newFacetCache(size int, ttl time.Duration) *facetCache {
+	return &facetCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		now:     time.Now,
+	}
+}
+
+// get returns the cached response for key unless it expired
+func (c *facetCache) get(key string) (*FacetsResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*facetCacheEntry)
+	if c.now().Sub(entry.stored) >= c.ttl {
+		c.order.Remove(elem)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.response, true
+}
+
+// put caches the response for key, evicting the least recently used entry when full
+func (c *facetCache) put(key string, response *FacetsResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &facetCacheEntry{key: key, response: response, stored: c.now()}
+	if elem, ok := c.entries[key]; ok {
+		elem.Value = entry
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*facetCacheEntry).key)
+	}
+}
@@ -0,0 +1,96 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-indexer/internal/database"
+)
+
+func TestFacetsHandler(t *testing.T) {
+	db := newTestDB(t)
+	id := addGroupedCollection(t, db, "k51grouped", database.VisibilityPublic)
+	if err := db.UpdateCollectionStatus(id, "downloaded", nil); err != nil {
+		t.Fatal(err)
+	}
+	handler := FacetsHandler(db)
+
+	get := func(path string) (*httptest.ResponseRecorder, FacetsResponse) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var response FacetsResponse
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return rec, response
+	}
+
+	rec, response := get("/api/facets?q=disc")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d", rec.Code, http.StatusOK)
+	}
+	if len(response.Extensions) != 1 || response.Extensions[0] != (FacetEntry{Value: "flac", Items: 2}) {
+		t.Errorf("extensions = %+v, want flac with 2 items", response.Extensions)
+	}
+	if len(response.Groups) != 2 || response.Groups[0].Value != "Artist/Album/Disc 1" {
+		t.Errorf("groups = %+v, want both discs", response.Groups)
+	}
+
+	// Counts are cached for a while
+	collection, err := db.GetCollection(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.CreateOrUpdateIndexItem("cid-disc3", "cid-disc3.flac", "flac", "Artist/Album/Disc 3", database.ItemFile{}, false,
+		collection.HostID, collection.PublisherID, id); err != nil {
+		t.Fatal(err)
+	}
+	if _, cached := get("/api/facets?q=disc"); cached.Extensions[0].Items != 2 {
+		t.Errorf("cached extensions = %+v, want the first count", cached.Extensions)
+	}
+	if _, fresh := get("/api/facets?q=Disc"); fresh.Extensions[0].Items != 3 {
+		t.Errorf("extensions of another query = %+v, want 3 items", fresh.Extensions)
+	}
+
+	for _, path := range []string{
+		"/api/facets?include_unlisted=true",
+		"/api/facets?publisher_id=abc",
+		"/api/facets?publisher_id=0",
+	} {
+		if rec, _ := get(path); rec.Code == http.StatusOK {
+			t.Errorf("%s accepted", path)
+		}
+	}
+}
+
+func TestFacetCacheExpiresAndEvicts(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := newFacetCache(2, time.Minute)
+	c.now = func() time.Time { return now }
+
+	c.put("a", &FacetsResponse{Query: "a"})
+	c.put("b", &FacetsResponse{Query: "b"})
+	c.get("a")                              // a is now the most recently used
+	c.put("c", &FacetsResponse{Query: "c"}) // evicts b
+
+	if _, ok := c.get("b"); ok {
+		t.Error("least recently used entry kept beyond the cache size")
+	}
+	if response, ok := c.get("a"); !ok || response.Query != "a" {
+		t.Errorf("get(a) = %+v, %v; want the cached response", response, ok)
+	}
+
+	now = now.Add(time.Minute)
+	if _, ok := c.get("a"); ok {
+		t.Error("expired entry returned")
+	}
+	if len(c.entries) != 1 || c.order.Len() != 1 {
+		t.Errorf("%d entries, %d in order; want only c left", len(c.entries), c.order.Len())
+	}
+}
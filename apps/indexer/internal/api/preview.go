@@ -0,0 +1,71 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/atregu/ipfs-common/fingerprint"
+)
+
+// previewBodyLimit bounds the request body of POST /api/collections/preview
+const previewBodyLimit = 64 << 10
+
+// PreviewFunc parses the index published under an IPNS name without storing it,
+// verifying claims against publisher if given. The result is served as JSON.
+type PreviewFunc func(ctx context.Context, ipns, publisher string) (any, error)
+
+// PreviewRequest is the body of POST /api/collections/preview
+type PreviewRequest struct {
+	IPNS      string `json:"ipns"`
+	Publisher string `json:"publisher,omitempty"` // Public key or fingerprint; defaults to the publisher that announced ipns
+}
+
+// PreviewHandler dry-runs the ingestion of a collection at POST
+// /api/collections/preview. Previews fetch from the network on request, so they
+// are only served to authenticated callers.
+func PreviewHandler(preview PreviewFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !Authenticated(r) {
+			http.Error(w, "previews require authentication", http.StatusForbidden)
+			return
+		}
+
+		var req PreviewRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, previewBodyLimit)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if req.IPNS == "" {
+			http.Error(w, "ipns is required", http.StatusBadRequest)
+			return
+		}
+		if req.Publisher != "" {
+			if _, err := fingerprint.Decode(req.Publisher); err != nil && !fingerprint.IsFingerprint(req.Publisher) {
+				http.Error(w, "publisher must be a public key or a fingerprint", http.StatusBadRequest)
+				return
+			}
+		}
+
+		result, err := preview(r.Context(), req.IPNS, req.Publisher)
+		var ambiguous *fingerprint.AmbiguousError
+		switch {
+		case errors.Is(err, fingerprint.ErrNotFound):
+			http.Error(w, "unknown publisher", http.StatusNotFound)
+			return
+		case errors.As(err, &ambiguous):
+			http.Error(w, ambiguous.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			http.Error(w, "failed to preview collection: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		writeJSON(w, result)
+	})
+}
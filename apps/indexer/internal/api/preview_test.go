@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atregu/ipfs-common/fingerprint"
+	"github.com/atregu/ipfs-indexer/internal/config"
+)
+
+func TestPreviewHandler(t *testing.T) {
+	var calls []PreviewRequest
+	preview := func(ctx context.Context, ipns, publisher string) (any, error) {
+		calls = append(calls, PreviewRequest{IPNS: ipns, Publisher: publisher})
+		switch ipns {
+		case "k51unknown":
+			return nil, fmt.Errorf("failed to find publisher: %w", fingerprint.ErrNotFound)
+		case "k51offline":
+			return nil, errors.New("failed to resolve IPNS: context deadline exceeded")
+		}
+		return map[string]any{"ipns": ipns, "items": 3}, nil
+	}
+
+	cfg := &config.APIConfig{BearerToken: "secret"}
+	handler := AuthMiddleware(cfg, PreviewHandler(preview))
+	post := func(t *testing.T, method, body, token string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, "/api/collections/preview", strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := post(t, http.MethodPost, `{"ipns":"k51test"}`, "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	var result map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result["ipns"] != "k51test" || result["items"] != float64(3) {
+		t.Errorf("result = %v", result)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		body   string
+		token  string
+		want   int
+	}{
+		{"get", http.MethodGet, "", "secret", http.StatusMethodNotAllowed},
+		{"wrong token", http.MethodPost, `{"ipns":"k51test"}`, "wrong", http.StatusUnauthorized},
+		{"invalid body", http.MethodPost, `{"ipns":`, "secret", http.StatusBadRequest},
+		{"missing ipns", http.MethodPost, `{}`, "secret", http.StatusBadRequest},
+		{"invalid publisher", http.MethodPost, `{"ipns":"k51test","publisher":"not a key"}`, "secret", http.StatusBadRequest},
+		{"unknown publisher", http.MethodPost, `{"ipns":"k51unknown","publisher":"mdn1-3f2a-9c4e"}`, "secret", http.StatusNotFound},
+		{"fetch failure", http.MethodPost, `{"ipns":"k51offline"}`, "secret", http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		if rec := post(t, tt.method, tt.body, tt.token); rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.want)
+		}
+	}
+	if len(calls) != 3 {
+		t.Errorf("%d previews run, want 3: requests rejected up front must not fetch", len(calls))
+	}
+}
+
+func TestPreviewHandlerRequiresAuthentication(t *testing.T) {
+	called := false
+	preview := func(ctx context.Context, ipns, publisher string) (any, error) {
+		called = true
+		return nil, nil
+	}
+
+	// Without configured credentials, anyone could make the node fetch arbitrary names
+	handler := AuthMiddleware(&config.APIConfig{}, PreviewHandler(preview))
+	req := httptest.NewRequest(http.MethodPost, "/api/collections/preview", strings.NewReader(`{"ipns":"k51test"}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if called {
+		t.Error("preview ran for an unauthenticated request")
+	}
+}
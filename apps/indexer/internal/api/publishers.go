@@ -0,0 +1,187 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/atregu/ipfs-common/fingerprint"
+	"github.com/atregu/ipfs-indexer/internal/database"
+)
+
+// PublisherEntry is one publisher of GET /api/publishers with its storage usage
+type PublisherEntry struct {
+	ID                 int64  `json:"id"`
+	PublicKey          string `json:"publicKey"`
+	Fingerprint        string `json:"fingerprint"` // Short form of PublicKey for display
+	Collections        int    `json:"collections"`
+	Items              int    `json:"items"`
+	RefusedCollections int    `json:"refusedCollections"`
+}
+
+// CollectionEntry is one collection of GET /api/collections
+type CollectionEntry struct {
+	ID             int64          `json:"id"`
+	PublisherID    int64          `json:"publisherId"`
+	IPNS           string         `json:"ipns"`
+	Version        int            `json:"version"`
+	Status         string         `json:"status"`
+	ItemsStored    int            `json:"itemsStored"`
+	ItemsIngested  int            `json:"itemsIngested"`  // Items searchable so far while the index is parsed
+	AnnouncedItems *int           `json:"announcedItems"` // Item count declared by the announcement, null if none
+	Visibility     string         `json:"visibility"`
+	License        string         `json:"license"`
+	Mirrors        []string       `json:"mirrors,omitempty"`
+	FetchSource    string         `json:"fetchSource,omitempty"` // native or gateway, once the index is downloaded
+	Manifest       *ManifestEntry `json:"manifest,omitempty"`    // Verified manifest of the publisher, if one was fetched
+	UpdatedAt      string         `json:"updatedAt"`
+}
+
+// ManifestEntry is the manifest of a collection in GET /api/collections
+type ManifestEntry struct {
+	CID               string `json:"cid"`
+	Name              string `json:"name,omitempty"`
+	Description       string `json:"description,omitempty"`
+	Topic             string `json:"topic,omitempty"`
+	HeartbeatInterval int    `json:"heartbeatInterval"` // Seconds between periodic announcements, 0 = none
+	Contact           string `json:"contact,omitempty"`
+	FetchedAt         string `json:"fetchedAt"`
+}
+
+// PublishersHandler serves every publisher with its collection and item counts
+// and the number of collections refused by quota at GET /api/publishers
+func PublishersHandler(db *database.DB) http.Handler {
+	return readOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		usage, err := db.GetPublisherUsage()
+		if err != nil {
+			http.Error(w, "failed to load publishers", http.StatusInternalServerError)
+			return
+		}
+
+		entries := make([]PublisherEntry, 0, len(usage))
+		for _, u := range usage {
+			entries = append(entries, PublisherEntry{
+				ID:                 u.PublisherID,
+				PublicKey:          u.PublicKey,
+				Fingerprint:        fingerprint.Format(u.PublicKey),
+				Collections:        u.Collections,
+				Items:              u.Items,
+				RefusedCollections: u.RefusedCollections,
+			})
+		}
+		writeJSON(w, entries)
+	}))
+}
+
+// CollectionsHandler serves the latest version of each collection at
+// GET /api/collections?publisher_id=N, or ?publisher=KEY with the publisher's public
+// key or fingerprint; without either all publishers are listed. Authenticated
+// callers may add include_unlisted=true to also see unlisted collections. With
+// page or per_page only that page is served, 50 collections by default.
+func CollectionsHandler(db *database.DB) http.Handler {
+	return readOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		includeUnlisted, err := parseIncludeUnlisted(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if includeUnlisted && !Authenticated(r) {
+			http.Error(w, "include_unlisted requires authentication", http.StatusForbidden)
+			return
+		}
+
+		publisherID, status, err := publisherFilter(db, r)
+		if err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+
+		page, err := parsePage(r, 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		collections, err := db.ListCollectionsPage(publisherID, includeUnlisted, page)
+		if err != nil {
+			http.Error(w, "failed to load collections", http.StatusInternalServerError)
+			return
+		}
+		manifests, err := db.ListCollectionManifests(publisherID)
+		if err != nil {
+			http.Error(w, "failed to load collections", http.StatusInternalServerError)
+			return
+		}
+		type collectionKey struct {
+			publisherID int64
+			ipns        string
+		}
+		byCollection := make(map[collectionKey]*ManifestEntry, len(manifests))
+		for _, m := range manifests {
+			byCollection[collectionKey{m.PublisherID, m.IPNS}] = &ManifestEntry{
+				CID:               m.CID,
+				Name:              m.Name,
+				Description:       m.Description,
+				Topic:             m.Topic,
+				HeartbeatInterval: m.HeartbeatInterval,
+				Contact:           m.Contact,
+				FetchedAt:         m.FetchedAt,
+			}
+		}
+
+		entries := make([]CollectionEntry, 0, len(collections))
+		for _, c := range collections {
+			entries = append(entries, CollectionEntry{
+				ID:             c.ID,
+				PublisherID:    c.PublisherID,
+				IPNS:           c.IPNS,
+				Version:        c.Version,
+				Status:         c.Status,
+				ItemsStored:    c.ItemsStored,
+				ItemsIngested:  c.ItemsIngested,
+				AnnouncedItems: c.AnnouncedSize,
+				Visibility:     c.Visibility,
+				License:        c.License,
+				Mirrors:        c.Mirrors,
+				FetchSource:    c.FetchSource,
+				Manifest:       byCollection[collectionKey{c.PublisherID, c.IPNS}],
+				UpdatedAt:      c.UpdatedAt,
+			})
+		}
+		writeJSON(w, entries)
+	}))
+}
+
+// publisherFilter returns the publisher to filter by, 0 for all, from the
+// publisher_id parameter or from publisher, which names a publisher by its public
+// key or by its fingerprint or an unambiguous prefix of it. On error it also
+// returns the HTTP status to answer with; an ambiguous prefix lists the candidates.
+func publisherFilter(db *database.DB, r *http.Request) (int64, int, error) {
+	id, query := r.URL.Query().Get("publisher_id"), r.URL.Query().Get("publisher")
+	switch {
+	case id != "" && query != "":
+		return 0, http.StatusBadRequest, errors.New("use either publisher_id or publisher")
+	case id != "":
+		publisherID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil || publisherID <= 0 {
+			return 0, http.StatusBadRequest, errors.New("invalid publisher_id")
+		}
+		return publisherID, 0, nil
+	case query != "":
+		if _, err := fingerprint.Decode(query); err != nil && !fingerprint.IsFingerprint(query) {
+			return 0, http.StatusBadRequest, errors.New("publisher must be a public key or a fingerprint")
+		}
+		publisher, err := db.FindPublisher(query)
+		var ambiguous *fingerprint.AmbiguousError
+		switch {
+		case errors.Is(err, fingerprint.ErrNotFound):
+			return 0, http.StatusNotFound, errors.New("unknown publisher")
+		case errors.As(err, &ambiguous):
+			return 0, http.StatusBadRequest, err
+		case err != nil:
+			return 0, http.StatusInternalServerError, errors.New("failed to load publishers")
+		}
+		return publisher.ID, 0, nil
+	}
+	return 0, 0, nil
+}
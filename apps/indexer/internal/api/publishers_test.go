@@ -0,0 +1,246 @@
+package api
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/atregu/ipfs-common/fingerprint"
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+)
+
+func TestUIRoutes(t *testing.T) {
+	db := newTestDB(t)
+	cfg := &config.APIConfig{BearerToken: "secret", UIEnabled: true, Gateways: []string{config.DefaultGateway}}
+	handler := newTestHandler(cfg, db)
+
+	paths := []string{
+		"/",
+		"/app.js",
+		"/api/ui/config",
+		"/api/activity",
+		"/api/publishers",
+		"/api/collections",
+		"/api/collections?publisher_id=1",
+		"/api/collections/1/items",
+		"/api/collections/1/groups",
+	}
+
+	for _, path := range paths {
+		for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodDelete} {
+			req := httptest.NewRequest(method, path, strings.NewReader("{}"))
+			req.Header.Set("Authorization", "Bearer secret")
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			want := http.StatusOK
+			if method != http.MethodGet {
+				want = http.StatusMethodNotAllowed
+			}
+			if rec.Code != want {
+				t.Errorf("%s %s: status %d, want %d", method, path, rec.Code, want)
+			}
+			if want == http.StatusMethodNotAllowed && rec.Header().Get("Allow") != "GET, HEAD" {
+				t.Errorf("%s %s: Allow = %q", method, path, rec.Header().Get("Allow"))
+			}
+		}
+	}
+}
+
+func TestPublishersAndCollections(t *testing.T) {
+	db := newTestDB(t)
+	cfg := &config.APIConfig{BearerToken: "secret", UIEnabled: true}
+	handler := newTestHandler(cfg, db)
+
+	// A newer version of the public collection replaces the first in the listing
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("publisher-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	announced := 12
+	if _, err := db.CreateCollection(host.ID, publisher.ID, 2, "k51public", &announced, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateOrGetPublisher("publisher-b"); err != nil {
+		t.Fatal(err)
+	}
+	manifest := &database.CollectionManifest{PublisherID: publisher.ID, IPNS: "k51public", CID: "bafkmanifest", Name: "Field recordings", HeartbeatInterval: 3600}
+	if err := db.SetCollectionManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(t *testing.T, path string, v any) int {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+				t.Fatalf("%s: %v", path, err)
+			}
+		}
+		return rec.Code
+	}
+
+	var publishers []PublisherEntry
+	if code := get(t, "/api/publishers", &publishers); code != http.StatusOK {
+		t.Fatalf("publishers: status %d", code)
+	}
+	if len(publishers) != 2 || publishers[0].PublicKey != "publisher-a" || publishers[0].Collections != 3 || publishers[1].Collections != 0 {
+		t.Errorf("publishers = %+v, want publisher-a with 3 collection versions and publisher-b with none", publishers)
+	}
+
+	tests := []struct {
+		query  string
+		status int
+		ipns   []string
+	}{
+		{"?publisher_id=1", http.StatusOK, []string{"k51public"}},
+		{"?publisher_id=1&include_unlisted=true", http.StatusOK, []string{"k51public", "k51unlisted"}},
+		{"?publisher_id=2", http.StatusOK, nil},
+		{"", http.StatusOK, []string{"k51public"}},
+		{"?publisher_id=abc", http.StatusBadRequest, nil},
+		{"?publisher_id=0", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		var collections []CollectionEntry
+		code := get(t, "/api/collections"+tt.query, &collections)
+		if code != tt.status {
+			t.Errorf("%q: status %d, want %d", tt.query, code, tt.status)
+			continue
+		}
+		if code != http.StatusOK {
+			continue
+		}
+
+		got := make(map[string]int)
+		for _, c := range collections {
+			got[c.IPNS] = c.Version
+		}
+		if len(got) != len(tt.ipns) || len(collections) != len(tt.ipns) {
+			t.Errorf("%q: collections %+v, want %v", tt.query, collections, tt.ipns)
+		}
+		for _, ipns := range tt.ipns {
+			if _, ok := got[ipns]; !ok {
+				t.Errorf("%q: %s missing", tt.query, ipns)
+			}
+		}
+		if v, ok := got["k51public"]; ok && v != 2 {
+			t.Errorf("%q: k51public listed at version %d, want the latest (2)", tt.query, v)
+		}
+		for _, c := range collections {
+			if c.IPNS == "k51public" && (c.AnnouncedItems == nil || *c.AnnouncedItems != announced) {
+				t.Errorf("%q: k51public announced items %v, want %d", tt.query, c.AnnouncedItems, announced)
+			}
+			if c.IPNS == "k51public" && (c.Manifest == nil || c.Manifest.Name != "Field recordings" || c.Manifest.HeartbeatInterval != 3600) {
+				t.Errorf("%q: k51public manifest %+v, want the stored one", tt.query, c.Manifest)
+			}
+			if c.IPNS == "k51unlisted" && c.Manifest != nil {
+				t.Errorf("%q: k51unlisted has manifest %+v, want none", tt.query, c.Manifest)
+			}
+		}
+	}
+}
+
+func TestGatewayURL(t *testing.T) {
+	tests := []struct {
+		template string
+		cid      string
+		filename string
+		want     string
+	}{
+		{config.DefaultGateway, "QmCID", "song.mp3", "https://ipfs.io/ipfs/QmCID?filename=song.mp3"},
+		{config.DefaultGateway, "QmCID", "01 Intro & Outro?.flac", "https://ipfs.io/ipfs/QmCID?filename=01+Intro+%26+Outro%3F.flac"},
+		{"https://gw.example/ipfs/{cid}", "Qm/../x", "a#b.mp3", "https://gw.example/ipfs/Qm%2F..%2Fx"},
+		{"https://{cid}.ipfs.dweb.link/?filename={filename}", "bafkcid", "Ünïcode.mp3", "https://bafkcid.ipfs.dweb.link/?filename=%C3%9Cn%C3%AFcode.mp3"},
+	}
+
+	for _, tt := range tests {
+		if got := GatewayURL(tt.template, tt.cid, tt.filename); got != tt.want {
+			t.Errorf("GatewayURL(%q, %q, %q) = %q, want %q", tt.template, tt.cid, tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestCollectionsByPublisherFingerprint(t *testing.T) {
+	db := newTestDB(t)
+	handler := newTestHandler(&config.APIConfig{UIEnabled: true}, db)
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for seed := byte(1); seed <= 2; seed++ {
+		s := make([]byte, ed25519.SeedSize)
+		s[0] = seed
+		key := base64.StdEncoding.EncodeToString(ed25519.NewKeyFromSeed(s).Public().(ed25519.PublicKey))
+		publisher, err := db.CreateOrGetPublisher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.CreateCollection(host.ID, publisher.ID, 1, fmt.Sprintf("k51seed%d", seed), nil, 1); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+	fp := fingerprint.Format(keys[1])
+
+	get := func(query string) (int, []CollectionEntry) {
+		req := httptest.NewRequest(http.MethodGet, "/api/collections?"+query, nil)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var collections []CollectionEntry
+		if rec.Code == http.StatusOK {
+			if err := json.NewDecoder(rec.Body).Decode(&collections); err != nil {
+				t.Fatalf("%s: %v", query, err)
+			}
+		}
+		return rec.Code, collections
+	}
+
+	for _, publisher := range []string{keys[1], fp, fp[:len(fingerprint.Prefix)+9]} {
+		code, collections := get("publisher=" + url.QueryEscape(publisher))
+		if code != http.StatusOK || len(collections) != 1 || collections[0].IPNS != "k51seed2" {
+			t.Errorf("publisher=%s: status %d, collections %+v, want k51seed2", publisher, code, collections)
+		}
+	}
+
+	tests := []struct {
+		query  string
+		status int
+	}{
+		{"publisher=mdn1-0000-0000", http.StatusNotFound},
+		{"publisher=not-a-key", http.StatusBadRequest},
+		{"publisher=" + url.QueryEscape(fp) + "&publisher_id=1", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		if code, _ := get(tt.query); code != tt.status {
+			t.Errorf("%q: status %d, want %d", tt.query, code, tt.status)
+		}
+	}
+
+	var publishers []PublisherEntry
+	req := httptest.NewRequest(http.MethodGet, "/api/publishers", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if err := json.NewDecoder(rec.Body).Decode(&publishers); err != nil {
+		t.Fatal(err)
+	}
+	last := publishers[len(publishers)-1]
+	if last.PublicKey != keys[1] || last.Fingerprint != fp {
+		t.Errorf("publishers = %+v, want fingerprint %s for the last", publishers, fp)
+	}
+}
@@ -0,0 +1,63 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ReachWindow is the period distinct publishers are counted over
+const ReachWindow = 24 * time.Hour
+
+// ReachEntry is the response of GET /api/v1/pubsub/reach
+type ReachEntry struct {
+	Publishers  int `json:"publishers"` // Distinct publishers heard within the window
+	WindowHours int `json:"windowHours"`
+}
+
+// ReachHandler serves the number of distinct publishers whose announcements
+// were heard in the last 24 hours at GET /api/v1/pubsub/reach
+func ReachHandler(db *database.DB) http.Handler {
+	return readOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count, err := db.CountPublishersHeardSince(time.Now().Add(-ReachWindow))
+		if err != nil {
+			http.Error(w, "failed to count publishers", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, ReachEntry{Publishers: count, WindowHours: int(ReachWindow / time.Hour)})
+	}))
+}
+
+// ReachCollector exports the distinct publishers heard in the last 24 hours as a
+// Prometheus gauge, counted in the database on every scrape
+type ReachCollector struct {
+	db         *database.DB
+	publishers *prometheus.Desc
+}
+
+// NewReachCollector creates the publisher reach collector
+func NewReachCollector(db *database.DB) *ReachCollector {
+	return &ReachCollector{
+		db: db,
+		publishers: prometheus.NewDesc("ipfsindexer_pubsub_publishers_heard",
+			"Distinct publishers whose announcements were heard in the last 24 hours.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *ReachCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.publishers
+}
+
+// Collect implements prometheus.Collector. A failed count is reported as an
+// invalid metric, so the scrape shows the error instead of a stale value.
+func (c *ReachCollector) Collect(ch chan<- prometheus.Metric) {
+	count, err := c.db.CountPublishersHeardSince(time.Now().Add(-ReachWindow))
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.publishers, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.publishers, prometheus.GaugeValue, float64(count))
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestReach(t *testing.T) {
+	db := newTestDB(t)
+	for _, key := range []string{"key-a", "key-b"} {
+		publisher, err := db.CreateOrGetPublisher(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.TouchPublisher(publisher.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.CreateOrGetPublisher("key-silent"); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	ReachHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/pubsub/reach", nil))
+	var entry ReachEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Publishers != 2 || entry.WindowHours != 24 {
+		t.Errorf("GET /api/v1/pubsub/reach = %+v, want 2 publishers in 24 hours", entry)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewReachCollector(db))
+	want := `
+# HELP ipfsindexer_pubsub_publishers_heard Distinct publishers whose announcements were heard in the last 24 hours.
+# TYPE ipfsindexer_pubsub_publishers_heard gauge
+ipfsindexer_pubsub_publishers_heard 2
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/atregu/ipfs-indexer/internal/database"
+)
+
+// receivedLimit is the number of announcements served by GET /api/v1/pubsub/received
+const receivedLimit = 20
+
+// ReceivedEntry is one announcement of GET /api/v1/pubsub/received. MessageID and
+// MessageHash match the send logged by the publisher at /api/v1/pubsub/sent.
+type ReceivedEntry struct {
+	CollectionID int64  `json:"collectionId"`
+	Publisher    string `json:"publisher"`
+	IPNS         string `json:"ipns"`
+	Version      int    `json:"version"`
+	ReceivedFrom string `json:"receivedFrom"` // Peer that forwarded the message
+	MessageID    string `json:"messageId"`    // Gossipsub message ID (hex); empty for announcements stored before it was recorded
+	MessageHash  string `json:"messageHash"`  // SHA-256 of the message (hex)
+	ReceivedAt   string `json:"receivedAt"`
+}
+
+// ReceivedHandler serves the last stored announcements with the PubSub message
+// each came in, newest first, at GET /api/v1/pubsub/received
+func ReceivedHandler(db *database.DB) http.Handler {
+	return readOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		announcements, err := db.GetRecentAnnouncements(receivedLimit)
+		if err != nil {
+			http.Error(w, "failed to load announcements", http.StatusInternalServerError)
+			return
+		}
+
+		entries := make([]ReceivedEntry, 0, len(announcements))
+		for _, a := range announcements {
+			entries = append(entries, ReceivedEntry{
+				CollectionID: a.CollectionID,
+				Publisher:    a.PublisherKey,
+				IPNS:         a.IPNS,
+				Version:      a.Version,
+				ReceivedFrom: a.ReceivedFrom,
+				MessageID:    a.MessageID,
+				MessageHash:  a.MessageHash,
+				ReceivedAt:   a.ReceivedAt,
+			})
+		}
+		writeJSON(w, entries)
+	}))
+}
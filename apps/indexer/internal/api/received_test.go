@@ -0,0 +1,47 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReceived(t *testing.T) {
+	db := newTestDB(t)
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("publisher-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection, err := db.CreateCollection(host.ID, publisher.ID, 2, "k51public", nil, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetCollectionMessage(collection.ID, "0a0b", "c0ffee"); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	ReceivedHandler(db).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/pubsub/received", nil))
+	var entries []ReceivedEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+
+	// The two collections of newTestDB were stored without a message
+	if len(entries) != 3 {
+		t.Fatalf("%d announcements, want 3", len(entries))
+	}
+	got := entries[0]
+	if got.CollectionID != collection.ID || got.Version != 2 || got.Publisher != "publisher-a" || got.ReceivedFrom != "host-key" ||
+		got.MessageID != "0a0b" || got.MessageHash != "c0ffee" {
+		t.Errorf("newest announcement = %+v, want version 2 with its message", got)
+	}
+	if entries[1].MessageID != "" {
+		t.Errorf("announcement without a message = %+v", entries[1])
+	}
+}
@@ -0,0 +1,133 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/atregu/ipfs-indexer/internal/database"
+)
+
+// Bounds of paginated responses and of GET /api/items
+const (
+	defaultPerPage = 50
+	maxPerPage     = 500
+	maxSearchQuery = 200
+)
+
+// SearchEntry is one item of GET /api/items with the collection holding it
+type SearchEntry struct {
+	ItemEntry
+	CollectionID int64 `json:"collectionId"`
+	PublisherID  int64 `json:"publisherId"`
+}
+
+// StatsResponse is the body of GET /api/stats
+type StatsResponse struct {
+	Collections int `json:"collections"`
+	Items       int `json:"items"` // Items of the latest downloaded version of each collection
+	Publishers  int `json:"publishers"`
+}
+
+// parsePage parses the page (from 1) and per_page query parameters. Without
+// either, the page holds perPage items, or everything if perPage is 0.
+func parsePage(r *http.Request, perPage int) (database.Page, error) {
+	query := r.URL.Query()
+	if !query.Has("page") && !query.Has("per_page") {
+		return database.Page{Limit: perPage}, nil
+	}
+
+	page := 1
+	if v := query.Get("page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return database.Page{}, fmt.Errorf("invalid page %q", v)
+		}
+		page = n
+	}
+	if perPage == 0 {
+		perPage = defaultPerPage
+	}
+	if v := query.Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxPerPage {
+			return database.Page{}, fmt.Errorf("invalid per_page %q, must be 1 to %d", v, maxPerPage)
+		}
+		perPage = n
+	}
+	return database.Page{Limit: perPage, Offset: (page - 1) * perPage}, nil
+}
+
+// SearchItemsHandler serves the items whose filename starts with q at
+// GET /api/items?q=prefix, ignoring ASCII case, ordered by filename and paginated
+// with page and per_page (50 items per page by default). Items come from the
+// latest downloaded version of each collection; authenticated callers may add
+// include_unlisted=true to also search unlisted collections.
+func SearchItemsHandler(db *database.DB) http.Handler {
+	return readOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		includeUnlisted, err := parseIncludeUnlisted(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if includeUnlisted && !Authenticated(r) {
+			http.Error(w, "include_unlisted requires authentication", http.StatusForbidden)
+			return
+		}
+
+		query := r.URL.Query().Get("q")
+		if query == "" {
+			http.Error(w, "q is required", http.StatusBadRequest)
+			return
+		}
+		if len(query) > maxSearchQuery {
+			http.Error(w, fmt.Sprintf("q is longer than %d bytes", maxSearchQuery), http.StatusBadRequest)
+			return
+		}
+		page, err := parsePage(r, defaultPerPage)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		items, err := db.SearchItems(query, includeUnlisted, page)
+		if err != nil {
+			http.Error(w, "failed to search items", http.StatusInternalServerError)
+			return
+		}
+
+		entries := make([]SearchEntry, 0, len(items))
+		for _, item := range items {
+			entries = append(entries, SearchEntry{
+				ItemEntry:    itemEntry(item),
+				CollectionID: item.CollectionID,
+				PublisherID:  item.PublisherID,
+			})
+		}
+		writeJSON(w, entries)
+	}))
+}
+
+// StatsHandler serves the number of collections, current items and publishers
+// at GET /api/stats. Authenticated callers may add include_unlisted=true to
+// also count unlisted collections.
+func StatsHandler(db *database.DB) http.Handler {
+	return readOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		includeUnlisted, err := parseIncludeUnlisted(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if includeUnlisted && !Authenticated(r) {
+			http.Error(w, "include_unlisted requires authentication", http.StatusForbidden)
+			return
+		}
+
+		totals, err := db.GetTotals(includeUnlisted)
+		if err != nil {
+			http.Error(w, "failed to count totals", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, StatsResponse{Collections: totals.Collections, Items: totals.Items, Publishers: totals.Publishers})
+	}))
+}
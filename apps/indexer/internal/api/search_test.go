@@ -0,0 +1,117 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/atregu/ipfs-indexer/internal/database"
+)
+
+// getJSON serves path with handler and decodes a successful response into v
+func getJSON(t *testing.T, handler http.Handler, path string, v any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code
+}
+
+func TestSearchItemsHandler(t *testing.T) {
+	db := newTestDB(t)
+	for ipns, visibility := range map[string]string{"k51grouped": database.VisibilityPublic, "k51hidden": database.VisibilityUnlisted} {
+		id := addGroupedCollection(t, db, ipns, visibility)
+		if err := db.UpdateCollectionStatus(id, "downloaded", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler := SearchItemsHandler(db)
+
+	// The prefix ignores case and matches the public collection only
+	var entries []SearchEntry
+	if code := getJSON(t, handler, "/api/items?q=CID-DISC", &entries); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if len(entries) != 2 || entries[0].Filename != "cid-disc1.flac" || entries[1].Filename != "cid-disc2.flac" {
+		t.Fatalf("entries = %+v, want both discs in order", entries)
+	}
+	if entries[0].CollectionID == 0 || entries[0].PublisherID == 0 || entries[0].Group != "Artist/Album/Disc 1" {
+		t.Errorf("entry = %+v, want its collection and group", entries[0])
+	}
+
+	// The prefix is not a substring match and wildcards match literally
+	for _, q := range []string{"disc", "cid_disc", "cid%"} {
+		entries = nil
+		if code := getJSON(t, handler, "/api/items?q="+url.QueryEscape(q), &entries); code != http.StatusOK || len(entries) != 0 {
+			t.Errorf("q=%s: status %d, %d entries, want none", q, code, len(entries))
+		}
+	}
+
+	// Pages follow the filename order
+	entries = nil
+	if code := getJSON(t, handler, "/api/items?q=cid-&page=2&per_page=3", &entries); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if len(entries) != 1 || entries[0].Filename != "cid-loose.flac" {
+		t.Errorf("page 2 = %+v, want cid-loose.flac", entries)
+	}
+
+	for _, path := range []string{
+		"/api/items",
+		"/api/items?q=cid&page=0",
+		"/api/items?q=cid&per_page=501",
+		"/api/items?q=cid&include_unlisted=true",
+	} {
+		if code := getJSON(t, handler, path, &entries); code == http.StatusOK {
+			t.Errorf("%s accepted", path)
+		}
+	}
+}
+
+func TestCollectionsPagination(t *testing.T) {
+	db := newTestDB(t)
+	for _, ipns := range []string{"k51a", "k51b", "k51c"} {
+		addGroupedCollection(t, db, ipns, database.VisibilityPublic)
+	}
+	handler := CollectionsHandler(db)
+
+	// newTestDB adds k51public
+	var all []CollectionEntry
+	if code := getJSON(t, handler, "/api/collections", &all); code != http.StatusOK || len(all) != 4 {
+		t.Fatalf("status %d, %d collections, want all 4", code, len(all))
+	}
+
+	var page []CollectionEntry
+	if code := getJSON(t, handler, "/api/collections?page=2&per_page=3", &page); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if len(page) != 1 || page[0].IPNS != "k51c" {
+		t.Errorf("page 2 = %+v, want k51c", page)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	db := newTestDB(t)
+	public := addGroupedCollection(t, db, "k51grouped", database.VisibilityPublic)
+	hidden := addGroupedCollection(t, db, "k51hidden", database.VisibilityUnlisted)
+	for _, id := range []int64{public, hidden} {
+		if err := db.UpdateCollectionStatus(id, "downloaded", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stats StatsResponse
+	if code := getJSON(t, StatsHandler(db), "/api/stats", &stats); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	// k51public of newTestDB counts, but has no items yet
+	if stats != (StatsResponse{Collections: 2, Items: 4, Publishers: 1}) {
+		t.Errorf("stats = %+v, want 2 public collections with 4 items", stats)
+	}
+}
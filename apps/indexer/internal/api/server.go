@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+
+	"github.com/atregu/ipfs-indexer/internal/config"
+)
+
+// readHeaderTimeout bounds how long a client may take to send request headers
+const readHeaderTimeout = 10 * time.Second
+
+// Server is the HTTP server of the indexer. It serves the metrics of its
+// registry at /metrics; other routes are added to its mux. Every route sits
+// behind the configured authentication.
+type Server struct {
+	mux      *http.ServeMux
+	registry *prometheus.Registry
+	server   *http.Server
+	log      *logrus.Logger
+}
+
+// NewServer creates a server listening on addr. Its mux is wrapped in
+// AuthMiddleware once, so routes added later need no wrapper of their own.
+func NewServer(addr string, cfg *config.APIConfig, log *logrus.Logger) *Server {
+	s := &Server{
+		mux:      http.NewServeMux(),
+		registry: prometheus.NewRegistry(),
+		log:      log,
+	}
+
+	s.mux.Handle("/metrics", promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{}))
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           AuthMiddleware(cfg, s.mux),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	return s
+}
+
+// Register adds a collector to the metrics served at /metrics
+func (s *Server) Register(c prometheus.Collector) error {
+	if err := s.registry.Register(c); err != nil {
+		return fmt.Errorf("failed to register metrics collector: %w", err)
+	}
+	return nil
+}
+
+// Mux returns the request router, for adding routes
+func (s *Server) Mux() *http.ServeMux {
+	return s.mux
+}
+
+// Handler returns the handler serving requests: the mux behind authentication
+func (s *Server) Handler() http.Handler {
+	return s.server.Handler
+}
+
+// Start listens on the configured address and serves requests in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.server.Addr, err)
+	}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Errorf("API server stopped: %v", err)
+		}
+	}()
+
+	s.log.Infof("API server listening on %s", listener.Addr())
+	return nil
+}
+
+// Stop shuts the server down, waiting for active requests until ctx is done
+func (s *Server) Stop(ctx context.Context) error {
+	if err := s.server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to stop API server: %w", err)
+	}
+	return nil
+}
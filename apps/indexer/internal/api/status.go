@@ -0,0 +1,89 @@
+package api
+
+import (
+	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// collectionStatuses are always exported, so a status without collections
+// reads 0 rather than disappearing from the scrape
+var collectionStatuses = []string{"pending", "stale", "downloaded", "truncated", "incomplete", "failed", "unretrievable"}
+
+// CollectionStatusCollector exports the number of collection versions in each
+// status as a Prometheus gauge, counted in the database on every scrape
+type CollectionStatusCollector struct {
+	db          *database.DB
+	collections *prometheus.Desc
+}
+
+// NewCollectionStatusCollector creates the collection status collector
+func NewCollectionStatusCollector(db *database.DB) *CollectionStatusCollector {
+	return &CollectionStatusCollector{
+		db: db,
+		collections: prometheus.NewDesc("ipfsindexer_collections",
+			"Collection versions by status; stale versions failed to resolve while an earlier version is still retrievable.",
+			[]string{"status"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *CollectionStatusCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.collections
+}
+
+// Collect implements prometheus.Collector. A failed count is reported as an
+// invalid metric, so the scrape shows the error instead of a stale value.
+func (c *CollectionStatusCollector) Collect(ch chan<- prometheus.Metric) {
+	counts, err := c.db.CountCollectionsByStatus()
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.collections, err)
+		return
+	}
+
+	for _, status := range collectionStatuses {
+		ch <- prometheus.MustNewConstMetric(c.collections, prometheus.GaugeValue, float64(counts[status]), status)
+	}
+}
+
+// NewIncompleteCollector exports count, the fetches whose item count disagreed
+// with the announced collection size, as a Prometheus counter
+func NewIncompleteCollector(count func() int64) prometheus.Collector {
+	return prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name: "ipfsindexer_collections_incomplete_total",
+		Help: "Collection fetches whose indexed item count differed from the announced collectionSize by more than fetcher.completeness_tolerance.",
+	}, func() float64 { return float64(count()) })
+}
+
+// fetchSources are the sources an index is downloaded from (fetcher.SourceNative
+// and fetcher.SourceGateway)
+var fetchSources = []string{"native", "gateway"}
+
+// FetchSourceCollector exports the number of indexes downloaded natively and
+// from the fallback gateways as a Prometheus counter
+type FetchSourceCollector struct {
+	count   func(source string) int64
+	fetches *prometheus.Desc
+}
+
+// NewFetchSourceCollector creates the fetch source collector over count, the
+// downloads from a source
+func NewFetchSourceCollector(count func(source string) int64) *FetchSourceCollector {
+	return &FetchSourceCollector{
+		count: count,
+		fetches: prometheus.NewDesc("ipfsindexer_collection_fetches_total",
+			"Collection indexes downloaded, by source: native retrieval or the fetcher.gateways fallback.",
+			[]string{"source"}, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *FetchSourceCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.fetches
+}
+
+// Collect implements prometheus.Collector
+func (c *FetchSourceCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, source := range fetchSources {
+		ch <- prometheus.MustNewConstMetric(c.fetches, prometheus.CounterValue, float64(c.count(source)), source)
+	}
+}
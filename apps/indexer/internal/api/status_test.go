@@ -0,0 +1,76 @@
+package api
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollectionStatusCollector(t *testing.T) {
+	db := newTestDB(t) // Holds two pending collections
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("key-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for version, status := range []string{"downloaded", "downloaded", "stale", "pending", "incomplete"} {
+		collection, err := db.CreateCollection(host.ID, publisher.ID, version+1, "k51a", nil, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.UpdateCollectionStatus(collection.ID, status, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewCollectionStatusCollector(db))
+	want := `
+# HELP ipfsindexer_collections Collection versions by status; stale versions failed to resolve while an earlier version is still retrievable.
+# TYPE ipfsindexer_collections gauge
+ipfsindexer_collections{status="downloaded"} 2
+ipfsindexer_collections{status="failed"} 0
+ipfsindexer_collections{status="incomplete"} 1
+ipfsindexer_collections{status="pending"} 3
+ipfsindexer_collections{status="stale"} 1
+ipfsindexer_collections{status="truncated"} 0
+ipfsindexer_collections{status="unretrievable"} 0
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIncompleteCollector(t *testing.T) {
+	count := int64(3)
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewIncompleteCollector(func() int64 { return count }))
+	want := `
+# HELP ipfsindexer_collections_incomplete_total Collection fetches whose indexed item count differed from the announced collectionSize by more than fetcher.completeness_tolerance.
+# TYPE ipfsindexer_collections_incomplete_total counter
+ipfsindexer_collections_incomplete_total 3
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFetchSourceCollector(t *testing.T) {
+	counts := map[string]int64{"native": 5, "gateway": 2}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewFetchSourceCollector(func(source string) int64 { return counts[source] }))
+	want := `
+# HELP ipfsindexer_collection_fetches_total Collection indexes downloaded, by source: native retrieval or the fetcher.gateways fallback.
+# TYPE ipfsindexer_collection_fetches_total counter
+ipfsindexer_collection_fetches_total{source="gateway"} 2
+ipfsindexer_collection_fetches_total{source="native"} 5
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
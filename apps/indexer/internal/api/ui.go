@@ -0,0 +1,172 @@
+package api
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+)
+
+// uiFiles holds the single-page web UI
+//
+//go:embed ui
+var uiFiles embed.FS
+
+// defaultActivityLimit and maxActivityLimit bound GET /api/activity
+const (
+	defaultActivityLimit = 20
+	maxActivityLimit     = 200
+)
+
+// UIConfig is the configuration the web UI loads from GET /api/ui/config
+type UIConfig struct {
+	Gateways []string `json:"gateways"`
+}
+
+// ActivityEntry is one entry of GET /api/activity
+type ActivityEntry struct {
+	CollectionID int64  `json:"collectionId"`
+	PublisherID  int64  `json:"publisherId"`
+	IPNS         string `json:"ipns"`
+	Version      int    `json:"version"`
+	Status       string `json:"status"`
+	ItemsStored  int    `json:"itemsStored"`
+	Visibility   string `json:"visibility"`
+	UpdatedAt    string `json:"updatedAt"`
+}
+
+// GatewayURL fills a gateway template with a CID and filename.
+// {cid} and {filename} are replaced with URL-escaped values.
+func GatewayURL(template, cid, filename string) string {
+	return strings.NewReplacer(
+		"{cid}", url.PathEscape(cid),
+		"{filename}", url.QueryEscape(filename),
+	).Replace(template)
+}
+
+// UIHandler serves the embedded web UI. It is strictly read-only:
+// only GET and HEAD requests are accepted.
+func UIHandler() http.Handler {
+	sub, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		// The embedded directory is part of the binary, so this cannot fail at runtime
+		panic(err)
+	}
+	return readOnly(http.FileServer(http.FS(sub)))
+}
+
+// UIConfigHandler serves the gateway templates for playback links
+func UIConfigHandler(cfg *config.APIConfig) http.Handler {
+	return readOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, &UIConfig{Gateways: cfg.Gateways})
+	}))
+}
+
+// ActivityHandler serves the most recently updated collections at GET /api/activity?limit=N.
+// Authenticated callers may add include_unlisted=true to also see unlisted collections.
+func ActivityHandler(db *database.DB) http.Handler {
+	return readOnly(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		includeUnlisted, err := parseIncludeUnlisted(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if includeUnlisted && !Authenticated(r) {
+			http.Error(w, "include_unlisted requires authentication", http.StatusForbidden)
+			return
+		}
+
+		limit := defaultActivityLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = min(n, maxActivityLimit)
+		}
+
+		activity, err := db.GetRecentActivity(limit, includeUnlisted)
+		if err != nil {
+			http.Error(w, "failed to load activity", http.StatusInternalServerError)
+			return
+		}
+
+		entries := make([]ActivityEntry, 0, len(activity))
+		for _, a := range activity {
+			entries = append(entries, ActivityEntry{
+				CollectionID: a.CollectionID,
+				PublisherID:  a.PublisherID,
+				IPNS:         a.IPNS,
+				Version:      a.Version,
+				Status:       a.Status,
+				ItemsStored:  a.ItemsStored,
+				Visibility:   a.Visibility,
+				UpdatedAt:    a.UpdatedAt,
+			})
+		}
+		writeJSON(w, entries)
+	}))
+}
+
+// RegisterREST adds the read-only REST routes to mux. They are served whenever
+// the API server runs; the Server authenticates every route of mux.
+func RegisterREST(mux *http.ServeMux, db *database.DB) {
+	mux.Handle("/api/activity", ActivityHandler(db))
+	mux.Handle("/api/publishers", PublishersHandler(db))
+	mux.Handle("/api/collections", CollectionsHandler(db))
+	mux.Handle("/api/collections/{id}/groups", CollectionGroupsHandler(db))
+	mux.Handle("/api/collections/{id}/items", CollectionItemsHandler(db))
+	mux.Handle("/api/facets", FacetsHandler(db))
+	mux.Handle("/api/items", SearchItemsHandler(db))
+	mux.Handle("/api/stats", StatsHandler(db))
+}
+
+// RegisterUI adds the web UI and its configuration to mux if api.ui_enabled
+// is set. The UI reads its data from the routes of RegisterREST.
+func RegisterUI(mux *http.ServeMux, cfg *config.APIConfig) {
+	if !cfg.UIEnabled {
+		return
+	}
+
+	mux.Handle("/", UIHandler())
+	mux.Handle("/api/ui/config", UIConfigHandler(cfg))
+}
+
+// parseIncludeUnlisted parses the include_unlisted query parameter
+func parseIncludeUnlisted(r *http.Request) (bool, error) {
+	v := r.URL.Query().Get("include_unlisted")
+	if v == "" {
+		return false, nil
+	}
+	include, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid include_unlisted %q", v)
+	}
+	return include, nil
+}
+
+// readOnly rejects every method except GET and HEAD
+func readOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
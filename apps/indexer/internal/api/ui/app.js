@@ -0,0 +1,139 @@
+// Read-only browser for the indexer REST API. Every request is a GET.
+"use strict";
+
+const view = document.getElementById("view");
+let gateways = [];
+
+function escapeHTML(value) {
+  return String(value).replace(/[&<>"']/g, (c) => ({
+    "&": "&amp;", "<": "&lt;", ">": "&gt;", '"': "&quot;", "'": "&#39;",
+  }[c]));
+}
+
+// gatewayURL mirrors api.GatewayURL on the server
+function gatewayURL(template, cid, filename) {
+  return template
+    .replace("{cid}", encodeURIComponent(cid))
+    .replace("{filename}", encodeURIComponent(filename));
+}
+
+async function getJSON(path) {
+  const resp = await fetch(path, { credentials: "same-origin" });
+  if (!resp.ok) {
+    throw new Error(`${path}: ${resp.status} ${resp.statusText}`);
+  }
+  return resp.json();
+}
+
+function table(headers, rows) {
+  const head = headers.map((h) => `<th>${escapeHTML(h)}</th>`).join("");
+  return `<table><thead><tr>${head}</tr></thead><tbody>${rows.join("")}</tbody></table>`;
+}
+
+async function showActivity() {
+  const entries = await getJSON("/api/activity?limit=50");
+  const rows = entries.map((a) => `<tr>
+    <td><a href="#/collections/${a.collectionId}">${a.collectionId}</a></td>
+    <td class="mono">${escapeHTML(a.ipns)}</td>
+    <td>${a.version}</td>
+    <td>${escapeHTML(a.status)}</td>
+    <td>${a.itemsStored}</td>
+    <td>${escapeHTML(a.updatedAt)}</td>
+  </tr>`);
+  view.innerHTML = "<h2>Recent activity</h2>" +
+    table(["Collection", "IPNS", "Version", "Status", "Items", "Updated"], rows);
+}
+
+async function showPublishers() {
+  const publishers = await getJSON("/api/publishers");
+  const rows = publishers.map((p) => `<tr>
+    <td><a href="#/publishers/${p.id}">${p.id}</a></td>
+    <td class="mono" title="${escapeHTML(p.publicKey)}">${escapeHTML(p.fingerprint)}</td>
+    <td>${p.collections}</td>
+    <td>${p.items}</td>
+  </tr>`);
+  view.innerHTML = "<h2>Publishers</h2>" +
+    table(["ID", "Fingerprint", "Collections", "Items"], rows);
+}
+
+async function showPublisher(id) {
+  const collections = await getJSON(`/api/collections?publisher_id=${encodeURIComponent(id)}`);
+  const rows = collections.map((c) => `<tr>
+    <td><a href="#/collections/${c.id}">${c.id}</a></td>
+    <td class="mono">${escapeHTML(c.ipns)}</td>
+    <td>${c.version}</td>
+    <td>${escapeHTML(c.status)}</td>
+    <td>${c.itemsStored}</td>
+    <td>${c.announcedItems ?? ""}</td>
+    <td>${escapeHTML(c.license || "")}</td>
+  </tr>`);
+  view.innerHTML = `<h2>Publisher ${escapeHTML(id)}</h2>` +
+    table(["Collection", "IPNS", "Version", "Status", "Items", "Announced", "License"], rows);
+}
+
+async function showCollection(id) {
+  const items = await getJSON(`/api/collections/${encodeURIComponent(id)}/items`);
+
+  // Items arrive sorted by group, so each group is one contiguous table
+  const groups = new Map();
+  for (const item of items) {
+    const group = item.group || "";
+    if (!groups.has(group)) {
+      groups.set(group, []);
+    }
+    groups.get(group).push(item);
+  }
+
+  let html = `<h2>Collection ${escapeHTML(id)}</h2>`;
+  for (const [group, groupItems] of groups) {
+    const rows = groupItems.map((item) => {
+      const links = gateways.map((gw, i) =>
+        `<a href="${escapeHTML(gatewayURL(gw, item.cid, item.filename))}" rel="noopener" target="_blank">gateway ${i + 1}</a>`
+      ).join(" ");
+      return `<tr>
+        <td>${escapeHTML(item.filename)}${item.endorsed ? ' <span title="Claim signed by the publisher">&#10003;</span>' : ""}</td>
+        <td>${escapeHTML(item.extension)}</td>
+        <td class="mono">${escapeHTML(item.cid)}</td>
+        <td>${links}</td>
+      </tr>`;
+    });
+    if (group !== "") {
+      html += `<div class="group">${escapeHTML(group)}</div>`;
+    }
+    html += table(["Filename", "Type", "CID", "Play"], rows);
+  }
+  view.innerHTML = html;
+}
+
+async function route() {
+  const hash = location.hash.replace(/^#/, "") || "/";
+  const parts = hash.split("/").filter(Boolean);
+
+  try {
+    if (parts.length === 0) {
+      await showActivity();
+    } else if (parts[0] === "publishers" && parts.length === 1) {
+      await showPublishers();
+    } else if (parts[0] === "publishers") {
+      await showPublisher(parts[1]);
+    } else if (parts[0] === "collections" && parts.length > 1) {
+      await showCollection(parts[1]);
+    } else {
+      view.textContent = "Not found";
+    }
+  } catch (err) {
+    view.innerHTML = `<p class="error">${escapeHTML(err.message)}</p>`;
+  }
+}
+
+async function init() {
+  try {
+    gateways = (await getJSON("/api/ui/config")).gateways || [];
+  } catch (err) {
+    gateways = [];
+  }
+  window.addEventListener("hashchange", route);
+  route();
+}
+
+init();
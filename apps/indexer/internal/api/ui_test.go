@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+)
+
+// newTestDB creates a migrated database with one public and one unlisted collection
+func newTestDB(t *testing.T) *database.DB {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"), log)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("publisher-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for ipns, visibility := range map[string]string{"k51public": database.VisibilityPublic, "k51unlisted": database.VisibilityUnlisted} {
+		if _, err := db.CreateCollection(host.ID, publisher.ID, 1, ipns, nil, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetCollectionMeta(publisher.ID, ipns, visibility, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+// newTestHandler returns the handler of a server with the REST routes of db and
+// the UI, as served behind the authentication of cfg
+func newTestHandler(cfg *config.APIConfig, db *database.DB) http.Handler {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	server := NewServer("", cfg, log)
+	RegisterREST(server.Mux(), db)
+	RegisterUI(server.Mux(), cfg)
+	return server.Handler()
+}
+
+func TestRESTWithoutUI(t *testing.T) {
+	handler := newTestHandler(&config.APIConfig{}, newTestDB(t))
+
+	for path, want := range map[string]int{
+		"/api/collections":         http.StatusOK,
+		"/api/collections/1/items": http.StatusOK,
+		"/api/items?q=a":           http.StatusOK,
+		"/api/publishers":          http.StatusOK,
+		"/api/stats":               http.StatusOK,
+		"/api/facets":              http.StatusOK,
+		"/api/ui/config":           http.StatusNotFound,
+		"/":                        http.StatusNotFound,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("GET %s with the UI disabled: status = %d, want %d", path, rec.Code, want)
+		}
+	}
+}
+
+func TestActivityUnlisted(t *testing.T) {
+	db := newTestDB(t)
+	cfg := &config.APIConfig{BearerToken: "secret", UIEnabled: true}
+	handler := newTestHandler(cfg, db)
+
+	tests := []struct {
+		name    string
+		query   string
+		token   string
+		status  int
+		entries int
+	}{
+		{"authenticated default", "", "secret", http.StatusOK, 1},
+		{"authenticated with unlisted", "?include_unlisted=true", "secret", http.StatusOK, 2},
+		{"unauthenticated", "?include_unlisted=true", "", http.StatusUnauthorized, 0},
+		{"invalid flag", "?include_unlisted=maybe", "secret", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/activity"+tt.query, nil)
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		var entries []ActivityEntry
+		if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(entries) != tt.entries {
+			t.Errorf("%s: %d entries, want %d", tt.name, len(entries), tt.entries)
+		}
+	}
+}
+
+func TestActivityUnlistedWithoutAuth(t *testing.T) {
+	db := newTestDB(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/activity?include_unlisted=1", nil)
+	rec := httptest.NewRecorder()
+	ActivityHandler(db).ServeHTTP(rec, req)
+
+	// Without configured credentials nobody can be authenticated
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusForbidden)
+	}
+}
@@ -0,0 +1,241 @@
+package catchup
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/atregu/ipfs-common/query"
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/sirupsen/logrus"
+)
+
+// Client resolves collection roots and publishes raw PubSub messages (implemented by ipfs.Client)
+type Client interface {
+	ResolveIPNS(ctx context.Context, ipnsName string) (string, error)
+	ResolveIndexFile(ctx context.Context, rootCID string) (string, error)
+	PublishToPubSub(ctx context.Context, topic string, data []byte) error
+}
+
+// queryTimeout bounds the publication of the query
+const queryTimeout = 10 * time.Second
+
+// answerWait is how long re-resolution waits after the query, so that collections
+// whose publishers answer are stored as pending and need not be resolved. Publishers
+// answer within 10 seconds.
+const answerWait = 15 * time.Second
+
+// resolveTimeout bounds the resolution of a single collection
+const resolveTimeout = time.Minute
+
+// progressEvery is the number of checked collections between progress log lines
+const progressEvery = 25
+
+// Result summarizes a catch-up run
+type Result struct {
+	QuerySent   bool // The query for current announcements was published
+	Collections int  // Current collections known to the indexer
+	Unchanged   int  // Collections whose name still resolves to the stored version
+	Scheduled   int  // Collections that changed; a new pending version was created
+	Skipped     int  // Collections whose latest version is still pending or stale
+	Failed      int  // Collections that could not be resolved
+}
+
+// CatchUp brings an indexer that was offline up to date: it asks publishers to
+// repeat their current announcements and re-resolves every known collection,
+// scheduling a new version of those whose IPNS name points elsewhere now
+type CatchUp struct {
+	client Client
+	db     *database.DB
+	topic  string
+	key    ed25519.PrivateKey
+	cfg    *config.CatchUpConfig
+	log    *logrus.Logger
+	wait   time.Duration
+}
+
+// New creates a catch-up for the collections in db. The query is published on the
+// query topic of announceTopic and signed with key; without a key it is not sent.
+func New(client Client, db *database.DB, announceTopic string, key ed25519.PrivateKey, cfg *config.CatchUpConfig, log *logrus.Logger) *CatchUp {
+	return &CatchUp{
+		client: client,
+		db:     db,
+		topic:  query.Topic(announceTopic),
+		key:    key,
+		cfg:    cfg,
+		log:    log,
+		wait:   answerWait,
+	}
+}
+
+// Run waits a random delay of up to the configured jitter, publishes the query and
+// re-resolves the current collections at the configured rate. It returns early
+// with the partial result when ctx is done.
+func (c *CatchUp) Run(ctx context.Context) (*Result, error) {
+	result := &Result{}
+
+	jitter := time.Duration(rand.Int64N(int64(c.cfg.JitterSeconds)*int64(time.Second) + 1))
+	c.log.Infof("Catching up on missed announcements in %v", jitter.Round(time.Second))
+	if err := sleep(ctx, jitter); err != nil {
+		return result, err
+	}
+
+	if c.key == nil {
+		c.log.Info("Catch-up query not sent: no signing key")
+	} else if err := c.sendQuery(ctx); err != nil {
+		c.log.Warnf("Failed to send catch-up query: %v", err)
+	} else {
+		result.QuerySent = true
+		c.log.Infof("Asked publishers for current announcements on PubSub topic %q", c.topic)
+		if err := sleep(ctx, c.wait); err != nil {
+			return result, err
+		}
+	}
+
+	collections, err := c.db.ListCollections(0, true)
+	if err != nil {
+		return result, err
+	}
+	result.Collections = len(collections)
+
+	interval := time.Minute / time.Duration(c.cfg.ResolvesPerMinute)
+	c.log.Infof("Re-resolving %d collections (at most %d per minute)", len(collections), c.cfg.ResolvesPerMinute)
+
+	resolved := 0
+	for i, collection := range collections {
+		if collection.Status == "pending" || collection.Status == "stale" || collection.Status == "incomplete" {
+			result.Skipped++
+		} else {
+			if resolved > 0 {
+				if err := sleep(ctx, interval); err != nil {
+					return result, err
+				}
+			}
+			resolved++
+			c.check(ctx, collection, result)
+		}
+
+		if checked := i + 1; checked%progressEvery == 0 && checked < len(collections) {
+			c.log.Infof("Catch-up progress: %d/%d collections checked, %d changed, %d failed",
+				checked, len(collections), result.Scheduled, result.Failed)
+		}
+	}
+
+	c.log.Infof("Catch-up finished: %d collections, %d unchanged, %d changed, %d pending, %d failed",
+		result.Collections, result.Unchanged, result.Scheduled, result.Skipped, result.Failed)
+	return result, nil
+}
+
+// sendQuery publishes a signed query for current announcements
+func (c *CatchUp) sendQuery(ctx context.Context) error {
+	msg := query.New()
+	if err := msg.Sign(c.key); err != nil {
+		return fmt.Errorf("failed to sign query: %w", err)
+	}
+
+	data, err := msg.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize query: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	return c.client.PublishToPubSub(ctx, c.topic, data)
+}
+
+// check resolves a collection and schedules a new version if its name points at
+// another index than the stored version
+func (c *CatchUp) check(ctx context.Context, collection *database.Collection, result *Result) {
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+
+	root, err := c.resolve(ctx, collection)
+	if err != nil {
+		c.log.Warnf("Catch-up could not resolve collection ID=%d, IPNS=%s: %v", collection.ID, collection.IPNS, err)
+		result.Failed++
+		return
+	}
+	if root == collection.RootCID || root == collection.IndexCID {
+		result.Unchanged++
+		return
+	}
+
+	// Collections stored before root CIDs were announced only record the index file
+	indexCID, err := c.client.ResolveIndexFile(ctx, root)
+	if err != nil {
+		c.log.Warnf("Catch-up could not locate the index of collection ID=%d in %s: %v", collection.ID, root, err)
+		result.Failed++
+		return
+	}
+	if indexCID == collection.IndexCID {
+		result.Unchanged++
+		return
+	}
+
+	next, err := c.schedule(collection, root, indexCID)
+	if err != nil {
+		c.log.Errorf("Catch-up could not schedule collection IPNS=%s: %v", collection.IPNS, err)
+		result.Failed++
+		return
+	}
+	c.log.Infof("Collection IPNS=%s changed since version %d, scheduled ID=%d for root %s",
+		collection.IPNS, collection.Version, next.ID, root)
+	result.Scheduled++
+}
+
+// resolve resolves the primary IPNS name of a collection and, if that fails,
+// each announced mirror name in turn
+func (c *CatchUp) resolve(ctx context.Context, collection *database.Collection) (string, error) {
+	root, err := c.client.ResolveIPNS(ctx, collection.IPNS)
+	if err == nil {
+		return root, nil
+	}
+
+	for _, mirror := range collection.Mirrors {
+		if root, mirrorErr := c.client.ResolveIPNS(ctx, mirror); mirrorErr == nil {
+			return root, nil
+		}
+	}
+	return "", err
+}
+
+// schedule records a pending version after collection that the fetcher downloads
+// from root. Without an announcement the publisher's version number is unknown,
+// so it is numbered after the stored one.
+func (c *CatchUp) schedule(collection *database.Collection, root, indexCID string) (*database.Collection, error) {
+	next, err := c.db.CreateCollection(collection.HostID, collection.PublisherID, collection.Version+1,
+		collection.IPNS, nil, time.Now().Unix())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.db.SetCollectionAnnouncedCIDs(next.ID, root, indexCID); err != nil {
+		return nil, err
+	}
+	if len(collection.Mirrors) > 0 {
+		if err := c.db.SetCollectionMirrors(next.ID, collection.Mirrors); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.db.SetCollectionMeta(collection.PublisherID, collection.IPNS, collection.Visibility, collection.License); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// sleep waits for d or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,222 @@
+package catchup
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-common/query"
+	"github.com/atregu/ipfs-common/topic"
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeClient resolves names and roots from maps and records published messages
+type fakeClient struct {
+	mu        sync.Mutex
+	names     map[string]string // IPNS name -> root CID
+	indexes   map[string]string // root CID -> index file CID
+	topics    []string
+	published [][]byte
+}
+
+func (c *fakeClient) ResolveIPNS(ctx context.Context, name string) (string, error) {
+	if root, ok := c.names[name]; ok {
+		return root, nil
+	}
+	return "", errors.New("not found")
+}
+
+func (c *fakeClient) ResolveIndexFile(ctx context.Context, root string) (string, error) {
+	if index, ok := c.indexes[root]; ok {
+		return index, nil
+	}
+	return root, nil // A bare index file
+}
+
+func (c *fakeClient) PublishToPubSub(ctx context.Context, topic string, data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.topics = append(c.topics, topic)
+	c.published = append(c.published, data)
+	return nil
+}
+
+// testEnv is a database with one host and publisher
+type testEnv struct {
+	db          *database.DB
+	hostID      int64
+	publisherID int64
+	log         *logrus.Logger
+}
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"), log)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("publisher-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testEnv{db: db, hostID: host.ID, publisherID: publisher.ID, log: log}
+}
+
+// addCollection stores version 3 of ipns with the given status, root and index CID
+func (e *testEnv) addCollection(t *testing.T, ipns, status, rootCID, indexCID string) *database.Collection {
+	t.Helper()
+
+	size := 2
+	c, err := e.db.CreateCollection(e.hostID, e.publisherID, 3, ipns, &size, 1700000000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rootCID != "" {
+		if err := e.db.SetCollectionAnnouncedCIDs(c.ID, rootCID, indexCID); err != nil {
+			t.Fatal(err)
+		}
+	} else if err := e.db.SetCollectionIndexCID(c.ID, indexCID); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.db.UpdateCollectionStatus(c.ID, status, nil); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// fastConfig catches up without jitter or rate limiting delays
+var fastConfig = config.CatchUpConfig{Enabled: true, ResolvesPerMinute: 60000}
+
+func TestRunQueriesAndSchedulesChangedCollections(t *testing.T) {
+	env := newTestEnv(t)
+	env.addCollection(t, "k51same", "downloaded", "root-same", "index-same")
+	env.addCollection(t, "k51legacy", "downloaded", "", "index-legacy")
+	changed := env.addCollection(t, "k51changed", "downloaded", "root-old", "index-old")
+	if err := env.db.SetCollectionMirrors(changed.ID, []string{"k51mirror"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.db.SetCollectionMeta(env.publisherID, "k51changed", database.VisibilityUnlisted, "CC0"); err != nil {
+		t.Fatal(err)
+	}
+	env.addCollection(t, "k51pending", "pending", "", "")
+	env.addCollection(t, "k51gone", "downloaded", "root-gone", "index-gone")
+
+	client := &fakeClient{
+		names: map[string]string{
+			"k51same":    "root-same",
+			"k51legacy":  "root-legacy",
+			"k51changed": "root-new",
+			"k51pending": "root-pending",
+		},
+		indexes: map[string]string{
+			"root-legacy": "index-legacy",
+			"root-new":    "index-new",
+		},
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := New(client, env.db, topic.Default, key, &fastConfig, env.log)
+	c.wait = 0
+
+	result, err := c.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Result{QuerySent: true, Collections: 5, Unchanged: 2, Scheduled: 1, Skipped: 1, Failed: 1}
+	if *result != want {
+		t.Errorf("result = %+v, want %+v", *result, want)
+	}
+
+	// The query is signed and published on the companion topic
+	if len(client.published) != 1 || client.topics[0] != "mdn/collections/query" {
+		t.Fatalf("published %d messages on %v, want one query", len(client.published), client.topics)
+	}
+	msg, err := query.Parse(client.published[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := msg.Validate(); err != nil {
+		t.Errorf("query does not validate: %v", err)
+	}
+	if err := msg.Verify(); err != nil {
+		t.Errorf("query does not verify: %v", err)
+	}
+
+	// The changed collection has a pending version fetched from the new root
+	pending, err := env.db.GetPendingCollections(10, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var next *database.Collection
+	for _, p := range pending {
+		if p.IPNS == "k51changed" {
+			next = p
+		}
+	}
+	if next == nil {
+		t.Fatalf("no pending version of the changed collection in %d pending", len(pending))
+	}
+	if next.Version != 4 || next.RootCID != "root-new" || next.IndexCID != "index-new" {
+		t.Errorf("scheduled version %d root %q index %q, want 4 root-new index-new", next.Version, next.RootCID, next.IndexCID)
+	}
+	if len(next.Mirrors) != 1 || next.Visibility != database.VisibilityUnlisted || next.License != "CC0" {
+		t.Errorf("scheduled mirrors %v visibility %q license %q, want those of version 3", next.Mirrors, next.Visibility, next.License)
+	}
+}
+
+func TestRunWithoutKeyOnlyResolves(t *testing.T) {
+	env := newTestEnv(t)
+	collection := env.addCollection(t, "k51mirrored", "failed", "root-old", "index-old")
+	if err := env.db.SetCollectionMirrors(collection.ID, []string{"k51mirror"}); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &fakeClient{names: map[string]string{"k51mirror": "root-old"}}
+	result, err := New(client, env.db, topic.Default, nil, &fastConfig, env.log).Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.QuerySent || len(client.published) != 0 {
+		t.Error("query sent without a signing key")
+	}
+	if result.Unchanged != 1 {
+		t.Errorf("result = %+v, want the collection resolved through its mirror", *result)
+	}
+}
+
+func TestRunStopsWithContext(t *testing.T) {
+	env := newTestEnv(t)
+	client := &fakeClient{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cfg := config.CatchUpConfig{Enabled: true, JitterSeconds: 3600, ResolvesPerMinute: 1}
+	if _, err := New(client, env.db, topic.Default, nil, &cfg, env.log).Run(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Run = %v, want context.Canceled", err)
+	}
+	if len(client.published) != 0 {
+		t.Error("query sent after cancellation")
+	}
+}
@@ -0,0 +1,218 @@
+package check
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/atregu/ipfs-indexer/internal/ipfs"
+)
+
+// Severity classifies the outcome of a check
+type Severity int
+
+const (
+	// Passed checks need no attention
+	Passed Severity = iota
+	// Warning checks point at a likely misconfiguration but do not fail the run
+	Warning
+	// Failed checks make the run fail
+	Failed
+)
+
+// Result is the outcome of a single check
+type Result struct {
+	Name     string
+	Severity Severity
+	Detail   string
+}
+
+// Report collects the results of a check run
+type Report struct {
+	Results []Result
+}
+
+// Options controls which checks Run performs
+type Options struct {
+	// StaticOnly performs only config, path and database checks (--validate-config)
+	StaticOnly bool
+
+	// StartNode starts the IPFS node and joins the topic; otherwise only port availability is checked
+	StartNode bool
+
+	// PeerWait is how long to wait for peers after joining the topic
+	PeerWait time.Duration
+
+	// IgnoreUnknownKeys reports unknown config keys as warnings (--ignore-unknown-config)
+	IgnoreUnknownKeys bool
+}
+
+func (r *Report) add(name string, ok bool, format string, args ...interface{}) {
+	severity := Passed
+	if !ok {
+		severity = Failed
+	}
+	r.Results = append(r.Results, Result{Name: name, Severity: severity, Detail: fmt.Sprintf(format, args...)})
+}
+
+func (r *Report) warn(name string, format string, args ...interface{}) {
+	r.Results = append(r.Results, Result{Name: name, Severity: Warning, Detail: fmt.Sprintf(format, args...)})
+}
+
+// count returns the number of results with the given severity
+func (r *Report) count(severity Severity) int {
+	n := 0
+	for _, res := range r.Results {
+		if res.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// OK reports whether no check failed; warnings do not count as failures
+func (r *Report) OK() bool {
+	return r.count(Failed) == 0
+}
+
+// ExitCode returns 0 if no check failed and 1 otherwise
+func (r *Report) ExitCode() int {
+	if r.OK() {
+		return 0
+	}
+	return 1
+}
+
+// Print writes a human readable summary
+func (r *Report) Print(w io.Writer) {
+	for _, res := range r.Results {
+		mark := "✓"
+		switch res.Severity {
+		case Warning:
+			mark = "!"
+		case Failed:
+			mark = "✗"
+		}
+		fmt.Fprintf(w, "%s %-10s %s\n", mark, res.Name, res.Detail)
+	}
+
+	failed, warnings := r.count(Failed), r.count(Warning)
+	switch {
+	case failed > 0:
+		fmt.Fprintf(w, "\n%d of %d checks failed, %d warning(s)\n", failed, len(r.Results), warnings)
+	case warnings > 0:
+		fmt.Fprintf(w, "\nAll checks passed with %d warning(s)\n", warnings)
+	default:
+		fmt.Fprintf(w, "\nAll %d checks passed\n", len(r.Results))
+	}
+}
+
+// Run loads the configuration at configPath and checks the indexer stack.
+// It never returns early on a failed check unless later checks depend on it.
+func Run(ctx context.Context, configPath string, opts Options) *Report {
+	report := &Report{}
+
+	cfg, err := config.LoadWithOptions(configPath, config.LoadOptions{IgnoreUnknownKeys: opts.IgnoreUnknownKeys})
+	if err != nil {
+		report.add("config", false, "%v", err)
+		return report
+	}
+	report.add("config", true, "%s is valid", configPath)
+	for _, warning := range cfg.Warnings() {
+		report.warn("config", "%s", warning)
+	}
+	report.add("topic", true, "%s", cfg.Pubsub.Topic)
+
+	checkDatabase(report, cfg.Database.Path)
+
+	if opts.StaticOnly {
+		return report
+	}
+
+	if !opts.StartNode {
+		emb := cfg.IPFS.Embedded
+		if err := ipfs.CheckAllPortsAvailable(emb.SwarmPort, emb.APIPort, emb.GatewayPort); err != nil {
+			report.add("ports", false, "%v", err)
+		} else {
+			report.add("ports", true, "swarm %d, API %d, gateway %d available", emb.SwarmPort, emb.APIPort, emb.GatewayPort)
+		}
+		return report
+	}
+
+	checkNode(ctx, report, cfg, opts.PeerWait)
+	return report
+}
+
+// checkDatabase verifies the database directory and schema version
+func checkDatabase(report *Report, dbPath string) {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		dir := filepath.Dir(dbPath)
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			report.add("database", false, "%s does not exist and directory %s is missing", dbPath, dir)
+			return
+		}
+		report.add("database", true, "%s will be created on first start", dbPath)
+		return
+	}
+
+	current, latest, err := database.SchemaVersion(dbPath)
+	if err != nil {
+		report.add("database", false, "%v", err)
+		return
+	}
+	if current < latest {
+		report.add("database", true, "%s opens; schema version %d, %d pending migration(s) up to %d will run on start", dbPath, current, latest-current, latest)
+		return
+	}
+	if current > latest {
+		report.add("database", false, "%s has schema version %d, newer than this build supports (%d)", dbPath, current, latest)
+		return
+	}
+	report.add("database", true, "%s opens; schema version %d is current", dbPath, current)
+}
+
+// checkNode starts the embedded node briefly, joins the topic and reports peer counts
+func checkNode(ctx context.Context, report *Report, cfg *config.Config, peerWait time.Duration) {
+	client, err := ipfs.NewClient(&cfg.IPFS.Embedded)
+	if err != nil {
+		report.add("ipfs", false, "%v", err)
+		return
+	}
+
+	if err := client.Start(); err != nil {
+		report.add("ipfs", false, "%v", err)
+		return
+	}
+	defer client.Close()
+	report.add("ipfs", true, "node started, peer ID %s", client.GetPeerID())
+
+	if repo, err := client.RepoStat(ctx); err != nil {
+		report.add("repo", false, "%v", err)
+	} else if repo.StorageMax > 0 {
+		report.add("repo", true, "%d of %d bytes used (%.1f%%), %d objects", repo.RepoSize, repo.StorageMax, repo.Utilization()*100, repo.NumObjects)
+	} else {
+		report.add("repo", true, "%d bytes used, %d objects", repo.RepoSize, repo.NumObjects)
+	}
+
+	sub, err := client.Subscribe(ctx, cfg.Pubsub.Topic)
+	if err != nil {
+		report.add("pubsub", false, "%v", err)
+		return
+	}
+	defer sub.Cancel()
+
+	if peerWait > 0 {
+		select {
+		case <-time.After(peerWait):
+		case <-ctx.Done():
+		}
+	}
+
+	topicPeers := len(client.GetPubSub().ListPeers(cfg.Pubsub.Topic))
+	report.add("pubsub", true, "joined %s; %d connected peers, %d topic peers", cfg.Pubsub.Topic, client.GetPeerCount(), topicPeers)
+}
@@ -1,10 +1,22 @@
 package config
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
 
+	"github.com/atregu/ipfs-common/bootstrap"
+	"github.com/atregu/ipfs-common/configschema"
+	"github.com/atregu/ipfs-common/fingerprint"
+	"github.com/atregu/ipfs-common/topic"
+	"github.com/go-viper/mapstructure/v2"
 	"github.com/spf13/viper"
 )
 
@@ -17,64 +29,193 @@ const (
 
 // EmbeddedIPFSConfig contains settings for embedded IPFS node
 type EmbeddedIPFSConfig struct {
-	RepoPath       string   `mapstructure:"repo_path"`
-	SwarmPort      int      `mapstructure:"swarm_port"`
-	APIPort        int      `mapstructure:"api_port"`
-	GatewayPort    int      `mapstructure:"gateway_port"`
-	BootstrapPeers []string `mapstructure:"bootstrap_peers"`
-	GC             GCConfig `mapstructure:"gc"`
+	RepoPath       string              `mapstructure:"repo_path" desc:"Repository of the embedded node (required)"`
+	SwarmPort      int                 `mapstructure:"swarm_port" desc:"libp2p swarm port"`
+	APIPort        int                 `mapstructure:"api_port" desc:"Kubo RPC API port"`
+	GatewayPort    int                 `mapstructure:"gateway_port" desc:"HTTP gateway port"`
+	BootstrapPeers []string            `mapstructure:"bootstrap_peers" desc:"Multiaddrs ending in /p2p/<peer ID>, or bare peer IDs; empty = keep the repo's list"`
+	PeerAddresses  map[string][]string `mapstructure:"peer_addresses" desc:"Addresses of the bare peer IDs in bootstrap_peers"`
+	GC             GCConfig            `mapstructure:"gc"`
+	Resources      ResourcesConfig     `mapstructure:"resources"`
 }
 
+// ResourcesConfig limits the libp2p resources of the embedded node.
+// Zero values keep kubo's defaults.
+type ResourcesConfig struct {
+	MaxMemory     string `mapstructure:"max_memory" desc:"Resource manager memory ceiling, e.g. 512MB; empty = kubo default"`
+	ConnLowWater  int    `mapstructure:"conn_low_water" desc:"Connection manager low water mark; 0 = kubo default"`
+	ConnHighWater int    `mapstructure:"conn_high_water" desc:"Connection manager high water mark; 0 = kubo default"`
+}
+
+// memorySizePattern matches kubo memory sizes such as "512MB" or "1.5GiB"
+var memorySizePattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?\s*([KMGT]i?)?B$`)
+
 // GCConfig contains garbage collection settings
 type GCConfig struct {
-	Enabled      bool  `mapstructure:"enabled"`
-	Interval     int64 `mapstructure:"interval"`
-	MinFreeSpace int64 `mapstructure:"min_free_space"`
+	Enabled      bool  `mapstructure:"enabled" desc:"Run repository garbage collection"`
+	Interval     int64 `mapstructure:"interval" desc:"Seconds between garbage collections"`
+	MinFreeSpace int64 `mapstructure:"min_free_space" desc:"Bytes of free disk space to keep"`
 }
 
 // IPFSConfig contains IPFS-related configuration
 type IPFSConfig struct {
-	Mode     IPFSMode           `mapstructure:"mode"`
+	Mode     IPFSMode           `mapstructure:"mode" desc:"IPFS mode; only embedded is supported (required)"`
 	Embedded EmbeddedIPFSConfig `mapstructure:"embedded"`
 }
 
 // DatabaseConfig contains database settings
 type DatabaseConfig struct {
-	Type string `mapstructure:"type"`
-	Path string `mapstructure:"path"`
+	Type string `mapstructure:"type" desc:"Database type; only sqlite is supported (required)"`
+	Path string `mapstructure:"path" desc:"SQLite database file (required)"`
 }
 
 // PubsubConfig contains Pubsub-related configuration
 type PubsubConfig struct {
-	Topic string `mapstructure:"topic"`
+	Topic          string         `mapstructure:"topic" desc:"Announcement topic, mdn/<category>/announce (required)"`
+	TopicAllowlist []string       `mapstructure:"topic_allowlist" desc:"path.Match patterns the topic must match; empty = any valid topic"`
+	Ack            AckConfig      `mapstructure:"ack"`
+	CatchUp        CatchUpConfig  `mapstructure:"catch_up"`
+	Listener       ListenerConfig `mapstructure:"listener"`
+}
+
+// ListenerConfig controls which announcements the listener stores: their
+// signatures are verified, and copies of announcements it already handled, e.g.
+// from GossipSub delivering a message more than once, are dropped
+type ListenerConfig struct {
+	VerificationEnabled bool `mapstructure:"verification_enabled" desc:"Discard announcements whose signature does not verify against their public key; disable only for development"`
+	MessageCacheSize    int  `mapstructure:"message_cache_size" desc:"Announcements remembered by publisher key and nonce" default:"10000"`
+	AnnounceInterval    int  `mapstructure:"announce_interval" desc:"Seconds between the publishers' announcements; a copy is dropped until its timestamp plus twice this" default:"3600"`
+	DedupeWindow        int  `mapstructure:"dedupe_window" desc:"Seconds after receiving a message during which copies with the same payload are dropped before parsing" default:"60"`
+}
+
+// AckConfig controls the signed acknowledgements published on the companion
+// ack topic after an announcement has been stored
+type AckConfig struct {
+	Enabled         bool `mapstructure:"enabled" desc:"Publish signed acks of stored announcements on mdn/<category>/ack"`
+	IntervalSeconds int  `mapstructure:"interval_seconds" desc:"Minimum seconds between acks of the same publisher version" default:"600"`
+	MaxPerMinute    int  `mapstructure:"max_per_minute" desc:"Ceiling on acks published per minute" default:"30"`
+}
+
+// CatchUpConfig controls the catch-up after startup: a signed query asking publishers
+// to repeat their current announcements and a re-resolution of every known collection
+type CatchUpConfig struct {
+	Enabled           bool `mapstructure:"enabled" desc:"Query publishers and re-resolve known collections after subscribing"`
+	JitterSeconds     int  `mapstructure:"jitter_seconds" desc:"Random delay of up to this many seconds before catching up"`
+	ResolvesPerMinute int  `mapstructure:"resolves_per_minute" desc:"Ceiling on IPNS resolutions while catching up" default:"30"`
 }
 
 // FetcherConfig contains fetcher settings
 type FetcherConfig struct {
-	RetryAttempts        int `mapstructure:"retry_attempts"`
-	RetryIntervalSeconds int `mapstructure:"retry_interval_seconds"`
-	ConcurrentDownloads  int `mapstructure:"concurrent_downloads"`
+	RetryAttempts         int      `mapstructure:"retry_attempts" desc:"Attempts before a collection is marked failed" default:"10"`
+	RetryIntervalSeconds  int      `mapstructure:"retry_interval_seconds" desc:"Seconds between fetch attempts" default:"60"`
+	ConcurrentDownloads   int      `mapstructure:"concurrent_downloads" desc:"Collections downloaded in parallel" default:"5"`
+	BlockParallelism      int      `mapstructure:"block_parallelism" desc:"Concurrent block requests per collection download" default:"16"`
+	InsertRetries         int      `mapstructure:"insert_retries" desc:"Retries of a batch after a transient database error; 0 disables"`
+	PreviewMaxBytes       int      `mapstructure:"preview_max_bytes" desc:"Bytes of an index a preview downloads; the rest is not parsed" default:"67108864"`
+	MaxCollectionSize     int64    `mapstructure:"max_collection_size" desc:"Largest index in bytes a fetch downloads; larger collections are marked failed; 0 = unlimited"`
+	CompletenessTolerance int      `mapstructure:"completeness_tolerance" desc:"Items the indexed count may differ from the announced collectionSize before a collection is marked incomplete"`
+	Gateways              []string `mapstructure:"gateways" desc:"HTTP gateways, in order, an index is fetched from when the native download fails; empty disables the fallback"`
+	GatewayMaxBytes       int64    `mapstructure:"gateway_max_bytes" desc:"Largest index in bytes fetched from a gateway; max_collection_size applies too" default:"67108864"`
+	NativeTimeoutSeconds  int      `mapstructure:"native_timeout_seconds" desc:"Seconds the native download may take before falling back to the gateways; only applies with gateways" default:"120"`
+}
+
+// DefaultInsertRetries is the number of retries of a transient database error
+// used when fetcher.insert_retries is not configured
+const DefaultInsertRetries = 3
+
+// LimitsConfig contains soft quotas protecting the database from oversized publishers
+type LimitsConfig struct {
+	MaxItemsPerCollection int `mapstructure:"max_items_per_collection" desc:"Items indexed per collection; 0 = unlimited"`
+	MaxItemsPerPublisher  int `mapstructure:"max_items_per_publisher" desc:"Items stored per publisher before new collections are refused; 0 = unlimited"`
 }
 
+// ClaimsConfig controls how the per-record claims publishers may sign into their
+// indexes are verified. Items whose claim verified are stored as endorsed.
+type ClaimsConfig struct {
+	Verify     string `mapstructure:"verify" desc:"Claim verification: off, sample or all" default:"sample"`
+	SampleSize int    `mapstructure:"sample_size" desc:"Signed records verified per index in sample mode" default:"32"`
+}
+
+// Claim verification modes
+const (
+	ClaimVerifyOff    = "off"
+	ClaimVerifySample = "sample"
+	ClaimVerifyAll    = "all"
+)
+
+// AggregatorConfig controls the aggregator, which publishes the merged catalog of
+// the included publishers as a collection of the indexer's own
+type AggregatorConfig struct {
+	Enabled         bool     `mapstructure:"enabled" desc:"Publish the merged catalog as a collection of this indexer"`
+	IntervalSeconds int      `mapstructure:"interval_seconds" desc:"Seconds between builds of the aggregate" default:"3600"`
+	Publishers      []string `mapstructure:"publishers" desc:"Base64 public keys or fingerprints of the included publishers; empty = all"`
+	MaxItems        int      `mapstructure:"max_items" desc:"Items in the aggregate at most" default:"100000"`
+	KeyPath         string   `mapstructure:"key_path" desc:"Hex Ed25519 key signing the announcements, created if missing; empty = aggregator.key next to the database"`
+	IPNSKey         string   `mapstructure:"ipns_key" desc:"Node key the aggregate is published under" default:"aggregator"`
+	StatePath       string   `mapstructure:"state_path" desc:"Version and CIDs of the last aggregate; empty = aggregator.json next to the database"`
+}
+
+// BasicAuthConfig contains HTTP basic authentication credentials
+type BasicAuthConfig struct {
+	Username string `mapstructure:"username" desc:"Basic auth user; enabled when username and password are set"`
+	Password string `mapstructure:"password" desc:"Basic auth password"`
+}
+
+// APIConfig contains REST API settings
+type APIConfig struct {
+	ListenAddr  string          `mapstructure:"listen_addr" desc:"host:port of the HTTP server for /metrics and the API; empty = disabled"`
+	BasicAuth   BasicAuthConfig `mapstructure:"basic_auth"`
+	BearerToken string          `mapstructure:"bearer_token" desc:"Require Authorization: Bearer <token> instead of basic auth"`
+	UIEnabled   bool            `mapstructure:"ui_enabled" desc:"Serve the read-only web UI at / (requires listen_addr); the REST API is served whenever listen_addr is set"`
+	Gateways    []string        `mapstructure:"gateways" desc:"Playback URL templates; {cid} and {filename} are substituted" default:"https://ipfs.io/ipfs/{cid}?filename={filename}"`
+}
+
+// TracingConfig contains settings of the OpenTelemetry traces of the ingest pipeline
+type TracingConfig struct {
+	Enabled bool `mapstructure:"enabled" desc:"Export OpenTelemetry traces over OTLP, configured by the standard OTEL_EXPORTER_OTLP_* variables"`
+}
+
+// DefaultGateway is the playback URL template used when api.gateways is empty
+const DefaultGateway = "https://ipfs.io/ipfs/{cid}?filename={filename}"
+
 // LoggingConfig contains logging settings
 type LoggingConfig struct {
-	Level    string `mapstructure:"level"`
-	Format   string `mapstructure:"format"`
-	Output   string `mapstructure:"output"`
-	FilePath string `mapstructure:"file_path"`
+	Level    string `mapstructure:"level" desc:"debug, info, warn or error" default:"info"`
+	Format   string `mapstructure:"format" desc:"text or json" default:"text"`
+	Output   string `mapstructure:"output" desc:"stdout or file" default:"stdout"`
+	FilePath string `mapstructure:"file_path" desc:"Log file when output is file"`
 }
 
 // Config represents the complete application configuration
 type Config struct {
-	IPFS     IPFSConfig     `mapstructure:"ipfs"`
-	Database DatabaseConfig `mapstructure:"database"`
-	Pubsub   PubsubConfig   `mapstructure:"pubsub"`
-	Fetcher  FetcherConfig  `mapstructure:"fetcher"`
-	Logging  LoggingConfig  `mapstructure:"logging"`
+	IPFS       IPFSConfig       `mapstructure:"ipfs"`
+	Database   DatabaseConfig   `mapstructure:"database"`
+	Pubsub     PubsubConfig     `mapstructure:"pubsub"`
+	Fetcher    FetcherConfig    `mapstructure:"fetcher"`
+	Limits     LimitsConfig     `mapstructure:"limits"`
+	Claims     ClaimsConfig     `mapstructure:"claims"`
+	Aggregator AggregatorConfig `mapstructure:"aggregator"`
+	API        APIConfig        `mapstructure:"api"`
+	Tracing    TracingConfig    `mapstructure:"tracing"`
+	Logging    LoggingConfig    `mapstructure:"logging"`
+
+	duplicatePeers []string // Bootstrap peers dropped by Validate as duplicates, reported by Warnings
+	unknownKeys    []string // Keys matching no setting, ignored on request and reported by Warnings
+}
+
+// LoadOptions adjusts how the configuration file is read
+type LoadOptions struct {
+	IgnoreUnknownKeys bool // Report unknown keys as warnings instead of failing
 }
 
-// Load reads and parses the configuration file
+// Load reads and parses the configuration file. Unknown keys, such as a
+// misspelled setting, fail the load.
 func Load(configPath string) (*Config, error) {
+	return LoadWithOptions(configPath, LoadOptions{})
+}
+
+// LoadWithOptions reads and parses the configuration file
+func LoadWithOptions(configPath string, opts LoadOptions) (*Config, error) {
 	v := viper.New()
 
 	// Set config file path
@@ -93,11 +234,24 @@ func Load(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
 
+	setDefaults(v)
+
 	var cfg Config
-	if err := v.Unmarshal(&cfg); err != nil {
+	var metadata mapstructure.Metadata
+	if err := v.Unmarshal(&cfg, func(dc *mapstructure.DecoderConfig) { dc.Metadata = &metadata }); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	// Viper drops keys that match no setting, so a misspelled key would silently keep its default
+	if len(metadata.Unused) > 0 {
+		sort.Strings(metadata.Unused)
+		if !opts.IgnoreUnknownKeys {
+			return nil, fmt.Errorf("unknown config keys %s; run \"ipfs-indexer config schema\" for the recognized keys",
+				strings.Join(metadata.Unused, ", "))
+		}
+		cfg.unknownKeys = metadata.Unused
+	}
+
 	// Validate and set defaults
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid config: %w", err)
@@ -106,6 +260,52 @@ func Load(configPath string) (*Config, error) {
 	return &cfg, nil
 }
 
+// setDefaults sets the defaults that may legitimately be configured as 0 or
+// false and so cannot be set in Validate
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("fetcher.insert_retries", DefaultInsertRetries)
+	v.SetDefault("pubsub.catch_up.enabled", true)
+	v.SetDefault("pubsub.catch_up.jitter_seconds", 30)
+	v.SetDefault("pubsub.listener.verification_enabled", true)
+}
+
+// Schema lists every recognized setting with its type, default and
+// description. Defaults applied by Validate come from the default tags.
+func Schema() ([]configschema.Field, error) {
+	v := viper.New()
+	setDefaults(v)
+
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal defaults: %w", err)
+	}
+
+	return configschema.Fields(&cfg), nil
+}
+
+// Warnings returns non-fatal configuration issues that should be logged at startup
+func (c *Config) Warnings() []string {
+	var warnings []string
+
+	if c.Pubsub.Topic != topic.Default {
+		warnings = append(warnings, fmt.Sprintf("pubsub.topic %q differs from the well-known default %q; only publishers using the same topic will be heard", c.Pubsub.Topic, topic.Default))
+	}
+
+	if !c.Pubsub.Listener.VerificationEnabled {
+		warnings = append(warnings, "pubsub.listener.verification_enabled is false; announcements are stored without checking their signature, so anyone can announce collections in a publisher's name")
+	}
+
+	if len(c.duplicatePeers) > 0 {
+		warnings = append(warnings, fmt.Sprintf("bootstrap peers %q repeat earlier entries and are ignored", c.duplicatePeers))
+	}
+
+	if len(c.unknownKeys) > 0 {
+		warnings = append(warnings, fmt.Sprintf("unknown config keys %s are ignored", strings.Join(c.unknownKeys, ", ")))
+	}
+
+	return warnings
+}
+
 // Validate checks if the configuration is valid and sets defaults
 func (c *Config) Validate() error {
 	// Validate IPFS config
@@ -126,6 +326,25 @@ func (c *Config) Validate() error {
 		c.IPFS.Embedded.RepoPath = abs
 	}
 
+	// Bootstrap peers are dialed in their canonical form; a typo fails here instead of at dial time
+	peers, duplicates, err := bootstrap.NormalizeList(c.IPFS.Embedded.BootstrapPeers, c.IPFS.Embedded.PeerAddresses)
+	if err != nil {
+		return fmt.Errorf("ipfs.embedded.bootstrap_peers: %w", err)
+	}
+	c.IPFS.Embedded.BootstrapPeers = peers
+	c.duplicatePeers = duplicates
+
+	res := c.IPFS.Embedded.Resources
+	if res.MaxMemory != "" && !memorySizePattern.MatchString(res.MaxMemory) {
+		return fmt.Errorf("ipfs.embedded.resources.max_memory must be a size like \"512MB\", got %q", res.MaxMemory)
+	}
+	if res.ConnLowWater < 0 || res.ConnHighWater < 0 {
+		return fmt.Errorf("ipfs.embedded.resources connection water marks must not be negative")
+	}
+	if res.ConnHighWater > 0 && res.ConnLowWater > res.ConnHighWater {
+		return fmt.Errorf("ipfs.embedded.resources.conn_low_water must not exceed conn_high_water")
+	}
+
 	// Validate database config
 	if c.Database.Type != "sqlite" {
 		return fmt.Errorf("only sqlite database type is supported")
@@ -145,6 +364,37 @@ func (c *Config) Validate() error {
 	if c.Pubsub.Topic == "" {
 		return fmt.Errorf("pubsub.topic is required")
 	}
+	if err := topic.Validate(c.Pubsub.Topic); err != nil {
+		return fmt.Errorf("pubsub.topic: %w", err)
+	}
+	allowed, err := TopicAllowed(c.Pubsub.Topic, c.Pubsub.TopicAllowlist)
+	if err != nil {
+		return fmt.Errorf("pubsub.topic_allowlist: %w", err)
+	}
+	if !allowed {
+		return fmt.Errorf("pubsub.topic %q is not in pubsub.topic_allowlist", c.Pubsub.Topic)
+	}
+	if c.Pubsub.Ack.IntervalSeconds <= 0 {
+		c.Pubsub.Ack.IntervalSeconds = 600
+	}
+	if c.Pubsub.Ack.MaxPerMinute <= 0 {
+		c.Pubsub.Ack.MaxPerMinute = 30
+	}
+	if c.Pubsub.CatchUp.JitterSeconds < 0 {
+		return fmt.Errorf("pubsub.catch_up.jitter_seconds must not be negative")
+	}
+	if c.Pubsub.CatchUp.ResolvesPerMinute <= 0 {
+		c.Pubsub.CatchUp.ResolvesPerMinute = 30
+	}
+	if c.Pubsub.Listener.MessageCacheSize <= 0 {
+		c.Pubsub.Listener.MessageCacheSize = 10000
+	}
+	if c.Pubsub.Listener.AnnounceInterval <= 0 {
+		c.Pubsub.Listener.AnnounceInterval = 3600
+	}
+	if c.Pubsub.Listener.DedupeWindow <= 0 {
+		c.Pubsub.Listener.DedupeWindow = 60
+	}
 
 	// Validate fetcher config with defaults
 	if c.Fetcher.RetryAttempts <= 0 {
@@ -156,6 +406,91 @@ func (c *Config) Validate() error {
 	if c.Fetcher.ConcurrentDownloads <= 0 {
 		c.Fetcher.ConcurrentDownloads = 5
 	}
+	if c.Fetcher.BlockParallelism <= 0 {
+		c.Fetcher.BlockParallelism = 16
+	}
+	if c.Fetcher.PreviewMaxBytes <= 0 {
+		c.Fetcher.PreviewMaxBytes = 64 << 20
+	}
+	// 0 disables retries; the default of 3 is set in Load so it can be told apart from unset
+	if c.Fetcher.InsertRetries < 0 {
+		return fmt.Errorf("fetcher.insert_retries must not be negative")
+	}
+	if c.Fetcher.MaxCollectionSize < 0 {
+		return fmt.Errorf("fetcher.max_collection_size must not be negative")
+	}
+	if c.Fetcher.CompletenessTolerance < 0 {
+		return fmt.Errorf("fetcher.completeness_tolerance must not be negative")
+	}
+	for i, gw := range c.Fetcher.Gateways {
+		u, err := url.Parse(gw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("fetcher.gateways entry %q must be an http(s) URL", gw)
+		}
+		c.Fetcher.Gateways[i] = strings.TrimRight(gw, "/")
+	}
+	if c.Fetcher.GatewayMaxBytes <= 0 {
+		c.Fetcher.GatewayMaxBytes = 64 << 20
+	}
+	if c.Fetcher.NativeTimeoutSeconds <= 0 {
+		c.Fetcher.NativeTimeoutSeconds = 120
+	}
+
+	// Validate limits (0 means unlimited)
+	if c.Limits.MaxItemsPerCollection < 0 {
+		return fmt.Errorf("limits.max_items_per_collection must not be negative")
+	}
+	if c.Limits.MaxItemsPerPublisher < 0 {
+		return fmt.Errorf("limits.max_items_per_publisher must not be negative")
+	}
+
+	// Validate claim verification with defaults
+	switch c.Claims.Verify {
+	case "":
+		c.Claims.Verify = ClaimVerifySample
+	case ClaimVerifyOff, ClaimVerifySample, ClaimVerifyAll:
+	default:
+		return fmt.Errorf("claims.verify must be off, sample or all, got %q", c.Claims.Verify)
+	}
+	if c.Claims.SampleSize < 0 {
+		return fmt.Errorf("claims.sample_size must not be negative")
+	}
+	if c.Claims.SampleSize == 0 {
+		c.Claims.SampleSize = 32
+	}
+
+	if err := c.Aggregator.validate(filepath.Dir(c.Database.Path)); err != nil {
+		return err
+	}
+
+	// Validate API listen address
+	if c.API.ListenAddr != "" {
+		if _, _, err := net.SplitHostPort(c.API.ListenAddr); err != nil {
+			return fmt.Errorf("invalid api.listen_addr %q: %w", c.API.ListenAddr, err)
+		}
+	}
+
+	if c.API.UIEnabled && c.API.ListenAddr == "" {
+		return fmt.Errorf("api.ui_enabled requires api.listen_addr")
+	}
+
+	// Validate API auth (both basic auth fields must be set together)
+	if (c.API.BasicAuth.Username == "") != (c.API.BasicAuth.Password == "") {
+		return fmt.Errorf("api.basic_auth requires both username and password")
+	}
+
+	// Validate gateway URL templates
+	if len(c.API.Gateways) == 0 {
+		c.API.Gateways = []string{DefaultGateway}
+	}
+	for _, gw := range c.API.Gateways {
+		if !strings.Contains(gw, "{cid}") {
+			return fmt.Errorf("api.gateways entry %q must contain {cid}", gw)
+		}
+		if !strings.HasPrefix(gw, "http://") && !strings.HasPrefix(gw, "https://") {
+			return fmt.Errorf("api.gateways entry %q must be an http(s) URL", gw)
+		}
+	}
 
 	// Validate logging config with defaults
 	if c.Logging.Level == "" {
@@ -178,3 +513,39 @@ func (c *Config) Validate() error {
 
 	return nil
 }
+
+// validate checks the aggregator settings and sets defaults, placing the key
+// and state files in dataDir unless configured
+func (a *AggregatorConfig) validate(dataDir string) error {
+	if a.IntervalSeconds < 0 {
+		return fmt.Errorf("aggregator.interval_seconds must not be negative")
+	}
+	if a.IntervalSeconds == 0 {
+		a.IntervalSeconds = 3600
+	}
+	if a.MaxItems < 0 {
+		return fmt.Errorf("aggregator.max_items must not be negative")
+	}
+	if a.MaxItems == 0 {
+		a.MaxItems = 100000
+	}
+	for _, key := range a.Publishers {
+		raw, err := base64.StdEncoding.DecodeString(key)
+		if (err != nil || len(raw) != ed25519.PublicKeySize) && !fingerprint.IsFingerprint(key) {
+			return fmt.Errorf("aggregator.publishers entry %q is neither a base64 Ed25519 public key nor a fingerprint", key)
+		}
+	}
+	if a.KeyPath == "" {
+		a.KeyPath = filepath.Join(dataDir, "aggregator.key")
+	}
+	if a.IPNSKey == "" {
+		a.IPNSKey = "aggregator"
+	}
+	if a.IPNSKey == "self" {
+		return fmt.Errorf("aggregator.ipns_key must not be the node identity key \"self\"")
+	}
+	if a.StatePath == "" {
+		a.StatePath = filepath.Join(dataDir, "aggregator.json")
+	}
+	return nil
+}
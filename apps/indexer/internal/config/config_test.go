@@ -0,0 +1,164 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/atregu/ipfs-common/configschema"
+	"github.com/atregu/ipfs-common/topic"
+)
+
+// loadYAML writes a configuration file with a repo and database in a temporary directory and loads it
+func loadYAML(t *testing.T, yaml string) (*Config, error) {
+	t.Helper()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := "ipfs:\n  mode: embedded\n  embedded:\n    repo_path: " + filepath.Join(dir, "repo") + "\n" + yaml +
+		"database:\n  type: sqlite\n  path: " + filepath.Join(dir, "indexer.db") + "\n" +
+		"pubsub:\n  topic: " + topic.Default + "\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestBootstrapPeersNormalized(t *testing.T) {
+	const peer = "12D3KooWRBy97UB99e3J6hiPesre1MZeuNQvfan4gBziswrRJsNK"
+
+	cfg, err := loadYAML(t, "    bootstrap_peers:\n"+
+		"      - /dnsaddr/bootstrap.libp2p.io/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN\n"+
+		"      - "+peer+"\n"+
+		"      - /dnsaddr/bootstrap.libp2p.io/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN\n"+
+		"    peer_addresses:\n      "+peer+":\n        - /dns4/indexer.example.org/tcp/4001\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "/dnsaddr/bootstrap.libp2p.io/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN /dns4/indexer.example.org/tcp/4001/p2p/" + peer
+	if got := strings.Join(cfg.IPFS.Embedded.BootstrapPeers, " "); got != want {
+		t.Errorf("bootstrap peers = %q, want %q", got, want)
+	}
+	if warnings := strings.Join(cfg.Warnings(), "\n"); !strings.Contains(warnings, "bootstrap peers") {
+		t.Errorf("Warnings() = %q, want the duplicate bootstrap peer reported", warnings)
+	}
+
+	_, err = loadYAML(t, "    bootstrap_peers:\n      - /dns4/indexer.example.org/tcp/4001\n")
+	if err == nil || !strings.Contains(err.Error(), `entry "/dns4/indexer.example.org/tcp/4001": missing /p2p/`) {
+		t.Errorf("peer without /p2p/: Load = %v, want the entry quoted", err)
+	}
+}
+
+func TestAggregatorDefaults(t *testing.T) {
+	cfg, err := loadYAML(t, "aggregator:\n  enabled: true\n  publishers:\n    - E8WtP2ctD8iOoZ1s95xrU55a4iYaCdlUD+auyMZfPLM=\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := filepath.Dir(cfg.Database.Path)
+	a := cfg.Aggregator
+	if a.IntervalSeconds != 3600 || a.MaxItems != 100000 || a.IPNSKey != "aggregator" ||
+		a.KeyPath != filepath.Join(dir, "aggregator.key") || a.StatePath != filepath.Join(dir, "aggregator.json") {
+		t.Errorf("aggregator = %+v, want the defaults next to the database", a)
+	}
+
+	_, err = loadYAML(t, "aggregator:\n  publishers:\n    - publisher-a\n")
+	if err == nil || !strings.Contains(err.Error(), `entry "publisher-a"`) {
+		t.Errorf("invalid publisher key: Load = %v, want the entry quoted", err)
+	}
+
+	if _, err := loadYAML(t, "aggregator:\n  publishers:\n    - mdn1-3f2a-9c41\n"); err != nil {
+		t.Errorf("fingerprint prefix: Load = %v, want it accepted", err)
+	}
+}
+
+func TestListenerVerification(t *testing.T) {
+	cfg, err := loadYAML(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Pubsub.Listener.VerificationEnabled {
+		t.Error("signature verification disabled by default")
+	}
+	if warnings := strings.Join(cfg.Warnings(), "\n"); strings.Contains(warnings, "verification_enabled") {
+		t.Errorf("Warnings() = %q with verification enabled", warnings)
+	}
+
+	cfg.Pubsub.Listener.VerificationEnabled = false
+	if warnings := strings.Join(cfg.Warnings(), "\n"); !strings.Contains(warnings, "verification_enabled is false") {
+		t.Errorf("Warnings() = %q, want disabled verification reported", warnings)
+	}
+}
+
+func TestFetcherGateways(t *testing.T) {
+	cfg, err := loadYAML(t, "fetcher:\n  gateways:\n    - https://ipfs.io/\n    - http://127.0.0.1:8080\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(cfg.Fetcher.Gateways, " "); got != "https://ipfs.io http://127.0.0.1:8080" {
+		t.Errorf("gateways = %q, want them without trailing slashes", got)
+	}
+	if cfg.Fetcher.GatewayMaxBytes != 64<<20 || cfg.Fetcher.NativeTimeoutSeconds != 120 {
+		t.Errorf("fetcher = %+v, want the gateway defaults", cfg.Fetcher)
+	}
+
+	_, err = loadYAML(t, "fetcher:\n  gateways:\n    - ipfs.io\n")
+	if err == nil || !strings.Contains(err.Error(), `entry "ipfs.io"`) {
+		t.Errorf("gateway without scheme: Load = %v, want the entry quoted", err)
+	}
+}
+
+func TestUnknownKeysFailLoad(t *testing.T) {
+	yaml := "fetcher:\n  retry-attempts: 3\n  concurrent_downloads: 2\napi:\n  listen_adr: \"127.0.0.1:8080\"\n"
+
+	_, err := loadYAML(t, yaml)
+	if err == nil || !strings.Contains(err.Error(), "unknown config keys api.listen_adr, fetcher.retry-attempts;") {
+		t.Errorf("Load = %v, want both unknown keys named", err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := "ipfs:\n  mode: embedded\n  embedded:\n    repo_path: " + filepath.Join(dir, "repo") + "\n" + yaml +
+		"database:\n  type: sqlite\n  path: " + filepath.Join(dir, "indexer.db") + "\n" +
+		"pubsub:\n  topic: " + topic.Default + "\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadWithOptions(path, LoadOptions{IgnoreUnknownKeys: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Fetcher.ConcurrentDownloads != 2 {
+		t.Errorf("fetcher.concurrent_downloads = %d, want 2", cfg.Fetcher.ConcurrentDownloads)
+	}
+	if warnings := strings.Join(cfg.Warnings(), "\n"); !strings.Contains(warnings, "unknown config keys api.listen_adr, fetcher.retry-attempts are ignored") {
+		t.Errorf("Warnings() = %q, want the ignored keys reported", warnings)
+	}
+}
+
+func TestSchemaDefaultsMatchLoad(t *testing.T) {
+	cfg, err := loadYAML(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mismatches := configschema.Mismatches(cfg); len(mismatches) > 0 {
+		t.Errorf("default tags differ from the loaded defaults:\n%s", strings.Join(mismatches, "\n"))
+	}
+
+	fields, err := Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	byKey := make(map[string]configschema.Field)
+	for _, f := range fields {
+		if f.Description == "" {
+			t.Errorf("setting %s has no desc tag", f.Key)
+		}
+		byKey[f.Key] = f
+	}
+	for key, want := range map[string]string{"fetcher.insert_retries": "3", "pubsub.catch_up.enabled": "true", "fetcher.retry_attempts": "10"} {
+		if got := byKey[key].Default; got != want {
+			t.Errorf("schema default of %s = %q, want %q", key, got, want)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"fmt"
+	"path"
+)
+
+// TopicAllowed reports whether topic matches one of the allowlist patterns.
+// Patterns use path.Match syntax (e.g. "mdn/*/announce"). An empty allowlist allows any topic.
+func TopicAllowed(topic string, allowlist []string) (bool, error) {
+	if len(allowlist) == 0 {
+		return true, nil
+	}
+
+	for _, pattern := range allowlist {
+		matched, err := path.Match(pattern, topic)
+		if err != nil {
+			return false, fmt.Errorf("invalid topic allowlist pattern %q: %w", pattern, err)
+		}
+		if matched {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,31 @@
+package config
+
+import "testing"
+
+func TestTopicAllowed(t *testing.T) {
+	allowlist := []string{"mdn/*/announce", "mdn/private/announce"}
+
+	tests := []struct {
+		topic     string
+		allowlist []string
+		allowed   bool
+	}{
+		{"mdn/music/announce", allowlist, true},
+		{"mdn/music/other", allowlist, false},
+		{"anything", nil, true},
+	}
+
+	for _, tt := range tests {
+		allowed, err := TopicAllowed(tt.topic, tt.allowlist)
+		if err != nil {
+			t.Fatalf("TopicAllowed(%q) failed: %v", tt.topic, err)
+		}
+		if allowed != tt.allowed {
+			t.Errorf("TopicAllowed(%q) = %t, want %t", tt.topic, allowed, tt.allowed)
+		}
+	}
+
+	if _, err := TopicAllowed("mdn/music/announce", []string{"mdn/[/announce"}); err == nil {
+		t.Error("expected error for malformed pattern")
+	}
+}
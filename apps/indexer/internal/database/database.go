@@ -1,11 +1,17 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"embed"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"time"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/atregu/ipfs-common/fingerprint"
+	"github.com/mattn/go-sqlite3"
 	"github.com/pressly/goose/v3"
 	"github.com/sirupsen/logrus"
 )
@@ -13,6 +19,9 @@ import (
 //go:embed migrations/*.sql
 var embedMigrations embed.FS
 
+// ErrNotFound is returned when a requested row does not exist
+var ErrNotFound = errors.New("not found")
+
 // DB wraps the database connection
 type DB struct {
 	conn *sql.DB
@@ -47,6 +56,79 @@ func New(dbPath string, log *logrus.Logger) (*DB, error) {
 	return db, nil
 }
 
+// SchemaVersion opens the database read-only and returns the applied and the latest
+// known migration versions without running any migrations
+func SchemaVersion(dbPath string) (current, latest int64, err error) {
+	conn, err := sql.Open("sqlite3", "file:"+dbPath+"?mode=ro")
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer conn.Close()
+
+	if err := conn.Ping(); err != nil {
+		return 0, 0, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	goose.SetBaseFS(embedMigrations)
+	if err := goose.SetDialect("sqlite3"); err != nil {
+		return 0, 0, err
+	}
+
+	migrations, err := goose.CollectMigrations("migrations", 0, goose.MaxVersion)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to collect migrations: %w", err)
+	}
+	last, err := migrations.Last()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to find latest migration: %w", err)
+	}
+
+	// goose.GetDBVersion would create the version table, which fails on a read-only connection
+	current, err = appliedVersion(conn)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+
+	return current, last.Version, nil
+}
+
+// appliedVersion reads the current schema version from goose_db_version the way
+// goose does: the newest applied entry that was not rolled back later. A database
+// without the table has version 0.
+func appliedVersion(conn *sql.DB) (int64, error) {
+	var tables int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'goose_db_version'`).Scan(&tables); err != nil {
+		return 0, err
+	}
+	if tables == 0 {
+		return 0, nil
+	}
+
+	rows, err := conn.Query(`SELECT version_id, is_applied FROM goose_db_version ORDER BY id DESC`)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	rolledBack := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		var applied bool
+		if err := rows.Scan(&version, &applied); err != nil {
+			return 0, err
+		}
+		if rolledBack[version] {
+			continue
+		}
+		if applied {
+			return version, nil
+		}
+		rolledBack[version] = true
+	}
+
+	return 0, rows.Err()
+}
+
 // runMigrations runs all pending migrations
 func (db *DB) runMigrations() error {
 	goose.SetBaseFS(embedMigrations)
@@ -62,11 +144,49 @@ func (db *DB) runMigrations() error {
 	return nil
 }
 
+// IsTransientError reports whether err is a temporary SQLite condition
+// (busy or locked database) that is worth retrying
+func IsTransientError(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+	}
+	return false
+}
+
 // Close closes the database connection
 func (db *DB) Close() error {
 	return db.conn.Close()
 }
 
+// idlePollInterval is how often CloseIdle checks for connections in use
+const idlePollInterval = 10 * time.Millisecond
+
+// CloseIdle waits until no connection is in use, so that open transactions
+// commit or roll back and running queries finish, then closes the database. If
+// ctx is done first, the database is closed anyway and an error is returned.
+func (db *DB) CloseIdle(ctx context.Context) error {
+	ticker := time.NewTicker(idlePollInterval)
+	defer ticker.Stop()
+
+	for db.conn.Stats().InUse > 0 {
+		select {
+		case <-ctx.Done():
+			inUse := db.conn.Stats().InUse
+			if err := db.conn.Close(); err != nil {
+				return fmt.Errorf("failed to close database: %w", err)
+			}
+			return fmt.Errorf("closed with %d connections still in use: %w", inUse, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+
+	if err := db.conn.Close(); err != nil {
+		return fmt.Errorf("failed to close database: %w", err)
+	}
+	return nil
+}
+
 // GetConn returns the underlying database connection
 func (db *DB) GetConn() *sql.DB {
 	return db.conn
@@ -88,26 +208,48 @@ type Publisher struct {
 
 // Collection represents a collection announcement
 type Collection struct {
-	ID          int64
-	HostID      int64
-	PublisherID int64
-	Version     int
-	IPNS        string
-	Size        *int
-	Timestamp   int64
-	Status      string
-	RetryCount  int
-	LastRetryAt *string
-	CreatedAt   string
-	UpdatedAt   string
+	ID            int64
+	HostID        int64
+	PublisherID   int64
+	Version       int
+	IPNS          string
+	Size          *int // Announced item count, replaced by the index size in bytes once fetched
+	AnnouncedSize *int // Item count declared by the announcement, if any
+	Timestamp     int64
+	Status        string
+	RetryCount    int
+	LastRetryAt   *string
+	ItemsStored   int
+	IndexCID      string
+	Visibility    string
+	License       string
+	Mirrors       []string // Secondary IPNS names announced for the same index
+	DeltaCID      string   // Announced delta file against the previous version, if any
+	RootCID       string   // Announced collection root directory, if any
+	ManifestCID   string   // Announced manifest.json inside the root directory, if any
+	ItemsIngested int      // Items stored so far by the parse, committed with each chunk
+	IngestOffset  int      // Index lines read up to the last committed chunk
+	FetchSource   string   // How the index was last downloaded: native or gateway; empty until then
+	TraceParent   string   // Trace context of the receipt of the announcement, empty without tracing
+	CreatedAt     string
+	UpdatedAt     string
 }
 
+// Collection visibility values
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+)
+
 // IndexItem represents a content item in the index
 type IndexItem struct {
 	ID           int64
 	CID          string
 	Filename     string
 	Extension    string
+	Group        string // Parent directory relative to the publisher's root, e.g. "Artist/Album"
+	Endorsed     bool   // The record's claim signature by the publisher key was verified
+	File         ItemFile
 	HostID       int64
 	PublisherID  int64
 	CollectionID int64
@@ -115,6 +257,14 @@ type IndexItem struct {
 	UpdatedAt    string
 }
 
+// ItemFile holds the file properties an index record may carry. Records of
+// older publishers have none; their fields are nil and stored as NULL.
+type ItemFile struct {
+	Size     *int64  // Bytes
+	ModTime  *int64  // Unix time of the last modification
+	MimeType *string // e.g. "audio/mpeg"
+}
+
 // CreateOrGetHost creates a new host or returns existing one
 func (db *DB) CreateOrGetHost(publicKey string) (*Host, error) {
 	var host Host
@@ -193,12 +343,133 @@ func (db *DB) CreateOrGetPublisher(publicKey string) (*Publisher, error) {
 	return &publisher, nil
 }
 
+// AddPublisherKeys records keys (base64) as keys the publisher rotated away.
+// Keys already recorded for it are skipped.
+func (db *DB) AddPublisherKeys(publisherID int64, keys []string) error {
+	for _, key := range keys {
+		if _, err := db.conn.Exec(`
+			INSERT OR IGNORE INTO publisher_keys (publisher_id, public_key) VALUES (?, ?)
+		`, publisherID, key); err != nil {
+			return fmt.Errorf("failed to add publisher key: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetPublisherKeys returns the keys (base64) the publisher with the current
+// key publicKey rotated away, oldest recorded first. An unknown publisher has none.
+func (db *DB) GetPublisherKeys(publicKey string) ([]string, error) {
+	rows, err := db.conn.Query(`
+		SELECT k.public_key
+		FROM publisher_keys k
+		JOIN publishers p ON p.id = k.publisher_id
+		WHERE p.public_key = ?
+		ORDER BY k.added_at ASC, k.rowid ASC
+	`, publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query publisher keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("failed to scan publisher key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// GetPublisher returns the publisher with the given ID
+func (db *DB) GetPublisher(id int64) (*Publisher, error) {
+	var publisher Publisher
+	err := db.conn.QueryRow(`
+		SELECT id, public_key, created_at
+		FROM publishers
+		WHERE id = ?
+	`, id).Scan(&publisher.ID, &publisher.PublicKey, &publisher.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get publisher %d: %w", id, err)
+	}
+	return &publisher, nil
+}
+
+// ListPublishers returns every publisher ordered by ID
+func (db *DB) ListPublishers() ([]*Publisher, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, public_key, created_at
+		FROM publishers
+		ORDER BY id ASC
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query publishers: %w", err)
+	}
+	defer rows.Close()
+
+	var publishers []*Publisher
+	for rows.Next() {
+		var p Publisher
+		if err := rows.Scan(&p.ID, &p.PublicKey, &p.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan publisher: %w", err)
+		}
+		publishers = append(publishers, &p)
+	}
+
+	return publishers, rows.Err()
+}
+
+// FindPublisher returns the publisher that query names: its full public key in
+// base64 or hex, or its fingerprint or an unambiguous prefix of it. A prefix
+// matching several publishers returns a *fingerprint.AmbiguousError listing
+// them, and an unknown publisher an error wrapping fingerprint.ErrNotFound.
+func (db *DB) FindPublisher(query string) (*Publisher, error) {
+	publishers, err := db.ListPublishers()
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, len(publishers))
+	byKey := make(map[string]*Publisher, len(publishers))
+	for i, p := range publishers {
+		keys[i] = p.PublicKey
+		byKey[p.PublicKey] = p
+	}
+	key, err := fingerprint.Resolve(query, keys)
+	if err != nil {
+		return nil, err
+	}
+	return byKey[key], nil
+}
+
+// GetIPNSPublisher returns the publisher that most recently announced a
+// collection under ipns, or nil if none did
+func (db *DB) GetIPNSPublisher(ipns string) (*Publisher, error) {
+	var publisher Publisher
+	err := db.conn.QueryRow(`
+		SELECT p.id, p.public_key, p.created_at
+		FROM collections c
+		JOIN publishers p ON p.id = c.publisher_id
+		WHERE c.ipns = ?
+		ORDER BY c.id DESC
+		LIMIT 1
+	`, ipns).Scan(&publisher.ID, &publisher.PublicKey, &publisher.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get publisher of IPNS %s: %w", ipns, err)
+	}
+	return &publisher, nil
+}
+
 // CreateCollection creates a new collection
 func (db *DB) CreateCollection(hostID, publisherID int64, version int, ipns string, size *int, timestamp int64) (*Collection, error) {
 	result, err := db.conn.Exec(`
-		INSERT INTO collections (host_id, publisher_id, version, ipns, size, timestamp, status)
-		VALUES (?, ?, ?, ?, ?, ?, 'pending')
-	`, hostID, publisherID, version, ipns, size, timestamp)
+		INSERT INTO collections (host_id, publisher_id, version, ipns, size, announced_size, timestamp, status)
+		VALUES (?, ?, ?, ?, ?, ?, ?, 'pending')
+	`, hostID, publisherID, version, ipns, size, size, timestamp)
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to insert collection: %w", err)
@@ -210,25 +481,70 @@ func (db *DB) CreateCollection(hostID, publisherID int64, version int, ipns stri
 	}
 
 	return &Collection{
-		ID:          id,
-		HostID:      hostID,
-		PublisherID: publisherID,
-		Version:     version,
-		IPNS:        ipns,
-		Size:        size,
-		Timestamp:   timestamp,
-		Status:      "pending",
+		ID:            id,
+		HostID:        hostID,
+		PublisherID:   publisherID,
+		Version:       version,
+		IPNS:          ipns,
+		Size:          size,
+		AnnouncedSize: size,
+		Timestamp:     timestamp,
+		Status:        "pending",
 	}, nil
 }
 
-// GetPendingCollections returns all collections with pending status and retry count < max
-func (db *DB) GetPendingCollections(maxRetries int) ([]*Collection, error) {
+// collectionColumns is the column list matching scanCollection
+const collectionColumns = `id, host_id, publisher_id, version, ipns, size, timestamp, status, retry_count, last_retry_at,
+		COALESCE(items_stored, 0), COALESCE(index_cid, ''), visibility, license, mirrors, delta_cid, root_cid,
+		items_ingested, ingest_offset, announced_size, fetch_source, trace_parent, manifest_cid, created_at, updated_at`
+
+// rowScanner is implemented by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanCollection scans a row selected with collectionColumns
+func scanCollection(row rowScanner) (*Collection, error) {
+	var c Collection
+	var mirrors string
+	err := row.Scan(&c.ID, &c.HostID, &c.PublisherID, &c.Version, &c.IPNS, &c.Size, &c.Timestamp, &c.Status,
+		&c.RetryCount, &c.LastRetryAt, &c.ItemsStored, &c.IndexCID, &c.Visibility, &c.License, &mirrors, &c.DeltaCID, &c.RootCID,
+		&c.ItemsIngested, &c.IngestOffset, &c.AnnouncedSize, &c.FetchSource, &c.TraceParent, &c.ManifestCID, &c.CreatedAt, &c.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if mirrors != "" {
+		if err := json.Unmarshal([]byte(mirrors), &c.Mirrors); err != nil {
+			return nil, fmt.Errorf("failed to decode mirrors of collection %d: %w", c.ID, err)
+		}
+	}
+	return &c, nil
+}
+
+// GetCollection returns a collection by ID
+func (db *DB) GetCollection(id int64) (*Collection, error) {
+	row := db.conn.QueryRow(`SELECT `+collectionColumns+` FROM collections WHERE id = ?`, id)
+
+	c, err := scanCollection(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("collection %d %w", id, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query collection: %w", err)
+	}
+
+	return c, nil
+}
+
+// GetPendingCollections returns all pending (or stale or incomplete) collections
+// with retry count < max that were not retried after retryBefore
+func (db *DB) GetPendingCollections(maxRetries int, retryBefore time.Time) ([]*Collection, error) {
 	rows, err := db.conn.Query(`
-		SELECT id, host_id, publisher_id, version, ipns, size, timestamp, status, retry_count, last_retry_at, created_at, updated_at
+		SELECT `+collectionColumns+`
 		FROM collections
-		WHERE status = 'pending' AND retry_count < ?
+		WHERE status IN ('pending', 'stale', 'incomplete') AND retry_count < ? AND (last_retry_at IS NULL OR last_retry_at <= ?)
 		ORDER BY created_at ASC
-	`, maxRetries)
+	`, maxRetries, retryBefore.UTC().Format(time.DateTime))
 
 	if err != nil {
 		return nil, fmt.Errorf("failed to query pending collections: %w", err)
@@ -237,80 +553,1071 @@ func (db *DB) GetPendingCollections(maxRetries int) ([]*Collection, error) {
 
 	var collections []*Collection
 	for rows.Next() {
-		var c Collection
-		err := rows.Scan(&c.ID, &c.HostID, &c.PublisherID, &c.Version, &c.IPNS, &c.Size, &c.Timestamp, &c.Status, &c.RetryCount, &c.LastRetryAt, &c.CreatedAt, &c.UpdatedAt)
+		c, err := scanCollection(rows)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan collection: %w", err)
 		}
-		collections = append(collections, &c)
+		collections = append(collections, c)
 	}
 
 	return collections, nil
 }
 
-// UpdateCollectionStatus updates the status of a collection
-func (db *DB) UpdateCollectionStatus(id int64, status string, size *int) error {
+// SetCollectionIndexCID records the resolved index CID of a collection
+func (db *DB) SetCollectionIndexCID(id int64, cid string) error {
 	_, err := db.conn.Exec(`
 		UPDATE collections 
-		SET status = ?, size = ?, updated_at = CURRENT_TIMESTAMP
+		SET index_cid = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
-	`, status, size, id)
+	`, cid, id)
 
 	if err != nil {
-		return fmt.Errorf("failed to update collection status: %w", err)
+		return fmt.Errorf("failed to update collection index CID: %w", err)
 	}
 
 	return nil
 }
 
-// IncrementRetryCount increments the retry count for a collection
-func (db *DB) IncrementRetryCount(id int64) error {
+// SetCollectionTraceParent records the trace context the fetch of a collection
+// continues
+func (db *DB) SetCollectionTraceParent(id int64, traceParent string) error {
 	_, err := db.conn.Exec(`
-		UPDATE collections 
-		SET retry_count = retry_count + 1, last_retry_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		UPDATE collections
+		SET trace_parent = ?, updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
-	`, id)
+	`, traceParent, id)
 
 	if err != nil {
-		return fmt.Errorf("failed to increment retry count: %w", err)
+		return fmt.Errorf("failed to update collection trace parent: %w", err)
 	}
 
 	return nil
 }
 
-// CreateOrUpdateIndexItem creates or updates an index item
-func (db *DB) CreateOrUpdateIndexItem(cid, filename, extension string, hostID, publisherID, collectionID int64) error {
-	// Check if item exists
-	var existingID int64
-	err := db.conn.QueryRow(`
-		SELECT id FROM index_items 
-		WHERE cid = ? AND collection_id = ?
-	`, cid, collectionID).Scan(&existingID)
+// SetCollectionFetchSource records how the index of a collection was downloaded
+func (db *DB) SetCollectionFetchSource(id int64, source string) error {
+	_, err := db.conn.Exec(`
+		UPDATE collections
+		SET fetch_source = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, source, id)
 
-	if err == sql.ErrNoRows {
-		// Create new item
-		_, err := db.conn.Exec(`
-			INSERT INTO index_items (cid, filename, extension, host_id, publisher_id, collection_id)
-			VALUES (?, ?, ?, ?, ?, ?)
-		`, cid, filename, extension, hostID, publisherID, collectionID)
+	if err != nil {
+		return fmt.Errorf("failed to update collection fetch source: %w", err)
+	}
+
+	return nil
+}
+
+// SetCollectionAnnouncedCIDs records the root directory and index file CIDs
+// carried in the announcement of a collection
+func (db *DB) SetCollectionAnnouncedCIDs(id int64, rootCID, indexCID string) error {
+	_, err := db.conn.Exec(`
+		UPDATE collections
+		SET root_cid = ?, index_cid = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, rootCID, indexCID, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to update collection announced CIDs: %w", err)
+	}
+
+	return nil
+}
+
+// SetCollectionMeta sets visibility and license on every version of a publisher's
+// collection, so a change announced in a new version also applies to existing items.
+// An empty license leaves the stored license unchanged, and so does any license
+// when the collection's stored manifest declares one.
+func (db *DB) SetCollectionMeta(publisherID int64, ipns, visibility, license string) error {
+	_, err := db.conn.Exec(`
+		UPDATE collections
+		SET visibility = ?, license = CASE
+			WHEN ? = '' OR EXISTS (SELECT 1 FROM collection_manifests m
+				WHERE m.publisher_id = collections.publisher_id AND m.ipns = collections.ipns AND m.license != '')
+			THEN license ELSE ? END,
+			updated_at = CURRENT_TIMESTAMP
+		WHERE publisher_id = ? AND ipns = ?
+	`, visibility, license, license, publisherID, ipns)
+
+	if err != nil {
+		return fmt.Errorf("failed to update collection metadata: %w", err)
+	}
+
+	return nil
+}
+
+// SetCollectionManifestCID records the manifest CID announced for a collection
+func (db *DB) SetCollectionManifestCID(id int64, cid string) error {
+	_, err := db.conn.Exec(`
+		UPDATE collections
+		SET manifest_cid = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, cid, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to update collection manifest CID: %w", err)
+	}
+
+	return nil
+}
+
+// CollectionManifest is the verified manifest last fetched for a publisher's collection
+type CollectionManifest struct {
+	PublisherID       int64
+	IPNS              string
+	CID               string
+	Name              string
+	Description       string
+	Topic             string
+	HeartbeatInterval int // Seconds between periodic announcements, 0 = none
+	License           string
+	Contact           string
+	FetchedAt         string
+}
 
+// SetCollectionManifest stores the manifest of a publisher's collection, replacing
+// the one fetched before. A license it declares applies to every version of the
+// collection, ahead of the license of announcements and index headers.
+func (db *DB) SetCollectionManifest(m *CollectionManifest) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO collection_manifests (publisher_id, ipns, cid, name, description, topic, heartbeat_interval, license, contact, fetched_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)
+		ON CONFLICT (publisher_id, ipns) DO UPDATE SET
+			cid = excluded.cid, name = excluded.name, description = excluded.description, topic = excluded.topic,
+			heartbeat_interval = excluded.heartbeat_interval, license = excluded.license, contact = excluded.contact,
+			fetched_at = excluded.fetched_at
+	`, m.PublisherID, m.IPNS, m.CID, m.Name, m.Description, m.Topic, m.HeartbeatInterval, m.License, m.Contact)
+	if err != nil {
+		return fmt.Errorf("failed to store collection manifest: %w", err)
+	}
+
+	if m.License != "" {
+		_, err = tx.Exec(`
+			UPDATE collections
+			SET license = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE publisher_id = ? AND ipns = ?
+		`, m.License, m.PublisherID, m.IPNS)
 		if err != nil {
-			return fmt.Errorf("failed to insert index item: %w", err)
+			return fmt.Errorf("failed to apply manifest license: %w", err)
 		}
-	} else if err != nil {
-		return fmt.Errorf("failed to query index item: %w", err)
-	} else {
-		// Update existing item
-		_, err := db.conn.Exec(`
-			UPDATE index_items 
-			SET filename = ?, extension = ?, updated_at = CURRENT_TIMESTAMP
-			WHERE id = ?
-		`, filename, extension, existingID)
+	}
 
-		if err != nil {
-			return fmt.Errorf("failed to update index item: %w", err)
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit collection manifest: %w", err)
+	}
+	return nil
+}
+
+// GetCollectionManifest returns the stored manifest of a publisher's collection
+func (db *DB) GetCollectionManifest(publisherID int64, ipns string) (*CollectionManifest, error) {
+	m := CollectionManifest{PublisherID: publisherID, IPNS: ipns}
+	err := db.conn.QueryRow(`
+		SELECT cid, name, description, topic, heartbeat_interval, license, contact, fetched_at
+		FROM collection_manifests
+		WHERE publisher_id = ? AND ipns = ?
+	`, publisherID, ipns).Scan(&m.CID, &m.Name, &m.Description, &m.Topic, &m.HeartbeatInterval, &m.License, &m.Contact, &m.FetchedAt)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("manifest of collection %s %w", ipns, ErrNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query collection manifest: %w", err)
+	}
+	return &m, nil
+}
+
+// ListCollectionManifests returns the stored manifests of a publisher's
+// collections, or of every publisher's if publisherID is 0
+func (db *DB) ListCollectionManifests(publisherID int64) ([]*CollectionManifest, error) {
+	rows, err := db.conn.Query(`
+		SELECT publisher_id, ipns, cid, name, description, topic, heartbeat_interval, license, contact, fetched_at
+		FROM collection_manifests
+		WHERE ? = 0 OR publisher_id = ?
+	`, publisherID, publisherID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query collection manifests: %w", err)
+	}
+	defer rows.Close()
+
+	var manifests []*CollectionManifest
+	for rows.Next() {
+		var m CollectionManifest
+		if err := rows.Scan(&m.PublisherID, &m.IPNS, &m.CID, &m.Name, &m.Description, &m.Topic,
+			&m.HeartbeatInterval, &m.License, &m.Contact, &m.FetchedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan collection manifest: %w", err)
 		}
+		manifests = append(manifests, &m)
+	}
+	return manifests, rows.Err()
+}
+
+// SetCollectionMirrors records the mirror IPNS names announced for a collection
+func (db *DB) SetCollectionMirrors(id int64, mirrors []string) error {
+	data, err := json.Marshal(mirrors)
+	if err != nil {
+		return fmt.Errorf("failed to encode collection mirrors: %w", err)
+	}
+
+	_, err = db.conn.Exec(`
+		UPDATE collections
+		SET mirrors = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, string(data), id)
+
+	if err != nil {
+		return fmt.Errorf("failed to update collection mirrors: %w", err)
+	}
+
+	return nil
+}
+
+// SetCollectionDeltaCID records the delta file CID announced for a collection
+func (db *DB) SetCollectionDeltaCID(id int64, cid string) error {
+	_, err := db.conn.Exec(`
+		UPDATE collections
+		SET delta_cid = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, cid, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to update collection delta CID: %w", err)
+	}
+
+	return nil
+}
+
+// SetCollectionMessage records the PubSub message a collection was announced in:
+// its gossipsub message ID and the SHA-256 of its data, both hex encoded
+func (db *DB) SetCollectionMessage(id int64, messageID, hash string) error {
+	_, err := db.conn.Exec(`
+		UPDATE collections
+		SET message_id = ?, message_hash = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, messageID, hash, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to update collection message: %w", err)
 	}
 
 	return nil
 }
+
+// ReceivedAnnouncement is a stored announcement with the PubSub message it came in
+type ReceivedAnnouncement struct {
+	CollectionID int64
+	PublisherKey string
+	IPNS         string
+	Version      int
+	ReceivedFrom string // Peer the message was received from, the collection's host
+	MessageID    string
+	MessageHash  string
+	ReceivedAt   string
+}
+
+// GetRecentAnnouncements returns the last limit stored announcements, newest first
+func (db *DB) GetRecentAnnouncements(limit int) ([]*ReceivedAnnouncement, error) {
+	rows, err := db.conn.Query(`
+		SELECT c.id, p.public_key, c.ipns, c.version, h.public_key, c.message_id, c.message_hash, c.created_at
+		FROM collections c
+		JOIN publishers p ON p.id = c.publisher_id
+		JOIN hosts h ON h.id = c.host_id
+		ORDER BY c.id DESC
+		LIMIT ?
+	`, limit)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recent announcements: %w", err)
+	}
+	defer rows.Close()
+
+	var announcements []*ReceivedAnnouncement
+	for rows.Next() {
+		var a ReceivedAnnouncement
+		if err := rows.Scan(&a.CollectionID, &a.PublisherKey, &a.IPNS, &a.Version, &a.ReceivedFrom,
+			&a.MessageID, &a.MessageHash, &a.ReceivedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan announcement: %w", err)
+		}
+		announcements = append(announcements, &a)
+	}
+
+	return announcements, rows.Err()
+}
+
+// GetDownloadedVersion returns the fully downloaded version of a publisher's
+// collection whose index CID matches, or nil if the indexer does not have it
+func (db *DB) GetDownloadedVersion(publisherID int64, ipns string, version int, indexCID string) (*Collection, error) {
+	row := db.conn.QueryRow(`
+		SELECT `+collectionColumns+`
+		FROM collections
+		WHERE publisher_id = ? AND ipns = ? AND version = ? AND index_cid = ? AND status = 'downloaded'
+		ORDER BY id DESC
+		LIMIT 1
+	`, publisherID, ipns, version, indexCID)
+
+	c, err := scanCollection(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query collection version: %w", err)
+	}
+
+	return c, nil
+}
+
+// GetLatestDownloadedVersion returns the highest downloaded (or truncated)
+// version of a collection with a recorded index CID, or nil if there is none
+func (db *DB) GetLatestDownloadedVersion(publisherID int64, ipns string) (*Collection, error) {
+	row := db.conn.QueryRow(`
+		SELECT `+collectionColumns+`
+		FROM collections
+		WHERE publisher_id = ? AND ipns = ? AND status IN ('downloaded', 'truncated') AND index_cid != ''
+		ORDER BY version DESC, id DESC
+		LIMIT 1
+	`, publisherID, ipns)
+
+	c, err := scanCollection(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query latest downloaded version: %w", err)
+	}
+
+	return c, nil
+}
+
+// CountCollectionsByStatus returns the number of collection versions in each status
+func (db *DB) CountCollectionsByStatus() (map[string]int, error) {
+	rows, err := db.conn.Query(`SELECT status, COUNT(*) FROM collections GROUP BY status`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count collections: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan collection count: %w", err)
+		}
+		counts[status] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// GetLatestCollectionVersion returns the highest version recorded for a
+// publisher's collection, or 0 if the indexer has never seen it
+func (db *DB) GetLatestCollectionVersion(publisherID int64, ipns string) (int, error) {
+	var version int
+	err := db.conn.QueryRow(`
+		SELECT COALESCE(MAX(version), 0) FROM collections WHERE publisher_id = ? AND ipns = ?
+	`, publisherID, ipns).Scan(&version)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to query latest collection version: %w", err)
+	}
+
+	return version, nil
+}
+
+// UpdateCollectionStatus updates the status of a collection
+func (db *DB) UpdateCollectionStatus(id int64, status string, size *int) error {
+	_, err := db.conn.Exec(`
+		UPDATE collections 
+		SET status = ?, size = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, status, size, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to update collection status: %w", err)
+	}
+
+	return nil
+}
+
+// IncrementRetryCount increments the retry count for a collection
+func (db *DB) IncrementRetryCount(id int64) error {
+	_, err := db.conn.Exec(`
+		UPDATE collections 
+		SET retry_count = retry_count + 1, last_retry_at = CURRENT_TIMESTAMP, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to increment retry count: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateCollectionItemsStored records how many index items were stored for a collection
+func (db *DB) UpdateCollectionItemsStored(id int64, count int) error {
+	_, err := db.conn.Exec(`
+		UPDATE collections 
+		SET items_stored = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, count, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to update collection items count: %w", err)
+	}
+
+	return nil
+}
+
+// SetCollectionProgress records the parse progress of a collection: the items
+// stored so far and the number of index lines read to store them
+func (db *DB) SetCollectionProgress(id int64, items, offset int) error {
+	return setCollectionProgress(db.conn, id, items, offset)
+}
+
+// setCollectionProgress records the parse progress of a collection using conn
+func setCollectionProgress(conn execQuerier, id int64, items, offset int) error {
+	_, err := conn.Exec(`
+		UPDATE collections 
+		SET items_ingested = ?, ingest_offset = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, items, offset, id)
+
+	if err != nil {
+		return fmt.Errorf("failed to update collection progress: %w", err)
+	}
+
+	return nil
+}
+
+// CountPublisherItems returns the total number of index items stored for a publisher
+func (db *DB) CountPublisherItems(publisherID int64) (int, error) {
+	var count int
+	err := db.conn.QueryRow(`
+		SELECT COUNT(*) FROM index_items WHERE publisher_id = ?
+	`, publisherID).Scan(&count)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to count publisher items: %w", err)
+	}
+
+	return count, nil
+}
+
+// IncrementPublisherRefusals increments the number of collections refused for a publisher
+func (db *DB) IncrementPublisherRefusals(publisherID int64) error {
+	_, err := db.conn.Exec(`
+		UPDATE publishers 
+		SET refused_collections = refused_collections + 1
+		WHERE id = ?
+	`, publisherID)
+
+	if err != nil {
+		return fmt.Errorf("failed to increment publisher refusals: %w", err)
+	}
+
+	return nil
+}
+
+// PublisherUsage summarizes the storage used by a publisher
+type PublisherUsage struct {
+	PublisherID        int64
+	PublicKey          string
+	Collections        int
+	Items              int
+	RefusedCollections int
+}
+
+// TouchPublisher records that a valid announcement of the publisher was heard now
+func (db *DB) TouchPublisher(publisherID int64) error {
+	_, err := db.conn.Exec(`
+		UPDATE publishers
+		SET last_heard_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, publisherID)
+
+	if err != nil {
+		return fmt.Errorf("failed to record publisher heard: %w", err)
+	}
+
+	return nil
+}
+
+// CountPublishersHeardSince returns the number of distinct publishers whose
+// announcements were heard at or after since
+func (db *DB) CountPublishersHeardSince(since time.Time) (int, error) {
+	var count int
+	err := db.conn.QueryRow(`
+		SELECT COUNT(*) FROM publishers WHERE last_heard_at >= ?
+	`, since.UTC().Format(time.DateTime)).Scan(&count)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to count publishers heard: %w", err)
+	}
+
+	return count, nil
+}
+
+// GetPublisherUsage returns per-publisher collection and item counts
+func (db *DB) GetPublisherUsage() ([]*PublisherUsage, error) {
+	rows, err := db.conn.Query(`
+		SELECT p.id, p.public_key,
+			(SELECT COUNT(*) FROM collections c WHERE c.publisher_id = p.id),
+			(SELECT COUNT(*) FROM index_items i WHERE i.publisher_id = p.id),
+			COALESCE(p.refused_collections, 0)
+		FROM publishers p
+		ORDER BY p.id ASC
+	`)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to query publisher usage: %w", err)
+	}
+	defer rows.Close()
+
+	var usage []*PublisherUsage
+	for rows.Next() {
+		var u PublisherUsage
+		if err := rows.Scan(&u.PublisherID, &u.PublicKey, &u.Collections, &u.Items, &u.RefusedCollections); err != nil {
+			return nil, fmt.Errorf("failed to scan publisher usage: %w", err)
+		}
+		usage = append(usage, &u)
+	}
+
+	return usage, rows.Err()
+}
+
+// Page selects a slice of an ordered result: Limit rows after skipping Offset.
+// The zero Page selects every row.
+type Page struct {
+	Limit  int
+	Offset int
+}
+
+// limit returns the LIMIT and OFFSET arguments of p; SQLite reads a negative
+// limit as none
+func (p Page) limit() (int, int) {
+	if p.Limit <= 0 {
+		return -1, p.Offset
+	}
+	return p.Limit, p.Offset
+}
+
+// ListCollections returns the latest version of every collection, of one publisher
+// if publisherID is non-zero, ordered by ID. Unlisted collections are left out
+// unless includeUnlisted is set.
+func (db *DB) ListCollections(publisherID int64, includeUnlisted bool) ([]*Collection, error) {
+	return db.ListCollectionsPage(publisherID, includeUnlisted, Page{})
+}
+
+// ListCollectionsPage returns one page of the collections of ListCollections
+func (db *DB) ListCollectionsPage(publisherID int64, includeUnlisted bool, page Page) ([]*Collection, error) {
+	limit, offset := page.limit()
+	rows, err := db.conn.Query(`
+		SELECT `+collectionColumns+`
+		FROM collections c
+		WHERE (? = 0 OR publisher_id = ?) AND (visibility != ? OR ?)
+			AND version = (SELECT MAX(version) FROM collections l WHERE l.publisher_id = c.publisher_id AND l.ipns = c.ipns)
+		ORDER BY id ASC
+		LIMIT ? OFFSET ?
+	`, publisherID, publisherID, VisibilityUnlisted, includeUnlisted, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query collections: %w", err)
+	}
+	defer rows.Close()
+
+	var collections []*Collection
+	for rows.Next() {
+		c, err := scanCollection(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan collection: %w", err)
+		}
+		collections = append(collections, c)
+	}
+
+	return collections, rows.Err()
+}
+
+// ListCollectionsByStatus returns every collection version in one of the given
+// statuses, or every version if none is given, ordered by ID
+func (db *DB) ListCollectionsByStatus(statuses ...string) ([]*Collection, error) {
+	query := `SELECT ` + collectionColumns + ` FROM collections`
+	args := make([]interface{}, len(statuses))
+	if len(statuses) > 0 {
+		query += ` WHERE status IN (?` + strings.Repeat(`, ?`, len(statuses)-1) + `)`
+		for i, status := range statuses {
+			args[i] = status
+		}
+	}
+	query += ` ORDER BY id ASC`
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query collections: %w", err)
+	}
+	defer rows.Close()
+
+	var collections []*Collection
+	for rows.Next() {
+		c, err := scanCollection(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan collection: %w", err)
+		}
+		collections = append(collections, c)
+	}
+
+	return collections, rows.Err()
+}
+
+// Activity is one entry of the recent-activity feed
+type Activity struct {
+	CollectionID int64
+	PublisherID  int64
+	IPNS         string
+	Version      int
+	Status       string
+	ItemsStored  int
+	Visibility   string
+	UpdatedAt    string
+}
+
+// GetRecentActivity returns the most recently updated collections, newest first.
+// Unlisted collections are left out unless includeUnlisted is set.
+func (db *DB) GetRecentActivity(limit int, includeUnlisted bool) ([]*Activity, error) {
+	rows, err := db.conn.Query(`
+		SELECT id, publisher_id, ipns, version, status, COALESCE(items_stored, 0), visibility, updated_at
+		FROM collections
+		WHERE visibility != ? OR ?
+		ORDER BY updated_at DESC, id DESC
+		LIMIT ?
+	`, VisibilityUnlisted, includeUnlisted, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recent activity: %w", err)
+	}
+	defer rows.Close()
+
+	var activity []*Activity
+	for rows.Next() {
+		var a Activity
+		if err := rows.Scan(&a.CollectionID, &a.PublisherID, &a.IPNS, &a.Version, &a.Status, &a.ItemsStored, &a.Visibility, &a.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan activity: %w", err)
+		}
+		activity = append(activity, &a)
+	}
+
+	return activity, rows.Err()
+}
+
+// execQuerier is implemented by *sql.DB and *sql.Tx
+type execQuerier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// Tx is a transaction for storing a batch of index items
+type Tx struct {
+	tx *sql.Tx
+}
+
+// BeginTx starts a transaction
+func (db *DB) BeginTx() (*Tx, error) {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	return &Tx{tx: tx}, nil
+}
+
+// Commit commits the transaction
+func (t *Tx) Commit() error {
+	if err := t.tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// Rollback aborts the transaction
+func (t *Tx) Rollback() error {
+	return t.tx.Rollback()
+}
+
+// SetCollectionProgress records the parse progress of a collection within the
+// transaction, so it is committed together with the items it counts
+func (t *Tx) SetCollectionProgress(id int64, items, offset int) error {
+	return setCollectionProgress(t.tx, id, items, offset)
+}
+
+// CreateOrUpdateIndexItem creates or updates an index item within the transaction
+func (t *Tx) CreateOrUpdateIndexItem(cid, filename, extension, group string, file ItemFile, endorsed bool, hostID, publisherID, collectionID int64) error {
+	return createOrUpdateIndexItem(t.tx, cid, filename, extension, group, file, endorsed, hostID, publisherID, collectionID)
+}
+
+// CreateOrUpdateIndexItem creates or updates an index item
+func (db *DB) CreateOrUpdateIndexItem(cid, filename, extension, group string, file ItemFile, endorsed bool, hostID, publisherID, collectionID int64) error {
+	return createOrUpdateIndexItem(db.conn, cid, filename, extension, group, file, endorsed, hostID, publisherID, collectionID)
+}
+
+// createOrUpdateIndexItem creates or updates an index item using conn
+func createOrUpdateIndexItem(conn execQuerier, cid, filename, extension, group string, file ItemFile, endorsed bool, hostID, publisherID, collectionID int64) error {
+	// Check if item exists
+	var existingID int64
+	err := conn.QueryRow(`
+		SELECT id FROM index_items 
+		WHERE cid = ? AND collection_id = ?
+	`, cid, collectionID).Scan(&existingID)
+
+	if err == sql.ErrNoRows {
+		// Create new item
+		_, err := conn.Exec(`
+			INSERT INTO index_items (cid, filename, extension, group_name, size, mtime, mime_type, endorsed, host_id, publisher_id, collection_id)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, cid, filename, extension, group, file.Size, file.ModTime, file.MimeType, endorsed, hostID, publisherID, collectionID)
+
+		if err != nil {
+			return fmt.Errorf("failed to insert index item: %w", err)
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to query index item: %w", err)
+	} else {
+		// Update existing item
+		_, err := conn.Exec(`
+			UPDATE index_items 
+			SET filename = ?, extension = ?, group_name = ?, size = ?, mtime = ?, mime_type = ?, endorsed = ?, updated_at = CURRENT_TIMESTAMP
+			WHERE id = ?
+		`, filename, extension, group, file.Size, file.ModTime, file.MimeType, endorsed, existingID)
+
+		if err != nil {
+			return fmt.Errorf("failed to update index item: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// CopyCollectionItems copies the index items of one collection into another,
+// replacing any items the target already has
+func (db *DB) CopyCollectionItems(fromID, toID int64) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM index_items WHERE collection_id = ?`, toID); err != nil {
+		return fmt.Errorf("failed to clear index items: %w", err)
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO index_items (cid, filename, extension, group_name, size, mtime, mime_type, endorsed, host_id, publisher_id, collection_id)
+		SELECT i.cid, i.filename, i.extension, i.group_name, i.size, i.mtime, i.mime_type, i.endorsed, c.host_id, c.publisher_id, c.id
+		FROM index_items i, collections c
+		WHERE i.collection_id = ? AND c.id = ?
+	`, fromID, toID); err != nil {
+		return fmt.Errorf("failed to copy index items: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit index item copy: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteIndexItem removes an item from a collection
+func (db *DB) DeleteIndexItem(collectionID int64, cid string) error {
+	_, err := db.conn.Exec(`
+		DELETE FROM index_items WHERE collection_id = ? AND cid = ?
+	`, collectionID, cid)
+
+	if err != nil {
+		return fmt.Errorf("failed to delete index item: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteCollectionItems removes all items of a collection
+func (db *DB) DeleteCollectionItems(collectionID int64) error {
+	_, err := db.conn.Exec(`DELETE FROM index_items WHERE collection_id = ?`, collectionID)
+	if err != nil {
+		return fmt.Errorf("failed to delete collection items: %w", err)
+	}
+
+	return nil
+}
+
+// CountCollectionItems returns the number of items stored for a collection
+func (db *DB) CountCollectionItems(collectionID int64) (int, error) {
+	var count int
+	err := db.conn.QueryRow(`
+		SELECT COUNT(*) FROM index_items WHERE collection_id = ?
+	`, collectionID).Scan(&count)
+
+	if err != nil {
+		return 0, fmt.Errorf("failed to count collection items: %w", err)
+	}
+
+	return count, nil
+}
+
+// GroupCount is the number of items in one directory group of a collection
+type GroupCount struct {
+	Group string
+	Items int
+}
+
+// GetCollectionGroups returns the directory groups of a collection with their item counts.
+// Items without a group are reported under the empty group.
+func (db *DB) GetCollectionGroups(collectionID int64) ([]*GroupCount, error) {
+	rows, err := db.conn.Query(`
+		SELECT group_name, COUNT(*) FROM index_items
+		WHERE collection_id = ?
+		GROUP BY group_name
+		ORDER BY group_name
+	`, collectionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query collection groups: %w", err)
+	}
+	defer rows.Close()
+
+	var groups []*GroupCount
+	for rows.Next() {
+		var g GroupCount
+		if err := rows.Scan(&g.Group, &g.Items); err != nil {
+			return nil, fmt.Errorf("failed to scan collection group: %w", err)
+		}
+		groups = append(groups, &g)
+	}
+
+	return groups, rows.Err()
+}
+
+// GetCollectionItems returns the items of a collection. If group is non-nil, only
+// items in that group are returned; nested groups (e.g. "Artist/Album/Disc 1" for
+// group "Artist/Album") are included when recursive is true.
+func (db *DB) GetCollectionItems(collectionID int64, group *string, recursive bool) ([]*IndexItem, error) {
+	query := `
+		SELECT id, cid, filename, extension, group_name, size, mtime, mime_type, endorsed, host_id, publisher_id, collection_id, created_at, updated_at
+		FROM index_items
+		WHERE collection_id = ?`
+	args := []interface{}{collectionID}
+
+	if group != nil {
+		if recursive && *group != "" {
+			query += ` AND (group_name = ? OR group_name LIKE ? ESCAPE '\')`
+			args = append(args, *group, escapeLike(*group)+"/%")
+		} else if !recursive {
+			query += ` AND group_name = ?`
+			args = append(args, *group)
+		}
+	}
+	query += ` ORDER BY group_name, filename`
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query collection items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []*IndexItem
+	for rows.Next() {
+		var item IndexItem
+		if err := rows.Scan(&item.ID, &item.CID, &item.Filename, &item.Extension, &item.Group,
+			&item.File.Size, &item.File.ModTime, &item.File.MimeType, &item.Endorsed, &item.HostID, &item.PublisherID, &item.CollectionID, &item.CreatedAt, &item.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan collection item: %w", err)
+		}
+		items = append(items, &item)
+	}
+
+	return items, rows.Err()
+}
+
+// CatalogItem is an item of the merged catalog with the key of its publisher
+type CatalogItem struct {
+	CID          string
+	Filename     string
+	Extension    string
+	Group        string
+	PublisherKey string // Public key of the publisher whose collection holds the item
+}
+
+// GetCatalogItems returns the items of the latest downloaded (or truncated)
+// version of every public collection, of the publishers in publisherKeys if it
+// is not empty, except those of excludeKey. Items are ordered by publisher,
+// collection, group and filename; an item whose CID appeared before is left out.
+// At most limit items are returned and truncated reports whether more exist.
+func (db *DB) GetCatalogItems(publisherKeys []string, excludeKey string, limit int) (items []*CatalogItem, truncated bool, err error) {
+	query := `
+		SELECT i.cid, i.filename, i.extension, i.group_name, p.public_key
+		FROM index_items i
+		JOIN collections c ON c.id = i.collection_id
+		JOIN publishers p ON p.id = c.publisher_id
+		WHERE c.status IN ('downloaded', 'truncated') AND c.visibility = ? AND p.public_key != ?
+			AND c.version = (SELECT MAX(version) FROM collections l
+				WHERE l.publisher_id = c.publisher_id AND l.ipns = c.ipns AND l.status IN ('downloaded', 'truncated'))`
+	args := []interface{}{VisibilityPublic, excludeKey}
+
+	if len(publisherKeys) > 0 {
+		query += ` AND p.public_key IN (?` + strings.Repeat(`, ?`, len(publisherKeys)-1) + `)`
+		for _, key := range publisherKeys {
+			args = append(args, key)
+		}
+	}
+	query += ` ORDER BY p.public_key, c.ipns, i.group_name, i.filename, i.cid`
+
+	rows, err := db.conn.Query(query, args...)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to query catalog items: %w", err)
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var item CatalogItem
+		if err := rows.Scan(&item.CID, &item.Filename, &item.Extension, &item.Group, &item.PublisherKey); err != nil {
+			return nil, false, fmt.Errorf("failed to scan catalog item: %w", err)
+		}
+		if seen[item.CID] {
+			continue
+		}
+		if len(items) == limit {
+			return items, true, nil
+		}
+		seen[item.CID] = true
+		items = append(items, &item)
+	}
+
+	return items, false, rows.Err()
+}
+
+// FacetCount is the number of items with one value of a facet
+type FacetCount struct {
+	Value string
+	Items int
+}
+
+// Facets are the item counts per extension and per directory group
+type Facets struct {
+	Extensions []*FacetCount
+	Groups     []*FacetCount // Items without a group are counted under ""
+}
+
+// currentCollections selects the IDs of the latest downloaded (or truncated)
+// version of every collection, of publisherID if it is non-zero. Unlisted
+// collections are left out unless the includeUnlisted argument is set.
+const currentCollections = `
+	SELECT c.id FROM collections c
+	WHERE c.status IN ('downloaded', 'truncated') AND (c.visibility != ? OR ?) AND (? = 0 OR c.publisher_id = ?)
+		AND c.version = (SELECT MAX(version) FROM collections l
+			WHERE l.publisher_id = c.publisher_id AND l.ipns = c.ipns AND l.status IN ('downloaded', 'truncated'))`
+
+// GetFacets counts the items of the latest downloaded (or truncated) version of
+// every collection per extension and per group, of publisherID if it is non-zero.
+// A non-empty query limits the count to items whose filename contains it, ignoring
+// ASCII case. Each facet holds at most limit values, the most frequent first.
+func (db *DB) GetFacets(query string, publisherID int64, includeUnlisted bool, limit int) (*Facets, error) {
+	facets := &Facets{}
+	for _, facet := range []struct {
+		column string
+		counts *[]*FacetCount
+	}{
+		{"extension", &facets.Extensions},
+		{"group_name", &facets.Groups},
+	} {
+		rows, err := db.conn.Query(`
+			WITH current AS (`+currentCollections+`)
+			SELECT i.`+facet.column+`, COUNT(*) AS items FROM index_items i
+			JOIN current ON i.collection_id = current.id
+			WHERE ? = '' OR i.filename LIKE ? ESCAPE '\'
+			GROUP BY i.`+facet.column+`
+			ORDER BY items DESC, i.`+facet.column+`
+			LIMIT ?
+		`, VisibilityUnlisted, includeUnlisted, publisherID, publisherID, query, "%"+escapeLike(query)+"%", limit)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s facet: %w", facet.column, err)
+		}
+
+		for rows.Next() {
+			var count FacetCount
+			if err := rows.Scan(&count.Value, &count.Items); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan %s facet: %w", facet.column, err)
+			}
+			*facet.counts = append(*facet.counts, &count)
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s facet: %w", facet.column, err)
+		}
+	}
+
+	return facets, nil
+}
+
+// SearchItems returns the items of the latest downloaded (or truncated) version
+// of every collection whose filename starts with prefix, ignoring ASCII case,
+// ordered by filename. Unlisted collections are left out unless includeUnlisted
+// is set.
+func (db *DB) SearchItems(prefix string, includeUnlisted bool, page Page) ([]*IndexItem, error) {
+	limit, offset := page.limit()
+	rows, err := db.conn.Query(`
+		WITH current AS (`+currentCollections+`)
+		SELECT i.id, i.cid, i.filename, i.extension, i.group_name, i.size, i.mtime, i.mime_type, i.endorsed,
+			i.host_id, i.publisher_id, i.collection_id, i.created_at, i.updated_at
+		FROM index_items i
+		JOIN current ON i.collection_id = current.id
+		WHERE i.filename LIKE ? ESCAPE '\'
+		ORDER BY i.filename COLLATE NOCASE, i.id
+		LIMIT ? OFFSET ?
+	`, VisibilityUnlisted, includeUnlisted, 0, 0, escapeLike(prefix)+"%", limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []*IndexItem
+	for rows.Next() {
+		var item IndexItem
+		if err := rows.Scan(&item.ID, &item.CID, &item.Filename, &item.Extension, &item.Group,
+			&item.File.Size, &item.File.ModTime, &item.File.MimeType, &item.Endorsed, &item.HostID, &item.PublisherID, &item.CollectionID, &item.CreatedAt, &item.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan item: %w", err)
+		}
+		items = append(items, &item)
+	}
+
+	return items, rows.Err()
+}
+
+// Totals counts what the indexer holds
+type Totals struct {
+	Collections int // Collections by publisher and IPNS name, not versions
+	Items       int // Items of the latest downloaded (or truncated) version of each collection
+	Publishers  int
+}
+
+// GetTotals counts the collections, their current items and the publishers.
+// Unlisted collections are left out unless includeUnlisted is set.
+func (db *DB) GetTotals(includeUnlisted bool) (*Totals, error) {
+	var totals Totals
+	err := db.conn.QueryRow(`
+		WITH current AS (`+currentCollections+`)
+		SELECT
+			(SELECT COUNT(*) FROM (SELECT DISTINCT publisher_id, ipns FROM collections WHERE visibility != ? OR ?)),
+			(SELECT COUNT(*) FROM index_items i JOIN current ON i.collection_id = current.id),
+			(SELECT COUNT(*) FROM publishers)
+	`, VisibilityUnlisted, includeUnlisted, 0, 0, VisibilityUnlisted, includeUnlisted).Scan(&totals.Collections, &totals.Items, &totals.Publishers)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count totals: %w", err)
+	}
+	return &totals, nil
+}
+
+// escapeLike escapes LIKE wildcards so s matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,901 @@
+package database
+
+import (
+	"context"
+	"crypto/ed25519"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-common/fingerprint"
+	"github.com/pressly/goose/v3"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestDB creates a migrated database in a temporary directory
+func newTestDB(t *testing.T) *DB {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	db, err := New(filepath.Join(t.TempDir(), "test.db"), log)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestPublisherQuotaCounters(t *testing.T) {
+	db := newTestDB(t)
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubA, err := db.CreateOrGetPublisher("publisher-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubB, err := db.CreateOrGetPublisher("publisher-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collection, err := db.CreateCollection(host.ID, pubA.ID, 1, "k51a", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cid := range []string{"cid1", "cid2", "cid3"} {
+		if err := db.CreateOrUpdateIndexItem(cid, cid+".mp3", "mp3", "", ItemFile{}, false, host.ID, pubA.ID, collection.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count, err := db.CountPublisherItems(pubA.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("CountPublisherItems(a) = %d, want 3", count)
+	}
+
+	if err := db.IncrementPublisherRefusals(pubB.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.IncrementPublisherRefusals(pubB.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	usage, err := db.GetPublisherUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 2 {
+		t.Fatalf("GetPublisherUsage returned %d entries, want 2", len(usage))
+	}
+
+	want := []PublisherUsage{
+		{PublisherID: pubA.ID, PublicKey: "publisher-a", Collections: 1, Items: 3},
+		{PublisherID: pubB.ID, PublicKey: "publisher-b", RefusedCollections: 2},
+	}
+	for i, w := range want {
+		if *usage[i] != w {
+			t.Errorf("usage[%d] = %+v, want %+v", i, *usage[i], w)
+		}
+	}
+}
+
+func TestSchemaVersion(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	path := filepath.Join(t.TempDir(), "migrated.db")
+	db, err := New(path, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	current, latest, err := SchemaVersion(path)
+	if err != nil {
+		t.Fatalf("SchemaVersion failed: %v", err)
+	}
+	if current == 0 || current != latest {
+		t.Errorf("migrated database: current %d, latest %d; want equal and non-zero", current, latest)
+	}
+}
+
+func TestSchemaVersionDoesNotWrite(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.db")
+	conn, err := sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec(`CREATE TABLE unrelated (id INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	current, latest, err := SchemaVersion(path)
+	if err != nil {
+		t.Fatalf("SchemaVersion failed: %v", err)
+	}
+	if current != 0 || latest == 0 {
+		t.Errorf("unmigrated database: current %d, latest %d; want 0 and non-zero", current, latest)
+	}
+
+	conn, err = sql.Open("sqlite3", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	var tables int
+	if err := conn.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE name = 'goose_db_version'`).Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables != 0 {
+		t.Error("SchemaVersion created the goose version table")
+	}
+}
+
+func TestSetCollectionAnnouncedCIDs(t *testing.T) {
+	db := newTestDB(t)
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("publisher-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection, err := db.CreateCollection(host.ID, publisher.ID, 2, "k51a", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.SetCollectionAnnouncedCIDs(collection.ID, "bafyroot", "bafkindex"); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := db.GetCollection(collection.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.RootCID != "bafyroot" || stored.IndexCID != "bafkindex" {
+		t.Errorf("root, index = %q, %q; want bafyroot, bafkindex", stored.RootCID, stored.IndexCID)
+	}
+}
+
+func TestCollectionMetaAndActivity(t *testing.T) {
+	db := newTestDB(t)
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("publisher-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := db.CreateCollection(host.ID, publisher.ID, 1, "k51public", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlisted, err := db.CreateCollection(host.ID, publisher.ID, 1, "k51unlisted", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.SetCollectionMeta(publisher.ID, "k51public", VisibilityPublic, "CC-BY-4.0"); err != nil {
+		t.Fatal(err)
+	}
+	// A later announcement without a license keeps the stored one
+	if err := db.SetCollectionMeta(publisher.ID, "k51public", VisibilityPublic, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetCollectionMeta(publisher.ID, "k51unlisted", VisibilityUnlisted, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := db.GetCollection(public.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.License != "CC-BY-4.0" {
+		t.Errorf("license = %q, want CC-BY-4.0", stored.License)
+	}
+
+	activity, err := db.GetRecentActivity(10, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activity) != 1 || activity[0].CollectionID != public.ID {
+		t.Errorf("public activity = %+v, want only collection %d", activity, public.ID)
+	}
+
+	activity, err = db.GetRecentActivity(10, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(activity) != 2 {
+		t.Fatalf("activity with unlisted has %d entries, want 2", len(activity))
+	}
+	for _, a := range activity {
+		if a.CollectionID == unlisted.ID && a.Visibility != VisibilityUnlisted {
+			t.Errorf("unlisted collection has visibility %q", a.Visibility)
+		}
+	}
+}
+
+func TestCollectionManifest(t *testing.T) {
+	db := newTestDB(t)
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("publisher-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection, err := db.CreateCollection(host.ID, publisher.ID, 1, "k51a", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetCollectionManifestCID(collection.ID, "bafkmanifest"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetCollectionMeta(publisher.ID, "k51a", VisibilityPublic, "All rights reserved"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.GetCollectionManifest(publisher.ID, "k51a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("GetCollectionManifest before any was stored = %v, want ErrNotFound", err)
+	}
+
+	manifest := &CollectionManifest{PublisherID: publisher.ID, IPNS: "k51a", CID: "bafkmanifest", Name: "Field recordings",
+		Topic: "mdn/audio/announce", HeartbeatInterval: 3600, License: "CC-BY-4.0", Contact: "mailto:ops@example.org"}
+	if err := db.SetCollectionManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := db.GetCollectionManifest(publisher.ID, "k51a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.CID != "bafkmanifest" || stored.Name != "Field recordings" || stored.HeartbeatInterval != 3600 || stored.Contact != "mailto:ops@example.org" {
+		t.Errorf("stored manifest = %+v", stored)
+	}
+
+	// The manifest license replaces the announced one and outlasts later announcements
+	if err := db.SetCollectionMeta(publisher.ID, "k51a", VisibilityUnlisted, "All rights reserved"); err != nil {
+		t.Fatal(err)
+	}
+	c, err := db.GetCollection(collection.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ManifestCID != "bafkmanifest" || c.License != "CC-BY-4.0" || c.Visibility != VisibilityUnlisted {
+		t.Errorf("manifest CID, license, visibility = %q, %q, %q; want the manifest's license", c.ManifestCID, c.License, c.Visibility)
+	}
+
+	// A newer manifest replaces the stored one
+	manifest.CID, manifest.License = "bafkmanifest2", "CC0-1.0"
+	if err := db.SetCollectionManifest(manifest); err != nil {
+		t.Fatal(err)
+	}
+	if stored, err = db.GetCollectionManifest(publisher.ID, "k51a"); err != nil || stored.CID != "bafkmanifest2" {
+		t.Errorf("manifest after replacing = %+v, %v; want bafkmanifest2", stored, err)
+	}
+	if c, _ = db.GetCollection(collection.ID); c.License != "CC0-1.0" {
+		t.Errorf("license after replacing = %q, want CC0-1.0", c.License)
+	}
+}
+
+func TestCollectionMirrors(t *testing.T) {
+	db := newTestDB(t)
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("publisher-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection, err := db.CreateCollection(host.ID, publisher.ID, 2, "k51a", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mirrors := []string{"k51b", "12D3KooWRBy97UB99e3J6hiPesre1MZeuNQvfan4gBziswrRJsNK"}
+	if err := db.SetCollectionMirrors(collection.ID, mirrors); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := db.GetCollection(collection.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(stored.Mirrors, mirrors) {
+		t.Errorf("mirrors = %q, want %q", stored.Mirrors, mirrors)
+	}
+
+	// Comma-joined values written before migration 00009 are converted
+	if err := goose.DownTo(db.conn, "migrations", 8); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.conn.Exec(`UPDATE collections SET mirrors = 'k51b,k51c' WHERE id = ?`, collection.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := goose.Up(db.conn, "migrations"); err != nil {
+		t.Fatal(err)
+	}
+	stored, err = db.GetCollection(collection.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"k51b", "k51c"}; !slices.Equal(stored.Mirrors, want) {
+		t.Errorf("migrated mirrors = %q, want %q", stored.Mirrors, want)
+	}
+}
+
+func TestCollectionGroups(t *testing.T) {
+	db := newTestDB(t)
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("publisher-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection, err := db.CreateCollection(host.ID, publisher.ID, 1, "k51a", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := map[string]string{
+		"cid-root":  "",
+		"cid-album": "Artist/Album",
+		"cid-disc1": "Artist/Album/Disc 1",
+		"cid-disc2": "Artist/Album/Disc 2",
+		"cid-other": "Artist/Album_2", // "_" must not match as a LIKE wildcard
+	}
+	for cid, group := range items {
+		if err := db.CreateOrUpdateIndexItem(cid, cid+".flac", "flac", group, ItemFile{}, false, host.ID, publisher.ID, collection.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	groups, err := db.GetCollectionGroups(collection.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, g := range groups {
+		if g.Items != 1 {
+			t.Errorf("group %q has %d items, want 1", g.Group, g.Items)
+		}
+		names = append(names, g.Group)
+	}
+	if want := []string{"", "Artist/Album", "Artist/Album/Disc 1", "Artist/Album/Disc 2", "Artist/Album_2"}; !slices.Equal(names, want) {
+		t.Errorf("groups = %q, want %q", names, want)
+	}
+
+	tests := []struct {
+		group     *string
+		recursive bool
+		want      []string
+	}{
+		{nil, false, []string{"cid-root", "cid-album", "cid-disc1", "cid-disc2", "cid-other"}},
+		{ptr(""), false, []string{"cid-root"}},
+		{ptr("Artist/Album"), false, []string{"cid-album"}},
+		{ptr("Artist/Album"), true, []string{"cid-album", "cid-disc1", "cid-disc2"}},
+		{ptr("Artist/Album/Disc 1"), true, []string{"cid-disc1"}},
+		{ptr("Artist/Alb"), true, nil},
+	}
+	for _, tt := range tests {
+		items, err := db.GetCollectionItems(collection.ID, tt.group, tt.recursive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cids []string
+		for _, item := range items {
+			cids = append(cids, item.CID)
+		}
+		if !slices.Equal(cids, tt.want) {
+			t.Errorf("group %v recursive %t: items %q, want %q", tt.group, tt.recursive, cids, tt.want)
+		}
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestFindPublisher(t *testing.T) {
+	db := newTestDB(t)
+
+	var keys []string
+	for seed := byte(1); seed <= 3; seed++ {
+		s := make([]byte, ed25519.SeedSize)
+		s[0] = seed
+		key := base64.StdEncoding.EncodeToString(ed25519.NewKeyFromSeed(s).Public().(ed25519.PublicKey))
+		if _, err := db.CreateOrGetPublisher(key); err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, key)
+	}
+
+	publishers, err := db.ListPublishers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(publishers) != 3 || publishers[1].PublicKey != keys[1] {
+		t.Fatalf("ListPublishers = %+v, want the 3 publishers in ID order", publishers)
+	}
+
+	for _, query := range []string{keys[1], fingerprint.Format(keys[1]), fingerprint.Format(keys[1])[:len(fingerprint.Prefix)+9]} {
+		p, err := db.FindPublisher(query)
+		if err != nil || p.ID != publishers[1].ID {
+			t.Errorf("FindPublisher(%q) = %+v, %v, want publisher %d", query, p, err, publishers[1].ID)
+		}
+	}
+
+	s := make([]byte, ed25519.SeedSize)
+	s[0] = 99
+	unknown := base64.StdEncoding.EncodeToString(ed25519.NewKeyFromSeed(s).Public().(ed25519.PublicKey))
+	if _, err := db.FindPublisher(fingerprint.Format(unknown)); !errors.Is(err, fingerprint.ErrNotFound) {
+		t.Errorf("FindPublisher(unknown) error = %v, want fingerprint.ErrNotFound", err)
+	}
+}
+
+func TestGetIPNSPublisher(t *testing.T) {
+	db := newTestDB(t)
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := db.CreateOrGetPublisher("first-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := db.CreateOrGetPublisher("second-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateCollection(host.ID, first.ID, 1, "k51shared", nil, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateCollection(host.ID, second.ID, 2, "k51shared", nil, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// The latest announcement names the publisher
+	p, err := db.GetIPNSPublisher("k51shared")
+	if err != nil || p == nil || p.ID != second.ID || p.PublicKey != "second-key" {
+		t.Errorf("GetIPNSPublisher = %+v, %v, want publisher %d", p, err, second.ID)
+	}
+
+	if p, err := db.GetIPNSPublisher("k51unknown"); p != nil || err != nil {
+		t.Errorf("GetIPNSPublisher(unknown) = %+v, %v, want nil", p, err)
+	}
+}
+
+func TestPublisherKeys(t *testing.T) {
+	db := newTestDB(t)
+
+	current, err := db.CreateOrGetPublisher("current-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := db.CreateOrGetPublisher("other-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddPublisherKeys(current.ID, []string{"old-key-1", "old-key-2"}); err != nil {
+		t.Fatal(err)
+	}
+	// Recorded again, e.g. from a later share document
+	if err := db.AddPublisherKeys(current.ID, []string{"old-key-1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := db.GetPublisherKeys("current-key")
+	if err != nil || len(keys) != 2 || keys[0] != "old-key-1" || keys[1] != "old-key-2" {
+		t.Errorf("GetPublisherKeys = %v, %v, want both old keys once", keys, err)
+	}
+
+	// The keys belong to their publisher only
+	for _, key := range []string{other.PublicKey, "unknown-key"} {
+		if keys, err := db.GetPublisherKeys(key); err != nil || len(keys) != 0 {
+			t.Errorf("GetPublisherKeys(%s) = %v, %v, want none", key, keys, err)
+		}
+	}
+}
+
+func TestPublishersHeardSince(t *testing.T) {
+	db := newTestDB(t)
+
+	heard, err := db.CreateOrGetPublisher("heard-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CreateOrGetPublisher("silent-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.TouchPublisher(heard.ID); err != nil {
+		t.Fatal(err)
+	}
+	// Announcements heard twice count once
+	if err := db.TouchPublisher(heard.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	count, err := db.CountPublishersHeardSince(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("publishers heard in the last hour = %d, want 1", count)
+	}
+
+	count, err = db.CountPublishersHeardSince(time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("publishers heard after now = %d, want 0", count)
+	}
+}
+
+func TestGetCatalogItems(t *testing.T) {
+	db := newTestDB(t)
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// addCollection stores a collection of publisher with the given items as CIDs
+	addCollection := func(publisher string, version int, ipns, status, visibility string, cids ...string) {
+		t.Helper()
+		pub, err := db.CreateOrGetPublisher(publisher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		collection, err := db.CreateCollection(host.ID, pub.ID, version, ipns, nil, int64(version))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, cid := range cids {
+			if err := db.CreateOrUpdateIndexItem(cid, cid+".mp3", "mp3", "", ItemFile{}, false, host.ID, pub.ID, collection.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.UpdateCollectionStatus(collection.ID, status, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetCollectionMeta(pub.ID, ipns, visibility, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	addCollection("publisher-a", 1, "k51a", "downloaded", VisibilityPublic, "cid1", "cid2")
+	addCollection("publisher-a", 2, "k51a", "downloaded", VisibilityPublic, "cid3", "cid2")
+	addCollection("publisher-a", 3, "k51a", "pending", VisibilityPublic, "cid9")
+	addCollection("publisher-b", 1, "k51b", "downloaded", VisibilityUnlisted, "cid4")
+	addCollection("publisher-b", 1, "k51c", "truncated", VisibilityPublic, "cid3", "cid5")
+	addCollection("publisher-c", 1, "k51d", "downloaded", VisibilityPublic, "cid6")
+
+	cids := func(items []*CatalogItem) []string {
+		var out []string
+		for _, item := range items {
+			out = append(out, item.PublisherKey+"/"+item.CID)
+		}
+		return out
+	}
+
+	tests := []struct {
+		name          string
+		publishers    []string
+		limit         int
+		want          []string
+		wantTruncated bool
+	}{
+		{"all", nil, 10, []string{"publisher-a/cid2", "publisher-a/cid3", "publisher-b/cid5"}, false},
+		{"filtered", []string{"publisher-b", "publisher-c"}, 10, []string{"publisher-b/cid3", "publisher-b/cid5"}, false},
+		{"limited", nil, 2, []string{"publisher-a/cid2", "publisher-a/cid3"}, true},
+		{"exact limit", nil, 3, []string{"publisher-a/cid2", "publisher-a/cid3", "publisher-b/cid5"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			items, truncated, err := db.GetCatalogItems(tt.publishers, "publisher-c", tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := cids(items); !slices.Equal(got, tt.want) || truncated != tt.wantTruncated {
+				t.Errorf("GetCatalogItems = %v, truncated %v; want %v, truncated %v", got, truncated, tt.want, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestStaleCollectionRetries(t *testing.T) {
+	db := newTestDB(t)
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("publisher-a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	latest, err := db.GetLatestDownloadedVersion(publisher.ID, "k51a")
+	if err != nil || latest != nil {
+		t.Fatalf("GetLatestDownloadedVersion without versions = %v, %v; want nil", latest, err)
+	}
+
+	for version, status := range map[int]string{1: "downloaded", 2: "truncated", 3: "failed"} {
+		collection, err := db.CreateCollection(host.ID, publisher.ID, version, "k51a", nil, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetCollectionIndexCID(collection.ID, "index"+collection.IPNS+string(rune('0'+version))); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.UpdateCollectionStatus(collection.ID, status, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	latest, err = db.GetLatestDownloadedVersion(publisher.ID, "k51a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest == nil || latest.Version != 2 {
+		t.Fatalf("GetLatestDownloadedVersion = %+v, want version 2", latest)
+	}
+
+	// A stale version is retried like a pending one, but not before the interval passed
+	stale, err := db.CreateCollection(host.ID, publisher.ID, 4, "k51a", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.UpdateCollectionStatus(stale.ID, "stale", nil); err != nil {
+		t.Fatal(err)
+	}
+	due, err := db.GetPendingCollections(10, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].ID != stale.ID {
+		t.Fatalf("GetPendingCollections = %d collections, want the stale version", len(due))
+	}
+
+	if err := db.IncrementRetryCount(stale.ID); err != nil {
+		t.Fatal(err)
+	}
+	if due, err = db.GetPendingCollections(10, time.Now().Add(-time.Minute)); err != nil || len(due) != 0 {
+		t.Errorf("GetPendingCollections right after a retry = %d collections, %v; want none", len(due), err)
+	}
+	if due, err = db.GetPendingCollections(10, time.Now().Add(time.Second)); err != nil || len(due) != 1 {
+		t.Errorf("GetPendingCollections after the interval = %d collections, %v; want 1", len(due), err)
+	}
+}
+
+func TestGetFacets(t *testing.T) {
+	db := newTestDB(t)
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// addCollection stores a collection of publisher with items named file.ext in group
+	addCollection := func(publisher string, version int, ipns, status, visibility string, files ...[3]string) int64 {
+		t.Helper()
+		pub, err := db.CreateOrGetPublisher(publisher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		collection, err := db.CreateCollection(host.ID, pub.ID, version, ipns, nil, int64(version))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, f := range files {
+			cid := fmt.Sprintf("%s-%d-%d", ipns, version, i)
+			if err := db.CreateOrUpdateIndexItem(cid, f[0]+"."+f[1], f[1], f[2], ItemFile{}, false, host.ID, pub.ID, collection.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := db.UpdateCollectionStatus(collection.ID, status, nil); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetCollectionMeta(pub.ID, ipns, visibility, ""); err != nil {
+			t.Fatal(err)
+		}
+		return pub.ID
+	}
+
+	a := addCollection("publisher-a", 1, "k51a", "downloaded", VisibilityPublic, [3]string{"old", "wav", ""})
+	addCollection("publisher-a", 2, "k51a", "downloaded", VisibilityPublic,
+		[3]string{"Blue Song", "mp3", "Rock"}, [3]string{"red song", "mp3", "Rock"}, [3]string{"intro", "flac", ""})
+	addCollection("publisher-a", 3, "k51a", "pending", VisibilityPublic, [3]string{"next", "ogg", ""})
+	addCollection("publisher-b", 1, "k51b", "truncated", VisibilityPublic, [3]string{"song_100%", "mkv", "Films"})
+	addCollection("publisher-c", 1, "k51c", "downloaded", VisibilityUnlisted, [3]string{"hidden song", "mp3", "Rock"})
+
+	counts := func(facet []*FacetCount) []string {
+		var out []string
+		for _, c := range facet {
+			out = append(out, fmt.Sprintf("%s=%d", c.Value, c.Items))
+		}
+		return out
+	}
+
+	tests := []struct {
+		name            string
+		query           string
+		publisherID     int64
+		includeUnlisted bool
+		limit           int
+		extensions      []string
+		groups          []string
+	}{
+		{"all", "", 0, false, 10, []string{"mp3=2", "flac=1", "mkv=1"}, []string{"Rock=2", "=1", "Films=1"}},
+		{"unlisted", "", 0, true, 10, []string{"mp3=3", "flac=1", "mkv=1"}, []string{"Rock=3", "=1", "Films=1"}},
+		{"query ignores case", "SONG", 0, false, 10, []string{"mp3=2", "mkv=1"}, []string{"Rock=2", "Films=1"}},
+		{"query is literal", "_100%", 0, false, 10, []string{"mkv=1"}, []string{"Films=1"}},
+		{"publisher", "", a, false, 10, []string{"mp3=2", "flac=1"}, []string{"Rock=2", "=1"}},
+		{"limited", "", 0, false, 1, []string{"mp3=2"}, []string{"Rock=2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			facets, err := db.GetFacets(tt.query, tt.publisherID, tt.includeUnlisted, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := counts(facets.Extensions); !slices.Equal(got, tt.extensions) {
+				t.Errorf("extensions = %v, want %v", got, tt.extensions)
+			}
+			if got := counts(facets.Groups); !slices.Equal(got, tt.groups) {
+				t.Errorf("groups = %v, want %v", got, tt.groups)
+			}
+		})
+	}
+}
+
+// facetBenchItems is the number of items BenchmarkGetFacets counts
+const facetBenchItems = 1_000_000
+
+// BenchmarkGetFacets counts facets over facetBenchItems items in 20 collections.
+// Seeding takes a while: run it with -run '^$' -bench GetFacets.
+func BenchmarkGetFacets(b *testing.B) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	db, err := New(filepath.Join(b.TempDir(), "bench.db"), log)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer db.Close()
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	const collections = 20
+	exts := []string{"mp3", "flac", "mkv", "mp4", "ogg", "wav", "avi", "opus"}
+	var stored []*Collection
+	for c := 0; c < collections; c++ {
+		pub, err := db.CreateOrGetPublisher(fmt.Sprintf("publisher-%d", c))
+		if err != nil {
+			b.Fatal(err)
+		}
+		collection, err := db.CreateCollection(host.ID, pub.ID, 1, fmt.Sprintf("k51bench%d", c), nil, 1)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := db.UpdateCollectionStatus(collection.ID, "downloaded", nil); err != nil {
+			b.Fatal(err)
+		}
+		stored = append(stored, collection)
+	}
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	stmt, err := tx.Prepare(`INSERT INTO index_items (cid, filename, extension, group_name, host_id, publisher_id, collection_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	for c, collection := range stored {
+		for i := 0; i < facetBenchItems/collections; i++ {
+			ext := exts[i%len(exts)]
+			if _, err := stmt.Exec(fmt.Sprintf("cid-%d-%d", c, i), fmt.Sprintf("track %d.%s", i, ext), ext,
+				fmt.Sprintf("Artist %d/Album %d", i%500, i%7), host.ID, collection.PublisherID, collection.ID); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	if err := stmt.Close(); err != nil {
+		b.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bench := range []struct {
+		name        string
+		query       string
+		publisherID int64
+	}{
+		{"all", "", 0},
+		{"query", "track 12", 0},
+		{"publisher", "", stored[0].PublisherID},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := db.GetFacets(bench.query, bench.publisherID, false, 50); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestCloseIdleWaitsForTransactions(t *testing.T) {
+	db := newTestDB(t)
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(`INSERT INTO publishers (public_key) VALUES ('in-flight')`); err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- db.CloseIdle(context.Background()) }()
+
+	select {
+	case err := <-closed:
+		t.Fatalf("CloseIdle returned %v while a transaction was open", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("commit of the open transaction: %v", err)
+	}
+	if err := <-closed; err != nil {
+		t.Errorf("CloseIdle = %v, want nil once the transaction committed", err)
+	}
+}
+
+func TestCloseIdleTimeout(t *testing.T) {
+	db := newTestDB(t)
+
+	tx, err := db.conn.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := db.CloseIdle(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("CloseIdle = %v, want the deadline error", err)
+	}
+}
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE collections ADD COLUMN items_stored INTEGER DEFAULT 0;
+ALTER TABLE publishers ADD COLUMN refused_collections INTEGER DEFAULT 0;
+
+CREATE INDEX idx_index_items_publisher ON index_items(publisher_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_index_items_publisher;
+ALTER TABLE publishers DROP COLUMN refused_collections;
+ALTER TABLE collections DROP COLUMN items_stored;
+-- +goose StatementEnd
@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE collections ADD COLUMN visibility TEXT NOT NULL DEFAULT 'public';
+ALTER TABLE collections ADD COLUMN license TEXT NOT NULL DEFAULT '';
+CREATE INDEX idx_collections_visibility ON collections(visibility);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_collections_visibility;
+ALTER TABLE collections DROP COLUMN license;
+ALTER TABLE collections DROP COLUMN visibility;
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE index_items ADD COLUMN group_name TEXT NOT NULL DEFAULT '';
+CREATE INDEX idx_index_items_collection_group ON index_items(collection_id, group_name);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_index_items_collection_group;
+ALTER TABLE index_items DROP COLUMN group_name;
+-- +goose StatementEnd
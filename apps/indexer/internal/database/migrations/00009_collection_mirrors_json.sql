@@ -0,0 +1,9 @@
+-- +goose Up
+-- +goose StatementBegin
+UPDATE collections SET mirrors = '["' || REPLACE(mirrors, ',', '","') || '"]' WHERE mirrors != '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+UPDATE collections SET mirrors = REPLACE(REPLACE(REPLACE(REPLACE(mirrors, '[]', ''), '["', ''), '"]', ''), '","', ',');
+-- +goose StatementEnd
@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE publishers ADD COLUMN last_heard_at TIMESTAMP;
+CREATE INDEX idx_publishers_last_heard ON publishers(last_heard_at);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_publishers_last_heard;
+ALTER TABLE publishers DROP COLUMN last_heard_at;
+-- +goose StatementEnd
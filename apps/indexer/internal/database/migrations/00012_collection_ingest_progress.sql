@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE collections ADD COLUMN items_ingested INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE collections ADD COLUMN ingest_offset INTEGER NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE collections DROP COLUMN ingest_offset;
+ALTER TABLE collections DROP COLUMN items_ingested;
+-- +goose StatementEnd
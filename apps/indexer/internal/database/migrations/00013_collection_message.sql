@@ -0,0 +1,11 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE collections ADD COLUMN message_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE collections ADD COLUMN message_hash TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE collections DROP COLUMN message_hash;
+ALTER TABLE collections DROP COLUMN message_id;
+-- +goose StatementEnd
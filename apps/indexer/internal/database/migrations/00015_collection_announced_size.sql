@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE collections ADD COLUMN announced_size INTEGER;
+UPDATE collections SET announced_size = size WHERE status IN ('pending', 'stale');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE collections DROP COLUMN announced_size;
+-- +goose StatementEnd
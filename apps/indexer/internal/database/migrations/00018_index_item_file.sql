@@ -0,0 +1,13 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE index_items ADD COLUMN size INTEGER;
+ALTER TABLE index_items ADD COLUMN mtime INTEGER;
+ALTER TABLE index_items ADD COLUMN mime_type TEXT;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE index_items DROP COLUMN mime_type;
+ALTER TABLE index_items DROP COLUMN mtime;
+ALTER TABLE index_items DROP COLUMN size;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE collections ADD COLUMN manifest_cid TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE collection_manifests (
+    publisher_id INTEGER NOT NULL,
+    ipns TEXT NOT NULL,
+    cid TEXT NOT NULL,
+    name TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    topic TEXT NOT NULL DEFAULT '',
+    heartbeat_interval INTEGER NOT NULL DEFAULT 0,
+    license TEXT NOT NULL DEFAULT '',
+    contact TEXT NOT NULL DEFAULT '',
+    fetched_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (publisher_id, ipns),
+    FOREIGN KEY (publisher_id) REFERENCES publishers(id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS collection_manifests;
+ALTER TABLE collections DROP COLUMN manifest_cid;
+-- +goose StatementEnd
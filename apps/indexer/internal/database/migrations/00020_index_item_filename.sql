@@ -0,0 +1,10 @@
+-- +goose Up
+-- +goose StatementBegin
+-- Serves filename prefix searches; LIKE ignores ASCII case, so the index does too
+CREATE INDEX idx_index_items_filename ON index_items(filename COLLATE NOCASE);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS idx_index_items_filename;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE publisher_keys (
+    publisher_id INTEGER NOT NULL,
+    public_key TEXT NOT NULL,
+    added_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (publisher_id, public_key),
+    FOREIGN KEY (publisher_id) REFERENCES publishers(id)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS publisher_keys;
+-- +goose StatementEnd
@@ -5,41 +5,79 @@ import (
 	"fmt"
 	"io"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/atregu/ipfs-common/tracing"
 	"github.com/atregu/ipfs-indexer/internal/config"
 	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/atregu/ipfs-indexer/internal/gateway"
 	"github.com/atregu/ipfs-indexer/internal/ipfs"
 	"github.com/atregu/ipfs-indexer/internal/parser"
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
+// Sources an index is downloaded from, recorded per collection
+const (
+	SourceNative  = "native"
+	SourceGateway = "gateway"
+)
+
+// gatewayRequestTimeout bounds each block request to a gateway
+const gatewayRequestTimeout = 30 * time.Second
+
+// Client resolves, downloads and pins collections (implemented by ipfs.Client)
+type Client interface {
+	ResolveIPNS(ctx context.Context, ipnsName string) (string, error)
+	ResolveIndexFile(ctx context.Context, rootCID string) (string, error)
+	Cat(ctx context.Context, cid string) (io.ReadCloser, error)
+	Stat(ctx context.Context, cid string) (*ipfs.StatResult, error)
+	CatWithSession(ctx context.Context, cid string, parallelism int) (io.ReadCloser, *ipfs.FetchStats, error)
+	Pin(ctx context.Context, cid string) error
+	PutBlock(ctx context.Context, block blocks.Block) error
+}
+
 // Fetcher handles downloading collections from IPNS
 type Fetcher struct {
-	ipfsClient *ipfs.Client
-	db         *database.DB
-	parser     *parser.Parser
-	cfg        *config.FetcherConfig
-	log        *logrus.Logger
-	ctx        context.Context
-	cancel     context.CancelFunc
-	wg         sync.WaitGroup
-	semaphore  chan struct{}
+	ipfsClient     Client
+	db             *database.DB
+	parser         *parser.Parser
+	cfg            *config.FetcherConfig
+	log            *logrus.Logger
+	ctx            context.Context // Done once Stop is called: no new fetches start
+	cancel         context.CancelFunc
+	fetchCtx       context.Context // Done when Stop gives up on draining: fetches in flight are interrupted
+	cancelFetches  context.CancelFunc
+	wg             sync.WaitGroup
+	semaphore      chan struct{}
+	incomplete     atomic.Int64    // Fetches whose item count disagreed with the announcement
+	gateway        *gateway.Client // Fallback of native retrieval; nil without fetcher.gateways
+	nativeFetches  atomic.Int64
+	gatewayFetches atomic.Int64
 }
 
 // NewFetcher creates a new collection fetcher
-func NewFetcher(ipfsClient *ipfs.Client, db *database.DB, parser *parser.Parser, cfg *config.FetcherConfig, log *logrus.Logger) *Fetcher {
+func NewFetcher(ipfsClient Client, db *database.DB, parser *parser.Parser, cfg *config.FetcherConfig, log *logrus.Logger) *Fetcher {
 	ctx, cancel := context.WithCancel(context.Background())
-	return &Fetcher{
-		ipfsClient: ipfsClient,
-		db:         db,
-		parser:     parser,
-		cfg:        cfg,
-		log:        log,
-		ctx:        ctx,
-		cancel:     cancel,
-		semaphore:  make(chan struct{}, cfg.ConcurrentDownloads),
+	fetchCtx, cancelFetches := context.WithCancel(context.Background())
+	f := &Fetcher{
+		ipfsClient:    ipfsClient,
+		db:            db,
+		parser:        parser,
+		cfg:           cfg,
+		log:           log,
+		ctx:           ctx,
+		cancel:        cancel,
+		fetchCtx:      fetchCtx,
+		cancelFetches: cancelFetches,
+		semaphore:     make(chan struct{}, cfg.ConcurrentDownloads),
+	}
+	if len(cfg.Gateways) > 0 {
+		f.gateway = gateway.NewClient(cfg.Gateways, gatewayRequestTimeout)
 	}
+	return f
 }
 
 // Start begins the background fetcher goroutine
@@ -77,7 +115,8 @@ func (f *Fetcher) worker() {
 
 // processPendingCollections fetches all pending collections
 func (f *Fetcher) processPendingCollections() {
-	collections, err := f.db.GetPendingCollections(f.cfg.RetryAttempts)
+	retryInterval := time.Duration(f.cfg.RetryIntervalSeconds) * time.Second
+	collections, err := f.db.GetPendingCollections(f.cfg.RetryAttempts, time.Now().Add(-retryInterval))
 	if err != nil {
 		f.log.Errorf("Failed to get pending collections: %v", err)
 		return
@@ -91,12 +130,6 @@ func (f *Fetcher) processPendingCollections() {
 	f.log.Infof("Processing %d pending collections...", len(collections))
 
 	for _, collection := range collections {
-		// Check if we should retry (check last retry time)
-		if collection.LastRetryAt != nil && collection.RetryCount > 0 {
-			// Don't retry too soon
-			continue
-		}
-
 		// Use semaphore to limit concurrent downloads
 		select {
 		case <-f.ctx.Done():
@@ -117,54 +150,464 @@ func (f *Fetcher) fetchCollection(collection *database.Collection) {
 		collection.ID, collection.IPNS, collection.RetryCount+1, f.cfg.RetryAttempts)
 
 	// Create a timeout context for the fetch operation
-	ctx, cancel := context.WithTimeout(f.ctx, 5*time.Minute)
+	ctx, cancel := context.WithTimeout(f.fetchCtx, 5*time.Minute)
 	defer cancel()
 
-	// Step 1: Resolve IPNS to CID
-	cid, err := f.ipfsClient.ResolveIPNS(ctx, collection.IPNS)
+	// The fetch continues the trace of the announcement's receipt
+	ctx, span := tracing.Start(tracing.Extract(ctx, collection.TraceParent), "fetch",
+		attribute.Int64("collection.id", collection.ID), attribute.Int("collection.version", collection.Version),
+		attribute.String("ipns.name", collection.IPNS))
+	defer span.End()
+
+	// Step 1: Use the announced root, or resolve IPNS to CID (falling back to mirror names)
+	cid := collection.RootCID
+	if cid != "" {
+		f.log.Infof("Using announced root CID: %s", cid)
+	} else {
+		resolved, resolvedName, err := f.resolveCollection(ctx, collection)
+		if err != nil {
+			if f.interrupted(collection) {
+				return
+			}
+			f.serveStale(ctx, collection)
+			f.handleFetchError(ctx, collection, fmt.Errorf("failed to resolve IPNS: %w", err))
+			return
+		}
+		cid = resolved
+		f.log.Infof("Resolved IPNS %s to CID: %s", resolvedName, cid)
+	}
+
+	// Collection roots may be a directory holding the index or a legacy bare index file
+	var indexCID string
+	rootViaGateway, err := f.retrieve(ctx, cid, func(ctx context.Context) error {
+		var err error
+		indexCID, err = f.ipfsClient.ResolveIndexFile(ctx, cid)
+		return err
+	})
 	if err != nil {
-		f.handleFetchError(collection, fmt.Errorf("failed to resolve IPNS: %w", err))
+		f.handleFetchError(ctx, collection, fmt.Errorf("failed to locate index in %s: %w", cid, err))
+		return
+	}
+	if indexCID != cid {
+		f.log.Debugf("Collection root %s is a directory, index file CID: %s", cid, indexCID)
+	}
+	if collection.RootCID != "" && collection.IndexCID != "" && indexCID != collection.IndexCID {
+		f.handleFetchError(ctx, collection, fmt.Errorf("index file %s in root %s does not match the announced index CID %s",
+			indexCID, cid, collection.IndexCID))
 		return
 	}
 
-	f.log.Infof("Resolved IPNS %s to CID: %s", collection.IPNS, cid)
+	if err := f.db.SetCollectionIndexCID(collection.ID, indexCID); err != nil {
+		f.log.Errorf("Failed to record index CID: %v", err)
+	}
+
+	// The license of a stored manifest takes precedence over the one of the index header
+	f.fetchManifest(ctx, collection)
 
-	// Step 2: Download the file content
-	reader, err := f.ipfsClient.Cat(ctx, cid)
+	// An incomplete collection is parsed again from the first line
+	if collection.Status == "incomplete" {
+		if err := f.db.SetCollectionProgress(collection.ID, 0, 0); err != nil {
+			f.handleFetchError(ctx, collection, err)
+			return
+		}
+		collection.ItemsIngested, collection.IngestOffset = 0, 0
+	}
+
+	// Prefer the announced delta when the base version is already applied. A
+	// partially parsed index resumes instead, as the delta would replace its items.
+	if collection.DeltaCID != "" && collection.IngestOffset == 0 {
+		err := f.applyDelta(ctx, collection, cid)
+		if err == nil {
+			return
+		}
+		f.log.Infof("Delta for collection ID=%d not applied (%v), fetching the full index", collection.ID, err)
+	}
+
+	// The size is known from the index's root block, before anything else is downloaded
+	statViaGateway := false
+	if f.cfg.MaxCollectionSize > 0 {
+		var stat *ipfs.StatResult
+		statViaGateway, err = f.retrieve(ctx, indexCID, func(ctx context.Context) error {
+			var err error
+			stat, err = f.ipfsClient.Stat(ctx, indexCID)
+			return err
+		})
+		if err != nil {
+			f.handleFetchError(ctx, collection, fmt.Errorf("failed to stat CID %s: %w", indexCID, err))
+			return
+		}
+		if stat.Size > uint64(f.cfg.MaxCollectionSize) {
+			f.rejectOversized(collection, stat)
+			return
+		}
+	}
+
+	// Step 2: Download the file content using a bitswap session
+	var reader io.ReadCloser
+	var stats *ipfs.FetchStats
+	downloadCtx, download := tracing.Start(ctx, "download", attribute.String("ipfs.cid", indexCID))
+	catViaGateway, err := f.retrieve(downloadCtx, indexCID, func(ctx context.Context) error {
+		var err error
+		reader, stats, err = f.ipfsClient.CatWithSession(ctx, indexCID, f.cfg.BlockParallelism)
+		return err
+	})
+	if err == nil {
+		download.SetAttributes(attribute.Int64("ipfs.blocks", stats.Blocks), attribute.Bool("gateway", catViaGateway))
+	}
+	tracing.End(download, err)
 	if err != nil {
-		f.handleFetchError(collection, fmt.Errorf("failed to fetch CID %s: %w", cid, err))
+		f.handleFetchError(ctx, collection, fmt.Errorf("failed to fetch CID %s: %w", indexCID, err))
 		return
 	}
+	// A reader opened under the native timeout ends with it; the content is local by now
+	if f.gateway != nil {
+		reader.Close()
+		if reader, err = f.ipfsClient.Cat(ctx, indexCID); err != nil {
+			f.handleFetchError(ctx, collection, fmt.Errorf("failed to read CID %s: %w", indexCID, err))
+			return
+		}
+	}
+	defer reader.Close()
+
+	source := SourceNative
+	if rootViaGateway || statViaGateway || catViaGateway {
+		source = SourceGateway
+	}
+	f.log.Infof("Fetched %d blocks for collection ID=%d in %v (%.1f blocks/s, %s)",
+		stats.Blocks, collection.ID, stats.Duration.Round(time.Millisecond), stats.BlocksPerSecond(), source)
+
+	// Steps 3-5: Stream the content through the parser, store and update the collection status
+	stream := &countingReader{r: reader}
+	_, parse := tracing.Start(ctx, "parse")
+	err = f.storeContent(collection, stream)
+	parse.SetAttributes(attribute.Int("index.bytes", stream.n))
+	tracing.End(parse, err)
+
+	// Pin the collection root (index file or its directory) so it can be reparsed without re-downloading
+	if stream.eof {
+		f.log.Infof("Downloaded collection ID=%d, size=%d bytes", collection.ID, stream.n)
+		if err := f.ipfsClient.Pin(ctx, cid); err != nil {
+			f.log.Warnf("Failed to pin index CID %s: %v", cid, err)
+		}
+		f.recordSource(collection, source)
+	}
+
+	if err != nil {
+		f.handleFetchError(ctx, collection, err)
+	}
+}
+
+// retrieve runs step, a native retrieval of the DAG of cid, under
+// fetcher.native_timeout_seconds when gateways are configured. If it fails, the
+// DAG is fetched from the gateways into the local blockstore, verified and
+// within the gateway size limit, and step runs again on the local blocks. It
+// reports whether the gateways were used.
+func (f *Fetcher) retrieve(ctx context.Context, cid string, step func(context.Context) error) (bool, error) {
+	if f.gateway == nil {
+		return false, step(ctx)
+	}
+
+	nativeCtx, cancel := context.WithTimeout(ctx, time.Duration(f.cfg.NativeTimeoutSeconds)*time.Second)
+	err := step(nativeCtx)
+	cancel()
+	if err == nil || ctx.Err() != nil {
+		return false, err
+	}
+
+	f.log.Warnf("Native retrieval of %s failed (%v), trying the gateways", cid, err)
+	fetched, gwErr := f.gateway.Fetch(ctx, cid, f.gatewayLimit(), f.ipfsClient)
+	if gwErr != nil {
+		return false, fmt.Errorf("%w; gateway fallback failed: %v", err, gwErr)
+	}
+	f.log.Infof("Fetched %s from the gateways (%d bytes)", cid, fetched)
+	return true, step(ctx)
+}
+
+// gatewayLimit returns the most bytes a DAG fetched from the gateways may have
+func (f *Fetcher) gatewayLimit() int64 {
+	limit := f.cfg.GatewayMaxBytes
+	if f.cfg.MaxCollectionSize > 0 && f.cfg.MaxCollectionSize < limit {
+		limit = f.cfg.MaxCollectionSize
+	}
+	return limit
+}
+
+// recordSource records the source a collection's index was downloaded from
+func (f *Fetcher) recordSource(collection *database.Collection, source string) {
+	if source == SourceGateway {
+		f.gatewayFetches.Add(1)
+	} else {
+		f.nativeFetches.Add(1)
+	}
+	if err := f.db.SetCollectionFetchSource(collection.ID, source); err != nil {
+		f.log.Errorf("Failed to record fetch source: %v", err)
+	}
+}
+
+// FetchCount returns the number of indexes downloaded from source
+func (f *Fetcher) FetchCount(source string) int64 {
+	if source == SourceGateway {
+		return f.gatewayFetches.Load()
+	}
+	return f.nativeFetches.Load()
+}
+
+// countingReader counts the bytes read from r and notes when r is exhausted
+type countingReader struct {
+	r   io.Reader
+	n   int
+	eof bool
+}
+
+// Read implements io.Reader
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	if err == io.EOF {
+		c.eof = true
+	}
+	return n, err
+}
+
+// applyDelta fetches the announced delta file of a collection and applies it to the
+// base version it was built against. It returns an error without changing the
+// collection if the base version is not downloaded or the delta does not apply.
+func (f *Fetcher) applyDelta(ctx context.Context, collection *database.Collection, rootCID string) error {
+	reader, err := f.ipfsClient.Cat(ctx, collection.DeltaCID)
+	if err != nil {
+		return fmt.Errorf("failed to fetch delta %s: %w", collection.DeltaCID, err)
+	}
 	defer reader.Close()
 
-	// Step 3: Read the content
 	content, err := io.ReadAll(reader)
 	if err != nil {
-		f.handleFetchError(collection, fmt.Errorf("failed to read content: %w", err))
-		return
+		return fmt.Errorf("failed to read delta: %w", err)
 	}
 
-	f.log.Infof("Downloaded collection ID=%d, size=%d bytes", collection.ID, len(content))
+	header, err := parser.ParseDeltaHeader(content)
+	if err != nil {
+		return err
+	}
 
-	// Step 4: Parse and store the collection
-	count, err := f.parser.ParseAndStore(collection, content)
+	base, err := f.db.GetDownloadedVersion(collection.PublisherID, collection.IPNS, header.BaseVersion, header.BaseIndexCID)
 	if err != nil {
-		f.handleFetchError(collection, fmt.Errorf("failed to parse collection: %w", err))
-		return
+		return err
+	}
+	if base == nil {
+		return fmt.Errorf("base version %d (%s) is not downloaded", header.BaseVersion, header.BaseIndexCID)
+	}
+
+	result, err := f.parser.ApplyDelta(base, collection, content)
+	if err != nil {
+		return err
+	}
+
+	if err := f.ipfsClient.Pin(ctx, rootCID); err != nil {
+		f.log.Warnf("Failed to pin index CID %s: %v", rootCID, err)
+	}
+
+	if err := f.db.UpdateCollectionItemsStored(collection.ID, result.Stored); err != nil {
+		f.log.Errorf("Failed to record stored item count: %v", err)
 	}
 
-	// Step 5: Update collection status to downloaded
 	size := len(content)
 	if err := f.db.UpdateCollectionStatus(collection.ID, "downloaded", &size); err != nil {
 		f.log.Errorf("Failed to update collection status: %v", err)
+	}
+
+	f.log.Infof("Successfully applied delta to collection ID=%d, %d items", collection.ID, result.Stored)
+	return nil
+}
+
+// resolveCollection resolves the primary IPNS name of a collection and, if that
+// fails, each announced mirror name in turn. It returns the CID and the name used.
+func (f *Fetcher) resolveCollection(ctx context.Context, collection *database.Collection) (_, _ string, err error) {
+	ctx, span := tracing.Start(ctx, "resolve", attribute.String("ipns.name", collection.IPNS))
+	defer func() { tracing.End(span, err) }()
+
+	cid, err := f.ipfsClient.ResolveIPNS(ctx, collection.IPNS)
+	if err == nil {
+		return cid, collection.IPNS, nil
+	}
+
+	primaryErr := err
+	for _, mirror := range collection.Mirrors {
+		f.log.Warnf("Primary IPNS %s failed to resolve (%v), trying mirror %s", collection.IPNS, primaryErr, mirror)
+
+		cid, err := f.ipfsClient.ResolveIPNS(ctx, mirror)
+		if err == nil {
+			return cid, mirror, nil
+		}
+		f.log.Warnf("Mirror IPNS %s failed to resolve: %v", mirror, err)
+	}
+
+	return "", "", primaryErr
+}
+
+// serveStale checks, after the IPNS name of a collection failed to resolve, that
+// the index of its latest downloaded version is still retrievable by pinning it.
+// If so the collection is marked stale: the earlier content stays searchable
+// while resolution is retried. Without such a version it stays pending.
+func (f *Fetcher) serveStale(ctx context.Context, collection *database.Collection) {
+	base, err := f.db.GetLatestDownloadedVersion(collection.PublisherID, collection.IPNS)
+	if err != nil {
+		f.log.Errorf("Failed to look up downloaded versions: %v", err)
 		return
 	}
+	if base == nil {
+		return
+	}
+
+	if err := f.ipfsClient.Pin(ctx, base.IndexCID); err != nil {
+		f.log.Warnf("Version %d of collection IPNS=%s is not retrievable either (index CID %s): %v",
+			base.Version, collection.IPNS, base.IndexCID, err)
+		return
+	}
+
+	if collection.Status != "stale" {
+		if err := f.db.UpdateCollectionStatus(collection.ID, "stale", collection.Size); err != nil {
+			f.log.Errorf("Failed to update collection status: %v", err)
+			return
+		}
+	}
+	f.log.Infof("Collection ID=%d is stale: IPNS=%s does not resolve, serving version %d (index CID %s)",
+		collection.ID, collection.IPNS, base.Version, base.IndexCID)
+}
+
+// storeContent parses the collection content read from stream and updates its
+// status. If the stream breaks off or any items fail to store, the collection
+// is left pending for a retry, which resumes after the last stored chunk.
+func (f *Fetcher) storeContent(collection *database.Collection, stream *countingReader) error {
+	result, err := f.parser.ParseAndStore(collection, stream)
+
+	if result != nil {
+		if err := f.db.UpdateCollectionItemsStored(collection.ID, result.Stored); err != nil {
+			f.log.Errorf("Failed to record stored item count: %v", err)
+		}
+	}
+
+	if err != nil {
+		expected := "unknown"
+		if collection.AnnouncedSize != nil {
+			expected = fmt.Sprintf("%d", *collection.AnnouncedSize)
+		}
+		stored := 0
+		if result != nil {
+			stored = result.Stored
+		}
+		return fmt.Errorf("failed to parse collection (stored %d, expected %s): %w", stored, expected, err)
+	}
+
+	// Truncated collections keep the items stored so far. A count that disagrees
+	// with the announcement is kept too, but the collection is fetched again.
+	status := "downloaded"
+	switch {
+	case result.Truncated:
+		status = "truncated"
+	case !f.complete(collection, result.Stored):
+		status = "incomplete"
+	}
+
+	size := stream.n
+	if err := f.db.UpdateCollectionStatus(collection.ID, status, &size); err != nil {
+		f.log.Errorf("Failed to update collection status: %v", err)
+		return nil
+	}
+
+	if result.Truncated {
+		f.log.Warnf("Collection ID=%d truncated at %d items", collection.ID, result.Stored)
+		return nil
+	}
+	if status == "incomplete" {
+		f.incomplete.Add(1)
+		f.log.Warnf("Collection ID=%d is incomplete: indexed %d items, the announcement declares %d; it will be fetched again",
+			collection.ID, result.Stored, *collection.AnnouncedSize)
+		if err := f.db.IncrementRetryCount(collection.ID); err != nil {
+			f.log.Errorf("Failed to increment retry count: %v", err)
+		}
+		return nil
+	}
+
+	f.log.Infof("Successfully processed collection ID=%d, indexed %d items", collection.ID, result.Stored)
+	return nil
+}
+
+// complete reports whether stored items match the item count announced for a
+// collection within fetcher.completeness_tolerance. A collection announced
+// without a count is complete.
+func (f *Fetcher) complete(collection *database.Collection, stored int) bool {
+	if collection.AnnouncedSize == nil {
+		return true
+	}
+	diff := stored - *collection.AnnouncedSize
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= f.cfg.CompletenessTolerance
+}
 
-	f.log.Infof("Successfully processed collection ID=%d, indexed %d items", collection.ID, count)
+// IncompleteCount returns the number of fetches whose item count disagreed with
+// the announced collection size
+func (f *Fetcher) IncompleteCount() int64 {
+	return f.incomplete.Load()
 }
 
-// handleFetchError handles errors during fetching, implementing retry logic
-func (f *Fetcher) handleFetchError(collection *database.Collection, err error) {
+// Reparse re-runs the parser for a collection from its pinned index CID
+// without resolving IPNS or downloading the index again
+func (f *Fetcher) Reparse(ctx context.Context, collectionID int64) error {
+	collection, err := f.db.GetCollection(collectionID)
+	if err != nil {
+		return err
+	}
+
+	if collection.IndexCID == "" {
+		return fmt.Errorf("collection %d has no recorded index CID", collectionID)
+	}
+
+	f.log.Infof("Reparsing collection ID=%d from index CID %s", collection.ID, collection.IndexCID)
+
+	reader, err := f.ipfsClient.Cat(ctx, collection.IndexCID)
+	if err != nil {
+		return fmt.Errorf("failed to read index CID %s: %w", collection.IndexCID, err)
+	}
+	defer reader.Close()
+
+	// Parse from the first line, not from the progress of the earlier parse
+	if err := f.db.SetCollectionProgress(collection.ID, 0, 0); err != nil {
+		return err
+	}
+	collection.ItemsIngested, collection.IngestOffset = 0, 0
+
+	return f.storeContent(collection, &countingReader{r: reader})
+}
+
+// interrupted reports whether the fetches were interrupted by Stop, logging
+// that the collection stays pending. An interrupted fetch is not a failed attempt.
+func (f *Fetcher) interrupted(collection *database.Collection) bool {
+	if f.fetchCtx.Err() == nil {
+		return false
+	}
+	f.log.Infof("Fetch of collection ID=%d interrupted by shutdown, it stays pending", collection.ID)
+	return true
+}
+
+// rejectOversized marks a collection whose index exceeds fetcher.max_collection_size
+// as failed without downloading it: a retry would find the same index
+func (f *Fetcher) rejectOversized(collection *database.Collection, stat *ipfs.StatResult) {
+	f.log.Warnf("Skipping collection ID=%d: index %s is %d bytes, over fetcher.max_collection_size (%d)",
+		collection.ID, stat.CID, stat.Size, f.cfg.MaxCollectionSize)
+	if err := f.db.UpdateCollectionStatus(collection.ID, "failed", nil); err != nil {
+		f.log.Errorf("Failed to update collection status to failed: %v", err)
+	}
+}
+
+// handleFetchError handles errors during fetching, implementing retry logic.
+// The error is recorded on the fetch span in ctx.
+func (f *Fetcher) handleFetchError(ctx context.Context, collection *database.Collection, err error) {
+	if f.interrupted(collection) {
+		return
+	}
+	tracing.Fail(ctx, err)
 	f.log.Warnf("Error fetching collection ID=%d: %v", collection.ID, err)
 
 	// Increment retry count
@@ -183,18 +626,32 @@ func (f *Fetcher) handleFetchError(collection *database.Collection, err error) {
 	}
 }
 
-// Stop gracefully stops the fetcher
-func (f *Fetcher) Stop() error {
+// Stop stops starting fetches and waits for the fetches in flight to finish
+// until ctx is done. Then it interrupts them and waits for them to return, so
+// no fetch writes to the database after Stop; interrupted collections stay
+// pending without counting as a failed attempt.
+func (f *Fetcher) Stop(ctx context.Context) error {
 	f.log.Info("Stopping collection fetcher...")
 
-	// Cancel context
-	if f.cancel != nil {
-		f.cancel()
+	// No new fetches
+	f.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		f.wg.Wait()
+		close(done)
+	}()
+
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		f.log.Warn("Fetches still running at the drain deadline, interrupting them")
+		err = fmt.Errorf("fetches interrupted: %w", ctx.Err())
 	}
-
-	// Wait for all goroutines to finish
-	f.wg.Wait()
+	f.cancelFetches()
+	<-done
 
 	f.log.Info("Collection fetcher stopped")
-	return nil
+	return err
 }
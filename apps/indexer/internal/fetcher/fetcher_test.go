@@ -0,0 +1,244 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/atregu/ipfs-indexer/internal/ipfs"
+	"github.com/atregu/ipfs-indexer/internal/parser"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/sirupsen/logrus"
+)
+
+// indexLines builds an index with n records
+func indexLines(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, `{"id":%d,"CID":"cid%d","filename":"file%d.mp3","extension":"mp3"}`+"\n", i, i, i)
+	}
+	return b.String()
+}
+
+func TestStoreContentCompleteness(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("publisher-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.FetcherConfig{ConcurrentDownloads: 1, RetryAttempts: 10, CompletenessTolerance: 1}
+	f := NewFetcher(nil, db, parser.NewParser(db, nil, 0, log), cfg, log)
+
+	tests := []struct {
+		announced *int
+		items     int
+		status    string
+	}{
+		{nil, 5, "downloaded"},
+		{intPtr(5), 5, "downloaded"},
+		{intPtr(6), 5, "downloaded"}, // Within the tolerance
+		{intPtr(8), 5, "incomplete"},
+		{intPtr(2), 5, "incomplete"},
+	}
+	incomplete := int64(0)
+	for i, tt := range tests {
+		collection, err := db.CreateCollection(host.ID, publisher.ID, i+1, fmt.Sprintf("k51test%d", i), tt.announced, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.storeContent(collection, &countingReader{r: strings.NewReader(indexLines(tt.items))}); err != nil {
+			t.Fatal(err)
+		}
+
+		stored, err := db.GetCollection(collection.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status != tt.status || stored.ItemsStored != tt.items {
+			t.Errorf("announced %v, %d items: status %s with %d items, want %s", tt.announced, tt.items, stored.Status, stored.ItemsStored, tt.status)
+		}
+		if tt.status == "incomplete" {
+			incomplete++
+			if stored.RetryCount != 1 {
+				t.Errorf("incomplete collection has retry count %d, want 1", stored.RetryCount)
+			}
+		}
+	}
+	if got := f.IncompleteCount(); got != incomplete {
+		t.Errorf("IncompleteCount = %d, want %d", got, incomplete)
+	}
+
+	// Incomplete collections are fetched again after the retry interval
+	due, err := db.GetPendingCollections(cfg.RetryAttempts, time.Now().Add(time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 2 {
+		t.Errorf("GetPendingCollections = %d collections, want the 2 incomplete ones", len(due))
+	}
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+// offlineNode is a fetcher client serving one index as a single raw block. Out
+// of reach of the network it only has the blocks added with PutBlock.
+type offlineNode struct {
+	index    []byte
+	indexCID string
+	offline  bool
+	blocks   map[string][]byte
+}
+
+func newOfflineNode(t *testing.T, index string) *offlineNode {
+	t.Helper()
+	k, err := cid.NewPrefixV1(cid.Raw, mh.SHA2_256).Sum([]byte(index))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &offlineNode{index: []byte(index), indexCID: k.String(), offline: true, blocks: make(map[string][]byte)}
+}
+
+// has reports whether the node can retrieve cid
+func (n *offlineNode) has(cid string) error {
+	if _, ok := n.blocks[cid]; ok || !n.offline {
+		return nil
+	}
+	return fmt.Errorf("no providers for %s", cid)
+}
+
+func (n *offlineNode) ResolveIPNS(ctx context.Context, name string) (string, error) {
+	return n.indexCID, nil
+}
+
+func (n *offlineNode) ResolveIndexFile(ctx context.Context, rootCID string) (string, error) {
+	return rootCID, n.has(rootCID)
+}
+
+func (n *offlineNode) Cat(ctx context.Context, cid string) (io.ReadCloser, error) {
+	if err := n.has(cid); err != nil {
+		return nil, err
+	}
+	return io.NopCloser(strings.NewReader(string(n.index))), nil
+}
+
+func (n *offlineNode) Stat(ctx context.Context, cid string) (*ipfs.StatResult, error) {
+	if err := n.has(cid); err != nil {
+		return nil, err
+	}
+	return &ipfs.StatResult{CID: cid, Size: uint64(len(n.index))}, nil
+}
+
+func (n *offlineNode) CatWithSession(ctx context.Context, cid string, parallelism int) (io.ReadCloser, *ipfs.FetchStats, error) {
+	reader, err := n.Cat(ctx, cid)
+	if err != nil {
+		return nil, nil, err
+	}
+	return reader, &ipfs.FetchStats{Blocks: 1}, nil
+}
+
+func (n *offlineNode) Pin(ctx context.Context, cid string) error {
+	return n.has(cid)
+}
+
+func (n *offlineNode) PutBlock(ctx context.Context, block blocks.Block) error {
+	n.blocks[block.Cid().String()] = block.RawData()
+	return nil
+}
+
+func TestFetchFallsBackToGateways(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("publisher-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	index := indexLines(3)
+	requests := 0
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(index))
+	}))
+	t.Cleanup(gw.Close)
+
+	tests := []struct {
+		name     string
+		offline  bool
+		gateways []string
+		maxBytes int64
+		status   string
+		source   string
+	}{
+		{"without gateways", true, nil, 1 << 20, "pending", ""},
+		{"native first", false, []string{gw.URL}, 1 << 20, "downloaded", SourceNative},
+		{"gateway fallback", true, []string{gw.URL}, 1 << 20, "downloaded", SourceGateway},
+		{"over the gateway limit", true, []string{gw.URL}, int64(len(index) - 1), "pending", ""},
+	}
+	for i, tt := range tests {
+		node := newOfflineNode(t, index)
+		node.offline = tt.offline
+		cfg := &config.FetcherConfig{ConcurrentDownloads: 1, RetryAttempts: 10, BlockParallelism: 1,
+			Gateways: tt.gateways, GatewayMaxBytes: tt.maxBytes, NativeTimeoutSeconds: 1}
+		f := NewFetcher(node, db, parser.NewParser(db, nil, 0, log), cfg, log)
+
+		collection, err := db.CreateCollection(host.ID, publisher.ID, i+1, fmt.Sprintf("k51test%d", i), nil, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		requests = 0
+		f.semaphore <- struct{}{}
+		f.wg.Add(1)
+		f.fetchCollection(collection)
+
+		stored, err := db.GetCollection(collection.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.Status != tt.status || stored.FetchSource != tt.source {
+			t.Errorf("%s: status %s from %q, want %s from %q", tt.name, stored.Status, stored.FetchSource, tt.status, tt.source)
+		}
+		if tt.source != "" && f.FetchCount(tt.source) != 1 {
+			t.Errorf("%s: FetchCount(%s) = %d, want 1", tt.name, tt.source, f.FetchCount(tt.source))
+		}
+		if tt.source != SourceGateway && len(node.blocks) != 0 {
+			t.Errorf("%s: %d blocks stored from the gateways, want none", tt.name, len(node.blocks))
+		}
+		if !tt.offline && requests != 0 {
+			t.Errorf("%s: %d gateway requests, want none while the native download works", tt.name, requests)
+		}
+	}
+}
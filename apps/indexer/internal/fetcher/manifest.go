@@ -0,0 +1,88 @@
+package fetcher
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/atregu/ipfs-common/manifest"
+	"github.com/atregu/ipfs-indexer/internal/database"
+)
+
+// manifestTimeout bounds the download of a collection manifest
+const manifestTimeout = 30 * time.Second
+
+// fetchManifest downloads, verifies and stores the manifest announced for a
+// collection, unless the stored one has the same CID. A manifest that cannot be
+// fetched or verified is logged and ignored: the collection keeps the metadata
+// of its announcements, as do collections of publishers without a manifest.
+func (f *Fetcher) fetchManifest(ctx context.Context, collection *database.Collection) {
+	if collection.ManifestCID == "" {
+		return
+	}
+	if stored, err := f.db.GetCollectionManifest(collection.PublisherID, collection.IPNS); err == nil && stored.CID == collection.ManifestCID {
+		return
+	}
+
+	m, err := f.readManifest(ctx, collection)
+	if err != nil {
+		f.log.Warnf("Ignoring manifest %s of collection ID=%d: %v", collection.ManifestCID, collection.ID, err)
+		return
+	}
+
+	err = f.db.SetCollectionManifest(&database.CollectionManifest{
+		PublisherID:       collection.PublisherID,
+		IPNS:              collection.IPNS,
+		CID:               collection.ManifestCID,
+		Name:              m.Name,
+		Description:       m.Description,
+		Topic:             m.Topic,
+		HeartbeatInterval: m.HeartbeatInterval,
+		License:           m.License,
+		Contact:           m.Contact,
+	})
+	if err != nil {
+		f.log.Errorf("Failed to store manifest of collection ID=%d: %v", collection.ID, err)
+		return
+	}
+	f.log.Infof("Stored manifest %s of collection ID=%d (%q)", collection.ManifestCID, collection.ID, m.Name)
+}
+
+// readManifest downloads the manifest of a collection and checks that it is
+// valid and signed by the collection's publisher
+func (f *Fetcher) readManifest(ctx context.Context, collection *database.Collection) (*manifest.Manifest, error) {
+	publisher, err := f.db.GetPublisher(collection.PublisherID)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, manifestTimeout)
+	defer cancel()
+
+	reader, err := f.ipfsClient.Cat(ctx, collection.ManifestCID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch: %w", err)
+	}
+	defer reader.Close()
+
+	data, err := io.ReadAll(io.LimitReader(reader, manifest.MaxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read: %w", err)
+	}
+
+	m, err := manifest.Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := m.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %w", err)
+	}
+	if err := m.Verify(); err != nil {
+		return nil, fmt.Errorf("invalid signature: %w", err)
+	}
+	if m.PublicKey != publisher.PublicKey {
+		return nil, fmt.Errorf("signed by %s instead of the publisher", m.Fingerprint)
+	}
+	return m, nil
+}
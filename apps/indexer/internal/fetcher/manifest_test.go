@@ -0,0 +1,125 @@
+package fetcher
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/atregu/ipfs-common/manifest"
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/atregu/ipfs-indexer/internal/parser"
+	"github.com/sirupsen/logrus"
+)
+
+// manifestNode serves manifests by CID and counts the downloads
+type manifestNode struct {
+	*offlineNode
+	manifests map[string][]byte
+	cats      int
+}
+
+func (n *manifestNode) Cat(ctx context.Context, cid string) (io.ReadCloser, error) {
+	n.cats++
+	data, ok := n.manifests[cid]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return io.NopCloser(strings.NewReader(string(data))), nil
+}
+
+// signedManifest returns a manifest with license signed by key
+func signedManifest(t *testing.T, key ed25519.PrivateKey, license string) []byte {
+	t.Helper()
+	m := &manifest.Manifest{Format: manifest.FormatVersion, Name: "Field recordings", License: license, HeartbeatInterval: 3600}
+	if err := m.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestFetchManifest(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	publicKey, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher(base64.StdEncoding.EncodeToString(publicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	node := &manifestNode{offlineNode: newOfflineNode(t, indexLines(1)), manifests: map[string][]byte{
+		"manifest-cc":    signedManifest(t, key, "CC-BY-4.0"),
+		"manifest-other": signedManifest(t, otherKey, "CC0-1.0"),
+		"manifest-large": []byte(strings.Repeat(" ", manifest.MaxSize+1)),
+	}}
+	cfg := &config.FetcherConfig{ConcurrentDownloads: 1, RetryAttempts: 10, BlockParallelism: 1}
+	f := NewFetcher(node, db, parser.NewParser(db, nil, 0, log), cfg, log)
+
+	collection, err := db.CreateCollection(host.ID, publisher.ID, 1, "k51test", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetCollectionMeta(publisher.ID, "k51test", database.VisibilityPublic, "All rights reserved"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Manifests signed by another key, oversized or missing are ignored
+	for _, cid := range []string{"manifest-other", "manifest-large", "manifest-missing"} {
+		collection.ManifestCID = cid
+		f.fetchManifest(context.Background(), collection)
+		if _, err := db.GetCollectionManifest(publisher.ID, "k51test"); !errors.Is(err, database.ErrNotFound) {
+			t.Errorf("%s: GetCollectionManifest = %v, want ErrNotFound", cid, err)
+		}
+	}
+
+	collection.ManifestCID = "manifest-cc"
+	f.fetchManifest(context.Background(), collection)
+	stored, err := db.GetCollectionManifest(publisher.ID, "k51test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.CID != "manifest-cc" || stored.Name != "Field recordings" || stored.HeartbeatInterval != 3600 {
+		t.Errorf("stored manifest = %+v", stored)
+	}
+	c, err := db.GetCollection(collection.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.License != "CC-BY-4.0" {
+		t.Errorf("license = %q, want the manifest's", c.License)
+	}
+
+	// An unchanged manifest is not downloaded again
+	cats := node.cats
+	f.fetchManifest(context.Background(), collection)
+	if node.cats != cats {
+		t.Error("unchanged manifest downloaded again")
+	}
+}
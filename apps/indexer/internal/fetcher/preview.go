@@ -0,0 +1,131 @@
+package fetcher
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/atregu/ipfs-common/fingerprint"
+	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/atregu/ipfs-indexer/internal/parser"
+)
+
+// PreviewResult is what indexing a collection would do, see Fetcher.Preview
+type PreviewResult struct {
+	IPNS      string `json:"ipns"`
+	RootCID   string `json:"rootCid"`
+	IndexCID  string `json:"indexCid"`
+	Publisher string `json:"publisher,omitempty"` // Fingerprint of the key claims were verified against
+	Bytes     int    `json:"bytes"`               // Bytes of the index read
+	Partial   bool   `json:"partial"`             // The index exceeds fetcher.preview_max_bytes; only its start was parsed
+	*parser.Preview
+}
+
+// previewTimeout bounds a preview like a fetch, from resolution to parsing
+const previewTimeout = 5 * time.Minute
+
+// errPreviewLimit ends the download of an index at fetcher.preview_max_bytes
+var errPreviewLimit = errors.New("preview size limit reached")
+
+// Preview resolves an IPNS name and parses up to fetcher.preview_max_bytes of
+// its index in memory. Nothing is stored or pinned, so a preview leaves no trace
+// of the collection. Claims are verified against publisher, a public key or the
+// fingerprint of a known publisher; if it is empty, against the key of the
+// publisher that announced the name, if any.
+func (f *Fetcher) Preview(ctx context.Context, ipnsName, publisher string) (*PreviewResult, error) {
+	publicKey, err := f.previewKey(ipnsName, publisher)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, previewTimeout)
+	defer cancel()
+
+	collection := &database.Collection{IPNS: ipnsName}
+	rootCID, _, err := f.resolveCollection(ctx, collection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve IPNS: %w", err)
+	}
+
+	indexCID, err := f.ipfsClient.ResolveIndexFile(ctx, rootCID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate index in %s: %w", rootCID, err)
+	}
+
+	reader, _, err := f.ipfsClient.CatWithSession(ctx, indexCID, f.cfg.BlockParallelism)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch CID %s: %w", indexCID, err)
+	}
+	defer reader.Close()
+
+	stream := &countingReader{r: &limitReader{r: reader, n: f.cfg.PreviewMaxBytes}}
+	preview, err := f.parser.Preview(collection, stream, publicKey)
+	partial := errors.Is(err, errPreviewLimit)
+	if err != nil && !partial {
+		return nil, err
+	}
+
+	result := &PreviewResult{
+		IPNS:     ipnsName,
+		RootCID:  rootCID,
+		IndexCID: indexCID,
+		Bytes:    stream.n,
+		Partial:  partial,
+		Preview:  preview,
+	}
+	if publicKey != "" {
+		result.Publisher = fingerprint.Format(publicKey)
+	}
+	return result, nil
+}
+
+// previewKey returns the base64-encoded key the claims of a preview are verified
+// against, or "" if there is none
+func (f *Fetcher) previewKey(ipnsName, publisher string) (string, error) {
+	if publisher == "" {
+		p, err := f.db.GetIPNSPublisher(ipnsName)
+		if err != nil || p == nil {
+			return "", err
+		}
+		return p.PublicKey, nil
+	}
+
+	// The full key of a publisher the indexer has not heard from yet
+	if key, err := fingerprint.Decode(publisher); err == nil {
+		return base64.StdEncoding.EncodeToString(key), nil
+	}
+
+	p, err := f.db.FindPublisher(publisher)
+	if err != nil {
+		return "", fmt.Errorf("failed to find publisher: %w", err)
+	}
+	return p.PublicKey, nil
+}
+
+// limitReader reads from r until n bytes are read. It then returns
+// errPreviewLimit, unless r has no more data.
+type limitReader struct {
+	r io.Reader
+	n int
+}
+
+// Read implements io.Reader
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.n <= 0 {
+		var b [1]byte
+		if n, err := l.r.Read(b[:]); n == 0 {
+			return 0, err
+		}
+		return 0, errPreviewLimit
+	}
+
+	if len(p) > l.n {
+		p = p[:l.n]
+	}
+	n, err := l.r.Read(p)
+	l.n -= n
+	return n, err
+}
@@ -0,0 +1,225 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// maxBlockSize is the largest block a gateway may return, the limit bitswap
+// enforces too
+const maxBlockSize = 2 << 20
+
+// ErrTooLarge is returned when a DAG exceeds the size limit of a fetch
+var ErrTooLarge = errors.New("content exceeds the gateway size limit")
+
+// BlockStore keeps the blocks fetched from gateways (implemented by ipfs.Client)
+type BlockStore interface {
+	PutBlock(ctx context.Context, block blocks.Block) error
+}
+
+// Client fetches content from HTTP gateways as raw blocks (trustless gateway
+// requests), so each block is verified against its CID before it is stored and
+// no gateway has to be trusted
+type Client struct {
+	gateways []string
+	http     *http.Client
+}
+
+// NewClient creates a client of gateways, base URLs tried in order, each
+// request limited to timeout
+func NewClient(gateways []string, timeout time.Duration) *Client {
+	return &Client{
+		gateways: gateways,
+		http:     &http.Client{Timeout: timeout},
+	}
+}
+
+// Fetch downloads the DAG of root block by block, from the first gateway that
+// serves each block, and adds the verified blocks to store. Only raw and dag-pb
+// (UnixFS) blocks are followed. It returns the number of bytes fetched, and
+// ErrTooLarge as soon as the blocks exceed maxBytes.
+func (c *Client) Fetch(ctx context.Context, root string, maxBytes int64, store BlockStore) (int64, error) {
+	rootCID, err := cid.Decode(root)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse CID %s: %w", root, err)
+	}
+
+	var fetched int64
+	seen := cid.NewSet()
+	queue := []cid.Cid{rootCID}
+	for len(queue) > 0 {
+		k := queue[0]
+		queue = queue[1:]
+		if !seen.Visit(k) {
+			continue
+		}
+
+		data, err := c.block(ctx, k, maxBytes-fetched)
+		if err != nil {
+			return fetched, err
+		}
+		fetched += int64(len(data))
+
+		links, err := blockLinks(k, data)
+		if err != nil {
+			return fetched, err
+		}
+		queue = append(queue, links...)
+
+		block, err := blocks.NewBlockWithCid(data, k)
+		if err != nil {
+			return fetched, fmt.Errorf("failed to create block %s: %w", k, err)
+		}
+		if err := store.PutBlock(ctx, block); err != nil {
+			return fetched, fmt.Errorf("failed to store block %s: %w", k, err)
+		}
+	}
+	return fetched, nil
+}
+
+// block fetches the block k from the first gateway that serves it and matches
+// its CID. A block larger than limit fails with ErrTooLarge.
+func (c *Client) block(ctx context.Context, k cid.Cid, limit int64) ([]byte, error) {
+	if len(c.gateways) == 0 {
+		return nil, fmt.Errorf("no gateways configured")
+	}
+
+	var errs []error
+	for _, gw := range c.gateways {
+		data, err := c.blockFrom(ctx, gw, k, limit)
+		if err == nil {
+			return data, nil
+		}
+		if errors.Is(err, ErrTooLarge) || ctx.Err() != nil {
+			return nil, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", gw, err))
+	}
+	return nil, fmt.Errorf("failed to fetch block %s: %w", k, errors.Join(errs...))
+}
+
+// blockFrom fetches the block k from the gateway gw and verifies it against k
+func (c *Client) blockFrom(ctx context.Context, gw string, k cid.Cid, limit int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gw+"/ipfs/"+k.String()+"?format=raw", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.ipld.raw")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	// A block is never larger than maxBlockSize, whatever limit is left
+	allowed := min(limit, maxBlockSize)
+	data, err := io.ReadAll(io.LimitReader(resp.Body, allowed+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read block: %w", err)
+	}
+	if int64(len(data)) > allowed {
+		if allowed < maxBlockSize {
+			return nil, ErrTooLarge
+		}
+		return nil, fmt.Errorf("block larger than %d bytes", maxBlockSize)
+	}
+
+	sum, err := k.Prefix().Sum(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash block: %w", err)
+	}
+	if !sum.Equals(k) {
+		return nil, fmt.Errorf("block does not match its CID")
+	}
+	return data, nil
+}
+
+// blockLinks returns the CIDs a verified block links to
+func blockLinks(k cid.Cid, data []byte) ([]cid.Cid, error) {
+	switch k.Type() {
+	case cid.Raw:
+		return nil, nil
+	case cid.DagProtobuf:
+		links, err := dagPBLinks(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode block %s: %w", k, err)
+		}
+		return links, nil
+	default:
+		return nil, fmt.Errorf("block %s has unsupported codec 0x%x", k, k.Type())
+	}
+}
+
+// dagPBLinks decodes the link hashes of a dag-pb node: the Hash (1) of each
+// PBLink in the Links (2) of a PBNode
+func dagPBLinks(data []byte) ([]cid.Cid, error) {
+	var links []cid.Cid
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		if num != 2 || typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, data)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			data = data[n:]
+			continue
+		}
+
+		link, n := protowire.ConsumeBytes(data)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		data = data[n:]
+
+		k, err := dagPBLinkHash(link)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, k)
+	}
+	return links, nil
+}
+
+// dagPBLinkHash decodes the Hash (1) of a PBLink
+func dagPBLinkHash(link []byte) (cid.Cid, error) {
+	for len(link) > 0 {
+		num, typ, n := protowire.ConsumeTag(link)
+		if n < 0 {
+			return cid.Undef, protowire.ParseError(n)
+		}
+		link = link[n:]
+
+		if num == 1 && typ == protowire.BytesType {
+			hash, n := protowire.ConsumeBytes(link)
+			if n < 0 {
+				return cid.Undef, protowire.ParseError(n)
+			}
+			return cid.Cast(hash)
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, link)
+		if n < 0 {
+			return cid.Undef, protowire.ParseError(n)
+		}
+		link = link[n:]
+	}
+	return cid.Undef, fmt.Errorf("link without a hash")
+}
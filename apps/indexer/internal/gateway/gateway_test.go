@@ -0,0 +1,125 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// memStore is a BlockStore keeping blocks in memory
+type memStore map[cid.Cid][]byte
+
+func (s memStore) PutBlock(ctx context.Context, block blocks.Block) error {
+	s[block.Cid()] = block.RawData()
+	return nil
+}
+
+// testDAG returns the blocks of a dag-pb node linking two raw leaves, and the
+// CID of the node
+func testDAG(t *testing.T) (map[string][]byte, cid.Cid) {
+	t.Helper()
+	dag := make(map[string][]byte)
+	put := func(prefix cid.Prefix, data []byte) cid.Cid {
+		k, err := prefix.Sum(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dag[k.String()] = data
+		return k
+	}
+
+	raw := cid.NewPrefixV1(cid.Raw, mh.SHA2_256)
+	var node []byte
+	for _, leaf := range []string{"first leaf\n", "second leaf\n"} {
+		var link []byte
+		link = protowire.AppendTag(link, 1, protowire.BytesType)
+		link = protowire.AppendBytes(link, put(raw, []byte(leaf)).Bytes())
+		node = protowire.AppendTag(node, 2, protowire.BytesType)
+		node = protowire.AppendBytes(node, link)
+	}
+	node = protowire.AppendTag(node, 1, protowire.BytesType)
+	node = protowire.AppendBytes(node, []byte{0x08, 0x02})
+	return dag, put(cid.NewPrefixV1(cid.DagProtobuf, mh.SHA2_256), node)
+}
+
+// serveBlocks returns a gateway serving the blocks of dag, after tamper if set
+func serveBlocks(t *testing.T, dag map[string][]byte, tamper func([]byte) []byte) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, ok := dag[strings.TrimPrefix(r.URL.Path, "/ipfs/")]
+		if !ok || r.URL.Query().Get("format") != "raw" {
+			http.NotFound(w, r)
+			return
+		}
+		if tamper != nil {
+			data = tamper(data)
+		}
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFetchVerifiesBlocks(t *testing.T) {
+	dag, root := testDAG(t)
+	liar := serveBlocks(t, dag, func(data []byte) []byte { return append([]byte("x"), data...) })
+	honest := serveBlocks(t, dag, nil)
+
+	// Blocks failing verification at the first gateway are taken from the next
+	store := memStore{}
+	c := NewClient([]string{liar.URL, honest.URL}, time.Second)
+	fetched, err := c.Fetch(context.Background(), root.String(), 1<<20, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store) != 3 {
+		t.Errorf("stored %d blocks, want 3", len(store))
+	}
+	var size int64
+	for k, data := range store {
+		if string(dag[k.String()]) != string(data) {
+			t.Errorf("block %s = %q, want %q", k, data, dag[k.String()])
+		}
+		size += int64(len(data))
+	}
+	if fetched != size {
+		t.Errorf("fetched %d bytes, want %d", fetched, size)
+	}
+
+	// A gateway serving only tampered blocks fails the fetch
+	c = NewClient([]string{liar.URL}, time.Second)
+	if _, err := c.Fetch(context.Background(), root.String(), 1<<20, memStore{}); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("Fetch from a tampering gateway = %v, want a verification error", err)
+	}
+}
+
+func TestFetchSizeLimit(t *testing.T) {
+	dag, root := testDAG(t)
+	srv := serveBlocks(t, dag, nil)
+
+	var size int64
+	for _, data := range dag {
+		size += int64(len(data))
+	}
+
+	c := NewClient([]string{srv.URL}, time.Second)
+	if _, err := c.Fetch(context.Background(), root.String(), size, memStore{}); err != nil {
+		t.Errorf("Fetch at exactly the limit: %v", err)
+	}
+	store := memStore{}
+	if _, err := c.Fetch(context.Background(), root.String(), size-1, store); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Fetch over the limit = %v, want ErrTooLarge", err)
+	}
+	if len(store) == len(dag) {
+		t.Error("all blocks stored although the DAG exceeds the limit")
+	}
+}
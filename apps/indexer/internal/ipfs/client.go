@@ -2,24 +2,34 @@ package ipfs
 
 import (
 	"context"
+	"crypto/ed25519"
 	"fmt"
 	"io"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/atregu/ipfs-common/dedupe"
 	"github.com/atregu/ipfs-indexer/internal/config"
 	"github.com/atregu/ipfs-indexer/internal/logger"
 
+	"github.com/ipfs/boxo/blockservice"
 	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/ipld/merkledag"
 	"github.com/ipfs/boxo/path"
+	blocks "github.com/ipfs/go-block-format"
+	cid "github.com/ipfs/go-cid"
 	"github.com/ipfs/kubo/core"
 	"github.com/ipfs/kubo/core/coreapi"
 	iface "github.com/ipfs/kubo/core/coreiface"
+	"github.com/ipfs/kubo/core/coreiface/options"
+	"github.com/ipfs/kubo/core/corerepo"
 	"github.com/ipfs/kubo/core/node/libp2p"
 	"github.com/ipfs/kubo/plugin/loader"
 	"github.com/ipfs/kubo/repo"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/peer"
 
 	// Import plugins
@@ -28,6 +38,9 @@ import (
 	_ "github.com/ipfs/kubo/plugin/plugins/levelds"
 )
 
+// IndexFileName is the name of the collection index inside a collection root directory
+const IndexFileName = "collection.ndjson"
+
 // Client represents an IPFS client interface
 type Client struct {
 	node    *core.IpfsNode
@@ -113,6 +126,17 @@ func (c *Client) Start() error {
 	}
 	c.repo = repo
 
+	// Apply memory and connection limits (e.g. for small devices)
+	res := c.cfg.Resources
+	if err := ApplyResourceLimits(repo, res.MaxMemory, res.ConnLowWater, res.ConnHighWater); err != nil {
+		CloseRepo(repo)
+		return err
+	}
+	if err := ApplyBootstrapPeers(repo, c.cfg.BootstrapPeers); err != nil {
+		CloseRepo(repo)
+		return err
+	}
+
 	// Build the IPFS node
 	nodeOptions := &core.BuildCfg{
 		Online:  true,
@@ -175,6 +199,14 @@ func (c *Client) GetPeerID() peer.ID {
 	return ""
 }
 
+// GetPeerCount returns the number of connected peers
+func (c *Client) GetPeerCount() int {
+	if !c.started || c.node == nil {
+		return 0
+	}
+	return len(c.node.PeerHost.Network().Peers())
+}
+
 // ResolveIPNS resolves an IPNS name to an IPFS CID
 func (c *Client) ResolveIPNS(ctx context.Context, ipnsName string) (string, error) {
 	if !c.started {
@@ -207,6 +239,76 @@ func (c *Client) ResolveIPNS(ctx context.Context, ipnsName string) (string, erro
 	return resolvedPath, nil
 }
 
+// ResolveIndexFile returns the CID of the collection index for a resolved IPNS value.
+// Newer publishers point IPNS at a directory containing IndexFileName; legacy
+// publishers point it at the bare index file, in which case rootCID is returned unchanged.
+func (c *Client) ResolveIndexFile(ctx context.Context, rootCID string) (string, error) {
+	if !c.started {
+		return "", fmt.Errorf("node not started")
+	}
+
+	p, err := path.NewPath("/ipfs/" + rootCID)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse path: %w", err)
+	}
+
+	node, err := c.api.Unixfs().Get(ctx, p)
+	if err != nil {
+		return "", fmt.Errorf("failed to get root node: %w", err)
+	}
+	defer node.Close()
+
+	if _, isDir := node.(files.Directory); !isDir {
+		return rootCID, nil
+	}
+
+	filePath, err := path.Join(p, IndexFileName)
+	if err != nil {
+		return "", fmt.Errorf("failed to build index path: %w", err)
+	}
+
+	resolved, _, err := c.api.ResolvePath(ctx, filePath)
+	if err != nil {
+		return "", fmt.Errorf("directory %s has no %s: %w", rootCID, IndexFileName, err)
+	}
+
+	return resolved.RootCid().String(), nil
+}
+
+// Content types reported by Stat
+const (
+	StatTypeFile = "file"
+	StatTypeDir  = "dir"
+)
+
+// StatResult describes content by CID without its data
+type StatResult struct {
+	CID      string
+	Size     uint64 // File size, or the cumulative DAG size of a directory
+	NumLinks int    // Links of the root node: chunks of a file, entries of a directory
+	Type     string // StatTypeFile or StatTypeDir
+}
+
+// Stat resolves the root node of cid and describes the content from it. Only
+// the root block is fetched, so the size of a collection is known before it
+// is downloaded. kubo no longer offers Object().Stat.
+func (c *Client) Stat(ctx context.Context, cidStr string) (*StatResult, error) {
+	if !c.started {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	p, err := path.NewPath("/ipfs/" + cidStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse path: %w", err)
+	}
+
+	node, err := c.api.ResolveNode(ctx, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get root node of %s: %w", cidStr, err)
+	}
+	return statNode(node)
+}
+
 // Cat retrieves file content from IPFS by CID
 func (c *Client) Cat(ctx context.Context, cid string) (io.ReadCloser, error) {
 	if !c.started {
@@ -234,6 +336,266 @@ func (c *Client) Cat(ctx context.Context, cid string) (io.ReadCloser, error) {
 	return file, nil
 }
 
+// PutBlock adds a block fetched outside the node, e.g. from a gateway, to the
+// local blockstore. The block must already be verified against its CID.
+func (c *Client) PutBlock(ctx context.Context, block blocks.Block) error {
+	if !c.started {
+		return fmt.Errorf("node not started")
+	}
+
+	if err := c.node.Blocks.AddBlock(ctx, block); err != nil {
+		return fmt.Errorf("failed to add block %s: %w", block.Cid(), err)
+	}
+	return nil
+}
+
+// Pin pins content by CID so it survives garbage collection
+func (c *Client) Pin(ctx context.Context, cidStr string) error {
+	if !c.started {
+		return fmt.Errorf("node not started")
+	}
+
+	p, err := path.NewPath("/ipfs/" + cidStr)
+	if err != nil {
+		return fmt.Errorf("failed to parse path: %w", err)
+	}
+
+	if err := c.api.Pin().Add(ctx, p); err != nil {
+		return fmt.Errorf("failed to pin: %w", err)
+	}
+
+	return nil
+}
+
+// Unpin removes the pin of content by CID so garbage collection may remove it
+func (c *Client) Unpin(ctx context.Context, cidStr string) error {
+	if !c.started {
+		return fmt.Errorf("node not started")
+	}
+
+	p, err := path.NewPath("/ipfs/" + cidStr)
+	if err != nil {
+		return fmt.Errorf("failed to parse path: %w", err)
+	}
+
+	if err := c.api.Pin().Rm(ctx, p); err != nil {
+		return fmt.Errorf("failed to unpin: %w", err)
+	}
+
+	return nil
+}
+
+// IsPinned reports whether content is pinned by CID, directly or as part of a
+// recursive pin
+func (c *Client) IsPinned(ctx context.Context, cidStr string) (bool, error) {
+	if !c.started {
+		return false, fmt.Errorf("node not started")
+	}
+
+	p, err := path.NewPath("/ipfs/" + cidStr)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse path: %w", err)
+	}
+
+	_, pinned, err := c.api.Pin().IsPinned(ctx, p, options.Pin.IsPinned.WithType("all"))
+	if err != nil {
+		return false, fmt.Errorf("failed to check pin: %w", err)
+	}
+	return pinned, nil
+}
+
+// RecursivePins lists the CIDs of the node's recursive pins
+func (c *Client) RecursivePins(ctx context.Context) ([]string, error) {
+	if !c.started {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	// Ls sends the pins and closes the channel when done
+	ch := make(chan iface.Pin)
+	lsErr := make(chan error, 1)
+	go func() {
+		lsErr <- c.api.Pin().Ls(ctx, ch, options.Pin.Ls.Recursive())
+	}()
+
+	var pins []string
+	for pin := range ch {
+		pins = append(pins, pin.Path().RootCid().String())
+	}
+	if err := <-lsErr; err != nil {
+		return nil, fmt.Errorf("failed to list pins: %w", err)
+	}
+	return pins, nil
+}
+
+// AddIndex adds a collection index wrapped in a directory holding it as
+// IndexFileName, the layout publishers use, and pins the directory. It returns
+// the CIDs of the directory and of the index file.
+func (c *Client) AddIndex(ctx context.Context, data []byte) (rootCID, indexCID string, err error) {
+	if !c.started {
+		return "", "", fmt.Errorf("node not started")
+	}
+
+	dir := files.NewMapDirectory(map[string]files.Node{
+		IndexFileName: files.NewBytesFile(data),
+	})
+
+	root, err := c.api.Unixfs().Add(ctx, dir, options.Unixfs.Pin(true, IndexFileName))
+	if err != nil {
+		return "", "", fmt.Errorf("failed to add index directory: %w", err)
+	}
+
+	filePath, err := path.Join(root, IndexFileName)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to build index path: %w", err)
+	}
+
+	resolved, _, err := c.api.ResolvePath(ctx, filePath)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve index file: %w", err)
+	}
+
+	return root.RootCid().String(), resolved.RootCid().String(), nil
+}
+
+// EnsureKey returns the IPNS name of the named key in the node's keystore,
+// generating an Ed25519 key if it does not exist
+func (c *Client) EnsureKey(ctx context.Context, name string) (string, error) {
+	if !c.started {
+		return "", fmt.Errorf("node not started")
+	}
+
+	keys, err := c.api.Key().List(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	for _, k := range keys {
+		if k.Name() == name {
+			return strings.TrimPrefix(k.Path().String(), "/ipns/"), nil
+		}
+	}
+
+	k, err := c.api.Key().Generate(ctx, name, options.Key.Type(options.Ed25519Key))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate key %s: %w", name, err)
+	}
+
+	logger.Get().Infof("Generated IPNS key %s", name)
+	return strings.TrimPrefix(k.Path().String(), "/ipns/"), nil
+}
+
+// PublishIPNS points the IPNS name of the named key at cid with a record valid for lifetime
+func (c *Client) PublishIPNS(ctx context.Context, cid, key string, lifetime time.Duration) (string, error) {
+	if !c.started {
+		return "", fmt.Errorf("node not started")
+	}
+
+	p, err := path.NewPath("/ipfs/" + cid)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse path: %w", err)
+	}
+
+	name, err := c.api.Name().Publish(ctx, p, options.Name.Key(key), options.Name.ValidTime(lifetime))
+	if err != nil {
+		return "", fmt.Errorf("failed to publish IPNS: %w", err)
+	}
+
+	return name.String(), nil
+}
+
+// FetchStats describes the block transfer of a session-scoped fetch
+type FetchStats struct {
+	Blocks   int64
+	Duration time.Duration
+}
+
+// BlocksPerSecond returns the block fetch rate
+func (s *FetchStats) BlocksPerSecond() float64 {
+	if s.Duration <= 0 {
+		return 0
+	}
+	return float64(s.Blocks) / s.Duration.Seconds()
+}
+
+// CatWithSession fetches the whole DAG of a CID through a single bitswap
+// session, requesting up to parallelism blocks concurrently, and then returns
+// a reader over the (now local) file content
+func (c *Client) CatWithSession(ctx context.Context, cidStr string, parallelism int) (io.ReadCloser, *FetchStats, error) {
+	if !c.started {
+		return nil, nil, fmt.Errorf("node not started")
+	}
+
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+
+	// All block requests made with this context share one bitswap session
+	ctx = blockservice.ContextWithSession(ctx, c.node.Blocks)
+
+	p, err := path.NewPath("/ipfs/" + cidStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse path: %w", err)
+	}
+
+	resolved, _, err := c.api.ResolvePath(ctx, p)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve path: %w", err)
+	}
+
+	// Prefetch all blocks with bounded parallelism
+	var blocks atomic.Int64
+	var mu sync.Mutex
+	seen := cid.NewSet()
+
+	visit := func(k cid.Cid) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if !seen.Visit(k) {
+			return false
+		}
+		blocks.Add(1)
+		return true
+	}
+
+	start := time.Now()
+	getLinks := merkledag.GetLinksDirect(c.node.DAG)
+	if err := merkledag.Walk(ctx, getLinks, resolved.RootCid(), visit, merkledag.Concurrency(parallelism)); err != nil {
+		return nil, nil, fmt.Errorf("failed to fetch DAG: %w", err)
+	}
+
+	stats := &FetchStats{
+		Blocks:   blocks.Load(),
+		Duration: time.Since(start),
+	}
+
+	reader, err := c.Cat(ctx, cidStr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return reader, stats, nil
+}
+
+// RepoStat returns statistics of the embedded node's repository
+func (c *Client) RepoStat(ctx context.Context) (*RepoStats, error) {
+	if !c.started || c.node == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	stat, err := corerepo.RepoStat(ctx, c.node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repo stats: %w", err)
+	}
+
+	return &RepoStats{
+		RepoSize:   stat.RepoSize,
+		StorageMax: stat.StorageMax,
+		NumObjects: stat.NumObjects,
+		RepoPath:   stat.RepoPath,
+		Version:    stat.Version,
+	}, nil
+}
+
 // Subscribe subscribes to a PubSub topic
 func (c *Client) Subscribe(ctx context.Context, topic string) (*pubsub.Subscription, error) {
 	if !c.started || c.pubsub == nil {
@@ -248,6 +610,82 @@ func (c *Client) Subscribe(ctx context.Context, topic string) (*pubsub.Subscript
 	return sub, nil
 }
 
+// subscribeQueueSize is the number of received messages buffered by SubscribeDeduped
+const subscribeQueueSize = 32
+
+// SubscribeDeduped subscribes to a PubSub topic and delivers its messages until
+// ctx is done. GossipSub delivers a message once per mesh peer forwarding it
+// before it is marked seen; a message whose payload was already delivered
+// within window is dropped.
+func (c *Client) SubscribeDeduped(ctx context.Context, topic string, window time.Duration) (<-chan *pubsub.Message, error) {
+	sub, err := c.Subscribe(ctx, topic)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := dedupe.NewWindow(window)
+	go seen.Run(ctx)
+
+	messages := make(chan *pubsub.Message, subscribeQueueSize)
+	go func() {
+		defer close(messages)
+		defer sub.Cancel()
+
+		for {
+			msg, err := sub.Next(ctx)
+			if err != nil {
+				return
+			}
+			if !seen.First(msg.Data, time.Now()) {
+				continue
+			}
+
+			select {
+			case messages <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return messages, nil
+}
+
+// PublishToPubSub publishes a message to a PubSub topic
+func (c *Client) PublishToPubSub(ctx context.Context, topic string, data []byte) error {
+	if !c.started || c.api == nil {
+		return fmt.Errorf("node not started")
+	}
+
+	if err := c.api.PubSub().Publish(ctx, topic, data); err != nil {
+		return fmt.Errorf("failed to publish to topic %s: %w", topic, err)
+	}
+
+	return nil
+}
+
+// SigningKey returns the node's identity key for signing messages such as acks.
+// Only Ed25519 identities (the kubo default) are supported.
+func (c *Client) SigningKey() (ed25519.PrivateKey, error) {
+	if c.node == nil || c.node.PrivateKey == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	if c.node.PrivateKey.Type() != crypto.Ed25519 {
+		return nil, fmt.Errorf("node identity is a %s key, Ed25519 is required", c.node.PrivateKey.Type())
+	}
+
+	raw, err := c.node.PrivateKey.Raw()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read node identity key: %w", err)
+	}
+	if len(raw) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid node identity key size %d", len(raw))
+	}
+
+	return ed25519.PrivateKey(raw), nil
+}
+
 // Close gracefully shuts down the node
 func (c *Client) Close() error {
 	if !c.started {
@@ -0,0 +1,133 @@
+//go:build integration
+
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/ipfs/boxo/files"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// freePort returns a TCP port that is currently unused
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// startTestNode starts an embedded node with a temporary repo
+func startTestNode(t *testing.T, name string) *Client {
+	t.Helper()
+
+	cfg := &config.EmbeddedIPFSConfig{
+		RepoPath:    filepath.Join(t.TempDir(), name),
+		SwarmPort:   freePort(t),
+		APIPort:     freePort(t),
+		GatewayPort: freePort(t),
+	}
+
+	client, err := NewClient(cfg)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if err := client.Start(); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// connect dials from c to the provider node
+func connect(ctx context.Context, t *testing.T, c, provider *Client) {
+	t.Helper()
+
+	info := peer.AddrInfo{
+		ID:    provider.node.Identity,
+		Addrs: provider.node.PeerHost.Addrs(),
+	}
+	if err := c.api.Swarm().Connect(ctx, info); err != nil {
+		t.Fatalf("failed to connect to provider: %v", err)
+	}
+}
+
+// TestCatWithSession fetches the same multi-block file from a provider once
+// through the plain Unixfs path and once through a bitswap session on two
+// otherwise identical nodes, and compares content and transfer rates.
+//
+//	go test -tags integration -run TestCatWithSession -v ./internal/ipfs
+func TestCatWithSession(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	provider := startTestNode(t, "provider")
+	naive := startTestNode(t, "naive")
+	session := startTestNode(t, "session")
+
+	// 32 MiB is a few hundred 256 KiB leaf blocks
+	data := make([]byte, 32<<20)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	added, err := provider.api.Unixfs().Add(ctx, files.NewBytesFile(data))
+	if err != nil {
+		t.Fatalf("failed to add test file: %v", err)
+	}
+	cidStr := added.RootCid().String()
+
+	connect(ctx, t, naive, provider)
+	connect(ctx, t, session, provider)
+
+	start := time.Now()
+	reader, err := naive.Cat(ctx, cidStr)
+	if err != nil {
+		t.Fatalf("naive Cat: %v", err)
+	}
+	naiveData, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatalf("naive read: %v", err)
+	}
+	naiveDuration := time.Since(start)
+
+	start = time.Now()
+	reader, stats, err := session.CatWithSession(ctx, cidStr, 16)
+	if err != nil {
+		t.Fatalf("CatWithSession: %v", err)
+	}
+	sessionData, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		t.Fatalf("session read: %v", err)
+	}
+	sessionDuration := time.Since(start)
+
+	if !bytes.Equal(naiveData, data) || !bytes.Equal(sessionData, data) {
+		t.Fatal("fetched content differs from the added file")
+	}
+	if stats.Blocks < 2 {
+		t.Fatalf("expected a multi-block DAG, fetched %d blocks", stats.Blocks)
+	}
+
+	naiveRate := float64(stats.Blocks) / naiveDuration.Seconds()
+	t.Logf("naive:   %d blocks in %v (%.1f blocks/s)", stats.Blocks, naiveDuration, naiveRate)
+	t.Logf("session: %d blocks in %v (%.1f blocks/s, %.1f during prefetch)",
+		stats.Blocks, sessionDuration, float64(stats.Blocks)/sessionDuration.Seconds(), stats.BlocksPerSecond())
+
+	if sessionDuration >= naiveDuration {
+		t.Errorf("session fetch (%v) was not faster than the naive fetch (%v)", sessionDuration, naiveDuration)
+	}
+}
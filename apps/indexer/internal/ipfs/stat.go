@@ -0,0 +1,43 @@
+package ipfs
+
+import (
+	"fmt"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+	ipld "github.com/ipfs/go-ipld-format"
+)
+
+// statNode describes content from its root node. The size of a file is read
+// from its UnixFS metadata; a directory's is the cumulative size its root
+// records for the DAG below it.
+func statNode(node ipld.Node) (*StatResult, error) {
+	result := &StatResult{CID: node.Cid().String(), NumLinks: len(node.Links()), Type: StatTypeFile}
+
+	switch n := node.(type) {
+	case *merkledag.RawNode:
+		// A single-block file added with raw leaves
+		result.Size = uint64(len(n.RawData()))
+	case *merkledag.ProtoNode:
+		fsNode, err := unixfs.FSNodeFromBytes(n.Data())
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode UnixFS node %s: %w", result.CID, err)
+		}
+		switch fsNode.Type() {
+		case unixfs.TFile, unixfs.TRaw:
+			result.Size = fsNode.FileSize()
+		case unixfs.TDirectory, unixfs.THAMTShard:
+			size, err := n.Size()
+			if err != nil {
+				return nil, fmt.Errorf("failed to get size of %s: %w", result.CID, err)
+			}
+			result.Size, result.Type = size, StatTypeDir
+		default:
+			return nil, fmt.Errorf("unsupported UnixFS node type %s of %s", fsNode.Type(), result.CID)
+		}
+	default:
+		return nil, fmt.Errorf("%s is not a UnixFS node", result.CID)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,57 @@
+package ipfs
+
+import (
+	"testing"
+
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/ipld/unixfs"
+)
+
+func TestStatNode(t *testing.T) {
+	raw := merkledag.NewRawNode([]byte("collection"))
+	stat, err := statNode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Type != StatTypeFile || stat.Size != 10 || stat.NumLinks != 0 || stat.CID != raw.Cid().String() {
+		t.Errorf("raw leaf stat = %+v", stat)
+	}
+
+	// A chunked file's size comes from its metadata, not from its blocks
+	file := unixfs.NewFSNode(unixfs.TFile)
+	file.AddBlockSize(1 << 20)
+	file.AddBlockSize(1 << 20)
+	data, err := file.GetBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := merkledag.NodeWithData(data)
+	for _, chunk := range []string{"a", "b"} {
+		if err := root.AddNodeLink(chunk, merkledag.NewRawNode([]byte(chunk))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stat, err = statNode(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat.Type != StatTypeFile || stat.Size != 2<<20 || stat.NumLinks != 2 {
+		t.Errorf("chunked file stat = %+v", stat)
+	}
+
+	dir := unixfs.EmptyDirNode()
+	if err := dir.AddNodeLink(IndexFileName, raw); err != nil {
+		t.Fatal(err)
+	}
+	stat, err = statNode(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := dir.Size(); stat.Type != StatTypeDir || stat.Size != want || stat.NumLinks != 1 {
+		t.Errorf("directory stat = %+v, want size %d", stat, want)
+	}
+
+	if _, err := statNode(merkledag.NodeWithData([]byte("not unixfs"))); err == nil {
+		t.Error("statNode accepted a node without UnixFS data")
+	}
+}
@@ -0,0 +1,6 @@
+package ipfs
+
+import "github.com/atregu/ipfs-common/stats"
+
+// RepoStats contains IPFS repository usage statistics
+type RepoStats = stats.RepoStats
@@ -0,0 +1,64 @@
+package parser
+
+import (
+	"math/rand/v2"
+
+	"github.com/atregu/ipfs-common/claim"
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+)
+
+// claimVerifier decides which items of one index are endorsed: items whose claim
+// by the publisher key was verified. In sample mode a random share of the signed
+// items is verified and the others are endorsed on the strength of the sample;
+// after the first failure every remaining item is verified.
+type claimVerifier struct {
+	publicKey string  // Base64-encoded Ed25519 key of the collection's publisher
+	rate      float64 // Share of signed items verified; 1 verifies all
+	verified  int
+	failed    int
+}
+
+// SetClaims enables the verification of per-record claims; without it no item is endorsed
+func (p *Parser) SetClaims(cfg *config.ClaimsConfig) {
+	p.claims = cfg
+}
+
+// newClaimVerifier returns a verifier for the items of collection, expecting about
+// records signed items in sample mode (0 verifies all). It returns nil if claims
+// are not verified or the publisher key is unavailable.
+func (p *Parser) newClaimVerifier(collection *database.Collection, records int) *claimVerifier {
+	if p.claims == nil || p.claims.Verify == config.ClaimVerifyOff {
+		return nil
+	}
+
+	publisher, err := p.db.GetPublisher(collection.PublisherID)
+	if err != nil {
+		p.log.Warnf("Claims of collection ID=%d are not verified: %v", collection.ID, err)
+		return nil
+	}
+
+	rate := 1.0
+	if p.claims.Verify == config.ClaimVerifySample && records > p.claims.SampleSize {
+		rate = float64(p.claims.SampleSize) / float64(records)
+	}
+	return &claimVerifier{publicKey: publisher.PublicKey, rate: rate}
+}
+
+// endorse reports whether item is endorsed, verifying its claim if it is sampled
+func (v *claimVerifier) endorse(item *ContentItem) (bool, error) {
+	if v == nil || item.Signature == "" {
+		return false, nil
+	}
+	if v.rate < 1 && rand.Float64() >= v.rate {
+		return true, nil
+	}
+
+	if err := claim.Verify(v.publicKey, item.CID, item.Filename, item.Size, item.Signature); err != nil {
+		v.failed++
+		v.rate = 1
+		return false, err
+	}
+	v.verified++
+	return true, nil
+}
@@ -0,0 +1,150 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/atregu/ipfs-common/extensions"
+	"github.com/atregu/ipfs-indexer/internal/database"
+)
+
+// Delta operations
+const (
+	deltaOpUpsert = "upsert"
+	deltaOpRemove = "remove"
+)
+
+// deltaType is the value of the type field that marks a delta header line
+const deltaType = "delta"
+
+// DeltaHeader is the first line of a delta file published alongside an index
+type DeltaHeader struct {
+	Type         string `json:"type"`
+	Version      int    `json:"version"`
+	BaseVersion  int    `json:"baseVersion"`
+	BaseIndexCID string `json:"baseIndexCID"`
+	ItemCount    int    `json:"itemCount"`
+}
+
+// deltaLine is a single change in a delta file
+type deltaLine struct {
+	Op string `json:"op"`
+	ContentItem
+}
+
+// ParseDeltaHeader parses the header line of a delta file
+func ParseDeltaHeader(content []byte) (*DeltaHeader, error) {
+	line, _, _ := bytes.Cut(content, []byte("\n"))
+
+	var header DeltaHeader
+	if err := json.Unmarshal(line, &header); err != nil {
+		return nil, fmt.Errorf("failed to parse delta header: %w", err)
+	}
+	if header.Type != deltaType {
+		return nil, fmt.Errorf("not a delta file: type %q", header.Type)
+	}
+
+	return &header, nil
+}
+
+// ApplyDelta stores the items of collection by copying the items of base and
+// applying the delta to them. The result must contain exactly the number of
+// items declared in the delta header and announced for the collection; on any
+// error or mismatch the copied items are removed so the caller can fall back
+// to parsing the full index.
+func (p *Parser) ApplyDelta(base, collection *database.Collection, content []byte) (*ParseResult, error) {
+	header, err := ParseDeltaHeader(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if header.BaseVersion != base.Version || header.BaseIndexCID != base.IndexCID {
+		return nil, fmt.Errorf("delta is based on version %d (%s), have version %d (%s)",
+			header.BaseVersion, header.BaseIndexCID, base.Version, base.IndexCID)
+	}
+
+	if p.limits != nil && p.limits.MaxItemsPerCollection > 0 && header.ItemCount > p.limits.MaxItemsPerCollection {
+		return nil, fmt.Errorf("delta declares %d items, over the limit of %d", header.ItemCount, p.limits.MaxItemsPerCollection)
+	}
+
+	p.log.Infof("Applying delta to collection ID=%d from base collection ID=%d (version %d)", collection.ID, base.ID, base.Version)
+
+	result, err := p.applyDelta(base, collection, header, content)
+	if err != nil {
+		if cleanupErr := p.db.DeleteCollectionItems(collection.ID); cleanupErr != nil {
+			p.log.Errorf("Failed to remove partially applied delta of collection ID=%d: %v", collection.ID, cleanupErr)
+		}
+		return nil, err
+	}
+
+	p.log.Infof("Applied delta to collection ID=%d: %d items", collection.ID, result.Stored)
+	return result, nil
+}
+
+// applyDelta copies the base items and applies each change, then verifies the item count
+func (p *Parser) applyDelta(base, collection *database.Collection, header *DeltaHeader, content []byte) (*ParseResult, error) {
+	if err := p.db.CopyCollectionItems(base.ID, collection.ID); err != nil {
+		return nil, err
+	}
+
+	// Deltas are small, so every signed upsert is verified
+	claims := p.newClaimVerifier(collection, 0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Bytes()
+		if lineNum == 1 || len(line) == 0 {
+			continue
+		}
+
+		var change deltaLine
+		if err := json.Unmarshal(line, &change); err != nil {
+			return nil, fmt.Errorf("failed to parse delta line %d: %w", lineNum, err)
+		}
+		if change.CID == "" {
+			return nil, fmt.Errorf("delta line %d: missing CID", lineNum)
+		}
+
+		switch change.Op {
+		case deltaOpRemove:
+			if err := p.db.DeleteIndexItem(collection.ID, change.CID); err != nil {
+				return nil, err
+			}
+		case deltaOpUpsert:
+			change.Extension = extensions.Normalize(change.Extension)
+			if change.Filename == "" || change.Extension == "" {
+				return nil, fmt.Errorf("delta line %d: missing required fields (filename or extension)", lineNum)
+			}
+			endorsed, err := claims.endorse(&change.ContentItem)
+			if err != nil {
+				p.log.Warnf("Claim of %s in delta line %d does not verify: %v", change.CID, lineNum, err)
+			}
+			change.Endorsed = endorsed
+			if err := p.storeItem(collection, &change.ContentItem); err != nil {
+				return nil, fmt.Errorf("failed to store item from delta line %d: %w", lineNum, err)
+			}
+		default:
+			return nil, fmt.Errorf("delta line %d: unknown op %q", lineNum, change.Op)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading delta content: %w", err)
+	}
+
+	count, err := p.db.CountCollectionItems(collection.ID)
+	if err != nil {
+		return nil, err
+	}
+	if count != header.ItemCount {
+		return nil, fmt.Errorf("delta produced %d items, header declares %d", count, header.ItemCount)
+	}
+	if collection.AnnouncedSize != nil && count != *collection.AnnouncedSize {
+		return nil, fmt.Errorf("delta produced %d items, announcement declares %d", count, *collection.AnnouncedSize)
+	}
+
+	return &ParseResult{Stored: count}, nil
+}
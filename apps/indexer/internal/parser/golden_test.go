@@ -0,0 +1,332 @@
+package parser
+
+import (
+	"bytes"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+)
+
+// testdataDir is the repository-wide fixture directory shared with the publisher
+const testdataDir = "../../../../testdata"
+
+// baseIndexCID is the CID the v2 delta golden names as its base (index-v1)
+const baseIndexCID = "bafkreiaxvuuhj3gyz3ypmcrvqsgdmsyuzx3pw2qd5fhsqqbcvhyhqk2exm"
+
+// readGolden reads a golden file from testdata/
+func readGolden(t *testing.T, name string) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(testdataDir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// storedItems returns "filename|CID|group" for every item of a collection, sorted
+func storedItems(t *testing.T, db *database.DB, collectionID int64) []string {
+	t.Helper()
+
+	items, err := db.GetCollectionItems(collectionID, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result []string
+	for _, item := range items {
+		result = append(result, item.Filename+"|"+item.CID+"|"+item.Group)
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestParseGoldenIndexV1(t *testing.T) {
+	p, db, collection := newTestParser(t, &config.LimitsConfig{})
+
+	result, err := p.ParseAndStore(collection, bytes.NewReader(readGolden(t, "index-v1.ndjson")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Stored != 3 || result.Errors != 0 {
+		t.Fatalf("result = %+v, want 3 stored without errors", *result)
+	}
+
+	want := []string{
+		"movie.mkv|bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi|",
+		"song.mp3|QmepHP9vMsBZB7w15yEqnUzTupNoQqnG9Lj3VhBQAvxg6B|",
+		"test-15mb.mp3|QmaYsXFBVpMMk74Ed78342XSH26wQZs9Y8PyAUWNCxzyZp|",
+	}
+	assertItems(t, storedItems(t, db, collection.ID), want)
+}
+
+func TestParseGoldenIndexV2(t *testing.T) {
+	p, db, collection := newTestParser(t, &config.LimitsConfig{})
+
+	result, err := p.ParseAndStore(collection, bytes.NewReader(readGolden(t, "index-v2.ndjson")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Stored != 3 || result.Errors != 0 {
+		t.Fatalf("result = %+v, want 3 stored without errors", *result)
+	}
+
+	assertItems(t, storedItems(t, db, collection.ID), goldenV2Items)
+
+	// The header carries the collection metadata
+	stored, err := db.GetCollection(collection.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Visibility != database.VisibilityUnlisted || stored.License != "CC-BY-4.0" {
+		t.Errorf("visibility/license = %q/%q, want unlisted/CC-BY-4.0", stored.Visibility, stored.License)
+	}
+}
+
+func TestApplyGoldenDeltaV2(t *testing.T) {
+	p, db, base := newTestParser(t, &config.LimitsConfig{})
+
+	// base is version 1 in newTestParser; the delta golden is against version 3
+	if _, err := p.ParseAndStore(base, bytes.NewReader(readGolden(t, "index-v1.ndjson"))); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetCollectionIndexCID(base.ID, baseIndexCID); err != nil {
+		t.Fatal(err)
+	}
+	base.Version = 3
+	base.IndexCID = baseIndexCID
+
+	size := 3
+	collection, err := db.CreateCollection(base.HostID, base.PublisherID, 4, base.IPNS, &size, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := p.ApplyDelta(base, collection, readGolden(t, "delta-v2.ndjson"))
+	if err != nil {
+		t.Fatalf("ApplyDelta: %v", err)
+	}
+	if result.Stored != 3 {
+		t.Errorf("Stored = %d, want 3", result.Stored)
+	}
+
+	// Applying the delta must give the same items as parsing the full v2 index
+	assertItems(t, storedItems(t, db, collection.ID), goldenV2Items)
+}
+
+func TestApplyDeltaRejectsWrongBase(t *testing.T) {
+	p, db, base := newTestParser(t, &config.LimitsConfig{})
+	base.Version = 3
+	base.IndexCID = "bafkreiother"
+
+	size := 3
+	collection, err := db.CreateCollection(base.HostID, base.PublisherID, 4, base.IPNS, &size, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.ApplyDelta(base, collection, readGolden(t, "delta-v2.ndjson")); err == nil {
+		t.Fatal("expected delta against a different base index to fail")
+	}
+	if items := storedItems(t, db, collection.ID); len(items) != 0 {
+		t.Errorf("failed delta left %d items behind", len(items))
+	}
+}
+
+// goldenV2Items are the items of index-v2.ndjson as stored by the indexer
+var goldenV2Items = []string{
+	"clip.webm|bafybeie5gq4jxvzmsym6hjlqxei4tmarjgczmugl7mr2ezecq2ktmjvxky|Videos",
+	"song.mp3|QmepHP9vMsBZB7w15yEqnUzTupNoQqnG9Lj3VhBQAvxg6B|Artist/Album/Disc 1",
+	"test-15mb.mp3|QmaYsXFBVpMMk74Ed78342XSH26wQZs9Y8PyAUWNCxzyZp|",
+}
+
+// assertItems compares sorted item lists
+func assertItems(t *testing.T, got, want []string) {
+	t.Helper()
+
+	if len(got) != len(want) {
+		t.Fatalf("got %d items %v, want %d %v", len(got), got, len(want), want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("item %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+// goldenPublisherKey is the base64-encoded public key of testdata/keys
+const goldenPublisherKey = "vXxDyIsjLkZ6pTd4Eln4G5b5UQW5s9CINJO/1qQwb3Y="
+
+// newSignedCollection returns a collection of the golden publisher in p's database
+func newSignedCollection(t *testing.T, db *database.DB, size int) *database.Collection {
+	t.Helper()
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher(goldenPublisherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection, err := db.CreateCollection(host.ID, publisher.ID, 1, "k51signed", &size, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return collection
+}
+
+// endorsedItems returns the filenames of the endorsed items of a collection, sorted
+func endorsedItems(t *testing.T, db *database.DB, collectionID int64) []string {
+	t.Helper()
+
+	items, err := db.GetCollectionItems(collectionID, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result []string
+	for _, item := range items {
+		if item.Endorsed {
+			result = append(result, item.Filename)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func TestParseGoldenSignedIndex(t *testing.T) {
+	for _, mode := range []string{config.ClaimVerifySample, config.ClaimVerifyAll} {
+		p, db, _ := newTestParser(t, &config.LimitsConfig{})
+		p.SetClaims(&config.ClaimsConfig{Verify: mode, SampleSize: 1})
+		collection := newSignedCollection(t, db, 3)
+
+		result, err := p.ParseAndStore(collection, bytes.NewReader(readGolden(t, "index-v2-signed.ndjson")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Stored != 3 || result.Endorsed != 3 || result.BadClaims != 0 {
+			t.Errorf("%s: result = %+v, want 3 stored and endorsed", mode, *result)
+		}
+		assertItems(t, storedItems(t, db, collection.ID), goldenV2Items)
+		assertItems(t, endorsedItems(t, db, collection.ID), []string{"clip.webm", "song.mp3", "test-15mb.mp3"})
+	}
+}
+
+func TestTamperedClaimsAreNotEndorsed(t *testing.T) {
+	content := readGolden(t, "index-v2-signed.ndjson")
+	// A node serving other content for song.mp3 cannot sign the new CID
+	content = bytes.Replace(content, []byte("QmepHP9vMsBZB7w15yEqnUzTupNoQqnG9Lj3VhBQAvxg6B"), []byte("QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG"), 1)
+	// and a record whose size was changed no longer matches its claim
+	content = bytes.Replace(content, []byte(`"size":1048576`), []byte(`"size":1048577`), 1)
+
+	p, db, _ := newTestParser(t, &config.LimitsConfig{})
+	p.SetClaims(&config.ClaimsConfig{Verify: config.ClaimVerifyAll})
+	collection := newSignedCollection(t, db, 3)
+
+	result, err := p.ParseAndStore(collection, bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Stored != 3 || result.Endorsed != 1 || result.BadClaims != 2 {
+		t.Errorf("result = %+v, want 3 stored, 1 endorsed, 2 bad claims", *result)
+	}
+	assertItems(t, endorsedItems(t, db, collection.ID), []string{"test-15mb.mp3"})
+
+	// Without verification nothing is endorsed
+	p.SetClaims(&config.ClaimsConfig{Verify: config.ClaimVerifyOff})
+	other := newSignedCollection(t, db, 3)
+	if _, err := p.ParseAndStore(other, bytes.NewReader(readGolden(t, "index-v2-signed.ndjson"))); err != nil {
+		t.Fatal(err)
+	}
+	if endorsed := endorsedItems(t, db, other.ID); len(endorsed) != 0 {
+		t.Errorf("endorsed %v with claims.verify off", endorsed)
+	}
+}
+
+func TestPreviewStoresNothing(t *testing.T) {
+	p, db, _ := newTestParser(t, &config.LimitsConfig{})
+	collection := newSignedCollection(t, db, 3)
+
+	preview, err := p.Preview(collection, bytes.NewReader(readGolden(t, "index-v2-signed.ndjson")), goldenPublisherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Items != 3 || preview.CIDs != 3 || preview.Errors != 0 || preview.Truncated {
+		t.Errorf("preview = %+v, want 3 items", *preview)
+	}
+	if preview.Header == nil || preview.Header.Visibility != "unlisted" || preview.Header.License != "CC-BY-4.0" || preview.Header.Generator != "ipfs-publisher/0.1.0" {
+		t.Errorf("header = %+v, want the index header", preview.Header)
+	}
+	if preview.Schema != 2 {
+		t.Errorf("schema = %d, want 2", preview.Schema)
+	}
+	if !maps.Equal(preview.Extensions, map[string]int{"mp3": 2, "webm": 1}) {
+		t.Errorf("extensions = %v", preview.Extensions)
+	}
+	assertItems(t, preview.Samples, []string{"test-15mb.mp3", "song.mp3", "clip.webm"})
+	if !preview.ClaimsVerified || preview.Signed != 3 || preview.Endorsed != 3 || preview.BadClaims != 0 {
+		t.Errorf("claims = %d signed, %d endorsed, %d bad, want all 3 verified", preview.Signed, preview.Endorsed, preview.BadClaims)
+	}
+
+	// Neither items, the header nor any progress was stored
+	if items := storedItems(t, db, collection.ID); len(items) != 0 {
+		t.Errorf("stored %v", items)
+	}
+	stored, err := db.GetCollection(collection.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Visibility == "unlisted" || stored.License != "" || stored.IngestOffset != 0 || stored.ItemsIngested != 0 {
+		t.Errorf("collection changed by a preview: %+v", *stored)
+	}
+}
+
+func TestPreviewWithoutKey(t *testing.T) {
+	p, db, _ := newTestParser(t, &config.LimitsConfig{})
+	p.SetClaims(&config.ClaimsConfig{Verify: config.ClaimVerifyAll})
+	collection := newSignedCollection(t, db, 3)
+
+	// The configured claims mode does not apply: without a key nothing is verified
+	preview, err := p.Preview(collection, bytes.NewReader(readGolden(t, "index-v2-signed.ndjson")), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.ClaimsVerified || preview.Signed != 3 || preview.Endorsed != 0 || preview.BadClaims != 0 {
+		t.Errorf("preview = %+v, want 3 unverified claims", *preview)
+	}
+}
+
+func TestIndexSchema(t *testing.T) {
+	p, _, collection := newTestParser(t, &config.LimitsConfig{})
+
+	v2 := string(readGolden(t, "index-v2.ndjson"))
+	tests := map[string]struct {
+		index  string
+		schema int
+	}{
+		"headerless":   {string(readGolden(t, "index-v1.ndjson")), 1},
+		"no schema":    {strings.Replace(v2, `"schema":2,`, "", 1), 1},
+		"schema 2":     {v2, 2},
+		"newer schema": {strings.Replace(v2, `"schema":2`, `"schema":3`, 1), 3},
+	}
+
+	for name, tt := range tests {
+		preview, err := p.Preview(collection, strings.NewReader(tt.index), "")
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if preview.Schema != tt.schema {
+			t.Errorf("%s: schema = %d, want %d", name, preview.Schema, tt.schema)
+		}
+		// An index of a newer schema is parsed as far as the parser knows it
+		if preview.Items != 3 || preview.Errors != 0 {
+			t.Errorf("%s: %d items, %d errors, want 3 items", name, preview.Items, preview.Errors)
+		}
+	}
+}
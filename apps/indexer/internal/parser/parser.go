@@ -5,7 +5,14 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
+	"mime"
+	"strings"
+	"time"
+	"unicode"
 
+	"github.com/atregu/ipfs-common/extensions"
+	"github.com/atregu/ipfs-indexer/internal/config"
 	"github.com/atregu/ipfs-indexer/internal/database"
 	"github.com/sirupsen/logrus"
 )
@@ -16,33 +23,194 @@ type ContentItem struct {
 	CID       string `json:"CID"`
 	Filename  string `json:"filename"`
 	Extension string `json:"extension"`
+	Group     string `json:"group,omitempty"`     // Parent directory relative to the publisher's root
+	Size      int64  `json:"size,omitempty"`      // File size in bytes, covered by the claim
+	ModTime   int64  `json:"mtime,omitempty"`     // Unix time of the file's last modification
+	MimeType  string `json:"mimeType,omitempty"`  // e.g. "audio/mpeg"
+	Signature string `json:"sig,omitempty"`       // Publisher's claim over CID, filename and size
+	Publisher string `json:"publisher,omitempty"` // Original publisher's public key in an aggregate collection
+	Endorsed  bool   `json:"-"`                   // The claim was verified (or sampled)
+}
+
+// Header is the optional first line of a collection index, marked by "type":"header"
+type Header struct {
+	Type       string `json:"type"`
+	Schema     int    `json:"schema,omitempty"`
+	Generator  string `json:"generator,omitempty"` // e.g. "ipfs-publisher/0.1.0"
+	CreatedAt  int64  `json:"createdAt,omitempty"` // Unix time the index version was created
+	Visibility string `json:"visibility,omitempty"`
+	License    string `json:"license,omitempty"`
+}
+
+// headerType is the value of the type field that marks a header line
+const headerType = "header"
+
+// SchemaVersion is the newest index schema the parser understands. Indexes
+// without a header, or with a header without a schema, are schema 1.
+const SchemaVersion = 2
+
+// schemaVersion returns the schema declared by the header
+func (h *Header) schemaVersion() int {
+	if h.Schema == 0 {
+		return 1
+	}
+	return h.Schema
+}
+
+// ParseResult summarizes the outcome of parsing a collection
+type ParseResult struct {
+	Stored      int  // Number of items stored in the database
+	Errors      int  // Number of lines that could not be parsed
+	StoreErrors int  // Number of valid items that could not be stored after retries
+	Truncated   bool // True if parsing stopped at the per-collection item limit
+	Endorsed    int  // Number of items stored as endorsed
+	BadClaims   int  // Number of items whose claim did not verify
+}
+
+// previewSamples is the number of filenames a preview lists
+const previewSamples = 10
+
+// Preview summarizes what parsing an index would store, see Parser.Preview
+type Preview struct {
+	Items          int            `json:"items"`     // Items that would be stored
+	CIDs           int            `json:"cids"`      // Distinct CIDs among the items; items sharing a CID are stored once
+	Errors         int            `json:"errors"`    // Lines that could not be parsed
+	Truncated      bool           `json:"truncated"` // Parsing stopped at limits.max_items_per_collection
+	Extensions     map[string]int `json:"extensions"`
+	Samples        []string       `json:"samples"` // Filenames of the first items
+	Schema         int            `json:"schema"`  // Index schema, 1 for an index without header
+	Header         *Header        `json:"header,omitempty"`
+	Signed         int            `json:"signed"`         // Items carrying a claim
+	ClaimsVerified bool           `json:"claimsVerified"` // False if no publisher key was given to verify claims against
+	Endorsed       int            `json:"endorsed"`       // Items whose claim verified
+	BadClaims      int            `json:"badClaims"`      // Items whose claim did not verify
+
+	cids map[string]struct{}
+}
+
+// add counts an item that would be stored
+func (pv *Preview) add(item *ContentItem) {
+	pv.Extensions[item.Extension]++
+	pv.cids[item.CID] = struct{}{}
+	if len(pv.Samples) < previewSamples {
+		pv.Samples = append(pv.Samples, item.Filename)
+	}
+	if item.Signature != "" {
+		pv.Signed++
+	}
 }
 
 // Parser handles parsing collection files
 type Parser struct {
-	db  *database.DB
-	log *logrus.Logger
+	db            *database.DB
+	limits        *config.LimitsConfig
+	insertRetries int
+	chunkSize     int                  // Items stored per transaction
+	claims        *config.ClaimsConfig // nil = claims are not verified
+	log           *logrus.Logger
 }
 
-// NewParser creates a new parser
-func NewParser(db *database.DB, log *logrus.Logger) *Parser {
+// NewParser creates a new parser. insertRetries is the number of times a
+// transient database error is retried before an item is counted as failed.
+func NewParser(db *database.DB, limits *config.LimitsConfig, insertRetries int, log *logrus.Logger) *Parser {
 	return &Parser{
-		db:  db,
-		log: log,
+		db:            db,
+		limits:        limits,
+		insertRetries: insertRetries,
+		chunkSize:     parseChunkSize,
+		log:           log,
 	}
 }
 
-// ParseAndStore parses a JSONL collection file and stores items in the database
-func (p *Parser) ParseAndStore(collection *database.Collection, content []byte) (int, error) {
-	p.log.Infof("Parsing collection ID=%d...", collection.ID)
+// ParseAndStore parses a JSONL collection index read from r and stores its
+// items in the database in chunks, each committed in one transaction together
+// with the progress of the collection. A collection with recorded progress
+// resumes after the last committed line instead of parsing the index again.
+func (p *Parser) ParseAndStore(collection *database.Collection, r io.Reader) (*ParseResult, error) {
+	expected := 0
+	if collection.AnnouncedSize != nil {
+		expected = *collection.AnnouncedSize
+	}
+	return p.parse(collection, r, p.newClaimVerifier(collection, expected), nil)
+}
+
+// Preview parses a collection index read from r like ParseAndStore, but only
+// summarizes it: nothing is written to the database, header metadata included.
+// Every claim is verified against publicKey, the publisher's base64-encoded
+// key; without it claims are not verified. On a read error the summary of the
+// lines read so far is returned with the error.
+func (p *Parser) Preview(collection *database.Collection, r io.Reader, publicKey string) (*Preview, error) {
+	var claims *claimVerifier
+	if publicKey != "" {
+		claims = &claimVerifier{publicKey: publicKey, rate: 1}
+	}
+
+	preview := &Preview{Extensions: make(map[string]int), Schema: 1, ClaimsVerified: claims != nil, cids: make(map[string]struct{})}
+	result, err := p.parse(collection, r, claims, preview)
+	preview.Items = result.Stored
+	preview.CIDs = len(preview.cids)
+	preview.Errors = result.Errors
+	preview.Truncated = result.Truncated
+	preview.Endorsed = result.Endorsed
+	preview.BadClaims = result.BadClaims
+	return preview, err
+}
 
-	scanner := bufio.NewScanner(bytes.NewReader(content))
+// parse parses an index, verifying claims with claims unless it is nil. With
+// preview set it runs dry: items and the header are added to the preview
+// instead of being stored, and the collection's progress is neither read nor
+// recorded.
+func (p *Parser) parse(collection *database.Collection, r io.Reader, claims *claimVerifier, preview *Preview) (*ParseResult, error) {
+	name := fmt.Sprintf("collection ID=%d", collection.ID)
+	resumeAt := collection.IngestOffset
+	switch {
+	case preview != nil:
+		name = fmt.Sprintf("preview of IPNS=%s", collection.IPNS)
+		resumeAt = 0
+		p.log.Infof("Parsing %s...", name)
+	case resumeAt > 0:
+		p.log.Infof("Resuming %s at line %d, %d items already stored", name, resumeAt+1, collection.ItemsIngested)
+	default:
+		p.log.Infof("Parsing %s...", name)
+	}
+
+	scanner := newLineScanner(r)
 	lineNum := 0
 	itemCount := 0
+	if preview == nil {
+		itemCount = collection.ItemsIngested
+	}
 	errorCount := 0
+	storeErrorCount := 0
+	truncated := false
+	batch := make([]ContentItem, 0, p.chunkSize)
+
+	maxItems := 0
+	if p.limits != nil {
+		maxItems = p.limits.MaxItemsPerCollection
+	}
+
+	endorsedCount := 0
+
+	// storeChunk stores the batch and records the progress up to the current
+	// line. Once a chunk fails to store, the progress stays at the chunk
+	// before it so the failed items are parsed again on the next attempt.
+	storeChunk := func() {
+		var at *progress
+		if storeErrorCount == 0 {
+			at = &progress{items: itemCount, offset: lineNum}
+		}
+		failed := p.storeBatch(collection, batch, at)
+		storeErrorCount += failed
+		itemCount -= failed
+		batch = batch[:0]
+	}
 
 	for scanner.Scan() {
 		lineNum++
+		if lineNum <= resumeAt {
+			continue
+		}
 		line := scanner.Text()
 
 		// Skip empty lines
@@ -50,43 +218,327 @@ func (p *Parser) ParseAndStore(collection *database.Collection, content []byte)
 			continue
 		}
 
+		// The first line may be a header carrying the schema and collection
+		// metadata. Fields of a newer schema are ignored like unknown fields
+		// of items, so such an index is parsed as far as this parser knows it.
+		if lineNum == 1 {
+			var header Header
+			if err := json.Unmarshal([]byte(line), &header); err == nil && header.Type == headerType {
+				if schema := header.schemaVersion(); schema > SchemaVersion {
+					p.log.Warnf("%s has index schema %d, newer than the supported schema %d; parsing the known fields", name, schema, SchemaVersion)
+				}
+				if preview != nil {
+					preview.Schema = header.schemaVersion()
+					preview.Header = &header
+				} else {
+					p.applyHeader(collection, &header)
+				}
+				continue
+			}
+		}
+
 		// Parse the line as JSON
 		var item ContentItem
 		if err := json.Unmarshal([]byte(line), &item); err != nil {
-			p.log.Warnf("Failed to parse line %d in collection ID=%d: %v", lineNum, collection.ID, err)
+			p.log.Warnf("Failed to parse line %d in %s: %v", lineNum, name, err)
 			errorCount++
 			continue
 		}
 
-		// Validate required fields
+		// Validate required fields; extensions are matched in the normalized form
+		item.Extension = extensions.Normalize(item.Extension)
 		if item.CID == "" || item.Filename == "" || item.Extension == "" {
-			p.log.Warnf("Skipping line %d in collection ID=%d: missing required fields (CID, filename, or extension)", lineNum, collection.ID)
+			p.log.Warnf("Skipping line %d in %s: missing required fields (CID, filename, or extension)", lineNum, name)
 			errorCount++
 			continue
 		}
 
-		// Store or update the item in the database
-		if err := p.db.CreateOrUpdateIndexItem(
+		// Stop inserting once the per-collection limit is reached
+		if maxItems > 0 && itemCount >= maxItems {
+			p.log.Warnf("Truncating %s at line %d, it exceeds the limit of %d items", name, lineNum, maxItems)
+			truncated = true
+			break
+		}
+
+		endorsed, err := claims.endorse(&item)
+		if err != nil {
+			p.log.Warnf("Claim of %s in %s does not verify: %v", item.CID, name, err)
+		}
+		item.Endorsed = endorsed
+		if endorsed {
+			endorsedCount++
+		}
+
+		itemCount++
+		if preview != nil {
+			preview.add(&item)
+			continue
+		}
+		batch = append(batch, item)
+		if len(batch) == p.chunkSize {
+			storeChunk()
+		}
+	}
+
+	if len(batch) > 0 {
+		storeChunk()
+	}
+
+	result := &ParseResult{
+		Stored:      itemCount,
+		Errors:      errorCount,
+		StoreErrors: storeErrorCount,
+		Truncated:   truncated,
+		Endorsed:    endorsedCount,
+	}
+	if claims != nil {
+		result.BadClaims = claims.failed
+		p.log.Infof("Verified %d claims in %s: %d endorsed, %d failed", claims.verified+claims.failed, name, endorsedCount, claims.failed)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("error reading collection content: %w", err)
+	}
+
+	if preview != nil {
+		p.log.Infof("Parsed %s: %d items, %d errors", name, itemCount, errorCount)
+		return result, nil
+	}
+	p.log.Infof("Parsed %s: %d items stored, %d errors", name, itemCount, errorCount+storeErrorCount)
+
+	// Items that could not be stored would be lost if the collection were
+	// marked downloaded, so report the parse as incomplete
+	if storeErrorCount > 0 {
+		return result, fmt.Errorf("%d of %d items could not be stored", storeErrorCount, itemCount+storeErrorCount)
+	}
+
+	return result, nil
+}
+
+// lineScanner reads the lines of an index like bufio.Scanner, except that a
+// line cut off by a read error is dropped rather than returned, so the recorded
+// progress never covers a line that was not read completely
+type lineScanner struct {
+	r    *bufio.Reader
+	line []byte
+	err  error
+	done bool
+}
+
+// newLineScanner creates a line scanner reading from r
+func newLineScanner(r io.Reader) *lineScanner {
+	return &lineScanner{r: bufio.NewReader(r)}
+}
+
+// Scan advances to the next line; it returns false at the end of the input or on a read error
+func (s *lineScanner) Scan() bool {
+	if s.done {
+		return false
+	}
+
+	line, err := s.r.ReadBytes('\n')
+	switch {
+	case err == io.EOF:
+		s.done = true
+		if len(line) == 0 {
+			return false
+		}
+	case err != nil:
+		s.done, s.err = true, err
+		return false
+	}
+
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	s.line = bytes.TrimSuffix(line, []byte("\r"))
+	return true
+}
+
+// Text returns the current line without its line ending
+func (s *lineScanner) Text() string {
+	return string(s.line)
+}
+
+// Err returns the read error that ended the scan, if any
+func (s *lineScanner) Err() error {
+	return s.err
+}
+
+// applyHeader stores the visibility and license declared in the index header
+func (p *Parser) applyHeader(collection *database.Collection, header *Header) {
+	if header.Visibility == "" && header.License == "" {
+		return
+	}
+
+	visibility := header.Visibility
+	if visibility != database.VisibilityUnlisted {
+		visibility = database.VisibilityPublic
+	}
+
+	if err := p.db.SetCollectionMeta(collection.PublisherID, collection.IPNS, visibility, header.License); err != nil {
+		p.log.Errorf("Failed to apply index header of collection ID=%d: %v", collection.ID, err)
+	}
+}
+
+// maxGroupLength bounds the stored directory group of an item
+const maxGroupLength = 1024
+
+// sanitizeGroup normalizes a publisher-supplied group to "/"-separated
+// components without control characters or "." and ".." components
+func sanitizeGroup(group string) string {
+	var parts []string
+	for _, part := range strings.Split(group, "/") {
+		part = strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) || r == '\\' {
+				return -1
+			}
+			return r
+		}, part)
+		part = strings.TrimSpace(part)
+		if part == "" || part == "." || part == ".." {
+			continue
+		}
+		parts = append(parts, part)
+	}
+
+	group = strings.Join(parts, "/")
+	if len(group) > maxGroupLength {
+		group = strings.TrimRight(group[:maxGroupLength], "/")
+	}
+	return group
+}
+
+// maxMimeTypeLength bounds the stored MIME type of an item
+const maxMimeTypeLength = 255
+
+// file returns the file properties of the item to store. Properties the record
+// does not carry, such as in indexes of older publishers, and invalid ones are
+// left out.
+func (item *ContentItem) file() database.ItemFile {
+	var file database.ItemFile
+	if item.Size > 0 {
+		file.Size = &item.Size
+	}
+	if item.ModTime > 0 {
+		file.ModTime = &item.ModTime
+	}
+	if mediaType, params, err := mime.ParseMediaType(item.MimeType); err == nil {
+		if mimeType := mime.FormatMediaType(mediaType, params); mimeType != "" && len(mimeType) <= maxMimeTypeLength {
+			file.MimeType = &mimeType
+		}
+	}
+	return file
+}
+
+// parseChunkSize is the number of items stored per database transaction
+const parseChunkSize = 10000
+
+// progress is the parse position of a collection after a stored chunk
+type progress struct {
+	items  int // Items stored up to and including the chunk
+	offset int // Index lines read up to the end of the chunk
+}
+
+// storeBatch stores items in a single transaction, recording at as the progress
+// of the collection unless it is nil. A transient database error rolls back the
+// batch, which is retried up to insertRetries times. If the batch still fails,
+// the items are stored one by one so that a single bad item does not drop the
+// whole batch, and the progress is recorded only if all of them were stored.
+// It returns the number of items that were not stored.
+func (p *Parser) storeBatch(collection *database.Collection, items []ContentItem, at *progress) int {
+	var err error
+	for attempt := 0; attempt <= p.insertRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
+
+		err = p.storeBatchTx(collection, items, at)
+		if err == nil {
+			return 0
+		}
+		if !database.IsTransientError(err) {
+			break
+		}
+
+		p.log.Debugf("Transient error storing batch of %d items in collection ID=%d (attempt %d/%d): %v",
+			len(items), collection.ID, attempt+1, p.insertRetries+1, err)
+	}
+
+	p.log.Warnf("Failed to store batch of %d items in collection ID=%d, storing items individually: %v", len(items), collection.ID, err)
+
+	failed := 0
+	for i := range items {
+		if err := p.storeItem(collection, &items[i]); err != nil {
+			p.log.Errorf("Failed to store item %s in collection ID=%d: %v", items[i].CID, collection.ID, err)
+			failed++
+		}
+	}
+	if failed == 0 && at != nil {
+		if err := p.db.SetCollectionProgress(collection.ID, at.items, at.offset); err != nil {
+			p.log.Errorf("Failed to record progress of collection ID=%d: %v", collection.ID, err)
+		}
+	}
+	return failed
+}
+
+// storeBatchTx stores items and the progress in one transaction, rolling back on any error
+func (p *Parser) storeBatchTx(collection *database.Collection, items []ContentItem, at *progress) error {
+	tx, err := p.db.BeginTx()
+	if err != nil {
+		return err
+	}
+
+	for i := range items {
+		item := &items[i]
+		err := tx.CreateOrUpdateIndexItem(
 			item.CID,
 			item.Filename,
 			item.Extension,
+			sanitizeGroup(item.Group),
+			item.file(),
+			item.Endorsed,
 			collection.HostID,
 			collection.PublisherID,
 			collection.ID,
-		); err != nil {
-			p.log.Errorf("Failed to store item from line %d in collection ID=%d: %v", lineNum, collection.ID, err)
-			errorCount++
-			continue
+		)
+		if err != nil {
+			tx.Rollback()
+			return err
 		}
-
-		itemCount++
 	}
-
-	if err := scanner.Err(); err != nil {
-		return itemCount, fmt.Errorf("error reading collection content: %w", err)
+	if at != nil {
+		if err := tx.SetCollectionProgress(collection.ID, at.items, at.offset); err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
 
-	p.log.Infof("Parsed collection ID=%d: %d items stored, %d errors", collection.ID, itemCount, errorCount)
+	return tx.Commit()
+}
+
+// storeItem stores a single item, retrying transient database errors
+func (p *Parser) storeItem(collection *database.Collection, item *ContentItem) error {
+	var err error
+	for attempt := 0; attempt <= p.insertRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+		}
 
-	return itemCount, nil
+		err = p.db.CreateOrUpdateIndexItem(
+			item.CID,
+			item.Filename,
+			item.Extension,
+			sanitizeGroup(item.Group),
+			item.file(),
+			item.Endorsed,
+			collection.HostID,
+			collection.PublisherID,
+			collection.ID,
+		)
+		if err == nil || !database.IsTransientError(err) {
+			return err
+		}
+
+		p.log.Debugf("Transient error storing item %s (attempt %d/%d): %v", item.CID, attempt+1, p.insertRetries+1, err)
+	}
+	return err
 }
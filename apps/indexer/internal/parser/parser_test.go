@@ -0,0 +1,279 @@
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/sirupsen/logrus"
+)
+
+// newTestParser creates a parser over a fresh database and a pending collection
+func newTestParser(t *testing.T, limits *config.LimitsConfig) (*Parser, *database.DB, *database.Collection) {
+	t.Helper()
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"), log)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("publisher-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	collection, err := db.CreateCollection(host.ID, publisher.ID, 1, "k51test", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewParser(db, limits, 0, log), db, collection
+}
+
+// indexLines builds an index with n records
+func indexLines(n int) []byte {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&b, `{"id":%d,"CID":"cid%d","filename":"file%d.mp3","extension":"mp3"}`+"\n", i, i, i)
+	}
+	return []byte(b.String())
+}
+
+func TestParseAndStoreTruncatesAtCollectionLimit(t *testing.T) {
+	p, db, collection := newTestParser(t, &config.LimitsConfig{MaxItemsPerCollection: 3})
+
+	result, err := p.ParseAndStore(collection, bytes.NewReader(indexLines(5)))
+	if err != nil {
+		t.Fatalf("ParseAndStore: %v", err)
+	}
+	if !result.Truncated {
+		t.Error("expected result to be truncated")
+	}
+	if result.Stored != 3 {
+		t.Errorf("Stored = %d, want 3", result.Stored)
+	}
+
+	count, err := db.CountCollectionItems(collection.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("stored %d items, want 3", count)
+	}
+}
+
+func TestParseAndStoreAtExactLimitIsNotTruncated(t *testing.T) {
+	p, _, collection := newTestParser(t, &config.LimitsConfig{MaxItemsPerCollection: 3})
+
+	result, err := p.ParseAndStore(collection, bytes.NewReader(indexLines(3)))
+	if err != nil {
+		t.Fatalf("ParseAndStore: %v", err)
+	}
+	if result.Truncated {
+		t.Error("collection at the limit should not be truncated")
+	}
+	if result.Stored != 3 {
+		t.Errorf("Stored = %d, want 3", result.Stored)
+	}
+}
+
+func TestParseAndStoreUnlimited(t *testing.T) {
+	p, _, collection := newTestParser(t, &config.LimitsConfig{})
+
+	content := append([]byte("not json\n"), indexLines(10)...)
+	result, err := p.ParseAndStore(collection, bytes.NewReader(content))
+	if err != nil {
+		t.Fatalf("ParseAndStore: %v", err)
+	}
+	if result.Truncated || result.Stored != 10 || result.Errors != 1 {
+		t.Errorf("result = %+v, want 10 stored, 1 error, not truncated", *result)
+	}
+}
+
+func TestSanitizeGroup(t *testing.T) {
+	tests := map[string]string{
+		"":                    "",
+		"Artist/Album":        "Artist/Album",
+		"/Artist//Album/":     "Artist/Album",
+		"../../etc":           "etc",
+		"Artist/./Album\x00":  "Artist/Album",
+		"Artist\\Album":       "ArtistAlbum",
+		"Artist/Album/Disc 1": "Artist/Album/Disc 1",
+		"  Artist / Album  ":  "Artist/Album",
+	}
+	for in, want := range tests {
+		if got := sanitizeGroup(in); got != want {
+			t.Errorf("sanitizeGroup(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParseAndStoreAcrossBatches(t *testing.T) {
+	p, db, collection := newTestParser(t, &config.LimitsConfig{})
+
+	p.chunkSize = 100
+	n := 2*p.chunkSize + 1
+	result, err := p.ParseAndStore(collection, bytes.NewReader(indexLines(n)))
+	if err != nil {
+		t.Fatalf("ParseAndStore: %v", err)
+	}
+	if result.Stored != n || result.StoreErrors != 0 {
+		t.Errorf("Stored = %d, StoreErrors = %d, want %d and 0", result.Stored, result.StoreErrors, n)
+	}
+
+	count, err := db.CountCollectionItems(collection.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != n {
+		t.Errorf("stored %d items, want %d", count, n)
+	}
+}
+
+func TestParseAndStoreNormalizesExtensions(t *testing.T) {
+	p, db, collection := newTestParser(t, &config.LimitsConfig{})
+
+	content := `{"id":1,"CID":"cid1","filename":"a.MP3","extension":"MP3"}
+{"id":2,"CID":"cid2","filename":"b.flac","extension":".flac"}
+{"id":3,"CID":"cid3","filename":"c.ogg","extension":" .Ogg "}
+{"id":4,"CID":"cid4","filename":"d","extension":"."}
+`
+	result, err := p.ParseAndStore(collection, strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("ParseAndStore: %v", err)
+	}
+	if result.Stored != 3 || result.Errors != 1 {
+		t.Errorf("Stored = %d, Errors = %d, want 3 and 1 (an extension of only a dot is missing)", result.Stored, result.Errors)
+	}
+
+	items, err := db.GetCollectionItems(collection.ID, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, item := range items {
+		got = append(got, item.Extension)
+	}
+	slices.Sort(got)
+	if want := []string{"flac", "mp3", "ogg"}; !slices.Equal(got, want) {
+		t.Errorf("stored extensions %q, want %q", got, want)
+	}
+}
+
+func TestParseAndStoreResumesAfterInterruption(t *testing.T) {
+	p, db, collection := newTestParser(t, &config.LimitsConfig{})
+	p.chunkSize = 10
+
+	const n = 35
+	content := indexLines(n)
+
+	// The stream breaks off in the middle of line 25
+	cut := bytes.Index(content, []byte(`{"id":25,`)) + 5
+	errKilled := errors.New("killed")
+	killed := io.MultiReader(bytes.NewReader(content[:cut]), iotest.ErrReader(errKilled))
+	if _, err := p.ParseAndStore(collection, killed); !errors.Is(err, errKilled) {
+		t.Fatalf("ParseAndStore of the broken stream: %v, want %v", err, errKilled)
+	}
+
+	collection, err := db.GetCollection(collection.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collection.ItemsIngested != 24 || collection.IngestOffset != 24 {
+		t.Errorf("progress after interruption = %d items at line %d, want 24 at line 24",
+			collection.ItemsIngested, collection.IngestOffset)
+	}
+
+	// Lines up to the offset are skipped, so a line now broken there is not seen
+	resumed := append([]byte("not json\n"), content[bytes.IndexByte(content, '\n')+1:]...)
+	result, err := p.ParseAndStore(collection, bytes.NewReader(resumed))
+	if err != nil {
+		t.Fatalf("ParseAndStore resuming: %v", err)
+	}
+	if result.Stored != n || result.Errors != 0 {
+		t.Errorf("Stored = %d, Errors = %d, want %d and 0", result.Stored, result.Errors, n)
+	}
+
+	count, err := db.CountCollectionItems(collection.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != n {
+		t.Errorf("stored %d items, want %d without duplicates", count, n)
+	}
+
+	collection, err = db.GetCollection(collection.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if collection.ItemsIngested != n || collection.IngestOffset != n {
+		t.Errorf("progress after completion = %d items at line %d, want %d at line %d",
+			collection.ItemsIngested, collection.IngestOffset, n, n)
+	}
+}
+
+func TestParseAndStoreIgnoresRecordMetadata(t *testing.T) {
+	p, _, collection := newTestParser(t, &config.LimitsConfig{})
+
+	content := `{"id":1,"CID":"cid1","filename":"a.mp3","extension":"mp3"}
+{"id":2,"CID":"cid2","filename":"b.mp3","extension":"mp3","metadata":{"title":"Intro","artist":"Björk","duration":26.122}}
+{"id":3,"CID":"cid3","filename":"c.mkv","extension":"mkv","metadata":{"width":1280,"height":720,"extra":[1,2]}}
+{"id":4,"CID":"cid4","filename":"d.mp3","extension":"mp3","metadata":"not an object"}
+`
+	result, err := p.ParseAndStore(collection, strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("ParseAndStore: %v", err)
+	}
+	if result.Stored != 4 || result.Errors != 0 {
+		t.Errorf("Stored = %d, Errors = %d, want 4 and 0", result.Stored, result.Errors)
+	}
+}
+
+func TestParseAndStoreItemFile(t *testing.T) {
+	p, db, collection := newTestParser(t, &config.LimitsConfig{})
+
+	content := `{"id":1,"CID":"cid1","filename":"old.mp3","extension":"mp3"}
+{"id":2,"CID":"cid2","filename":"new.mp3","extension":"mp3","size":4096,"mtime":1764260509,"mimeType":"audio/mpeg"}
+{"id":3,"CID":"cid3","filename":"odd.txt","extension":"txt","size":-1,"mimeType":"not a type"}
+`
+	result, err := p.ParseAndStore(collection, strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("ParseAndStore: %v", err)
+	}
+	if result.Stored != 3 || result.Errors != 0 {
+		t.Fatalf("Stored = %d, Errors = %d, want 3 and 0", result.Stored, result.Errors)
+	}
+
+	items, err := db.GetCollectionItems(collection.ID, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]database.ItemFile)
+	for _, item := range items {
+		files[item.Filename] = item.File
+	}
+	if f := files["new.mp3"]; f.Size == nil || *f.Size != 4096 || f.ModTime == nil || *f.ModTime != 1764260509 ||
+		f.MimeType == nil || *f.MimeType != "audio/mpeg" {
+		t.Errorf("new.mp3 stored with %+v, want its size, mtime and MIME type", f)
+	}
+	for _, name := range []string{"old.mp3", "odd.txt"} {
+		if f := files[name]; f != (database.ItemFile{}) {
+			t.Errorf("%s stored with %+v, want no file properties", name, f)
+		}
+	}
+}
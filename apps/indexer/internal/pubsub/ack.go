@@ -0,0 +1,132 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/atregu/ipfs-common/ack"
+	"github.com/sirupsen/logrus"
+)
+
+// AckPublisher publishes raw messages to a PubSub topic (implemented by ipfs.Client)
+type AckPublisher interface {
+	PublishToPubSub(ctx context.Context, topic string, data []byte) error
+}
+
+// maxTrackedAcks bounds the number of publisher versions remembered for rate limiting.
+// When it is exceeded, the least recently acknowledged version is dropped.
+const maxTrackedAcks = 1000
+
+// ackTimeout bounds the publication of a single ack
+const ackTimeout = 10 * time.Second
+
+// ackKey identifies an acknowledged publisher version
+type ackKey struct {
+	publisherKey string
+	version      int
+}
+
+// Acker publishes signed, rate-limited acks of stored announcements on the
+// companion ack topic. Announcements repeat on every publisher heartbeat, so each
+// publisher version is acknowledged at most once per interval.
+type Acker struct {
+	publisher    AckPublisher
+	topic        string
+	key          ed25519.PrivateKey
+	interval     time.Duration
+	maxPerMinute int
+	log          *logrus.Logger
+
+	mu          sync.Mutex
+	sent        map[ackKey]time.Time
+	windowStart time.Time
+	windowCount int
+}
+
+// NewAcker creates an acker publishing on the ack topic of announceTopic
+func NewAcker(publisher AckPublisher, announceTopic string, key ed25519.PrivateKey, interval time.Duration, maxPerMinute int, log *logrus.Logger) *Acker {
+	return &Acker{
+		publisher:    publisher,
+		topic:        ack.Topic(announceTopic),
+		key:          key,
+		interval:     interval,
+		maxPerMinute: maxPerMinute,
+		log:          log,
+		sent:         make(map[ackKey]time.Time),
+	}
+}
+
+// Topic returns the topic acks are published on
+func (a *Acker) Topic() string {
+	return a.topic
+}
+
+// Ack acknowledges a stored announcement unless the same version was acknowledged
+// within the interval or the per-minute limit is reached
+func (a *Acker) Ack(publisherKey string, version int) error {
+	if !a.allow(ackKey{publisherKey, version}, time.Now()) {
+		a.log.Debugf("Skipping ack of version %d: rate limited", version)
+		return nil
+	}
+
+	msg := ack.New(publisherKey, version)
+	if err := msg.Sign(a.key); err != nil {
+		return fmt.Errorf("failed to sign ack: %w", err)
+	}
+
+	data, err := msg.ToJSON()
+	if err != nil {
+		return fmt.Errorf("failed to serialize ack: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ackTimeout)
+	defer cancel()
+
+	if err := a.publisher.PublishToPubSub(ctx, a.topic, data); err != nil {
+		return fmt.Errorf("failed to publish ack: %w", err)
+	}
+
+	a.log.Debugf("Acknowledged version %d on topic %s", version, a.topic)
+	return nil
+}
+
+// allow reports whether an ack of key may be published at now and records it if so
+func (a *Acker) allow(key ackKey, now time.Time) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if last, ok := a.sent[key]; ok && now.Sub(last) < a.interval {
+		return false
+	}
+
+	if now.Sub(a.windowStart) >= time.Minute {
+		a.windowStart = now
+		a.windowCount = 0
+	}
+	if a.windowCount >= a.maxPerMinute {
+		return false
+	}
+	a.windowCount++
+
+	if _, ok := a.sent[key]; !ok && len(a.sent) >= maxTrackedAcks {
+		a.evictOldest()
+	}
+	a.sent[key] = now
+	return true
+}
+
+// evictOldest drops the least recently acknowledged version. mu must be held.
+func (a *Acker) evictOldest() {
+	var oldest ackKey
+	var oldestAt time.Time
+	first := true
+	for key, at := range a.sent {
+		if first || at.Before(oldestAt) {
+			oldest, oldestAt, first = key, at, false
+		}
+	}
+	delete(a.sent, oldest)
+}
@@ -0,0 +1,110 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-common/ack"
+	"github.com/sirupsen/logrus"
+)
+
+// recordingPublisher collects the messages published per topic
+type recordingPublisher struct {
+	messages map[string][][]byte
+}
+
+func (p *recordingPublisher) PublishToPubSub(ctx context.Context, topic string, data []byte) error {
+	p.messages[topic] = append(p.messages[topic], data)
+	return nil
+}
+
+func newTestAcker(t *testing.T, interval time.Duration, maxPerMinute int) (*Acker, *recordingPublisher) {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	publisher := &recordingPublisher{messages: make(map[string][][]byte)}
+	return NewAcker(publisher, "mdn/collections/announce", key, interval, maxPerMinute, log), publisher
+}
+
+func TestAckPublishesSignedAck(t *testing.T) {
+	acker, publisher := newTestAcker(t, time.Minute, 10)
+
+	if err := acker.Ack("cHVibGlzaGVy", 12); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+
+	acks := publisher.messages["mdn/collections/ack"]
+	if len(acks) != 1 {
+		t.Fatalf("published %v, want one ack on mdn/collections/ack", publisher.messages)
+	}
+	msg, err := ack.Parse(acks[0])
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if err := msg.Verify(); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if msg.PublisherKey != "cHVibGlzaGVy" || msg.Version != 12 {
+		t.Errorf("ack = %+v, want publisher cHVibGlzaGVy version 12", msg)
+	}
+
+	// The heartbeat repeating the same version is not acknowledged again
+	if err := acker.Ack("cHVibGlzaGVy", 12); err != nil {
+		t.Fatalf("Ack: %v", err)
+	}
+	if n := len(publisher.messages["mdn/collections/ack"]); n != 1 {
+		t.Errorf("repeated version acknowledged, %d acks published", n)
+	}
+}
+
+func TestAckRateLimit(t *testing.T) {
+	acker, _ := newTestAcker(t, time.Hour, 2)
+	start := time.Now()
+
+	allowed := 0
+	for v := 1; v <= 5; v++ {
+		if acker.allow(ackKey{"publisher", v}, start) {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("%d acks allowed in one minute, want 2", allowed)
+	}
+
+	// A new minute opens a new window, but not for versions within the interval
+	if !acker.allow(ackKey{"publisher", 6}, start.Add(time.Minute)) {
+		t.Error("ack refused in the next minute")
+	}
+	if acker.allow(ackKey{"publisher", 1}, start.Add(2*time.Minute)) {
+		t.Error("version re-acknowledged within the interval")
+	}
+	if !acker.allow(ackKey{"publisher", 1}, start.Add(time.Hour)) {
+		t.Error("version not re-acknowledged after the interval")
+	}
+}
+
+func TestAckTrackingIsBounded(t *testing.T) {
+	acker, _ := newTestAcker(t, time.Hour, maxTrackedAcks*2)
+	start := time.Now()
+
+	for v := 1; v <= maxTrackedAcks+10; v++ {
+		acker.allow(ackKey{"publisher", v}, start.Add(time.Duration(v)*time.Millisecond))
+	}
+	if len(acker.sent) != maxTrackedAcks {
+		t.Errorf("%d versions tracked, want %d", len(acker.sent), maxTrackedAcks)
+	}
+	if _, ok := acker.sent[ackKey{"publisher", 1}]; ok {
+		t.Error("oldest version was not evicted")
+	}
+}
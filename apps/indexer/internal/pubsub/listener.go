@@ -2,59 +2,101 @@ package pubsub
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
-	"strings"
+	"sync/atomic"
+	"time"
 
+	"github.com/atregu/ipfs-common/fingerprint"
+	"github.com/atregu/ipfs-common/tracing"
+	"github.com/atregu/ipfs-indexer/internal/config"
 	"github.com/atregu/ipfs-indexer/internal/database"
 	"github.com/atregu/ipfs-indexer/internal/ipfs"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// Message represents a PubSub message announcing a collection
-type Message struct {
-	Version        int    `json:"version"`
-	IPNS           string `json:"ipns"`
-	PublicKey      string `json:"publicKey"`
-	CollectionSize *int   `json:"collectionSize,omitempty"`
-	Timestamp      int64  `json:"timestamp"`
-	Signature      string `json:"signature"`
-}
-
 // Listener handles PubSub subscriptions and message processing
 type Listener struct {
 	ipfsClient *ipfs.Client
 	db         *database.DB
 	topic      string
+	limits     *config.LimitsConfig
 	log        *logrus.Logger
 	ctx        context.Context
 	cancel     context.CancelFunc
-	sub        *pubsub.Subscription
+	messages   <-chan *pubsub.Message
+	window     time.Duration // Copies of a payload within it are dropped on receipt
+	done       chan struct{} // Closed when message processing returned
+	refused    atomic.Int64
+	duplicates atomic.Int64
+	acker      *Acker
+	seen       *SeenCache
+	unverified bool   // Store announcements without checking their signature
+	ignored    string // Public key whose announcements are not stored, e.g. the aggregator's own
 }
 
 // NewListener creates a new PubSub listener
-func NewListener(ipfsClient *ipfs.Client, db *database.DB, topic string, log *logrus.Logger) *Listener {
+func NewListener(ipfsClient *ipfs.Client, db *database.DB, topic string, limits *config.LimitsConfig, log *logrus.Logger) *Listener {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Listener{
 		ipfsClient: ipfsClient,
 		db:         db,
 		topic:      topic,
+		limits:     limits,
 		log:        log,
 		ctx:        ctx,
 		cancel:     cancel,
+		window:     DefaultDedupeWindow,
 	}
 }
 
+// DefaultDedupeWindow is the time copies of a received payload are dropped for,
+// unless SetDedupeWindow sets another
+const DefaultDedupeWindow = time.Minute
+
+// SetDedupeWindow sets the time after receiving a payload during which copies
+// of it, e.g. forwarded by other mesh peers, are dropped before they are parsed
+func (l *Listener) SetDedupeWindow(window time.Duration) {
+	l.window = window
+}
+
+// SetAcker enables acknowledging stored announcements through acker
+func (l *Listener) SetAcker(acker *Acker) {
+	l.acker = acker
+}
+
+// SetSeenCache enables dropping announcements already handled, as remembered by seen
+func (l *Listener) SetSeenCache(seen *SeenCache) {
+	l.seen = seen
+}
+
+// SetVerification sets whether announcements must carry a valid signature of
+// their public key; it is enabled by default
+func (l *Listener) SetVerification(enabled bool) {
+	l.unverified = !enabled
+}
+
+// IgnorePublisher skips announcements signed with publicKey (base64), so the
+// indexer does not index the collection it publishes itself
+func (l *Listener) IgnorePublisher(publicKey string) {
+	l.ignored = publicKey
+}
+
 // Start subscribes to the PubSub topic and begins processing messages
 func (l *Listener) Start() error {
 	l.log.Infof("Subscribing to PubSub topic: %s", l.topic)
 
-	sub, err := l.ipfsClient.Subscribe(l.ctx, l.topic)
+	messages, err := l.ipfsClient.SubscribeDeduped(l.ctx, l.topic, l.window)
 	if err != nil {
 		return fmt.Errorf("failed to subscribe to topic: %w", err)
 	}
-	l.sub = sub
+	l.messages = messages
+	l.done = make(chan struct{})
 
 	l.log.Infof("Successfully subscribed to topic: %s", l.topic)
 
@@ -66,6 +108,7 @@ func (l *Listener) Start() error {
 
 // processMessages continuously processes incoming PubSub messages
 func (l *Listener) processMessages() {
+	defer close(l.done)
 	l.log.Info("Started processing PubSub messages")
 
 	for {
@@ -73,15 +116,12 @@ func (l *Listener) processMessages() {
 		case <-l.ctx.Done():
 			l.log.Info("Stopping PubSub message processing")
 			return
-		default:
-			msg, err := l.sub.Next(l.ctx)
-			if err != nil {
-				if l.ctx.Err() != nil {
-					// Context cancelled, exit gracefully
-					return
+		case msg, ok := <-l.messages:
+			if !ok {
+				if l.ctx.Err() == nil {
+					l.log.Error("PubSub subscription ended")
 				}
-				l.log.Errorf("Error receiving message: %v", err)
-				continue
+				return
 			}
 
 			// Process the message
@@ -94,63 +134,75 @@ func (l *Listener) processMessages() {
 
 // handleMessage processes a single PubSub message
 func (l *Listener) handleMessage(msg *pubsub.Message) error {
-	// Extract sender peer ID (host)
+	// Extract sender peer ID (host). The message ID and hash are rendered as the
+	// publisher logs them, so a receipt can be matched with its send.
 	senderID := msg.ReceivedFrom.String()
-	l.log.Debugf("Received message from peer: %s", senderID)
+	messageID := hex.EncodeToString([]byte(msg.ID))
+	return l.handleAnnouncement(senderID, messageID, msg.Data)
+}
+
+// handleAnnouncement parses, checks and stores the announcement in data, received
+// from senderID in the message messageID. Announcements that do not parse, are
+// invalid or fail signature verification are logged and skipped.
+func (l *Listener) handleAnnouncement(senderID, messageID string, data []byte) (err error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	l.log.Debugf("Received message %s from peer %s: hash %s", messageID, senderID, hash)
 
 	// Parse the message
-	var collMsg Message
-	if err := json.Unmarshal(msg.Data, &collMsg); err != nil {
+	collMsg, err := FromJSON(data)
+	if err != nil {
 		l.log.Warnf("Failed to parse message: %v", err)
 		return nil // Don't return error, just skip this message
 	}
 
+	// The receipt continues the trace of the publish that announced the version
+	ctx, span := tracing.Start(tracing.Extract(context.Background(), collMsg.TraceParent), "receive",
+		attribute.String("ipns.name", collMsg.IPNS), attribute.Int("collection.version", collMsg.Version),
+		attribute.String("pubsub.message_id", messageID))
+	defer func() { tracing.End(span, err) }()
+
 	// Validate the message
-	if err := l.validateMessage(&collMsg); err != nil {
+	if err := collMsg.Validate(); err != nil {
 		l.log.Warnf("Invalid message: %v", err)
 		return nil // Don't return error, just skip this message
 	}
 
-	l.log.Infof("Valid collection announcement received: IPNS=%s, Version=%d, Size=%v, Timestamp=%d",
-		collMsg.IPNS, collMsg.Version, collMsg.CollectionSize, collMsg.Timestamp)
-
-	// Store in database
-	if err := l.storeAnnouncement(senderID, &collMsg); err != nil {
-		return fmt.Errorf("failed to store announcement: %w", err)
-	}
-
-	return nil
-}
-
-// validateMessage performs basic validation on the message
-func (l *Listener) validateMessage(msg *Message) error {
-	// Check required fields
-	if msg.Version == 0 {
-		return fmt.Errorf("missing required field: version")
+	if l.ignored != "" && collMsg.PublicKey == l.ignored {
+		l.log.Debugf("Skipping own announcement of version %d", collMsg.Version)
+		return nil
 	}
 
-	if msg.IPNS == "" {
-		return fmt.Errorf("missing required field: ipns")
+	// Only the holder of the publisher key can announce its collections
+	if !l.unverified {
+		if err := collMsg.VerifyWithHistory(l.historicKeys); err != nil {
+			l.log.Warnf("Discarding announcement %s of IPNS=%s from peer %s, publisher %s: %v",
+				messageID, collMsg.IPNS, senderID, fingerprint.Format(collMsg.PublicKey), err)
+			return nil
+		}
 	}
 
-	if msg.PublicKey == "" {
-		return fmt.Errorf("missing required field: publicKey")
+	// Copies of a handled announcement are dropped before touching the database
+	if l.seen != nil && l.seen.Seen(collMsg, time.Now()) {
+		l.duplicates.Add(1)
+		l.log.Debugf("Dropping repeated announcement %s of version %d from peer %s", messageID, collMsg.Version, senderID)
+		return nil
 	}
 
-	if msg.Timestamp == 0 {
-		return fmt.Errorf("missing required field: timestamp")
-	}
+	l.log.Infof("Valid collection announcement received: IPNS=%s, Version=%d, Size=%v, Timestamp=%d, Message=%s, Publisher=%s, From=%s",
+		collMsg.IPNS, collMsg.Version, collMsg.CollectionSize, collMsg.Timestamp, messageID, fingerprint.Format(collMsg.PublicKey), senderID)
 
-	// Validate IPNS format (should start with "k2k4r8")
-	if !strings.HasPrefix(msg.IPNS, "k2k4r8") {
-		return fmt.Errorf("invalid IPNS format: must start with k2k4r8")
+	// Store in database
+	if err := l.storeAnnouncement(ctx, senderID, messageID, hash, collMsg); err != nil {
+		return fmt.Errorf("failed to store announcement: %w", err)
 	}
 
 	return nil
 }
 
-// storeAnnouncement stores the announcement in the database
-func (l *Listener) storeAnnouncement(hostPublicKey string, msg *Message) error {
+// storeAnnouncement stores the announcement in the database with the ID and hash
+// of the message it came in, and the trace context of its receipt in ctx
+func (l *Listener) storeAnnouncement(ctx context.Context, hostPublicKey, messageID, hash string, msg *Message) error {
 	// Create or get host
 	host, err := l.db.CreateOrGetHost(hostPublicKey)
 	if err != nil {
@@ -162,6 +214,26 @@ func (l *Listener) storeAnnouncement(hostPublicKey string, msg *Message) error {
 	if err != nil {
 		return fmt.Errorf("failed to create/get publisher: %w", err)
 	}
+	if err := l.db.TouchPublisher(publisher.ID); err != nil {
+		l.log.Warnf("Failed to record publisher ID=%d as heard: %v", publisher.ID, err)
+	}
+
+	// Refuse new collections from publishers over their total quota
+	if l.limits != nil && l.limits.MaxItemsPerPublisher > 0 {
+		count, err := l.db.CountPublisherItems(publisher.ID)
+		if err != nil {
+			return fmt.Errorf("failed to count publisher items: %w", err)
+		}
+		if count >= l.limits.MaxItemsPerPublisher {
+			l.refused.Add(1)
+			if err := l.db.IncrementPublisherRefusals(publisher.ID); err != nil {
+				l.log.Errorf("Failed to record refusal for publisher ID=%d: %v", publisher.ID, err)
+			}
+			l.log.Warnf("Refusing collection IPNS=%s: publisher ID=%d has %d items (limit %d)",
+				msg.IPNS, publisher.ID, count, l.limits.MaxItemsPerPublisher)
+			return nil
+		}
+	}
 
 	// Create collection
 	collection, err := l.db.CreateCollection(
@@ -176,23 +248,114 @@ func (l *Listener) storeAnnouncement(hostPublicKey string, msg *Message) error {
 		return fmt.Errorf("failed to create collection: %w", err)
 	}
 
-	l.log.Infof("Stored collection announcement: ID=%d, IPNS=%s, Status=pending", collection.ID, msg.IPNS)
+	if err := l.db.SetCollectionMessage(collection.ID, messageID, hash); err != nil {
+		return fmt.Errorf("failed to set collection message: %w", err)
+	}
+
+	// The fetch of the collection joins the trace of its receipt
+	if traceParent := tracing.Inject(ctx); traceParent != "" {
+		if err := l.db.SetCollectionTraceParent(collection.ID, traceParent); err != nil {
+			l.log.Warnf("Failed to record trace context of collection ID=%d: %v", collection.ID, err)
+		}
+	}
+
+	// Record mirror IPNS names for fallback resolution
+	if len(msg.Mirrors) > 0 {
+		if err := l.db.SetCollectionMirrors(collection.ID, msg.Mirrors); err != nil {
+			return fmt.Errorf("failed to set collection mirrors: %w", err)
+		}
+	}
+
+	// Record the announced root and index so the fetcher uses exactly this version
+	if msg.RootCID != "" {
+		if err := l.db.SetCollectionAnnouncedCIDs(collection.ID, msg.RootCID, msg.IndexCID); err != nil {
+			return fmt.Errorf("failed to set collection CIDs: %w", err)
+		}
+	}
+
+	// Record the delta against the previous version so the fetcher can apply it
+	if msg.DeltaCID != "" {
+		if err := l.db.SetCollectionDeltaCID(collection.ID, msg.DeltaCID); err != nil {
+			return fmt.Errorf("failed to set collection delta CID: %w", err)
+		}
+	}
+
+	// The fetcher downloads the manifest, if it changed, next to the index
+	if msg.ManifestCID != "" {
+		if err := l.db.SetCollectionManifestCID(collection.ID, msg.ManifestCID); err != nil {
+			return fmt.Errorf("failed to set collection manifest CID: %w", err)
+		}
+	}
+
+	// Apply visibility and license to this and all earlier versions of the collection
+	visibility := msg.Visibility
+	if visibility != database.VisibilityUnlisted {
+		if visibility != "" && visibility != database.VisibilityPublic {
+			l.log.Warnf("Unknown visibility %q for IPNS=%s, treating as public", visibility, msg.IPNS)
+		}
+		visibility = database.VisibilityPublic
+	}
+	if err := l.db.SetCollectionMeta(publisher.ID, msg.IPNS, visibility, msg.License); err != nil {
+		return fmt.Errorf("failed to set collection metadata: %w", err)
+	}
+
+	l.log.Infof("Stored collection announcement: ID=%d, IPNS=%s, Visibility=%s, Status=pending", collection.ID, msg.IPNS, visibility)
+
+	// Only announcements that were stored are acknowledged
+	if l.acker != nil {
+		if err := l.acker.Ack(msg.PublicKey, msg.Version); err != nil {
+			l.log.Warnf("Failed to acknowledge IPNS=%s version %d: %v", msg.IPNS, msg.Version, err)
+		}
+	}
 
 	return nil
 }
 
-// Stop gracefully stops the PubSub listener
-func (l *Listener) Stop() error {
+// historicKeys returns the keys the publisher with the current key publicKey
+// rotated away, as recorded from its share document
+func (l *Listener) historicKeys(publicKey string) ([]ed25519.PublicKey, error) {
+	encoded, err := l.db.GetPublisherKeys(publicKey)
+	if err != nil {
+		return nil, err
+	}
+	var keys []ed25519.PublicKey
+	for _, e := range encoded {
+		key, err := base64.StdEncoding.DecodeString(e)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			l.log.Warnf("Skipping invalid historic key of publisher %s", fingerprint.Format(publicKey))
+			continue
+		}
+		keys = append(keys, ed25519.PublicKey(key))
+	}
+	return keys, nil
+}
+
+// GetRefusedCount returns the number of collections refused due to publisher quotas
+func (l *Listener) GetRefusedCount() int64 {
+	return l.refused.Load()
+}
+
+// GetDuplicateCount returns the number of announcements dropped as already handled
+func (l *Listener) GetDuplicateCount() int64 {
+	return l.duplicates.Load()
+}
+
+// Stop unsubscribes and waits until ctx is done for the message being handled,
+// so no announcement is stored after Stop returns nil
+func (l *Listener) Stop(ctx context.Context) error {
 	l.log.Info("Stopping PubSub listener...")
 
-	// Cancel context
+	// Cancel context, which also ends the subscription
 	if l.cancel != nil {
 		l.cancel()
 	}
 
-	// Unsubscribe
-	if l.sub != nil {
-		l.sub.Cancel()
+	if l.done != nil {
+		select {
+		case <-l.done:
+		case <-ctx.Done():
+			return fmt.Errorf("announcement still being handled: %w", ctx.Err())
+		}
 	}
 
 	l.log.Info("PubSub listener stopped")
@@ -0,0 +1,239 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-common/tracing"
+	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/sirupsen/logrus"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// newTestListener returns a listener storing into a fresh database
+func newTestListener(t *testing.T) (*Listener, *database.DB) {
+	t.Helper()
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewListener(nil, db, "mdn/collections/announce", nil, log), db
+}
+
+// signedAnnouncement returns the v2 golden announcement signed with a new key, as
+// modified by tamper after signing
+func signedAnnouncement(t *testing.T, tamper func(*Message)) []byte {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := loadAnnouncement(t, "announcement-v2.json")
+	msg.Timestamp = time.Now().Unix()
+	if err := msg.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	if tamper != nil {
+		tamper(msg)
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// storedCollections returns the number of collections and publishers in db
+func storedCollections(t *testing.T, db *database.DB) (collections, publishers int) {
+	t.Helper()
+	counts, err := db.CountCollectionsByStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, n := range counts {
+		collections += n
+	}
+	list, err := db.ListPublishers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return collections, len(list)
+}
+
+func TestListenerVerifiesSignatures(t *testing.T) {
+	l, db := newTestListener(t)
+
+	tampered := map[string]func(*Message){
+		"version":   func(m *Message) { m.Version++ },
+		"indexCID":  func(m *Message) { m.IndexCID = "bafkreitampered" },
+		"publicKey": func(m *Message) { m.PublicKey = "E8WtP2ctD8iOoZ1s95xrU55a4iYaCdlUD+auyMZfPLM=" },
+		"signature": func(m *Message) { m.Signature = m.Signature[:10] },
+	}
+	for name, tamper := range tampered {
+		if err := l.handleAnnouncement("peer", "msg-"+name, signedAnnouncement(t, tamper)); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+	if collections, publishers := storedCollections(t, db); collections != 0 || publishers != 0 {
+		t.Errorf("tampered announcements stored %d collections of %d publishers, want none", collections, publishers)
+	}
+
+	if err := l.handleAnnouncement("peer", "msg-valid", signedAnnouncement(t, nil)); err != nil {
+		t.Fatal(err)
+	}
+	if collections, publishers := storedCollections(t, db); collections != 1 || publishers != 1 {
+		t.Errorf("valid announcement stored %d collections of %d publishers, want 1 of 1", collections, publishers)
+	}
+
+	// Without verification a tampered announcement is stored, e.g. in development
+	l.SetVerification(false)
+	if err := l.handleAnnouncement("peer", "msg-unverified", signedAnnouncement(t, tampered["version"])); err != nil {
+		t.Fatal(err)
+	}
+	if collections, _ := storedCollections(t, db); collections != 2 {
+		t.Errorf("%d collections stored with verification disabled, want 2", collections)
+	}
+}
+
+func TestListenerDropsRepeatedAnnouncements(t *testing.T) {
+	l, db := newTestListener(t)
+	seen, err := NewSeenCache(10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.SetSeenCache(seen)
+
+	data := signedAnnouncement(t, nil)
+	for i := 0; i < 3; i++ {
+		if err := l.handleAnnouncement("peer", "msg", data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if collections, _ := storedCollections(t, db); collections != 1 {
+		t.Errorf("%d collections stored from three deliveries, want 1", collections)
+	}
+	if n := l.GetDuplicateCount(); n != 2 {
+		t.Errorf("GetDuplicateCount = %d, want 2", n)
+	}
+}
+
+func TestListenerAcceptsHistoricKeys(t *testing.T) {
+	l, db := newTestListener(t)
+
+	current, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldPub, old, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	currentKey := base64.StdEncoding.EncodeToString(current)
+	oldKey := base64.StdEncoding.EncodeToString(oldPub)
+
+	// Naming the publisher's current key, but signed with the one it rotated away
+	announcement := func(t *testing.T) []byte {
+		t.Helper()
+		msg := loadAnnouncement(t, "announcement-v2.json")
+		msg.Timestamp = time.Now().Unix()
+		msg.PublicKey = currentKey
+		data, err := msg.signedBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(old, data))
+		data, err = json.Marshal(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	if err := l.handleAnnouncement("peer", "msg-unrecorded", announcement(t)); err != nil {
+		t.Fatal(err)
+	}
+	if collections, _ := storedCollections(t, db); collections != 0 {
+		t.Errorf("%d collections stored without a recorded key history, want none", collections)
+	}
+
+	// The old key in the history of another publisher does not count
+	other, err := db.CreateOrGetPublisher("E8WtP2ctD8iOoZ1s95xrU55a4iYaCdlUD+auyMZfPLM=")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddPublisherKeys(other.ID, []string{oldKey}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.handleAnnouncement("peer", "msg-other", announcement(t)); err != nil {
+		t.Fatal(err)
+	}
+	if collections, _ := storedCollections(t, db); collections != 0 {
+		t.Errorf("%d collections stored with the key of another publisher's history, want none", collections)
+	}
+
+	publisher, err := db.CreateOrGetPublisher(currentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddPublisherKeys(publisher.ID, []string{oldKey}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.handleAnnouncement("peer", "msg-historic", announcement(t)); err != nil {
+		t.Fatal(err)
+	}
+	if collections, _ := storedCollections(t, db); collections != 1 {
+		t.Errorf("%d collections stored with the publisher's historic key, want 1", collections)
+	}
+}
+
+func TestReceiveContinuesTrace(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracing.Use(provider)
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		tracing.Use(noop.NewTracerProvider())
+	})
+
+	ctx, announce := tracing.Start(context.Background(), "announce")
+	traceParent := tracing.Inject(ctx)
+	announce.End()
+
+	l, db := newTestListener(t)
+	data := signedAnnouncement(t, func(m *Message) { m.TraceParent = traceParent })
+	if err := l.handleAnnouncement("peer", "msg", data); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[1].Name != "receive" {
+		t.Fatalf("recorded %d spans, want announce and receive", len(spans))
+	}
+	receive := spans[1]
+	if receive.Parent.SpanID() != announce.SpanContext().SpanID() || receive.SpanContext.TraceID() != announce.SpanContext().TraceID() {
+		t.Errorf("receive span is not a child of the announced span")
+	}
+
+	// The stored trace context makes the fetch a child of the receipt
+	collections, err := db.ListCollectionsByStatus("pending")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "00-" + receive.SpanContext.TraceID().String() + "-" + receive.SpanContext.SpanID().String() + "-01"
+	if len(collections) != 1 || collections[0].TraceParent != want {
+		t.Errorf("stored collections %v, want one with trace parent %s", collections, want)
+	}
+}
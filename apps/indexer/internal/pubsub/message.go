@@ -0,0 +1,188 @@
+package pubsub
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/atregu/ipfs-common/ipns"
+)
+
+// nonceSize is the number of random bytes in the nonce Sign sets
+const nonceSize = 16
+
+// Message represents a PubSub message announcing a collection
+type Message struct {
+	Version        int      `json:"version"`
+	IPNS           string   `json:"ipns"`
+	PublicKey      string   `json:"publicKey"`
+	CollectionSize *int     `json:"collectionSize,omitempty"`
+	Timestamp      int64    `json:"timestamp"`
+	RootCID        string   `json:"rootCID,omitempty"`
+	IndexCID       string   `json:"indexCID,omitempty"`
+	DeltaCID       string   `json:"deltaCID,omitempty"`
+	ManifestCID    string   `json:"manifestCID,omitempty"` // manifest.json next to the index; absent from older publishers
+	Visibility     string   `json:"visibility,omitempty"`
+	License        string   `json:"license,omitempty"`
+	Mirrors        []string `json:"mirrors,omitempty"`
+	Nonce          string   `json:"nonce,omitempty"` // Random per signature; absent from older publishers
+	Signature      string   `json:"signature"`
+
+	// TraceParent is the W3C trace context of the publish that announced the
+	// version. It is not signed: a forged one only misplaces spans.
+	TraceParent string `json:"traceparent,omitempty"`
+}
+
+// FromJSON parses an announcement from its JSON encoding
+func FromJSON(data []byte) (*Message, error) {
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal message: %w", err)
+	}
+	return &msg, nil
+}
+
+// Validate checks the required fields and that the primary name and every mirror
+// parse as an IPNS name or peer ID
+func (m *Message) Validate() error {
+	if m.Version == 0 {
+		return fmt.Errorf("missing required field: version")
+	}
+
+	if m.IPNS == "" {
+		return fmt.Errorf("missing required field: ipns")
+	}
+
+	if m.PublicKey == "" {
+		return fmt.Errorf("missing required field: publicKey")
+	}
+
+	if m.Timestamp == 0 {
+		return fmt.Errorf("missing required field: timestamp")
+	}
+
+	if err := ipns.ValidateName(m.IPNS); err != nil {
+		return fmt.Errorf("invalid IPNS name: %w", err)
+	}
+
+	for _, mirror := range m.Mirrors {
+		if err := ipns.ValidateName(mirror); err != nil {
+			return fmt.Errorf("invalid mirror IPNS name %q: %w", mirror, err)
+		}
+	}
+
+	return nil
+}
+
+// KeyHistory returns the keys a publisher rotated away, looked up by its
+// current base64 public key; a publisher without any has none
+type KeyHistory func(publicKey string) ([]ed25519.PublicKey, error)
+
+// Verify checks the Ed25519 signature of the message against its public key
+func (m *Message) Verify() error {
+	return m.VerifyWithHistory(nil)
+}
+
+// VerifyWithHistory checks the Ed25519 signature of the message against its
+// public key or, failing that, the keys history returns for that same key, so a
+// message of a publisher signed with a key it rotated away still verifies. A
+// key of another publisher's history never does.
+func (m *Message) VerifyWithHistory(history KeyHistory) error {
+	publicKey, err := base64.StdEncoding.DecodeString(m.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode public key: %w", err)
+	}
+	if len(publicKey) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key size: expected %d, got %d", ed25519.PublicKeySize, len(publicKey))
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	data, err := m.signedBytes()
+	if err != nil {
+		return fmt.Errorf("failed to serialize message: %w", err)
+	}
+
+	if ed25519.Verify(ed25519.PublicKey(publicKey), data, signature) {
+		return nil
+	}
+	if history != nil {
+		historic, err := history(m.PublicKey)
+		if err != nil {
+			return fmt.Errorf("failed to look up key history: %w", err)
+		}
+		for _, key := range historic {
+			if ed25519.Verify(key, data, signature) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("signature verification failed")
+}
+
+// Sign sets the public key, a new nonce and the Ed25519 signature of the
+// message, as a publisher does, so the indexer can announce collections of its own
+func (m *Message) Sign(privateKey ed25519.PrivateKey) error {
+	m.PublicKey = base64.StdEncoding.EncodeToString(privateKey.Public().(ed25519.PublicKey))
+
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	m.Nonce = hex.EncodeToString(nonce)
+
+	data, err := m.signedBytes()
+	if err != nil {
+		return fmt.Errorf("failed to serialize message: %w", err)
+	}
+
+	m.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, data))
+	return nil
+}
+
+// signedBytes returns the canonical JSON the publisher signs: every field except
+// the signature, in declaration order, with collectionSize always present
+func (m *Message) signedBytes() ([]byte, error) {
+	size := 0
+	if m.CollectionSize != nil {
+		size = *m.CollectionSize
+	}
+
+	msg := struct {
+		Version        int      `json:"version"`
+		IPNS           string   `json:"ipns"`
+		PublicKey      string   `json:"publicKey"`
+		CollectionSize int      `json:"collectionSize"`
+		Timestamp      int64    `json:"timestamp"`
+		RootCID        string   `json:"rootCID,omitempty"`
+		IndexCID       string   `json:"indexCID,omitempty"`
+		DeltaCID       string   `json:"deltaCID,omitempty"`
+		ManifestCID    string   `json:"manifestCID,omitempty"`
+		Visibility     string   `json:"visibility,omitempty"`
+		License        string   `json:"license,omitempty"`
+		Mirrors        []string `json:"mirrors,omitempty"`
+		Nonce          string   `json:"nonce,omitempty"`
+	}{
+		Version:        m.Version,
+		IPNS:           m.IPNS,
+		PublicKey:      m.PublicKey,
+		CollectionSize: size,
+		Timestamp:      m.Timestamp,
+		RootCID:        m.RootCID,
+		IndexCID:       m.IndexCID,
+		DeltaCID:       m.DeltaCID,
+		ManifestCID:    m.ManifestCID,
+		Visibility:     m.Visibility,
+		License:        m.License,
+		Mirrors:        m.Mirrors,
+		Nonce:          m.Nonce,
+	}
+
+	return json.Marshal(msg)
+}
@@ -0,0 +1,172 @@
+package pubsub
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testdataDir is the repository-wide fixture directory shared with the publisher
+const testdataDir = "../../../../testdata"
+
+// loadAnnouncement parses an announcement golden file
+func loadAnnouncement(t *testing.T, name string) *Message {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(testdataDir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg Message
+	if err := json.Unmarshal(data, &msg); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return &msg
+}
+
+func TestVerifyPublisherSignedAnnouncements(t *testing.T) {
+	for _, name := range []string{"announcement-v1.json", "announcement-v2.json"} {
+		msg := loadAnnouncement(t, name)
+		if err := msg.Verify(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestVerifyAnnouncementWithoutNonce(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Publishers before nonces signed the same fields without one
+	msg := loadAnnouncement(t, "announcement-v2.json")
+	msg.PublicKey = base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+	msg.Nonce = ""
+	data, err := msg.signedBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "nonce") {
+		t.Errorf("signed bytes without a nonce contain it: %s", data)
+	}
+	msg.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, data))
+	if err := msg.Verify(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerifyWithRotatedKey(t *testing.T) {
+	_, rotated, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Naming the publisher's current key, but signed with the rotated-away one
+	msg := loadAnnouncement(t, "announcement-v2.json")
+	msg.PublicKey = base64.StdEncoding.EncodeToString(current)
+	data, err := msg.signedBytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(rotated, data))
+
+	if err := msg.Verify(); err == nil {
+		t.Error("expected a signature of another key to fail verification")
+	}
+
+	// Only the history of the key the message names counts
+	history := func(keys map[string][]ed25519.PublicKey) KeyHistory {
+		return func(publicKey string) ([]ed25519.PublicKey, error) {
+			return keys[publicKey], nil
+		}
+	}
+	if err := msg.VerifyWithHistory(history(map[string][]ed25519.PublicKey{msg.PublicKey: {rotated.Public().(ed25519.PublicKey)}})); err != nil {
+		t.Errorf("VerifyWithHistory with the rotated key in the publisher's history: %v", err)
+	}
+	if err := msg.VerifyWithHistory(history(map[string][]ed25519.PublicKey{"another-publisher": {rotated.Public().(ed25519.PublicKey)}})); err == nil {
+		t.Error("expected a key of another publisher's history to fail verification")
+	}
+	if err := msg.VerifyWithHistory(history(map[string][]ed25519.PublicKey{msg.PublicKey: {current}})); err == nil {
+		t.Error("expected verification with an unrelated historic key to fail")
+	}
+}
+
+func TestAnnouncementV2Fields(t *testing.T) {
+	msg := loadAnnouncement(t, "announcement-v2.json")
+
+	if msg.CollectionSize == nil || *msg.CollectionSize != 3 {
+		t.Errorf("CollectionSize = %v, want 3", msg.CollectionSize)
+	}
+	if msg.RootCID == "" || msg.IndexCID == "" || msg.DeltaCID == "" || msg.ManifestCID == "" {
+		t.Errorf("expected rootCID, indexCID, deltaCID and manifestCID to be set: %+v", msg)
+	}
+	if msg.Visibility != "unlisted" || msg.License != "CC-BY-4.0" {
+		t.Errorf("visibility/license = %q/%q", msg.Visibility, msg.License)
+	}
+	if len(msg.Mirrors) != 1 {
+		t.Errorf("Mirrors = %v, want one entry", msg.Mirrors)
+	}
+}
+
+func TestVerifyRejectsTamperedAnnouncement(t *testing.T) {
+	tests := map[string]func(*Message){
+		"version":    func(m *Message) { m.Version++ },
+		"indexCID":   func(m *Message) { m.IndexCID = "bafkreitampered" },
+		"manifest":   func(m *Message) { m.ManifestCID = "" },
+		"visibility": func(m *Message) { m.Visibility = "" },
+		"mirrors":    func(m *Message) { m.Mirrors = nil },
+		"nonce":      func(m *Message) { m.Nonce = "" },
+		"signature":  func(m *Message) { m.Signature = m.Signature[:10] },
+	}
+
+	for name, tamper := range tests {
+		msg := loadAnnouncement(t, "announcement-v2.json")
+		tamper(msg)
+		if err := msg.Verify(); err == nil {
+			t.Errorf("%s: expected tampered message to fail verification", name)
+		}
+	}
+}
+
+func TestValidateNames(t *testing.T) {
+	const (
+		k51    = "k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8"
+		peerID = "12D3KooWRBy97UB99e3J6hiPesre1MZeuNQvfan4gBziswrRJsNK"
+	)
+
+	for _, name := range []string{"announcement-v1.json", "announcement-v2.json"} {
+		if err := loadAnnouncement(t, name).Validate(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+
+	valid := loadAnnouncement(t, "announcement-v2.json")
+	valid.IPNS = k51
+	valid.Mirrors = []string{peerID, "/ipns/" + k51}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("k51 name and peer ID mirrors: %v", err)
+	}
+
+	tests := map[string]func(m *Message){
+		"garbage name":    func(m *Message) { m.IPNS = "k2k4r8notreal" },
+		"garbage mirror":  func(m *Message) { m.Mirrors = []string{k51, "not-a-name"} },
+		"missing version": func(m *Message) { m.Version = 0 },
+	}
+	for name, modify := range tests {
+		msg := loadAnnouncement(t, "announcement-v2.json")
+		modify(msg)
+		if err := msg.Validate(); err == nil {
+			t.Errorf("%s: Validate succeeded", name)
+		}
+	}
+}
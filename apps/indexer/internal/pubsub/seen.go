@@ -0,0 +1,55 @@
+package pubsub
+
+import (
+	"fmt"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+)
+
+// seenKey identifies an announcement by its publisher and nonce
+type seenKey struct {
+	publicKey string
+	nonce     string
+}
+
+// SeenCache remembers recently handled announcements, so a copy delivered again
+// by GossipSub is dropped. An announcement is remembered until its timestamp
+// plus the TTL; re-announcements after that carry a new nonce anyway, so the
+// TTL only bounds replays of an old message. The least recently seen entries
+// are evicted when the cache is full.
+type SeenCache struct {
+	entries *lru.Cache[seenKey, time.Time] // Expiry of each announcement
+	ttl     time.Duration
+}
+
+// NewSeenCache creates a cache of size announcements, each remembered until ttl
+// after its timestamp
+func NewSeenCache(size int, ttl time.Duration) (*SeenCache, error) {
+	entries, err := lru.New[seenKey, time.Time](size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create message cache: %w", err)
+	}
+	return &SeenCache{entries: entries, ttl: ttl}, nil
+}
+
+// Seen reports whether msg was handled before and not yet expired at now, and
+// otherwise remembers it. Messages without a nonce, from older publishers, are
+// never reported as seen.
+func (c *SeenCache) Seen(msg *Message, now time.Time) bool {
+	if msg.Nonce == "" {
+		return false
+	}
+
+	key := seenKey{msg.PublicKey, msg.Nonce}
+	if expiry, ok := c.entries.Get(key); ok && now.Before(expiry) {
+		return true
+	}
+	c.entries.Add(key, time.Unix(msg.Timestamp, 0).Add(c.ttl))
+	return false
+}
+
+// Len returns the number of remembered announcements, expired ones included
+func (c *SeenCache) Len() int {
+	return c.entries.Len()
+}
@@ -0,0 +1,55 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeenCache(t *testing.T) {
+	now := time.Unix(1764260509, 0)
+	cache, err := NewSeenCache(2, 2*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &Message{PublicKey: "publisher", Nonce: "n1", Timestamp: now.Unix()}
+	if cache.Seen(msg, now) {
+		t.Error("first delivery reported as seen")
+	}
+	if !cache.Seen(msg, now.Add(time.Minute)) {
+		t.Error("second delivery not reported as seen")
+	}
+
+	// The same nonce from another publisher is a different announcement
+	other := &Message{PublicKey: "other", Nonce: "n1", Timestamp: now.Unix()}
+	if cache.Seen(other, now) {
+		t.Error("announcement of another publisher reported as seen")
+	}
+
+	// Replays after the timestamp plus the TTL are handled again
+	if cache.Seen(msg, now.Add(3*time.Hour)) {
+		t.Error("expired announcement reported as seen")
+	}
+
+	// Without a nonce nothing can be told apart
+	old := &Message{PublicKey: "publisher", Timestamp: now.Unix()}
+	if cache.Seen(old, now) || cache.Seen(old, now) {
+		t.Error("announcement without a nonce reported as seen")
+	}
+
+	// The least recently seen announcement is evicted
+	third := &Message{PublicKey: "publisher", Nonce: "n3", Timestamp: now.Unix()}
+	cache.Seen(third, now)
+	if cache.Len() != 2 {
+		t.Errorf("Len = %d, want 2", cache.Len())
+	}
+	if cache.Seen(other, now) {
+		t.Error("evicted announcement reported as seen")
+	}
+}
+
+func TestNewSeenCacheRejectsZeroSize(t *testing.T) {
+	if _, err := NewSeenCache(0, time.Hour); err == nil {
+		t.Error("expected an error for a cache of size 0")
+	}
+}
@@ -0,0 +1,122 @@
+package share
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/atregu/ipfs-common/share"
+	"github.com/atregu/ipfs-common/topic"
+	"github.com/sirupsen/logrus"
+
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+)
+
+// HostKey is the host recorded for collections registered from a share document
+// rather than received over PubSub
+const HostKey = "share-import"
+
+// Result reports what Register did with each collection of a share document
+type Result struct {
+	Created []*database.Collection // Collections stored as pending
+	Known   []string               // IPNS names already stored at the same or a newer version
+	Refused []string               // IPNS names refused by the publisher quota
+}
+
+// Load reads an identity from a file path or an mdn://share/ URI
+func Load(source string) (*share.Identity, error) {
+	if strings.HasPrefix(source, share.URIPrefix) {
+		return share.Parse([]byte(source))
+	}
+
+	data, err := os.ReadFile(source)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read share file: %w", err)
+	}
+	return share.Parse(data)
+}
+
+// Register verifies the identity and stores its collections as pending,
+// bypassing PubSub discovery. The suggested topic must pass the topic allowlist
+// and each collection is subject to the same publisher quota as announcements.
+func Register(db *database.DB, id *share.Identity, cfg *config.Config, log *logrus.Logger) (*Result, error) {
+	if err := id.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid share document: %w", err)
+	}
+	if err := id.Verify(); err != nil {
+		return nil, fmt.Errorf("invalid share document: %w", err)
+	}
+
+	if id.Topic != "" {
+		if err := topic.Validate(id.Topic); err != nil {
+			return nil, fmt.Errorf("invalid share document: %w", err)
+		}
+		allowed, err := config.TopicAllowed(id.Topic, cfg.Pubsub.TopicAllowlist)
+		if err != nil {
+			return nil, err
+		}
+		if !allowed {
+			return nil, fmt.Errorf("share document topic %q is not in pubsub.topic_allowlist", id.Topic)
+		}
+		if id.Topic != cfg.Pubsub.Topic {
+			log.Infof("Share document suggests topic %q; set pubsub.topic to receive its updates", id.Topic)
+		}
+	}
+
+	host, err := db.CreateOrGetHost(HostKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create/get host: %w", err)
+	}
+
+	publisher, err := db.CreateOrGetPublisher(id.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create/get publisher: %w", err)
+	}
+	// Keys the current one vouches for verify the publisher's announcements too
+	if err := db.AddPublisherKeys(publisher.ID, id.PreviousKeys); err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+	for _, c := range id.Collections {
+		// Documents without a version describe the first one
+		version := max(c.Version, 1)
+
+		latest, err := db.GetLatestCollectionVersion(publisher.ID, c.IPNS)
+		if err != nil {
+			return result, err
+		}
+		if latest >= version {
+			log.Infof("Shared collection IPNS=%s is already known at version %d", c.IPNS, latest)
+			result.Known = append(result.Known, c.IPNS)
+			continue
+		}
+
+		if cfg.Limits.MaxItemsPerPublisher > 0 {
+			count, err := db.CountPublisherItems(publisher.ID)
+			if err != nil {
+				return result, fmt.Errorf("failed to count publisher items: %w", err)
+			}
+			if count >= cfg.Limits.MaxItemsPerPublisher {
+				if err := db.IncrementPublisherRefusals(publisher.ID); err != nil {
+					log.Errorf("Failed to record refusal for publisher ID=%d: %v", publisher.ID, err)
+				}
+				log.Warnf("Refusing shared collection IPNS=%s: publisher ID=%d has %d items (limit %d)",
+					c.IPNS, publisher.ID, count, cfg.Limits.MaxItemsPerPublisher)
+				result.Refused = append(result.Refused, c.IPNS)
+				continue
+			}
+		}
+
+		collection, err := db.CreateCollection(host.ID, publisher.ID, version, c.IPNS, nil, id.Timestamp)
+		if err != nil {
+			return result, fmt.Errorf("failed to create collection %s: %w", c.IPNS, err)
+		}
+		log.Infof("Registered shared collection: ID=%d, IPNS=%s, Version=%d, Title=%q, Status=pending",
+			collection.ID, c.IPNS, version, c.Title)
+		result.Created = append(result.Created, collection)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,245 @@
+package share
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/atregu/ipfs-common/share"
+	"github.com/atregu/ipfs-common/topic"
+	"github.com/sirupsen/logrus"
+
+	"github.com/atregu/ipfs-indexer/internal/config"
+	"github.com/atregu/ipfs-indexer/internal/database"
+)
+
+const (
+	testIPNS  = "k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8"
+	testIPNS2 = "k51qzi5uqu5dkweh3vfy3ac59oobbnehs3ojsno0sog1nbvc70kt7tgbxvmqgh"
+)
+
+// newTestDB creates a migrated database in a temporary directory
+func newTestDB(t *testing.T, log *logrus.Logger) *database.DB {
+	t.Helper()
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"), log)
+	if err != nil {
+		t.Fatalf("failed to create database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newTestConfig returns a config with the default topic and no limits
+func newTestConfig() *config.Config {
+	cfg := &config.Config{}
+	cfg.Pubsub.Topic = topic.Default
+	return cfg
+}
+
+// shareURI signs a document for collections with key, as ipfs-publisher share does
+func shareURI(t *testing.T, key ed25519.PrivateKey, topic string, collections ...share.Collection) string {
+	t.Helper()
+
+	id := share.NewIdentity(collections, topic, nil)
+	if err := id.Sign(key); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	uri, err := id.ToURI()
+	if err != nil {
+		t.Fatalf("ToURI: %v", err)
+	}
+	return uri
+}
+
+// register loads source and registers it
+func register(t *testing.T, db *database.DB, source string, cfg *config.Config, log *logrus.Logger) (*Result, error) {
+	t.Helper()
+
+	id, err := Load(source)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	return Register(db, id, cfg, log)
+}
+
+func TestRegisterRoundTrip(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	db := newTestDB(t, log)
+	cfg := newTestConfig()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	uri := shareURI(t, key, topic.Default,
+		share.Collection{IPNS: testIPNS, Title: "Music", Version: 4},
+		share.Collection{IPNS: testIPNS2, Title: "Books"})
+
+	// The same document as a JSON file
+	id, err := Load(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc, err := id.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "share.json")
+	if err := os.WriteFile(file, doc, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := register(t, db, uri, cfg, log)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if len(result.Created) != 2 {
+		t.Fatalf("created %d collections, want 2", len(result.Created))
+	}
+	for i, want := range []struct {
+		ipns    string
+		version int
+	}{{testIPNS, 4}, {testIPNS2, 1}} {
+		stored, err := db.GetCollection(result.Created[i].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stored.IPNS != want.ipns || stored.Version != want.version || stored.Status != "pending" {
+			t.Errorf("collection %d = %s v%d %s, want %s v%d pending",
+				i, stored.IPNS, stored.Version, stored.Status, want.ipns, want.version)
+		}
+	}
+
+	// Registering the same document again, from the file, adds nothing
+	result, err = register(t, db, file, cfg, log)
+	if err != nil {
+		t.Fatalf("Register again: %v", err)
+	}
+	if len(result.Created) != 0 || len(result.Known) != 2 {
+		t.Errorf("second register created %d, known %d; want 0, 2", len(result.Created), len(result.Known))
+	}
+
+	// A newer version is registered
+	result, err = register(t, db, shareURI(t, key, "", share.Collection{IPNS: testIPNS, Version: 5}), cfg, log)
+	if err != nil {
+		t.Fatalf("Register newer: %v", err)
+	}
+	if len(result.Created) != 1 || result.Created[0].Version != 5 {
+		t.Errorf("newer version: created %+v, want version 5", result.Created)
+	}
+}
+
+func TestRegisterRecordsPreviousKeys(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	db := newTestDB(t, log)
+
+	public, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := share.NewIdentity([]share.Collection{{IPNS: testIPNS}}, topic.Default, nil)
+	id.PreviousKeys = []string{base64.StdEncoding.EncodeToString(previous)}
+	if err := id.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Register(db, id, newTestConfig(), log); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	keys, err := db.GetPublisherKeys(base64.StdEncoding.EncodeToString(public))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || keys[0] != id.PreviousKeys[0] {
+		t.Errorf("GetPublisherKeys = %v, want the previous key of the document", keys)
+	}
+}
+
+func TestRegisterRejects(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]func(cfg *config.Config) *share.Identity{
+		"tampered": func(cfg *config.Config) *share.Identity {
+			id, _ := Load(shareURI(t, key, "", share.Collection{IPNS: testIPNS, Title: "Music"}))
+			id.Collections[0].Title = "Other"
+			return id
+		},
+		"invalid ipns": func(cfg *config.Config) *share.Identity {
+			id := share.NewIdentity([]share.Collection{{IPNS: "k2k4r8notreal"}}, "", nil)
+			id.Sign(key)
+			return id
+		},
+		"topic not allowed": func(cfg *config.Config) *share.Identity {
+			cfg.Pubsub.TopicAllowlist = []string{"mdn/*/announce"}
+			id, _ := Load(shareURI(t, key, "other/topic", share.Collection{IPNS: testIPNS}))
+			return id
+		},
+	}
+
+	for name, build := range tests {
+		db := newTestDB(t, log)
+		cfg := newTestConfig()
+		if _, err := Register(db, build(cfg), cfg, log); err == nil {
+			t.Errorf("%s: Register succeeded", name)
+		}
+	}
+}
+
+func TestRegisterQuota(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+	db := newTestDB(t, log)
+	cfg := newTestConfig()
+	cfg.Limits.MaxItemsPerPublisher = 1
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := register(t, db, shareURI(t, key, "", share.Collection{IPNS: testIPNS}), cfg, log)
+	if err != nil || len(result.Created) != 1 {
+		t.Fatalf("first register: %+v, %v", result, err)
+	}
+
+	// Fill the quota with an item of the registered collection
+	c := result.Created[0]
+	if err := db.CreateOrUpdateIndexItem("cid1", "a.mp3", "mp3", "", database.ItemFile{}, false, c.HostID, c.PublisherID, c.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = register(t, db, shareURI(t, key, "", share.Collection{IPNS: testIPNS2}), cfg, log)
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if len(result.Created) != 0 || len(result.Refused) != 1 || result.Refused[0] != testIPNS2 {
+		t.Errorf("over quota: created %d, refused %v; want 0, [%s]", len(result.Created), result.Refused, testIPNS2)
+	}
+
+	usage, err := db.GetPublisherUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(usage) != 1 || usage[0].RefusedCollections != 1 {
+		t.Errorf("usage = %+v, want one refusal", usage)
+	}
+}
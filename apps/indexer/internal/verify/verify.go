@@ -0,0 +1,322 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/atregu/ipfs-indexer/internal/parser"
+	"github.com/sirupsen/logrus"
+)
+
+// Kinds of problems a run finds
+const (
+	// Unpinned collections have an index that is not pinned, so garbage collection may remove it
+	Unpinned = "unpinned"
+	// Unretrievable collections have an index that could not be read within the timeout
+	Unretrievable = "unretrievable"
+	// CountMismatch collections store other items than their index holds
+	CountMismatch = "count-mismatch"
+	// UnknownPin is a recursive pin of the node that no collection accounts for
+	UnknownPin = "unknown-pin"
+)
+
+// StatusUnretrievable is the status --fix gives a downloaded collection whose
+// index could not be read. Its items are no longer served.
+const StatusUnretrievable = "unretrievable"
+
+// Node is the IPFS access of a run (implemented by ipfs.Client)
+type Node interface {
+	IsPinned(ctx context.Context, cid string) (bool, error)
+	RecursivePins(ctx context.Context) ([]string, error)
+	ResolveIndexFile(ctx context.Context, rootCID string) (string, error)
+	Cat(ctx context.Context, cid string) (io.ReadCloser, error)
+	Pin(ctx context.Context, cid string) error
+}
+
+// Reparser parses a collection again from its pinned index (implemented by fetcher.Fetcher)
+type Reparser interface {
+	Reparse(ctx context.Context, collectionID int64) error
+}
+
+// Options controls a run
+type Options struct {
+	// Fix re-pins, re-parses and marks unretrievable collections as it finds them
+	Fix bool
+
+	// Concurrency is the number of collections checked at once
+	Concurrency int
+
+	// Timeout bounds reading the index of each collection and resolving each pin
+	Timeout time.Duration
+
+	// KnownPins are pins held for other reasons, such as the aggregate's root
+	KnownPins []string
+}
+
+// Problem is an inconsistency found by a run
+type Problem struct {
+	Kind         string
+	CollectionID int64 // Zero for unknown pins
+	CID          string
+	Detail       string
+	Fixed        string // What the fix did; empty if nothing was fixed
+	FixErr       error
+}
+
+// Report is the outcome of a run
+type Report struct {
+	Collections int // Downloaded collections checked
+	Pins        int // Recursive pins checked
+	Problems    []Problem
+}
+
+// Fixed returns the number of problems that were fixed
+func (r *Report) Fixed() int {
+	n := 0
+	for _, p := range r.Problems {
+		if p.Fixed != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// ExitCode returns 0 if no problem was found and 1 otherwise, fixed or not
+func (r *Report) ExitCode() int {
+	if len(r.Problems) == 0 {
+		return 0
+	}
+	return 1
+}
+
+// Print writes a human readable summary
+func (r *Report) Print(w io.Writer) {
+	for _, p := range r.Problems {
+		subject := fmt.Sprintf("collection %d", p.CollectionID)
+		if p.CollectionID == 0 {
+			subject = "pin"
+		}
+		if p.CID != "" {
+			subject += " " + p.CID
+		}
+		fmt.Fprintf(w, "✗ %-14s %s: %s\n", p.Kind, subject, p.Detail)
+		switch {
+		case p.FixErr != nil:
+			fmt.Fprintf(w, "  fix failed: %v\n", p.FixErr)
+		case p.Fixed != "":
+			fmt.Fprintf(w, "  fixed: %s\n", p.Fixed)
+		}
+	}
+
+	switch {
+	case len(r.Problems) == 0:
+		fmt.Fprintf(w, "No problems in %d downloaded collections and %d pins\n", r.Collections, r.Pins)
+	default:
+		fmt.Fprintf(w, "\n%d problem(s) in %d downloaded collections and %d pins, %d fixed\n",
+			len(r.Problems), r.Collections, r.Pins, r.Fixed())
+	}
+}
+
+// Verifier compares the downloaded collections in the database with the pins
+// and content of the IPFS node
+type Verifier struct {
+	node     Node
+	db       *database.DB
+	parser   *parser.Parser
+	reparser Reparser
+	log      *logrus.Logger
+}
+
+// New creates a verifier. The parser only counts the items of indexes; nothing
+// is stored unless reparser parses a collection again.
+func New(node Node, db *database.DB, p *parser.Parser, reparser Reparser, log *logrus.Logger) *Verifier {
+	return &Verifier{node: node, db: db, parser: p, reparser: reparser, log: log}
+}
+
+// Run checks that the index of every downloaded collection is pinned, can be
+// read and holds the items stored for it, then that every recursive pin of the
+// node belongs to a collection. With opts.Fix set the problems are fixed after
+// all collections were checked: unpinned indexes are pinned, miscounted
+// collections are parsed again and unreadable ones are marked
+// StatusUnretrievable. Unknown pins are only reported.
+func (v *Verifier) Run(ctx context.Context, opts Options) (*Report, error) {
+	collections, err := v.db.ListCollectionsByStatus("downloaded")
+	if err != nil {
+		return nil, err
+	}
+
+	found := make([][]Problem, len(collections))
+	sem := make(chan struct{}, max(opts.Concurrency, 1))
+	var wg sync.WaitGroup
+	for i, c := range collections {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			found[i] = v.checkCollection(ctx, c, opts.Timeout)
+		}()
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	report := &Report{Collections: len(collections)}
+	for i, c := range collections {
+		for _, p := range found[i] {
+			if opts.Fix {
+				v.fix(ctx, c, &p, opts.Timeout)
+			}
+			report.Problems = append(report.Problems, p)
+		}
+	}
+
+	pins, unknown, err := v.checkPins(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	report.Pins = pins
+	report.Problems = append(report.Problems, unknown...)
+	return report, nil
+}
+
+// checkCollection checks the pin, the retrievability and the item count of
+// the index of a downloaded collection
+func (v *Verifier) checkCollection(ctx context.Context, c *database.Collection, timeout time.Duration) []Problem {
+	problem := func(kind, format string, args ...interface{}) Problem {
+		return Problem{Kind: kind, CollectionID: c.ID, CID: c.IndexCID, Detail: fmt.Sprintf(format, args...)}
+	}
+
+	if c.IndexCID == "" {
+		return []Problem{problem(Unretrievable, "no index CID recorded")}
+	}
+
+	var problems []Problem
+	pinned, err := v.node.IsPinned(ctx, c.IndexCID)
+	if err != nil {
+		problems = append(problems, problem(Unpinned, "failed to check the pin: %v", err))
+	} else if !pinned {
+		problems = append(problems, problem(Unpinned, "the index is not pinned"))
+	}
+
+	items, err := v.countItems(ctx, c, timeout)
+	if err != nil {
+		// Pinning an unreadable index would only hang
+		return []Problem{problem(Unretrievable, "%v", err)}
+	}
+
+	stored, err := v.db.CountCollectionItems(c.ID)
+	if err != nil {
+		v.log.Errorf("Failed to count the items of collection ID=%d: %v", c.ID, err)
+		return problems
+	}
+	if stored != items {
+		problems = append(problems, problem(CountMismatch, "the index holds %d items, the database %d", items, stored))
+	}
+	return problems
+}
+
+// countItems reads the index of a collection within timeout and returns the
+// number of distinct CIDs among its items, which is what the database stores
+func (v *Verifier) countItems(ctx context.Context, c *database.Collection, timeout time.Duration) (int, error) {
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	reader, err := v.node.Cat(ctx, c.IndexCID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the index: %w", err)
+	}
+	defer reader.Close()
+
+	preview, err := v.parser.Preview(c, reader, "")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the index: %w", err)
+	}
+	return preview.CIDs, nil
+}
+
+// fix fixes a problem of collection c and records the outcome in p
+func (v *Verifier) fix(ctx context.Context, c *database.Collection, p *Problem, timeout time.Duration) {
+	switch p.Kind {
+	case Unpinned:
+		// The fetcher pins the announced root, which holds the index
+		target := c.IndexCID
+		if c.RootCID != "" {
+			target = c.RootCID
+		}
+		pinCtx, cancel := withTimeout(ctx, timeout)
+		defer cancel()
+		if p.FixErr = v.node.Pin(pinCtx, target); p.FixErr == nil {
+			p.Fixed = "pinned " + target
+		}
+	case CountMismatch:
+		if p.FixErr = v.reparser.Reparse(ctx, c.ID); p.FixErr == nil {
+			p.Fixed = "parsed again from the index"
+		}
+	case Unretrievable:
+		if p.FixErr = v.db.UpdateCollectionStatus(c.ID, StatusUnretrievable, c.Size); p.FixErr == nil {
+			p.Fixed = "marked " + StatusUnretrievable
+		}
+	}
+
+	if p.FixErr != nil {
+		v.log.Warnf("Failed to fix %s collection ID=%d: %v", p.Kind, c.ID, p.FixErr)
+	}
+}
+
+// checkPins returns the number of recursive pins of the node and a problem for
+// each that is neither the root or index of a collection nor in opts.KnownPins.
+// A pinned directory holding the index of a collection belongs to it, as the
+// fetcher pins resolved roots without recording them.
+func (v *Verifier) checkPins(ctx context.Context, opts Options) (int, []Problem, error) {
+	collections, err := v.db.ListCollectionsByStatus()
+	if err != nil {
+		return 0, nil, err
+	}
+	known := make(map[string]bool)
+	for _, c := range collections {
+		known[c.RootCID] = true
+		known[c.IndexCID] = true
+	}
+	for _, cid := range opts.KnownPins {
+		known[cid] = true
+	}
+	delete(known, "")
+
+	pins, err := v.node.RecursivePins(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	sort.Strings(pins)
+
+	var problems []Problem
+	for _, pin := range pins {
+		if known[pin] {
+			continue
+		}
+
+		resolveCtx, cancel := withTimeout(ctx, opts.Timeout)
+		indexCID, err := v.node.ResolveIndexFile(resolveCtx, pin)
+		cancel()
+		if err == nil && known[indexCID] {
+			continue
+		}
+
+		problems = append(problems, Problem{Kind: UnknownPin, CID: pin, Detail: "no collection in the database holds it"})
+	}
+	return len(pins), problems, nil
+}
+
+// withTimeout returns ctx bounded by timeout, or ctx itself if timeout is zero
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
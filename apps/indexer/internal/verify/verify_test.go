@@ -0,0 +1,214 @@
+package verify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-indexer/internal/database"
+	"github.com/atregu/ipfs-indexer/internal/parser"
+	"github.com/sirupsen/logrus"
+)
+
+// fakeNode serves indexes by CID; content it does not have cannot be read
+type fakeNode struct {
+	indexes map[string]string // Index CID -> content
+	pinned  map[string]bool   // CIDs pinned directly or as part of a recursive pin
+	pins    []string          // Recursive pins
+	dirs    map[string]string // Root directory CID -> index CID
+}
+
+func (n *fakeNode) IsPinned(ctx context.Context, cid string) (bool, error) {
+	return n.pinned[cid], nil
+}
+
+func (n *fakeNode) RecursivePins(ctx context.Context) ([]string, error) {
+	return n.pins, nil
+}
+
+func (n *fakeNode) ResolveIndexFile(ctx context.Context, rootCID string) (string, error) {
+	if indexCID, ok := n.dirs[rootCID]; ok {
+		return indexCID, nil
+	}
+	return "", errors.New("not a collection root")
+}
+
+func (n *fakeNode) Cat(ctx context.Context, cid string) (io.ReadCloser, error) {
+	content, ok := n.indexes[cid]
+	if !ok {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	return io.NopCloser(strings.NewReader(content)), nil
+}
+
+func (n *fakeNode) Pin(ctx context.Context, cid string) error {
+	n.pinned[cid] = true
+	return nil
+}
+
+// reparseFunc is a Reparser calling itself
+type reparseFunc func(ctx context.Context, collectionID int64) error
+
+func (f reparseFunc) Reparse(ctx context.Context, collectionID int64) error {
+	return f(ctx, collectionID)
+}
+
+// indexLines builds an index with n records; the last two share a CID
+func indexLines(n int) string {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		cid := i
+		if i == n && n > 1 {
+			cid = n - 1
+		}
+		fmt.Fprintf(&b, `{"id":%d,"CID":"cid%d","filename":"file%d.mp3","extension":"mp3"}`+"\n", i, cid, i)
+	}
+	return b.String()
+}
+
+func TestRun(t *testing.T) {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	db, err := database.New(filepath.Join(t.TempDir(), "test.db"), log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	host, err := db.CreateOrGetHost("host-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	publisher, err := db.CreateOrGetPublisher("publisher-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := parser.NewParser(db, nil, 0, log)
+
+	node := &fakeNode{
+		indexes: map[string]string{"index-ok": indexLines(4), "index-unpinned": indexLines(2), "index-short": indexLines(4)},
+		pinned:  map[string]bool{"root-ok": true, "index-ok": true, "index-short": true},
+		pins:    []string{"root-ok", "dir-short", "aggregate", "stray"},
+		dirs:    map[string]string{"dir-short": "index-short"},
+	}
+
+	// collection stores a downloaded collection with the first lines of its index
+	collection := func(version int, rootCID, indexCID string, lines int) *database.Collection {
+		t.Helper()
+		c, err := db.CreateCollection(host.ID, publisher.ID, version, fmt.Sprintf("k51test%d", version), nil, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := db.SetCollectionAnnouncedCIDs(c.ID, rootCID, indexCID); err != nil {
+			t.Fatal(err)
+		}
+		content := strings.Join(strings.SplitAfter(node.indexes[indexCID], "\n")[:lines], "")
+		if _, err := p.ParseAndStore(c, strings.NewReader(content)); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.UpdateCollectionStatus(c.ID, "downloaded", nil); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	collection(1, "root-ok", "index-ok", 4)
+	unpinned := collection(2, "", "index-unpinned", 2)
+	short := collection(3, "", "index-short", 2)
+	lost := collection(4, "", "index-lost", 0)
+	if _, err := db.CreateCollection(host.ID, publisher.ID, 5, "k51pending", nil, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	var reparsed []int64
+	reparser := reparseFunc(func(ctx context.Context, collectionID int64) error {
+		reparsed = append(reparsed, collectionID)
+		c, err := db.GetCollection(collectionID)
+		if err != nil {
+			return err
+		}
+		_, err = p.ParseAndStore(c, strings.NewReader(node.indexes[c.IndexCID]))
+		return err
+	})
+
+	v := New(node, db, p, reparser, log)
+	opts := Options{Concurrency: 2, Timeout: 50 * time.Millisecond, KnownPins: []string{"aggregate"}}
+
+	type found struct {
+		kind string
+		id   int64
+		cid  string
+	}
+	want := []found{
+		{Unpinned, unpinned.ID, "index-unpinned"},
+		{CountMismatch, short.ID, "index-short"},
+		{Unretrievable, lost.ID, "index-lost"},
+		{UnknownPin, 0, "stray"},
+	}
+	check := func(report *Report) {
+		t.Helper()
+		var got []found
+		for _, p := range report.Problems {
+			got = append(got, found{p.Kind, p.CollectionID, p.CID})
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("problems = %v, want %v", got, want)
+		}
+		if report.Collections != 4 || report.Pins != 4 || report.ExitCode() != 1 {
+			t.Errorf("checked %d collections and %d pins with exit code %d, want 4, 4 and 1",
+				report.Collections, report.Pins, report.ExitCode())
+		}
+	}
+
+	// Without --fix nothing changes
+	report, err := v.Run(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(report)
+	if report.Fixed() != 0 || node.pinned["index-unpinned"] || len(reparsed) != 0 {
+		t.Errorf("run without fix changed something: %d fixed, reparsed %v", report.Fixed(), reparsed)
+	}
+
+	opts.Fix = true
+	report, err = v.Run(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(report)
+	if report.Fixed() != 3 {
+		t.Errorf("fixed %d problems, want 3 (unknown pins are only reported)", report.Fixed())
+	}
+	if !node.pinned["index-unpinned"] {
+		t.Error("unpinned index was not pinned")
+	}
+	if !reflect.DeepEqual(reparsed, []int64{short.ID}) {
+		t.Errorf("reparsed %v, want %d", reparsed, short.ID)
+	}
+	if c, err := db.GetCollection(lost.ID); err != nil || c.Status != StatusUnretrievable {
+		t.Errorf("unreadable collection = %+v, %v, want status %s", c, err, StatusUnretrievable)
+	}
+
+	var out bytes.Buffer
+	report.Print(&out)
+	if !strings.Contains(out.String(), "fixed: pinned index-unpinned") || !strings.Contains(out.String(), "4 problem(s)") {
+		t.Errorf("report:\n%s", out.String())
+	}
+
+	// Once fixed and the stray pin is gone, nothing is found
+	node.pins = node.pins[:3]
+	report, err = v.Run(context.Background(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 0 || report.ExitCode() != 0 || report.Collections != 3 {
+		t.Errorf("run after fixing found %+v in %d collections", report.Problems, report.Collections)
+	}
+}
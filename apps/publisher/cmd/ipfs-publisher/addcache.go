@@ -0,0 +1,125 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"sync"
+)
+
+// addCache maps content hashes to the CIDs they were recently added as, so
+// identical files are added once. An add of content that is already being added
+// waits for that add and reuses its CID. The cache keeps at most size entries,
+// dropping the least recently used.
+type addCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List               // Entries, most recently used first
+	entries map[string]*list.Element // Content hash to its element in order
+	adding  map[string]*pendingAdd   // Content hashes being added
+}
+
+// addCacheEntry is a cached content hash and its CID
+type addCacheEntry struct {
+	hash string
+	cid  string
+}
+
+// pendingAdd is an add in progress; done is closed once it finished
+type pendingAdd struct {
+	done chan struct{}
+	cid  string
+	err  error
+}
+
+// newAddCache creates a cache of at most size entries
+func newAddCache(size int) *addCache {
+	return &addCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+		adding:  make(map[string]*pendingAdd),
+	}
+}
+
+// add returns the CID of the content with the given hash, calling addFn only if
+// it is neither cached nor being added. shared reports that the CID came from
+// the cache or another caller's add. A failed add is not cached: callers that
+// waited for it run addFn themselves.
+func (c *addCache) add(ctx context.Context, hash string, addFn func() (string, error)) (cid string, shared bool, err error) {
+	for {
+		c.mu.Lock()
+		if elem, ok := c.entries[hash]; ok {
+			c.order.MoveToFront(elem)
+			cid := elem.Value.(*addCacheEntry).cid
+			c.mu.Unlock()
+			return cid, true, nil
+		}
+
+		pending, ok := c.adding[hash]
+		if !ok {
+			pending = &pendingAdd{done: make(chan struct{})}
+			c.adding[hash] = pending
+			c.mu.Unlock()
+			return c.run(hash, pending, addFn)
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-pending.done:
+			if pending.err == nil {
+				return pending.cid, true, nil
+			}
+		case <-ctx.Done():
+			return "", false, ctx.Err()
+		}
+	}
+}
+
+// run performs the add of hash, caches its CID on success and wakes the callers
+// waiting for it
+func (c *addCache) run(hash string, pending *pendingAdd, addFn func() (string, error)) (string, bool, error) {
+	pending.cid, pending.err = addFn()
+
+	c.mu.Lock()
+	delete(c.adding, hash)
+	if pending.err == nil {
+		c.store(hash, pending.cid)
+	}
+	c.mu.Unlock()
+	close(pending.done)
+
+	return pending.cid, false, pending.err
+}
+
+// store caches the CID of hash, evicting the least recently used entry when full.
+// The caller holds mu.
+func (c *addCache) store(hash, cid string) {
+	if elem, ok := c.entries[hash]; ok {
+		elem.Value.(*addCacheEntry).cid = cid
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[hash] = c.order.PushFront(&addCacheEntry{hash: hash, cid: cid})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*addCacheEntry).hash)
+	}
+}
+
+// forget drops the entries of cid, e.g. after it could not be pinned, so the
+// next file with its content is added again
+func (c *addCache) forget(cid string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		if entry := elem.Value.(*addCacheEntry); entry.cid == cid {
+			c.order.Remove(elem)
+			delete(c.entries, entry.hash)
+		}
+		elem = next
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/atregu/ipfs-publisher/internal/ipfs"
+)
+
+// gatedClient is an ipfs.Client whose Add counts its calls and blocks until
+// release is closed. It is safe for concurrent use.
+type gatedClient struct {
+	fakeClient
+	mu      sync.Mutex
+	calls   int
+	started chan struct{} // Closed by the first Add
+	release chan struct{}
+}
+
+func (c *gatedClient) Add(ctx context.Context, reader io.Reader, filename string, opts ipfs.AddOptions) (*ipfs.AddResult, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.calls++
+	if c.calls == 1 {
+		close(c.started)
+	}
+	c.mu.Unlock()
+
+	<-c.release
+	return &ipfs.AddResult{CID: "cid-" + string(data), Size: uint64(len(data)), Name: filename}, nil
+}
+
+func TestIdenticalFilesAddedOnce(t *testing.T) {
+	dir := t.TempDir()
+	const copies = 8
+	for i := 0; i < copies; i++ {
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("copy%d.mp3", i)), []byte("same"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &gatedClient{started: make(chan struct{}), release: make(chan struct{})}
+	a := newTestApp(t, dir, client)
+	a.dedupe = newAddCache(16)
+
+	pending := scanPending(t, a)
+	if len(pending) != copies {
+		t.Fatalf("%d pending files, want %d", len(pending), copies)
+	}
+
+	// Every copy is scheduled at once; the first add holds the others back
+	var wg sync.WaitGroup
+	errs := make(chan error, copies)
+	for i := range pending {
+		wg.Add(1)
+		go func(file int) {
+			defer wg.Done()
+			errs <- a.uploadFile(context.Background(), &pending[file], nil)
+		}(i)
+	}
+	<-client.started
+	close(client.release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("uploadFile: %v", err)
+		}
+	}
+	if client.calls != 1 {
+		t.Errorf("%d Add calls, want 1", client.calls)
+	}
+	for i := range pending {
+		fs, ok := a.state.GetStagedFile(pending[i].Path)
+		if !ok || fs.CID != "cid-same" {
+			t.Errorf("%s staged as %+v, want cid-same", pending[i].Name, fs)
+		}
+	}
+}
+
+func TestPublishedContentIsNotAddedAgain(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "album.mp3"), []byte("rip"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &fakeClient{}
+	a := newTestApp(t, dir, client)
+	a.dedupe = newAddCache(16)
+	ctx := context.Background()
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A backup copy found after a restart, with nothing in the add cache
+	a.dedupe = newAddCache(16)
+	if err := os.Mkdir(filepath.Join(dir, "backup"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "backup", "album.mp3"), []byte("rip"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if client.adds != 1 {
+		t.Errorf("adds = %d, want 1: published content was added again", client.adds)
+	}
+	if fs, ok := a.state.GetFile(filepath.Join(dir, "backup", "album.mp3")); !ok || fs.CID != "cid-rip" {
+		t.Errorf("copy recorded as %+v, want the published CID cid-rip", fs)
+	}
+}
+
+func TestAddCacheFailedAddIsNotCached(t *testing.T) {
+	c := newAddCache(4)
+	ctx := context.Background()
+
+	failed := errors.New("add failed")
+	if _, _, err := c.add(ctx, "h", func() (string, error) { return "", failed }); !errors.Is(err, failed) {
+		t.Fatalf("add = %v, want the add error", err)
+	}
+
+	cid, shared, err := c.add(ctx, "h", func() (string, error) { return "cid", nil })
+	if err != nil || shared || cid != "cid" {
+		t.Errorf("add after a failure = %q, %v, %v; want a fresh add of cid", cid, shared, err)
+	}
+
+	cid, shared, _ = c.add(ctx, "h", func() (string, error) { return "other", nil })
+	if !shared || cid != "cid" {
+		t.Errorf("cached add = %q, %v; want the cached cid", cid, shared)
+	}
+
+	c.forget("cid")
+	if _, shared, _ = c.add(ctx, "h", func() (string, error) { return "cid", nil }); shared {
+		t.Error("forgotten CID was reused")
+	}
+}
+
+func TestAddCacheWaiterRetriesFailedAdd(t *testing.T) {
+	c := newAddCache(4)
+	ctx := context.Background()
+
+	started, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, _, err := c.add(ctx, "h", func() (string, error) {
+			close(started)
+			<-release
+			return "", errors.New("add failed")
+		})
+		done <- err
+	}()
+	<-started
+
+	waiter := make(chan string)
+	go func() {
+		cid, _, _ := c.add(ctx, "h", func() (string, error) { return "retried", nil })
+		waiter <- cid
+	}()
+
+	close(release)
+	if err := <-done; err == nil {
+		t.Error("first add succeeded, want its error")
+	}
+	if cid := <-waiter; cid != "retried" {
+		t.Errorf("waiter got %q, want its own add after the failure", cid)
+	}
+}
+
+func TestAddCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newAddCache(2)
+	ctx := context.Background()
+	add := func(hash string) bool {
+		_, shared, _ := c.add(ctx, hash, func() (string, error) { return "cid-" + hash, nil })
+		return shared
+	}
+
+	add("a")
+	add("b")
+	add("a") // a is now the most recently used
+	add("c") // evicts b
+
+	if !add("a") {
+		t.Error("a was evicted")
+	}
+	if add("b") {
+		t.Error("b was kept beyond the cache size")
+	}
+	if len(c.entries) != 2 || c.order.Len() != 2 {
+		t.Errorf("%d entries, %d in order; want 2", len(c.entries), c.order.Len())
+	}
+}
@@ -0,0 +1,1581 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/schollz/progressbar/v3"
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/atregu/ipfs-common/ack"
+	"github.com/atregu/ipfs-common/extensions"
+	"github.com/atregu/ipfs-common/query"
+	"github.com/atregu/ipfs-common/stats"
+	"github.com/atregu/ipfs-common/tracing"
+
+	"github.com/atregu/ipfs-publisher/internal/api"
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/failover"
+	"github.com/atregu/ipfs-publisher/internal/index"
+	"github.com/atregu/ipfs-publisher/internal/ipfs"
+	"github.com/atregu/ipfs-publisher/internal/keys"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/metadata"
+	"github.com/atregu/ipfs-publisher/internal/metrics"
+	"github.com/atregu/ipfs-publisher/internal/pubsub"
+	"github.com/atregu/ipfs-publisher/internal/scanner"
+	"github.com/atregu/ipfs-publisher/internal/state"
+	"github.com/atregu/ipfs-publisher/internal/watcher"
+)
+
+// ipnsKey is the IPNS key the collection is published under
+const ipnsKey = "self"
+
+// watcherDebounce is the delay after the last change to a file before it is processed
+const watcherDebounce = 300 * time.Millisecond
+
+// apiShutdownTimeout bounds how long shutdown waits for active API requests
+const apiShutdownTimeout = 5 * time.Second
+
+// tracingShutdownTimeout bounds how long shutdown waits for the last spans to be exported
+const tracingShutdownTimeout = 5 * time.Second
+
+// spacePauseDuration is how long uploads pause after the repository volume filled up
+const spacePauseDuration = 5 * time.Minute
+
+// Reasons uploads are paused
+const (
+	pauseNoSpace     = "lack of disk space"
+	pauseUnreachable = "an unreachable IPFS node"
+)
+
+// errStopping ends a scan whose uploads were stopped by a shutdown request
+var errStopping = errors.New("shutting down")
+
+// app holds the components of a running publisher
+type app struct {
+	cfg         *config.Config
+	client      ipfs.Client
+	state       *state.Manager
+	index       *index.Manager
+	scanner     *scanner.Scanner
+	announcer   *pubsub.Publisher // nil when PubSub is disabled
+	addOpts     ipfs.AddOptions
+	verifier    *ipfs.Verifier
+	scanned     map[string]*scanner.FileInfo // Files of the last scan by path
+	lastSave    time.Time                    // When the staged changes were last saved
+	pausedUntil time.Time                    // Uploads are paused until then
+	pauseReason string                       // Why uploads are paused
+	deferPins   bool                         // Files are added unpinned and pinned in bulk after each batch
+	claimKey    ed25519.PrivateKey           // Signs per-record claims; nil unless publish.sign_records is set
+	unpinned    []unpinnedFile               // Files added without a pin, waiting for the batch pin
+	uploads     *metrics.UploadMetrics
+	inFlight    inFlight  // Files being uploaded by the scan or the watch pipeline
+	dedupe      *addCache // CIDs of recently added content; nil unless behavior.dedupe_uploads is set
+	providers   *metrics.ProviderMetrics
+	probing     atomic.Bool       // A provider probe is running
+	unchanged   string            // Root CID whose skipped publish was last logged at info level
+	flagged     map[string]string // Last warning of checkUploads by path, logged at debug level while unchanged
+	stopping    chan struct{}     // Closed on the first shutdown signal; uploads stop after the file in progress
+	scans       *scanCoordinator
+	progressBar bool           // behavior.progress_bar is set and stdout is a terminal
+	failover    *failover.Node // Writes snapshots for a standby; nil unless failover.enabled is set
+	manifest    []byte         // Signed manifest.json uploaded with each version; nil uploads none
+}
+
+// unpinnedFile is a file added with the deferred pin strategy; it is staged once pinned
+type unpinnedFile struct {
+	path  string
+	state *state.FileState
+}
+
+// run publishes the collection and keeps it up to date until interrupted
+func run(cfg *config.Config) error {
+	log := logger.Get()
+	log.Infof("Starting ipfs-publisher %s (instance %s, %s mode)", version, cfg.Behavior.InstanceID, cfg.IPFS.Mode)
+
+	lock, err := lockInstance(cfg, ownerPublisher)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	signingKey, err := loadKey(cfg, keys.DefaultName)
+	if err != nil {
+		return err
+	}
+
+	stateManager := state.New(cfg.StatePath())
+	if err := stateManager.Load(); err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+	restoreDirectories(cfg, stateManager)
+
+	indexManager := index.New(cfg.IndexPath())
+	if err := indexManager.Load(); err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+	indexManager.SetMetadata(cfg.Collection.Visibility, cfg.Collection.License)
+	indexManager.SetGenerator(generator)
+
+	// A crash between saving the index and the state after a publish leaves the
+	// staged changes in an index file that is no longer the published base
+	if stateManager.StagedCount() > 0 {
+		indexManager.ForgetPublished()
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Large files resume from the parts recorded in the state file
+	if external, ok := client.(*ipfs.ExternalClient); ok {
+		external.EnableChunkedAdd(&cfg.IPFS.External.ChunkedAdd, stateManager)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Spans cost nothing unless tracing is enabled
+	if cfg.Tracing.Enabled {
+		shutdown, err := tracing.Setup(ctx, "ipfs-publisher")
+		if err != nil {
+			return err
+		}
+		defer func() {
+			stopCtx, stopCancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+			defer stopCancel()
+			if err := shutdown(stopCtx); err != nil {
+				log.Warnf("Failed to export the last traces: %v", err)
+			}
+		}()
+		log.Info("Exporting OpenTelemetry traces over OTLP")
+	}
+
+	if err := client.IsAvailable(ctx); err != nil {
+		return fmt.Errorf("IPFS node is not available: %w", err)
+	}
+
+	// Fail at startup rather than on every add
+	if external, ok := client.(*ipfs.ExternalClient); ok && addOptions(cfg).NoCopy {
+		if err := external.CheckFilestore(ctx); errors.Is(err, ipfs.ErrFilestoreDisabled) {
+			return err
+		} else if err != nil {
+			log.Warnf("Could not check the node's filestore, adds will report it: %v", err)
+		}
+	}
+
+	warnMissingPins(ctx, client, stateManager.GetAllFiles(), cfg.Behavior.PinCheckSample)
+
+	// A standby that took over, or a stale primary, must not publish next to the active one
+	var failoverNode *failover.Node
+	if cfg.Failover.Enabled {
+		id, err := failover.LoadID(cfg.FailoverIDPath())
+		if err != nil {
+			return err
+		}
+		if failoverNode, err = failover.New(client, cfg, signingKey, id); err != nil {
+			return err
+		}
+		if err := failoverNode.CheckStart(ctx, stateManager.GetVersion()); err != nil {
+			return err
+		}
+		log.Infof("Writing failover snapshots as publisher %s every %s", id, cfg.Failover.Interval())
+	}
+
+	a := &app{
+		cfg:       cfg,
+		client:    client,
+		state:     stateManager,
+		index:     indexManager,
+		scanner:   newScanner(cfg),
+		addOpts:   addOptions(cfg),
+		uploads:   metrics.NewUploadMetrics(),
+		providers: metrics.NewProviderMetrics(),
+		failover:  failoverNode,
+	}
+	if cfg.Publish.SignRecords {
+		a.claimKey = signingKey
+	}
+	if a.manifest, err = buildManifest(cfg, signingKey); err != nil {
+		return err
+	}
+	if cfg.Behavior.PinStrategy == config.PinStrategyDeferred && a.addOpts.Pin {
+		a.addOpts.Pin = false
+		a.deferPins = true
+		log.Info("Pinning deferred: files are added unpinned and pinned in bulk after each batch")
+	}
+	a.scans = newScanCoordinator(a.scan)
+	a.progressBar = cfg.Behavior.ProgressBar && isTerminal(os.Stdout)
+	if cfg.Behavior.ProgressBar && !a.progressBar {
+		log.Debug("Progress bar disabled: stdout is not a terminal")
+	}
+	a.verifier = ipfs.NewVerifier(client, &cfg.Behavior, a.addOpts.NoCopy)
+	if a.addOpts.NoCopy && cfg.Behavior.VerifyUploads != config.VerifyUploadsOff {
+		log.Warn("behavior.verify_uploads is ignored with nocopy: the node reads content back from the local files")
+	}
+	if cfg.Behavior.DedupeUploads {
+		if a.addOpts.NoCopy {
+			// A reused CID would reference the blocks of the other file's path
+			log.Warn("behavior.dedupe_uploads is ignored with nocopy: each file is referenced by its own path")
+		} else {
+			a.dedupe = newAddCache(cfg.Behavior.DedupeCacheSize)
+		}
+	}
+
+	var server *api.Server
+	if cfg.API.ListenAddr != "" {
+		server = api.NewServer(cfg.API.ListenAddr)
+		if err := server.Register(stats.NewRepoCollector("ipfspublisher", client.RepoStat)); err != nil {
+			return err
+		}
+		if err := server.Register(a.uploads); err != nil {
+			return err
+		}
+		if err := server.Register(a.providers); err != nil {
+			return err
+		}
+		if err := server.Register(stats.NewRuntimeCollector("ipfspublisher")); err != nil {
+			return err
+		}
+		server.Handle("/api/v1/ipfs/repo", stats.RepoHandler(client.RepoStat))
+		server.Handle("/api/v1/status/runtime", stats.RuntimeHandler())
+		server.Handle("/api/v1/status/scan", a.scans.Handler())
+		if external, ok := client.(*ipfs.ExternalClient); ok && external.Breaker() != nil {
+			circuit := metrics.NewCircuitMetrics()
+			external.Breaker().SetObserver(circuit)
+			if err := server.Register(circuit); err != nil {
+				return err
+			}
+			server.Handle("/api/v1/status/ipfs", external.Breaker().Handler())
+		}
+		if err := server.Start(); err != nil {
+			return err
+		}
+		defer func() {
+			stopCtx, stopCancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+			defer stopCancel()
+			if err := server.Stop(stopCtx); err != nil {
+				log.Warn(err)
+			}
+		}()
+	}
+
+	if cfg.Pubsub.Enabled {
+		log.Infof("Announcing on PubSub topic %q", cfg.Pubsub.Topic)
+		announcer, node, err := newAnnouncer(cfg, client, signingKey)
+		if err != nil {
+			return err
+		}
+		if node != nil {
+			defer node.Stop()
+		}
+
+		announcer.SetCollectionMeta(cfg.Collection.Visibility, cfg.Collection.License)
+
+		// The topic peers present at each announcement estimate who receives them
+		reachMetrics := metrics.NewReachMetrics()
+		reach := pubsub.NewReachTracker(cfg.ReachPath(), cfg.Pubsub.ReachAlertAfter, reachMetrics)
+		if err := reach.Load(); err != nil {
+			log.Warnf("Starting a new reach record: %v", err)
+		}
+		announcer.SetReachTracker(reach)
+		if server != nil {
+			if err := server.Register(reachMetrics); err != nil {
+				return err
+			}
+			server.Handle("/api/v1/pubsub/reach", reach.Handler())
+			server.Handle("/api/v1/pubsub/sent", announcer.SendLog().Handler())
+		}
+
+		if published := stateManager.GetManifest(); published != nil {
+			announcer.SetManifestCID(published.CID)
+		}
+		announcer.Resume(stateManager.GetVersion(), stateManager.GetIPNS(), indexManager.Count(),
+			announcedRoot(&cfg.Publish, stateManager), stateManager.GetLastIndexCID())
+		if err := announcer.Start(); err != nil {
+			return fmt.Errorf("failed to start PubSub publisher: %w", err)
+		}
+		defer announcer.Stop()
+		a.announcer = announcer
+
+		// Indexers that enable acks confirm each stored announcement on the companion
+		// topic, and restarted indexers ask for current announcements on the query topic
+		if subscriber := topicSubscriber(client, node); subscriber != nil {
+			if err := announcer.ListenForAcks(ctx, subscriber, ack.Topic(cfg.Pubsub.Topic), &ackRecorder{state: stateManager}); err != nil {
+				log.Warnf("Indexer acks are not tracked: %v", err)
+			}
+			if err := announcer.ListenForQueries(ctx, subscriber, query.Topic(cfg.Pubsub.Topic)); err != nil {
+				log.Warnf("Indexer queries are not answered: %v", err)
+			}
+		}
+	}
+
+	// A shutdown during the initial scan also waits for the upload in progress
+	sigChan := make(chan os.Signal, 2)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	a.stopping = make(chan struct{})
+	go a.watchSignals(sigChan, cancel)
+
+	// Staged uploads and removals reach the state file while a scan runs, not only
+	// between upload batches
+	go a.state.AutoSave(ctx, time.Duration(cfg.Behavior.StateSaveInterval)*time.Second, cfg.Behavior.StateSaveFiles)
+
+	var fenced <-chan struct{}
+	if a.failover != nil {
+		fenced = a.failover.Fenced()
+		go a.failover.Run(ctx, cfg.StatePath(), cfg.IndexPath())
+	}
+
+	// The watcher starts first so files copied in during the scan are not missed
+	w, err := watcher.NewWatcher(&watcher.Config{
+		Directories:   cfg.Directories,
+		Extensions:    cfg.Extensions,
+		DebounceDelay: watcherDebounce,
+		PollAll:       cfg.Behavior.WatchMode == config.WatchModePoll,
+		PollInterval:  time.Duration(cfg.Behavior.ScanInterval) * time.Second,
+	})
+	if err != nil {
+		return err
+	}
+	if err := w.Start(cfg.Directories); err != nil {
+		return fmt.Errorf("failed to start watcher: %w", err)
+	}
+	defer w.Stop()
+
+	// A scan stopped by a shutdown ends at the top of the main loop
+	err = a.scans.Run(ctx, triggerStartup, func(ctx context.Context) (*scanSummary, error) {
+		return a.initialScan(ctx, w.Events())
+	})
+	if err != nil && !a.stopRequested() {
+		return err
+	}
+	a.probeProviders(ctx)
+
+	if server != nil {
+		if err := server.Register(w.EventCounter()); err != nil {
+			return err
+		}
+		if err := server.Register(w.WatchMetrics()); err != nil {
+			return err
+		}
+		server.Handle("/api/v1/watcher/hotspots", w.HotspotsHandler())
+		server.Handle("/api/v1/watcher/status", w.StatusHandler())
+	}
+
+	// Directories added or removed at runtime, through the publisher's directory
+	// callbacks, are scanned and published right away
+	dirChanges := newDirectoryChanges(w)
+	var control dirControl = dirChanges
+	if a.announcer != nil {
+		a.announcer.SetDirectoryCallbacks(pubsub.DirectoryCallbacks{Add: dirChanges.AddDirectory, Remove: dirChanges.RemoveDirectory})
+		control = a.announcer
+	}
+	if cfg.API.Control.Enabled {
+		controlServer := api.NewControlServer(cfg.API.Control.ListenAddr, cfg.API.Control.Token)
+		controlServer.Handle("/api/v1/watcher/directories", dirChanges.Handler(control))
+		if err := controlServer.Start(); err != nil {
+			return err
+		}
+		defer func() {
+			stopCtx, stopCancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+			defer stopCancel()
+			if err := controlServer.Stop(stopCtx); err != nil {
+				log.Warn(err)
+			}
+		}()
+	}
+
+	// An embedded node that regains peers after an outage, e.g. a laptop waking
+	// up, republishes and announces right away instead of at the next timer
+	var restored chan time.Duration
+	if counter, ok := client.(peerCounter); ok {
+		connectivity := metrics.NewConnectivityMetrics()
+		if server != nil {
+			if err := server.Register(connectivity); err != nil {
+				return err
+			}
+		}
+		restored = make(chan time.Duration, 1)
+		go watchConnectivity(ctx, counter, newConnectivityMonitor(connectivityPollInterval), connectivity, restored)
+	}
+
+	// Re-sign the IPNS record before it expires, even if nothing changed
+	republish := time.NewTicker(cfg.Publish.RepublishInterval())
+	defer republish.Stop()
+
+	// Rescan everything periodically in case the watcher missed a change
+	rescans := newRescanScheduler(time.Duration(cfg.Behavior.ScanInterval) * time.Second)
+	go rescans.run(ctx)
+
+	log.Info("✓ Watching for changes (press Ctrl+C to stop)")
+
+	for {
+		if a.stopRequested() {
+			if err := a.state.Save(); err != nil {
+				return fmt.Errorf("failed to save state: %w", err)
+			}
+			return nil
+		}
+
+		// Retry paused uploads once the pause is over
+		var resume <-chan time.Time
+		if !a.pausedUntil.IsZero() {
+			resume = time.After(time.Until(a.pausedUntil))
+		}
+
+		select {
+		case event := <-w.Events():
+			err := a.scans.Run(ctx, triggerWatcher, func(ctx context.Context) (*scanSummary, error) {
+				return a.handleEvents(ctx, event, w.Events())
+			})
+			if err := a.scanFailed(ctx, err); err != nil {
+				return err
+			}
+
+		case <-dirChanges.Ready():
+			a.applyDirChanges(dirChanges.take())
+			if err := a.scanFailed(ctx, a.scans.Run(ctx, triggerDirs, a.scan)); err != nil {
+				return err
+			}
+
+		case <-resume:
+			log.Info("Retrying paused uploads")
+			if err := a.scanFailed(ctx, a.scans.Run(ctx, triggerResume, a.scan)); err != nil {
+				return err
+			}
+
+		case <-rescans.Due():
+			err := a.scans.Run(ctx, triggerRescan, a.scan)
+			rescans.done()
+			if err := a.scanFailed(ctx, err); err != nil {
+				return err
+			}
+
+		case <-republish.C:
+			log.Debug("Re-signing IPNS record")
+			if err := a.publish(ctx, false); err != nil {
+				if ipfs.IsFatal(err) {
+					return err
+				}
+				log.Errorf("Failed to republish IPNS record: %v", err)
+			}
+			a.probeProviders(ctx)
+
+		case outage := <-restored:
+			republished, err := a.reconnected(ctx, outage)
+			if err != nil {
+				if ipfs.IsFatal(err) {
+					return err
+				}
+				log.Errorf("Failed to republish IPNS record after reconnecting: %v", err)
+			}
+			if republished {
+				republish.Reset(cfg.Publish.RepublishInterval())
+			}
+			a.probeProviders(ctx)
+
+		case <-fenced:
+			if err := a.state.Save(); err != nil {
+				log.Errorf("Failed to save state: %v", err)
+			}
+			return fmt.Errorf("stopped publishing: %w", failover.ErrFenced)
+
+		case <-a.stopping:
+			// The state is saved at the top of the loop
+		}
+	}
+}
+
+// watchSignals handles SIGINT and SIGTERM. The first closes a.stopping, so a
+// running scan stops after the file being uploaded and the publisher then exits;
+// the second calls abort to cancel that upload too.
+func (a *app) watchSignals(sigChan <-chan os.Signal, abort func()) {
+	log := logger.Get()
+
+	sig := <-sigChan
+	log.Infof("Received %v, shutting down after the upload in progress (repeat to abort it)...", sig)
+	close(a.stopping)
+
+	sig = <-sigChan
+	log.Warnf("Received %v again, aborting the upload in progress", sig)
+	abort()
+}
+
+// stopRequested reports whether a shutdown signal was received
+func (a *app) stopRequested() bool {
+	select {
+	case <-a.stopping:
+		return true
+	default:
+		return false
+	}
+}
+
+// scanFailed returns err if it ends the publisher and logs it otherwise.
+// Scans stopped or aborted by a shutdown are not errors.
+func (a *app) scanFailed(ctx context.Context, err error) error {
+	switch {
+	case err == nil, errors.Is(err, errStopping), a.stopRequested() && ctx.Err() != nil:
+		return nil
+	case ipfs.IsFatal(err):
+		return err
+	case errors.Is(err, ipfs.ErrCircuitOpen):
+		// The changes are published by the scan retrying the uploads
+		a.pauseUploads(err)
+		return nil
+	}
+	logger.Get().Errorf("Failed to process changes: %v", err)
+	return nil
+}
+
+// ackSaveInterval is the minimum time between state saves triggered by indexer acks
+const ackSaveInterval = time.Minute
+
+// ackRecorder records indexer acks in the state and saves it at most once per
+// ackSaveInterval, so --status shows them without waiting for the next publish
+type ackRecorder struct {
+	state    *state.Manager
+	lastSave time.Time
+}
+
+// RecordAck records the ack and saves the state if a new indexer acknowledged
+func (r *ackRecorder) RecordAck(version int, indexerKey string) (int, bool) {
+	count, added := r.state.RecordAck(version, indexerKey)
+	if added && time.Since(r.lastSave) >= ackSaveInterval {
+		if err := r.state.Save(); err != nil {
+			logger.Get().Warnf("Failed to save state: %v", err)
+		}
+		r.lastSave = time.Now()
+	}
+	return count, added
+}
+
+// handleEvents processes event together with any events already queued behind it,
+// so a batch of changes results in a single scan and publish
+func (a *app) handleEvents(ctx context.Context, event watcher.FileEvent, events <-chan watcher.FileEvent) (*scanSummary, error) {
+	batch := []watcher.FileEvent{event}
+	for drained := false; !drained; {
+		select {
+		case next := <-events:
+			batch = append(batch, next)
+		default:
+			drained = true
+		}
+	}
+
+	return a.processEvents(ctx, batch)
+}
+
+// processEvents stages the removal of deleted files in the order of events, then
+// scans for new and changed files
+func (a *app) processEvents(ctx context.Context, events []watcher.FileEvent) (*scanSummary, error) {
+	removed := 0
+	for _, e := range events {
+		if e.EventType == watcher.EventDelete || e.EventType == watcher.EventRename {
+			if a.removeFile(e.Path) {
+				removed++
+			}
+		}
+	}
+
+	summary, err := a.scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	summary.removed += removed
+	return summary, nil
+}
+
+// initialScan runs the startup scan while the watcher is already running. Events
+// arriving meanwhile are collected instead of starting uploads of their own; those
+// for changes the scan picked up are dropped, and the rest go through the watch
+// pipeline in path order once the scan is done.
+func (a *app) initialScan(ctx context.Context, events <-chan watcher.FileEvent) (*scanSummary, error) {
+	backlog := collectEvents(events)
+	summary, err := a.scan(ctx)
+	collected := backlog.Stop()
+	if err != nil {
+		return nil, err
+	}
+
+	remaining := a.unhandledEvents(collected)
+	if len(collected) > 0 {
+		logger.Get().Infof("%d files changed during the initial scan, %d not covered by it", len(collected), len(remaining))
+	}
+	if len(remaining) == 0 {
+		return summary, nil
+	}
+	later, err := a.processEvents(ctx, remaining)
+	if err != nil {
+		return nil, err
+	}
+	summary.add(later)
+	return summary, nil
+}
+
+// removeFile stages the removal of a deleted file from the index and state. It
+// reports whether the file was recorded.
+func (a *app) removeFile(path string) bool {
+	_, published := a.state.GetFile(path)
+	staged, ok := a.state.GetStagedFile(path)
+	if !published && (!ok || staged == nil) {
+		return false
+	}
+
+	a.state.StageDelete(path)
+	logger.Get().Infof("Staged removal of deleted file: %s", path)
+	return true
+}
+
+// newScanner creates the scanner of the configured directories
+func newScanner(cfg *config.Config) *scanner.Scanner {
+	s := scanner.New(cfg.Directories, cfg.Extensions)
+	s.ExcludePatterns = cfg.Behavior.ExcludePatterns
+	return s
+}
+
+// scanDirectories scans the configured directories, in parallel with
+// behavior.scan_workers above 1
+func scanDirectories(cfg *config.Config, s *scanner.Scanner) ([]scanner.FileInfo, error) {
+	if cfg.Behavior.ScanWorkers > 1 {
+		return s.ScanParallel(cfg.Behavior.ScanWorkers)
+	}
+	return s.Scan()
+}
+
+// runScan uploads new and changed files, then publishes the index
+func (a *app) runScan(ctx context.Context) error {
+	_, err := a.scan(ctx)
+	if err == nil {
+		a.checkAnnouncedSize()
+	}
+	return err
+}
+
+// checkAnnouncedSize warns when the collection size repeated by the announcer
+// differs from the records in the index: indexers compare it with the items
+// they parse and mark the collection incomplete
+func (a *app) checkAnnouncedSize() {
+	if a.announcer == nil || a.announcer.GetCurrentVersion() == 0 {
+		return
+	}
+	if announced, count := a.announcer.GetCollectionSize(), a.index.Count(); announced != count {
+		logger.Get().Warnf("Announcement of version %d declares %d files, but the index holds %d records; indexers will report the collection as incomplete",
+			a.announcer.GetCurrentVersion(), announced, count)
+	}
+}
+
+// scan is runScan returning what the scan did. After a shutdown request it
+// returns errStopping once the upload in progress is done, without publishing.
+func (a *app) scan(ctx context.Context) (*scanSummary, error) {
+	log := logger.Get()
+
+	files, err := scanDirectories(a.cfg, a.scanner)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan directories: %w", err)
+	}
+
+	a.scanned = make(map[string]*scanner.FileInfo, len(files))
+	a.unpinned = nil // Left over by an interrupted scan; those files are still pending
+	var pending []scanner.FileInfo
+	for i := range files {
+		a.scanned[files[i].Path] = &files[i]
+		if a.needsUpload(&files[i]) {
+			pending = append(pending, files[i])
+		}
+	}
+	// Failures of files that are gone no longer matter
+	a.state.RetainUploadFailures(func(path string) bool {
+		_, found := a.scanned[path]
+		return found
+	})
+	// Renamed and moved files keep their CID
+	pending, renamed := a.stageRenames(pending)
+	// Files too large or not what their extension claims are never opened for adding
+	pending, tooLarge, mismatched := a.checkUploads(pending)
+
+	// Periodic rescans mostly find nothing new
+	logScan := log.Infof
+	if len(pending) == 0 {
+		logScan = log.Debugf
+	}
+	logScan("Scan found %d files, %d new or changed", len(files), len(pending))
+	summary := &scanSummary{scanned: len(files), renamed: renamed, tooLarge: tooLarge, mismatched: mismatched}
+	summary.removed = a.stageMissing()
+
+	if time.Now().Before(a.pausedUntil) {
+		if len(pending) > 0 {
+			log.Warnf("Uploads paused for %s until %s; %d files waiting", a.pauseReason, a.pausedUntil.Format(time.TimeOnly), len(pending))
+		}
+		pending = nil
+	} else if len(pending) == 0 {
+		a.resumeUploads()
+	}
+
+	var bar *progressbar.ProgressBar
+	if a.progressBar && len(pending) > 0 {
+		bar = progressbar.DefaultBytes(int64(batchBytes(pending)), "Uploading")
+		// A scan ending early leaves the bar where it stopped, on its own line
+		defer func() {
+			if !bar.IsFinished() {
+				_ = bar.Exit()
+			}
+		}()
+	}
+	started := 0
+
+	uploaded, failed := 0, 0
+	verifiedBefore, verifyFailedBefore := a.verifier.Counts()
+	batchSize := a.cfg.Behavior.BatchSize
+	publishBatchSize := a.cfg.Behavior.PublishBatchSize
+	for start := 0; start < len(pending); start += batchSize {
+		batch := pending[start:min(start+batchSize, len(pending))]
+
+		if err := a.client.PreflightAdd(ctx, batchBytes(batch)); err != nil {
+			if !errors.Is(err, ipfs.ErrNoSpace) && !errors.Is(err, ipfs.ErrCircuitOpen) {
+				return nil, err
+			}
+			a.pauseUploads(err)
+			break
+		}
+		a.resumeUploads()
+
+		for i := range batch {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// The files added so far are still pinned and staged below
+			if a.stopRequested() {
+				break
+			}
+
+			started++
+			progress, done := fileProgress(bar, batch[i].Size)
+			if bar != nil {
+				bar.Describe(fmt.Sprintf("Uploading %d/%d", started, len(pending)))
+			}
+			attempts, err := a.uploadWithRetries(ctx, &batch[i], progress)
+			done()
+			if err == nil {
+				uploaded++
+				if publishBatchSize > 0 && a.state.StagedCount() >= publishBatchSize {
+					if err := a.publish(ctx, true); err != nil {
+						return nil, err
+					}
+				}
+				continue
+			}
+
+			// The upload in progress records the file
+			if errors.Is(err, errUploadInFlight) {
+				log.Debugf("%v", err)
+				continue
+			}
+
+			// Nothing was recorded, so the next scan finds the file new or changed again
+			if errors.Is(err, scanner.ErrFileChanged) {
+				log.Warnf("%v; it will be uploaded again on the next scan", err)
+				continue
+			}
+
+			// The daemon ran out of space despite the preflight (e.g. external mode),
+			// or it cannot be reached and the rest of the batch would fail the same way
+			if errors.Is(err, ipfs.ErrNoSpace) || errors.Is(err, ipfs.ErrCircuitOpen) {
+				a.pauseUploads(err)
+				break
+			}
+			if ipfs.IsFatal(err) {
+				return nil, err
+			}
+			failed++
+			a.state.RecordUploadFailure(batch[i].Path, err, attempts)
+			log.Errorf("Failed to upload %s after %d attempts: %v", batch[i].Path, attempts, err)
+		}
+
+		// Files added unpinned are staged only once the batch is pinned; those
+		// whose pin failed count as failed rather than uploaded
+		pinFailed := a.pinDeferred(ctx)
+		uploaded, failed = uploaded-pinFailed, failed+pinFailed
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		// Staged uploads are saved by the shutdown and published after the restart
+		if a.stopRequested() {
+			return nil, errStopping
+		}
+		if a.deferPins && publishBatchSize > 0 && a.state.StagedCount() >= publishBatchSize {
+			if err := a.publish(ctx, true); err != nil {
+				return nil, err
+			}
+		}
+
+		if !a.pausedUntil.IsZero() {
+			break
+		}
+
+		// Staged uploads survive a crash, so they are not added again
+		if err := a.saveStaged(); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(pending) > 0 {
+		log.Infof("Scan complete: %d uploaded, %d failed", uploaded, failed)
+		if a.verifier.Enabled() {
+			verified, verifyFailed := a.verifier.Counts()
+			log.Infof("Upload verification: %d verified, %d failed", verified-verifiedBefore, verifyFailed-verifyFailedBefore)
+		}
+	}
+
+	summary.uploaded, summary.failed = uploaded, failed
+	summary.skipped = summary.scanned - uploaded - failed - renamed
+
+	// The publish would fail fast as well; the scan retrying the uploads once
+	// the node is probed again publishes the staged changes
+	if a.pauseReason == pauseUnreachable && time.Now().Before(a.pausedUntil) {
+		if err := a.saveStaged(); err != nil {
+			return nil, err
+		}
+		return summary, nil
+	}
+
+	// The rest of the change set, including deletions, is published together
+	if err := a.publish(ctx, true); err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// needsUpload reports whether a scanned file is new or its size or mtime differ
+// from its recorded state. uploadFile then compares the content hash, so a file
+// rewritten with the same content is not added again.
+func (a *app) needsUpload(file *scanner.FileInfo) bool {
+	fs, _ := a.recordedFile(file.Path)
+	return fs == nil || fs.ModTime != file.ModTime || fs.Size != file.Size
+}
+
+// recordedFile returns the staged state of a file or, if it has no staged
+// change, its published state. It returns nil for unknown files and staged
+// deletions; staged reports where the state came from.
+func (a *app) recordedFile(path string) (fs *state.FileState, staged bool) {
+	if fs, ok := a.state.GetStagedFile(path); ok {
+		return fs, true
+	}
+	fs, _ = a.state.GetFile(path)
+	return fs, false
+}
+
+// saveStaged saves the state if behavior.state_save_interval has passed since the last save
+func (a *app) saveStaged() error {
+	if !a.state.Dirty() || time.Since(a.lastSave) < time.Duration(a.cfg.Behavior.StateSaveInterval)*time.Second {
+		return nil
+	}
+	if err := a.state.Save(); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	a.lastSave = time.Now()
+	return nil
+}
+
+// batchBytes returns the total size of the files in batch
+func batchBytes(batch []scanner.FileInfo) uint64 {
+	var total uint64
+	for _, file := range batch {
+		total += uint64(file.Size)
+	}
+	return total
+}
+
+// fileProgress returns the progress callback of one file's upload, which
+// advances bar by the bytes the add read, and done, which advances it to the
+// end of the file once the upload is over: unchanged, reused or failed files
+// are not read in full. Both are no-ops without a bar.
+func fileProgress(bar *progressbar.ProgressBar, size int64) (progress func(int64), done func()) {
+	if bar == nil {
+		return nil, func() {}
+	}
+
+	// External adds read the file on the HTTP client's goroutine
+	var reported atomic.Int64
+	progress = func(n int64) {
+		bar.Add64(n - reported.Swap(n))
+	}
+	done = func() {
+		if rest := size - reported.Swap(size); rest > 0 {
+			bar.Add64(rest)
+		}
+	}
+	return progress, done
+}
+
+// isTerminal reports whether f is a terminal; the progress bar's redraws only
+// garble output piped to a file or collected by a service manager
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// pauseUploads stops uploads for spacePauseDuration after the repository volume
+// filled up, or while the circuit breaker of the external client is open, until
+// it probes the node again. Files that were not uploaded stay pending and are
+// retried when the pause is over.
+func (a *app) pauseUploads(err error) {
+	var open *ipfs.CircuitOpenError
+	if errors.As(err, &open) {
+		// The breaker logged the failures already
+		if !a.pausedUntil.Equal(open.RetryAt) {
+			a.pausedUntil, a.pauseReason = open.RetryAt, pauseUnreachable
+			logger.Get().Warnf("Uploads paused until %s while the IPFS node is unreachable", a.pausedUntil.Format(time.TimeOnly))
+		}
+		return
+	}
+
+	a.pausedUntil, a.pauseReason = time.Now().Add(spacePauseDuration), pauseNoSpace
+	a.uploads.Paused(true)
+	logger.Get().Errorf("Uploads paused until %s: %v", a.pausedUntil.Format(time.TimeOnly), err)
+}
+
+// resumeUploads ends a pause once it is over and the disk space preflight passes
+func (a *app) resumeUploads() {
+	if a.pausedUntil.IsZero() {
+		return
+	}
+	a.pausedUntil = time.Time{}
+	a.uploads.Paused(false)
+	logger.Get().Info("✓ Uploads resumed")
+}
+
+// uploadFile adds a file to IPFS and stages it for the next published version.
+// progress, if set, is called with the bytes of the file read by the add so far.
+func (a *app) uploadFile(ctx context.Context, file *scanner.FileInfo, progress func(int64)) error {
+	log := logger.Get()
+
+	if !a.inFlight.start(file.Path) {
+		return fmt.Errorf("%w: %s", errUploadInFlight, file.Path)
+	}
+	defer a.inFlight.done(file.Path)
+
+	hash, err := state.HashFile(file.Path)
+	if err != nil {
+		return err
+	}
+	if a.refreshUnchanged(file, hash) {
+		return nil
+	}
+
+	cid, err := a.addFile(ctx, file, hash, progress)
+	if err != nil {
+		return err
+	}
+
+	fs := &state.FileState{
+		CID:     cid,
+		ModTime: file.ModTime,
+		Size:    file.Size,
+		SHA256:  hash,
+	}
+	if file.Info != nil {
+		fs.ModTimeNs = file.Info.ModTime().UnixNano()
+	}
+	if a.deferPins {
+		a.unpinned = append(a.unpinned, unpinnedFile{path: file.Path, state: fs})
+		log.Infof("✓ Added %s: %s (pin deferred)", file.Name, cid)
+		return nil
+	}
+	a.state.StageFile(file.Path, fs)
+
+	log.Infof("✓ Uploaded %s: %s", file.Name, cid)
+	return nil
+}
+
+// maxUploadRetryBackoff caps the wait between retries of an upload
+const maxUploadRetryBackoff = 5 * time.Minute
+
+// uploadWithRetries uploads a file like uploadFile, retrying transient failures
+// up to behavior.upload_retries times. The wait before the first retry is
+// behavior.upload_retry_backoff and doubles for each further one. A missing or
+// unreadable file or a fatal node error fails right away. It returns the
+// number of attempts made; a successful upload clears the file's failure record.
+func (a *app) uploadWithRetries(ctx context.Context, file *scanner.FileInfo, progress func(int64)) (int, error) {
+	backoff := time.Duration(a.cfg.Behavior.UploadRetryBackoff) * time.Second
+	for attempt := 1; ; attempt++ {
+		err := a.uploadFile(ctx, file, progress)
+		if err == nil {
+			a.state.ClearUploadFailure(file.Path)
+			return attempt, nil
+		}
+		if attempt > a.cfg.Behavior.UploadRetries || !uploadRetryable(err) || ctx.Err() != nil {
+			return attempt, err
+		}
+
+		logger.Get().Warnf("Upload of %s failed (attempt %d of %d), retrying in %s: %v",
+			file.Name, attempt, a.cfg.Behavior.UploadRetries+1, backoff, err)
+		select {
+		case <-ctx.Done():
+			return attempt, ctx.Err()
+		case <-a.stopping:
+			return attempt, err
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxUploadRetryBackoff)
+	}
+}
+
+// uploadRetryable reports whether a failed upload may succeed when tried again:
+// the node could not be reached or timed out. A file that vanished or cannot be
+// read fails the same way every time.
+func uploadRetryable(err error) bool {
+	if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrPermission) || errors.Is(err, scanner.ErrFileChanged) {
+		return false
+	}
+	return ipfs.IsTransient(err)
+}
+
+// refreshUnchanged handles a file whose size or mtime changed but whose content
+// still has the recorded hash, e.g. after an editor saved it unmodified. Its
+// recorded size and mtime are updated without adding it again or publishing a
+// new version, and refreshUnchanged returns true. With behavior.extract_metadata,
+// metadata that no longer matches the record, e.g. of a record written before
+// metadata was read, is staged with the recorded CID instead, so the next
+// version updates the record in place. States recorded without a hash never
+// match, so such files are added once more and get one.
+func (a *app) refreshUnchanged(file *scanner.FileInfo, hash string) bool {
+	recorded, staged := a.recordedFile(file.Path)
+	if recorded == nil || recorded.SHA256 == "" || recorded.SHA256 != hash {
+		return false
+	}
+
+	// The hash is only valid for the scanned size and mtime
+	if err := file.Verify(); err != nil {
+		return false
+	}
+
+	fs := *recorded
+	fs.ModTime = file.ModTime
+	fs.Size = file.Size
+	if file.Info != nil {
+		fs.ModTimeNs = file.Info.ModTime().UnixNano()
+	}
+	if a.metadataChanged(file, recorded) {
+		a.state.StageFile(file.Path, &fs)
+		logger.Get().Infof("Content of %s is unchanged but its metadata changed, updating its record without uploading it again", file.Name)
+		return true
+	}
+	if staged {
+		a.state.StageFile(file.Path, &fs)
+	} else {
+		a.state.SetFile(file.Path, &fs)
+	}
+
+	logger.Get().Infof("Content of %s is unchanged, not uploading it again", file.Name)
+	return true
+}
+
+// metadataChanged reports whether the metadata read from file differs from the
+// metadata in its index record, whose hash the recorded state keeps
+func (a *app) metadataChanged(file *scanner.FileInfo, recorded *state.FileState) bool {
+	if !a.cfg.Behavior.ExtractMetadata || !metadata.Supported(file.Extension) {
+		return false
+	}
+	return extractMetadata(file).Hash() != recorded.MetadataHash
+}
+
+// addFile adds a file's content, whose SHA-256 is hash, and returns its CID.
+// With behavior.dedupe_uploads, content already recorded for another file,
+// added recently or being added by another upload is not added again.
+func (a *app) addFile(ctx context.Context, file *scanner.FileInfo, hash string, progress func(int64)) (string, error) {
+	if a.dedupe == nil {
+		return a.addContent(ctx, file, progress)
+	}
+
+	// Recorded content is pinned already and stays pinned while a file has it
+	if cid, ok := a.state.CIDByHash(hash); ok {
+		if err := file.Verify(); err != nil {
+			return "", err
+		}
+		logger.Get().Debugf("Reusing %s of a recorded file for identical content of %s", cid, file.Name)
+		return cid, nil
+	}
+
+	cid, shared, err := a.dedupe.add(ctx, hash, func() (string, error) {
+		return a.addContent(ctx, file, progress)
+	})
+	if err != nil || !shared {
+		return cid, err
+	}
+
+	// The reused CID is only valid for the content that was hashed
+	if err := file.Verify(); err != nil {
+		return "", err
+	}
+	logger.Get().Debugf("Reusing %s for identical content of %s", cid, file.Name)
+	return cid, nil
+}
+
+// addContent adds a file to the node, then checks that it did not change while
+// it was read and, if enabled, reads it back
+func (a *app) addContent(ctx context.Context, file *scanner.FileInfo, progress func(int64)) (string, error) {
+	f, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	opts := a.addOpts
+	opts.FileInfo = file.Info
+	opts.ProgressFn = progress
+
+	// The filestore references the file by its full path
+	name := file.Name
+	if opts.NoCopy {
+		name = file.Path
+	}
+
+	addCtx, span := tracing.Start(ctx, "add", attribute.String("file.path", file.Path), attribute.Int64("file.size", file.Size))
+	result, err := a.client.Add(addCtx, f, name, opts)
+	if err == nil {
+		span.SetAttributes(attribute.String("ipfs.cid", result.CID))
+	}
+	tracing.End(span, err)
+	if err != nil {
+		return "", fmt.Errorf("failed to add %s: %w", file.Name, err)
+	}
+
+	// Record nothing if the file changed while it was uploaded: its CID would be
+	// stored under the stale size and mtime of the scan
+	if err := file.Verify(); err != nil {
+		return "", err
+	}
+
+	// Read the content back from the node; a mismatch leaves the file pending
+	if err := a.verifier.Verify(ctx, result.CID, file.Path, file.Size); err != nil {
+		return "", err
+	}
+
+	return result.CID, nil
+}
+
+// pinDeferred pins the files added since the last batch pin and stages them.
+// If the bulk pin fails, each file is pinned on its own; files that still fail
+// are not recorded, so the next scan adds them again. It returns the number of
+// files that could not be pinned.
+func (a *app) pinDeferred(ctx context.Context) int {
+	if len(a.unpinned) == 0 {
+		return 0
+	}
+	log := logger.Get()
+
+	files := a.unpinned
+	a.unpinned = nil
+
+	cids := make([]string, len(files))
+	for i, file := range files {
+		cids[i] = file.state.CID
+	}
+
+	err := a.client.PinMany(ctx, cids)
+	if err == nil {
+		for _, file := range files {
+			a.state.StageFile(file.path, file.state)
+		}
+		log.Infof("✓ Pinned %d files", len(files))
+		return 0
+	}
+	log.Warnf("Bulk pin of %d files failed, pinning them one by one: %v", len(files), err)
+
+	failed := 0
+	for _, file := range files {
+		if err := a.client.Pin(ctx, file.state.CID); err != nil {
+			log.Errorf("Failed to pin %s: %v; it will be uploaded again on the next scan", file.path, err)
+			if a.dedupe != nil {
+				a.dedupe.forget(file.state.CID)
+			}
+			failed++
+			continue
+		}
+		a.state.StageFile(file.path, file.state)
+	}
+	log.Infof("✓ Pinned %d of %d files", len(files)-failed, len(files))
+	return failed
+}
+
+// applyStaged applies the staged change set to the index and returns the changes
+// to commit to the state, with the index IDs of the uploaded files filled in.
+// Applying the same change set again is harmless, so a failed publish can retry it.
+func (a *app) applyStaged() (map[string]*state.FileState, error) {
+	log := logger.Get()
+
+	changes := a.state.GetStaged()
+
+	// Removals go first, so a file moved away frees its path in the index
+	// before another file takes it
+	paths := make([]string, 0, len(changes))
+	for path := range changes {
+		paths = append(paths, path)
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if removed := changes[paths[i]] == nil; removed != (changes[paths[j]] == nil) {
+			return removed
+		}
+		return paths[i] < paths[j]
+	})
+
+	// The records of removed files are found by the index IDs committed for them
+	var recordPaths map[int]string
+
+	for _, path := range paths {
+		staged := changes[path]
+		name := filepath.Base(path)
+
+		// Imported files have no local file and are never scanned
+		file, scanned := a.scanned[path]
+		imported := staged != nil && staged.Imported
+		if staged == nil || (!scanned && !imported) {
+			// Files that vanished after they were staged are removed as well
+			if old, ok := a.state.GetFile(path); ok {
+				if recordPaths == nil {
+					recordPaths = a.index.Paths()
+				}
+				if recordPath, ok := recordPaths[old.IndexID]; ok {
+					if err := a.index.Delete(recordPath); err != nil {
+						return nil, err
+					}
+					delete(recordPaths, old.IndexID)
+				}
+			}
+			changes[path] = nil
+			continue
+		}
+
+		group := file.Group
+		if imported {
+			group = staged.Group
+		}
+		recordPath := index.RecordPath(group, name)
+
+		// Imported files have no modification time and directories no MIME type
+		props := index.File{Size: staged.Size, ModTime: staged.ModTime}
+		if scanned {
+			props.MimeType = file.MimeType()
+		} else if !staged.Directory {
+			props.MimeType = extensions.MimeType(extensions.Of(name))
+		}
+
+		record, exists := a.index.Get(recordPath)
+		if exists {
+			var err error
+			if record, err = a.index.Update(recordPath, staged.CID, props); err != nil {
+				return nil, err
+			}
+		} else if imported {
+			ext := extensions.Of(name)
+			if staged.Directory {
+				ext = index.DirectoryExtension
+			}
+			record = a.index.AddInGroup(name, staged.CID, ext, group, props)
+		} else {
+			record = a.index.AddInGroup(name, staged.CID, file.Extension, group, props)
+		}
+
+		if scanned && a.cfg.Behavior.ExtractMetadata {
+			record.Metadata = extractMetadata(file)
+		}
+
+		fs := *staged
+		fs.IndexID = record.ID
+		fs.MetadataHash = record.Metadata.Hash()
+		changes[path] = &fs
+	}
+
+	if len(changes) > 0 {
+		log.Infof("Committing %d staged changes", len(changes))
+	}
+	return changes, nil
+}
+
+// extractMetadata reads the metadata of a file for its index record. A file
+// whose headers do not parse gets a record without metadata.
+func extractMetadata(file *scanner.FileInfo) metadata.Metadata {
+	md, err := metadata.Extract(file.Path)
+	if err != nil {
+		logger.Get().Warnf("Publishing %s without metadata: %v", file.Name, err)
+	}
+	return md
+}
+
+// publish commits the staged change set (if commit is set and there is one, or
+// nothing was published yet) as a new index version, publishes the collection
+// root to IPNS and announces it. The state only records the new version and the
+// committed files once IPNS points at it, and is saved right after. An unchanged
+// index is not published again while its IPNS record is fresh.
+func (a *app) publish(ctx context.Context, commit bool) error {
+	log := logger.Get()
+
+	if a.fenced() {
+		return &ipfs.FatalError{Op: "publish", Err: failover.ErrFenced}
+	}
+
+	var changes map[string]*state.FileState
+	if commit && a.state.StagedCount() > 0 {
+		var err error
+		if changes, err = a.applyStaged(); err != nil {
+			return fmt.Errorf("failed to apply staged changes: %w", err)
+		}
+	}
+
+	if commit {
+		a.updateClaims(changes)
+	}
+
+	// Claims added or stripped, manifest changes and a switch to or from a
+	// collection tree since the last publish also need a new version
+	newVersion := len(changes) > 0 || a.index.Modified() || a.manifestChanged() || a.layoutChanged() || a.state.GetLastRootCID() == ""
+	rootCID := a.state.GetLastRootCID()
+
+	// An unchanged index is not published again while its record is fresh; the
+	// republish timer (commit false) re-signs it before it expires
+	if commit && !newVersion {
+		if record, remaining := a.freshRecord(rootCID, time.Now()); record != nil {
+			a.logUnchanged(record, remaining)
+			if a.announcer != nil {
+				a.announcer.SetMirrors(record.Mirrors)
+			}
+			return nil
+		}
+	}
+
+	var uploaded *indexVersion
+	if newVersion {
+		var err error
+		if uploaded, err = a.publishIndex(ctx); err != nil {
+			return err
+		}
+		rootCID = uploaded.rootCID
+	}
+
+	publishCtx, span := tracing.Start(ctx, "ipns-publish", attribute.String("ipfs.cid", rootCID))
+	result, err := ipfs.PublishWithMirrors(publishCtx, a.client, rootCID, &a.cfg.Publish, ipnsKey)
+	tracing.End(span, err)
+	if err != nil {
+		return fmt.Errorf("failed to publish to IPNS: %w", err)
+	}
+	ipns := result.Primary.Name
+	a.state.SetIPNS(ipns)
+
+	deltaCID := ""
+	var removed []string
+	if newVersion {
+		if err := a.index.Save(); err != nil {
+			return fmt.Errorf("failed to save index: %w", err)
+		}
+		removed = a.removedCIDs(changes)
+		a.state.CommitStaged(changes, uploaded.version, uploaded.indexCID, uploaded.rootCID, uploaded.indexRootCID)
+		a.recordManifest(uploaded.manifestCID)
+		a.index.MarkPublished()
+		deltaCID = uploaded.deltaCID
+		if !a.cfg.Publish.Tree() && a.state.GetTree() != nil {
+			a.removeTree(ctx)
+		}
+	}
+	a.recordPublished(rootCID, result)
+
+	if err := a.state.Save(); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	a.lastSave = time.Now()
+
+	log.Infof("✓ Published version %d: /ipns/%s -> %s", a.state.GetVersion(), ipns, rootCID)
+	a.unpinRemoved(ctx, removed)
+	if a.failover != nil && newVersion {
+		a.failover.Trigger()
+	}
+
+	if a.announcer == nil {
+		return nil
+	}
+
+	a.announcer.SetMirrors(result.Mirrors)
+	if !newVersion {
+		// The periodic announcement repeats the unchanged version
+		a.announcer.Resume(a.state.GetVersion(), ipns, a.index.Count(), announcedRoot(&a.cfg.Publish, a.state), a.state.GetLastIndexCID())
+		return nil
+	}
+
+	// Indexers join their spans to the trace of this publish through the announcement
+	announceCtx, span := tracing.Start(ctx, "announce", attribute.Int("collection.version", a.state.GetVersion()))
+	a.announcer.SetTraceParent(tracing.Inject(announceCtx))
+	err = a.announcer.AnnounceIndexDelta(ipns, a.index.Count(), announcedRoot(&a.cfg.Publish, a.state), a.state.GetLastIndexCID(), deltaCID)
+	tracing.End(span, err)
+	if err != nil {
+		// The next periodic announcement retries
+		log.Warnf("Failed to announce version %d: %v", a.state.GetVersion(), err)
+	}
+
+	return nil
+}
+
+// fenced reports whether another publisher took over the collection
+func (a *app) fenced() bool {
+	if a.failover == nil {
+		return false
+	}
+	select {
+	case <-a.failover.Fenced():
+		return true
+	default:
+		return false
+	}
+}
+
+// recordPublished records the IPNS record just published for rootCID in the
+// state. Mirror keys that failed are left out, so the next publish retries them.
+func (a *app) recordPublished(rootCID string, result *ipfs.MirrorPublishResult) {
+	record := &state.PublishedRecord{
+		Value:       rootCID,
+		Version:     a.state.GetVersion(),
+		IPNS:        result.Primary.Name,
+		Mirrors:     result.Mirrors,
+		PublishedAt: time.Now().Unix(),
+	}
+	for _, key := range a.cfg.Publish.MirrorKeys {
+		if _, failed := result.Failed[key]; !failed {
+			record.MirrorKeys = append(record.MirrorKeys, key)
+		}
+	}
+	a.state.SetPublished(record)
+	a.unchanged = ""
+}
+
+// freshRecord returns the last published IPNS record if it points at rootCID
+// under the configured keys and was signed less than publish.ipns_lifetime/2
+// before now, together with the time until it is due for re-signing
+func (a *app) freshRecord(rootCID string, now time.Time) (*state.PublishedRecord, time.Duration) {
+	record := a.state.GetPublished()
+	if record == nil || record.Value != rootCID || record.Version != a.state.GetVersion() ||
+		!slices.Equal(record.MirrorKeys, a.cfg.Publish.MirrorKeys) {
+		return nil, 0
+	}
+
+	remaining := a.cfg.Publish.RepublishInterval() - now.Sub(time.Unix(record.PublishedAt, 0))
+	if remaining <= 0 {
+		return nil, 0
+	}
+	return record, remaining
+}
+
+// logUnchanged logs why an unchanged index is not published again, at info
+// level the first time for the record and at debug level afterwards
+func (a *app) logUnchanged(record *state.PublishedRecord, remaining time.Duration) {
+	log := logger.Get()
+	if a.unchanged == record.Value {
+		log.Debugf("Index unchanged, IPNS record due for re-signing in %v", remaining.Round(time.Second))
+		return
+	}
+	a.unchanged = record.Value
+
+	log.Infof("Index unchanged (version %d, %s) and the IPNS record signed at %s is due for re-signing in %v; not publishing it again",
+		record.Version, record.Value, time.Unix(record.PublishedAt, 0).Format(time.RFC3339), remaining.Round(time.Second))
+}
+
+// updateClaims signs the index records that lack a claim when publish.sign_records
+// is set and strips all claims when it is not. Sizes come from the state, with the
+// uncommitted changes applied.
+func (a *app) updateClaims(changes map[string]*state.FileState) {
+	if a.claimKey == nil {
+		if stripped := a.index.StripClaims(); stripped > 0 {
+			logger.Get().Infof("Removed claims from %d index records", stripped)
+		}
+		return
+	}
+
+	sizes := make(map[int]int64)
+	for _, fs := range a.state.GetAllFiles() {
+		sizes[fs.IndexID] = fs.Size
+	}
+	for _, fs := range changes {
+		if fs != nil {
+			sizes[fs.IndexID] = fs.Size
+		}
+	}
+	if signed := a.index.SignRecords(a.claimKey, sizes); signed > 0 {
+		logger.Get().Infof("Signed claims for %d index records", signed)
+	}
+}
+
+// indexVersion is an uploaded index version that is not yet published to IPNS
+type indexVersion struct {
+	version      int
+	indexCID     string
+	rootCID      string // What IPNS is to point at
+	indexRootCID string // Directory of the index and manifest if rootCID is the collection tree
+	deltaCID     string // Empty if there is no delta
+	manifestCID  string // Empty if no manifest was uploaded
+}
+
+// publishIndex uploads the index and the manifest as the next version together
+// with the delta from the previous version, and links them into the collection
+// tree with publish.layout directory or both. The index file and the state,
+// except for the record of the tree, are left unchanged.
+func (a *app) publishIndex(ctx context.Context) (*indexVersion, error) {
+	log := logger.Get()
+
+	baseVersion := a.state.GetVersion()
+	version := baseVersion + 1
+
+	a.index.SetCreatedAt(time.Now())
+	data, err := a.index.Marshal()
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal index: %w", err)
+	}
+
+	indexOpts := ipfs.AddOptions{Pin: true, Chunker: a.addOpts.Chunker, RawLeaves: a.addOpts.RawLeaves}
+	uploadCtx, span := tracing.Start(ctx, "upload-index", attribute.Int("collection.version", version), attribute.Int("index.records", a.index.Count()))
+	uploaded, err := a.client.AddIndex(uploadCtx, data, a.manifest, indexOpts)
+	tracing.End(span, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload index: %w", err)
+	}
+
+	delta, err := a.index.BuildDelta(version, baseVersion, a.state.GetLastIndexCID())
+	if err != nil {
+		return nil, fmt.Errorf("failed to build delta: %w", err)
+	}
+
+	deltaCID := ""
+	if delta != nil {
+		result, err := a.client.Add(ctx, bytes.NewReader(delta), index.DeltaFileName(version), indexOpts)
+		if err != nil {
+			// Indexers fall back to the full index
+			log.Warnf("Failed to upload delta for version %d: %v", version, err)
+		} else {
+			deltaCID = result.CID
+		}
+	}
+
+	log.Infof("✓ Uploaded index version %d (%d records): %s", version, a.index.Count(), uploaded.IndexCID)
+	result := &indexVersion{version: version, indexCID: uploaded.IndexCID, rootCID: uploaded.RootCID, deltaCID: deltaCID, manifestCID: uploaded.ManifestCID}
+	if a.cfg.Publish.Tree() {
+		treeCtx, span := tracing.Start(ctx, "update-tree", attribute.Int("collection.version", version))
+		treeCID, err := a.buildTree(treeCtx, uploaded.IndexCID, uploaded.ManifestCID)
+		tracing.End(span, err)
+		if err != nil {
+			return nil, err
+		}
+		result.rootCID, result.indexRootCID = treeCID, uploaded.RootCID
+	}
+	return result, nil
+}
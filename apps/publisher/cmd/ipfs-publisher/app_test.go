@@ -0,0 +1,772 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"github.com/atregu/ipfs-common/claim"
+	"github.com/atregu/ipfs-common/tracing"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/index"
+	"github.com/atregu/ipfs-publisher/internal/ipfs"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/metrics"
+	"github.com/atregu/ipfs-publisher/internal/pubsub"
+	"github.com/atregu/ipfs-publisher/internal/scanner"
+	"github.com/atregu/ipfs-publisher/internal/state"
+)
+
+// fakeClient is an ipfs.Client whose Add returns a CID derived from the content.
+// Methods the tests do not use panic through the nil embedded interface.
+type fakeClient struct {
+	ipfs.Client
+	duringAdd   func()   // Called after the content was read, before Add returns
+	addErrs     []error  // Errors returned by the next Add calls, in order
+	adds        int      // Number of Add calls so far
+	added       []string // Filenames passed to Add, in order
+	publishFail bool     // PublishIPNS fails, as if the process died before IPNS pointed at the new root
+	published   string   // Root CID last published to IPNS
+	publishes   int      // Number of PublishIPNS calls so far
+	pinFail     string   // CID that cannot be pinned
+	pinned      []string
+	pinManys    int      // Number of PinMany calls so far
+	unpinned    []string // CIDs passed to Unpin, in order
+	manifests   [][]byte // Manifests passed to AddIndex, in order
+}
+
+func (c *fakeClient) Add(ctx context.Context, reader io.Reader, filename string, opts ipfs.AddOptions) (*ipfs.AddResult, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	if c.duringAdd != nil {
+		c.duringAdd()
+	}
+	if len(c.addErrs) > 0 {
+		err := c.addErrs[0]
+		c.addErrs = c.addErrs[1:]
+		return nil, err
+	}
+	c.adds++
+	c.added = append(c.added, filename)
+	return &ipfs.AddResult{CID: "cid-" + string(data), Size: uint64(len(data)), Name: filename}, nil
+}
+
+func (c *fakeClient) PreflightAdd(ctx context.Context, bytes uint64) error {
+	return nil
+}
+
+func (c *fakeClient) AddIndex(ctx context.Context, data, manifest []byte, opts ipfs.AddOptions) (*ipfs.IndexUploadResult, error) {
+	records := strings.Count(string(data), "\n") - strings.Count(string(data), `"type":"header"`)
+	result := &ipfs.IndexUploadResult{RootCID: fmt.Sprintf("root-%d", records), IndexCID: fmt.Sprintf("index-%d", records)}
+	if manifest != nil {
+		c.manifests = append(c.manifests, manifest)
+		result.ManifestCID = fmt.Sprintf("manifest-%d", len(c.manifests))
+	}
+	return result, nil
+}
+
+func (c *fakeClient) Pin(ctx context.Context, cid string) error {
+	if cid == c.pinFail {
+		return errors.New("pin failed")
+	}
+	c.pinned = append(c.pinned, cid)
+	return nil
+}
+
+func (c *fakeClient) PinMany(ctx context.Context, cids []string) error {
+	c.pinManys++
+	for _, cid := range cids {
+		if err := c.Pin(ctx, cid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *fakeClient) Unpin(ctx context.Context, cid string) error {
+	c.unpinned = append(c.unpinned, cid)
+	return nil
+}
+
+func (c *fakeClient) PublishIPNS(ctx context.Context, cid string, opts ipfs.IPNSPublishOptions) (*ipfs.IPNSPublishResult, error) {
+	if c.publishFail {
+		return nil, errors.New("publish failed")
+	}
+	c.published = cid
+	c.publishes++
+	return &ipfs.IPNSPublishResult{Name: "k51test", Value: cid}, nil
+}
+
+// newTestApp returns an app with fresh state and index files in t.TempDir() that scans dir
+func newTestApp(t *testing.T, dir string, client ipfs.Client) *app {
+	t.Helper()
+	return loadTestApp(t, dir, client, t.TempDir())
+}
+
+// loadTestApp returns an app that scans dir and loads its state and index from
+// dataDir, as a restarted publisher would
+func loadTestApp(t *testing.T, dir string, client ipfs.Client, dataDir string) *app {
+	t.Helper()
+	logger.Get().SetOutput(io.Discard)
+
+	a := &app{
+		cfg: &config.Config{
+			Behavior: config.BehaviorConfig{BatchSize: 10, StateSaveInterval: 60},
+		},
+		client:   client,
+		state:    state.New(filepath.Join(dataDir, "state.json")),
+		index:    index.New(filepath.Join(dataDir, "collection.ndjson")),
+		scanner:  scanner.New([]string{dir}, []string{"mp3"}),
+		verifier: ipfs.NewVerifier(client, &config.BehaviorConfig{VerifyUploads: config.VerifyUploadsOff}, false),
+		uploads:  metrics.NewUploadMetrics(),
+	}
+	if err := a.state.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.index.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+// scanPending scans and returns the files that need an upload
+func scanPending(t *testing.T, a *app) []scanner.FileInfo {
+	t.Helper()
+
+	files, err := a.scanner.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pending []scanner.FileInfo
+	for i := range files {
+		if a.needsUpload(&files[i]) {
+			pending = append(pending, files[i])
+		}
+	}
+	return pending
+}
+
+func TestFileChangedDuringUploadIsUploadedAgain(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "song.mp3")
+	if err := os.WriteFile(path, []byte("take1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &fakeClient{}
+	a := newTestApp(t, dir, client)
+	ctx := context.Background()
+
+	// The file is rewritten while its first version is being added
+	client.duringAdd = func() {
+		client.duringAdd = nil
+		if err := os.WriteFile(path, []byte("take2, longer"), 0o644); err != nil {
+			t.Error(err)
+		}
+	}
+
+	pending := scanPending(t, a)
+	if len(pending) != 1 {
+		t.Fatalf("first scan: %d pending files, want 1", len(pending))
+	}
+	if err := a.uploadFile(ctx, &pending[0], nil); !errors.Is(err, scanner.ErrFileChanged) {
+		t.Fatalf("uploadFile = %v, want ErrFileChanged", err)
+	}
+	if _, ok := a.state.GetStagedFile(path); ok {
+		t.Fatal("state staged a file that changed during its upload")
+	}
+
+	// The next run uploads the new content and records its size
+	pending = scanPending(t, a)
+	if len(pending) != 1 {
+		t.Fatalf("second scan: %d pending files, want 1", len(pending))
+	}
+	if err := a.uploadFile(ctx, &pending[0], nil); err != nil {
+		t.Fatalf("uploadFile: %v", err)
+	}
+	fs, ok := a.state.GetStagedFile(path)
+	if !ok || fs.CID != "cid-take2, longer" || fs.Size != int64(len("take2, longer")) {
+		t.Errorf("state = %+v, want the second version", fs)
+	}
+
+	if pending := scanPending(t, a); len(pending) != 0 {
+		t.Errorf("third scan: %d pending files, want 0", len(pending))
+	}
+}
+
+func TestUploadRetries(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "song.mp3")
+	if err := os.WriteFile(path, []byte("take1"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	client := &fakeClient{addErrs: []error{refused, refused}}
+	a := newTestApp(t, dir, client)
+	a.cfg.Behavior.UploadRetries = 2
+	a.cfg.Behavior.UploadRetryBackoff = 0
+	ctx := context.Background()
+
+	// Transient failures are retried until the add succeeds
+	a.state.RecordUploadFailure(path, refused, 3)
+	pending := scanPending(t, a)
+	attempts, err := a.uploadWithRetries(ctx, &pending[0], nil)
+	if err != nil || attempts != 3 {
+		t.Fatalf("uploadWithRetries = %d attempts, %v; want success on the third", attempts, err)
+	}
+	if _, ok := a.state.GetStagedFile(path); !ok {
+		t.Error("retried upload not staged")
+	}
+	if failures := a.state.GetUploadFailures(); len(failures) != 0 {
+		t.Errorf("failures after the upload succeeded = %+v, want none", failures)
+	}
+
+	// Retries end after behavior.upload_retries
+	client.addErrs = []error{refused, refused, refused}
+	if err := os.WriteFile(path, []byte("take2, longer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pending = scanPending(t, a)
+	if attempts, err := a.uploadWithRetries(ctx, &pending[0], nil); !errors.Is(err, syscall.ECONNREFUSED) || attempts != 3 {
+		t.Errorf("uploadWithRetries = %d attempts, %v; want ECONNREFUSED after 3", attempts, err)
+	}
+
+	// A vanished file is not retried
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if attempts, err := a.uploadWithRetries(ctx, &pending[0], nil); !errors.Is(err, os.ErrNotExist) || attempts != 1 {
+		t.Errorf("uploadWithRetries of a vanished file = %d attempts, %v; want ErrNotExist after 1", attempts, err)
+	}
+}
+
+func TestCircuitOpenPausesScan(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.mp3", "b.mp3"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	retryAt := time.Now().Add(time.Minute)
+	client := &fakeClient{addErrs: []error{fmt.Errorf("failed to add file to IPFS: %w", &ipfs.CircuitOpenError{RetryAt: retryAt, Err: errors.New("connection refused")})}}
+	a := newTestApp(t, dir, client)
+	a.cfg.Behavior.UploadRetries = 2
+	ctx := context.Background()
+
+	// The first short-circuited add pauses uploads until the breaker probes the
+	// node; nothing is retried, recorded as failed or published
+	summary, err := a.scan(ctx)
+	if err != nil {
+		t.Fatalf("scan = %v, want a clean pause", err)
+	}
+	if summary.uploaded != 0 || summary.failed != 0 || client.adds != 0 || client.publishes != 0 {
+		t.Errorf("summary = %+v, %d adds, %d publishes; want nothing done", summary, client.adds, client.publishes)
+	}
+	if !a.pausedUntil.Equal(retryAt) || a.pauseReason != pauseUnreachable {
+		t.Errorf("paused until %s for %s, want %s for %s", a.pausedUntil, a.pauseReason, retryAt, pauseUnreachable)
+	}
+	if failures := a.state.GetUploadFailures(); len(failures) != 0 {
+		t.Errorf("failures = %+v, want none", failures)
+	}
+
+	// Once the pause is over both files are uploaded and published
+	a.pausedUntil = time.Now().Add(-time.Second)
+	if _, err := a.scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if client.adds != 2 || client.publishes != 1 || !a.pausedUntil.IsZero() {
+		t.Errorf("%d adds, %d publishes, paused until %s; want 2 adds published and no pause", client.adds, client.publishes, a.pausedUntil)
+	}
+}
+
+func TestStagedChangesResumeAfterCrash(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.mp3", "b.mp3"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The process dies after both uploads were staged and saved, before IPNS was published
+	client := &fakeClient{publishFail: true}
+	dataDir := t.TempDir()
+	a := loadTestApp(t, dir, client, dataDir)
+	if err := a.runScan(context.Background()); err == nil {
+		t.Fatal("runScan succeeded although publishing failed")
+	}
+
+	a = loadTestApp(t, dir, client, dataDir)
+	if v := a.state.GetVersion(); v != 0 {
+		t.Errorf("version after crash = %d, want 0", v)
+	}
+	if files := a.state.GetAllFiles(); len(files) != 0 {
+		t.Errorf("published files after crash = %d, want 0", len(files))
+	}
+	if got, want := a.state.StagedSummary(), "staged changes: 2 files pending publication"; got != want {
+		t.Errorf("StagedSummary() = %q, want %q", got, want)
+	}
+
+	// The restarted publisher publishes the staged change set without adding the files again
+	client.publishFail = false
+	addsBefore := client.adds
+	if err := a.runScan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if added := client.adds - addsBefore; added != 0 {
+		t.Errorf("resumed scan added %d files, want 0", added)
+	}
+
+	a = loadTestApp(t, dir, client, dataDir)
+	if v := a.state.GetVersion(); v != 1 {
+		t.Errorf("version = %d, want 1", v)
+	}
+	if n := a.state.StagedCount(); n != 0 {
+		t.Errorf("%d changes still staged", n)
+	}
+	if a.index.Count() != 2 || client.published != a.state.GetLastRootCID() {
+		t.Errorf("index has %d records and published root %s, want 2 records at %s", a.index.Count(), client.published, a.state.GetLastRootCID())
+	}
+	for _, name := range []string{"a.mp3", "b.mp3"} {
+		fs, ok := a.state.GetFile(filepath.Join(dir, name))
+		record, indexed := a.index.Get(name)
+		if !ok || !indexed || fs.IndexID != record.ID {
+			t.Errorf("%s: state %+v, index record %+v", name, fs, record)
+		}
+	}
+}
+
+func TestDeferredPinningRecordsOnlyPinnedFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.mp3", "b.mp3", "c.mp3"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &fakeClient{pinFail: "cid-b.mp3"}
+	a := newTestApp(t, dir, client)
+	a.deferPins = true
+
+	if err := a.runScan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if client.pinManys != 1 {
+		t.Errorf("%d bulk pins for one batch, want 1", client.pinManys)
+	}
+
+	// The file that could not be pinned is neither published nor staged
+	files := a.state.GetAllFiles()
+	if len(files) != 2 || files[filepath.Join(dir, "b.mp3")] != nil {
+		t.Errorf("published files = %v, want a.mp3 and c.mp3", files)
+	}
+	if _, ok := a.index.Get("b.mp3"); ok {
+		t.Error("unpinned file is in the index")
+	}
+	if pending := scanPending(t, a); len(pending) != 1 || pending[0].Name != "b.mp3" {
+		t.Fatalf("pending after scan = %v, want b.mp3", pending)
+	}
+
+	// Once pinning works again the next scan publishes it
+	client.pinFail = ""
+	if err := a.runScan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if fs, ok := a.state.GetFile(filepath.Join(dir, "b.mp3")); !ok || fs.CID != "cid-b.mp3" {
+		t.Errorf("b.mp3 state = %+v, want it published", fs)
+	}
+	if v := a.state.GetVersion(); v != 2 {
+		t.Errorf("version = %d, want 2", v)
+	}
+}
+
+func TestRecordClaimsPublishedAsNewVersion(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "song.mp3"), []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	a := newTestApp(t, dir, &fakeClient{})
+	if err := a.runScan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v := a.state.GetVersion(); v != 1 {
+		t.Fatalf("version = %d, want 1", v)
+	}
+
+	// Enabling claims on an unchanged collection publishes the signed records
+	publicKey, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.claimKey = key
+	if err := a.runScan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v := a.state.GetVersion(); v != 2 {
+		t.Errorf("version after enabling claims = %d, want 2", v)
+	}
+	record, _ := a.index.Get("song.mp3")
+	if err := claim.Verify(base64.StdEncoding.EncodeToString(publicKey), record.CID, record.Filename, 7, record.Signature); err != nil {
+		t.Errorf("claim of song.mp3 (size %d): %v", record.Size, err)
+	}
+
+	// Nothing changed since, so no new version
+	if err := a.runScan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v := a.state.GetVersion(); v != 2 {
+		t.Errorf("version after an unchanged scan = %d, want 2", v)
+	}
+
+	// Disabling them strips the claims in another version
+	a.claimKey = nil
+	if err := a.runScan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if v := a.state.GetVersion(); v != 3 {
+		t.Errorf("version after disabling claims = %d, want 3", v)
+	}
+	if record.Signature != "" || record.Size != 0 {
+		t.Errorf("record after disabling claims = %+v", *record)
+	}
+}
+
+func TestUnchangedIndexNotPublishedAgain(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "song.mp3"), []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &fakeClient{}
+	a := newTestApp(t, dir, client)
+	ctx := context.Background()
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if client.publishes != 1 {
+		t.Fatalf("publishes after the first scan = %d, want 1", client.publishes)
+	}
+	record := a.state.GetPublished()
+	if record == nil || record.Value != client.published || record.Version != 1 || record.IPNS != "k51test" {
+		t.Fatalf("published record = %+v, want version 1 of %s", record, client.published)
+	}
+
+	// Nothing changed and the record is fresh
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if client.publishes != 1 {
+		t.Errorf("publishes after an unchanged scan = %d, want 1", client.publishes)
+	}
+
+	// The republish timer re-signs the record regardless
+	if err := a.publish(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	if client.publishes != 2 {
+		t.Errorf("publishes after the republish timer = %d, want 2", client.publishes)
+	}
+
+	// A record signed more than half its lifetime ago is published again
+	record = a.state.GetPublished()
+	record.PublishedAt = time.Now().Add(-a.cfg.Publish.RepublishInterval()).Unix()
+	a.state.SetPublished(record)
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if client.publishes != 3 {
+		t.Errorf("publishes with a stale record = %d, want 3", client.publishes)
+	}
+	if v := a.state.GetVersion(); v != 1 {
+		t.Errorf("version = %d, want 1", v)
+	}
+}
+
+func TestRewriteWithSameContentNotUploaded(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "song.mp3")
+	if err := os.WriteFile(path, []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &fakeClient{}
+	a := newTestApp(t, dir, client)
+	ctx := context.Background()
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	fs, _ := a.state.GetFile(path)
+	if fs == nil || fs.SHA256 == "" {
+		t.Fatalf("state = %+v, want the content hash recorded", fs)
+	}
+
+	// Saved again unmodified: only the mtime changes
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if client.adds != 1 {
+		t.Errorf("adds after an unmodified save = %d, want 1", client.adds)
+	}
+	if fs, _ := a.state.GetFile(path); fs == nil || fs.ModTime != later.Unix() {
+		t.Errorf("state = %+v, want the new mtime recorded", fs)
+	}
+	if v := a.state.GetVersion(); v != 1 {
+		t.Errorf("version = %d, want 1", v)
+	}
+	if pending := scanPending(t, a); len(pending) != 0 {
+		t.Errorf("%d pending files after the mtime was recorded, want 0", len(pending))
+	}
+
+	// A state recorded without a hash uploads the file once more to get one
+	legacy := *fs
+	legacy.SHA256 = ""
+	legacy.ModTime--
+	a.state.SetFile(path, &legacy)
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if client.adds != 2 {
+		t.Errorf("adds for a state without hash = %d, want 2", client.adds)
+	}
+	if fs, _ := a.state.GetFile(path); fs == nil || fs.SHA256 == "" {
+		t.Errorf("state = %+v, want the hash recorded", fs)
+	}
+
+	// Changed content is uploaded
+	if err := os.WriteFile(path, []byte("changed"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if client.adds != 3 {
+		t.Errorf("adds after a change = %d, want 3", client.adds)
+	}
+}
+
+func TestMetadataChangeUpdatesRecordWithoutUpload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "song.mp3")
+	// An ID3v2.3 tag holding the title "Intro" in ISO-8859-1
+	title := append([]byte{'T', 'I', 'T', '2', 0, 0, 0, 6, 0, 0, 0}, "Intro"...)
+	tag := append([]byte{'I', 'D', '3', 3, 0, 0, 0, 0, 0, byte(len(title))}, title...)
+	if err := os.WriteFile(path, tag, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &fakeClient{}
+	a := newTestApp(t, dir, client)
+	ctx := context.Background()
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	record, _ := a.index.Get("song.mp3")
+	id, cid := record.ID, record.CID
+
+	// Metadata read for the first time, after the option was enabled
+	a.cfg.Behavior.ExtractMetadata = true
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if client.adds != 1 {
+		t.Errorf("adds after a metadata change = %d, want 1", client.adds)
+	}
+	if v := a.state.GetVersion(); v != 2 {
+		t.Errorf("version = %d, want 2", v)
+	}
+	record, _ = a.index.Get("song.mp3")
+	if record == nil || record.ID != id || record.CID != cid || record.Metadata.Title != "Intro" {
+		t.Fatalf("record = %+v, want record %d of %s titled Intro", record, id, cid)
+	}
+	fs, _ := a.state.GetFile(path)
+	if fs == nil || fs.MetadataHash != record.Metadata.Hash() || fs.ModTime != later.Unix() {
+		t.Errorf("state = %+v, want the metadata hash and the new mtime recorded", fs)
+	}
+
+	// Unchanged metadata publishes nothing
+	later = later.Add(time.Hour)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v := a.state.GetVersion(); v != 2 || client.adds != 1 {
+		t.Errorf("version %d after %d adds, want version 2 after 1", v, client.adds)
+	}
+}
+
+// recordingTransport records the announcements published through it
+type recordingTransport struct {
+	published []*pubsub.AnnouncementMessage
+}
+
+func (t *recordingTransport) Name() string {
+	return "recording"
+}
+
+func (t *recordingTransport) Publish(data []byte) error {
+	msg, err := pubsub.FromJSON(data)
+	if err != nil {
+		return err
+	}
+	t.published = append(t.published, msg)
+	return nil
+}
+
+func TestAnnouncedSizeIsIndexCount(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.mp3", "b.mp3"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &fakeClient{}
+	a := newTestApp(t, dir, client)
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &recordingTransport{}
+	a.announcer = pubsub.NewPublisher(nil, key, &pubsub.PublisherConfig{AnnounceInterval: time.Hour})
+	a.announcer.AddTransport(transport)
+
+	// The state records files the index does not hold, e.g. one left behind
+	// with behavior.remove_missing disabled
+	a.state.SetFile(filepath.Join(dir, "gone.mp3"), &state.FileState{CID: "cid-gone", Size: 4})
+	ctx := context.Background()
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if files, records := len(a.state.GetAllFiles()), a.index.Count(); files != 3 || records != 2 {
+		t.Fatalf("state holds %d files and the index %d records, want 3 and 2", files, records)
+	}
+
+	// Announcements repeated without a new version carry the same size
+	if err := a.announcer.AnnounceCurrent(); err != nil {
+		t.Fatal(err)
+	}
+	if len(transport.published) != 2 {
+		t.Fatalf("%d announcements published, want 2", len(transport.published))
+	}
+	for _, msg := range transport.published {
+		if msg.CollectionSize != 2 {
+			t.Errorf("announced collectionSize = %d, want the 2 records of the index", msg.CollectionSize)
+		}
+	}
+}
+
+func TestPublishTrace(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.mp3", "b.mp3"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracing.Use(provider)
+	t.Cleanup(func() { tracing.Use(noop.NewTracerProvider()) })
+
+	a := newTestApp(t, dir, &fakeClient{})
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &recordingTransport{}
+	a.announcer = pubsub.NewPublisher(nil, key, &pubsub.PublisherConfig{AnnounceInterval: time.Hour})
+	a.announcer.AddTransport(transport)
+	a.scans = newScanCoordinator(a.scan)
+
+	if err := a.scans.Run(context.Background(), triggerStartup, a.scan); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every step is a child of the scan span
+	spans := exporter.GetSpans()
+	names := make(map[string]int)
+	var scan, announce tracetest.SpanStub
+	for _, span := range spans {
+		names[span.Name]++
+		switch span.Name {
+		case "scan":
+			scan = span
+		case "announce":
+			announce = span
+		}
+	}
+	want := map[string]int{"scan": 1, "add": 2, "upload-index": 1, "ipns-publish": 1, "announce": 1}
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("spans = %v, want %v", names, want)
+	}
+	for _, span := range spans {
+		if span.Name != "scan" && span.Parent.SpanID() != scan.SpanContext.SpanID() {
+			t.Errorf("%s span is not a child of the scan span", span.Name)
+		}
+	}
+
+	// The announcement carries the announce span as the parent of the indexer's spans
+	if len(transport.published) != 1 {
+		t.Fatalf("%d announcements published, want 1", len(transport.published))
+	}
+	traceParent := transport.published[0].TraceParent
+	wantParent := fmt.Sprintf("00-%s-%s-01", announce.SpanContext.TraceID(), announce.SpanContext.SpanID())
+	if traceParent != wantParent {
+		t.Errorf("announced traceparent = %q, want %q", traceParent, wantParent)
+	}
+	if err := transport.published[0].Verify(); err != nil {
+		t.Errorf("announcement with a traceparent does not verify: %v", err)
+	}
+}
+
+func TestIsTerminal(t *testing.T) {
+	// Output redirected to a file or a pipe gets no progress bar
+	f, err := os.Create(filepath.Join(t.TempDir(), "out.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if isTerminal(f) {
+		t.Error("a regular file is reported as a terminal")
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	defer w.Close()
+	if isTerminal(w) {
+		t.Error("a pipe is reported as a terminal")
+	}
+}
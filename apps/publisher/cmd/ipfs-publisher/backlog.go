@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/atregu/ipfs-publisher/internal/scanner"
+	"github.com/atregu/ipfs-publisher/internal/watcher"
+)
+
+// errUploadInFlight is returned by uploadFile for a file that is already being uploaded
+var errUploadInFlight = errors.New("upload already in progress")
+
+// inFlight is the set of files being uploaded, shared by the scan and the watch
+// pipeline so a file is never uploaded twice at the same time. The zero value is
+// an empty set.
+type inFlight struct {
+	mu    sync.Mutex
+	paths map[string]bool
+}
+
+// start adds path to the set; it returns false if path is already in it
+func (f *inFlight) start(path string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.paths[path] {
+		return false
+	}
+	if f.paths == nil {
+		f.paths = make(map[string]bool)
+	}
+	f.paths[path] = true
+	return true
+}
+
+// done removes path from the set
+func (f *inFlight) done(path string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.paths, path)
+}
+
+// eventBacklog collects watcher events while a scan runs, keeping the latest
+// event per path. Without it the watcher's queue fills up during a long scan,
+// e.g. while files are bulk-copied into a watched directory.
+type eventBacklog struct {
+	mu     sync.Mutex
+	events map[string]watcher.FileEvent
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// collectEvents starts collecting events from the channel until Stop is called
+func collectEvents(events <-chan watcher.FileEvent) *eventBacklog {
+	b := &eventBacklog{
+		events: make(map[string]watcher.FileEvent),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(b.done)
+		for {
+			select {
+			case event := <-events:
+				b.mu.Lock()
+				b.events[event.Path] = event
+				b.mu.Unlock()
+			case <-b.stop:
+				return
+			}
+		}
+	}()
+
+	return b
+}
+
+// Stop stops collecting and returns the collected events in path order.
+// Events arriving afterwards stay queued in the watcher.
+func (b *eventBacklog) Stop() []watcher.FileEvent {
+	close(b.stop)
+	<-b.done
+
+	events := make([]watcher.FileEvent, 0, len(b.events))
+	for _, event := range b.events {
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].Path < events[j].Path })
+	return events
+}
+
+// unhandledEvents returns the backlog events whose change the scan did not already
+// pick up. The scan read every file it scheduled at upload time, so an event for a
+// file whose size and mtime now match its staged or published state, or for a file
+// that is gone and was never recorded, is coalesced into the scan's results.
+func (a *app) unhandledEvents(events []watcher.FileEvent) []watcher.FileEvent {
+	var remaining []watcher.FileEvent
+	for _, event := range events {
+		info, err := os.Stat(event.Path)
+		if err != nil {
+			_, published := a.state.GetFile(event.Path)
+			staged, ok := a.state.GetStagedFile(event.Path)
+			if published || (ok && staged != nil) {
+				remaining = append(remaining, event)
+			}
+			continue
+		}
+
+		file := &scanner.FileInfo{Path: event.Path, Size: info.Size(), ModTime: info.ModTime().Unix()}
+		if a.needsUpload(file) {
+			remaining = append(remaining, event)
+		}
+	}
+	return remaining
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/watcher"
+)
+
+func TestInitialScanCoalescesConcurrentEvents(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.mp3", "b.mp3"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &fakeClient{}
+	a := newTestApp(t, dir, client)
+	events := make(chan watcher.FileEvent)
+
+	// While the scan uploads its first file, a bulk copy adds c.mp3, which the
+	// walk already missed, and the watcher reports all three files repeatedly
+	copied := false
+	client.duringAdd = func() {
+		if copied {
+			return
+		}
+		copied = true
+		if err := os.WriteFile(filepath.Join(dir, "c.mp3"), []byte("c.mp3"), 0o644); err != nil {
+			t.Error(err)
+		}
+		for i := 0; i < 100; i++ {
+			for _, name := range []string{"a.mp3", "b.mp3", "c.mp3"} {
+				events <- watcher.FileEvent{Path: filepath.Join(dir, name), EventType: watcher.EventCreate, Timestamp: time.Now()}
+			}
+		}
+	}
+
+	summary, err := a.initialScan(context.Background(), events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.uploaded != 3 {
+		t.Errorf("summary counts %d uploads, want those of the scan and of the events", summary.uploaded)
+	}
+
+	added := append([]string(nil), client.added...)
+	sort.Strings(added)
+	if len(added) != 3 || added[0] != "a.mp3" || added[1] != "b.mp3" || added[2] != "c.mp3" {
+		t.Errorf("uploaded %v, want a.mp3, b.mp3 and c.mp3 once each", client.added)
+	}
+	if _, ok := a.index.Get("c.mp3"); !ok {
+		t.Error("c.mp3 copied during the scan was not published")
+	}
+}
+
+func TestInitialScanWithoutEvents(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.mp3"), []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &fakeClient{}
+	a := newTestApp(t, dir, client)
+	if _, err := a.initialScan(context.Background(), make(chan watcher.FileEvent)); err != nil {
+		t.Fatal(err)
+	}
+	if client.adds != 1 {
+		t.Errorf("adds = %d, want 1", client.adds)
+	}
+}
+
+func TestFileInFlightIsNotUploadedAgain(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.mp3")
+	if err := os.WriteFile(path, []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &fakeClient{}
+	a := newTestApp(t, dir, client)
+
+	// The other pipeline is uploading the file
+	if !a.inFlight.start(path) {
+		t.Fatal("start of a free path failed")
+	}
+	if err := a.runScan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if client.adds != 0 {
+		t.Fatalf("adds = %d while the file was in flight, want 0", client.adds)
+	}
+
+	a.inFlight.done(path)
+	if err := a.runScan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if client.adds != 1 {
+		t.Errorf("adds = %d after the upload finished, want 1", client.adds)
+	}
+}
+
+func TestUnhandledEventsInPathOrder(t *testing.T) {
+	dir := t.TempDir()
+	client := &fakeClient{}
+	a := newTestApp(t, dir, client)
+
+	events := make(chan watcher.FileEvent)
+	backlog := collectEvents(events)
+	for _, name := range []string{"z.mp3", "m.mp3", "a.mp3", "m.mp3"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		events <- watcher.FileEvent{Path: path, EventType: watcher.EventCreate}
+	}
+	// A file that came and went without being recorded needs no processing
+	events <- watcher.FileEvent{Path: filepath.Join(dir, "gone.mp3"), EventType: watcher.EventDelete}
+
+	remaining := a.unhandledEvents(backlog.Stop())
+	var names []string
+	for _, event := range remaining {
+		names = append(names, filepath.Base(event.Path))
+	}
+	if len(names) != 3 || names[0] != "a.mp3" || names[1] != "m.mp3" || names[2] != "z.mp3" {
+		t.Errorf("remaining events = %v, want a.mp3, m.mp3, z.mp3", names)
+	}
+}
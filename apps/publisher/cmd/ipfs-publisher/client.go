@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/ipfs"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/pubsub"
+)
+
+// pubsubTimeout bounds a single announcement published through an IPFS node
+const pubsubTimeout = 30 * time.Second
+
+// nodeInfo is implemented by both IPFS clients
+type nodeInfo interface {
+	GetVersion() (string, error)
+	GetID() (string, error)
+}
+
+// newClient creates the IPFS client for the configured mode. An embedded node is started.
+func newClient(cfg *config.Config) (ipfs.Client, error) {
+	if cfg.IPFS.Mode == config.IPFSModeEmbedded {
+		// The running publisher holds the embedded node's repo
+		if holder := runningPublisher(cfg); holder != nil && holder.PID != os.Getpid() {
+			return nil, fmt.Errorf("the publisher is running (PID %d) with the embedded IPFS node; stop it before running this command", holder.PID)
+		}
+		client, err := ipfs.NewEmbeddedClient(&cfg.IPFS.Embedded)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create embedded IPFS node: %w", err)
+		}
+		if err := client.Start(); err != nil {
+			return nil, fmt.Errorf("failed to start embedded IPFS node: %w", err)
+		}
+		return client, nil
+	}
+
+	timeout := time.Duration(cfg.IPFS.External.Timeout) * time.Second
+	client, err := ipfs.NewExternalClient(cfg.IPFS.External.APIURL, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create IPFS client: %w", err)
+	}
+	client.EnableCircuitBreaker(&cfg.IPFS.External.Breaker)
+	return client, nil
+}
+
+// addOptions converts the add_options of the active IPFS mode
+func addOptions(cfg *config.Config) ipfs.AddOptions {
+	options := cfg.IPFS.External.Options
+	if cfg.IPFS.Mode == config.IPFSModeEmbedded {
+		options = cfg.IPFS.Embedded.Options
+	}
+
+	return ipfs.AddOptions{
+		Pin:       boolOption(options, "pin", true),
+		NoCopy:    boolOption(options, "nocopy", false),
+		Chunker:   stringOption(options, "chunker"),
+		RawLeaves: boolOption(options, "raw_leaves", false),
+	}
+}
+
+// boolOption returns a boolean add option or def if it is unset
+func boolOption(options map[string]interface{}, key string, def bool) bool {
+	if v, ok := options[key].(bool); ok {
+		return v
+	}
+	return def
+}
+
+// stringOption returns a string add option or "" if it is unset
+func stringOption(options map[string]interface{}, key string) string {
+	if v, ok := options[key].(string); ok {
+		return v
+	}
+	return ""
+}
+
+// pubsubConfig returns the standalone PubSub node configuration
+func pubsubConfig(cfg *config.Config) *pubsub.Config {
+	return &pubsub.Config{
+		Topic:           cfg.Pubsub.Topic,
+		ListenPort:      cfg.Pubsub.ListenPort,
+		ListenAddresses: cfg.Pubsub.ListenAddresses,
+		BootstrapPeers:  cfg.Pubsub.BootstrapPeers,
+		MaxMemory:       cfg.Pubsub.MaxMemory,
+		EmbeddedIPFS:    cfg.IPFS.Mode == config.IPFSModeEmbedded,
+	}
+}
+
+// newAnnouncer creates the PubSub publisher. In embedded mode announcements go
+// through the embedded node's PubSub; otherwise a standalone node is started,
+// which is returned so the caller can stop it.
+func newAnnouncer(cfg *config.Config, client ipfs.Client, privateKey ed25519.PrivateKey) (*pubsub.Publisher, *pubsub.Node, error) {
+	publisherCfg := &pubsub.PublisherConfig{
+		AnnounceInterval: time.Duration(cfg.Pubsub.AnnounceInterval) * time.Second,
+		AckWarnAfter:     cfg.Pubsub.AckWarnAfter,
+	}
+
+	if embedded, ok := client.(*ipfs.EmbeddedClient); ok {
+		announcer := pubsub.NewPublisher(nil, privateKey, publisherCfg)
+		announcer.AddTransport(pubsub.NewDaemonTransport("embedded-ipfs", embedded, cfg.Pubsub.Topic, pubsubTimeout))
+		return announcer, nil, nil
+	}
+
+	nodeCfg := pubsubConfig(cfg)
+	node, err := pubsub.NewNode(nodeCfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create PubSub node: %w", err)
+	}
+	if err := node.Start(nodeCfg); err != nil {
+		return nil, nil, fmt.Errorf("failed to start PubSub node: %w", err)
+	}
+
+	announcer := pubsub.NewPublisher(node, privateKey, publisherCfg)
+
+	// The standalone node is a separate gossip mesh; also reach indexers on the daemon's network
+	if daemon, ok := client.(*ipfs.ExternalClient); ok && cfg.Pubsub.PublishViaDaemon {
+		ctx, cancel := context.WithTimeout(context.Background(), pubsubTimeout)
+		defer cancel()
+
+		log := logger.Get()
+		if transport := pubsub.DetectDaemonTransport(ctx, daemon, cfg.Pubsub.Topic, pubsubTimeout); transport != nil {
+			announcer.AddTransport(transport)
+			log.Info("✓ Also publishing announcements via the external daemon's PubSub")
+		} else {
+			log.Warn("pubsub.publish_via_daemon is set, but the external daemon has PubSub disabled; publishing via the standalone node only")
+		}
+	}
+
+	return announcer, node, nil
+}
+
+// topicSubscriber returns the PubSub that indexer acks and queries are received
+// through: the standalone node, or the embedded node's PubSub when there is none
+func topicSubscriber(client ipfs.Client, node *pubsub.Node) pubsub.TopicSubscriber {
+	if node != nil {
+		return node
+	}
+	if embedded, ok := client.(*ipfs.EmbeddedClient); ok {
+		return embedded
+	}
+	return nil
+}
@@ -0,0 +1,711 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/atregu/ipfs-common/fingerprint"
+	"github.com/atregu/ipfs-common/share"
+	"github.com/mdp/qrterminal/v3"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/index"
+	"github.com/atregu/ipfs-publisher/internal/ipfs"
+	"github.com/atregu/ipfs-publisher/internal/keys"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/pubsub"
+	"github.com/atregu/ipfs-publisher/internal/state"
+	"github.com/atregu/ipfs-publisher/internal/utils"
+)
+
+// commandTimeout bounds the one-shot test commands
+const commandTimeout = 2 * time.Minute
+
+// runInit writes a default configuration file (if none exists) and generates the keys
+func runInit(configPath string) error {
+	if _, err := os.Stat(configPath); err == nil {
+		fmt.Printf("Configuration file already exists: %s\n", configPath)
+	} else {
+		data, err := config.DefaultsYAML()
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(configPath, data, 0644); err != nil {
+			return fmt.Errorf("failed to write config file: %w", err)
+		}
+		fmt.Printf("✓ Created configuration file: %s\n", configPath)
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	keysDir := filepath.Join(home, ".ipfs_publisher", "keys")
+	if err := keys.New(keysDir).Initialize(); err != nil {
+		return fmt.Errorf("failed to initialize keys: %w", err)
+	}
+	fmt.Printf("✓ Keys ready in %s\n", keysDir)
+
+	fmt.Println()
+	fmt.Println("Next steps:")
+	fmt.Printf("  1. Add your media directories to %s\n", configPath)
+	fmt.Println("  2. Run ./ipfs-publisher --check-ipfs")
+	fmt.Println("  3. Run ./ipfs-publisher")
+	return nil
+}
+
+// runCheckIPFS connects to the IPFS node and prints its version and ID
+func runCheckIPFS(cfg *config.Config) error {
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	if err := client.IsAvailable(ctx); err != nil {
+		return fmt.Errorf("IPFS node is not available: %w", err)
+	}
+
+	fmt.Println("✓ Connected to IPFS node")
+	if info, ok := client.(nodeInfo); ok {
+		if v, err := info.GetVersion(); err == nil {
+			fmt.Printf("  Version: %s\n", v)
+		}
+		if id, err := info.GetID(); err == nil {
+			fmt.Printf("  Node ID: %s\n", id)
+		}
+	}
+
+	repo, err := client.RepoStat(ctx)
+	if err != nil {
+		fmt.Printf("  Repo: unavailable (%v)\n", err)
+		return nil
+	}
+	fmt.Printf("  Repo size: %s (%d objects)\n", utils.FormatBytes(int64(repo.RepoSize)), repo.NumObjects)
+	if repo.StorageMax > 0 {
+		fmt.Printf("  Storage max: %s (%.1f%% used)\n", utils.FormatBytes(int64(repo.StorageMax)), repo.Utilization()*100)
+	}
+	return nil
+}
+
+// runTestUpload uploads a single file, or a directory as a whole, and prints its CID
+func runTestUpload(cfg *config.Config, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	opts := addOptions(cfg)
+	if info.IsDir() {
+		result, err := client.AddDirectory(ctx, path, opts)
+		if err != nil {
+			return fmt.Errorf("upload failed: %w", err)
+		}
+		fmt.Println("✓ Upload successful!")
+		fmt.Printf("  Directory: %s\n", result.Name)
+		fmt.Printf("  Size: %d bytes\n", result.Size)
+		fmt.Printf("  CID: %s\n", result.CID)
+		fmt.Printf("  Pinned: %t\n", opts.Pin)
+		return nil
+	}
+	opts.FileInfo = info
+
+	name := filepath.Base(path)
+	if opts.NoCopy {
+		if name, err = filepath.Abs(path); err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", path, err)
+		}
+	}
+
+	result, err := client.Add(ctx, file, name, opts)
+	if err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+
+	fmt.Println("✓ Upload successful!")
+	fmt.Printf("  File: %s\n", filepath.Base(path))
+	fmt.Printf("  Size: %d bytes\n", info.Size())
+	if result.Size > 0 {
+		fmt.Printf("  DAG size: %d bytes\n", result.Size)
+	}
+	fmt.Printf("  CID: %s\n", result.CID)
+	fmt.Printf("  Pinned: %t\n", opts.Pin)
+	return nil
+}
+
+// runTestIPNS uploads test content, publishes it to IPNS and resolves the name
+func runTestIPNS(cfg *config.Config) error {
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	fmt.Println("1. Uploading test content...")
+	content := fmt.Sprintf("ipfs-publisher IPNS test %d\n", time.Now().Unix())
+	result, err := client.Add(ctx, bytes.NewReader([]byte(content)), "ipns-test.txt", ipfs.AddOptions{Pin: true})
+	if err != nil {
+		return fmt.Errorf("upload failed: %w", err)
+	}
+	fmt.Printf("   CID: %s\n", result.CID)
+
+	fmt.Println("2. Publishing to IPNS...")
+	published, err := client.PublishIPNS(ctx, result.CID, ipfs.NewIPNSPublishOptions(&cfg.Publish, ipnsKey))
+	if err != nil {
+		return fmt.Errorf("IPNS publish failed: %w", err)
+	}
+	fmt.Printf("   IPNS: /ipns/%s\n", published.Name)
+
+	fmt.Println("3. Resolving IPNS name...")
+	resolved, err := client.ResolveIPNS(ctx, published.Name)
+	if err != nil {
+		return fmt.Errorf("IPNS resolve failed: %w", err)
+	}
+	fmt.Printf("   Resolved: %s\n", resolved)
+
+	fmt.Println("✓ IPNS test successful!")
+	return nil
+}
+
+// runTestPubSub signs, verifies and publishes a test announcement
+func runTestPubSub(cfg *config.Config) error {
+	key, err := loadKey(cfg, keys.DefaultName)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Ed25519 keypair ready: %s\n", fingerprint.Of(key.Public().(ed25519.PublicKey)))
+
+	msg := pubsub.NewAnnouncementMessage(1, "k51-test", 0, time.Now().Unix())
+	if err := msg.Sign(key); err != nil {
+		return err
+	}
+	if err := msg.Verify(); err != nil {
+		return fmt.Errorf("signature verification failed: %w", err)
+	}
+	data, err := msg.ToJSON()
+	if err != nil {
+		return err
+	}
+	fmt.Println("✓ Test announcement signed and verified")
+
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if embedded, ok := client.(*ipfs.EmbeddedClient); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), pubsubTimeout)
+		defer cancel()
+		if err := embedded.PublishToPubSub(ctx, cfg.Pubsub.Topic, data); err != nil {
+			return fmt.Errorf("failed to publish: %w", err)
+		}
+	} else {
+		nodeCfg := pubsubConfig(cfg)
+		node, err := pubsub.NewNode(nodeCfg)
+		if err != nil {
+			return err
+		}
+		if err := node.Start(nodeCfg); err != nil {
+			return fmt.Errorf("failed to start PubSub node: %w", err)
+		}
+		defer node.Stop()
+
+		fmt.Printf("✓ PubSub node started: %s (%d peers)\n", node.GetPeerID(), node.GetPeerCount())
+		if _, err := node.Publish(data); err != nil {
+			return fmt.Errorf("failed to publish: %w", err)
+		}
+	}
+
+	fmt.Printf("✓ Test announcement published to topic %q\n", cfg.Pubsub.Topic)
+	return nil
+}
+
+// runPeerInfo prints the peer ID and addresses of the IPFS and PubSub nodes
+func runPeerInfo(cfg *config.Config) error {
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	fmt.Println("IPFS Node Information:")
+	fmt.Printf("Mode: %s\n\n", cfg.IPFS.Mode)
+
+	if info, ok := client.(nodeInfo); ok {
+		if id, err := info.GetID(); err == nil {
+			fmt.Printf("IPFS Peer ID: %s\n", id)
+		}
+	}
+
+	embedded, ok := client.(*ipfs.EmbeddedClient)
+	if ok {
+		addrs, err := embedded.GetPeerAddresses(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println("\nListen addresses:")
+		for _, addr := range addrs {
+			fmt.Printf("  %s\n", addr)
+		}
+		printSubscribeHelp(addrs, cfg.Pubsub.Topic)
+		return nil
+	}
+
+	fmt.Printf("API URL: %s\n", cfg.IPFS.External.APIURL)
+	fmt.Println("\n=== Standalone PubSub Node (External Mode) ===")
+	fmt.Println("Initializing standalone PubSub node...")
+
+	nodeCfg := pubsubConfig(cfg)
+	node, err := pubsub.NewNode(nodeCfg)
+	if err != nil {
+		return err
+	}
+	if err := node.Start(nodeCfg); err != nil {
+		return fmt.Errorf("failed to start PubSub node: %w", err)
+	}
+	defer node.Stop()
+
+	fmt.Printf("\nPubSub Peer ID: %s\n", node.GetPeerID())
+	fmt.Printf("Topic: %s\n", cfg.Pubsub.Topic)
+	fmt.Printf("Connected peers: %d\n", node.GetPeerCount())
+	fmt.Printf("Topic peers: %d\n", node.GetTopicPeerCount())
+
+	addrs := node.GetListenAddresses()
+	fmt.Println("\nListen addresses:")
+	for _, addr := range addrs {
+		fmt.Printf("  %s\n", addr)
+	}
+
+	if status := node.GetBootstrapStatus(); len(status) > 0 {
+		fmt.Println("\nBootstrap peers:")
+		for _, s := range status {
+			line := fmt.Sprintf("  %s connected=%t attempts=%d", s.Address, s.Connected, s.Attempts)
+			if s.LastError != "" {
+				line += fmt.Sprintf(" last_error=%q", s.LastError)
+			}
+			if !s.NextRetry.IsZero() {
+				line += fmt.Sprintf(" next_retry=%s", s.NextRetry.Format(time.RFC3339))
+			}
+			fmt.Println(line)
+		}
+	}
+
+	printSubscribeHelp(addrs, cfg.Pubsub.Topic)
+	return nil
+}
+
+// runSwarmConnect connects the IPFS node to a peer, e.g. a pinning cluster node
+func runSwarmConnect(cfg *config.Config, peerAddr string) error {
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	if err := client.SwarmConnect(ctx, peerAddr); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Connected to %s\n", peerAddr)
+	printEmbeddedSwarmNote(client)
+	return nil
+}
+
+// runSwarmDisconnect closes the IPFS node's connections to a peer
+func runSwarmDisconnect(cfg *config.Config, peerID string) error {
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	if err := client.SwarmDisconnect(ctx, peerID); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Disconnected from %s\n", peerID)
+	printEmbeddedSwarmNote(client)
+	return nil
+}
+
+// printEmbeddedSwarmNote explains that the embedded node of a command only
+// lives as long as the command
+func printEmbeddedSwarmNote(client ipfs.Client) {
+	if _, ok := client.(*ipfs.EmbeddedClient); ok {
+		fmt.Println("  The embedded node was started for this command and has stopped again; to connect on every start, list the peer in ipfs.embedded.bootstrap_peers")
+	}
+}
+
+// printSubscribeHelp prints how to receive announcements from the given addresses
+func printSubscribeHelp(addrs []string, topic string) {
+	if len(addrs) == 0 {
+		return
+	}
+
+	fmt.Println("\n=== To receive PubSub messages from this node ===")
+	fmt.Println("Run this command from your IPFS node:")
+	fmt.Printf("\n  ipfs swarm connect %s\n\n", addrs[0])
+	fmt.Println("Then subscribe to announcements:")
+	fmt.Printf("  ipfs pubsub sub %s\n", topic)
+}
+
+// runDryRun scans the directories and reports which files would be uploaded
+func runDryRun(cfg *config.Config) error {
+	stateManager := state.New(cfg.StatePath())
+	if err := stateManager.Load(); err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+
+	files, err := scanDirectories(cfg, newScanner(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to scan directories: %w", err)
+	}
+
+	var newFiles, changed, unchanged int
+	var pendingBytes int64
+	for _, file := range files {
+		// Staged uploads are not added again
+		fs, ok := stateManager.GetStagedFile(file.Path)
+		if !ok {
+			fs, ok = stateManager.GetFile(file.Path)
+		}
+		switch {
+		case !ok || fs == nil:
+			newFiles++
+			pendingBytes += file.Size
+			fmt.Printf("  [new]     %s (%s)\n", file.Path, utils.FormatBytes(file.Size))
+		case fs.ModTime != file.ModTime || fs.Size != file.Size:
+			changed++
+			pendingBytes += file.Size
+			fmt.Printf("  [changed] %s (%s)\n", file.Path, utils.FormatBytes(file.Size))
+		default:
+			unchanged++
+		}
+	}
+
+	fmt.Printf("\nDry run: %d files found, %d new, %d changed, %d unchanged\n", len(files), newFiles, changed, unchanged)
+	fmt.Printf("Would upload %s\n", utils.FormatBytes(pendingBytes))
+	return nil
+}
+
+// runShare prints a signed share document for the published collection as JSON
+// and as an mdn://share/ URI, optionally rendered as a QR code
+func runShare(cfg *config.Config, qr bool, multiaddrs []string) error {
+	stateManager := state.New(cfg.StatePath())
+	if err := stateManager.Load(); err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+	ipns := stateManager.GetIPNS()
+	if ipns == "" {
+		return fmt.Errorf("the collection has not been published yet; run the publisher first")
+	}
+
+	keyManager, err := newKeyManager(cfg)
+	if err != nil {
+		return err
+	}
+	key, err := keyManager.Get(keys.DefaultName)
+	if err != nil {
+		return err
+	}
+	// Indexers accept announcements signed with a rotated key only once the
+	// current key vouched for it here
+	historic, err := keyManager.LoadHistoric(keys.DefaultName)
+	if err != nil {
+		return fmt.Errorf("failed to load rotated keys: %w", err)
+	}
+
+	collection := share.Collection{IPNS: ipns, Title: cfg.Collection.Title, Version: stateManager.GetVersion()}
+	id := share.NewIdentity([]share.Collection{collection}, cfg.Pubsub.Topic, multiaddrs)
+	for _, previous := range historic {
+		id.PreviousKeys = append(id.PreviousKeys, base64.StdEncoding.EncodeToString(previous))
+	}
+	if err := id.Sign(key); err != nil {
+		return fmt.Errorf("failed to sign share document: %w", err)
+	}
+	if err := id.Validate(); err != nil {
+		return fmt.Errorf("invalid share document: %w", err)
+	}
+
+	doc, err := id.ToJSON()
+	if err != nil {
+		return err
+	}
+	uri, err := id.ToURI()
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(string(doc))
+	fmt.Println()
+	fmt.Println(uri)
+	if qr {
+		fmt.Println()
+		qrterminal.GenerateHalfBlock(uri, qrterminal.L, os.Stdout)
+	}
+
+	fmt.Println("\nFriends can add it with: ipfs-indexer add-collection --from-share <file|uri>")
+	return nil
+}
+
+// warnMissingPins checks a random sample of the CIDs recorded in state against the
+// node and warns prominently if enough are missing to suspect a wiped repo
+func warnMissingPins(ctx context.Context, client ipfs.Client, files map[string]*state.FileState, sample int) {
+	log := logger.Get()
+	if sample == 0 || len(files) == 0 {
+		return
+	}
+
+	report, err := ipfs.CheckPins(ctx, client, files, sample)
+	if err != nil {
+		log.Warnf("Pin check failed: %v", err)
+		return
+	}
+
+	switch {
+	case report.Severe():
+		log.Warn("!!! ==========================================================")
+		log.Warnf("!!! %d of %d sampled CIDs from the state file are missing from the IPFS node (%.0f%%)",
+			len(report.Missing), report.Checked, report.MissingRatio()*100)
+		log.Warn("!!! The node's content was likely wiped: published files are unretrievable")
+		log.Warn("!!! Run ipfs-publisher --repair to re-add them")
+		log.Warn("!!! ==========================================================")
+	case len(report.Missing) > 0:
+		log.Warnf("%d of %d sampled CIDs are missing from the IPFS node; run --verify-pins for details",
+			len(report.Missing), report.Checked)
+	default:
+		log.Debugf("Pin check: all %d sampled CIDs are on the node", report.Checked)
+	}
+}
+
+// runStatus prints whether the publisher is running, the published version, IPNS
+// name, the record counts of the saved index and pending staged changes
+func runStatus(cfg *config.Config) error {
+	stateManager := state.New(cfg.StatePath())
+	if err := stateManager.Load(); err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+
+	ipns := stateManager.GetIPNS()
+	if ipns == "" {
+		ipns = "(not published yet)"
+	}
+	if holder := runningPublisher(cfg); holder != nil {
+		fmt.Printf("publisher: running (PID %d), showing its last saved state\n", holder.PID)
+	} else {
+		fmt.Println("publisher: stopped")
+	}
+	fmt.Printf("version: %d\n", stateManager.GetVersion())
+	fmt.Printf("ipns: %s\n", ipns)
+	stats, err := index.ReadStats(cfg.IndexPath())
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	fmt.Printf("index: %d records, %d signed, %s\n", stats.Records, stats.Signed, utils.FormatBytes(stats.Bytes))
+	fmt.Println(stateManager.StagedSummary())
+	printUploadFailures(stateManager.GetUploadFailures())
+	if stateManager.GetVersion() > 0 {
+		fmt.Println(stateManager.AckSummary())
+	}
+	if cfg.Pubsub.Enabled {
+		reach := pubsub.NewReachTracker(cfg.ReachPath(), cfg.Pubsub.ReachAlertAfter, nil)
+		if err := reach.Load(); err != nil {
+			return err
+		}
+		fmt.Println(reach.Summary(time.Now()))
+	}
+	return nil
+}
+
+// printUploadFailures prints the files whose upload failed, sorted by path
+func printUploadFailures(failures map[string]state.UploadFailure) {
+	fmt.Printf("failed uploads: %d files\n", len(failures))
+	paths := make([]string, 0, len(failures))
+	for path := range failures {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		f := failures[path]
+		fmt.Printf("  %s: %s (%d attempts, %s)\n", path, f.Error, f.Attempts, time.Unix(f.FailedAt, 0).Format(time.DateTime))
+	}
+}
+
+// runVerifyPins checks every CID recorded in state against the node and prints the missing ones
+func runVerifyPins(cfg *config.Config) error {
+	stateManager := state.New(cfg.StatePath())
+	if err := stateManager.Load(); err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := client.IsAvailable(ctx); err != nil {
+		return fmt.Errorf("IPFS node is not available: %w", err)
+	}
+
+	report, err := ipfs.CheckPins(ctx, client, stateManager.GetAllFiles(), 0)
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(report.Missing))
+	for path := range report.Missing {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Printf("  [missing] %s (%s)\n", path, report.Missing[path])
+	}
+
+	fmt.Printf("\n%d of %d recorded CIDs missing from the node\n", len(report.Missing), report.Checked)
+	if len(report.Missing) > 0 {
+		fmt.Println("Run ipfs-publisher --repair to re-add them")
+		return fmt.Errorf("%d recorded CIDs are missing", len(report.Missing))
+	}
+	return nil
+}
+
+// runListPins prints every pin of the node with its type, then the CIDs recorded
+// in state that are not pinned
+func runListPins(cfg *config.Config) error {
+	stateManager := state.New(cfg.StatePath())
+	if err := stateManager.Load(); err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := client.IsAvailable(ctx); err != nil {
+		return fmt.Errorf("IPFS node is not available: %w", err)
+	}
+
+	pins, err := client.ListPins(ctx)
+	if err != nil {
+		return err
+	}
+
+	cids := make([]string, 0, len(pins))
+	types := make(map[string]int)
+	for cid, pinType := range pins {
+		cids = append(cids, cid)
+		types[pinType]++
+	}
+	sort.Strings(cids)
+	for _, cid := range cids {
+		fmt.Printf("  %s %s\n", cid, pins[cid])
+	}
+	fmt.Printf("\n%d pins: %d recursive, %d direct, %d indirect\n", len(pins),
+		types[ipfs.PinTypeRecursive], types[ipfs.PinTypeDirect], types[ipfs.PinTypeIndirect])
+
+	missing := ipfs.UnpinnedCIDs(pins, stateManager.GetAllFiles())
+	for _, cid := range missing {
+		fmt.Printf("  [not pinned] %s\n", cid)
+	}
+	fmt.Printf("%d CIDs recorded in state are not pinned\n", len(missing))
+	return nil
+}
+
+// runRepair re-adds the files whose recorded CIDs are missing from the node
+func runRepair(cfg *config.Config) error {
+	lock, err := lockInstance(cfg, "--repair")
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	stateManager := state.New(cfg.StatePath())
+	if err := stateManager.Load(); err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	// Re-adds must take the same path as the original adds to reproduce their CIDs
+	if external, ok := client.(*ipfs.ExternalClient); ok {
+		external.EnableChunkedAdd(&cfg.IPFS.External.ChunkedAdd, stateManager)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := client.IsAvailable(ctx); err != nil {
+		return fmt.Errorf("IPFS node is not available: %w", err)
+	}
+
+	result, err := ipfs.RepairMissing(ctx, client, stateManager.GetAllFiles(), addOptions(cfg))
+	if result != nil {
+		fmt.Printf("Repair: %d re-added, %d changed since publishing (left to the next scan), %d mismatched, %d failed\n",
+			result.Readded, result.Skipped, len(result.Mismatch), result.Failed)
+		if result.Imported > 0 {
+			fmt.Printf("  %d imported files are missing and must be pinned again on the node\n", result.Imported)
+		}
+		for _, path := range result.Mismatch {
+			fmt.Printf("  [mismatch] %s\n", path)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("repair failed: %w", err)
+	}
+	if len(result.Mismatch) > 0 || result.Failed > 0 {
+		return fmt.Errorf("%d files could not be repaired", len(result.Mismatch)+result.Failed)
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/metrics"
+)
+
+// Connectivity monitoring of the embedded node. A reconnect counts once peers
+// were seen on connectivitySettlePolls polls in a row after an outage of at least
+// connectivityMinOutage, and at most once per connectivityCooldown, so a flapping
+// connection does not republish and announce on every blip.
+const (
+	connectivityPollInterval = 10 * time.Second
+	connectivitySettlePolls  = 2
+	connectivityMinOutage    = time.Minute
+	connectivityCooldown     = 5 * time.Minute
+
+	// A gap this much larger than the poll interval means the host was suspended;
+	// connections do not survive that, even if the peer count has not dropped yet
+	connectivitySuspendFactor = 3
+)
+
+// peerCounter is implemented by IPFS clients that know their connected peers
+type peerCounter interface {
+	PeerCount() int
+}
+
+// connectivityMonitor turns peer count samples into reconnect-after-outage events
+type connectivityMonitor struct {
+	interval  time.Duration
+	minOutage time.Duration
+	cooldown  time.Duration
+
+	lastPoll     time.Time // Wall clock, which keeps running while the host sleeps
+	offlineSince time.Time // Start of the current outage; zero while connected
+	restoredAt   time.Time // First poll with peers after the outage
+	settled      int       // Polls with peers since restoredAt
+	lastRestore  time.Time // Last reported reconnect
+}
+
+func newConnectivityMonitor(interval time.Duration) *connectivityMonitor {
+	return &connectivityMonitor{
+		interval:  interval,
+		minOutage: connectivityMinOutage,
+		cooldown:  connectivityCooldown,
+	}
+}
+
+// observe records the peer count sampled at now. It returns the outage that
+// ended when a reconnect is confirmed and should be acted upon.
+func (m *connectivityMonitor) observe(peers int, now time.Time) (time.Duration, bool) {
+	log := logger.Get()
+	now = now.Round(0) // Strip the monotonic reading, which stops while suspended
+
+	if !m.lastPoll.IsZero() && m.offlineSince.IsZero() && now.Sub(m.lastPoll) > connectivitySuspendFactor*m.interval {
+		log.Infof("No connectivity check for %v, the host was probably suspended", formatOutage(now.Sub(m.lastPoll)))
+		m.offlineSince = m.lastPoll
+		m.settled = 0
+	}
+	m.lastPoll = now
+
+	if peers == 0 {
+		if m.offlineSince.IsZero() {
+			log.Warn("Embedded IPFS node lost all peers")
+			m.offlineSince = now
+		}
+		m.settled = 0
+		return 0, false
+	}
+	if m.offlineSince.IsZero() {
+		return 0, false
+	}
+
+	if m.settled == 0 {
+		m.restoredAt = now
+	}
+	m.settled++
+	if m.settled < connectivitySettlePolls {
+		return 0, false
+	}
+
+	outage := m.restoredAt.Sub(m.offlineSince)
+	m.offlineSince = time.Time{}
+	m.settled = 0
+	if outage < m.minOutage {
+		log.Debugf("Peers back after %v, too short to republish", outage.Round(time.Second))
+		return 0, false
+	}
+	if !m.lastRestore.IsZero() && now.Sub(m.lastRestore) < m.cooldown {
+		log.Infof("Connectivity restored after %s, but the last reconnect was handled %v ago; waiting for the next republish",
+			formatOutage(outage), now.Sub(m.lastRestore).Round(time.Second))
+		return 0, false
+	}
+	m.lastRestore = now
+	return outage, true
+}
+
+// watchConnectivity samples the peer count every poll interval until ctx is
+// done and sends the duration of each outage a confirmed reconnect ended
+func watchConnectivity(ctx context.Context, counter peerCounter, m *connectivityMonitor, metric *metrics.ConnectivityMetrics, restored chan<- time.Duration) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			peers := counter.PeerCount()
+			metric.Peers(peers)
+			outage, ok := m.observe(peers, now)
+			if !ok {
+				continue
+			}
+			metric.Restored(outage)
+			select {
+			case restored <- outage:
+			default: // A reconnect is still being handled
+			}
+		}
+	}
+}
+
+// reconnected handles a reconnect after an outage: it republishes the IPNS
+// record if it is due for re-signing or was last signed during the outage, when
+// it most likely reached no peer, and announces the current version again. It
+// reports whether the record was republished.
+func (a *app) reconnected(ctx context.Context, outage time.Duration) (bool, error) {
+	log := logger.Get()
+	rootCID := a.state.GetLastRootCID()
+	if rootCID == "" {
+		log.Infof("Connectivity restored after %s; nothing published yet", formatOutage(outage))
+		return false, nil
+	}
+
+	now := time.Now()
+	record, _ := a.freshRecord(rootCID, now)
+	republish := record == nil || time.Unix(record.PublishedAt, 0).After(now.Add(-outage))
+
+	announced := 0
+	if a.announcer != nil {
+		announced = a.announcer.GetCurrentVersion()
+	}
+
+	var done []string
+	if republish {
+		if err := a.publish(ctx, false); err != nil {
+			return false, err
+		}
+		done = append(done, "republished IPNS")
+	} else {
+		done = append(done, "IPNS record still fresh")
+	}
+
+	// A publish of pending changes announced its new version already
+	if a.announcer != nil && a.announcer.GetCurrentVersion() != announced {
+		done = append(done, fmt.Sprintf("announced v%d", a.announcer.GetCurrentVersion()))
+	} else if a.announcer != nil {
+		if err := a.announcer.AnnounceCurrent(); err != nil {
+			log.Warnf("Failed to announce after reconnecting: %v", err)
+		} else {
+			done = append(done, fmt.Sprintf("announced v%d", a.announcer.GetCurrentVersion()))
+		}
+	}
+
+	log.Infof("Connectivity restored after %s, %s", formatOutage(outage), strings.Join(done, ", "))
+	return republish, nil
+}
+
+// formatOutage formats an outage to the minute, or to the second below a minute
+func formatOutage(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+	s := strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// observeAll feeds peer counts sampled one poll interval apart from start and
+// returns the outages reported
+func observeAll(m *connectivityMonitor, start time.Time, peers ...int) (time.Time, []time.Duration) {
+	var outages []time.Duration
+	now := start
+	for _, p := range peers {
+		now = now.Add(m.interval)
+		if outage, ok := m.observe(p, now); ok {
+			outages = append(outages, outage)
+		}
+	}
+	return now, outages
+}
+
+func TestConnectivityMonitorReportsReconnectAfterOutage(t *testing.T) {
+	logger.Get().SetOutput(io.Discard)
+	m := newConnectivityMonitor(10 * time.Second)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// 12 polls without peers, then peers come back and stay
+	now, outages := observeAll(m, start, 5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 3)
+	if len(outages) != 0 {
+		t.Fatalf("outages before the connection settled = %v, want none", outages)
+	}
+	_, outages = observeAll(m, now, 4)
+	if len(outages) != 1 || outages[0] != 120*time.Second {
+		t.Errorf("outages = %v, want one of 2m", outages)
+	}
+}
+
+func TestConnectivityMonitorDebouncesFlapping(t *testing.T) {
+	logger.Get().SetOutput(io.Discard)
+	m := newConnectivityMonitor(10 * time.Second)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Drops shorter than the minimum outage and single polls with peers do not count
+	now, outages := observeAll(m, start, 5, 0, 0, 2, 5, 0, 1, 0, 0, 0, 3, 3)
+	if len(outages) != 0 {
+		t.Errorf("outages of a flapping connection = %v, want none", outages)
+	}
+
+	// A second real outage right after a handled one waits for the cooldown
+	zeros := make([]int, 7)
+	now, outages = observeAll(m, now, append(append([]int{}, zeros...), 2, 2)...)
+	if len(outages) != 1 {
+		t.Fatalf("outages = %v, want one", outages)
+	}
+	now, outages = observeAll(m, now, append(append([]int{}, zeros...), 2, 2)...)
+	if len(outages) != 0 {
+		t.Errorf("outages within the cooldown = %v, want none", outages)
+	}
+	_, outages = observeAll(m, now.Add(connectivityCooldown), append(append([]int{}, zeros...), 2, 2)...)
+	if len(outages) != 1 {
+		t.Errorf("outages after the cooldown = %v, want one", outages)
+	}
+}
+
+func TestConnectivityMonitorTreatsSuspendAsOutage(t *testing.T) {
+	logger.Get().SetOutput(io.Discard)
+	m := newConnectivityMonitor(10 * time.Second)
+	start := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	now, _ := observeAll(m, start, 5, 5)
+
+	// The laptop slept overnight; the stale peer count never dropped to 0
+	wake := now.Add(7*time.Hour + 23*time.Minute)
+	if _, ok := m.observe(5, wake); ok {
+		t.Fatal("reconnect reported before the connection settled")
+	}
+	outage, ok := m.observe(6, wake.Add(m.interval))
+	if !ok || formatOutage(outage) != "7h23m" {
+		t.Errorf("observe after waking = %v, %v, want an outage of 7h23m", outage, ok)
+	}
+}
+
+func TestReconnectedRepublishesDueRecord(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "song.mp3"), []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &fakeClient{}
+	a := newTestApp(t, dir, client)
+	ctx := context.Background()
+
+	// Nothing to republish before the first publish
+	if republished, err := a.reconnected(ctx, time.Hour); err != nil || republished {
+		t.Fatalf("reconnected before publishing = %v, %v", republished, err)
+	}
+
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Signed before a short outage and still fresh
+	record := a.state.GetPublished()
+	record.PublishedAt = time.Now().Add(-10 * time.Minute).Unix()
+	a.state.SetPublished(record)
+	if republished, err := a.reconnected(ctx, 5*time.Minute); err != nil || republished {
+		t.Errorf("reconnected with a fresh record = %v, %v, want no republish", republished, err)
+	}
+	if client.publishes != 1 {
+		t.Errorf("publishes = %d, want 1", client.publishes)
+	}
+
+	// Signed during the outage, so it likely reached nobody
+	if republished, err := a.reconnected(ctx, 20*time.Minute); err != nil || !republished {
+		t.Errorf("reconnected after a record signed offline = %v, %v, want a republish", republished, err)
+	}
+
+	// Due for re-signing
+	record = a.state.GetPublished()
+	record.PublishedAt = time.Now().Add(-a.cfg.Publish.RepublishInterval()).Unix()
+	a.state.SetPublished(record)
+	if republished, err := a.reconnected(ctx, 5*time.Minute); err != nil || !republished {
+		t.Errorf("reconnected with a due record = %v, %v, want a republish", republished, err)
+	}
+	if client.publishes != 3 {
+		t.Errorf("publishes = %d, want 3", client.publishes)
+	}
+	if v := a.state.GetVersion(); v != 1 {
+		t.Errorf("version = %d, want 1", v)
+	}
+}
+
+func TestFormatOutage(t *testing.T) {
+	for d, want := range map[time.Duration]string{
+		7*time.Hour + 23*time.Minute + 10*time.Second: "7h23m",
+		90 * time.Second: "2m",
+		45 * time.Second: "45s",
+		3 * time.Hour:    "3h",
+	} {
+		if got := formatOutage(d); got != want {
+			t.Errorf("formatOutage(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
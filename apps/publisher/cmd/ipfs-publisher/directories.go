@@ -0,0 +1,189 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/state"
+)
+
+// dirWatcher is the part of watcher.Watcher changed at runtime
+type dirWatcher interface {
+	AddDirectory(dir string) error
+	RemoveDirectory(dir string) error
+	Directories() []string
+}
+
+// dirChange is a directory added to or removed from the watcher at runtime
+type dirChange struct {
+	dir     string
+	removed bool
+}
+
+// directoryChanges adds and removes watched directories while the publisher
+// runs. The watcher changes right away; the directories the scans cover are
+// changed by the main loop, which then scans and publishes.
+type directoryChanges struct {
+	watcher dirWatcher
+
+	mu      sync.Mutex
+	pending []dirChange
+	ready   chan struct{} // Signaled when changes are pending
+}
+
+// newDirectoryChanges creates the directory changes of w
+func newDirectoryChanges(w dirWatcher) *directoryChanges {
+	return &directoryChanges{watcher: w, ready: make(chan struct{}, 1)}
+}
+
+// Ready is signaled when changes are pending
+func (d *directoryChanges) Ready() <-chan struct{} {
+	return d.ready
+}
+
+// take returns the pending changes in the order they were made and clears them
+func (d *directoryChanges) take() []dirChange {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	changes := d.pending
+	d.pending = nil
+	return changes
+}
+
+// queue records a change for the main loop
+func (d *directoryChanges) queue(change dirChange) {
+	d.mu.Lock()
+	d.pending = append(d.pending, change)
+	d.mu.Unlock()
+
+	select {
+	case d.ready <- struct{}{}:
+	default: // Already signaled
+	}
+}
+
+// dirControl adds and removes watched directories, e.g. pubsub.Publisher
+// through its directory callbacks
+type dirControl interface {
+	AddDirectory(dir string) error
+	RemoveDirectory(dir string) error
+}
+
+// AddDirectory adds the absolute directory dir to the watcher and queues the
+// scan covering it
+func (d *directoryChanges) AddDirectory(dir string) error {
+	return d.change(dir, false)
+}
+
+// RemoveDirectory removes the absolute directory dir from the watcher and
+// queues the scan that no longer covers it
+func (d *directoryChanges) RemoveDirectory(dir string) error {
+	return d.change(dir, true)
+}
+
+// change applies and queues the change of dir
+func (d *directoryChanges) change(dir string, removed bool) error {
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("path must be an absolute directory")
+	}
+	change := dirChange{dir: filepath.Clean(dir), removed: removed}
+	if err := d.apply(change); err != nil {
+		return err
+	}
+	d.queue(change)
+	return nil
+}
+
+// Handler serves /api/v1/watcher/directories on the control server: GET lists
+// the watched directories, POST adds and DELETE removes the absolute directory
+// given in the "path" query parameter through control. Changes answer 202
+// Accepted with the new list; the scan covering them runs next.
+func (d *directoryChanges) Handler(control dirControl) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost, http.MethodDelete:
+			change := control.AddDirectory
+			if r.Method == http.MethodDelete {
+				change = control.RemoveDirectory
+			}
+			if err := change(r.URL.Query().Get("path")); err != nil {
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+			status = http.StatusAccepted
+		default:
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.WriteHeader(status)
+		_ = json.NewEncoder(rw).Encode(d.watcher.Directories())
+	})
+}
+
+// apply adds or removes the directory of change in the watcher
+func (d *directoryChanges) apply(change dirChange) error {
+	if change.removed {
+		return d.watcher.RemoveDirectory(change.dir)
+	}
+
+	info, err := os.Stat(change.dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", change.dir)
+	}
+	return d.watcher.AddDirectory(change.dir)
+}
+
+// applyDirChanges changes the directories the scans cover and records the
+// changes in the state, so they outlast a restart. The files of a removed
+// directory are missing from the next scan, so behavior.remove_missing removes
+// them from the index.
+func (a *app) applyDirChanges(changes []dirChange) {
+	log := logger.Get()
+	for _, change := range changes {
+		a.state.RecordDirectoryChange(change.dir, change.removed)
+		if change.removed {
+			a.cfg.Directories = slices.DeleteFunc(a.cfg.Directories, func(dir string) bool { return dir == change.dir })
+			log.Infof("Removed directory %s", change.dir)
+			continue
+		}
+		a.cfg.Directories = append(a.cfg.Directories, change.dir)
+		log.Infof("Added directory %s", change.dir)
+	}
+	a.scanner = newScanner(a.cfg)
+}
+
+// restoreDirectories applies the directory changes recorded in the state over
+// the configured directories. An added directory that is gone is skipped.
+func restoreDirectories(cfg *config.Config, stateManager *state.Manager) {
+	log := logger.Get()
+	var dirs []string
+	for _, dir := range stateManager.ApplyDirectoryChanges(cfg.Directories) {
+		if !slices.Contains(cfg.Directories, dir) {
+			if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+				log.Warnf("Skipping directory %s added at runtime: not a directory anymore", dir)
+				continue
+			}
+		}
+		dirs = append(dirs, dir)
+	}
+	if !slices.Equal(dirs, cfg.Directories) {
+		log.Infof("Watching %s, as changed at runtime", strings.Join(dirs, ", "))
+	}
+	cfg.Directories = dirs
+}
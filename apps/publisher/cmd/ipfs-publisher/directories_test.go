@@ -0,0 +1,138 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/pubsub"
+	"github.com/atregu/ipfs-publisher/internal/state"
+)
+
+// fakeDirWatcher records the watched directories
+type fakeDirWatcher struct {
+	dirs []string
+}
+
+func (w *fakeDirWatcher) AddDirectory(dir string) error {
+	w.dirs = append(w.dirs, dir)
+	return nil
+}
+
+func (w *fakeDirWatcher) RemoveDirectory(dir string) error {
+	i := slices.Index(w.dirs, dir)
+	if i < 0 {
+		return errors.New("not watched")
+	}
+	w.dirs = slices.Delete(w.dirs, i, i+1)
+	return nil
+}
+
+func (w *fakeDirWatcher) Directories() []string {
+	return append([]string{}, w.dirs...)
+}
+
+func TestDirectoryChanges(t *testing.T) {
+	first, second := t.TempDir(), t.TempDir()
+	file := filepath.Join(second, "song.mp3")
+	if err := os.WriteFile(file, []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Changes go through the publisher's directory callbacks, as in the app
+	w := &fakeDirWatcher{dirs: []string{first}}
+	dirs := newDirectoryChanges(w)
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	announcer := pubsub.NewPublisher(nil, key, &pubsub.PublisherConfig{AnnounceInterval: time.Hour})
+	announcer.SetDirectoryCallbacks(pubsub.DirectoryCallbacks{Add: dirs.AddDirectory, Remove: dirs.RemoveDirectory})
+	handler := dirs.Handler(announcer)
+
+	request := func(method, path string) (int, []string) {
+		t.Helper()
+		target := "/api/v1/watcher/directories"
+		if path != "" {
+			target += "?path=" + url.QueryEscape(path)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		var listed []string
+		if rec.Code == http.StatusOK || rec.Code == http.StatusAccepted {
+			if err := json.Unmarshal(rec.Body.Bytes(), &listed); err != nil {
+				t.Fatalf("%s %s: %v", method, target, err)
+			}
+		}
+		return rec.Code, listed
+	}
+
+	if code, listed := request(http.MethodGet, ""); code != http.StatusOK || !reflect.DeepEqual(listed, []string{first}) {
+		t.Errorf("GET = %d %v, want 200 [%s]", code, listed, first)
+	}
+	for _, bad := range []string{"", "relative/dir", file, filepath.Join(second, "missing")} {
+		if code, _ := request(http.MethodPost, bad); code != http.StatusBadRequest {
+			t.Errorf("POST %q = %d, want 400", bad, code)
+		}
+	}
+	if code, _ := request(http.MethodDelete, second); code != http.StatusBadRequest {
+		t.Errorf("DELETE of an unwatched directory = %d, want 400", code)
+	}
+	if code, _ := request(http.MethodPut, first); code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT = %d, want 405", code)
+	}
+	select {
+	case <-dirs.Ready():
+		t.Fatal("rejected changes signaled")
+	default:
+	}
+
+	if code, listed := request(http.MethodPost, second+"/"); code != http.StatusAccepted || !reflect.DeepEqual(listed, []string{first, second}) {
+		t.Errorf("POST = %d %v, want 202 [%s %s]", code, listed, first, second)
+	}
+	if code, _ := request(http.MethodDelete, first); code != http.StatusAccepted {
+		t.Errorf("DELETE = %d, want 202", code)
+	}
+
+	// Both changes are signaled once and reach the scanned directories in order
+	<-dirs.Ready()
+	stateManager := state.New(filepath.Join(t.TempDir(), "state.json"))
+	a := &app{cfg: &config.Config{Directories: []string{first}}, state: stateManager}
+	a.applyDirChanges(dirs.take())
+	if !reflect.DeepEqual(a.cfg.Directories, []string{second}) {
+		t.Errorf("directories = %v, want [%s]", a.cfg.Directories, second)
+	}
+	if a.scanner == nil {
+		t.Error("scanner not replaced")
+	}
+	if changes := dirs.take(); len(changes) != 0 {
+		t.Errorf("changes taken twice: %v", changes)
+	}
+
+	// The changes outlast a restart with the same configuration
+	cfg := &config.Config{Directories: []string{first}}
+	restoreDirectories(cfg, stateManager)
+	if !reflect.DeepEqual(cfg.Directories, []string{second}) {
+		t.Errorf("restored directories = %v, want [%s]", cfg.Directories, second)
+	}
+
+	// An added directory that is gone is skipped
+	if err := os.RemoveAll(second); err != nil {
+		t.Fatal(err)
+	}
+	cfg = &config.Config{Directories: []string{first}}
+	restoreDirectories(cfg, stateManager)
+	if len(cfg.Directories) != 0 {
+		t.Errorf("restored directories = %v, want none", cfg.Directories)
+	}
+}
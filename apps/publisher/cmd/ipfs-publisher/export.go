@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/schollz/progressbar/v3"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/index"
+	"github.com/atregu/ipfs-publisher/internal/ipfs"
+	"github.com/atregu/ipfs-publisher/internal/state"
+	"github.com/atregu/ipfs-publisher/internal/utils"
+)
+
+// exportRoot is a DAG included in a CAR export
+type exportRoot struct {
+	cid  string
+	name string // Index record path, or the collection root directory
+}
+
+// exportRoots returns the roots of a CAR export of the published collection:
+// the root directory IPNS points at, the directory holding only the index and
+// manifest if that is the collection tree, then the CID of every index record
+// in ID order. A CID shared by several records is included once.
+func exportRoots(rootCID, indexRootCID string, idx *index.Manager) []exportRoot {
+	roots := []exportRoot{{cid: rootCID, name: "(collection root)"}}
+	seen := map[string]bool{rootCID: true}
+	if indexRootCID != "" {
+		roots = append(roots, exportRoot{cid: indexRootCID, name: "(index directory)"})
+		seen[indexRootCID] = true
+	}
+
+	paths := idx.Paths()
+	ids := make([]int, 0, len(paths))
+	for id := range paths {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		record, ok := idx.Get(paths[id])
+		if !ok || seen[record.CID] {
+			continue
+		}
+		seen[record.CID] = true
+		roots = append(roots, exportRoot{cid: record.CID, name: paths[id]})
+	}
+	return roots
+}
+
+// missingRoots returns the roots whose DAG is not complete on the node
+func missingRoots(ctx context.Context, client ipfs.Client, roots []exportRoot) ([]exportRoot, error) {
+	var missing []exportRoot
+	for i, root := range roots {
+		local, err := client.HasLocal(ctx, root.cid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check %s: %w", root.cid, err)
+		}
+		if !local {
+			missing = append(missing, root)
+		}
+		if (i+1)%1000 == 0 {
+			fmt.Printf("Checked %d/%d roots\n", i+1, len(roots))
+		}
+	}
+	return missing, nil
+}
+
+// runExportCAR writes the published collection, the index directory and every
+// file it lists, to a CAR archive at path. Every root must be on the node.
+func runExportCAR(cfg *config.Config, path string) error {
+	stateManager := state.New(cfg.StatePath())
+	if err := stateManager.Load(); err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+	rootCID := stateManager.GetLastRootCID()
+	if rootCID == "" {
+		return fmt.Errorf("nothing published yet; run the publisher before exporting")
+	}
+
+	indexManager := index.New(cfg.IndexPath())
+	if err := indexManager.Load(); err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+	if staged := stateManager.StagedCount(); staged > 0 {
+		fmt.Printf("Note: %s; the export holds the published version %d\n", stateManager.StagedSummary(), stateManager.GetVersion())
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	exporter, ok := client.(ipfs.Exporter)
+	if !ok {
+		return fmt.Errorf("the IPFS client cannot export CAR archives")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := client.IsAvailable(ctx); err != nil {
+		return fmt.Errorf("IPFS node is not available: %w", err)
+	}
+
+	roots := exportRoots(rootCID, stateManager.GetIndexRootCID(), indexManager)
+	fmt.Printf("Checking that the %d roots of version %d are on the node...\n", len(roots), stateManager.GetVersion())
+	missing, err := missingRoots(ctx, client, roots)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		for _, root := range missing {
+			fmt.Printf("  [missing] %s (%s)\n", root.name, root.cid)
+		}
+		fmt.Println("Run ipfs-publisher --repair to re-add them")
+		return fmt.Errorf("%d of %d roots are missing from the node", len(missing), len(roots))
+	}
+
+	cids := make([]string, len(roots))
+	for i, root := range roots {
+		cids[i] = root.cid
+	}
+
+	// The archive replaces path only once complete
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", tmpPath, err)
+	}
+	var out io.Writer = file
+	var bar *progressbar.ProgressBar
+	if isTerminal(os.Stdout) {
+		bar = progressbar.DefaultBytes(-1, "Exporting")
+		out = io.MultiWriter(file, bar)
+	}
+
+	result, err := exporter.ExportCAR(ctx, cids, out)
+	if bar != nil {
+		_ = bar.Finish()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("export failed: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename %s: %w", tmpPath, err)
+	}
+
+	fmt.Println("Roots:")
+	for _, root := range roots {
+		fmt.Printf("  %s %s\n", root.cid, root.name)
+	}
+	fmt.Printf("\n✓ Exported version %d to %s: %d roots, %d blocks, %s\n",
+		stateManager.GetVersion(), path, result.Roots, result.Blocks, utils.FormatBytes(result.Bytes))
+	fmt.Printf("Import it on another node with: ipfs dag import %s\n", path)
+	return nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/atregu/ipfs-publisher/internal/index"
+)
+
+func TestExportRoots(t *testing.T) {
+	idx := index.New(filepath.Join(t.TempDir(), "collection.ndjson"))
+	idx.Add("b.mp3", "cid-b", "mp3", index.File{})
+	idx.AddInGroup("a.mp3", "cid-a", "mp3", "Album", index.File{})
+	// A copy shares the CID of the first record
+	idx.AddInGroup("b.mp3", "cid-b", "mp3", "Copies", index.File{})
+
+	roots := exportRoots("root-1", "", idx)
+	want := []exportRoot{{"root-1", "(collection root)"}, {"cid-b", "b.mp3"}, {"cid-a", "Album/a.mp3"}}
+	if !slices.Equal(roots, want) {
+		t.Errorf("roots = %v, want %v", roots, want)
+	}
+
+	// A collection tree is exported with the directory of the index
+	roots = exportRoots("tree-1", "root-1", idx)
+	want = []exportRoot{{"tree-1", "(collection root)"}, {"root-1", "(index directory)"}, {"cid-b", "b.mp3"}, {"cid-a", "Album/a.mp3"}}
+	if !slices.Equal(roots, want) {
+		t.Errorf("roots with a tree = %v, want %v", roots, want)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"syscall"
+
+	"github.com/atregu/ipfs-common/extensions"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/index"
+	"github.com/atregu/ipfs-publisher/internal/ipfs"
+	"github.com/atregu/ipfs-publisher/internal/keys"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/state"
+	"github.com/atregu/ipfs-publisher/internal/utils"
+)
+
+// importFilter selects the files an import adopts
+type importFilter struct {
+	match      string         // Glob matched against the filename; "" matches all
+	extensions extensions.Set // Empty matches all
+}
+
+// matches reports whether the filename passes the filter
+func (f importFilter) matches(name string) bool {
+	if len(f.extensions) > 0 && !f.extensions.Matches(name) {
+		return false
+	}
+	if f.match == "" {
+		return true
+	}
+	ok, err := path.Match(f.match, name)
+	return err == nil && ok
+}
+
+// selectImports returns the entries to adopt: those passing the filter whose
+// path is not in the index yet. The index is keyed by group and filename, so of
+// several entries with the same path only the first is adopted.
+func selectImports(entries []ipfs.ImportEntry, filter importFilter, indexManager *index.Manager) (selected []ipfs.ImportEntry, skipped int) {
+	seen := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if !filter.matches(entry.Name) {
+			continue
+		}
+		path := index.RecordPath(entry.Group, entry.Name)
+		if _, exists := indexManager.Get(path); exists || seen[path] {
+			skipped++
+			continue
+		}
+		seen[path] = true
+		selected = append(selected, entry)
+	}
+	return selected, skipped
+}
+
+// importSource selects what an import adopts; exactly one field is set
+type importSource struct {
+	pins bool   // Files of the node's recursive pins
+	mfs  string // Files below this MFS directory
+	dir  string // This local directory, uploaded as a single UnixFS directory
+}
+
+// runImport adopts files already pinned on the node or below an MFS directory,
+// or uploads a local directory as a whole, into the collection and publishes
+// it. Imported entries are recorded under placeholder paths and flagged so
+// scans never upload or delete them.
+func runImport(cfg *config.Config, source importSource, filter importFilter, dryRun bool) error {
+	log := logger.Get()
+
+	sources := 0
+	for _, set := range []bool{source.pins, source.mfs != "", source.dir != ""} {
+		if set {
+			sources++
+		}
+	}
+	if sources != 1 {
+		return fmt.Errorf("import needs exactly one of --from-pins, --from-mfs and --from-dir")
+	}
+	if _, err := path.Match(filter.match, ""); err != nil {
+		return fmt.Errorf("invalid --match pattern %q: %w", filter.match, err)
+	}
+
+	// A dry run only reads the state and index, which the publisher replaces atomically
+	if !dryRun {
+		lock, err := lockInstance(cfg, "import")
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+	}
+
+	stateManager := state.New(cfg.StatePath())
+	if err := stateManager.Load(); err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+	if stateManager.StagedCount() > 0 {
+		return fmt.Errorf("%s; run the publisher to publish them before importing", stateManager.StagedSummary())
+	}
+
+	indexManager := index.New(cfg.IndexPath())
+	if err := indexManager.Load(); err != nil {
+		return fmt.Errorf("failed to load index: %w", err)
+	}
+	indexManager.SetMetadata(cfg.Collection.Visibility, cfg.Collection.License)
+	indexManager.SetGenerator(generator)
+
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	importer, ok := client.(ipfs.Importer)
+	if !ok && source.dir == "" {
+		return fmt.Errorf("import requires ipfs.mode external")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := client.IsAvailable(ctx); err != nil {
+		return fmt.Errorf("IPFS node is not available: %w", err)
+	}
+
+	var entries []ipfs.ImportEntry
+	switch {
+	case source.pins:
+		entries, err = importer.ListPinnedFiles(ctx)
+	case source.mfs != "":
+		entries, err = importer.ListMFSFiles(ctx, source.mfs)
+	default:
+		var entry ipfs.ImportEntry
+		entry, err = localDirectoryEntry(source.dir)
+		entries = []ipfs.ImportEntry{entry}
+		filter.extensions = nil // A directory has no extension
+	}
+	if err != nil {
+		return err
+	}
+
+	selected, skipped := selectImports(entries, filter, indexManager)
+	if skipped > 0 {
+		log.Warnf("Skipped %d files whose names are already in the collection", skipped)
+	}
+
+	var total int64
+	for _, entry := range selected {
+		total += entry.Size
+		if dryRun {
+			fmt.Printf("  [import] %s (%s) %s\n", entry.Path, utils.FormatBytes(entry.Size), entry.CID)
+		}
+	}
+	fmt.Printf("Import: %d of %d files selected (%s)\n", len(selected), len(entries), utils.FormatBytes(total))
+	if dryRun || len(selected) == 0 {
+		return nil
+	}
+
+	if source.dir != "" {
+		result, err := client.AddDirectory(ctx, source.dir, addOptions(cfg))
+		if err != nil {
+			return fmt.Errorf("failed to upload %s: %w", source.dir, err)
+		}
+		selected[0].CID = result.CID
+		selected[0].Size = int64(result.Size)
+		log.Infof("Uploaded %s as directory %s", source.dir, result.CID)
+	}
+
+	for _, entry := range selected {
+		stateManager.StageFile(entry.Path, &state.FileState{
+			CID:       entry.CID,
+			Size:      entry.Size,
+			Imported:  true,
+			Group:     entry.Group,
+			Directory: source.dir != "",
+		})
+	}
+
+	// Imported files are not scanned; scanned stays empty so nothing else is staged
+	a := &app{
+		cfg:     cfg,
+		client:  client,
+		state:   stateManager,
+		index:   indexManager,
+		addOpts: addOptions(cfg),
+	}
+	key, err := loadKey(cfg, keys.DefaultName)
+	if err != nil {
+		return err
+	}
+	if cfg.Publish.SignRecords {
+		a.claimKey = key
+	}
+	if a.manifest, err = buildManifest(cfg, key); err != nil {
+		return err
+	}
+	if err := a.publish(ctx, true); err != nil {
+		return err
+	}
+
+	log.Info("Indexers receive the new version with the next announcement of the running publisher")
+	return nil
+}
+
+// localDirectoryEntry returns the import entry of a local directory uploaded as
+// a whole; its CID is known once it is uploaded
+func localDirectoryEntry(dir string) (ipfs.ImportEntry, error) {
+	absPath, err := filepath.Abs(dir)
+	if err != nil {
+		return ipfs.ImportEntry{}, fmt.Errorf("failed to resolve %s: %w", dir, err)
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return ipfs.ImportEntry{}, fmt.Errorf("failed to stat %s: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return ipfs.ImportEntry{}, fmt.Errorf("%s is not a directory", dir)
+	}
+
+	size, err := ipfs.DirectorySize(absPath)
+	if err != nil {
+		return ipfs.ImportEntry{}, err
+	}
+	return ipfs.ImportEntry{
+		Path: ipfs.ImportDirPrefix + absPath,
+		Name: filepath.Base(absPath),
+		Size: size,
+	}, nil
+}
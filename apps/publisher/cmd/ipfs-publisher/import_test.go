@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/atregu/ipfs-common/extensions"
+
+	"github.com/atregu/ipfs-publisher/internal/index"
+	"github.com/atregu/ipfs-publisher/internal/ipfs"
+	"github.com/atregu/ipfs-publisher/internal/state"
+)
+
+func TestSelectImports(t *testing.T) {
+	a := newTestApp(t, t.TempDir(), &fakeClient{})
+	a.index.Add("local.mp3", "cid-local", "mp3", index.File{})
+
+	entries := []ipfs.ImportEntry{
+		{Path: "pin:dir/a/live.mp3", Name: "live.mp3", Group: "a"},
+		{Path: "pin:dir/b/live.mp3", Name: "live.mp3", Group: "b"},
+		{Path: "pin:dir/local.mp3", Name: "local.mp3"},
+		{Path: "pin:dir/studio.mp3", Name: "studio.mp3"},
+		{Path: "pin:dir/cover.jpg", Name: "cover.jpg"},
+	}
+	filter := importFilter{match: "*.mp3", extensions: extensions.NewSet([]string{"MP3"})}
+
+	selected, skipped := selectImports(entries, filter, a.index)
+	if len(selected) != 3 || selected[0].Path != "pin:dir/a/live.mp3" || selected[1].Path != "pin:dir/b/live.mp3" || selected[2].Name != "studio.mp3" {
+		t.Errorf("selected = %v, want a/live.mp3, b/live.mp3 and studio.mp3", selected)
+	}
+	if skipped != 1 {
+		t.Errorf("skipped = %d, want 1 (path already in the index)", skipped)
+	}
+
+	selected, _ = selectImports(entries, importFilter{match: "s*"}, a.index)
+	if len(selected) != 1 || selected[0].Name != "studio.mp3" {
+		t.Errorf("selected with match s* = %v, want studio.mp3", selected)
+	}
+}
+
+func TestImportedFilesSurviveScans(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "local.mp3"), []byte("local"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &fakeClient{}
+	a := newTestApp(t, dir, client)
+
+	const importPath = ipfs.ImportMFSPrefix + "/music/Live/encore.mp3"
+	a.state.StageFile(importPath, &state.FileState{CID: "cid-encore", Size: 7, Imported: true, Group: "Live"})
+	if err := a.publish(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+
+	record, ok := a.index.Get("Live/encore.mp3")
+	if !ok || record.CID != "cid-encore" || record.Group != "Live" || record.Extension != "mp3" {
+		t.Fatalf("imported record = %+v", record)
+	}
+
+	// Scans find no local file for it but neither upload nor remove it
+	if err := a.runScan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if client.adds != 1 {
+		t.Errorf("%d adds, want only the local file", client.adds)
+	}
+	if _, ok := a.index.Get("Live/encore.mp3"); !ok {
+		t.Error("imported file was removed from the index")
+	}
+	if fs, ok := a.state.GetFile(importPath); !ok || !fs.Imported {
+		t.Errorf("imported state = %+v, want it kept", fs)
+	}
+}
+
+func TestImportedDirectoryIndexedAsDirectory(t *testing.T) {
+	album := filepath.Join(t.TempDir(), "Album")
+	for name, content := range map[string]string{"01.flac": "track one", "cover.jpg": "art", ".DS_Store": "hidden", "Scans/back.jpg": "back"} {
+		path := filepath.Join(album, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entry, err := localDirectoryEntry(album)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Path != ipfs.ImportDirPrefix+album || entry.Name != "Album" || entry.Size != int64(len("track one")+len("art")+len("back")) {
+		t.Errorf("entry = %+v, want Album with the size of its visible files", entry)
+	}
+	if _, err := localDirectoryEntry(filepath.Join(album, "01.flac")); err == nil {
+		t.Error("a file was accepted as a directory")
+	}
+
+	client := &fakeClient{}
+	a := newTestApp(t, t.TempDir(), client)
+	a.state.StageFile(entry.Path, &state.FileState{CID: "cid-album", Size: entry.Size, Imported: true, Directory: true})
+	if err := a.publish(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+	if record, ok := a.index.Get("Album"); !ok || record.CID != "cid-album" || record.Extension != index.DirectoryExtension {
+		t.Errorf("directory record = %+v, want extension %q", record, index.DirectoryExtension)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/atregu/ipfs-common/fingerprint"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/keys"
+)
+
+// newKeyManager returns the initialized key manager of the base directory
+func newKeyManager(cfg *config.Config) (*keys.Manager, error) {
+	keyManager := keys.New(filepath.Join(cfg.BaseDir, "keys"))
+	if err := keyManager.Initialize(); err != nil {
+		return nil, fmt.Errorf("failed to initialize keys: %w", err)
+	}
+	return keyManager, nil
+}
+
+// loadKey returns the private key of an active named key
+func loadKey(cfg *config.Config, name string) (ed25519.PrivateKey, error) {
+	keyManager, err := newKeyManager(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return keyManager.Get(name)
+}
+
+// runKeys lists, creates, rotates or retires named publisher keys. Changes
+// take the instance lock; listing works while the publisher is running.
+func runKeys(cfg *config.Config, action, name string) error {
+	if action == "create" || action == "rotate" || action == "retire" {
+		lock, err := lockInstance(cfg, "keys "+action)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+	}
+
+	keyManager, err := newKeyManager(cfg)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "", "list":
+		for _, info := range keyManager.List() {
+			line := fmt.Sprintf("%-16s %-8s %s  %s  created %s", info.Name, info.Status, fingerprint.Format(info.PublicKey), info.PublicKey, info.CreatedAt.Format(time.RFC3339))
+			if info.RetiredAt != nil {
+				line += ", retired " + info.RetiredAt.Format(time.RFC3339)
+			}
+			fmt.Println(line)
+		}
+		return nil
+	case "create":
+		if name == "" {
+			return fmt.Errorf("usage: ipfs-publisher keys create <name>")
+		}
+		publicKey, err := keyManager.Create(name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✓ Created key %q: %s\n", name, fingerprint.Of(publicKey))
+		fmt.Printf("   Public key: %s\n", hex.EncodeToString(publicKey))
+		return nil
+	case "rotate":
+		if name == "" {
+			return fmt.Errorf("usage: ipfs-publisher keys rotate <name>")
+		}
+		publicKey, err := keyManager.Rotate(name)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✓ Rotated key %q: %s\n", name, fingerprint.Of(publicKey))
+		fmt.Printf("   Public key: %s\n", hex.EncodeToString(publicKey))
+		fmt.Println("   The old pair is archived next to it; announce again so indexers see the new key")
+		return nil
+	case "retire":
+		if name == "" {
+			return fmt.Errorf("usage: ipfs-publisher keys retire <name>")
+		}
+		if err := keyManager.Retire(name); err != nil {
+			return err
+		}
+		fmt.Printf("✓ Retired key %q; its private key was deleted, the public key is kept\n", name)
+		return nil
+	default:
+		return fmt.Errorf("unknown keys action %q; use list, create, rotate or retire", action)
+	}
+}
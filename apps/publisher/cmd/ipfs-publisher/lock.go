@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/lockfile"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// ownerPublisher is the lock owner of the running publisher
+const ownerPublisher = "publisher"
+
+// lockInstance takes the exclusive instance lock for command. Only one process
+// changes the state, index and keys at a time: the running publisher, or a
+// command that modifies them while the publisher is stopped. Read-only commands
+// do not lock; they read the files the publisher replaces atomically.
+func lockInstance(cfg *config.Config, command string) (*lockfile.Lockfile, error) {
+	lock, err := lockfile.New(cfg.BaseDir, cfg.Behavior.InstanceID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create lock file: %w", err)
+	}
+
+	err = lock.Acquire(command)
+	var held *lockfile.HeldError
+	switch {
+	case err == nil:
+		return lock, nil
+	case !errors.As(err, &held):
+		return nil, fmt.Errorf("failed to acquire lock %s: %w", lock.GetPath(), err)
+	case isPublisher(&held.Holder) && command == ownerPublisher:
+		return nil, fmt.Errorf("the publisher is already running (PID %d)", held.PID)
+	case isPublisher(&held.Holder):
+		return nil, fmt.Errorf("the publisher is running (PID %d); stop it before running %s, as it would overwrite the changes", held.PID, command)
+	case command == ownerPublisher:
+		return nil, fmt.Errorf("%s is running (PID %d); wait for it to finish before starting the publisher", held.Owner, held.PID)
+	default:
+		return nil, fmt.Errorf("%s is running (PID %d); wait for it to finish before running %s", held.Owner, held.PID, command)
+	}
+}
+
+// isPublisher reports whether a lock holder is the publisher. Lock files of
+// older versions do not name their owner and are assumed to be the publisher.
+func isPublisher(holder *lockfile.Holder) bool {
+	return holder.Owner == ownerPublisher || holder.Owner == ""
+}
+
+// runningPublisher returns the running publisher of the instance, or nil if it is stopped
+func runningPublisher(cfg *config.Config) *lockfile.Holder {
+	lock, err := lockfile.New(cfg.BaseDir, cfg.Behavior.InstanceID)
+	if err != nil {
+		return nil
+	}
+
+	holder, err := lock.Holder()
+	if err != nil {
+		logger.Get().Debugf("Failed to read lock %s: %v", lock.GetPath(), err)
+		return nil
+	}
+	if holder == nil || !isPublisher(holder) {
+		return nil
+	}
+	return holder
+}
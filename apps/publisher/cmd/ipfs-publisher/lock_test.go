@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/lockfile"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// newLockTestConfig returns the configuration of an instance in a fresh base directory
+func newLockTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+	logger.Get().SetOutput(io.Discard)
+
+	return &config.Config{
+		BaseDir:  t.TempDir(),
+		Behavior: config.BehaviorConfig{InstanceID: config.DefaultInstanceID},
+		IPFS:     config.IPFSConfig{Mode: config.IPFSModeEmbedded},
+	}
+}
+
+// holdLock makes the instance lock look taken by owner in another running
+// process, the parent of the test binary
+func holdLock(t *testing.T, cfg *config.Config, owner string) {
+	t.Helper()
+
+	lock, err := lockfile.New(cfg.BaseDir, cfg.Behavior.InstanceID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := fmt.Sprintf("%d\n%s\n", os.Getppid(), owner)
+	if err := os.WriteFile(lock.GetPath(), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMutatingCommandsWhilePublisherRuns(t *testing.T) {
+	commands := map[string]func(cfg *config.Config) error{
+		"import":      func(cfg *config.Config) error { return runImport(cfg, importSource{pins: true}, importFilter{}, false) },
+		"--repair":    runRepair,
+		"keys create": func(cfg *config.Config) error { return runKeys(cfg, "create", "mirror-1") },
+		"keys retire": func(cfg *config.Config) error { return runKeys(cfg, "retire", "mirror-1") },
+	}
+
+	for name, run := range commands {
+		cfg := newLockTestConfig(t)
+		holdLock(t, cfg, ownerPublisher)
+
+		err := run(cfg)
+		if err == nil || !strings.Contains(err.Error(), "stop it before running "+name) {
+			t.Errorf("%s while the publisher runs: %v, want an error asking to stop it", name, err)
+		}
+	}
+}
+
+func TestLockInstanceHolders(t *testing.T) {
+	tests := []struct {
+		holder, command, want string
+	}{
+		{"", ownerPublisher, ""},
+		{ownerPublisher, ownerPublisher, "the publisher is already running"},
+		{"", "import", "the publisher is running"}, // Lock file of an older version
+		{"import", ownerPublisher, "import is running"},
+		{"import", "--repair", "wait for it to finish before running --repair"},
+	}
+
+	for _, tt := range tests {
+		cfg := newLockTestConfig(t)
+		if tt.holder != "" || tt.want != "" {
+			holdLock(t, cfg, tt.holder)
+		}
+
+		lock, err := lockInstance(cfg, tt.command)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("%s with the lock free: %v", tt.command, err)
+				continue
+			}
+			lock.Release()
+			continue
+		}
+		if err == nil {
+			lock.Release()
+			t.Errorf("%s held by %q: lock taken", tt.command, tt.holder)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s held by %q: %v, want %q", tt.command, tt.holder, err, tt.want)
+		}
+	}
+}
+
+func TestReadOnlyCommandsWhilePublisherRuns(t *testing.T) {
+	cfg := newLockTestConfig(t)
+	if runningPublisher(cfg) != nil {
+		t.Fatal("publisher reported running before the lock was taken")
+	}
+
+	// Creating a key works while the publisher is stopped
+	if err := runKeys(cfg, "create", "mirror-1"); err != nil {
+		t.Fatalf("keys create with the publisher stopped: %v", err)
+	}
+
+	holdLock(t, cfg, ownerPublisher)
+	holder := runningPublisher(cfg)
+	if holder == nil || holder.PID != os.Getppid() {
+		t.Fatalf("runningPublisher = %+v, want the lock holder", holder)
+	}
+
+	if err := runKeys(cfg, "list", ""); err != nil {
+		t.Errorf("keys list while the publisher runs: %v", err)
+	}
+	if err := runStatus(cfg); err != nil {
+		t.Errorf("--status while the publisher runs: %v", err)
+	}
+
+	// The embedded node's repo belongs to the running publisher
+	if _, err := newClient(cfg); err == nil || !strings.Contains(err.Error(), "embedded IPFS node") {
+		t.Errorf("newClient in embedded mode while the publisher runs: %v, want a refusal", err)
+	}
+
+	// Another command holding the lock is not the publisher
+	holdLock(t, cfg, "import")
+	if holder := runningPublisher(cfg); holder != nil {
+		t.Errorf("runningPublisher = %+v while import holds the lock, want nil", holder)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/atregu/ipfs-common/configschema"
+	"github.com/atregu/ipfs-common/extensions"
+	"github.com/spf13/pflag"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+const version = "0.1.0"
+
+// generator identifies the publisher in the index header
+const generator = "ipfs-publisher/" + version
+
+// options holds the parsed command-line flags
+type options struct {
+	configPath      string
+	showVersion     bool
+	showHelp        bool
+	init            bool
+	printDefaults   bool
+	envHelp         bool
+	ignoreUnknown   bool
+	checkIPFS       bool
+	testUpload      string
+	testIPNS        bool
+	testPubSub      bool
+	peerInfo        bool
+	swarmConnect    string
+	swarmDisconnect string
+	dryRun          bool
+	status          bool
+	verifyPins      bool
+	listPins        bool
+	repair          bool
+	exportCAR       string
+	ipfsMode        string
+	command         string
+	qr              bool
+	multiaddrs      []string
+	fromPins        bool
+	fromMFS         string
+	fromDir         string
+	match           string
+	importExts      []string
+	probeSample     int
+	probeCIDs       []string
+	reprovide       bool
+	sync            bool
+	takeover        bool
+}
+
+// parseFlags parses the command line
+func parseFlags() *options {
+	opts := &options{}
+
+	pflag.StringVarP(&opts.configPath, "config", "c", "./config.yaml", "Path to config file")
+	pflag.BoolVarP(&opts.showVersion, "version", "v", false, "Show version information")
+	pflag.BoolVarP(&opts.showHelp, "help", "h", false, "Show help message")
+	pflag.BoolVar(&opts.init, "init", false, "Initialize configuration and generate keys")
+	pflag.BoolVar(&opts.printDefaults, "print-defaults", false, "Print the default configuration as plain YAML and exit")
+	pflag.BoolVar(&opts.envHelp, "env-help", false, "Print the environment variables that override config settings and exit")
+	pflag.BoolVar(&opts.ignoreUnknown, "ignore-unknown-config", false, "Warn about unknown config keys instead of failing")
+	pflag.BoolVar(&opts.checkIPFS, "check-ipfs", false, "Check IPFS connection and exit")
+	pflag.StringVar(&opts.testUpload, "test-upload", "", "Upload a test file or directory to IPFS and exit")
+	pflag.BoolVar(&opts.testIPNS, "test-ipns", false, "Test IPNS publish and resolve")
+	pflag.BoolVar(&opts.testPubSub, "test-pubsub", false, "Test PubSub announcement publishing")
+	pflag.BoolVar(&opts.peerInfo, "peer-info", false, "Show IPFS and PubSub peer information")
+	pflag.StringVar(&opts.swarmConnect, "swarm-connect", "", "Connect the IPFS node to the peer at this multiaddr ending in /p2p/<peer ID> and exit")
+	pflag.StringVar(&opts.swarmDisconnect, "swarm-disconnect", "", "Disconnect the IPFS node from this peer ID and exit")
+	pflag.BoolVar(&opts.dryRun, "dry-run", false, "Scan and show what would be processed without uploading")
+	pflag.BoolVar(&opts.status, "status", false, "Show version, IPNS name, index records, staged changes, failed uploads, indexer acks and reach and exit")
+	pflag.BoolVar(&opts.verifyPins, "verify-pins", false, "Check that every CID recorded in state is still on the node and exit")
+	pflag.BoolVar(&opts.listPins, "list-pins", false, "List the node's pins and the CIDs recorded in state that are not pinned and exit")
+	pflag.BoolVar(&opts.repair, "repair", false, "Re-add files whose recorded CIDs are missing from the node and exit")
+	pflag.StringVar(&opts.exportCAR, "export-car", "", "Write the published index and every file it lists to this CAR archive and exit")
+	pflag.StringVar(&opts.ipfsMode, "ipfs-mode", "", "Override IPFS mode from config (external/embedded)")
+	pflag.BoolVar(&opts.qr, "qr", false, "share: also render the share URI as a QR code")
+	pflag.StringSliceVar(&opts.multiaddrs, "multiaddr", nil, "share: multiaddr to include in the share document (repeatable)")
+	pflag.BoolVar(&opts.fromPins, "from-pins", false, "import: adopt the files of the node's recursive pins")
+	pflag.StringVar(&opts.fromMFS, "from-mfs", "", "import: adopt the files below this MFS directory")
+	pflag.StringVar(&opts.fromDir, "from-dir", "", "import: upload this local directory as a single directory CID, e.g. an album folder")
+	pflag.StringVar(&opts.match, "match", "", "import: only adopt files whose name matches this glob")
+	pflag.StringSliceVar(&opts.importExts, "ext", nil, "import: only adopt files with these extensions (default: configured extensions)")
+	pflag.IntVar(&opts.probeSample, "sample", defaultProbeSample, "probe: number of randomly chosen published CIDs to look up (0 = all)")
+	pflag.StringSliceVar(&opts.probeCIDs, "cid", nil, "probe: look up this CID instead of a sample (repeatable)")
+	pflag.BoolVar(&opts.reprovide, "reprovide", false, "probe: announce CIDs without an external provider again")
+	pflag.BoolVar(&opts.sync, "sync", false, "standby: restore the primary's latest state snapshot")
+	pflag.BoolVar(&opts.takeover, "takeover", false, "standby: restore the latest snapshot and take over publishing once the primary's heartbeat stopped")
+
+	pflag.Parse()
+	opts.command = pflag.Arg(0)
+	return opts
+}
+
+func main() {
+	opts := parseFlags()
+
+	if opts.showHelp {
+		fmt.Println("Usage: ipfs-publisher [flags]")
+		fmt.Println("       ipfs-publisher share [--qr] [--multiaddr addr]...")
+		fmt.Println("       ipfs-publisher import --from-pins|--from-mfs path [--match glob] [--ext ext]... [--dry-run]")
+		fmt.Println("       ipfs-publisher import --from-dir path [--dry-run]")
+		fmt.Println("       ipfs-publisher keys [list | create <name> | rotate <name> | retire <name>]")
+		fmt.Println("       ipfs-publisher probe [--sample N | --cid cid...] [--reprovide]")
+		fmt.Println("       ipfs-publisher standby --sync|--takeover")
+		fmt.Println("       ipfs-publisher config schema")
+		fmt.Println()
+		pflag.PrintDefaults()
+		return
+	}
+
+	if opts.showVersion {
+		fmt.Printf("ipfs-publisher version %s\n", version)
+		return
+	}
+
+	if opts.printDefaults {
+		data, err := config.DefaultsYAML()
+		if err != nil {
+			exitf("%v", err)
+		}
+		os.Stdout.Write(data)
+		return
+	}
+
+	if opts.envHelp {
+		settings, err := config.EnvSettings()
+		if err != nil {
+			exitf("%v", err)
+		}
+		if err := config.WriteEnvSettings(os.Stdout, settings); err != nil {
+			exitf("Failed to print environment variables: %v", err)
+		}
+		return
+	}
+
+	maxArgs := 1
+	switch opts.command {
+	case "keys":
+		maxArgs = 3
+	case "config":
+		maxArgs = 2
+	}
+	if pflag.NArg() > maxArgs || (opts.command != "" && opts.command != "share" && opts.command != "import" && opts.command != "keys" && opts.command != "probe" && opts.command != "standby" && opts.command != "config") ||
+		(opts.command == "config" && pflag.Arg(1) != "schema") {
+		exitf("unknown command %q; see --help", strings.Join(pflag.Args(), " "))
+	}
+
+	if opts.command == "config" {
+		fields, err := config.Schema()
+		if err != nil {
+			exitf("%v", err)
+		}
+		configschema.Write(os.Stdout, fields)
+		return
+	}
+
+	if opts.init {
+		if err := runInit(opts.configPath); err != nil {
+			exitf("Initialization failed: %v", err)
+		}
+		return
+	}
+
+	cfg, err := config.LoadWithOptions(opts.configPath, config.LoadOptions{IgnoreUnknownKeys: opts.ignoreUnknown})
+	if err != nil {
+		exitf("Failed to load configuration: %v", err)
+	}
+
+	if opts.ipfsMode != "" {
+		cfg.IPFS.Mode = config.IPFSMode(opts.ipfsMode)
+		if err := cfg.Validate(); err != nil {
+			exitf("Invalid --ipfs-mode: %v", err)
+		}
+	}
+
+	if err := logger.Init(cfg.Logging.Level, cfg.Logging.File, cfg.Logging.MaxSize, cfg.Logging.MaxBackups, cfg.Logging.Console); err != nil {
+		exitf("Failed to initialize logger: %v", err)
+	}
+
+	log := logger.Get()
+	for _, warning := range cfg.Warnings() {
+		log.Warn(warning)
+	}
+
+	switch {
+	case opts.command == "share":
+		err = runShare(cfg, opts.qr, opts.multiaddrs)
+	case opts.command == "keys":
+		err = runKeys(cfg, pflag.Arg(1), pflag.Arg(2))
+	case opts.command == "probe":
+		err = runProbe(cfg, opts.probeSample, opts.probeCIDs, opts.reprovide)
+	case opts.command == "standby":
+		err = runStandby(cfg, opts.sync, opts.takeover)
+	case opts.command == "import":
+		exts := opts.importExts
+		if len(exts) == 0 {
+			exts = cfg.Extensions
+		}
+		filter := importFilter{match: opts.match, extensions: extensions.NewSet(exts)}
+		source := importSource{pins: opts.fromPins, mfs: opts.fromMFS, dir: opts.fromDir}
+		err = runImport(cfg, source, filter, opts.dryRun)
+	case opts.checkIPFS:
+		err = runCheckIPFS(cfg)
+	case opts.testUpload != "":
+		err = runTestUpload(cfg, opts.testUpload)
+	case opts.testIPNS:
+		err = runTestIPNS(cfg)
+	case opts.testPubSub:
+		err = runTestPubSub(cfg)
+	case opts.peerInfo:
+		err = runPeerInfo(cfg)
+	case opts.swarmConnect != "":
+		err = runSwarmConnect(cfg, opts.swarmConnect)
+	case opts.swarmDisconnect != "":
+		err = runSwarmDisconnect(cfg, opts.swarmDisconnect)
+	case opts.dryRun:
+		err = runDryRun(cfg)
+	case opts.status:
+		err = runStatus(cfg)
+	case opts.verifyPins:
+		err = runVerifyPins(cfg)
+	case opts.listPins:
+		err = runListPins(cfg)
+	case opts.repair:
+		err = runRepair(cfg)
+	case opts.exportCAR != "":
+		err = runExportCAR(cfg, opts.exportCAR)
+	default:
+		err = run(cfg)
+	}
+
+	if err != nil {
+		exitf("%v", err)
+	}
+}
+
+// exitf prints an error message and exits with a non-zero status
+func exitf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "Error: "+format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/atregu/ipfs-common/manifest"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/state"
+)
+
+// buildManifest returns the signed manifest.json published next to the index,
+// built from the collection and PubSub settings. It carries no timestamp, so
+// unchanged settings give the same bytes and do not publish a new version.
+func buildManifest(cfg *config.Config, key ed25519.PrivateKey) ([]byte, error) {
+	m := &manifest.Manifest{
+		Format:      manifest.FormatVersion,
+		Name:        cfg.Collection.Title,
+		Description: cfg.Collection.Description,
+		License:     cfg.Collection.License,
+		Contact:     cfg.Collection.Contact,
+	}
+	if cfg.Pubsub.Enabled {
+		m.Topic = cfg.Pubsub.Topic
+		m.HeartbeatInterval = cfg.Pubsub.AnnounceInterval
+	}
+	if err := m.Sign(key); err != nil {
+		return nil, fmt.Errorf("failed to sign manifest: %w", err)
+	}
+	return m.ToJSON()
+}
+
+// manifestHash returns the hex SHA-256 of a manifest, or "" for none
+func manifestHash(data []byte) string {
+	if data == nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// manifestChanged reports whether the manifest differs from the one last published
+func (a *app) manifestChanged() bool {
+	published := ""
+	if m := a.state.GetManifest(); m != nil {
+		published = m.SHA256
+	}
+	return manifestHash(a.manifest) != published
+}
+
+// recordManifest records the manifest uploaded with a new version, and
+// announces its CID from then on
+func (a *app) recordManifest(cid string) {
+	if a.manifest == nil {
+		a.state.SetManifest(nil)
+	} else {
+		a.state.SetManifest(&state.PublishedManifest{CID: cid, SHA256: manifestHash(a.manifest)})
+	}
+	if a.announcer != nil {
+		a.announcer.SetManifestCID(cid)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/atregu/ipfs-common/manifest"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+)
+
+func TestManifestPublishedWithIndex(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "song.mp3"), []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &fakeClient{}
+	a := newTestApp(t, dir, client)
+	a.cfg.Collection = config.CollectionConfig{Title: "Field recordings", License: "CC-BY-4.0", Contact: "mailto:ops@example.org"}
+	a.cfg.Pubsub = config.PubsubConfig{Enabled: true, Topic: "mdn/audio/announce", AnnounceInterval: 3600}
+	if a.manifest, err = buildManifest(a.cfg, key); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(client.manifests) != 1 {
+		t.Fatalf("uploaded %d manifests, want 1", len(client.manifests))
+	}
+	m, err := manifest.Parse(client.manifests[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	if err := m.Verify(); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if m.Name != "Field recordings" || m.Topic != "mdn/audio/announce" || m.HeartbeatInterval != 3600 || m.Contact != "mailto:ops@example.org" {
+		t.Errorf("manifest = %+v, want the collection and PubSub settings", m)
+	}
+	if published := a.state.GetManifest(); published == nil || published.CID != "manifest-1" {
+		t.Errorf("recorded manifest = %+v, want manifest-1", published)
+	}
+
+	// The same settings build the same manifest: no new version
+	if a.manifest, err = buildManifest(a.cfg, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v := a.state.GetVersion(); v != 1 {
+		t.Errorf("version after an unchanged scan = %d, want 1", v)
+	}
+
+	// A changed setting publishes the new manifest as a new version
+	a.cfg.Collection.Description = "Birdsong, 2019-2024"
+	if a.manifest, err = buildManifest(a.cfg, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if v := a.state.GetVersion(); v != 2 {
+		t.Errorf("version after changing the description = %d, want 2", v)
+	}
+	if published := a.state.GetManifest(); published == nil || published.CID != "manifest-2" {
+		t.Errorf("recorded manifest = %+v, want manifest-2", published)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/state"
+)
+
+// stageMissing stages the removal of recorded files the last scan did not find,
+// e.g. files deleted while the publisher was stopped. Files below a configured
+// directory that is missing or came back empty are kept, as the directory is
+// more likely unmounted than emptied, and nothing is removed when more than
+// behavior.remove_missing_max_ratio of the recorded files are missing. It
+// returns the number of removals staged.
+func (a *app) stageMissing() int {
+	log := logger.Get()
+	if !a.cfg.Behavior.RemoveMissing {
+		return 0
+	}
+
+	// Directories without any scanned file keep their recorded files
+	unavailable := a.unavailableDirs()
+
+	tracked := 0
+	var missing []string
+	kept := make(map[string]int)
+	for path, fs := range a.state.GetAllFiles() {
+		// Imported files have no local file and are never scanned
+		if fs.Imported {
+			continue
+		}
+		tracked++
+		if _, ok := a.scanned[path]; ok {
+			continue
+		}
+		if staged, ok := a.state.GetStagedFile(path); ok && staged == nil {
+			continue
+		}
+
+		if dir := a.configuredDir(path); unavailable[dir] {
+			kept[dir]++
+			continue
+		}
+		missing = append(missing, path)
+	}
+
+	for dir, count := range kept {
+		log.Warnf("Directory %s is missing or empty; keeping its %d recorded files in case it is not mounted", dir, count)
+	}
+	if len(missing) == 0 {
+		return 0
+	}
+
+	maxRatio := a.cfg.Behavior.RemoveMissingMaxRatio
+	if float64(len(missing)) > maxRatio*float64(tracked) {
+		log.Warnf("%d of %d recorded files are missing, more than behavior.remove_missing_max_ratio (%.0f%%); not removing any. "+
+			"Check that every directory is mounted, or raise the ratio to remove them", len(missing), tracked, maxRatio*100)
+		return 0
+	}
+
+	sort.Strings(missing)
+	for _, path := range missing {
+		a.state.StageDelete(path)
+		log.Infof("Staged removal of missing file: %s", path)
+	}
+	log.Infof("%d recorded files were not found by the scan and will be removed from the index", len(missing))
+	return len(missing)
+}
+
+// unavailableDirs returns the configured directories that are missing or in
+// which the last scan found no file, as they are more likely unmounted than emptied
+func (a *app) unavailableDirs() map[string]bool {
+	unavailable := make(map[string]bool)
+	for _, dir := range a.cfg.Directories {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() || !a.scannedBelow(dir) {
+			unavailable[dir] = true
+		}
+	}
+	return unavailable
+}
+
+// scannedBelow reports whether the last scan found a file below dir
+func (a *app) scannedBelow(dir string) bool {
+	for path := range a.scanned {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// configuredDir returns the configured directory path is below, or "" if none is
+func (a *app) configuredDir(path string) string {
+	for _, dir := range a.cfg.Directories {
+		if strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return dir
+		}
+	}
+	return ""
+}
+
+// removedCIDs returns the CIDs of the committed files a change set removes. It
+// is called before the change set is committed.
+func (a *app) removedCIDs(changes map[string]*state.FileState) []string {
+	var cids []string
+	for path, fs := range changes {
+		if fs != nil {
+			continue
+		}
+		if old, ok := a.state.GetFile(path); ok && old.CID != "" {
+			cids = append(cids, old.CID)
+		}
+	}
+	return cids
+}
+
+// unpinRemoved unpins the CIDs of removed files with behavior.unpin_removed,
+// unless a recorded file still has the same content. Failures only warn: the
+// files are already gone from the index.
+func (a *app) unpinRemoved(ctx context.Context, cids []string) {
+	log := logger.Get()
+	if !a.cfg.Behavior.UnpinRemoved || len(cids) == 0 {
+		return
+	}
+
+	inUse := make(map[string]bool)
+	for _, fs := range a.state.GetAllFiles() {
+		inUse[fs.CID] = true
+	}
+	for _, fs := range a.state.GetStaged() {
+		if fs != nil {
+			inUse[fs.CID] = true
+		}
+	}
+
+	unpinned := 0
+	for _, cid := range cids {
+		if inUse[cid] {
+			continue
+		}
+		inUse[cid] = true // Unpin each CID once
+
+		if err := a.client.Unpin(ctx, cid); err != nil {
+			log.Warnf("Failed to unpin %s of a removed file: %v", cid, err)
+			continue
+		}
+		if a.dedupe != nil {
+			a.dedupe.forget(cid)
+		}
+		unpinned++
+	}
+	if unpinned > 0 {
+		log.Infof("Unpinned %d CIDs of removed files", unpinned)
+	}
+}
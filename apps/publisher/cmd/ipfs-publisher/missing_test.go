@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// newMissingTestApp returns an app that removes missing files and has published
+// the given files of dir
+func newMissingTestApp(t *testing.T, dir string, client *fakeClient, names ...string) *app {
+	t.Helper()
+
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a := newTestApp(t, dir, client)
+	a.cfg.Directories = []string{dir}
+	a.cfg.Behavior.RemoveMissing = true
+	a.cfg.Behavior.RemoveMissingMaxRatio = 0.5
+	a.cfg.Behavior.UnpinRemoved = true
+	if err := a.runScan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestMissingFileRemovedOnRescan(t *testing.T) {
+	dir := t.TempDir()
+	client := &fakeClient{}
+	a := newMissingTestApp(t, dir, client, "a.mp3", "b.mp3", "c.mp3")
+
+	if err := os.Remove(filepath.Join(dir, "a.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.runScan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := a.index.Get("a.mp3"); ok {
+		t.Error("missing file still in the index")
+	}
+	if _, ok := a.state.GetFile(filepath.Join(dir, "a.mp3")); ok {
+		t.Error("missing file still in the state")
+	}
+	if _, ok := a.index.Get("b.mp3"); !ok {
+		t.Error("remaining file removed from the index")
+	}
+	if v := a.state.GetVersion(); v != 2 {
+		t.Errorf("version = %d, want 2", v)
+	}
+	if want := []string{"cid-a.mp3"}; !reflect.DeepEqual(client.unpinned, want) {
+		t.Errorf("unpinned %v, want %v", client.unpinned, want)
+	}
+}
+
+func TestMissingFilesKeptWhenTooManyVanish(t *testing.T) {
+	tests := map[string][]string{
+		"empty directory":       {"a.mp3", "b.mp3", "c.mp3"},
+		"more than the maximum": {"a.mp3", "b.mp3"},
+	}
+
+	for name, removed := range tests {
+		dir := t.TempDir()
+		client := &fakeClient{}
+		a := newMissingTestApp(t, dir, client, "a.mp3", "b.mp3", "c.mp3")
+
+		for _, file := range removed {
+			if err := os.Remove(filepath.Join(dir, file)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := a.runScan(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if n := a.index.Count(); n != 3 {
+			t.Errorf("%s: %d files in the index, want all 3 kept", name, n)
+		}
+		if v := a.state.GetVersion(); v != 1 {
+			t.Errorf("%s: version = %d, want 1", name, v)
+		}
+		if len(client.unpinned) != 0 {
+			t.Errorf("%s: unpinned %v", name, client.unpinned)
+		}
+	}
+}
+
+func TestMissingFileKeepsSameNameInOtherDirectory(t *testing.T) {
+	dir := t.TempDir()
+	for _, season := range []string{"Season 1", "Season 2"} {
+		if err := os.Mkdir(filepath.Join(dir, season), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, season, "episode01.mp3"), []byte(season), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client := &fakeClient{}
+	a := newMissingTestApp(t, dir, client, "a.mp3", "b.mp3")
+
+	if a.index.Count() != 4 {
+		t.Fatalf("index has %d records, want 4: files with the same name collided", a.index.Count())
+	}
+
+	if err := os.Remove(filepath.Join(dir, "Season 1", "episode01.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.runScan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := a.index.Get("Season 1/episode01.mp3"); ok {
+		t.Error("missing file still in the index")
+	}
+	if record, ok := a.index.Get("Season 2/episode01.mp3"); !ok || record.CID != "cid-Season 2" {
+		t.Errorf("file with the same name in another directory = %+v, want it kept", record)
+	}
+}
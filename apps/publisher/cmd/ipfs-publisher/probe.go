@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/ipfs"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/state"
+)
+
+// defaultProbeSample is the number of published CIDs probe looks up without --sample or --cid
+const defaultProbeSample = 20
+
+// runProbe looks up providers of sample randomly chosen published CIDs (0 = all),
+// or of the given CIDs, and prints those that no peer besides this node provides
+func runProbe(cfg *config.Config, sample int, cids []string, reprovide bool) error {
+	targets := make(map[string]string, len(cids))
+	for _, cid := range cids {
+		targets[cid] = cid
+	}
+	if len(targets) == 0 {
+		stateManager := state.New(cfg.StatePath())
+		if err := stateManager.Load(); err != nil {
+			return fmt.Errorf("failed to load state: %w", err)
+		}
+		targets = ipfs.SampleCIDs(stateManager.GetAllFiles(), sample)
+		if len(targets) == 0 {
+			fmt.Println("No published files to probe")
+			return nil
+		}
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	prober, ok := client.(ipfs.Prober)
+	if !ok {
+		return fmt.Errorf("the IPFS client cannot look up providers")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := client.IsAvailable(ctx); err != nil {
+		return fmt.Errorf("IPFS node is not available: %w", err)
+	}
+
+	fmt.Printf("Looking up providers of %d CIDs (up to %v each)...\n", len(targets), ipfs.ProbeTimeout)
+	report, err := ipfs.ProbeProviders(ctx, prober, targets, ipfs.ProbeTimeout, reprovide)
+	if err != nil {
+		return err
+	}
+
+	printProviderReport(report, reprovide)
+	if len(report.Unreachable) > 0 {
+		return fmt.Errorf("%d CIDs have no provider besides this node", len(report.Unreachable))
+	}
+	return nil
+}
+
+// printProviderReport prints the unreachable CIDs of a provider probe and a summary
+func printProviderReport(report *ipfs.ProviderReport, reprovided bool) {
+	paths := make([]string, 0, len(report.Unreachable))
+	for path := range report.Unreachable {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		if cid := report.Unreachable[path]; cid != path {
+			fmt.Printf("  [unreachable] %s (%s)\n", path, cid)
+		} else {
+			fmt.Printf("  [unreachable] %s\n", cid)
+		}
+	}
+
+	fmt.Printf("\n%d of %d CIDs have a provider besides this node\n", report.OK, report.Checked)
+	switch {
+	case reprovided:
+		fmt.Printf("Re-provided %d CIDs, %d failed\n", report.Reprovided, report.Failed)
+	case len(report.Unreachable) > 0:
+		fmt.Println("Run ipfs-publisher probe --reprovide to announce them again")
+	}
+}
+
+// probeProviders looks up providers of behavior.probe_sample published CIDs in the
+// background, logs the result and updates the provider gauges. A probe still
+// running is not started again.
+func (a *app) probeProviders(ctx context.Context) {
+	if a.cfg.Behavior.ProbeSample == 0 {
+		return
+	}
+	prober, ok := a.client.(ipfs.Prober)
+	if !ok || !a.probing.CompareAndSwap(false, true) {
+		return
+	}
+
+	// The sample is taken here, as the state changes while the probe runs
+	targets := ipfs.SampleCIDs(a.state.GetAllFiles(), a.cfg.Behavior.ProbeSample)
+	if len(targets) == 0 {
+		a.probing.Store(false)
+		return
+	}
+
+	go func() {
+		defer a.probing.Store(false)
+
+		log := logger.Get()
+		report, err := ipfs.ProbeProviders(ctx, prober, targets, ipfs.ProbeTimeout, false)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Warnf("Provider probe failed: %v", err)
+			}
+			return
+		}
+
+		a.providers.Probed(report.Checked, report.OK)
+		if len(report.Unreachable) > 0 {
+			log.Warnf("Provider probe: only %d of %d sampled CIDs have a provider besides this node; run ipfs-publisher probe --reprovide",
+				report.OK, report.Checked)
+			return
+		}
+		log.Infof("Provider probe: all %d sampled CIDs have a provider besides this node", report.Checked)
+	}()
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/scanner"
+	"github.com/atregu/ipfs-publisher/internal/state"
+)
+
+// renameKey groups recorded files by the size and mtime a rename keeps
+type renameKey struct {
+	size    int64
+	modTime int64
+}
+
+// stageRenames finds the new files among pending that are recorded files
+// renamed or moved since: a recorded file the scan no longer found with the same
+// size and mtime and, if its hash was recorded, the same content. Each is staged
+// under its new path with the recorded CID and the old path is staged for
+// removal, so the content is not added again while the next version carries
+// the new name. Files below a directory that seems unmounted are not matched.
+// It returns the files still to upload and the number of renames staged.
+func (a *app) stageRenames(pending []scanner.FileInfo) ([]scanner.FileInfo, int) {
+	vanished := a.vanishedFiles()
+	if len(vanished) == 0 {
+		return pending, 0
+	}
+	log := logger.Get()
+
+	remaining := pending[:0:0]
+	renamed := 0
+	for i := range pending {
+		file := &pending[i]
+		oldPath, fs := a.matchRename(file, vanished)
+		if fs == nil {
+			remaining = append(remaining, *file)
+			continue
+		}
+
+		a.state.StageDelete(oldPath)
+		a.state.StageFile(file.Path, fs)
+		renamed++
+		log.Infof("Renamed %s to %s; keeping %s without uploading it again", oldPath, file.Path, fs.CID)
+	}
+	return remaining, renamed
+}
+
+// vanishedFiles returns the recorded files the last scan did not find, grouped
+// by size and mtime, in path order. Staged changes take precedence over the
+// published state; a staged removal, e.g. of a file the watcher saw renamed,
+// still leaves its published state to match.
+func (a *app) vanishedFiles() map[renameKey][]string {
+	recorded := a.state.GetAllFiles()
+	for path, fs := range a.state.GetStaged() {
+		if fs != nil {
+			recorded[path] = fs
+		}
+	}
+
+	unavailable := a.unavailableDirs()
+	var paths []string
+	for path, fs := range recorded {
+		// Imported files have no local file and are never scanned
+		if fs.Imported {
+			continue
+		}
+		if _, ok := a.scanned[path]; ok || unavailable[a.configuredDir(path)] {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	vanished := make(map[renameKey][]string)
+	for _, path := range paths {
+		key := renameKey{recorded[path].Size, recorded[path].ModTime}
+		vanished[key] = append(vanished[key], path)
+	}
+	return vanished
+}
+
+// matchRename returns the vanished file that file is a rename of and its
+// state under the new path, and removes it from vanished. Files with a recorded
+// hash match by content; of files recorded without one, only a single candidate
+// with the same size and mtime is taken. It returns a nil state if there is no
+// match.
+func (a *app) matchRename(file *scanner.FileInfo, vanished map[renameKey][]string) (string, *state.FileState) {
+	// Known files are changed, not renamed
+	if recorded, _ := a.recordedFile(file.Path); recorded != nil {
+		return "", nil
+	}
+	key := renameKey{file.Size, file.ModTime}
+	candidates := vanished[key]
+	if len(candidates) == 0 {
+		return "", nil
+	}
+
+	hash, err := state.HashFile(file.Path)
+	if err != nil {
+		return "", nil
+	}
+	// The hash is only valid for the scanned size and mtime
+	if err := file.Verify(); err != nil {
+		return "", nil
+	}
+
+	match := -1
+	var unhashed []int
+	for i, path := range candidates {
+		fs := a.vanishedState(path)
+		if fs.SHA256 == hash {
+			match = i
+			break
+		}
+		if fs.SHA256 == "" {
+			unhashed = append(unhashed, i)
+		}
+	}
+	if match < 0 && len(unhashed) == 1 {
+		match = unhashed[0]
+	}
+	if match < 0 {
+		return "", nil
+	}
+
+	oldPath := candidates[match]
+	vanished[key] = append(candidates[:match:match], candidates[match+1:]...)
+
+	fs := *a.vanishedState(oldPath)
+	fs.SHA256 = hash
+	if file.Info != nil {
+		fs.ModTimeNs = file.Info.ModTime().UnixNano()
+	}
+	return oldPath, &fs
+}
+
+// vanishedState returns the recorded state of a vanished file: its staged
+// state unless that is a removal, otherwise its published state
+func (a *app) vanishedState(path string) *state.FileState {
+	if fs, ok := a.state.GetStagedFile(path); ok && fs != nil {
+		return fs
+	}
+	fs, _ := a.state.GetFile(path)
+	return fs
+}
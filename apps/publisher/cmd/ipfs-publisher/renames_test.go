@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRenamedFilesKeepTheirCID(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{"01 - Track.mp3": "alpha", "b.mp3": "beta"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &fakeClient{}
+	a := newTestApp(t, dir, client)
+	a.cfg.Behavior.UnpinRemoved = true
+	ctx := context.Background()
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Renamed in place and moved to a subdirectory under the same name
+	if err := os.Rename(filepath.Join(dir, "01 - Track.mp3"), filepath.Join(dir, "01 Track.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "b.mp3"), filepath.Join(dir, "sub", "b.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	summary, err := a.scan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if client.adds != 2 {
+		t.Errorf("adds = %d, want 2: renamed files were uploaded again", client.adds)
+	}
+	if summary.renamed != 2 || summary.uploaded != 0 {
+		t.Errorf("summary = %+v, want 2 renamed and nothing uploaded", *summary)
+	}
+	if _, ok := a.index.Get("01 - Track.mp3"); ok {
+		t.Error("old name still in the index")
+	}
+	if record, ok := a.index.Get("01 Track.mp3"); !ok || record.CID != "cid-alpha" {
+		t.Errorf("renamed record = %+v, want CID cid-alpha", record)
+	}
+	if _, ok := a.index.Get("b.mp3"); ok {
+		t.Error("old path of the moved file still in the index")
+	}
+	if record, ok := a.index.Get("sub/b.mp3"); !ok || record.CID != "cid-beta" || record.Group != "sub" {
+		t.Errorf("moved record = %+v, want CID cid-beta in group sub", record)
+	}
+	if _, ok := a.state.GetFile(filepath.Join(dir, "b.mp3")); ok {
+		t.Error("old path still in the state")
+	}
+	if fs, ok := a.state.GetFile(filepath.Join(dir, "sub", "b.mp3")); !ok || fs.CID != "cid-beta" || fs.SHA256 == "" {
+		t.Errorf("state of the moved file = %+v, want CID cid-beta with its hash", fs)
+	}
+	if v := a.state.GetVersion(); v != 2 {
+		t.Errorf("version = %d, want 2", v)
+	}
+	if len(client.unpinned) != 0 {
+		t.Errorf("unpinned %v, want the content of renamed files kept", client.unpinned)
+	}
+	if pending := scanPending(t, a); len(pending) != 0 {
+		t.Errorf("%d pending files after the renames, want 0", len(pending))
+	}
+}
+
+func TestRenameNeedsSameContent(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "old.mp3")
+	if err := os.WriteFile(old, []byte("gamma"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &fakeClient{}
+	a := newTestApp(t, dir, client)
+	ctx := context.Background()
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A copy leaves the original in place, so it is not a rename
+	if err := os.WriteFile(filepath.Join(dir, "copy.mp3"), []byte("gamma"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Unix(a.scanned[old].ModTime, 0)
+	if err := os.Chtimes(filepath.Join(dir, "copy.mp3"), mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if client.adds != 2 {
+		t.Errorf("adds after a copy = %d, want 2", client.adds)
+	}
+	if _, ok := a.index.Get("old.mp3"); !ok {
+		t.Error("original of the copy removed from the index")
+	}
+
+	// Another file of the same size and mtime replacing a vanished one is uploaded
+	if err := os.Remove(old); err != nil {
+		t.Fatal(err)
+	}
+	other := filepath.Join(dir, "other.mp3")
+	if err := os.WriteFile(other, []byte("delta"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(other, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if client.adds != 3 {
+		t.Errorf("adds after a different file replaced the vanished one = %d, want 3", client.adds)
+	}
+	if record, ok := a.index.Get("other.mp3"); !ok || record.CID != "cid-delta" {
+		t.Errorf("record = %+v, want CID cid-delta", record)
+	}
+}
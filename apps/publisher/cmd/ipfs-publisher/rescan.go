@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// scanSummary counts what a scan did with the files it found
+type scanSummary struct {
+	scanned  int // Media files found
+	uploaded int
+	skipped  int // Unchanged files and files left for a later scan
+	failed   int
+	removed  int // Recorded files no longer found, staged for removal
+	renamed  int // Recorded files found under a new path, staged without uploading
+
+	tooLarge   int // Files skipped for exceeding behavior.max_file_size, also counted as skipped
+	mismatched int // Files whose content does not match their extension, see behavior.content_check
+}
+
+// add adds the uploads, failures and removals of a later scan, whose file
+// counts and check results replace those of s
+func (s *scanSummary) add(later *scanSummary) {
+	s.scanned, s.skipped = later.scanned, later.skipped
+	s.tooLarge, s.mismatched = later.tooLarge, later.mismatched
+	s.uploaded += later.uploaded
+	s.failed += later.failed
+	s.removed += later.removed
+	s.renamed += later.renamed
+}
+
+// changed reports whether the scan uploaded, removed, renamed or failed to upload any file
+func (s *scanSummary) changed() bool {
+	return s.uploaded > 0 || s.failed > 0 || s.removed > 0 || s.renamed > 0
+}
+
+// rescanScheduler requests a full rescan every behavior.scan_interval. Rescans
+// catch changes the watcher missed, e.g. on filesystems that deliver no events.
+type rescanScheduler struct {
+	interval time.Duration
+	due      chan struct{}
+	busy     atomic.Bool // A requested rescan is queued or running
+	skipped  int         // Cycles skipped since the last requested rescan
+}
+
+// newRescanScheduler creates a scheduler requesting a rescan every interval
+func newRescanScheduler(interval time.Duration) *rescanScheduler {
+	return &rescanScheduler{interval: interval, due: make(chan struct{}, 1)}
+}
+
+// run requests rescans until ctx is done. A cycle is skipped while the previous
+// rescan is still queued or running, so slow scans never pile up.
+func (s *rescanScheduler) run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !s.busy.CompareAndSwap(false, true) {
+				s.skipped++
+				logger.Get().Debugf("Skipping rescan: the previous one is still running (%d cycles skipped)", s.skipped)
+				continue
+			}
+			s.skipped = 0
+			s.due <- struct{}{}
+		}
+	}
+}
+
+// Due delivers the requested rescans; done must be called after each
+func (s *rescanScheduler) Due() <-chan struct{} {
+	return s.due
+}
+
+// done marks the requested rescan as finished
+func (s *rescanScheduler) done() {
+	s.busy.Store(false)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRescanSchedulerSkipsWhileBusy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := newRescanScheduler(10 * time.Millisecond)
+	go s.run(ctx)
+
+	select {
+	case <-s.Due():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no rescan requested")
+	}
+
+	// Ticks while the rescan runs request nothing
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-s.Due():
+		t.Fatal("rescan requested while the previous one was running")
+	default:
+	}
+
+	s.done()
+	select {
+	case <-s.Due():
+	case <-time.After(5 * time.Second):
+		t.Fatal("no rescan requested after the previous one was done")
+	}
+}
+
+func TestRescanSummary(t *testing.T) {
+	dir := t.TempDir()
+	client := &fakeClient{}
+	a := newMissingTestApp(t, dir, client, "a.mp3", "b.mp3", "c.mp3")
+	ctx := context.Background()
+
+	// Nothing changed: nothing is uploaded or published
+	summary, err := a.scan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (scanSummary{scanned: 3, skipped: 3}); *summary != want || summary.changed() {
+		t.Errorf("summary = %+v, want %+v", *summary, want)
+	}
+	if client.publishes != 1 {
+		t.Errorf("publishes after an unchanged rescan = %d, want 1", client.publishes)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "d.mp3"), []byte("d"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "a.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	summary, err = a.scan(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (scanSummary{scanned: 3, uploaded: 1, skipped: 2, removed: 1}); *summary != want || !summary.changed() {
+		t.Errorf("summary = %+v, want %+v", *summary, want)
+	}
+	if client.publishes != 2 || a.state.GetVersion() != 2 {
+		t.Errorf("%d publishes, version %d after a change, want 2 and 2", client.publishes, a.state.GetVersion())
+	}
+}
+
+func TestScanStopsAfterUploadInProgress(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.mp3", "b.mp3", "c.mp3"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &fakeClient{}
+	a := newTestApp(t, dir, client)
+	a.stopping = make(chan struct{})
+	// The shutdown signal arrives while the first file is added
+	client.duringAdd = func() {
+		if !a.stopRequested() {
+			close(a.stopping)
+		}
+	}
+
+	if _, err := a.scan(context.Background()); !errors.Is(err, errStopping) {
+		t.Fatalf("scan error = %v, want errStopping", err)
+	}
+	if client.adds != 1 {
+		t.Errorf("%d adds, want only the file in progress", client.adds)
+	}
+	if n := a.state.StagedCount(); n != 1 {
+		t.Errorf("%d staged files, want the completed upload", n)
+	}
+	if client.publishes != 0 {
+		t.Errorf("published %d times while shutting down", client.publishes)
+	}
+}
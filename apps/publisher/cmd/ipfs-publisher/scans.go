@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+
+	"github.com/atregu/ipfs-common/tracing"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// Scan triggers, recorded in the scan status and report
+const (
+	triggerStartup = "startup"     // Initial scan
+	triggerWatcher = "watcher"     // Watcher events
+	triggerRescan  = "rescan"      // Periodic rescan every behavior.scan_interval
+	triggerResume  = "resume"      // Paused uploads are retried
+	triggerDirs    = "directories" // Directories added or removed through the API
+)
+
+// Scan states served by GET /api/v1/status/scan
+const (
+	scanIdle    = "idle"
+	scanRunning = "running"
+	scanQueued  = "queued" // Running, with a follow-up scan queued
+)
+
+// scanFunc runs a scan and returns what it did
+type scanFunc func(ctx context.Context) (*scanSummary, error)
+
+// ScanReport describes a finished scan
+type ScanReport struct {
+	Trigger    string    `json:"trigger"`
+	StartedAt  time.Time `json:"started_at"`
+	DurationMs int64     `json:"duration_ms"`
+	Scanned    int       `json:"scanned"`
+	Uploaded   int       `json:"uploaded"`
+	Skipped    int       `json:"skipped"`
+	Failed     int       `json:"failed"`
+	Removed    int       `json:"removed"`
+	Renamed    int       `json:"renamed"`
+	TooLarge   int       `json:"skipped_too_large"` // Also counted in Skipped
+	Mismatched int       `json:"content_mismatch"`  // Flagged by behavior.content_check, skipped or uploaded
+	Error      string    `json:"error,omitempty"`
+}
+
+// ScanStatus is the state of the scan coordinator
+type ScanStatus struct {
+	State         string      `json:"state"`                    // idle, running or queued
+	Trigger       string      `json:"trigger,omitempty"`        // What started the running scan
+	StartedAt     *time.Time  `json:"started_at,omitempty"`     // When the running scan started
+	QueuedTrigger string      `json:"queued_trigger,omitempty"` // First trigger coalesced into the follow-up
+	Coalesced     int         `json:"coalesced"`                // Triggers coalesced into the follow-up
+	Last          *ScanReport `json:"last,omitempty"`
+}
+
+// scanCoordinator runs one scan at a time. The state and index managers are not
+// safe for concurrent scans, so a trigger arriving while a scan runs does not
+// start another: overlapping triggers coalesce into a single follow-up scan,
+// run once the current one is done.
+type scanCoordinator struct {
+	followUp scanFunc // Full scan run for the queued follow-up
+
+	mu        sync.Mutex
+	running   bool
+	trigger   string
+	startedAt time.Time
+	queued    string // Trigger of the follow-up; empty if none is queued
+	coalesced int
+	last      *ScanReport
+}
+
+// newScanCoordinator creates a coordinator that runs followUp for queued scans
+func newScanCoordinator(followUp scanFunc) *scanCoordinator {
+	return &scanCoordinator{followUp: followUp}
+}
+
+// Run runs scan for trigger, then the queued follow-up if other triggers came
+// in meanwhile. If a scan is already running, it queues the follow-up and
+// returns nil right away; the follow-up is a full scan, which covers whatever
+// the trigger's own scan would have done. A failed scan drops the follow-up,
+// as the next trigger scans again, and its error is returned.
+func (c *scanCoordinator) Run(ctx context.Context, trigger string, scan scanFunc) error {
+	c.mu.Lock()
+	if c.running {
+		if c.queued == "" {
+			c.queued = trigger
+		}
+		c.coalesced++
+		c.mu.Unlock()
+		return nil
+	}
+	c.running = true
+	c.mu.Unlock()
+
+	for {
+		err := c.runOne(ctx, trigger, scan)
+
+		c.mu.Lock()
+		trigger, c.queued, c.coalesced = c.queued, "", 0
+		if err != nil || trigger == "" {
+			c.running = false
+			c.mu.Unlock()
+			return err
+		}
+		c.mu.Unlock()
+
+		logger.Get().Debugf("Running the scan queued by %s", trigger)
+		scan = c.followUp
+	}
+}
+
+// runOne runs a scan and records and logs its report
+func (c *scanCoordinator) runOne(ctx context.Context, trigger string, scan scanFunc) error {
+	started := time.Now()
+	c.mu.Lock()
+	c.trigger, c.startedAt = trigger, started
+	c.mu.Unlock()
+
+	ctx, span := tracing.Start(ctx, "scan", attribute.String("scan.trigger", trigger))
+	summary, err := scan(ctx)
+	if summary != nil {
+		span.SetAttributes(attribute.Int("scan.uploaded", summary.uploaded), attribute.Int("scan.removed", summary.removed))
+	}
+	tracing.End(span, err)
+
+	report := &ScanReport{Trigger: trigger, StartedAt: started, DurationMs: time.Since(started).Milliseconds()}
+	if summary != nil {
+		report.Scanned, report.Uploaded, report.Skipped = summary.scanned, summary.uploaded, summary.skipped
+		report.Failed, report.Removed, report.Renamed = summary.failed, summary.removed, summary.renamed
+		report.TooLarge, report.Mismatched = summary.tooLarge, summary.mismatched
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
+	c.mu.Lock()
+	c.last = report
+	c.mu.Unlock()
+
+	// Periodic rescans mostly find nothing; those are only logged at debug level
+	if summary != nil {
+		log := logger.Get()
+		logf := log.Debugf
+		if summary.changed() || trigger != triggerRescan {
+			logf = log.Infof
+		}
+		logf("Scan (%s): %d scanned, %d uploaded, %d skipped, %d failed, %d removed, %d renamed in %s", trigger,
+			summary.scanned, summary.uploaded, summary.skipped, summary.failed, summary.removed, summary.renamed, time.Since(started).Round(time.Millisecond))
+	}
+	return err
+}
+
+// Status returns the current state and the report of the last scan
+func (c *scanCoordinator) Status() ScanStatus {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := ScanStatus{State: scanIdle, Last: c.last}
+	if c.running {
+		startedAt := c.startedAt
+		status.State, status.Trigger, status.StartedAt = scanRunning, c.trigger, &startedAt
+	}
+	if c.queued != "" {
+		status.State, status.QueuedTrigger, status.Coalesced = scanQueued, c.queued, c.coalesced
+	}
+	return status
+}
+
+// Handler serves Status as JSON at GET /api/v1/status/scan
+func (c *scanCoordinator) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(c.Status())
+	})
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// blockingScans counts scans and their concurrency; each scan blocks until released
+type blockingScans struct {
+	started  chan string // Receives the trigger of each scan as it starts
+	release  chan struct{}
+	active   atomic.Int32
+	maxSeen  atomic.Int32
+	finished atomic.Int32
+}
+
+func newBlockingScans() *blockingScans {
+	return &blockingScans{started: make(chan string, 10), release: make(chan struct{})}
+}
+
+// scan returns a scanFunc recording trigger
+func (b *blockingScans) scan(trigger string) scanFunc {
+	return func(ctx context.Context) (*scanSummary, error) {
+		n := b.active.Add(1)
+		for {
+			seen := b.maxSeen.Load()
+			if n <= seen || b.maxSeen.CompareAndSwap(seen, n) {
+				break
+			}
+		}
+		b.started <- trigger
+		<-b.release
+		b.active.Add(-1)
+		b.finished.Add(1)
+		return &scanSummary{scanned: 1, skipped: 1}, nil
+	}
+}
+
+func TestScanCoordinatorCoalescesTriggers(t *testing.T) {
+	scans := newBlockingScans()
+	c := newScanCoordinator(scans.scan("follow-up"))
+	ctx := context.Background()
+
+	// The first trigger starts a scan
+	first := make(chan error, 1)
+	go func() { first <- c.Run(ctx, triggerWatcher, scans.scan(triggerWatcher)) }()
+	if got := <-scans.started; got != triggerWatcher {
+		t.Fatalf("first scan by %q, want %q", got, triggerWatcher)
+	}
+
+	// Simultaneous triggers return at once, queueing one follow-up
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			trigger := triggerRescan
+			if i%2 == 1 {
+				trigger = triggerResume
+			}
+			if err := c.Run(ctx, trigger, scans.scan(trigger)); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	status := c.Status()
+	if status.State != scanQueued || status.Trigger != triggerWatcher || status.Coalesced != 20 || status.StartedAt == nil {
+		t.Errorf("status while running = %+v, want queued behind the watcher scan with 20 coalesced triggers", status)
+	}
+	if status.QueuedTrigger != triggerRescan && status.QueuedTrigger != triggerResume {
+		t.Errorf("queued trigger = %q", status.QueuedTrigger)
+	}
+	queuedBy := status.QueuedTrigger
+
+	// Once released, exactly one follow-up runs, in the first trigger's call
+	scans.release <- struct{}{}
+	if got := <-scans.started; got != "follow-up" {
+		t.Fatalf("second scan %q, want the follow-up", got)
+	}
+	if status := c.Status(); status.State != scanRunning || status.Trigger != queuedBy {
+		t.Errorf("status of the follow-up = %+v, want running for %q", status, queuedBy)
+	}
+	scans.release <- struct{}{}
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+
+	if n := scans.finished.Load(); n != 2 {
+		t.Errorf("%d scans ran, want the first and one follow-up", n)
+	}
+	if n := scans.maxSeen.Load(); n != 1 {
+		t.Errorf("%d scans ran at once, want 1", n)
+	}
+	status = c.Status()
+	if status.State != scanIdle || status.Last == nil || status.Last.Trigger != queuedBy || status.Last.Scanned != 1 {
+		t.Errorf("status after the scans = %+v, want idle with the follow-up reported", status)
+	}
+}
+
+func TestScanCoordinatorFailureDropsFollowUp(t *testing.T) {
+	followUps := 0
+	c := newScanCoordinator(func(ctx context.Context) (*scanSummary, error) {
+		followUps++
+		return &scanSummary{}, nil
+	})
+
+	failure := errors.New("publish failed")
+	err := c.Run(context.Background(), triggerRescan, func(ctx context.Context) (*scanSummary, error) {
+		// A trigger arriving meanwhile is queued
+		if err := c.Run(context.Background(), triggerWatcher, nil); err != nil {
+			t.Errorf("queued trigger: %v", err)
+		}
+		return nil, failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("Run error = %v, want the scan's", err)
+	}
+	if followUps != 0 {
+		t.Errorf("%d follow-ups ran after a failed scan, want 0", followUps)
+	}
+
+	status := c.Status()
+	if status.State != scanIdle || status.Last == nil || status.Last.Error != failure.Error() {
+		t.Errorf("status = %+v, want idle with the failure reported", status)
+	}
+
+	// The next trigger scans again
+	if err := c.Run(context.Background(), triggerWatcher, c.followUp); err != nil || followUps != 1 {
+		t.Errorf("next scan: %v after %d scans", err, followUps)
+	}
+}
+
+func TestScanStatusHandler(t *testing.T) {
+	c := newScanCoordinator(nil)
+	started := time.Now()
+	if err := c.Run(context.Background(), triggerStartup, func(ctx context.Context) (*scanSummary, error) {
+		return &scanSummary{scanned: 3, uploaded: 2, skipped: 1}, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	c.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/status/scan", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d", rec.Code)
+	}
+	var status ScanStatus
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.State != scanIdle || status.Last == nil || status.Last.Trigger != triggerStartup || status.Last.Uploaded != 2 {
+		t.Errorf("status = %+v", status)
+	}
+	if status.Last.StartedAt.Before(started.Truncate(time.Second)) {
+		t.Errorf("last scan started at %s, before %s", status.Last.StartedAt, started)
+	}
+
+	rec = httptest.NewRecorder()
+	c.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/status/scan", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
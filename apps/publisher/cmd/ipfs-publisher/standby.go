@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/failover"
+	"github.com/atregu/ipfs-publisher/internal/keys"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/state"
+)
+
+// runStandby restores the primary's latest snapshot. With takeover it then
+// claims the collection and runs as the publisher, provided the primary's
+// heartbeat stopped and the collection was not published after the snapshot.
+func runStandby(cfg *config.Config, sync, takeover bool) error {
+	if sync == takeover {
+		return fmt.Errorf("standby needs exactly one of --sync and --takeover")
+	}
+	if !cfg.Failover.Enabled {
+		return fmt.Errorf("standby needs failover.enabled and the primary's failover settings")
+	}
+
+	if err := restoreSnapshot(cfg, takeover); err != nil {
+		return err
+	}
+	if !takeover {
+		return nil
+	}
+	return run(cfg)
+}
+
+// restoreSnapshot replaces the local state and index with the primary's latest
+// snapshot and, with takeover, publishes a manifest of them as this publisher's.
+// It releases the instance lock and the IPFS node before returning, so the
+// publisher can start on them.
+func restoreSnapshot(cfg *config.Config, takeover bool) error {
+	log := logger.Get()
+
+	command := "standby --sync"
+	if takeover {
+		command = "standby --takeover"
+	}
+	lock, err := lockInstance(cfg, command)
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	signingKey, err := loadKey(cfg, keys.DefaultName)
+	if err != nil {
+		return err
+	}
+	stateManager := state.New(cfg.StatePath())
+	if err := stateManager.Load(); err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+	id, err := failover.LoadID(cfg.FailoverIDPath())
+	if err != nil {
+		return err
+	}
+
+	client, err := newClient(cfg)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := client.IsAvailable(ctx); err != nil {
+		return fmt.Errorf("IPFS node is not available: %w", err)
+	}
+
+	node, err := failover.New(client, cfg, signingKey, id)
+	if err != nil {
+		return err
+	}
+	m, err := node.Fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to read the primary's snapshot: %w", err)
+	}
+
+	age := m.Age(time.Now()).Round(time.Second)
+	if m.PublisherID == id {
+		if takeover {
+			// A takeover that stopped after claiming only needs to start publishing
+			log.Infof("This publisher already took over the collection (heartbeat %s ago)", age)
+			return nil
+		}
+		return fmt.Errorf("this publisher wrote the latest snapshot %s ago: it is the primary, not a standby", age)
+	}
+	if m.Version < stateManager.GetVersion() {
+		return fmt.Errorf("the local state has version %d, newer than the snapshot's version %d; not replacing it", stateManager.GetVersion(), m.Version)
+	}
+	if takeover && age < cfg.Failover.Timeout() {
+		return fmt.Errorf("publisher %s wrote a heartbeat %s ago; refusing to take over while it is active (failover.heartbeat_timeout is %s)", m.PublisherID, age, cfg.Failover.Timeout())
+	}
+
+	if err := node.Restore(ctx, m, cfg.StatePath(), cfg.IndexPath()); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Restored version %d from publisher %s, snapshot written %s ago\n", m.Version, m.PublisherID, age)
+	if !takeover {
+		return nil
+	}
+
+	// A publish after the last snapshot would be published again with the same version
+	if restored := state.New(cfg.StatePath()); restored.Load() == nil && restored.GetIPNS() != "" && m.RootCID != "" {
+		root, err := client.ResolveIPNS(ctx, restored.GetIPNS())
+		if err != nil {
+			log.Warnf("Could not resolve /ipns/%s to compare it with the snapshot: %v", restored.GetIPNS(), err)
+		} else if root = strings.TrimPrefix(root, "/ipfs/"); root != m.RootCID {
+			return fmt.Errorf("/ipns/%s points at %s, not at the snapshot's root %s: the primary published after its last snapshot; refusing to take over with an older version", restored.GetIPNS(), root, m.RootCID)
+		}
+	}
+
+	if err := node.Claim(ctx, cfg.StatePath(), cfg.IndexPath()); err != nil {
+		return fmt.Errorf("failed to claim the collection: %w", err)
+	}
+	fmt.Printf("✓ Took over the collection from publisher %s as publisher %s\n", m.PublisherID, id)
+	return nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/atregu/ipfs-common/manifest"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/index"
+	"github.com/atregu/ipfs-publisher/internal/ipfs"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/state"
+)
+
+// treeDir is the MFS directory the collection tree is kept in between
+// publishes. MFS is safe from garbage collection, so the directories of the
+// tree need no pin of their own.
+const treeDir = "/.ipfs-publisher/tree"
+
+// treeEntries returns the entries of the collection tree: the CID of every
+// index record at its path, and the index and the manifest at the root
+func treeEntries(idx *index.Manager, indexCID, manifestCID string) map[string]string {
+	entries := idx.CIDs()
+	for _, name := range []string{ipfs.IndexFileName, manifest.FileName} {
+		if _, taken := entries[name]; taken {
+			logger.Get().Warnf("%s at the root of the collection is left out of the collection tree, which holds the index there", name)
+			delete(entries, name)
+		}
+	}
+	entries[ipfs.IndexFileName] = indexCID
+	if manifestCID != "" {
+		entries[manifest.FileName] = manifestCID
+	}
+	return entries
+}
+
+// buildTree brings the collection tree in MFS up to date with the index and
+// the manifest of a new version and returns its root CID. Only the paths whose
+// CID changed since the tree was last built are relinked. A tree that is missing
+// or was changed by someone else, e.g. on the node of a publisher that took
+// over, is built again from scratch.
+func (a *app) buildTree(ctx context.Context, indexCID, manifestCID string) (string, error) {
+	log := logger.Get()
+
+	builder, ok := a.client.(ipfs.TreeBuilder)
+	if !ok {
+		return "", fmt.Errorf("the IPFS client cannot build directory trees")
+	}
+
+	entries := treeEntries(a.index, indexCID, manifestCID)
+	current, err := builder.TreeRoot(ctx, treeDir)
+	if err != nil {
+		return "", err
+	}
+
+	var changes ipfs.TreeChanges
+	if last := a.state.GetTree(); last != nil && last.CID == current {
+		changes = ipfs.DiffTree(last.Entries, entries)
+	} else {
+		if current != "" {
+			log.Warnf("Collection tree %s is not the one last built, building it again", treeDir)
+			if err := builder.RemoveTree(ctx, treeDir); err != nil {
+				return "", err
+			}
+		}
+		log.Infof("Building the collection tree of %d files in %s", len(entries), treeDir)
+		changes = ipfs.DiffTree(nil, entries)
+	}
+
+	root, err := builder.UpdateTree(ctx, treeDir, changes)
+	if err != nil {
+		return "", fmt.Errorf("failed to update collection tree: %w", err)
+	}
+	// The tree is recorded as built even if the version is never published, so
+	// the next one continues from it
+	a.state.SetTree(&state.CollectionTree{CID: root, Entries: entries})
+
+	log.Infof("✓ Updated collection tree (%d paths linked, %d removed): %s", len(changes.Link), len(changes.Unlink), root)
+	return root, nil
+}
+
+// removeTree removes the collection tree once IPNS no longer points at it
+func (a *app) removeTree(ctx context.Context) {
+	if builder, ok := a.client.(ipfs.TreeBuilder); ok {
+		if err := builder.RemoveTree(ctx, treeDir); err != nil {
+			logger.Get().Warnf("Failed to remove the collection tree: %v", err)
+			return
+		}
+	}
+	a.state.SetTree(nil)
+}
+
+// layoutChanged reports whether publish.layout switched between the index
+// directory and the collection tree since the last publish
+func (a *app) layoutChanged() bool {
+	return a.state.GetLastRootCID() != "" && a.cfg.Publish.Tree() != (a.state.GetIndexRootCID() != "")
+}
+
+// announcedRoot returns the root announced to indexers, which pin it. That is
+// the collection tree with publish.layout directory, so indexers keep a copy
+// of the files, and the directory of the index otherwise.
+func announcedRoot(cfg *config.PublishConfig, st *state.Manager) string {
+	if indexRoot := st.GetIndexRootCID(); indexRoot != "" && cfg.Layout != config.LayoutDirectory {
+		return indexRoot
+	}
+	return st.GetLastRootCID()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/ipfs"
+)
+
+// treeClient is a fakeClient keeping MFS trees as maps of CIDs by path. The
+// CID of a tree is derived from its entries.
+type treeClient struct {
+	*fakeClient
+	trees   map[string]map[string]string
+	updates []ipfs.TreeChanges
+}
+
+func treeCID(entries map[string]string) string {
+	return fmt.Sprintf("tree-%x", sha256.Sum256([]byte(fmt.Sprint(entries))))[:21]
+}
+
+func (c *treeClient) TreeRoot(ctx context.Context, dir string) (string, error) {
+	entries, ok := c.trees[dir]
+	if !ok {
+		return "", nil
+	}
+	return treeCID(entries), nil
+}
+
+func (c *treeClient) UpdateTree(ctx context.Context, dir string, changes ipfs.TreeChanges) (string, error) {
+	c.updates = append(c.updates, changes)
+	entries := maps.Clone(c.trees[dir])
+	if entries == nil {
+		entries = make(map[string]string)
+	}
+	for _, p := range changes.Unlink {
+		delete(entries, p)
+	}
+	maps.Copy(entries, changes.Link)
+	c.trees[dir] = entries
+	return treeCID(entries), nil
+}
+
+func (c *treeClient) RemoveTree(ctx context.Context, dir string) error {
+	delete(c.trees, dir)
+	return nil
+}
+
+func TestCollectionTreeUpdatedIncrementally(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"Album/a.mp3", "Album/b.mp3", "c.mp3"} {
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &treeClient{fakeClient: &fakeClient{}, trees: make(map[string]map[string]string)}
+	a := newTestApp(t, dir, client)
+	a.cfg.Publish.Layout = config.LayoutBoth
+	a.cfg.Directories = []string{dir}
+	a.cfg.Behavior.RemoveMissing = true
+	a.cfg.Behavior.RemoveMissingMaxRatio = 0.5
+	ctx := context.Background()
+
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	tree := client.trees[treeDir]
+	want := map[string]string{"Album/a.mp3": "cid-Album/a.mp3", "Album/b.mp3": "cid-Album/b.mp3", "c.mp3": "cid-c.mp3", "collection.ndjson": "index-3"}
+	if !maps.Equal(tree, want) {
+		t.Fatalf("tree = %v, want %v", tree, want)
+	}
+	if client.published != treeCID(want) || a.state.GetLastRootCID() != client.published {
+		t.Errorf("IPNS points at %s, want the tree %s", client.published, treeCID(want))
+	}
+	// Indexers are announced the directory of the index only
+	if root := announcedRoot(&a.cfg.Publish, a.state); root != "root-3" {
+		t.Errorf("announced root = %s, want root-3", root)
+	}
+
+	// Only the changed paths are relinked
+	if err := os.Remove(filepath.Join(dir, "Album", "b.mp3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "c.mp3"), []byte("new c"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	last := client.updates[len(client.updates)-1]
+	if got := last.LinkPaths(); !slices.Equal(got, []string{"c.mp3", "collection.ndjson"}) || !slices.Equal(last.Unlink, []string{"Album/b.mp3"}) {
+		t.Errorf("second update linked %v and unlinked %v, want c.mp3 and the index, and Album/b.mp3", got, last.Unlink)
+	}
+
+	// A tree changed outside the publisher is built again
+	client.trees[treeDir]["stray.mp3"] = "cid-stray"
+	if err := os.WriteFile(filepath.Join(dir, "d.mp3"), []byte("d.mp3"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, stray := client.trees[treeDir]["stray.mp3"]; stray {
+		t.Error("tree changed outside the publisher was not built again")
+	}
+
+	// Switching back to the index directory publishes it and removes the tree
+	a.cfg.Publish.Layout = config.LayoutNDJSON
+	version := a.state.GetVersion()
+	if err := a.runScan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if a.state.GetVersion() != version+1 || client.published != a.state.GetLastRootCID() || a.state.GetIndexRootCID() != "" {
+		t.Errorf("version %d publishing %s, want version %d publishing the index directory", a.state.GetVersion(), client.published, version+1)
+	}
+	if _, ok := client.trees[treeDir]; ok || a.state.GetTree() != nil {
+		t.Error("collection tree left after switching to the ndjson layout")
+	}
+}
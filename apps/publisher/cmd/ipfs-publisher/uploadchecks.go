@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/scanner"
+	"github.com/atregu/ipfs-publisher/internal/utils"
+)
+
+// checkUploads runs the checks of behavior.max_file_size and
+// behavior.content_check on the files a scan is about to upload, before any of
+// them is opened for adding. Files above the size limit are skipped; files whose
+// first bytes do not match their extension are flagged, and skipped if
+// content_check is "skip". Skipped files stay unrecorded, so every scan checks
+// them again. Each file is warned about once while its size and mtime stay the
+// same; later scans log it at debug level. It returns the files left to upload
+// and the number of files skipped as too large and flagged as mismatched.
+func (a *app) checkUploads(pending []scanner.FileInfo) (kept []scanner.FileInfo, tooLarge, mismatched int) {
+	maxSize := a.cfg.Behavior.MaxFileSize
+	contentCheck := a.cfg.Behavior.ContentCheck
+	if maxSize <= 0 && contentCheck == config.ContentCheckOff {
+		return pending, 0, 0
+	}
+
+	log := logger.Get()
+	// Files no longer pending are forgotten, so they are warned about again if they come back
+	flagged := make(map[string]string)
+	warn := func(file *scanner.FileInfo, reason string) {
+		key := fmt.Sprintf("%d/%d/%s", file.Size, file.ModTime, reason)
+		logf := log.Warnf
+		if a.flagged[file.Path] == key {
+			logf = log.Debugf
+		}
+		flagged[file.Path] = key
+		logf("%s", reason)
+	}
+	defer func() { a.flagged = flagged }()
+
+	kept = make([]scanner.FileInfo, 0, len(pending))
+	for i := range pending {
+		file := &pending[i]
+		if maxSize > 0 && file.Size > maxSize {
+			tooLarge++
+			warn(file, fmt.Sprintf("Skipping %s: its size of %s is above behavior.max_file_size (%s)",
+				file.Path, utils.FormatBytes(file.Size), utils.FormatBytes(maxSize)))
+			continue
+		}
+
+		if contentCheck != config.ContentCheckOff {
+			if detected, ok := file.ContentMismatch(); ok {
+				mismatched++
+				if contentCheck == config.ContentCheckSkip {
+					warn(file, fmt.Sprintf("Skipping %s: its content (%s) does not match the extension %q", file.Path, detected, file.Extension))
+					continue
+				}
+				warn(file, fmt.Sprintf("Uploading %s although its content (%s) does not match the extension %q", file.Path, detected, file.Extension))
+			}
+		}
+		kept = append(kept, *file)
+	}
+	return kept, tooLarge, mismatched
+}
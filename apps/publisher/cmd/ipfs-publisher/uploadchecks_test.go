@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+func TestUploadChecksSkipFiles(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string][]byte{
+		"song.mp3":  append([]byte("ID3\x04\x00\x00\x00\x00\x00\x00"), make([]byte, 64)...),
+		"image.mp3": append([]byte("\xebc\x90MSDOS5.0"), make([]byte, 4096)...),
+		"notes.mp3": []byte("not audio at all"),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	client := &fakeClient{}
+	a := newTestApp(t, dir, client)
+	a.cfg.Behavior.MaxFileSize = 1024
+	a.cfg.Behavior.ContentCheck = config.ContentCheckSkip
+	var logs bytes.Buffer
+	logger.Get().SetOutput(&logs)
+	defer logger.Get().SetOutput(io.Discard)
+
+	summary, err := a.scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.uploaded != 1 || summary.skipped != 2 || summary.tooLarge != 1 || summary.mismatched != 1 {
+		t.Errorf("summary = %+v, want 1 uploaded and 2 skipped, 1 too large and 1 mismatched", *summary)
+	}
+	if len(client.added) != 1 {
+		t.Errorf("added %d files, want only the matching one", len(client.added))
+	}
+	if _, ok := a.index.Get("image.mp3"); ok {
+		t.Error("file above max_file_size added to the index")
+	}
+
+	// Skipped files are checked again, but warned about only once
+	if _, err := a.scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(logs.String(), "image.mp3: its size"); n != 1 {
+		t.Errorf("too large file warned about %d times, want once", n)
+	}
+
+	// In warn mode mismatched files are uploaded
+	a.cfg.Behavior.ContentCheck = config.ContentCheckWarn
+	summary, err = a.scan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.uploaded != 1 || summary.mismatched != 1 {
+		t.Errorf("summary = %+v, want the mismatched file uploaded", *summary)
+	}
+	if _, ok := a.index.Get("notes.mp3"); !ok {
+		t.Error("mismatched file not added in warn mode")
+	}
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// readHeaderTimeout bounds how long a client may take to send request headers
+const readHeaderTimeout = 10 * time.Second
+
+// Server serves the Prometheus metrics and status endpoints of the publisher
+type Server struct {
+	mux      *http.ServeMux
+	registry *prometheus.Registry
+	server   *http.Server
+}
+
+// NewServer creates a server for addr with the metrics of its registry served at /metrics
+func NewServer(addr string) *Server {
+	s := &Server{
+		mux:      http.NewServeMux(),
+		registry: prometheus.NewRegistry(),
+	}
+
+	s.mux.Handle("/metrics", promhttp.HandlerFor(s.registry, promhttp.HandlerOpts{}))
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           s.mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	return s
+}
+
+// NewControlServer creates a server for addr whose routes all require the
+// bearer token. It serves no metrics; routes are added with Handle.
+func NewControlServer(addr, token string) *Server {
+	s := &Server{
+		mux:      http.NewServeMux(),
+		registry: prometheus.NewRegistry(),
+	}
+
+	s.server = &http.Server{
+		Addr:              addr,
+		Handler:           RequireToken(token, s.mux),
+		ReadHeaderTimeout: readHeaderTimeout,
+	}
+
+	return s
+}
+
+// RequireToken answers 401 Unauthorized to requests not carrying token as
+// their bearer token, and passes the others to next
+func RequireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="IPFS Publisher"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Register adds a collector to the metrics served at /metrics
+func (s *Server) Register(c prometheus.Collector) error {
+	if err := s.registry.Register(c); err != nil {
+		return fmt.Errorf("failed to register metrics collector: %w", err)
+	}
+	return nil
+}
+
+// Handle registers the handler for pattern. Handlers may be added after Start.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// Handler returns the handler of the server's requests
+func (s *Server) Handler() http.Handler {
+	return s.server.Handler
+}
+
+// Start listens on the configured address and serves requests in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.server.Addr, err)
+	}
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Get().Errorf("API server stopped: %v", err)
+		}
+	}()
+
+	logger.Get().Infof("API server listening on %s", listener.Addr())
+	return nil
+}
+
+// Stop shuts the server down, waiting for active requests until ctx is done
+func (s *Server) Stop(ctx context.Context) error {
+	if err := s.server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("failed to stop API server: %w", err)
+	}
+	return nil
+}
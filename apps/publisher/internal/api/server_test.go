@@ -0,0 +1,92 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/atregu/ipfs-publisher/internal/metrics"
+)
+
+func TestServerRoutes(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+
+	counter := metrics.NewEventCounter()
+	counter.Inc("CREATE", "mp3")
+	if err := s.Register(counter); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(counter); err == nil {
+		t.Error("expected error registering the same collector twice")
+	}
+
+	s.Handle("/api/v1/ping", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "pong")
+	}))
+
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /metrics: status %d", rec.Code)
+	}
+	if want := `ipfspublisher_watcher_events_total{extension="mp3",type="create"} 1`; !strings.Contains(rec.Body.String(), want) {
+		t.Errorf("GET /metrics missing %q:\n%s", want, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/ping", nil))
+	if rec.Body.String() != "pong" {
+		t.Errorf("GET /api/v1/ping = %q, want pong", rec.Body.String())
+	}
+}
+
+func TestServerStartStop(t *testing.T) {
+	s := NewServer("127.0.0.1:0")
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if err := s.Stop(t.Context()); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+}
+
+func TestControlServerRequiresToken(t *testing.T) {
+	s := NewControlServer("127.0.0.1:0", "secret")
+	s.Handle("/api/v1/ping", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "pong")
+	}))
+
+	for _, auth := range []string{"", "Bearer wrong", "Basic c2VjcmV0", "secret"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/ping", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status %d, want 401", auth, rec.Code)
+		}
+		if challenge := rec.Header().Get("WWW-Authenticate"); challenge != `Bearer realm="IPFS Publisher"` {
+			t.Errorf("Authorization %q: WWW-Authenticate = %q", auth, challenge)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/ping", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK || rec.Body.String() != "pong" {
+		t.Errorf("with the token: status %d %q, want 200 pong", rec.Code, rec.Body.String())
+	}
+
+	// Metrics are left to the status server
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	rec = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /metrics: status %d, want 404", rec.Code)
+	}
+}
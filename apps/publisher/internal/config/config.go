@@ -1,12 +1,27 @@
 package config
 
 import (
+	"encoding/hex"
 	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/go-viper/mapstructure/v2"
+	ma "github.com/multiformats/go-multiaddr"
 	"github.com/spf13/viper"
+	"go.yaml.in/yaml/v3"
+
+	"github.com/atregu/ipfs-common/bootstrap"
+	"github.com/atregu/ipfs-common/configschema"
+	"github.com/atregu/ipfs-common/extensions"
+	"github.com/atregu/ipfs-common/manifest"
+	"github.com/atregu/ipfs-common/topic"
 )
 
 // IPFSMode represents the mode of IPFS operation
@@ -17,82 +32,351 @@ const (
 	IPFSModeEmbedded IPFSMode = "embedded"
 )
 
+// Collection visibility values for collection.visibility
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+)
+
+// Collection layouts for publish.layout
+const (
+	LayoutNDJSON    = "ndjson"    // IPNS points at a directory holding the index and the manifest
+	LayoutDirectory = "directory" // IPNS points at a directory tree of the files, with the index at its root
+	LayoutBoth      = "both"      // As directory, but indexers are still announced the index-only directory
+)
+
+// Upload verification modes for behavior.verify_uploads
+const (
+	VerifyUploadsOff    = "off"
+	VerifyUploadsSample = "sample"
+	VerifyUploadsFull   = "full"
+)
+
+// Pinning strategies for behavior.pin_strategy
+const (
+	PinStrategyInline   = "inline"   // Pin with every add
+	PinStrategyDeferred = "deferred" // Add unpinned, then pin each batch in bulk
+)
+
+// Watch modes for behavior.watch_mode
+const (
+	WatchModeAuto = "auto" // Watch through inotify, polling subtrees once watches run out
+	WatchModePoll = "poll" // Poll every directory every scan_interval
+)
+
+// Content checks for behavior.content_check
+const (
+	ContentCheckOff  = "off"
+	ContentCheckWarn = "warn" // Upload mismatched files with a warning
+	ContentCheckSkip = "skip" // Skip mismatched files with a warning
+)
+
+// Configuration profiles for behavior.profile
+const (
+	ProfileDefault  = "default"
+	ProfileLowPower = "low-power"
+)
+
+// memorySizePattern matches kubo memory sizes such as "512MB" or "1.5GiB"
+var memorySizePattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?\s*([KMGT]i?)?B$`)
+
+// DefaultIPNSLifetime is the default validity period of published IPNS records
+const DefaultIPNSLifetime = 24 * time.Hour
+
+// DefaultIPNSTTL is the default cache TTL of published IPNS records
+const DefaultIPNSTTL = time.Hour
+
+// DefaultInstanceID is the instance ID used when none is configured.
+// The default instance keeps the legacy state and index file names.
+const DefaultInstanceID = "default"
+
+var instanceIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
 // ExternalIPFSConfig contains settings for external IPFS node
 type ExternalIPFSConfig struct {
-	APIURL  string                 `mapstructure:"api_url"`
-	Timeout int                    `mapstructure:"timeout"`
-	Options map[string]interface{} `mapstructure:"add_options"`
+	APIURL     string                 `mapstructure:"api_url" desc:"Kubo RPC API of the external node"`
+	Timeout    int                    `mapstructure:"timeout" desc:"Seconds before a request to the node times out"`
+	Options    map[string]interface{} `mapstructure:"add_options" desc:"Options of every add, e.g. pin, chunker, raw_leaves, nocopy"`
+	ChunkedAdd ChunkedAddConfig       `mapstructure:"chunked_add"`
+	Breaker    CircuitBreakerConfig   `mapstructure:"circuit_breaker"`
+}
+
+// CircuitBreakerConfig contains settings for failing fast while the external node is unreachable
+type CircuitBreakerConfig struct {
+	FailureThreshold int `mapstructure:"failure_threshold" desc:"Consecutive connection failures that open the circuit; 0 disables the breaker"`
+	Cooldown         int `mapstructure:"cooldown" desc:"Seconds requests fail fast before a health check probes the node again"`
+}
+
+// CooldownDuration returns how long the circuit stays open before the next probe
+func (c *CircuitBreakerConfig) CooldownDuration() time.Duration {
+	return time.Duration(c.Cooldown) * time.Second
+}
+
+// ChunkedAddConfig contains settings for resumable part-wise adds of large files.
+// Files added this way get a different CID than a plain add with the same chunker.
+type ChunkedAddConfig struct {
+	Threshold int64 `mapstructure:"threshold" desc:"Files larger than this many bytes are added in resumable parts; 0 disables"`
+	PartSize  int64 `mapstructure:"part_size" desc:"Bytes per part, a multiple of 262144"`
+	Retries   int   `mapstructure:"retries" desc:"Attempts per part before the add fails"`
 }
 
+// ChunkedAddAlignment is the alignment required for chunked add part sizes.
+// It matches the default 256KiB chunker so parts split on chunk boundaries.
+const ChunkedAddAlignment = 262144
+
 // EmbeddedIPFSConfig contains settings for embedded IPFS node
 type EmbeddedIPFSConfig struct {
-	RepoPath       string                 `mapstructure:"repo_path"`
-	SwarmPort      int                    `mapstructure:"swarm_port"`
-	APIPort        int                    `mapstructure:"api_port"`
-	GatewayPort    int                    `mapstructure:"gateway_port"`
-	Options        map[string]interface{} `mapstructure:"add_options"`
-	BootstrapPeers []string               `mapstructure:"bootstrap_peers"`
+	RepoPath       string                 `mapstructure:"repo_path" desc:"Repository of the embedded node"`
+	SwarmPort      int                    `mapstructure:"swarm_port" desc:"libp2p swarm port"`
+	APIPort        int                    `mapstructure:"api_port" desc:"Kubo RPC API port"`
+	GatewayPort    int                    `mapstructure:"gateway_port" desc:"HTTP gateway port"`
+	Options        map[string]interface{} `mapstructure:"add_options" desc:"Options of every add, e.g. pin, chunker, raw_leaves, nocopy"`
+	BootstrapPeers []string               `mapstructure:"bootstrap_peers" desc:"Replaces the repo's bootstrap list when set; multiaddrs ending in /p2p/<peer ID>, or bare peer IDs"`
+	PeerAddresses  map[string][]string    `mapstructure:"peer_addresses" desc:"Addresses of the bare peer IDs in bootstrap_peers"`
 	GC             GCConfig               `mapstructure:"gc"`
+	Resources      ResourcesConfig        `mapstructure:"resources"`
+}
+
+// ResourcesConfig limits the libp2p resources of the embedded node.
+// Zero values keep kubo's defaults.
+type ResourcesConfig struct {
+	MaxMemory     string `mapstructure:"max_memory" desc:"Resource manager memory ceiling, e.g. 512MB; empty = kubo default"`
+	ConnLowWater  int    `mapstructure:"conn_low_water" desc:"Connection manager low water mark; 0 = kubo default"`
+	ConnHighWater int    `mapstructure:"conn_high_water" desc:"Connection manager high water mark; 0 = kubo default"`
+}
+
+// Validate checks the resource limits
+func (r *ResourcesConfig) Validate() error {
+	if r.MaxMemory != "" && !memorySizePattern.MatchString(r.MaxMemory) {
+		return fmt.Errorf("resources.max_memory must be a size like \"512MB\", got %q", r.MaxMemory)
+	}
+	if r.ConnLowWater < 0 || r.ConnHighWater < 0 {
+		return fmt.Errorf("resources connection water marks cannot be negative")
+	}
+	if r.ConnHighWater > 0 && r.ConnLowWater > r.ConnHighWater {
+		return fmt.Errorf("resources.conn_low_water (%d) cannot exceed conn_high_water (%d)", r.ConnLowWater, r.ConnHighWater)
+	}
+	return nil
 }
 
 // GCConfig contains garbage collection settings
 type GCConfig struct {
-	Enabled      bool  `mapstructure:"enabled"`
-	Interval     int64 `mapstructure:"interval"`
-	MinFreeSpace int64 `mapstructure:"min_free_space"`
+	Enabled      bool  `mapstructure:"enabled" desc:"Run repository garbage collection, also when disk space runs short"`
+	Interval     int64 `mapstructure:"interval" desc:"Seconds between checks that collect garbage when free space is below min_free_space; 0 disables them"`
+	MinFreeSpace int64 `mapstructure:"min_free_space" desc:"Bytes of free disk space kept on the repo volume; 0 collects on every check"`
 }
 
 // IPFSConfig contains IPFS-related configuration
 type IPFSConfig struct {
-	Mode     IPFSMode           `mapstructure:"mode"`
+	Mode     IPFSMode           `mapstructure:"mode" desc:"external (existing IPFS node) or embedded (node inside the app)"`
 	External ExternalIPFSConfig `mapstructure:"external"`
 	Embedded EmbeddedIPFSConfig `mapstructure:"embedded"`
 }
 
 // PubsubConfig contains Pubsub-related configuration
 type PubsubConfig struct {
-	Enabled          bool     `mapstructure:"enabled"`
-	Topic            string   `mapstructure:"topic"`
-	AnnounceInterval int      `mapstructure:"announce_interval"`
-	BootstrapPeers   []string `mapstructure:"bootstrap_peers"`
-	ListenPort       int      `mapstructure:"listen_port"`
+	Enabled          bool                `mapstructure:"enabled" desc:"Announce the collection on PubSub"`
+	Topic            string              `mapstructure:"topic" desc:"Announcement topic, mdn/<category>/announce"`
+	AnnounceInterval int                 `mapstructure:"announce_interval" desc:"Seconds between periodic announcements"`
+	BootstrapPeers   []string            `mapstructure:"bootstrap_peers" desc:"Peers of the standalone PubSub node; multiaddrs ending in /p2p/<peer ID>, or bare peer IDs; empty = IPFS defaults"`
+	PeerAddresses    map[string][]string `mapstructure:"peer_addresses" desc:"Addresses of the bare peer IDs in bootstrap_peers"`
+	ListenPort       int                 `mapstructure:"listen_port" desc:"Port of the standalone PubSub node; 0 = random"`
+	ListenAddresses  []string            `mapstructure:"listen_addresses" desc:"Multiaddrs the standalone PubSub node listens on, e.g. /ip4/10.8.0.2/tcp/4002; empty = IPv4 and IPv6 on listen_port"`
+	PublishViaDaemon bool                `mapstructure:"publish_via_daemon" desc:"External mode: also publish through the daemon's pubsub API"`
+	AckWarnAfter     int                 `mapstructure:"ack_warn_after" desc:"Periodic announcements without any indexer ack before warning; 0 = never"`
+	ReachAlertAfter  int                 `mapstructure:"reach_alert_after" desc:"Announcements in a row without topic peers before alerting; 0 = never"`
+	MaxMemory        int64               `mapstructure:"max_memory" desc:"Memory ceiling of the standalone node in bytes; 0 = libp2p default"`
+}
+
+// PublishConfig contains IPNS publishing settings
+type PublishConfig struct {
+	IPNSLifetime string   `mapstructure:"ipns_lifetime" desc:"Validity of IPNS records, a duration such as 24h"`
+	IPNSTTL      string   `mapstructure:"ipns_ttl" desc:"How long resolvers may cache IPNS records"`
+	MirrorKeys   []string `mapstructure:"mirror_keys" desc:"Extra key names the index is published under in parallel"`
+	SignRecords  bool     `mapstructure:"sign_records" desc:"Add a claim signed with the publisher key to every index record"`
+	Layout       string   `mapstructure:"layout" desc:"What IPNS points at: ndjson (the index), directory (a tree of the files at their paths with the index at its root) or both (the tree, announcing only the index to indexers)"`
+}
+
+// Tree reports whether the layout publishes the collection as a directory tree
+func (p *PublishConfig) Tree() bool {
+	return p.Layout == LayoutDirectory || p.Layout == LayoutBoth
+}
+
+// Lifetime returns the parsed IPNS record lifetime (DefaultIPNSLifetime if unset or invalid)
+func (p *PublishConfig) Lifetime() time.Duration {
+	d, err := time.ParseDuration(p.IPNSLifetime)
+	if err != nil || d <= 0 {
+		return DefaultIPNSLifetime
+	}
+	return d
+}
+
+// TTL returns the parsed IPNS record TTL (0 lets the node use its default)
+func (p *PublishConfig) TTL() time.Duration {
+	d, err := time.ParseDuration(p.IPNSTTL)
+	if err != nil || d < 0 {
+		return 0
+	}
+	return d
+}
+
+// RepublishInterval returns how often IPNS records should be re-signed so they never expire
+func (p *PublishConfig) RepublishInterval() time.Duration {
+	return p.Lifetime() / 2
+}
+
+// CollectionConfig contains metadata announced with the collection
+type CollectionConfig struct {
+	Visibility  string `mapstructure:"visibility" desc:"public or unlisted"`
+	License     string `mapstructure:"license" desc:"License string announced with the collection, e.g. CC-BY-4.0"`
+	Title       string `mapstructure:"title" desc:"Title shown in share documents and the manifest"`
+	Description string `mapstructure:"description" desc:"Description published in the manifest"`
+	Contact     string `mapstructure:"contact" desc:"Contact URI published in the manifest, e.g. mailto:ops@example.org"`
+}
+
+// APIConfig contains settings of the local metrics and status HTTP server
+type APIConfig struct {
+	ListenAddr string        `mapstructure:"listen_addr" desc:"host:port of the metrics and status server, e.g. 127.0.0.1:9090; empty = disabled"`
+	Control    ControlConfig `mapstructure:"control"`
+}
+
+// ControlConfig contains settings of the HTTP server that changes the running
+// publisher, e.g. its watched directories. It is separate from the status
+// server, so exposing metrics never exposes control.
+type ControlConfig struct {
+	Enabled    bool   `mapstructure:"enabled" desc:"Serve the control routes, e.g. adding and removing watched directories"`
+	ListenAddr string `mapstructure:"listen_addr" desc:"host:port of the control server; keep it on a loopback address"`
+	Token      string `mapstructure:"token" desc:"Bearer token every control request must carry; required when enabled"`
+}
+
+// Loopback reports whether the control server listens on a loopback address only
+func (c *ControlConfig) Loopback() bool {
+	host, _, err := net.SplitHostPort(c.ListenAddr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// TracingConfig contains settings of the OpenTelemetry traces of the publish pipeline
+type TracingConfig struct {
+	Enabled bool `mapstructure:"enabled" desc:"Export OpenTelemetry traces over OTLP, configured by the standard OTEL_EXPORTER_OTLP_* variables"`
+}
+
+// FailoverConfig contains settings of the state snapshots kept for a standby publisher
+type FailoverConfig struct {
+	Enabled          bool   `mapstructure:"enabled" desc:"Upload encrypted snapshots of the state and index with a heartbeat for a standby publisher"`
+	Secret           string `mapstructure:"secret" desc:"Shared key encrypting the snapshots, 64 hex characters; the same on the primary and the standby"`
+	SnapshotKey      string `mapstructure:"snapshot_key" desc:"IPNS key name the snapshot manifest is published under; the standby's node needs the same key"`
+	SnapshotInterval int    `mapstructure:"snapshot_interval" desc:"Seconds between heartbeats; changed state and index are uploaded with the next one"`
+	HeartbeatTimeout int    `mapstructure:"heartbeat_timeout" desc:"Seconds without a heartbeat before the standby may take over; at least twice snapshot_interval"`
+}
+
+// SecretKey returns the decoded snapshot encryption key
+func (f *FailoverConfig) SecretKey() ([]byte, error) {
+	key, err := hex.DecodeString(f.Secret)
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("failover.secret must be 64 hex characters (32 bytes), e.g. from openssl rand -hex 32")
+	}
+	return key, nil
+}
+
+// Interval returns the time between heartbeats
+func (f *FailoverConfig) Interval() time.Duration {
+	return time.Duration(f.SnapshotInterval) * time.Second
+}
+
+// Timeout returns how long the heartbeat must be missing before a takeover
+func (f *FailoverConfig) Timeout() time.Duration {
+	return time.Duration(f.HeartbeatTimeout) * time.Second
 }
 
 // LoggingConfig contains logging settings
 type LoggingConfig struct {
-	Level      string `mapstructure:"level"`
-	File       string `mapstructure:"file"`
-	MaxSize    int    `mapstructure:"max_size"`
-	MaxBackups int    `mapstructure:"max_backups"`
-	Console    bool   `mapstructure:"console"`
+	Level      string `mapstructure:"level" desc:"debug, info, warn or error"`
+	File       string `mapstructure:"file" desc:"Log file; suffixed with the instance ID"`
+	MaxSize    int    `mapstructure:"max_size" desc:"Megabytes before the log file is rotated"`
+	MaxBackups int    `mapstructure:"max_backups" desc:"Rotated log files kept"`
+	Console    bool   `mapstructure:"console" desc:"Also log to the console"`
 }
 
 // BehaviorConfig contains application behavior settings
 type BehaviorConfig struct {
-	ScanInterval      int  `mapstructure:"scan_interval"`
-	BatchSize         int  `mapstructure:"batch_size"`
-	ProgressBar       bool `mapstructure:"progress_bar"`
-	StateSaveInterval int  `mapstructure:"state_save_interval"`
+	ScanInterval          int      `mapstructure:"scan_interval" desc:"Seconds between full rescans of the directories and between polls of unwatched directories"`
+	ScanWorkers           int      `mapstructure:"scan_workers" desc:"Goroutines listing directories during scans; above 1, at least one per directory"`
+	ExcludePatterns       []string `mapstructure:"exclude_patterns" desc:"Globs of files never published, matched against the absolute path and the file name, e.g. **/extras/** or *.nfo"`
+	BatchSize             int      `mapstructure:"batch_size" desc:"Files uploaded per batch"`
+	UploadRetries         int      `mapstructure:"upload_retries" desc:"Retries of an upload that failed to reach the node or timed out; 0 = no retries"`
+	UploadRetryBackoff    int      `mapstructure:"upload_retry_backoff" desc:"Seconds before the first retry of an upload, doubled for each further retry"`
+	ProgressBar           bool     `mapstructure:"progress_bar" desc:"Show a progress bar of the bytes uploaded during scans when stdout is a terminal"`
+	StateSaveInterval     int      `mapstructure:"state_save_interval" desc:"Seconds between saves of unsaved state changes"`
+	StateSaveFiles        int      `mapstructure:"state_save_files" desc:"Also save the state after this many staged or recorded files; 0 = on the interval only"`
+	InstanceID            string   `mapstructure:"instance_id" desc:"Distinct ID per instance sharing base_dir"`
+	Profile               string   `mapstructure:"profile" desc:"default, or low-power for conservative defaults on small devices"`
+	PublishBatchSize      int      `mapstructure:"publish_batch_size" desc:"Publish after this many staged changes; 0 = after the whole change set"`
+	VerifyUploads         string   `mapstructure:"verify_uploads" desc:"Verify uploads: off, sample or full"`
+	VerifySampleSize      int64    `mapstructure:"verify_sample_size" desc:"Bytes read at each end of a file in sample verification"`
+	PinCheckSample        int      `mapstructure:"pin_check_sample" desc:"Recorded CIDs checked on the node at startup; 0 = skip"`
+	ProbeSample           int      `mapstructure:"probe_sample" desc:"Published CIDs looked up in the routing system after each publish; 0 = off"`
+	PinStrategy           string   `mapstructure:"pin_strategy" desc:"inline pins with every add; deferred pins each batch in bulk"`
+	DedupeUploads         bool     `mapstructure:"dedupe_uploads" desc:"Reuse the CID of identical content already published or added recently instead of adding it again"`
+	DedupeCacheSize       int      `mapstructure:"dedupe_cache_size" desc:"Content hashes remembered by dedupe_uploads"`
+	RemoveMissing         bool     `mapstructure:"remove_missing" desc:"Remove recorded files a scan no longer finds from the index and state"`
+	RemoveMissingMaxRatio float64  `mapstructure:"remove_missing_max_ratio" desc:"Remove nothing when more than this fraction of recorded files is missing, e.g. an unmounted disk; 1 = no limit"`
+	UnpinRemoved          bool     `mapstructure:"unpin_removed" desc:"Unpin the content of files removed from the index unless another file has the same content"`
+	ExtractMetadata       bool     `mapstructure:"extract_metadata" desc:"Add title, artist, album, duration and frame size read from audio tags and video containers to index records"`
+	MaxFileSize           int64    `mapstructure:"max_file_size" desc:"Files larger than this many bytes are skipped with a warning instead of uploaded; 0 = no limit"`
+	ContentCheck          string   `mapstructure:"content_check" desc:"Check the first bytes of media files against their extension before uploading: off, warn or skip"`
+	WatchMode             string   `mapstructure:"watch_mode" desc:"auto watches through the OS and polls what it cannot watch; poll polls everything every scan_interval"`
 }
 
 // Config represents the complete application configuration
 type Config struct {
-	IPFS        IPFSConfig     `mapstructure:"ipfs"`
-	Pubsub      PubsubConfig   `mapstructure:"pubsub"`
-	Directories []string       `mapstructure:"directories"`
-	Extensions  []string       `mapstructure:"extensions"`
-	Logging     LoggingConfig  `mapstructure:"logging"`
-	Behavior    BehaviorConfig `mapstructure:"behavior"`
-	BaseDir     string         `mapstructure:"base_dir"`
+	IPFS        IPFSConfig       `mapstructure:"ipfs"`
+	Pubsub      PubsubConfig     `mapstructure:"pubsub"`
+	Publish     PublishConfig    `mapstructure:"publish"`
+	Collection  CollectionConfig `mapstructure:"collection"`
+	Directories []string         `mapstructure:"directories" desc:"Directories whose media files are published"`
+	Extensions  []string         `mapstructure:"extensions" desc:"File extensions to publish, case and leading dot ignored"`
+	Logging     LoggingConfig    `mapstructure:"logging"`
+	Behavior    BehaviorConfig   `mapstructure:"behavior"`
+	API         APIConfig        `mapstructure:"api"`
+	Tracing     TracingConfig    `mapstructure:"tracing"`
+	Failover    FailoverConfig   `mapstructure:"failover"`
+	BaseDir     string           `mapstructure:"base_dir" desc:"Directory of keys, state, index and logs"`
+
+	duplicateExtensions []string // Extensions dropped by Validate as duplicates, reported by Warnings
+	duplicatePeers      []string // Bootstrap peers dropped by Validate as duplicates, reported by Warnings
+	unknownKeys         []string // Keys matching no setting, ignored on request and reported by Warnings
+}
+
+// LoadOptions adjusts how the configuration file is read
+type LoadOptions struct {
+	IgnoreUnknownKeys bool // Report unknown keys as warnings instead of failing
 }
 
-// Load loads configuration from the specified file
+// Load loads configuration from the specified file. Unknown keys, such as a
+// misspelled setting, fail the load.
 func Load(configPath string) (*Config, error) {
+	return LoadWithOptions(configPath, LoadOptions{})
+}
+
+// LoadWithOptions loads configuration from the specified file
+func LoadWithOptions(configPath string, opts LoadOptions) (*Config, error) {
 	v := viper.New()
 
 	// Set defaults
 	setDefaults(v)
 
+	// Environment variables override the file, e.g. in containers
+	if err := bindEnv(v); err != nil {
+		return nil, err
+	}
+
 	// Expand tilde in config path
 	if strings.HasPrefix(configPath, "~") {
 		home, err := os.UserHomeDir()
@@ -114,13 +398,37 @@ func Load(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to read config: %w", err)
 	}
 
+	// Profiles change defaults only, so values set in the file still win
+	if err := applyProfile(v, v.GetString("behavior.profile")); err != nil {
+		return nil, err
+	}
+
 	var cfg Config
-	if err := v.Unmarshal(&cfg); err != nil {
+	var metadata mapstructure.Metadata
+	if err := v.Unmarshal(&cfg, func(dc *mapstructure.DecoderConfig) { dc.Metadata = &metadata }); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
+	// Viper drops keys that match no setting, so a misspelled key would silently keep its default
+	if len(metadata.Unused) > 0 {
+		sort.Strings(metadata.Unused)
+		if !opts.IgnoreUnknownKeys {
+			return nil, fmt.Errorf("unknown config keys %s; run \"ipfs-publisher config schema\" for the recognized keys",
+				strings.Join(metadata.Unused, ", "))
+		}
+		cfg.unknownKeys = metadata.Unused
+	}
+
+	if err := normalizeAddOptions("ipfs.external.add_options", cfg.IPFS.External.Options); err != nil {
+		return nil, fmt.Errorf("config validation failed: %w", err)
+	}
+	if err := normalizeAddOptions("ipfs.embedded.add_options", cfg.IPFS.Embedded.Options); err != nil {
+		return nil, fmt.Errorf("config validation failed: %w", err)
+	}
+
 	// Expand tilde in paths
 	cfg.expandPaths()
+	cfg.applyInstanceID()
 
 	// Validate configuration
 	if err := cfg.Validate(); err != nil {
@@ -135,25 +443,142 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("ipfs.mode", "external")
 	v.SetDefault("ipfs.external.api_url", "http://localhost:5001")
 	v.SetDefault("ipfs.external.timeout", 300)
+	v.SetDefault("ipfs.external.chunked_add.threshold", 0)
+	v.SetDefault("ipfs.external.chunked_add.part_size", 268435456)
+	v.SetDefault("ipfs.external.chunked_add.retries", 5)
+	v.SetDefault("ipfs.external.circuit_breaker.failure_threshold", 5)
+	v.SetDefault("ipfs.external.circuit_breaker.cooldown", 30)
 	v.SetDefault("ipfs.embedded.swarm_port", 4002)
 	v.SetDefault("ipfs.embedded.api_port", 5002)
 	v.SetDefault("ipfs.embedded.gateway_port", 8081)
 	v.SetDefault("ipfs.embedded.repo_path", "~/.ipfs_publisher/ipfs-repo")
-	v.SetDefault("pubsub.topic", "mdn/collections/announce")
+	v.SetDefault("pubsub.topic", topic.Default)
 	v.SetDefault("pubsub.announce_interval", 3600)
 	v.SetDefault("pubsub.listen_port", 0)
+	v.SetDefault("pubsub.listen_addresses", []string{})
+	v.SetDefault("pubsub.publish_via_daemon", false)
+	v.SetDefault("pubsub.ack_warn_after", 5)
+	v.SetDefault("pubsub.reach_alert_after", 5)
+	v.SetDefault("publish.ipns_lifetime", "24h")
+	v.SetDefault("publish.ipns_ttl", "1h")
+	v.SetDefault("publish.mirror_keys", []string{})
+	v.SetDefault("publish.sign_records", false)
+	v.SetDefault("publish.layout", LayoutNDJSON)
+	v.SetDefault("collection.visibility", VisibilityPublic)
+	v.SetDefault("collection.license", "")
+	v.SetDefault("collection.title", "")
+	v.SetDefault("collection.description", "")
+	v.SetDefault("collection.contact", "")
 	v.SetDefault("logging.level", "info")
 	v.SetDefault("logging.file", "~/.ipfs_publisher/logs/app.log")
 	v.SetDefault("logging.max_size", 100)
 	v.SetDefault("logging.max_backups", 5)
 	v.SetDefault("logging.console", true)
 	v.SetDefault("behavior.scan_interval", 10)
+	v.SetDefault("behavior.scan_workers", 1)
+	v.SetDefault("behavior.exclude_patterns", []string{})
 	v.SetDefault("behavior.batch_size", 10)
+	v.SetDefault("behavior.upload_retries", 3)
+	v.SetDefault("behavior.upload_retry_backoff", 2)
 	v.SetDefault("behavior.progress_bar", true)
 	v.SetDefault("behavior.state_save_interval", 60)
+	v.SetDefault("behavior.state_save_files", 100)
+	v.SetDefault("behavior.instance_id", DefaultInstanceID)
+	v.SetDefault("behavior.profile", ProfileDefault)
+	v.SetDefault("behavior.publish_batch_size", 0)
+	v.SetDefault("pubsub.max_memory", 0)
+	v.SetDefault("ipfs.embedded.gc.interval", 86400)
+	v.SetDefault("ipfs.embedded.resources.max_memory", "")
+	v.SetDefault("ipfs.embedded.resources.conn_low_water", 0)
+	v.SetDefault("ipfs.embedded.resources.conn_high_water", 0)
+	v.SetDefault("behavior.verify_uploads", VerifyUploadsOff)
+	v.SetDefault("behavior.verify_sample_size", 1048576)
+	v.SetDefault("behavior.pin_check_sample", 20)
+	v.SetDefault("behavior.probe_sample", 0)
+	v.SetDefault("behavior.pin_strategy", PinStrategyInline)
+	v.SetDefault("behavior.dedupe_uploads", false)
+	v.SetDefault("behavior.dedupe_cache_size", 1024)
+	v.SetDefault("behavior.watch_mode", WatchModeAuto)
+	v.SetDefault("behavior.remove_missing", true)
+	v.SetDefault("behavior.remove_missing_max_ratio", 0.5)
+	v.SetDefault("behavior.unpin_removed", false)
+	v.SetDefault("behavior.extract_metadata", false)
+	v.SetDefault("behavior.max_file_size", 0)
+	v.SetDefault("behavior.content_check", ContentCheckOff)
+	v.SetDefault("api.listen_addr", "")
+	v.SetDefault("api.control.enabled", false)
+	v.SetDefault("api.control.listen_addr", "127.0.0.1:9091")
+	v.SetDefault("api.control.token", "")
+	v.SetDefault("tracing.enabled", false)
+	v.SetDefault("failover.enabled", false)
+	v.SetDefault("failover.secret", "")
+	v.SetDefault("failover.snapshot_key", "publisher-failover")
+	v.SetDefault("failover.snapshot_interval", 300)
+	v.SetDefault("failover.heartbeat_timeout", 900)
 	v.SetDefault("base_dir", "~/.ipfs_publisher")
 }
 
+// DefaultsYAML renders the default configuration as plain YAML without comments.
+// It uses the same defaults as Load, so the output stays in sync with setDefaults.
+func DefaultsYAML() ([]byte, error) {
+	v := viper.New()
+	setDefaults(v)
+
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal defaults: %w", err)
+	}
+
+	// Convert back to a map keyed by the mapstructure tags used in config files
+	var settings map[string]interface{}
+	if err := mapstructure.Decode(cfg, &settings); err != nil {
+		return nil, fmt.Errorf("failed to convert defaults: %w", err)
+	}
+
+	data, err := yaml.Marshal(settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal defaults: %w", err)
+	}
+
+	return data, nil
+}
+
+// Schema lists every recognized setting with its type, default and description
+func Schema() ([]configschema.Field, error) {
+	v := viper.New()
+	setDefaults(v)
+
+	var cfg Config
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal defaults: %w", err)
+	}
+
+	return configschema.Fields(&cfg), nil
+}
+
+// applyProfile overrides defaults with the values of a configuration profile
+func applyProfile(v *viper.Viper, profile string) error {
+	switch profile {
+	case "", ProfileDefault:
+	case ProfileLowPower:
+		// Small devices such as a Raspberry Pi: few connections, a hard memory
+		// ceiling, bigger chunks (fewer blocks to track) and small batches
+		v.SetDefault("ipfs.embedded.resources.max_memory", "256MB")
+		v.SetDefault("ipfs.embedded.resources.conn_low_water", 16)
+		v.SetDefault("ipfs.embedded.resources.conn_high_water", 48)
+		v.SetDefault("ipfs.embedded.add_options.chunker", "size-1048576")
+		v.SetDefault("ipfs.external.add_options.chunker", "size-1048576")
+		if v.GetString("ipfs.mode") != string(IPFSModeEmbedded) {
+			v.SetDefault("pubsub.max_memory", 67108864)
+		}
+		v.SetDefault("behavior.batch_size", 2)
+		v.SetDefault("behavior.pin_check_sample", 5)
+	default:
+		return fmt.Errorf("unknown behavior.profile %q (must be %q or %q)", profile, ProfileDefault, ProfileLowPower)
+	}
+	return nil
+}
+
 // expandPaths expands ~ in file paths
 func (c *Config) expandPaths() {
 	home, err := os.UserHomeDir()
@@ -210,6 +635,168 @@ func (c *Config) expandPaths() {
 	}
 }
 
+// applyInstanceID suffixes the log file name with the instance ID so that
+// several instances sharing a base directory don't write to the same log
+func (c *Config) applyInstanceID() {
+	if c.Behavior.InstanceID == "" || c.Behavior.InstanceID == DefaultInstanceID || c.Logging.File == "" {
+		return
+	}
+
+	ext := filepath.Ext(c.Logging.File)
+	c.Logging.File = strings.TrimSuffix(c.Logging.File, ext) + "-" + c.Behavior.InstanceID + ext
+}
+
+// instanceFileName returns name with the instance ID inserted before the extension
+func (c *Config) instanceFileName(name string) string {
+	if c.Behavior.InstanceID == "" || c.Behavior.InstanceID == DefaultInstanceID {
+		return name
+	}
+
+	ext := filepath.Ext(name)
+	return strings.TrimSuffix(name, ext) + "-" + c.Behavior.InstanceID + ext
+}
+
+// StatePath returns the state file path for this instance
+func (c *Config) StatePath() string {
+	return filepath.Join(c.BaseDir, c.instanceFileName("state.json"))
+}
+
+// IndexPath returns the NDJSON index file path for this instance
+func (c *Config) IndexPath() string {
+	return filepath.Join(c.BaseDir, c.instanceFileName("collection.ndjson"))
+}
+
+// normalizeBootstrapPeers validates the bootstrap peers of the standalone PubSub node
+// and the embedded node and replaces them with canonical multiaddrs, resolving bare
+// peer IDs through peer_addresses
+func (c *Config) normalizeBootstrapPeers() error {
+	lists := []struct {
+		name      string
+		peers     *[]string
+		addresses map[string][]string
+	}{
+		{"pubsub", &c.Pubsub.BootstrapPeers, c.Pubsub.PeerAddresses},
+		{"ipfs.embedded", &c.IPFS.Embedded.BootstrapPeers, c.IPFS.Embedded.PeerAddresses},
+	}
+
+	for _, list := range lists {
+		normalized, duplicates, err := bootstrap.NormalizeList(*list.peers, list.addresses)
+		if err != nil {
+			return fmt.Errorf("%s.bootstrap_peers: %w", list.name, err)
+		}
+		*list.peers = normalized
+		c.duplicatePeers = append(c.duplicatePeers, duplicates...)
+	}
+	return nil
+}
+
+// FailoverIDPath returns the file holding the publisher ID of failover manifests for this instance
+func (c *Config) FailoverIDPath() string {
+	return filepath.Join(c.BaseDir, c.instanceFileName("failover-id"))
+}
+
+// ReachPath returns the announcement reach snapshot file path for this instance
+func (c *Config) ReachPath() string {
+	return filepath.Join(c.BaseDir, c.instanceFileName("reach.json"))
+}
+
+// Validate checks the failover settings; mirrorKeys are the publish.mirror_keys
+// the snapshot key must not be one of
+func (f *FailoverConfig) Validate(mirrorKeys []string) error {
+	if _, err := f.SecretKey(); err != nil {
+		return err
+	}
+	if f.SnapshotKey == "" || f.SnapshotKey == "self" || slices.Contains(mirrorKeys, f.SnapshotKey) {
+		return fmt.Errorf("failover.snapshot_key must be a key name other than 'self' and the publish.mirror_keys, got %q", f.SnapshotKey)
+	}
+	if f.SnapshotInterval <= 0 {
+		return fmt.Errorf("failover.snapshot_interval must be positive, got %d", f.SnapshotInterval)
+	}
+	if f.HeartbeatTimeout < 2*f.SnapshotInterval {
+		return fmt.Errorf("failover.heartbeat_timeout must be at least twice failover.snapshot_interval (%d), got %d", 2*f.SnapshotInterval, f.HeartbeatTimeout)
+	}
+	return nil
+}
+
+// Validate checks the chunked add settings
+func (c *ChunkedAddConfig) Validate() error {
+	if c.Threshold < 0 {
+		return fmt.Errorf("ipfs.external.chunked_add.threshold cannot be negative, got %d", c.Threshold)
+	}
+	if c.Threshold == 0 {
+		return nil
+	}
+	if c.PartSize <= 0 || c.PartSize%ChunkedAddAlignment != 0 {
+		return fmt.Errorf("ipfs.external.chunked_add.part_size must be a positive multiple of %d, got %d", ChunkedAddAlignment, c.PartSize)
+	}
+	if c.Retries < 1 {
+		return fmt.Errorf("ipfs.external.chunked_add.retries must be at least 1, got %d", c.Retries)
+	}
+	return nil
+}
+
+// Validate checks the circuit breaker settings
+func (c *CircuitBreakerConfig) Validate() error {
+	if c.FailureThreshold < 0 {
+		return fmt.Errorf("ipfs.external.circuit_breaker.failure_threshold cannot be negative, got %d", c.FailureThreshold)
+	}
+	if c.FailureThreshold > 0 && c.Cooldown <= 0 {
+		return fmt.Errorf("ipfs.external.circuit_breaker.cooldown must be positive, got %d", c.Cooldown)
+	}
+	return nil
+}
+
+// Warnings returns non-fatal configuration issues that should be logged at startup
+func (c *Config) Warnings() []string {
+	var warnings []string
+
+	if c.Pubsub.Enabled && c.Pubsub.Topic != topic.Default {
+		warnings = append(warnings, fmt.Sprintf("pubsub.topic %q differs from the well-known default %q; only indexers using the same topic will hear announcements", c.Pubsub.Topic, topic.Default))
+	}
+
+	if len(c.duplicateExtensions) > 0 {
+		warnings = append(warnings, fmt.Sprintf("extensions %q repeat earlier entries once normalized (lowercase, no leading dot) and are ignored; extensions in use: %s", c.duplicateExtensions, strings.Join(c.Extensions, ", ")))
+	}
+
+	if len(c.duplicatePeers) > 0 {
+		warnings = append(warnings, fmt.Sprintf("bootstrap peers %q repeat earlier entries and are ignored", c.duplicatePeers))
+	}
+
+	if len(c.unknownKeys) > 0 {
+		warnings = append(warnings, fmt.Sprintf("unknown config keys %s are ignored", strings.Join(c.unknownKeys, ", ")))
+	}
+
+	// Deferred pinning changes when files are pinned, not whether they are
+	addOptions := c.IPFS.External.Options
+	if c.IPFS.Mode == IPFSModeEmbedded {
+		addOptions = c.IPFS.Embedded.Options
+	}
+	if pin, ok := addOptions["pin"].(bool); ok && !pin && c.Behavior.PinStrategy == PinStrategyDeferred {
+		warnings = append(warnings, "behavior.pin_strategy \"deferred\" has no effect with add_options pin: false; files are not pinned")
+	}
+
+	if c.API.Control.Enabled && !c.API.Control.Loopback() {
+		warnings = append(warnings, fmt.Sprintf("api.control.listen_addr %q is not a loopback address; anyone reaching it with the token can change the watched directories", c.API.Control.ListenAddr))
+	}
+
+	if c.Pubsub.PublishViaDaemon && c.IPFS.Mode != IPFSModeExternal {
+		warnings = append(warnings, "pubsub.publish_via_daemon only applies to external mode and is ignored")
+	}
+
+	// IPNS records may expire between publishes if the lifetime is too short
+	publishInterval := time.Duration(c.Behavior.ScanInterval) * time.Second
+	if c.Pubsub.Enabled {
+		if announce := time.Duration(c.Pubsub.AnnounceInterval) * time.Second; announce > publishInterval {
+			publishInterval = announce
+		}
+	}
+	if lifetime := c.Publish.Lifetime(); lifetime < 2*publishInterval {
+		warnings = append(warnings, fmt.Sprintf("publish.ipns_lifetime %s is shorter than twice the publish interval %s; IPNS records may expire between publishes", lifetime, publishInterval))
+	}
+
+	return warnings
+}
+
 // Validate validates the configuration
 func (c *Config) Validate() error {
 	// Validate IPFS mode
@@ -225,6 +812,22 @@ func (c *Config) Validate() error {
 		if c.IPFS.External.Timeout <= 0 {
 			return fmt.Errorf("external IPFS timeout must be positive, got %d", c.IPFS.External.Timeout)
 		}
+		if err := c.IPFS.External.ChunkedAdd.Validate(); err != nil {
+			return err
+		}
+		if err := c.IPFS.External.Breaker.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Bootstrap peers are dialed in their canonical form; a typo fails here instead of at dial time
+	if err := c.normalizeBootstrapPeers(); err != nil {
+		return err
+	}
+
+	// The embedded node's PubSub is always used: never run a second libp2p host next to it
+	if c.Pubsub.Enabled && c.IPFS.Mode == IPFSModeEmbedded && (c.Pubsub.ListenPort != 0 || len(c.Pubsub.ListenAddresses) > 0 || len(c.Pubsub.BootstrapPeers) > 0 || c.Pubsub.MaxMemory > 0) {
+		return fmt.Errorf("pubsub.listen_port, pubsub.listen_addresses, pubsub.bootstrap_peers and pubsub.max_memory configure the standalone PubSub node, which cannot run next to the embedded node; remove them and use ipfs.embedded settings instead")
 	}
 
 	// Validate ports for embedded mode
@@ -248,6 +851,14 @@ func (c *Config) Validate() error {
 		if len(ports) < 3 {
 			return fmt.Errorf("embedded IPFS ports must be unique")
 		}
+
+		if c.IPFS.Embedded.GC.Interval < 0 || c.IPFS.Embedded.GC.MinFreeSpace < 0 {
+			return fmt.Errorf("ipfs.embedded.gc.interval and min_free_space must not be negative")
+		}
+
+		if err := c.IPFS.Embedded.Resources.Validate(); err != nil {
+			return fmt.Errorf("ipfs.embedded.%w", err)
+		}
 	}
 
 	// Validate directories
@@ -269,10 +880,16 @@ func (c *Config) Validate() error {
 		}
 	}
 
-	// Validate extensions
+	// Validate extensions; scanner and watcher match the normalized form
 	if len(c.Extensions) == 0 {
 		return fmt.Errorf("at least one file extension must be configured")
 	}
+	normalized, duplicates, err := extensions.NormalizeList(c.Extensions)
+	if err != nil {
+		return fmt.Errorf("invalid extensions: %w", err)
+	}
+	c.Extensions = normalized
+	c.duplicateExtensions = append(c.duplicateExtensions, duplicates...)
 
 	// Validate logging level
 	validLevels := map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
@@ -286,23 +903,201 @@ func (c *Config) Validate() error {
 			return err
 		}
 	}
+	if len(c.Pubsub.ListenAddresses) > 0 && c.Pubsub.ListenPort != 0 {
+		return fmt.Errorf("pubsub.listen_port only applies without pubsub.listen_addresses; put the port in the addresses instead")
+	}
+	for _, addr := range c.Pubsub.ListenAddresses {
+		if err := validateListenAddress(addr); err != nil {
+			return fmt.Errorf("pubsub.listen_addresses: %w", err)
+		}
+	}
 
 	// Validate PubSub topic
 	if c.Pubsub.Enabled && c.Pubsub.Topic == "" {
 		return fmt.Errorf("pubsub.topic cannot be empty when PubSub is enabled")
 	}
+	if c.Pubsub.Topic != "" {
+		if err := topic.Validate(c.Pubsub.Topic); err != nil {
+			return fmt.Errorf("pubsub.topic: %w", err)
+		}
+	}
+
+	// Validate IPNS publish durations
+	if lifetime, err := time.ParseDuration(c.Publish.IPNSLifetime); err != nil {
+		return fmt.Errorf("invalid publish.ipns_lifetime %q: %w", c.Publish.IPNSLifetime, err)
+	} else if lifetime <= 0 {
+		return fmt.Errorf("publish.ipns_lifetime must be positive, got %s", lifetime)
+	}
+	if ttl, err := time.ParseDuration(c.Publish.IPNSTTL); err != nil {
+		return fmt.Errorf("invalid publish.ipns_ttl %q: %w", c.Publish.IPNSTTL, err)
+	} else if ttl < 0 {
+		return fmt.Errorf("publish.ipns_ttl cannot be negative, got %s", ttl)
+	}
+	switch c.Publish.Layout {
+	case LayoutNDJSON, LayoutDirectory, LayoutBoth:
+	default:
+		return fmt.Errorf("publish.layout must be 'ndjson', 'directory' or 'both', got %q", c.Publish.Layout)
+	}
+
+	// Validate API listen address
+	if c.API.ListenAddr != "" {
+		if _, _, err := net.SplitHostPort(c.API.ListenAddr); err != nil {
+			return fmt.Errorf("invalid api.listen_addr %q: %w", c.API.ListenAddr, err)
+		}
+	}
+	if c.API.Control.Enabled {
+		if _, _, err := net.SplitHostPort(c.API.Control.ListenAddr); err != nil {
+			return fmt.Errorf("invalid api.control.listen_addr %q: %w", c.API.Control.ListenAddr, err)
+		}
+		if c.API.Control.Token == "" {
+			return fmt.Errorf("api.control.token is required when api.control.enabled is true")
+		}
+	}
+
+	// Validate collection metadata
+	if c.Collection.Visibility != VisibilityPublic && c.Collection.Visibility != VisibilityUnlisted {
+		return fmt.Errorf("collection.visibility must be 'public' or 'unlisted', got %q", c.Collection.Visibility)
+	}
+	if err := manifest.ValidateContact(c.Collection.Contact); err != nil {
+		return fmt.Errorf("collection.contact: %w", err)
+	}
+
+	// Validate mirror key names
+	seenKeys := make(map[string]bool)
+	for _, name := range c.Publish.MirrorKeys {
+		if name == "" || name == "self" {
+			return fmt.Errorf("publish.mirror_keys cannot contain an empty name or 'self'")
+		}
+		if seenKeys[name] {
+			return fmt.Errorf("publish.mirror_keys contains duplicate key %q", name)
+		}
+		seenKeys[name] = true
+	}
+
+	if c.Failover.Enabled {
+		if err := c.Failover.Validate(c.Publish.MirrorKeys); err != nil {
+			return err
+		}
+	}
 
 	// Validate behavior values
 	if c.Behavior.ScanInterval <= 0 {
 		return fmt.Errorf("scan_interval must be positive")
 	}
+	if c.Behavior.ScanWorkers <= 0 {
+		return fmt.Errorf("scan_workers must be positive")
+	}
+	for _, pattern := range c.Behavior.ExcludePatterns {
+		if strings.TrimSpace(pattern) == "" {
+			return fmt.Errorf("exclude_patterns cannot contain an empty pattern")
+		}
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("exclude_patterns: invalid pattern %q: %w", pattern, err)
+		}
+	}
 	if c.Behavior.BatchSize <= 0 {
 		return fmt.Errorf("batch_size must be positive")
 	}
+	if c.Behavior.UploadRetries < 0 {
+		return fmt.Errorf("upload_retries must not be negative")
+	}
+	if c.Behavior.UploadRetryBackoff < 0 {
+		return fmt.Errorf("upload_retry_backoff must not be negative")
+	}
 	if c.Behavior.StateSaveInterval <= 0 {
 		return fmt.Errorf("state_save_interval must be positive")
 	}
+	if c.Behavior.StateSaveFiles < 0 {
+		return fmt.Errorf("state_save_files must not be negative")
+	}
+	if !instanceIDPattern.MatchString(c.Behavior.InstanceID) {
+		return fmt.Errorf("instance_id must contain only letters, digits, '-' and '_', got %q", c.Behavior.InstanceID)
+	}
+	switch c.Behavior.VerifyUploads {
+	case VerifyUploadsOff, VerifyUploadsSample, VerifyUploadsFull:
+	default:
+		return fmt.Errorf("verify_uploads must be 'off', 'sample' or 'full', got %q", c.Behavior.VerifyUploads)
+	}
+	if c.Behavior.VerifyUploads == VerifyUploadsSample && c.Behavior.VerifySampleSize <= 0 {
+		return fmt.Errorf("verify_sample_size must be positive")
+	}
+
+	switch c.Behavior.PinStrategy {
+	case PinStrategyInline, PinStrategyDeferred:
+	default:
+		return fmt.Errorf("pin_strategy must be 'inline' or 'deferred', got %q", c.Behavior.PinStrategy)
+	}
+	if c.Behavior.DedupeUploads && c.Behavior.DedupeCacheSize <= 0 {
+		return fmt.Errorf("dedupe_cache_size must be positive")
+	}
+	if c.Behavior.RemoveMissingMaxRatio <= 0 || c.Behavior.RemoveMissingMaxRatio > 1 {
+		return fmt.Errorf("remove_missing_max_ratio must be above 0 and at most 1, got %g", c.Behavior.RemoveMissingMaxRatio)
+	}
+	if c.Behavior.MaxFileSize < 0 {
+		return fmt.Errorf("max_file_size cannot be negative, got %d", c.Behavior.MaxFileSize)
+	}
+	switch c.Behavior.ContentCheck {
+	case ContentCheckOff, ContentCheckWarn, ContentCheckSkip:
+	default:
+		return fmt.Errorf("content_check must be 'off', 'warn' or 'skip', got %q", c.Behavior.ContentCheck)
+	}
+	switch c.Behavior.WatchMode {
+	case WatchModeAuto, WatchModePoll:
+	default:
+		return fmt.Errorf("watch_mode must be 'auto' or 'poll', got %q", c.Behavior.WatchMode)
+	}
+
+	if c.Pubsub.AckWarnAfter < 0 {
+		return fmt.Errorf("pubsub.ack_warn_after cannot be negative, got %d", c.Pubsub.AckWarnAfter)
+	}
+
+	if c.Pubsub.ReachAlertAfter < 0 {
+		return fmt.Errorf("pubsub.reach_alert_after cannot be negative, got %d", c.Pubsub.ReachAlertAfter)
+	}
+
+	if c.Pubsub.MaxMemory < 0 {
+		return fmt.Errorf("pubsub.max_memory cannot be negative, got %d", c.Pubsub.MaxMemory)
+	}
 
+	if c.Behavior.PublishBatchSize < 0 {
+		return fmt.Errorf("publish_batch_size cannot be negative, got %d", c.Behavior.PublishBatchSize)
+	}
+
+	if c.Behavior.PinCheckSample < 0 {
+		return fmt.Errorf("pin_check_sample cannot be negative, got %d", c.Behavior.PinCheckSample)
+	}
+
+	if c.Behavior.ProbeSample < 0 {
+		return fmt.Errorf("probe_sample cannot be negative, got %d", c.Behavior.ProbeSample)
+	}
+
+	return nil
+}
+
+// validateListenAddress checks that addr is a multiaddr a libp2p host can listen
+// on: an IP address followed by a TCP or UDP port, without a peer ID
+func validateListenAddress(addr string) error {
+	parsed, err := ma.NewMultiaddr(addr)
+	if err != nil {
+		return fmt.Errorf("invalid multiaddr %q: %w", addr, err)
+	}
+
+	protocols := parsed.Protocols()
+	if code := protocols[0].Code; code != ma.P_IP4 && code != ma.P_IP6 {
+		return fmt.Errorf("%q must start with /ip4 or /ip6", addr)
+	}
+	hasPort := false
+	for _, p := range protocols {
+		switch p.Code {
+		case ma.P_TCP, ma.P_UDP:
+			hasPort = true
+		case ma.P_P2P:
+			return fmt.Errorf("%q cannot contain a peer ID", addr)
+		}
+	}
+	if !hasPort {
+		return fmt.Errorf("%q has no /tcp or /udp port", addr)
+	}
 	return nil
 }
 
@@ -0,0 +1,320 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadYAML writes a configuration file with a media directory and loads it
+func loadYAML(t *testing.T, yaml string) (*Config, error) {
+	t.Helper()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := "directories:\n  - " + dir + "\nextensions:\n  - mp3\n" + yaml
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestEmbeddedModeRejectsStandalonePubsubSettings(t *testing.T) {
+	for _, setting := range []string{
+		"  listen_port: 4002\n",
+		"  listen_addresses:\n    - /ip6/::/tcp/4002\n",
+		"  bootstrap_peers:\n    - /ip4/127.0.0.1/tcp/4001/p2p/12D3KooWRBy97UB99e3J6hiPesre1MZeuNQvfan4gBziswrRJsNK\n",
+		"  max_memory: 67108864\n",
+	} {
+		_, err := loadYAML(t, "ipfs:\n  mode: embedded\npubsub:\n  enabled: true\n"+setting)
+		if err == nil || !strings.Contains(err.Error(), "standalone PubSub node") {
+			t.Errorf("setting %q: Load = %v, want a standalone PubSub error", strings.TrimSpace(setting), err)
+		}
+	}
+}
+
+func TestPubsubListenAddresses(t *testing.T) {
+	cfg, err := loadYAML(t, "pubsub:\n  listen_addresses:\n    - /ip4/10.8.0.2/tcp/4002\n    - /ip6/::/udp/4002/quic-v1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Pubsub.ListenAddresses) != 2 {
+		t.Errorf("listen_addresses = %v, want both", cfg.Pubsub.ListenAddresses)
+	}
+
+	for _, bad := range []string{
+		"/ip4/10.8.0.2/tcp",
+		"/dns4/example.com/tcp/4002",
+		"/ip4/10.8.0.2",
+		"/ip4/10.8.0.2/tcp/4002/p2p/12D3KooWRBy97UB99e3J6hiPesre1MZeuNQvfan4gBziswrRJsNK",
+	} {
+		if _, err := loadYAML(t, "pubsub:\n  listen_addresses:\n    - "+bad+"\n"); err == nil || !strings.Contains(err.Error(), "pubsub.listen_addresses") {
+			t.Errorf("listen address %q: Load = %v, want a listen_addresses error", bad, err)
+		}
+	}
+
+	if _, err := loadYAML(t, "pubsub:\n  listen_port: 4002\n  listen_addresses:\n    - /ip4/10.8.0.2/tcp/4002\n"); err == nil {
+		t.Error("listen_port accepted next to listen_addresses")
+	}
+}
+
+func TestLowPowerProfileLimitsOnlyStandalonePubsub(t *testing.T) {
+	embedded, err := loadYAML(t, "behavior:\n  profile: low-power\nipfs:\n  mode: embedded\npubsub:\n  enabled: true\n")
+	if err != nil {
+		t.Fatalf("embedded low-power: %v", err)
+	}
+	if embedded.Pubsub.MaxMemory != 0 {
+		t.Errorf("embedded low-power pubsub.max_memory = %d, want 0", embedded.Pubsub.MaxMemory)
+	}
+
+	external, err := loadYAML(t, "behavior:\n  profile: low-power\nipfs:\n  mode: external\npubsub:\n  enabled: true\n")
+	if err != nil {
+		t.Fatalf("external low-power: %v", err)
+	}
+	if external.Pubsub.MaxMemory != 67108864 {
+		t.Errorf("external low-power pubsub.max_memory = %d, want 67108864", external.Pubsub.MaxMemory)
+	}
+}
+
+func TestExtensionsNormalized(t *testing.T) {
+	cfg, err := loadYAML(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Extensions = []string{"MP3", ".mp3", "mp3", " .FLAC"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	if got := strings.Join(cfg.Extensions, ","); got != "mp3,flac" {
+		t.Errorf("extensions = %q, want mp3,flac", got)
+	}
+	warned := false
+	for _, warning := range cfg.Warnings() {
+		warned = warned || strings.Contains(warning, `".mp3" "mp3"`)
+	}
+	if !warned {
+		t.Errorf("Warnings() = %q, want the ignored duplicates reported", cfg.Warnings())
+	}
+
+	for _, bad := range []string{"", "*.mp3", "tar.gz", "mp3,flac"} {
+		cfg.Extensions = []string{"mp3", bad}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("extension %q accepted", bad)
+		}
+	}
+}
+
+func TestPinStrategy(t *testing.T) {
+	cfg, err := loadYAML(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Behavior.PinStrategy != PinStrategyInline {
+		t.Errorf("default pin_strategy = %q, want %q", cfg.Behavior.PinStrategy, PinStrategyInline)
+	}
+
+	if _, err := loadYAML(t, "behavior:\n  pin_strategy: later\n"); err == nil {
+		t.Error("unknown pin_strategy accepted")
+	}
+
+	cfg, err = loadYAML(t, "behavior:\n  pin_strategy: deferred\nipfs:\n  mode: external\n  external:\n    add_options:\n      pin: false\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	warned := false
+	for _, warning := range cfg.Warnings() {
+		warned = warned || strings.Contains(warning, "pin_strategy")
+	}
+	if !warned {
+		t.Errorf("Warnings() = %q, want deferred pinning without pins reported", cfg.Warnings())
+	}
+}
+
+func TestExcludePatterns(t *testing.T) {
+	cfg, err := loadYAML(t, "behavior:\n  exclude_patterns:\n    - \"**/Thumbs.db\"\n    - \"*.nfo\"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Behavior.ExcludePatterns) != 2 {
+		t.Errorf("exclude_patterns = %q, want both", cfg.Behavior.ExcludePatterns)
+	}
+
+	for _, bad := range []string{`"[a-"`, `""`} {
+		if _, err := loadYAML(t, "behavior:\n  exclude_patterns:\n    - "+bad+"\n"); err == nil || !strings.Contains(err.Error(), "exclude_patterns") {
+			t.Errorf("pattern %s: Load = %v, want an exclude_patterns error", bad, err)
+		}
+	}
+}
+
+func TestUploadChecks(t *testing.T) {
+	cfg, err := loadYAML(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Behavior.MaxFileSize != 0 || cfg.Behavior.ContentCheck != ContentCheckOff {
+		t.Errorf("defaults max_file_size = %d, content_check = %q, want no checks", cfg.Behavior.MaxFileSize, cfg.Behavior.ContentCheck)
+	}
+
+	for _, bad := range []string{"max_file_size: -1", "content_check: fail"} {
+		if _, err := loadYAML(t, "behavior:\n  "+bad+"\n"); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+}
+
+func TestCollectionContact(t *testing.T) {
+	if _, err := loadYAML(t, "collection:\n  contact: \"mailto:ops@example.org\"\n"); err != nil {
+		t.Errorf("mailto contact rejected: %v", err)
+	}
+	if _, err := loadYAML(t, "collection:\n  contact: \"ops@example.org\"\n"); err == nil {
+		t.Error("contact without a scheme accepted")
+	}
+}
+
+func TestBootstrapPeersNormalized(t *testing.T) {
+	const peer = "12D3KooWRBy97UB99e3J6hiPesre1MZeuNQvfan4gBziswrRJsNK"
+
+	cfg, err := loadYAML(t, "ipfs:\n  mode: external\npubsub:\n  enabled: true\n  bootstrap_peers:\n"+
+		"    - "+peer+"\n"+
+		"    - /dns4/node.example.org/tcp/4001/p2p/"+peer+"\n"+
+		"  peer_addresses:\n    "+peer+":\n      - /dns4/node.example.org/tcp/4001\n      - /ip4/10.0.0.2/udp/4001/quic-v1\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "/dns4/node.example.org/tcp/4001/p2p/" + peer + " /ip4/10.0.0.2/udp/4001/quic-v1/p2p/" + peer
+	if got := strings.Join(cfg.Pubsub.BootstrapPeers, " "); got != want {
+		t.Errorf("bootstrap peers = %q, want %q", got, want)
+	}
+	warned := false
+	for _, warning := range cfg.Warnings() {
+		warned = warned || strings.Contains(warning, "bootstrap peers")
+	}
+	if !warned {
+		t.Errorf("Warnings() = %q, want the duplicate bootstrap peer reported", cfg.Warnings())
+	}
+
+	_, err = loadYAML(t, "ipfs:\n  mode: embedded\n  embedded:\n    bootstrap_peers:\n      - /ip4/10.0.0.2/tcp/4001\n")
+	if err == nil || !strings.Contains(err.Error(), `ipfs.embedded.bootstrap_peers: entry "/ip4/10.0.0.2/tcp/4001"`) {
+		t.Errorf("embedded peer without /p2p/: Load = %v, want the entry quoted", err)
+	}
+}
+
+func TestUnknownKeysFailLoad(t *testing.T) {
+	_, err := loadYAML(t, "ipfs:\n  mdoe: embedded\nbehaviour:\n  batch_size: 5\n")
+	if err == nil || !strings.Contains(err.Error(), "unknown config keys behaviour, ipfs.mdoe") {
+		t.Fatalf("Load = %v, want an error naming both unknown keys", err)
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	content := "directories:\n  - " + dir + "\nextensions:\n  - mp3\nipfs:\n  mdoe: embedded\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadWithOptions(path, LoadOptions{IgnoreUnknownKeys: true})
+	if err != nil {
+		t.Fatalf("LoadWithOptions ignoring unknown keys: %v", err)
+	}
+	warnings := strings.Join(cfg.Warnings(), "\n")
+	if !strings.Contains(warnings, "unknown config keys ipfs.mdoe are ignored") {
+		t.Errorf("warnings = %q, want the ignored key", warnings)
+	}
+}
+
+func TestSchemaDescribesEverySetting(t *testing.T) {
+	fields, err := Schema()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make(map[string]string)
+	for _, f := range fields {
+		if f.Description == "" {
+			t.Errorf("%s has no desc tag", f.Key)
+		}
+		keys[f.Key] = f.Default
+	}
+	if keys["ipfs.mode"] != "external" || keys["behavior.batch_size"] != "10" {
+		t.Errorf("ipfs.mode default %q, behavior.batch_size default %q; want the setDefaults values", keys["ipfs.mode"], keys["behavior.batch_size"])
+	}
+}
+
+func TestFailoverSettings(t *testing.T) {
+	cfg, err := loadYAML(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Failover.Enabled {
+		t.Error("failover enabled by default")
+	}
+
+	secret := strings.Repeat("0f", 32)
+	cfg, err = loadYAML(t, "failover:\n  enabled: true\n  secret: "+secret+"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, err := cfg.Failover.SecretKey(); err != nil || len(key) != 32 {
+		t.Errorf("SecretKey = %d bytes, %v; want 32 bytes", len(key), err)
+	}
+
+	for name, yaml := range map[string]string{
+		"no secret":         "failover:\n  enabled: true\n",
+		"short secret":      "failover:\n  enabled: true\n  secret: 0f0f\n",
+		"self key":          "failover:\n  enabled: true\n  secret: " + secret + "\n  snapshot_key: self\n",
+		"mirror key":        "publish:\n  mirror_keys: [backup]\nfailover:\n  enabled: true\n  secret: " + secret + "\n  snapshot_key: backup\n",
+		"too short timeout": "failover:\n  enabled: true\n  secret: " + secret + "\n  snapshot_interval: 300\n  heartbeat_timeout: 400\n",
+	} {
+		if _, err := loadYAML(t, yaml); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+}
+
+func TestControlSettings(t *testing.T) {
+	cfg, err := loadYAML(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.API.Control.Enabled || !cfg.API.Control.Loopback() {
+		t.Errorf("control enabled %v on %q by default, want disabled on loopback", cfg.API.Control.Enabled, cfg.API.Control.ListenAddr)
+	}
+
+	for name, yaml := range map[string]string{
+		"no token":     "api:\n  control:\n    enabled: true\n",
+		"invalid addr": "api:\n  control:\n    enabled: true\n    token: secret\n    listen_addr: localhost\n",
+	} {
+		if _, err := loadYAML(t, yaml); err == nil {
+			t.Errorf("%s accepted", name)
+		}
+	}
+
+	cfg, err = loadYAML(t, "api:\n  control:\n    enabled: true\n    token: secret\n    listen_addr: 0.0.0.0:9091\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warnings := strings.Join(cfg.Warnings(), "\n"); !strings.Contains(warnings, "api.control.listen_addr") {
+		t.Errorf("Warnings() = %q, want the remote control address reported", warnings)
+	}
+}
+
+func TestCircuitBreakerSettings(t *testing.T) {
+	cfg, err := loadYAML(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := cfg.IPFS.External.Breaker; b.FailureThreshold != 5 || b.CooldownDuration() != 30*time.Second {
+		t.Errorf("defaults failure_threshold = %d, cooldown = %s; want 5 and 30s", b.FailureThreshold, b.CooldownDuration())
+	}
+	if _, err := loadYAML(t, "ipfs:\n  external:\n    circuit_breaker:\n      failure_threshold: 0\n      cooldown: 0\n"); err != nil {
+		t.Errorf("disabled breaker rejected: %v", err)
+	}
+
+	for _, bad := range []string{"failure_threshold: -1", "cooldown: 0"} {
+		if _, err := loadYAML(t, "ipfs:\n  external:\n    circuit_breaker:\n      "+bad+"\n"); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+}
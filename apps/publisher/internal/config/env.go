@@ -0,0 +1,119 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/atregu/ipfs-common/configschema"
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix starts the names of the environment variables that override
+// settings, e.g. IPFS_PUBLISHER_IPFS_MODE for ipfs.mode
+const EnvPrefix = "IPFS_PUBLISHER"
+
+// envKeyReplacer turns the dots of a setting's key into the underscores of its
+// environment variable
+var envKeyReplacer = strings.NewReplacer(".", "_")
+
+// envAddOptions are the add_options read by the publisher, with their types.
+// add_options is a map, so its entries have no setting of their own.
+var envAddOptions = []struct {
+	name, typ string
+}{
+	{"pin", "bool"},
+	{"nocopy", "bool"},
+	{"chunker", "string"},
+	{"raw_leaves", "bool"},
+}
+
+// EnvSetting is a setting that an environment variable overrides
+type EnvSetting struct {
+	configschema.Field
+	Variable string
+}
+
+// EnvVar returns the environment variable overriding the setting key
+func EnvVar(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(envKeyReplacer.Replace(key))
+}
+
+// EnvSettings lists the settings environment variables override, in schema
+// order. Lists take comma-separated values. Maps other than add_options, e.g.
+// peer_addresses, can only be set in the config file.
+func EnvSettings() ([]EnvSetting, error) {
+	fields, err := Schema()
+	if err != nil {
+		return nil, err
+	}
+	return envSettings(fields), nil
+}
+
+// WriteEnvSettings prints settings as a table of variable, key, type and
+// description
+func WriteEnvSettings(w io.Writer, settings []EnvSetting) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VARIABLE\tKEY\tTYPE\tDESCRIPTION")
+	for _, s := range settings {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", s.Variable, s.Key, s.Type, s.Description)
+	}
+	return tw.Flush()
+}
+
+// envSettings returns the settings of fields environment variables override
+func envSettings(fields []configschema.Field) []EnvSetting {
+	var settings []EnvSetting
+	for _, f := range fields {
+		if !strings.HasPrefix(f.Type, "map[") {
+			settings = append(settings, EnvSetting{Field: f, Variable: EnvVar(f.Key)})
+			continue
+		}
+		if !strings.HasSuffix(f.Key, ".add_options") {
+			continue
+		}
+		for _, option := range envAddOptions {
+			key := f.Key + "." + option.name
+			settings = append(settings, EnvSetting{
+				Field:    configschema.Field{Key: key, Type: option.typ, Description: "The " + option.name + " entry of " + f.Key},
+				Variable: EnvVar(key),
+			})
+		}
+	}
+	return settings
+}
+
+// bindEnv lets environment variables override every setting of v. Viper only
+// looks up variables of the keys it knows, so each one is bound explicitly,
+// also those without a default.
+func bindEnv(v *viper.Viper) error {
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(envKeyReplacer)
+	v.AutomaticEnv()
+
+	for _, s := range envSettings(configschema.Fields(&Config{})) {
+		if err := v.BindEnv(s.Key); err != nil {
+			return fmt.Errorf("failed to bind %s: %w", s.Variable, err)
+		}
+	}
+	return nil
+}
+
+// normalizeAddOptions parses the boolean add_options given as strings, as
+// environment variables set them, so they are not taken for unset
+func normalizeAddOptions(key string, options map[string]interface{}) error {
+	for _, option := range envAddOptions {
+		s, ok := options[option.name].(string)
+		if !ok || option.typ != "bool" {
+			continue
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("%s.%s must be true or false, got %q", key, option.name, s)
+		}
+		options[option.name] = b
+	}
+	return nil
+}
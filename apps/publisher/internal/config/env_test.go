@@ -0,0 +1,75 @@
+package config
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEnvOverridesFile(t *testing.T) {
+	t.Setenv("IPFS_PUBLISHER_IPFS_MODE", "embedded")
+	t.Setenv("IPFS_PUBLISHER_BEHAVIOR_BATCH_SIZE", "25")
+	t.Setenv("IPFS_PUBLISHER_BEHAVIOR_EXTRACT_METADATA", "true")
+	t.Setenv("IPFS_PUBLISHER_EXTENSIONS", "flac,.OGG")
+	t.Setenv("IPFS_PUBLISHER_IPFS_EMBEDDED_ADD_OPTIONS_PIN", "false")
+	// Settings without a default are bound too
+	t.Setenv("IPFS_PUBLISHER_COLLECTION_CONTACT", "mailto:curator@example.com")
+
+	cfg, err := loadYAML(t, "ipfs:\n  mode: external\n  embedded:\n    add_options:\n      chunker: size-262144\nbehavior:\n  batch_size: 5\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.IPFS.Mode != IPFSModeEmbedded || cfg.Behavior.BatchSize != 25 || !cfg.Behavior.ExtractMetadata {
+		t.Errorf("ipfs.mode %q, batch_size %d, extract_metadata %v; want the environment to win", cfg.IPFS.Mode, cfg.Behavior.BatchSize, cfg.Behavior.ExtractMetadata)
+	}
+	if !slices.Equal(cfg.Extensions, []string{"flac", "ogg"}) {
+		t.Errorf("extensions = %v, want the comma-separated list of the environment", cfg.Extensions)
+	}
+	if pin, ok := cfg.IPFS.Embedded.Options["pin"].(bool); !ok || pin {
+		t.Errorf("add_options pin = %#v, want false", cfg.IPFS.Embedded.Options["pin"])
+	}
+	if cfg.IPFS.Embedded.Options["chunker"] != "size-262144" {
+		t.Errorf("add_options = %v, want the chunker of the file kept", cfg.IPFS.Embedded.Options)
+	}
+	if cfg.Collection.Contact != "mailto:curator@example.com" {
+		t.Errorf("collection.contact = %q, want the environment's", cfg.Collection.Contact)
+	}
+
+	t.Setenv("IPFS_PUBLISHER_IPFS_EMBEDDED_ADD_OPTIONS_PIN", "maybe")
+	if _, err := loadYAML(t, ""); err == nil || !strings.Contains(err.Error(), "ipfs.embedded.add_options.pin") {
+		t.Errorf("Load = %v, want an add_options.pin error", err)
+	}
+}
+
+func TestEnvSettings(t *testing.T) {
+	settings, err := EnvSettings()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vars := make(map[string]string)
+	for _, s := range settings {
+		vars[s.Variable] = s.Key
+	}
+	for variable, key := range map[string]string{
+		"IPFS_PUBLISHER_IPFS_MODE":                        "ipfs.mode",
+		"IPFS_PUBLISHER_PUBSUB_BOOTSTRAP_PEERS":           "pubsub.bootstrap_peers",
+		"IPFS_PUBLISHER_IPFS_EXTERNAL_ADD_OPTIONS_NOCOPY": "ipfs.external.add_options.nocopy",
+	} {
+		if vars[variable] != key {
+			t.Errorf("%s overrides %q, want %s", variable, vars[variable], key)
+		}
+	}
+	if _, ok := vars["IPFS_PUBLISHER_PUBSUB_PEER_ADDRESSES"]; ok {
+		t.Error("peer_addresses listed, but maps cannot be set from the environment")
+	}
+
+	var buf bytes.Buffer
+	if err := WriteEnvSettings(&buf, settings); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "VARIABLE") || !strings.Contains(buf.String(), "IPFS_PUBLISHER_IPFS_MODE ") {
+		t.Errorf("table lacks the header or IPFS_PUBLISHER_IPFS_MODE:\n%s", buf.String())
+	}
+}
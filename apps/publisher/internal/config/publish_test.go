@@ -0,0 +1,49 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRepublishInterval(t *testing.T) {
+	tests := []struct {
+		lifetime string
+		want     time.Duration
+	}{
+		{"24h", 12 * time.Hour},
+		{"720h", 360 * time.Hour},
+		{"", DefaultIPNSLifetime / 2},
+		{"invalid", DefaultIPNSLifetime / 2},
+	}
+
+	for _, tt := range tests {
+		p := &PublishConfig{IPNSLifetime: tt.lifetime}
+		if got := p.RepublishInterval(); got != tt.want {
+			t.Errorf("RepublishInterval() with lifetime %q = %s, want %s", tt.lifetime, got, tt.want)
+		}
+	}
+}
+
+func TestPublishLayout(t *testing.T) {
+	cfg, err := loadYAML(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Publish.Layout != LayoutNDJSON || cfg.Publish.Tree() {
+		t.Errorf("default layout = %q, want %q without a tree", cfg.Publish.Layout, LayoutNDJSON)
+	}
+
+	for _, layout := range []string{LayoutDirectory, LayoutBoth} {
+		cfg, err := loadYAML(t, "publish:\n  layout: "+layout+"\n")
+		if err != nil {
+			t.Fatalf("layout %s: %v", layout, err)
+		}
+		if !cfg.Publish.Tree() {
+			t.Errorf("layout %s builds no tree", layout)
+		}
+	}
+
+	if _, err := loadYAML(t, "publish:\n  layout: tree\n"); err == nil {
+		t.Error("unknown layout accepted")
+	}
+}
@@ -0,0 +1,396 @@
+package failover
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/ipfs"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// ErrFenced means another publisher took over the collection; this one must
+// not publish anymore
+var ErrFenced = errors.New("another publisher took over the collection")
+
+// maxManifestSize bounds the sealed manifest read from the network
+const maxManifestSize = 64 << 10
+
+// Node publishes and restores the snapshots of one publisher instance
+type Node struct {
+	client     ipfs.Client
+	cfg        *config.FailoverConfig
+	ipnsOpts   ipfs.IPNSPublishOptions
+	instanceID string
+	key        []byte
+	signer     ed25519.PrivateKey
+	id         string
+
+	since       time.Time // Manifests of other publishers written after it fence this one
+	last        *Manifest // Last manifest written by this node, or its own found at start
+	manifestCID string    // CID of last, if written by this node
+
+	fenceOnce sync.Once
+	fenced    chan struct{}
+	trigger   chan struct{}
+}
+
+// New returns the failover node of a publisher with ID id. Manifests are
+// signed with signer, the publisher's default key.
+func New(client ipfs.Client, cfg *config.Config, signer ed25519.PrivateKey, id string) (*Node, error) {
+	key, err := cfg.Failover.SecretKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Node{
+		client: client,
+		cfg:    &cfg.Failover,
+		ipnsOpts: ipfs.IPNSPublishOptions{
+			Key:      cfg.Failover.SnapshotKey,
+			Lifetime: cfg.Publish.Lifetime().String(),
+			// Resolvers must not serve a heartbeat older than the next one
+			TTL: cfg.Failover.Interval().String(),
+		},
+		instanceID: cfg.Behavior.InstanceID,
+		key:        key,
+		signer:     signer,
+		id:         id,
+		since:      time.Now(),
+		fenced:     make(chan struct{}),
+		trigger:    make(chan struct{}, 1),
+	}, nil
+}
+
+// LoadID returns the publisher ID stored at path, creating a random one on
+// first use. It tells the manifests of the primary and the standby apart.
+func LoadID(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if id := strings.TrimSpace(string(data)); id != "" {
+			return id, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read publisher ID: %w", err)
+	}
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate publisher ID: %w", err)
+	}
+	id := hex.EncodeToString(buf)
+	if err := writeFile(path, []byte(id+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to save publisher ID: %w", err)
+	}
+	return id, nil
+}
+
+// ID returns the publisher ID of the node
+func (n *Node) ID() string {
+	return n.id
+}
+
+// Name returns the IPNS name of the snapshot key
+func (n *Node) Name(ctx context.Context) (string, error) {
+	return n.client.EnsureKey(ctx, n.cfg.SnapshotKey)
+}
+
+// Fetch resolves the snapshot key and returns the latest manifest
+func (n *Node) Fetch(ctx context.Context) (*Manifest, error) {
+	_, sealed, err := n.fetchSealed(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return decodeManifest(n.key, n.signer.Public().(ed25519.PublicKey), sealed)
+}
+
+// fetchSealed resolves the snapshot key and reads the sealed manifest it points at
+func (n *Node) fetchSealed(ctx context.Context) (string, []byte, error) {
+	name, err := n.Name(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	resolved, err := n.client.ResolveIPNS(ctx, name)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to resolve snapshot name %s: %w", name, err)
+	}
+	cid := strings.TrimPrefix(resolved, "/ipfs/")
+	sealed, err := n.cat(ctx, cid, maxManifestSize)
+	return cid, sealed, err
+}
+
+// CheckStart refuses to start publishing while another publisher writes
+// heartbeats, or if its last snapshot has a newer version than version, the
+// local one. Without a manifest to compare with it only warns.
+func (n *Node) CheckStart(ctx context.Context, version int) error {
+	log := logger.Get()
+	n.since = time.Now()
+
+	cid, sealed, err := n.fetchSealed(ctx)
+	if err != nil {
+		log.Warnf("No failover snapshot found, starting as the primary: %v", err)
+		return nil
+	}
+	m, err := decodeManifest(n.key, n.signer.Public().(ed25519.PublicKey), sealed)
+	if err != nil {
+		return fmt.Errorf("failed to read failover manifest: %w", err)
+	}
+
+	if m.PublisherID == n.id {
+		n.last, n.manifestCID = m, cid
+		return nil
+	}
+	if age := m.Age(n.since); age < n.cfg.Timeout() {
+		return fmt.Errorf("publisher %s wrote a failover heartbeat %s ago; refusing to publish while it is active", m.PublisherID, age.Round(time.Second))
+	}
+	if m.Version > version {
+		return fmt.Errorf("the last snapshot of publisher %s has version %d, newer than the local version %d; run ipfs-publisher standby --takeover to continue from it", m.PublisherID, m.Version, version)
+	}
+	log.Warnf("Publisher %s stopped writing failover heartbeats %s ago; continuing as the primary", m.PublisherID, m.Age(n.since).Round(time.Second))
+	return nil
+}
+
+// Claim publishes a manifest of the state and index files as this node's,
+// ending the heartbeat of the previous primary. Its manifest does not fence
+// this node, even if written in the last moment.
+func (n *Node) Claim(ctx context.Context, statePath, indexPath string) error {
+	n.since = time.Now()
+	return n.Heartbeat(ctx, statePath, indexPath)
+}
+
+// Heartbeat uploads what changed of the state and index files and publishes a
+// fresh manifest of them. It returns ErrFenced if another publisher wrote a
+// manifest since this one started.
+func (n *Node) Heartbeat(ctx context.Context, statePath, indexPath string) error {
+	log := logger.Get()
+
+	if m, err := n.Fetch(ctx); err != nil {
+		log.Debugf("Failed to read the current failover manifest: %v", err)
+	} else if m.PublisherID != n.id && m.Heartbeat > n.since.Unix() {
+		n.fenceOnce.Do(func() { close(n.fenced) })
+		return fmt.Errorf("%w: publisher %s wrote a heartbeat at %s", ErrFenced, m.PublisherID, m.HeartbeatTime().Format(time.RFC3339))
+	}
+
+	// A new publisher has neither file until it saves them; its snapshot is empty
+	stateData, err := os.ReadFile(statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read state: %w", err)
+	}
+	var published struct {
+		Version     int    `json:"version"`
+		LastRootCID string `json:"lastRootCID"`
+	}
+	if len(stateData) > 0 {
+		if err := json.Unmarshal(stateData, &published); err != nil {
+			return fmt.Errorf("failed to parse state: %w", err)
+		}
+	}
+	indexData, err := os.ReadFile(indexPath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	m := &Manifest{
+		PublisherID: n.id,
+		InstanceID:  n.instanceID,
+		Version:     published.Version,
+		RootCID:     published.LastRootCID,
+		Heartbeat:   time.Now().Unix(),
+	}
+	prev := n.last
+	if prev == nil {
+		prev = &Manifest{}
+	}
+	if m.StateCID, m.StateSHA256, err = n.upload(ctx, "state.json.enc", stateData, prev.StateCID, prev.StateSHA256); err != nil {
+		return err
+	}
+	if m.IndexCID, m.IndexSHA256, err = n.upload(ctx, "collection.ndjson.enc", indexData, prev.IndexCID, prev.IndexSHA256); err != nil {
+		return err
+	}
+
+	sealed, err := encodeManifest(n.key, n.signer, m)
+	if err != nil {
+		return err
+	}
+	added, err := n.client.Add(ctx, bytes.NewReader(sealed), "manifest.enc", ipfs.AddOptions{Pin: true})
+	if err != nil {
+		return fmt.Errorf("failed to upload failover manifest: %w", err)
+	}
+	if _, err := n.client.PublishIPNS(ctx, added.CID, n.ipnsOpts); err != nil {
+		return fmt.Errorf("failed to publish failover manifest: %w", err)
+	}
+	log.Debugf("Wrote failover heartbeat for version %d: %s", m.Version, added.CID)
+
+	// The snapshot just replaced is no longer needed
+	for _, replaced := range []struct{ old, new string }{
+		{n.manifestCID, added.CID},
+		{prev.StateCID, m.StateCID},
+		{prev.IndexCID, m.IndexCID},
+	} {
+		if replaced.old == "" || replaced.old == replaced.new {
+			continue
+		}
+		if err := n.client.Unpin(ctx, replaced.old); err != nil {
+			log.Debugf("Failed to unpin replaced snapshot %s: %v", replaced.old, err)
+		}
+	}
+	n.last, n.manifestCID = m, added.CID
+	return nil
+}
+
+// upload seals and adds data unless its hash equals prevHash, in which case the
+// previous upload prevCID is reused
+func (n *Node) upload(ctx context.Context, name string, data []byte, prevCID, prevHash string) (string, string, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	if prevCID != "" && hash == prevHash {
+		return prevCID, hash, nil
+	}
+
+	sealed, err := seal(n.key, data)
+	if err != nil {
+		return "", "", err
+	}
+	added, err := n.client.Add(ctx, bytes.NewReader(sealed), name, ipfs.AddOptions{Pin: true})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to upload %s: %w", name, err)
+	}
+	return added.CID, hash, nil
+}
+
+// Restore downloads the snapshot of m and replaces the index and state files
+// with it. The index is written first, as by a publish. Files the primary had
+// not saved yet are left alone.
+func (n *Node) Restore(ctx context.Context, m *Manifest, statePath, indexPath string) error {
+	indexData, err := n.download(ctx, m.IndexCID, m.IndexSHA256)
+	if err != nil {
+		return fmt.Errorf("failed to download index snapshot: %w", err)
+	}
+	stateData, err := n.download(ctx, m.StateCID, m.StateSHA256)
+	if err != nil {
+		return fmt.Errorf("failed to download state snapshot: %w", err)
+	}
+
+	if len(indexData) > 0 {
+		if err := writeFile(indexPath, indexData, 0644); err != nil {
+			return fmt.Errorf("failed to write index: %w", err)
+		}
+	}
+	if len(stateData) > 0 {
+		if err := writeFile(statePath, stateData, 0644); err != nil {
+			return fmt.Errorf("failed to write state: %w", err)
+		}
+	}
+	return nil
+}
+
+// download reads and opens a sealed snapshot file and checks its hash
+func (n *Node) download(ctx context.Context, cid, hash string) ([]byte, error) {
+	sealed, err := n.cat(ctx, cid, -1)
+	if err != nil {
+		return nil, err
+	}
+	data, err := open(n.key, sealed)
+	if err != nil {
+		return nil, err
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf("content of %s does not match the manifest", cid)
+	}
+	return data, nil
+}
+
+// cat reads the content of cid, at most limit bytes unless limit is negative
+func (n *Node) cat(ctx context.Context, cid string, limit int64) ([]byte, error) {
+	reader, err := n.client.Cat(ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	var r io.Reader = reader
+	if limit >= 0 {
+		r = io.LimitReader(reader, limit+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", cid, err)
+	}
+	if limit >= 0 && int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s is larger than %d bytes", cid, limit)
+	}
+	return data, nil
+}
+
+// Run writes a heartbeat every failover.snapshot_interval and after each
+// Trigger until ctx is done or another publisher took over
+func (n *Node) Run(ctx context.Context, statePath, indexPath string) {
+	log := logger.Get()
+
+	ticker := time.NewTicker(n.cfg.Interval())
+	defer ticker.Stop()
+
+	for {
+		err := n.Heartbeat(ctx, statePath, indexPath)
+		switch {
+		case errors.Is(err, ErrFenced):
+			log.Error("!!! ==========================================================")
+			log.Errorf("!!! %v", err)
+			log.Error("!!! Two publishers were active at once: this one stops publishing")
+			log.Error("!!! Run it as the standby (ipfs-publisher standby --sync) or stop the other one")
+			log.Error("!!! ==========================================================")
+			return
+		case err != nil && ctx.Err() == nil:
+			log.Warnf("Failed to write failover heartbeat: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.trigger:
+		}
+	}
+}
+
+// Trigger asks Run for a heartbeat right away, e.g. to snapshot a new version
+func (n *Node) Trigger() {
+	select {
+	case n.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Fenced returns a channel that is closed once another publisher took over
+func (n *Node) Fenced() <-chan struct{} {
+	return n.fenced
+}
+
+// writeFile writes data to path atomically through a temporary file
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
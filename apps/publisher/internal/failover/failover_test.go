@@ -0,0 +1,323 @@
+package failover
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/ipfs"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// network is the content and IPNS records shared by the fake nodes
+type network struct {
+	blocks  map[string][]byte
+	records map[string]string // IPNS name -> CID
+}
+
+// fakeNode is an ipfs.Client on a shared network. Every node holds the same
+// snapshot key, as both publishers must. Methods the tests do not use panic
+// through the nil embedded interface.
+type fakeNode struct {
+	ipfs.Client
+	net      *network
+	adds     []string // Filenames passed to Add, in order
+	unpinned []string
+}
+
+func (c *fakeNode) Add(ctx context.Context, reader io.Reader, filename string, opts ipfs.AddOptions) (*ipfs.AddResult, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	cid := "Qm" + hex.EncodeToString(sum[:8])
+	c.net.blocks[cid] = data
+	c.adds = append(c.adds, filename)
+	return &ipfs.AddResult{CID: cid, Size: uint64(len(data)), Name: filename}, nil
+}
+
+func (c *fakeNode) Cat(ctx context.Context, cid string) (io.ReadCloser, error) {
+	data, ok := c.net.blocks[cid]
+	if !ok {
+		return nil, fmt.Errorf("%s not found", cid)
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (c *fakeNode) Unpin(ctx context.Context, cid string) error {
+	c.unpinned = append(c.unpinned, cid)
+	return nil
+}
+
+func (c *fakeNode) EnsureKey(ctx context.Context, name string) (string, error) {
+	return "k51" + name, nil
+}
+
+func (c *fakeNode) PublishIPNS(ctx context.Context, cid string, opts ipfs.IPNSPublishOptions) (*ipfs.IPNSPublishResult, error) {
+	name := "k51" + opts.Key
+	c.net.records[name] = cid
+	return &ipfs.IPNSPublishResult{Name: name, Value: "/ipfs/" + cid}, nil
+}
+
+func (c *fakeNode) ResolveIPNS(ctx context.Context, name string) (string, error) {
+	cid, ok := c.net.records[name]
+	if !ok {
+		return "", errors.New("could not resolve name")
+	}
+	return "/ipfs/" + cid, nil
+}
+
+// testConfig returns failover settings shared by the primary and the standby
+func testConfig() *config.Config {
+	return &config.Config{
+		Failover: config.FailoverConfig{
+			Enabled:          true,
+			Secret:           strings.Repeat("ab", 32),
+			SnapshotKey:      "publisher-failover",
+			SnapshotInterval: 300,
+			HeartbeatTimeout: 900,
+		},
+		Behavior: config.BehaviorConfig{InstanceID: "default"},
+	}
+}
+
+// publisher is one machine: a failover node with its own state and index files
+type publisher struct {
+	node      *Node
+	client    *fakeNode
+	statePath string
+	indexPath string
+}
+
+func newPublisher(t *testing.T, net *network, cfg *config.Config, signer ed25519.PrivateKey, id string) *publisher {
+	t.Helper()
+	client := &fakeNode{net: net}
+	node, err := New(client, cfg, signer, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	return &publisher{node: node, client: client, statePath: filepath.Join(dir, "state.json"), indexPath: filepath.Join(dir, "collection.ndjson")}
+}
+
+// write replaces the state and index files of the publisher
+func (p *publisher) write(t *testing.T, version int, index string) {
+	t.Helper()
+	state := fmt.Sprintf(`{"version": %d, "lastRootCID": "root-%d"}`, version, version)
+	if err := os.WriteFile(p.statePath, []byte(state), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p.indexPath, []byte(index), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setup returns a primary and a standby sharing the network, config and keys
+func setup(t *testing.T) (primary, standby *publisher) {
+	t.Helper()
+	logger.Get().SetOutput(io.Discard)
+
+	_, signer, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	net := &network{blocks: make(map[string][]byte), records: make(map[string]string)}
+	cfg := testConfig()
+	return newPublisher(t, net, cfg, signer, "primary"), newPublisher(t, net, cfg, signer, "standby")
+}
+
+func TestManifestSealedAndSigned(t *testing.T) {
+	_, signer, _ := ed25519.GenerateKey(nil)
+	otherPublic, _, _ := ed25519.GenerateKey(nil)
+	key := bytes.Repeat([]byte{1}, 32)
+
+	m := &Manifest{PublisherID: "primary", Version: 7, Heartbeat: 1700000000}
+	sealed, err := encodeManifest(key, signer, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sealed, []byte("primary")) {
+		t.Error("sealed manifest contains the publisher ID in the clear")
+	}
+
+	decoded, err := decodeManifest(key, signer.Public().(ed25519.PublicKey), sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *decoded != *m {
+		t.Errorf("decoded %+v, want %+v", *decoded, *m)
+	}
+
+	if _, err := decodeManifest(bytes.Repeat([]byte{2}, 32), signer.Public().(ed25519.PublicKey), sealed); err == nil {
+		t.Error("manifest opened with another secret")
+	}
+	if _, err := decodeManifest(key, otherPublic, sealed); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("manifest of another publisher key: err = %v, want ErrBadSignature", err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := decodeManifest(key, signer.Public().(ed25519.PublicKey), sealed); err == nil {
+		t.Error("tampered manifest accepted")
+	}
+}
+
+func TestHeartbeatAndRestore(t *testing.T) {
+	primary, standby := setup(t)
+	ctx := context.Background()
+
+	primary.write(t, 3, "index v3\n")
+	if err := primary.node.Heartbeat(ctx, primary.statePath, primary.indexPath); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := standby.node.Fetch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.PublisherID != "primary" || m.Version != 3 || m.RootCID != "root-3" {
+		t.Errorf("manifest = %+v, want version 3 of the primary", *m)
+	}
+	if err := standby.node.Restore(ctx, m, standby.statePath, standby.indexPath); err != nil {
+		t.Fatal(err)
+	}
+	for _, paths := range [][2]string{{primary.statePath, standby.statePath}, {primary.indexPath, standby.indexPath}} {
+		want, _ := os.ReadFile(paths[0])
+		if got, _ := os.ReadFile(paths[1]); !bytes.Equal(got, want) {
+			t.Errorf("restored %s = %q, want %q", filepath.Base(paths[1]), got, want)
+		}
+	}
+
+	// An unchanged index is not uploaded again; the replaced state is unpinned
+	oldState := m.StateCID
+	primary.write(t, 3, "index v3\n")
+	if err := os.WriteFile(primary.statePath, []byte(`{"version": 3, "lastRootCID": "root-3", "acks": {}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	primary.client.adds = nil
+	if err := primary.node.Heartbeat(ctx, primary.statePath, primary.indexPath); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"state.json.enc", "manifest.enc"}; fmt.Sprint(primary.client.adds) != fmt.Sprint(want) {
+		t.Errorf("second heartbeat added %v, want %v", primary.client.adds, want)
+	}
+	if !strings.Contains(fmt.Sprint(primary.client.unpinned), oldState) {
+		t.Errorf("replaced state %s not unpinned: %v", oldState, primary.client.unpinned)
+	}
+
+	// The stored snapshot is encrypted
+	for cid, data := range primary.client.net.blocks {
+		if bytes.Contains(data, []byte("index v3")) || bytes.Contains(data, []byte("lastRootCID")) {
+			t.Errorf("block %s holds the snapshot in the clear", cid)
+		}
+	}
+}
+
+func TestCheckStart(t *testing.T) {
+	primary, standby := setup(t)
+	ctx := context.Background()
+
+	// Nothing published yet
+	if err := primary.node.CheckStart(ctx, 0); err != nil {
+		t.Fatalf("first start refused: %v", err)
+	}
+
+	primary.write(t, 5, "index v5\n")
+	if err := primary.node.Heartbeat(ctx, primary.statePath, primary.indexPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// The primary restarts; the standby must not start next to it
+	if err := primary.node.CheckStart(ctx, 5); err != nil {
+		t.Errorf("restart of the primary refused: %v", err)
+	}
+	if err := standby.node.CheckStart(ctx, 5); err == nil || !strings.Contains(err.Error(), "active") {
+		t.Errorf("start next to an active primary: err = %v, want refusal", err)
+	}
+
+	// Once the heartbeat is stale, only a standby with the latest version may start
+	m, _ := standby.node.Fetch(ctx)
+	m.Heartbeat = time.Now().Add(-time.Hour).Unix()
+	sealed, err := encodeManifest(primary.node.key, primary.node.signer, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	added, _ := primary.client.Add(ctx, bytes.NewReader(sealed), "manifest.enc", ipfs.AddOptions{})
+	primary.client.net.records["k51publisher-failover"] = added.CID
+
+	if err := standby.node.CheckStart(ctx, 4); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("start with an older version: err = %v, want refusal", err)
+	}
+	if err := standby.node.CheckStart(ctx, 5); err != nil {
+		t.Errorf("start after the heartbeat stopped refused: %v", err)
+	}
+}
+
+func TestTakeoverFencesPrimary(t *testing.T) {
+	primary, standby := setup(t)
+	ctx := context.Background()
+
+	if err := primary.node.CheckStart(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+	primary.node.since = primary.node.since.Add(-time.Minute) // Heartbeats have a resolution of a second
+	primary.write(t, 2, "index v2\n")
+	if err := primary.node.Heartbeat(ctx, primary.statePath, primary.indexPath); err != nil {
+		t.Fatal(err)
+	}
+
+	// The primary's heartbeat is not missed in time, but the standby takes over anyway
+	m, err := standby.node.Fetch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := standby.node.Restore(ctx, m, standby.statePath, standby.indexPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := standby.node.Claim(ctx, standby.statePath, standby.indexPath); err != nil {
+		t.Fatal(err)
+	}
+
+	err = primary.node.Heartbeat(ctx, primary.statePath, primary.indexPath)
+	if !errors.Is(err, ErrFenced) {
+		t.Fatalf("heartbeat after the takeover: err = %v, want ErrFenced", err)
+	}
+	select {
+	case <-primary.node.Fenced():
+	default:
+		t.Error("Fenced not closed")
+	}
+
+	// The new primary keeps its heartbeat
+	if err := standby.node.Heartbeat(ctx, standby.statePath, standby.indexPath); err != nil {
+		t.Errorf("heartbeat of the new primary: %v", err)
+	}
+	if m, _ := standby.node.Fetch(ctx); m.PublisherID != "standby" || m.Version != 2 {
+		t.Errorf("manifest after the takeover = %+v, want version 2 of the standby", *m)
+	}
+}
+
+func TestLoadID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "failover-id")
+	id, err := LoadID(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := LoadID(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id == "" || again != id {
+		t.Errorf("LoadID = %q, then %q; want the same ID", id, again)
+	}
+}
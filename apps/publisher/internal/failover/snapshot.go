@@ -0,0 +1,132 @@
+// Package failover keeps a warm standby publisher ready to take over the
+// collection. The primary uploads its state and index files encrypted with a
+// shared key and publishes a signed manifest of them, renewed as a heartbeat,
+// under a dedicated IPNS key. The standby restores the latest snapshot and
+// takes over once the heartbeat stops.
+package failover
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// sealedData is the additional data of every sealed blob, so data sealed for
+// another purpose with the same key does not open as a snapshot
+var sealedData = []byte("ipfs-publisher failover snapshot v1")
+
+// ErrBadSignature means a manifest was not signed by the publisher key
+var ErrBadSignature = errors.New("manifest is not signed by this publisher's key")
+
+// seal encrypts data with AES-256-GCM under key and prepends the random nonce
+func seal(key, data []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return aead.Seal(nonce, nonce, data, sealedData), nil
+}
+
+// open decrypts data sealed by seal
+func open(key, sealed []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("sealed data too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	data, err := aead.Open(nil, nonce, ciphertext, sealedData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt snapshot (different failover.secret?): %w", err)
+	}
+	return data, nil
+}
+
+// newAEAD returns AES-256-GCM with key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Manifest describes the latest snapshot of a publisher. Its heartbeat is
+// renewed every failover.snapshot_interval while the publisher runs.
+type Manifest struct {
+	PublisherID string `json:"publisher_id"` // ID of the machine that wrote it, see LoadID
+	InstanceID  string `json:"instance_id"`
+	Version     int    `json:"version"`  // Collection version of the state snapshot
+	RootCID     string `json:"root_cid"` // Root CID published for that version
+	StateCID    string `json:"state_cid"`
+	StateSHA256 string `json:"state_sha256"` // Of the plain state file
+	IndexCID    string `json:"index_cid"`
+	IndexSHA256 string `json:"index_sha256"` // Of the plain index file
+	Heartbeat   int64  `json:"heartbeat"`    // Unix time the manifest was written
+}
+
+// HeartbeatTime returns when the manifest was written
+func (m *Manifest) HeartbeatTime() time.Time {
+	return time.Unix(m.Heartbeat, 0)
+}
+
+// Age returns how long before now the manifest was written
+func (m *Manifest) Age(now time.Time) time.Duration {
+	return now.Sub(m.HeartbeatTime())
+}
+
+// signedManifest is the sealed form of a Manifest
+type signedManifest struct {
+	Manifest  json.RawMessage `json:"manifest"`
+	Signature []byte          `json:"signature"` // Ed25519 signature of Manifest by the publisher key
+}
+
+// encodeManifest signs m with signer and seals it with key
+func encodeManifest(key []byte, signer ed25519.PrivateKey, m *Manifest) ([]byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	signed, err := json.Marshal(&signedManifest{Manifest: data, Signature: ed25519.Sign(signer, data)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal signed manifest: %w", err)
+	}
+	return seal(key, signed)
+}
+
+// decodeManifest opens a manifest sealed by encodeManifest and checks that
+// publicKey signed it
+func decodeManifest(key []byte, publicKey ed25519.PublicKey, sealed []byte) (*Manifest, error) {
+	data, err := open(key, sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	var signed signedManifest
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, fmt.Errorf("failed to parse signed manifest: %w", err)
+	}
+	if !ed25519.Verify(publicKey, signed.Manifest, signed.Signature) {
+		return nil, ErrBadSignature
+	}
+
+	var m Manifest
+	if err := json.Unmarshal(signed.Manifest, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &m, nil
+}
@@ -0,0 +1,125 @@
+package index
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Delta operations
+const (
+	DeltaOpUpsert = "upsert"
+	DeltaOpRemove = "remove"
+)
+
+// deltaType marks the header line of a delta file
+const deltaType = "delta"
+
+// DeltaHeader is the first line of a delta file. Applying the delta to the
+// index published as BaseVersion (BaseIndexCID) must yield ItemCount records.
+type DeltaHeader struct {
+	Type         string `json:"type"`
+	Version      int    `json:"version"`
+	BaseVersion  int    `json:"baseVersion"`
+	BaseIndexCID string `json:"baseIndexCID"`
+	ItemCount    int    `json:"itemCount"`
+}
+
+// DeltaRecord is a single change in a delta file. Removals only need the CID.
+type DeltaRecord struct {
+	Op string `json:"op"`
+	Record
+}
+
+// DeltaFileName returns the name of the delta file for a version
+func DeltaFileName(version int) string {
+	return fmt.Sprintf("changes-v%d.ndjson", version)
+}
+
+// MarkPublished records the current records as the base for the next delta
+func (m *Manager) MarkPublished() {
+	m.published = make(map[string]Record, len(m.records))
+	for path, record := range m.records {
+		m.published[path] = *record
+	}
+}
+
+// ForgetPublished drops the base of the next delta, so the next version is
+// published without one and indexers fetch the full index instead
+func (m *Manager) ForgetPublished() {
+	m.published = nil
+}
+
+// Modified reports whether any record differs from the last MarkPublished.
+// It returns false if there is no published base.
+func (m *Manager) Modified() bool {
+	if m.published == nil {
+		return false
+	}
+	if len(m.published) != len(m.records) {
+		return true
+	}
+	for path, record := range m.records {
+		if old, existed := m.published[path]; !existed || old != *record {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildDelta returns the changes since the last MarkPublished as a delta file.
+// A record whose CID changed is emitted as a removal of the old CID followed by
+// an upsert of the new one. It returns nil if there is no published base.
+func (m *Manager) BuildDelta(version, baseVersion int, baseIndexCID string) ([]byte, error) {
+	if m.published == nil || baseIndexCID == "" {
+		return nil, nil
+	}
+
+	var changes []DeltaRecord
+	for path, record := range m.records {
+		old, existed := m.published[path]
+		switch {
+		case !existed:
+			changes = append(changes, DeltaRecord{Op: DeltaOpUpsert, Record: *record})
+		case old.CID != record.CID:
+			changes = append(changes, DeltaRecord{Op: DeltaOpRemove, Record: old})
+			changes = append(changes, DeltaRecord{Op: DeltaOpUpsert, Record: *record})
+		case old != *record:
+			changes = append(changes, DeltaRecord{Op: DeltaOpUpsert, Record: *record})
+		}
+	}
+	for path, old := range m.published {
+		if _, exists := m.records[path]; !exists {
+			changes = append(changes, DeltaRecord{Op: DeltaOpRemove, Record: old})
+		}
+	}
+
+	// Removals first so a CID moving between paths is not dropped, then by ID
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Op != changes[j].Op {
+			return changes[i].Op == DeltaOpRemove
+		}
+		return changes[i].ID < changes[j].ID
+	})
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	header := &DeltaHeader{
+		Type:         deltaType,
+		Version:      version,
+		BaseVersion:  baseVersion,
+		BaseIndexCID: baseIndexCID,
+		ItemCount:    len(m.records),
+	}
+	if err := enc.Encode(header); err != nil {
+		return nil, fmt.Errorf("failed to marshal delta header: %w", err)
+	}
+	for i := range changes {
+		if err := enc.Encode(&changes[i]); err != nil {
+			return nil, fmt.Errorf("failed to marshal delta record: %w", err)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
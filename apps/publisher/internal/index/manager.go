@@ -2,27 +2,105 @@ package index
 
 import (
 	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/ed25519"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/atregu/ipfs-common/claim"
 
 	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/metadata"
 )
 
+// DirectoryExtension is the extension of records whose CID is a UnixFS
+// directory, such as an album folder with its artwork, instead of a single file
+const DirectoryExtension = "dir"
+
 // Record represents a single entry in the NDJSON index
 type Record struct {
 	ID        int    `json:"id"`
 	CID       string `json:"CID"`
 	Filename  string `json:"filename"`
 	Extension string `json:"extension"`
+	Group     string `json:"group,omitempty"`    // Parent directory relative to the scanned root
+	Path      string `json:"path,omitempty"`     // Group followed by filename, the record's key in the index
+	Size      int64  `json:"size,omitempty"`     // File size in bytes, also covered by the claim
+	ModTime   int64  `json:"mtime,omitempty"`    // Unix time of the file's last modification
+	MimeType  string `json:"mimeType,omitempty"` // e.g. "audio/mpeg"
+	Signature string `json:"sig,omitempty"`      // Claim by the publisher key over CID, filename and size
+
+	// Metadata holds the tags and stream properties read with
+	// behavior.extract_metadata; omitted when nothing was read
+	Metadata metadata.Metadata `json:"metadata,omitzero"`
+}
+
+// File holds the properties of a file recorded with its index record
+type File struct {
+	Size     int64  // Bytes
+	ModTime  int64  // Unix time of the last modification
+	MimeType string // Empty if not known
+}
+
+// setFile records the properties of the record's file
+func (r *Record) setFile(file File) {
+	r.Size = file.Size
+	r.ModTime = file.ModTime
+	r.MimeType = file.MimeType
+}
+
+// RecordPath returns the path of a file inside the collection directory: its
+// group followed by its filename, e.g. "Artist/Album/Disc 1/01 Intro.flac". Files
+// with the same name in different directories have different paths, so records
+// are keyed by it. A UnixFS directory representation of the collection places
+// files at this path so it agrees with the grouping in the index.
+func RecordPath(group, filename string) string {
+	if group == "" {
+		return filename
+	}
+	return group + "/" + filename
+}
+
+// Header is the first line of the index, carrying its schema and the
+// collection metadata. Indexes without one are schema 1.
+type Header struct {
+	Type       string `json:"type"`
+	Schema     int    `json:"schema,omitempty"`
+	Generator  string `json:"generator,omitempty"` // e.g. "ipfs-publisher/0.1.0"
+	CreatedAt  int64  `json:"createdAt,omitempty"` // Unix time the index version was created
+	Visibility string `json:"visibility,omitempty"`
+	License    string `json:"license,omitempty"`
+}
+
+// headerType marks a header line
+const headerType = "header"
+
+// SchemaVersion is the schema of the indexes written by the Manager. Schema 2
+// added the header line with the schema itself; indexes without a header, or
+// with a header without a schema, are schema 1.
+const SchemaVersion = 2
+
+// schemaOf returns the schema of an index whose header is header, nil if it has none
+func schemaOf(header *Header) int {
+	if header == nil || header.Schema == 0 {
+		return 1
+	}
+	return header.Schema
 }
 
 // Manager handles NDJSON index operations
 type Manager struct {
 	indexPath string
-	records   map[string]*Record
+	records   map[string]*Record // By path
 	nextID    int
+	header    *Header           // Always written, see SetMetadata and SetGenerator
+	published map[string]Record // Records as of the last publish, the base for deltas
 }
 
 // New creates a new index manager
@@ -31,6 +109,7 @@ func New(indexPath string) *Manager {
 		indexPath: expandPath(indexPath),
 		records:   make(map[string]*Record),
 		nextID:    1,
+		header:    &Header{Type: headerType, Schema: SchemaVersion},
 	}
 }
 
@@ -54,83 +133,237 @@ func (m *Manager) Load() error {
 	}
 	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
-	lineNum := 0
-
-	for scanner.Scan() {
-		lineNum++
-		line := scanner.Text()
-
-		if line == "" {
-			continue
+	migrated := 0
+	var loaded *Header
+	err = scanLines(file, func(lineNum int, line []byte) {
+		// The header is rewritten from config on Save; only its schema and
+		// creation time are kept
+		if header, ok := parseHeader(line); ok {
+			if lineNum == 1 {
+				loaded = header
+			}
+			return
 		}
 
-		var record Record
-		if err := json.Unmarshal([]byte(line), &record); err != nil {
+		record := &Record{}
+		if err := json.Unmarshal(line, record); err != nil {
 			log.Warnf("Failed to parse line %d: %v", lineNum, err)
-			continue
+			return
 		}
 
-		m.records[record.Filename] = &record
+		// Indexes written before records carried their path are keyed by
+		// filename; the path follows from the group and filename either way
+		path := RecordPath(record.Group, record.Filename)
+		if record.Path != path {
+			if record.Path != "" {
+				log.Warnf("Record %d on line %d has path %q, but its group and filename give %q", record.ID, lineNum, record.Path, path)
+			}
+			record.Path = path
+			migrated++
+		}
+		if old, exists := m.records[path]; exists {
+			log.Warnf("Duplicate path %s on line %d replaces record %d", path, lineNum, old.ID)
+		}
+
+		m.records[path] = record
 
 		if record.ID >= m.nextID {
 			m.nextID = record.ID + 1
 		}
+	})
+	if err != nil {
+		return fmt.Errorf("error reading index file: %w", err)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("error reading index file: %w", err)
+	schema := schemaOf(loaded)
+	if schema > SchemaVersion {
+		return fmt.Errorf("index schema %d is newer than the supported schema %d; upgrade the publisher", schema, SchemaVersion)
+	}
+	if loaded != nil {
+		m.header.CreatedAt = loaded.CreatedAt
+	}
+
+	// The index on disk is the last published one
+	m.MarkPublished()
+
+	if migrated > 0 {
+		log.Infof("Added the path to %d index records; they are saved with it on the next publish", migrated)
 	}
 
-	log.Infof("Loaded %d records from index (next ID: %d)", len(m.records), m.nextID)
+	log.Infof("Loaded %d records from index (schema %d, next ID: %d)", len(m.records), schema, m.nextID)
 	return nil
 }
 
-// Save writes the index to disk
-func (m *Manager) Save() error {
-	log := logger.Get()
+// Stats summarizes an index file
+type Stats struct {
+	Records int
+	Signed  int   // Records carrying a claim
+	Bytes   int64 // Size of the file
+}
 
-	tmpPath := m.indexPath + ".tmp"
-	file, err := os.Create(tmpPath)
+// ReadStats counts the records of the index file at path without loading
+// them, e.g. for status output while the publisher holds the index. A missing
+// file has no records.
+func ReadStats(path string) (*Stats, error) {
+	file, err := os.Open(expandPath(path))
+	if os.IsNotExist(err) {
+		return &Stats{}, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to create temp index file: %w", err)
+		return nil, fmt.Errorf("failed to open index file: %w", err)
 	}
+	defer file.Close()
 
-	writer := bufio.NewWriter(file)
-
-	recordCount := 0
-	for _, record := range m.records {
-		data, err := json.Marshal(record)
-		if err != nil {
-			file.Close()
-			os.Remove(tmpPath)
-			return fmt.Errorf("failed to marshal record: %w", err)
+	stats := &Stats{}
+	var fields struct {
+		Signature string `json:"sig"`
+	}
+	err = scanLines(file, func(lineNum int, line []byte) {
+		if isHeader(line) {
+			return
 		}
+		fields.Signature = ""
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return
+		}
+		stats.Records++
+		if fields.Signature != "" {
+			stats.Signed++
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading index file: %w", err)
+	}
 
-		if _, err := writer.Write(data); err != nil {
-			file.Close()
-			os.Remove(tmpPath)
-			return fmt.Errorf("failed to write record: %w", err)
+	if info, err := file.Stat(); err == nil {
+		stats.Bytes = info.Size()
+	}
+	return stats, nil
+}
+
+// scanLines calls fn with each non-empty line of r and its number. The line
+// is only valid until fn returns.
+func scanLines(r io.Reader, fn func(lineNum int, line []byte)) error {
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		if line := scanner.Bytes(); len(line) > 0 {
+			fn(lineNum, line)
 		}
+	}
+	return scanner.Err()
+}
+
+// isHeader reports whether line is a header line
+func isHeader(line []byte) bool {
+	_, ok := parseHeader(line)
+	return ok
+}
+
+// parseHeader decodes line if it is a header line. Records never have a type
+// field, so lines without one are not decoded a second time.
+func parseHeader(line []byte) (*Header, bool) {
+	if !bytes.Contains(line, []byte(`"type"`)) {
+		return nil, false
+	}
+	header := &Header{}
+	if json.Unmarshal(line, header) != nil || header.Type != headerType {
+		return nil, false
+	}
+	return header, true
+}
+
+// saveProgressInterval is the number of records between progress logs while a
+// large index is saved
+const saveProgressInterval = 250_000
 
-		if _, err := writer.WriteString("\n"); err != nil {
-			file.Close()
-			os.Remove(tmpPath)
-			return fmt.Errorf("failed to write newline: %w", err)
+// Marshal returns the index file content: the header followed by the records
+// ordered by ID
+func (m *Manager) Marshal() ([]byte, error) {
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// WriteTo writes the index file content to w, as Marshal returns it
+func (m *Manager) WriteTo(w io.Writer) (int64, error) {
+	return m.write(w, nil)
+}
+
+// write writes the header and the records ordered by ID to w, calling progress
+// with the number of records written every saveProgressInterval records. The
+// records are encoded one at a time through a single encoder into a buffered
+// writer, so only the ID-ordered slice of pointers grows with the index.
+func (m *Manager) write(w io.Writer, progress func(written int)) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriterSize(cw, 64<<10)
+	enc := json.NewEncoder(bw)
+
+	if err := enc.Encode(m.header); err != nil {
+		return cw.n, fmt.Errorf("failed to marshal header: %w", err)
+	}
+
+	records := make([]*Record, 0, len(m.records))
+	for _, record := range m.records {
+		records = append(records, record)
+	}
+	slices.SortFunc(records, func(a, b *Record) int { return cmp.Compare(a.ID, b.ID) })
+
+	for i, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return cw.n, fmt.Errorf("failed to marshal record: %w", err)
 		}
+		if progress != nil && (i+1)%saveProgressInterval == 0 {
+			progress(i + 1)
+		}
+	}
 
-		recordCount++
+	if err := bw.Flush(); err != nil {
+		return cw.n, err
 	}
+	return cw.n, nil
+}
 
-	if err := writer.Flush(); err != nil {
-		file.Close()
-		os.Remove(tmpPath)
-		return fmt.Errorf("failed to flush writer: %w", err)
+// countingWriter counts the bytes written to w
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write implements io.Writer
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// Save writes the index to disk, streaming it into a temporary file that then
+// replaces the index
+func (m *Manager) Save() error {
+	log := logger.Get()
+
+	var progress func(int)
+	if len(m.records) >= saveProgressInterval {
+		progress = func(written int) {
+			log.Infof("Saving index: %d/%d records", written, len(m.records))
+		}
 	}
 
-	if err := file.Close(); err != nil {
+	tmpPath := m.indexPath + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create temp index file: %w", err)
+	}
+	_, err = m.write(file, progress)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
 		os.Remove(tmpPath)
-		return fmt.Errorf("failed to close file: %w", err)
+		return fmt.Errorf("failed to write temp index file: %w", err)
 	}
 
 	if err := os.Rename(tmpPath, m.indexPath); err != nil {
@@ -138,57 +371,161 @@ func (m *Manager) Save() error {
 		return fmt.Errorf("failed to rename temp file: %w", err)
 	}
 
-	log.Infof("Saved %d records to index", recordCount)
+	log.Infof("Saved %d records to index", len(m.records))
 	return nil
 }
 
+// SetMetadata sets the visibility and license written to the index header.
+// Public is the default visibility and is not written.
+func (m *Manager) SetMetadata(visibility, license string) {
+	if visibility == "public" {
+		visibility = ""
+	}
+	m.header.Visibility = visibility
+	m.header.License = license
+}
+
+// SetGenerator sets the name and version of the program written to the index
+// header, e.g. "ipfs-publisher/0.1.0"
+func (m *Manager) SetGenerator(generator string) {
+	m.header.Generator = generator
+}
+
+// SetCreatedAt sets the creation time written to the index header. It is
+// set when a new version is published and kept across Load, so saving the
+// published index writes the bytes that were uploaded.
+func (m *Manager) SetCreatedAt(t time.Time) {
+	m.header.CreatedAt = t.Unix()
+}
+
 // Add adds a new file to the index
-func (m *Manager) Add(filename, cid, extension string) *Record {
+func (m *Manager) Add(filename, cid, extension string, file File) *Record {
+	return m.AddInGroup(filename, cid, extension, "", file)
+}
+
+// AddInGroup adds a new file to the index under a directory group
+func (m *Manager) AddInGroup(filename, cid, extension, group string, file File) *Record {
 	record := &Record{
 		ID:        m.nextID,
 		CID:       cid,
 		Filename:  filename,
 		Extension: extension,
+		Group:     group,
+		Path:      RecordPath(group, filename),
 	}
+	record.setFile(file)
 
-	m.records[filename] = record
+	m.records[record.Path] = record
 	m.nextID++
 
 	return record
 }
 
-// Update updates the CID for an existing file
-func (m *Manager) Update(filename, cid string) (*Record, error) {
-	record, exists := m.records[filename]
+// Update updates the CID and file properties of the file at path
+func (m *Manager) Update(path, cid string, file File) (*Record, error) {
+	record, exists := m.records[path]
 	if !exists {
-		return nil, fmt.Errorf("record not found: %s", filename)
+		return nil, fmt.Errorf("record not found: %s", path)
 	}
 
 	record.CID = cid
+	record.setFile(file)
+	record.Signature = ""                 // The claim covered the old CID
+	record.Metadata = metadata.Metadata{} // Read from the old content
+	return record, nil
+}
+
+// Move moves the record at path to another group and filename, keeping its ID
+// and CID
+func (m *Manager) Move(path, group, filename string) (*Record, error) {
+	record, exists := m.records[path]
+	if !exists {
+		return nil, fmt.Errorf("record not found: %s", path)
+	}
+	newPath := RecordPath(group, filename)
+	if other, taken := m.records[newPath]; taken && other != record {
+		return nil, fmt.Errorf("record already exists: %s", newPath)
+	}
+
+	if record.Filename != filename {
+		record.Signature = "" // The claim covered the old filename
+	}
+	delete(m.records, path)
+	record.Group = group
+	record.Filename = filename
+	record.Path = newPath
+	m.records[newPath] = record
 	return record, nil
 }
 
-// Delete removes a record by filename
-func (m *Manager) Delete(filename string) error {
-	if _, exists := m.records[filename]; !exists {
-		return fmt.Errorf("record not found: %s", filename)
+// Delete removes the record at path
+func (m *Manager) Delete(path string) error {
+	if _, exists := m.records[path]; !exists {
+		return fmt.Errorf("record not found: %s", path)
 	}
 
-	delete(m.records, filename)
+	delete(m.records, path)
 	return nil
 }
 
-// Get retrieves a record by filename
-func (m *Manager) Get(filename string) (*Record, bool) {
-	record, exists := m.records[filename]
+// Get retrieves the record at path, see RecordPath
+func (m *Manager) Get(path string) (*Record, bool) {
+	record, exists := m.records[path]
 	return record, exists
 }
 
+// Paths returns the paths of the records by ID
+func (m *Manager) Paths() map[int]string {
+	paths := make(map[int]string, len(m.records))
+	for path, record := range m.records {
+		paths[record.ID] = path
+	}
+	return paths
+}
+
+// CIDs returns the CID of every record by path
+func (m *Manager) CIDs() map[string]string {
+	cids := make(map[string]string, len(m.records))
+	for path, record := range m.records {
+		cids[path] = record.CID
+	}
+	return cids
+}
+
 // Count returns the number of records
 func (m *Manager) Count() int {
 	return len(m.records)
 }
 
+// SignRecords adds a claim to every record that lacks one or whose size changed.
+// sizes maps record IDs to file sizes; records without a size are left unsigned.
+// It returns the number of records signed.
+func (m *Manager) SignRecords(privateKey ed25519.PrivateKey, sizes map[int]int64) int {
+	signed := 0
+	for _, record := range m.records {
+		size, ok := sizes[record.ID]
+		if !ok || (record.Signature != "" && record.Size == size) {
+			continue
+		}
+		record.Size = size
+		record.Signature = claim.Sign(privateKey, record.CID, record.Filename, size)
+		signed++
+	}
+	return signed
+}
+
+// StripClaims removes the claims from all records and returns the number of records changed
+func (m *Manager) StripClaims() int {
+	stripped := 0
+	for _, record := range m.records {
+		if record.Signature != "" {
+			record.Signature = ""
+			stripped++
+		}
+	}
+	return stripped
+}
+
 // GetPath returns the index file path
 func (m *Manager) GetPath() string {
 	return m.indexPath
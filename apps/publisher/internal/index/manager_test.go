@@ -0,0 +1,555 @@
+package index
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-common/claim"
+
+	"github.com/atregu/ipfs-publisher/internal/keys"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/metadata"
+	"github.com/atregu/ipfs-publisher/internal/utils"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/")
+
+// testdataDir is the repository-wide fixture directory shared with the indexer
+const testdataDir = "../../../../testdata"
+
+// baseIndexCID is the CID the v1 golden index is published under in the v2 delta
+const baseIndexCID = "bafkreiaxvuuhj3gyz3ypmcrvqsgdmsyuzx3pw2qd5fhsqqbcvhyhqk2exm"
+
+// loadGoldenV1 returns a manager loaded from a copy of the v1 golden index
+func loadGoldenV1(t *testing.T) *Manager {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join(testdataDir, "index-v1.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "collection.ndjson")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	m := New(path)
+	if err := m.Load(); err != nil {
+		t.Fatalf("failed to load v1 index: %v", err)
+	}
+	return m
+}
+
+// applyV2Changes turns the v1 golden collection into the v2 one
+func applyV2Changes(t *testing.T, m *Manager) {
+	t.Helper()
+
+	m.SetMetadata("unlisted", "CC-BY-4.0")
+	m.SetGenerator("ipfs-publisher/0.1.0")
+	m.SetCreatedAt(time.Unix(1764260600, 0))
+
+	if _, err := m.Move("song.mp3", "Artist/Album/Disc 1", "song.mp3"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.Delete("movie.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	m.AddInGroup("clip.webm", "bafybeie5gq4jxvzmsym6hjlqxei4tmarjgczmugl7mr2ezecq2ktmjvxky", "webm", "Videos",
+		File{Size: 1048576, ModTime: 1764260509, MimeType: "video/webm"})
+}
+
+// normalizeIndex sorts the record lines of an index, which Save writes in map
+// order, keeping a header line first
+func normalizeIndex(data []byte) []byte {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	start := 0
+	if strings.Contains(lines[0], `"type":"header"`) {
+		start = 1
+	}
+	sort.Strings(lines[start:])
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// checkGolden compares normalized data with a golden file, rewriting it with -update
+func checkGolden(t *testing.T, name string, data []byte, normalize func([]byte) []byte) {
+	t.Helper()
+
+	path := filepath.Join(testdataDir, name)
+	if *update {
+		if err := os.WriteFile(path, normalize(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(normalize(data), normalize(golden)) {
+		t.Errorf("%s does not match; run go test -update if the format changed\ngot:\n%s\nwant:\n%s", name, data, golden)
+	}
+}
+
+func TestIndexGoldenV1Migration(t *testing.T) {
+	m := loadGoldenV1(t)
+	if m.Count() != 3 {
+		t.Fatalf("loaded %d records, want 3", m.Count())
+	}
+
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(m.GetPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// v1 records lack the path and the index its header: re-saving adds both
+	// and changes nothing else
+	golden, err := os.ReadFile(filepath.Join(testdataDir, "index-v1.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want bytes.Buffer
+	enc := json.NewEncoder(&want)
+	if err := enc.Encode(&Header{Type: headerType, Schema: SchemaVersion}); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(golden)), "\n") {
+		var record Record
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		if record.Path != "" {
+			t.Fatalf("v1 golden record %d already has a path", record.ID)
+		}
+		record.Path = record.Filename
+		if err := enc.Encode(&record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(normalizeIndex(data), normalizeIndex(want.Bytes())) {
+		t.Errorf("re-saved v1 index differs from the migrated golden\ngot:\n%s\nwant:\n%s", data, want.Bytes())
+	}
+
+	// The migration alone is not a change to publish
+	if m.Modified() {
+		t.Error("Modified() = true after loading a v1 index")
+	}
+}
+
+func TestRecordsKeyedByPath(t *testing.T) {
+	m := New(filepath.Join(t.TempDir(), "collection.ndjson"))
+	first := m.AddInGroup("episode01.mkv", "cid-s1e1", "mkv", "Season 1", File{})
+	second := m.AddInGroup("episode01.mkv", "cid-s2e1", "mkv", "Season 2", File{})
+	if m.Count() != 2 {
+		t.Fatalf("Count() = %d, want 2 records with the same filename", m.Count())
+	}
+	if record, ok := m.Get("Season 1/episode01.mkv"); !ok || record != first {
+		t.Errorf("Get(Season 1/episode01.mkv) = %v, %v, want the first record", record, ok)
+	}
+	if record, ok := m.Get("Season 2/episode01.mkv"); !ok || record.CID != "cid-s2e1" {
+		t.Errorf("Get(Season 2/episode01.mkv) = %v, %v, want the second record", record, ok)
+	}
+
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := New(m.GetPath())
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.Count() != 2 {
+		t.Errorf("reloaded %d records, want 2", loaded.Count())
+	}
+
+	// Moving keeps the ID; a taken path is refused
+	if _, err := m.Move("Season 2/episode01.mkv", "Season 1", "episode01.mkv"); err == nil {
+		t.Error("Move onto an existing path succeeded")
+	}
+	moved, err := m.Move("Season 2/episode01.mkv", "Season 2", "episode02.mkv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.ID != second.ID || moved.Path != "Season 2/episode02.mkv" {
+		t.Errorf("moved record = %+v, want ID %d at Season 2/episode02.mkv", moved, second.ID)
+	}
+	if _, ok := m.Get("Season 2/episode01.mkv"); ok {
+		t.Error("old path still in the index after Move")
+	}
+	if paths := m.Paths(); paths[first.ID] != "Season 1/episode01.mkv" || paths[second.ID] != "Season 2/episode02.mkv" {
+		t.Errorf("Paths() = %v", paths)
+	}
+	if cids := m.CIDs(); len(cids) != 2 || cids["Season 1/episode01.mkv"] != "cid-s1e1" || cids["Season 2/episode02.mkv"] != "cid-s2e1" {
+		t.Errorf("CIDs() = %v", cids)
+	}
+}
+
+func TestRecordMetadata(t *testing.T) {
+	m := New(filepath.Join(t.TempDir(), "collection.ndjson"))
+	tagged := m.Add("intro.mp3", "cid-intro", "mp3", File{})
+	tagged.Metadata = metadata.Metadata{Title: "Intro", Artist: "Björk", Duration: 26.122}
+	m.Add("notes.txt", "cid-notes", "txt", File{})
+
+	data, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.Count(data, []byte(`"metadata"`)); got != 1 {
+		t.Errorf("index has %d metadata objects, want 1 (omitted when empty):\n%s", got, data)
+	}
+
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := New(m.GetPath())
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	record, ok := loaded.Get("intro.mp3")
+	if !ok || record.Metadata != tagged.Metadata {
+		t.Fatalf("reloaded record = %+v, want metadata %+v", record, tagged.Metadata)
+	}
+
+	// New content has its own metadata
+	if _, err := loaded.Update("intro.mp3", "cid-remaster", File{}); err != nil {
+		t.Fatal(err)
+	}
+	if record.Metadata != (metadata.Metadata{}) {
+		t.Errorf("metadata after Update = %+v, want none", record.Metadata)
+	}
+}
+
+func TestIndexGoldenV2(t *testing.T) {
+	m := loadGoldenV1(t)
+	applyV2Changes(t, m)
+
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(m.GetPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkGolden(t, "index-v2.ndjson", data, normalizeIndex)
+}
+
+func TestHeaderSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "collection.ndjson")
+	m := New(path)
+	m.SetGenerator("ipfs-publisher/0.1.0")
+	m.SetCreatedAt(time.Unix(1764260600, 0))
+	m.Add("song.mp3", "QmepHP9vMsBZB7w15yEqnUzTupNoQqnG9Lj3VhBQAvxg6B", "mp3", File{})
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _, _ := strings.Cut(string(data), "\n")
+	if want := `{"type":"header","schema":2,"generator":"ipfs-publisher/0.1.0","createdAt":1764260600}`; first != want {
+		t.Errorf("header = %s, want %s", first, want)
+	}
+
+	// The creation time survives a restart, so saving again writes the same bytes
+	loaded := New(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	loaded.SetGenerator("ipfs-publisher/0.1.0")
+	again, err := loaded.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Errorf("reloaded index marshals as\n%s\nwant\n%s", again, data)
+	}
+
+	// An index from a newer publisher is not rewritten in the older schema
+	newer := strings.Replace(string(data), `"schema":2`, `"schema":3`, 1)
+	if err := os.WriteFile(path, []byte(newer), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := New(path).Load(); err == nil {
+		t.Error("loaded an index with a newer schema")
+	}
+}
+
+func TestDeltaGoldenV2(t *testing.T) {
+	m := loadGoldenV1(t)
+	applyV2Changes(t, m)
+
+	delta, err := m.BuildDelta(4, 3, baseIndexCID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkGolden(t, "delta-v2.ndjson", delta, func(data []byte) []byte { return data })
+}
+
+func TestBuildDeltaWithoutBase(t *testing.T) {
+	m := New(filepath.Join(t.TempDir(), "collection.ndjson"))
+	m.Add("song.mp3", "QmepHP9vMsBZB7w15yEqnUzTupNoQqnG9Lj3VhBQAvxg6B", "mp3", File{})
+
+	delta, err := m.BuildDelta(1, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if delta != nil {
+		t.Errorf("expected no delta without a published base, got %s", delta)
+	}
+}
+
+func TestRecordPathUsesScannedGroup(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "music")
+	file := filepath.Join(root, "Artist", "Album", "Disc 1", "01 Intro.flac")
+
+	record := New(filepath.Join(t.TempDir(), "collection.ndjson")).
+		AddInGroup("01 Intro.flac", "bafkreigawy2oq47r6rvwok3q5u7khmsvfd5r6san657a2k2basbxsiomny", "flac", utils.GroupForPath(root, file), File{})
+
+	if got, want := record.Path, "Artist/Album/Disc 1/01 Intro.flac"; got != want {
+		t.Errorf("Path = %q, want %q", got, want)
+	}
+
+	if got := RecordPath("", "song.mp3"); got != "song.mp3" {
+		t.Errorf("RecordPath without a group = %q, want song.mp3", got)
+	}
+}
+
+// goldenSizes are the file sizes behind the claims in index-v2-signed.ndjson
+var goldenSizes = map[int]int64{1: 15728640, 2: 4194304, 4: 1048576}
+
+func TestIndexGoldenV2Signed(t *testing.T) {
+	keyManager := keys.New(filepath.Join(testdataDir, "keys"))
+	if err := keyManager.Initialize(); err != nil {
+		t.Fatalf("failed to load test key: %v", err)
+	}
+	key, err := keyManager.Get(keys.DefaultName)
+	if err != nil {
+		t.Fatalf("failed to load test key: %v", err)
+	}
+
+	m := loadGoldenV1(t)
+	applyV2Changes(t, m)
+	if signed := m.SignRecords(key, goldenSizes); signed != 3 {
+		t.Fatalf("signed %d records, want 3", signed)
+	}
+
+	data, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkGolden(t, "index-v2-signed.ndjson", data, normalizeIndex)
+}
+
+func TestClaimsFollowRecordChanges(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey))
+
+	m := loadGoldenV1(t)
+	sizes := map[int]int64{1: 100, 2: 200, 3: 300}
+	if signed := m.SignRecords(key, sizes); signed != 3 {
+		t.Fatalf("signed %d records, want 3", signed)
+	}
+	if !m.Modified() {
+		t.Error("signing did not modify the published records")
+	}
+	m.MarkPublished()
+
+	// Only records without a claim or with a new size are signed again
+	if signed := m.SignRecords(key, sizes); signed != 0 {
+		t.Errorf("re-signed %d unchanged records", signed)
+	}
+	song, err := m.Update("song.mp3", "bafkreinewsong", File{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if song.Signature != "" {
+		t.Error("Update kept the claim over the old CID")
+	}
+	sizes[song.ID] = 250
+	if signed := m.SignRecords(key, sizes); signed != 1 {
+		t.Errorf("signed %d records after one update, want 1", signed)
+	}
+	if err := claim.Verify(publicKey, song.CID, song.Filename, 250, song.Signature); err != nil {
+		t.Errorf("claim of updated record: %v", err)
+	}
+
+	if stripped := m.StripClaims(); stripped != 3 {
+		t.Errorf("stripped %d records, want 3", stripped)
+	}
+	// Sizes are file properties and stay
+	if song.Size != 250 || song.Signature != "" {
+		t.Errorf("record after StripClaims = %+v", *song)
+	}
+}
+
+func TestSaveStreamsMarshalledIndex(t *testing.T) {
+	m := loadGoldenV1(t)
+	applyV2Changes(t, m)
+
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := os.ReadFile(m.GetPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := m.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, data) {
+		t.Errorf("saved index differs from Marshal\nsaved:\n%s\nmarshalled:\n%s", saved, data)
+	}
+
+	var buf bytes.Buffer
+	if n, err := m.WriteTo(&buf); err != nil || n != int64(len(data)) {
+		t.Errorf("WriteTo = %d, %v; want %d bytes", n, err, len(data))
+	}
+	if _, err := os.Stat(m.GetPath() + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temp file left behind: %v", err)
+	}
+}
+
+func TestReadStats(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := loadGoldenV1(t)
+	applyV2Changes(t, m)
+	m.SignRecords(key, map[int]int64{1: 100, 2: 200})
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	stats, err := ReadStats(m.GetPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(m.GetPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header line is not a record
+	if want := (Stats{Records: m.Count(), Signed: 2, Bytes: info.Size()}); *stats != want {
+		t.Errorf("stats = %+v, want %+v", *stats, want)
+	}
+
+	stats, err = ReadStats(filepath.Join(t.TempDir(), "missing.ndjson"))
+	if err != nil || *stats != (Stats{}) {
+		t.Errorf("stats of a missing index = %+v, %v", stats, err)
+	}
+}
+
+// syntheticIndex returns a manager holding n signed records in groups
+func syntheticIndex(tb testing.TB, n int) *Manager {
+	tb.Helper()
+
+	m := New(filepath.Join(tb.TempDir(), "collection.ndjson"))
+	sig := base64.StdEncoding.EncodeToString(make([]byte, ed25519.SignatureSize))
+	for i := range n {
+		record := m.AddInGroup(fmt.Sprintf("%07d Track.flac", i),
+			fmt.Sprintf("bafkreigawy2oq47r6rvwok3q5u7khmsvfd5r6san657a2k2bas%07d", i), "flac",
+			fmt.Sprintf("Artist %d/Album %d", i/1000, i/10), File{Size: int64(30<<20 + i)})
+		record.Signature = sig
+	}
+	return m
+}
+
+// saveBuffered writes the index the way Save did before streaming: every record
+// marshalled into one buffer, which is then written out
+func saveBuffered(m *Manager) error {
+	var buf bytes.Buffer
+	records := make([]*Record, 0, len(m.records))
+	for _, record := range m.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+	for _, record := range records {
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		buf.Write(append(data, '\n'))
+	}
+
+	tmpPath := m.indexPath + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, m.indexPath)
+}
+
+// BenchmarkSave compares the allocations of saving a 1M record index streamed
+// and buffered:
+//
+//	go test ./internal/index -run '^$' -bench Save -benchmem
+func BenchmarkSave(b *testing.B) {
+	m := syntheticIndex(b, 1_000_000)
+	logger.Get().SetOutput(io.Discard)
+
+	for _, bench := range []struct {
+		name string
+		save func() error
+	}{
+		{"streamed", m.Save},
+		{"buffered", func() error { return saveBuffered(m) }},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				if err := bench.save(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkReadStats compares counting the records of a 1M record index with loading it
+func BenchmarkReadStats(b *testing.B) {
+	m := syntheticIndex(b, 1_000_000)
+	logger.Get().SetOutput(io.Discard)
+	if err := m.Save(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.Run("stats", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if _, err := ReadStats(m.GetPath()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("load", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			if err := New(m.GetPath()).Load(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
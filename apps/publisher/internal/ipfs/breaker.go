@@ -0,0 +1,299 @@
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// Circuit breaker states served by GET /api/v1/status/ipfs
+const (
+	CircuitClosed   = "closed"    // Requests reach the node
+	CircuitOpen     = "open"      // Requests fail with ErrCircuitOpen until the cooldown is over
+	CircuitHalfOpen = "half-open" // A health check probes the node; other requests wait for it
+)
+
+// breakerProbeTimeout bounds the health check that may close the circuit
+const breakerProbeTimeout = 10 * time.Second
+
+// ErrCircuitOpen is returned, without contacting the node, for requests made
+// while the circuit breaker of the external client is open
+var ErrCircuitOpen = errors.New("IPFS node unreachable, circuit breaker open")
+
+// CircuitOpenError is returned for a request short-circuited by an open
+// breaker. It matches ErrCircuitOpen with errors.Is.
+type CircuitOpenError struct {
+	RetryAt time.Time // When the next request probes the node
+	Err     error     // Last connection failure
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%v until %s: %v", ErrCircuitOpen, e.RetryAt.Format(time.TimeOnly), e.Err)
+}
+
+// Is reports whether target is ErrCircuitOpen. The last failure is not
+// unwrapped, so a short-circuited request is never taken for a transient error.
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// BreakerObserver is notified of the state changes of a Breaker and of the
+// requests it fails fast, e.g. to export them as metrics
+type BreakerObserver interface {
+	CircuitState(from, to string)
+	CircuitRejected()
+}
+
+// BreakerStatus is the state of a Breaker
+type BreakerStatus struct {
+	State     string     `json:"state"`                // closed, open or half-open
+	Failures  int        `json:"consecutive_failures"` // Connection failures since the last response
+	Threshold int        `json:"failure_threshold"`
+	Opens     int        `json:"opens"`                // Times the circuit opened since the start
+	Rejected  int        `json:"rejected"`             // Requests failed fast while open
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`  // When the open circuit opened
+	RetryAt   *time.Time `json:"retry_at,omitempty"`   // When the open circuit probes the node
+	LastError string     `json:"last_error,omitempty"` // Last connection failure
+}
+
+// Breaker is an http.RoundTripper that stops sending requests to a node that
+// cannot be reached. After threshold consecutive connection-level failures,
+// requests that got no HTTP response at all, the circuit opens and requests fail
+// with ErrCircuitOpen for the cooldown. The first request after it runs a single
+// health check: if the node answers, the circuit closes and the request goes
+// ahead, otherwise the cooldown starts over. Any HTTP response, even an error
+// status, counts as the node being reachable.
+type Breaker struct {
+	next      http.RoundTripper
+	probe     func(ctx context.Context) error
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	observer BreakerObserver
+	state    string
+	failures int
+	lastErr  error
+	openedAt time.Time
+	retryAt  time.Time
+	opens    int
+	rejected int
+	probing  chan struct{} // Closed once the running health check is done; nil without one
+}
+
+// NewBreaker returns a breaker sending requests through next. probe is the
+// health check run before closing an open circuit.
+func NewBreaker(next http.RoundTripper, probe func(ctx context.Context) error, threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{
+		next:      next,
+		probe:     probe,
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+		state:     CircuitClosed,
+	}
+}
+
+// SetObserver sets the observer of state changes and rejected requests
+func (b *Breaker) SetObserver(o BreakerObserver) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.observer = o
+}
+
+// RoundTrip implements http.RoundTripper
+func (b *Breaker) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := b.allow(req.Context()); err != nil {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, err
+	}
+
+	resp, err := b.next.RoundTrip(req)
+	b.record(req.Context(), err)
+	return resp, err
+}
+
+// allow returns nil if a request may be sent. Once the cooldown is over, the
+// first caller runs the health check and the others wait for its outcome.
+func (b *Breaker) allow(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		if b.state == CircuitClosed {
+			b.mu.Unlock()
+			return nil
+		}
+		if probing := b.probing; probing != nil {
+			b.mu.Unlock()
+			select {
+			case <-probing:
+				continue
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if b.now().Before(b.retryAt) {
+			b.rejected++
+			if b.observer != nil {
+				b.observer.CircuitRejected()
+			}
+			err := b.openError()
+			b.mu.Unlock()
+			return err
+		}
+
+		b.probing = make(chan struct{})
+		b.setState(CircuitHalfOpen)
+		b.mu.Unlock()
+		return b.runProbe(ctx)
+	}
+}
+
+// runProbe runs the health check of a half-open circuit and closes or reopens it
+func (b *Breaker) runProbe(ctx context.Context) error {
+	probeCtx, cancel := context.WithTimeout(ctx, breakerProbeTimeout)
+	err := b.probe(probeCtx)
+	cancel()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	close(b.probing)
+	b.probing = nil
+
+	switch {
+	case err == nil:
+		b.close()
+		return nil
+	case ctx.Err() != nil:
+		// The caller gave up; the next request probes again
+		b.setState(CircuitOpen)
+		return ctx.Err()
+	default:
+		b.open(err)
+		return b.openError()
+	}
+}
+
+// record counts the outcome of a request that was sent
+func (b *Breaker) record(ctx context.Context, err error) {
+	failed := err != nil && connectionFailure(ctx, err)
+	if err != nil && !failed {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures, b.lastErr = 0, nil
+		// A response proves the node is reachable, even if it was sent before
+		// the circuit opened
+		if b.state == CircuitOpen {
+			b.close()
+		}
+		return
+	}
+
+	b.failures++
+	b.lastErr = err
+	if b.state == CircuitClosed && b.failures >= b.threshold {
+		b.open(err)
+	}
+}
+
+// connectionFailure reports whether a request failed without reaching the
+// node: it could not connect, the connection broke or the request timed out.
+// A request the caller cancelled, or whose local body could not be read, says
+// nothing about the node.
+func connectionFailure(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		deadline, ok := ctx.Deadline()
+		return ok && !time.Now().Before(deadline)
+	}
+	var opErr *net.OpError
+	return IsTransient(err) || errors.As(err, &opErr)
+}
+
+// open opens the circuit for the cooldown after the failure err; b.mu is held
+func (b *Breaker) open(err error) {
+	log := logger.Get()
+	now := b.now()
+	b.lastErr = err
+	b.retryAt = now.Add(b.cooldown)
+	if b.state == CircuitHalfOpen {
+		log.Warnf("IPFS node still unreachable, probing again at %s: %v", b.retryAt.Format(time.TimeOnly), err)
+	} else {
+		b.opens++
+		b.openedAt = now
+		log.Errorf("IPFS node unreachable after %d consecutive failures, failing requests until %s: %v",
+			b.failures, b.retryAt.Format(time.TimeOnly), err)
+	}
+	b.setState(CircuitOpen)
+}
+
+// close closes the circuit after the node answered; b.mu is held
+func (b *Breaker) close() {
+	logger.Get().Infof("✓ IPFS node reachable again after %s, circuit closed", b.now().Sub(b.openedAt).Round(time.Second))
+	b.failures, b.lastErr = 0, nil
+	b.setState(CircuitClosed)
+}
+
+// setState changes the state and notifies the observer; b.mu is held
+func (b *Breaker) setState(state string) {
+	if b.state == state {
+		return
+	}
+	from := b.state
+	b.state = state
+	if b.observer != nil {
+		b.observer.CircuitState(from, state)
+	}
+}
+
+// openError returns the error of a short-circuited request; b.mu is held
+func (b *Breaker) openError() error {
+	return &CircuitOpenError{RetryAt: b.retryAt, Err: b.lastErr}
+}
+
+// Status returns the current state of the breaker
+func (b *Breaker) Status() BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := BreakerStatus{
+		State:     b.state,
+		Failures:  b.failures,
+		Threshold: b.threshold,
+		Opens:     b.opens,
+		Rejected:  b.rejected,
+	}
+	if b.state != CircuitClosed {
+		openedAt, retryAt := b.openedAt, b.retryAt
+		status.OpenedAt, status.RetryAt = &openedAt, &retryAt
+	}
+	if b.lastErr != nil {
+		status.LastError = b.lastErr.Error()
+	}
+	return status
+}
+
+// Handler serves Status as JSON at GET /api/v1/status/ipfs
+func (b *Breaker) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(b.Status())
+	})
+}
@@ -0,0 +1,236 @@
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// roundTripFunc is an http.RoundTripper calling itself
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// fakeNode answers requests unless down is set, in which case connecting fails
+type fakeNode struct {
+	down     atomic.Bool
+	requests atomic.Int32
+}
+
+func (n *fakeNode) RoundTrip(*http.Request) (*http.Response, error) {
+	n.requests.Add(1)
+	if n.down.Load() {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	}
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+// probe is a health check reaching the node the way requests do
+func (n *fakeNode) probe(context.Context) error {
+	_, err := n.RoundTrip(nil)
+	return err
+}
+
+// newTestBreaker returns a breaker in front of node with a threshold of 3, a
+// cooldown of a minute and a clock advanced by the returned function
+func newTestBreaker(node *fakeNode) (*Breaker, func(time.Duration)) {
+	b := NewBreaker(node, node.probe, 3, time.Minute)
+	now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	b.now = func() time.Time { return now }
+	return b, func(d time.Duration) { now = now.Add(d) }
+}
+
+// send sends a request through b and returns its error
+func send(b *Breaker, ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://127.0.0.1:5001/api/v0/id", nil)
+	if err != nil {
+		panic(err)
+	}
+	_, err = b.RoundTrip(req)
+	return err
+}
+
+// recordingObserver records the state changes of a breaker
+type recordingObserver struct {
+	changes  []string
+	rejected int
+}
+
+func (o *recordingObserver) CircuitState(from, to string) {
+	o.changes = append(o.changes, from+">"+to)
+}
+
+func (o *recordingObserver) CircuitRejected() {
+	o.rejected++
+}
+
+func TestBreakerOpensAndCloses(t *testing.T) {
+	node := &fakeNode{}
+	b, advance := newTestBreaker(node)
+	observer := &recordingObserver{}
+	b.SetObserver(observer)
+	ctx := context.Background()
+
+	// A response in between resets the count
+	node.down.Store(true)
+	send(b, ctx)
+	send(b, ctx)
+	node.down.Store(false)
+	if err := send(b, ctx); err != nil {
+		t.Fatal(err)
+	}
+	node.down.Store(true)
+	send(b, ctx)
+	send(b, ctx)
+	if status := b.Status(); status.State != CircuitClosed || status.Failures != 2 {
+		t.Fatalf("status = %+v, want closed after 2 failures", status)
+	}
+
+	// The third consecutive failure opens the circuit
+	if err := send(b, ctx); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("third failure = %v, want the connection error", err)
+	}
+	sent := node.requests.Load()
+	err := send(b, ctx)
+	var open *CircuitOpenError
+	if !errors.As(err, &open) || !errors.Is(err, ErrCircuitOpen) || IsTransient(err) {
+		t.Fatalf("request while open = %v, want a CircuitOpenError", err)
+	}
+	if node.requests.Load() != sent {
+		t.Error("request while open reached the node")
+	}
+	if want := b.now().Add(time.Minute); !open.RetryAt.Equal(want) {
+		t.Errorf("RetryAt = %s, want %s", open.RetryAt, want)
+	}
+
+	// A failed probe restarts the cooldown
+	advance(time.Minute)
+	if err := send(b, ctx); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("request after a failed probe = %v, want ErrCircuitOpen", err)
+	}
+	if node.requests.Load() != sent+1 {
+		t.Errorf("%d requests reached the node, want only the probe", node.requests.Load()-sent)
+	}
+	advance(30 * time.Second)
+	if err := send(b, ctx); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("request during the new cooldown = %v, want ErrCircuitOpen", err)
+	}
+
+	// A successful probe closes the circuit and lets the request through
+	advance(30 * time.Second)
+	node.down.Store(false)
+	if err := send(b, ctx); err != nil {
+		t.Fatalf("request after a successful probe = %v", err)
+	}
+
+	status := b.Status()
+	if status.State != CircuitClosed || status.Failures != 0 || status.Opens != 1 || status.Rejected != 2 {
+		t.Errorf("status = %+v, want closed after 1 opening and 2 rejected requests", status)
+	}
+	want := []string{"closed>open", "open>half-open", "half-open>open", "open>half-open", "half-open>closed"}
+	if len(observer.changes) != len(want) || observer.rejected != 2 {
+		t.Fatalf("observed %v and %d rejections, want %v and 2", observer.changes, observer.rejected, want)
+	}
+	for i := range want {
+		if observer.changes[i] != want[i] {
+			t.Errorf("change %d = %s, want %s", i, observer.changes[i], want[i])
+		}
+	}
+}
+
+func TestBreakerIgnoresCancelledRequests(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b := NewBreaker(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return nil, req.Context().Err()
+	}), func(context.Context) error { return nil }, 1, time.Minute)
+
+	if err := send(b, ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want context.Canceled", err)
+	}
+	if status := b.Status(); status.State != CircuitClosed || status.Failures != 0 {
+		t.Errorf("status = %+v, want a cancelled request not to count", status)
+	}
+
+	// A timed out request does count
+	timeout, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-timeout.Done()
+	send(b, timeout)
+	if status := b.Status(); status.State != CircuitOpen {
+		t.Errorf("status = %+v, want a timed out request to open the circuit", status)
+	}
+}
+
+func TestBreakerSingleProbe(t *testing.T) {
+	node := &fakeNode{}
+	node.down.Store(true)
+	b, advance := newTestBreaker(node)
+	for range 3 {
+		send(b, context.Background())
+	}
+	advance(time.Minute)
+
+	// Requests arriving during the probe wait for its outcome
+	release := make(chan struct{})
+	var probes atomic.Int32
+	b.probe = func(context.Context) error {
+		probes.Add(1)
+		<-release
+		return nil
+	}
+	node.down.Store(false)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+	for range 4 {
+		wg.Go(func() {
+			errs <- send(b, context.Background())
+		})
+	}
+	for b.Status().State != CircuitHalfOpen {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("request = %v, want it sent once the probe succeeded", err)
+		}
+	}
+	if probes.Load() != 1 {
+		t.Errorf("%d probes, want 1", probes.Load())
+	}
+}
+
+func TestBreakerHandler(t *testing.T) {
+	node := &fakeNode{}
+	node.down.Store(true)
+	b, _ := newTestBreaker(node)
+	for range 3 {
+		send(b, context.Background())
+	}
+
+	rec := httptest.NewRecorder()
+	b.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/status/ipfs", nil))
+	var status BreakerStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.State != CircuitOpen || status.Opens != 1 || status.RetryAt == nil || status.LastError == "" {
+		t.Errorf("status = %+v, want open with a retry time and the last error", status)
+	}
+}
@@ -0,0 +1,194 @@
+package ipfs
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	gocid "github.com/ipfs/go-cid"
+)
+
+// Exporter writes DAGs held by the node as CAR archives (implemented by both clients)
+type Exporter interface {
+	// ExportCAR writes the complete DAGs of roots to w as one CARv1 archive
+	// listing them as its roots. Every block is written once, even if several
+	// DAGs share it. Blocks are read from the node only: a missing block fails
+	// the export instead of being fetched from peers.
+	ExportCAR(ctx context.Context, roots []string, w io.Writer) (*CARResult, error)
+}
+
+// CARResult summarizes an exported CAR archive
+type CARResult struct {
+	Roots  int
+	Blocks int
+	Bytes  int64 // Size of the archive
+}
+
+// maxCARSection bounds a block section read from a CAR stream; blocks are at
+// most a few MiB
+const maxCARSection = 8 << 20
+
+// carWriter writes a CARv1 archive: a DAG-CBOR header naming the roots,
+// followed by length-prefixed sections of a CID and its block
+type carWriter struct {
+	w      *bufio.Writer
+	seen   map[string]struct{} // Binary CIDs of the blocks written
+	result CARResult
+}
+
+// newCARWriter writes the header of a CARv1 archive with roots to w
+func newCARWriter(w io.Writer, roots []gocid.Cid) (*carWriter, error) {
+	cw := &carWriter{
+		w:      bufio.NewWriterSize(w, 1<<20),
+		seen:   make(map[string]struct{}),
+		result: CARResult{Roots: len(roots)},
+	}
+	if err := cw.writeSection(encodeCARHeader(roots)); err != nil {
+		return nil, fmt.Errorf("failed to write CAR header: %w", err)
+	}
+	return cw, nil
+}
+
+// has reports whether the block of c was written already
+func (cw *carWriter) has(c gocid.Cid) bool {
+	_, ok := cw.seen[c.KeyString()]
+	return ok
+}
+
+// writeBlock writes the block data of c unless it was written already
+func (cw *carWriter) writeBlock(c gocid.Cid, data []byte) error {
+	if cw.has(c) {
+		return nil
+	}
+	cw.seen[c.KeyString()] = struct{}{}
+	if err := cw.writeSection(c.Bytes(), data); err != nil {
+		return fmt.Errorf("failed to write block %s: %w", c, err)
+	}
+	cw.result.Blocks++
+	return nil
+}
+
+// writeSection writes the concatenated parts prefixed with their total length
+func (cw *carWriter) writeSection(parts ...[]byte) error {
+	size := 0
+	for _, part := range parts {
+		size += len(part)
+	}
+	prefix := binary.AppendUvarint(nil, uint64(size))
+	if _, err := cw.w.Write(prefix); err != nil {
+		return err
+	}
+	for _, part := range parts {
+		if _, err := cw.w.Write(part); err != nil {
+			return err
+		}
+	}
+	cw.result.Bytes += int64(len(prefix) + size)
+	return nil
+}
+
+// Close flushes the archive and returns its summary
+func (cw *carWriter) Close() (*CARResult, error) {
+	if err := cw.w.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write CAR: %w", err)
+	}
+	return &cw.result, nil
+}
+
+// encodeCARHeader returns the DAG-CBOR encoding of the CARv1 header
+// {"roots": [roots...], "version": 1}. Keys are in DAG-CBOR order, shorter first.
+func encodeCARHeader(roots []gocid.Cid) []byte {
+	buf := cborHead(nil, 5, 2) // Map of two entries
+	buf = cborText(buf, "roots")
+	buf = cborHead(buf, 4, uint64(len(roots)))
+	for _, root := range roots {
+		// CID links are tag 42 over the binary CID with a leading zero byte
+		buf = append(buf, 0xd8, 42)
+		raw := root.Bytes()
+		buf = cborHead(buf, 2, uint64(len(raw)+1))
+		buf = append(buf, 0)
+		buf = append(buf, raw...)
+	}
+	buf = cborText(buf, "version")
+	return cborHead(buf, 0, 1)
+}
+
+// cborText appends a CBOR text string
+func cborText(buf []byte, s string) []byte {
+	return append(cborHead(buf, 3, uint64(len(s))), s...)
+}
+
+// cborHead appends the head of a CBOR item of major type major with argument n
+// in its shortest form
+func cborHead(buf []byte, major byte, n uint64) []byte {
+	major <<= 5
+	switch {
+	case n < 24:
+		return append(buf, major|byte(n))
+	case n <= 0xff:
+		return append(buf, major|24, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(buf, major|25), uint16(n))
+	case n <= 0xffffffff:
+		return binary.BigEndian.AppendUint32(append(buf, major|26), uint32(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(buf, major|27), n)
+	}
+}
+
+// readCAR calls fn with every block of the CARv1 archive read from r. The
+// header is skipped; data is only valid until fn returns.
+func readCAR(r io.Reader, fn func(c gocid.Cid, data []byte) error) error {
+	br := bufio.NewReaderSize(r, 1<<20)
+	var section []byte
+	for first := true; ; first = false {
+		size, err := binary.ReadUvarint(br)
+		if err == io.EOF && !first {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read CAR section: %w", err)
+		}
+		if size > maxCARSection {
+			return fmt.Errorf("CAR section of %d bytes exceeds the limit of %d", size, maxCARSection)
+		}
+
+		if uint64(cap(section)) < size {
+			section = make([]byte, size)
+		}
+		section = section[:size]
+		if _, err := io.ReadFull(br, section); err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return fmt.Errorf("failed to read CAR section: %w", err)
+		}
+		if first {
+			continue
+		}
+
+		n, c, err := gocid.CidFromBytes(section)
+		if err != nil {
+			return fmt.Errorf("invalid CID in CAR section: %w", err)
+		}
+		if err := fn(c, section[n:]); err != nil {
+			return err
+		}
+	}
+}
+
+// parseRoots decodes the CIDs of the roots of an export
+func parseRoots(roots []string) ([]gocid.Cid, error) {
+	cids := make([]gocid.Cid, 0, len(roots))
+	for _, root := range roots {
+		c, err := gocid.Decode(root)
+		if err != nil {
+			return nil, fmt.Errorf("invalid root CID %s: %w", root, err)
+		}
+		cids = append(cids, c)
+	}
+	return cids, nil
+}
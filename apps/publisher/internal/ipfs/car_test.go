@@ -0,0 +1,79 @@
+package ipfs
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+
+	gocid "github.com/ipfs/go-cid"
+	"github.com/multiformats/go-multihash"
+)
+
+// rawBlock returns the CIDv1 of data as a raw block
+func rawBlock(t *testing.T, data string) gocid.Cid {
+	t.Helper()
+	hash, err := multihash.Sum([]byte(data), multihash.SHA2_256, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gocid.NewCidV1(gocid.Raw, hash)
+}
+
+func TestEncodeCARHeader(t *testing.T) {
+	root := rawBlock(t, "song")
+	raw := root.Bytes()
+
+	// {"roots": [42(h'00' + cid)], "version": 1}
+	want := "a2" + "65" + hex.EncodeToString([]byte("roots")) + "81" + "d82a" +
+		fmt.Sprintf("58%02x", len(raw)+1) + "00" + hex.EncodeToString(raw) +
+		"67" + hex.EncodeToString([]byte("version")) + "01"
+	if got := hex.EncodeToString(encodeCARHeader([]gocid.Cid{root})); got != want {
+		t.Errorf("header = %s, want %s", got, want)
+	}
+}
+
+func TestCARRoundTrip(t *testing.T) {
+	song, cover := rawBlock(t, "song"), rawBlock(t, "cover")
+
+	var buf bytes.Buffer
+	car, err := newCARWriter(&buf, []gocid.Cid{song, cover})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A block shared by two DAGs is written once
+	for _, block := range []struct {
+		cid  gocid.Cid
+		data string
+	}{{song, "song"}, {cover, "cover"}, {song, "song"}} {
+		if err := car.writeBlock(block.cid, []byte(block.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := car.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *result != (CARResult{Roots: 2, Blocks: 2, Bytes: int64(buf.Len())}) {
+		t.Errorf("result = %+v, want 2 roots, 2 blocks and %d bytes", *result, buf.Len())
+	}
+
+	var read []string
+	err = readCAR(bytes.NewReader(buf.Bytes()), func(c gocid.Cid, data []byte) error {
+		read = append(read, c.String()+"="+string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{song.String() + "=song", cover.String() + "=cover"}; strings.Join(read, ",") != strings.Join(want, ",") {
+		t.Errorf("read %v, want %v", read, want)
+	}
+
+	// A truncated archive is an error, not a shorter one
+	truncated := buf.Bytes()[:buf.Len()-2]
+	if err := readCAR(bytes.NewReader(truncated), func(gocid.Cid, []byte) error { return nil }); err == nil {
+		t.Error("truncated CAR read without error")
+	}
+}
@@ -0,0 +1,248 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	ft "github.com/ipfs/boxo/ipld/unixfs"
+	unixfs_pb "github.com/ipfs/boxo/ipld/unixfs/pb"
+	"github.com/ipfs/go-cid"
+	shell "github.com/ipfs/go-ipfs-api"
+	format "github.com/ipfs/go-ipld-format"
+)
+
+// PartStore persists the part CIDs of unfinished chunked adds so that an add
+// interrupted by a crash or a dropped connection resumes instead of restarting.
+// state.Manager implements it.
+type PartStore interface {
+	GetUploadParts(path string, size, modTime, partSize int64, rawLeaves bool) []string
+	SetUploadPart(path string, size, modTime, partSize int64, rawLeaves bool, index int, cid string)
+	DeleteUpload(path string)
+	Save() error
+}
+
+// uploadsStagingDir is the MFS directory holding the parts of unfinished chunked adds.
+// Parts linked into MFS survive garbage collection between attempts.
+const uploadsStagingDir = "/.ipfs-publisher/uploads"
+
+// EnableChunkedAdd makes Add split files larger than cfg.Threshold into parts
+// that are added separately and concatenated into one UnixFS file on the node.
+// Completed parts are recorded in store so a later Add of the same file reuses them.
+func (c *ExternalClient) EnableChunkedAdd(cfg *config.ChunkedAddConfig, store PartStore) {
+	c.chunked = cfg
+	c.parts = store
+}
+
+// useChunkedAdd reports whether a file of the given size should be added in parts
+func (c *ExternalClient) useChunkedAdd(info os.FileInfo) bool {
+	return c.chunked != nil && c.parts != nil && c.chunked.Threshold > 0 &&
+		info != nil && info.Size() > c.chunked.Threshold
+}
+
+// addChunked adds the file at path in fixed-size parts, staging each part in MFS
+// and recording it in the part store, then links the parts under a single UnixFS
+// file node. The resulting CID differs from a plain add of the same file.
+// Progress is reported once per part, since retries read a part again.
+func (c *ExternalClient) addChunked(ctx context.Context, r io.ReaderAt, path string, info os.FileInfo, opts AddOptions) (*AddResult, error) {
+	log := logger.Get()
+
+	size := info.Size()
+	modTime := info.ModTime().Unix()
+	partSize := c.chunked.PartSize
+	numParts := int((size + partSize - 1) / partSize)
+
+	sum := sha256.Sum256([]byte(path))
+	stagingDir := uploadsStagingDir + "/" + hex.EncodeToString(sum[:8])
+	if err := c.shell.FilesMkdir(ctx, stagingDir, shell.FilesMkdir.Parents(true)); err != nil {
+		return nil, fmt.Errorf("failed to create MFS staging directory: %w", translateNoSpace("mkdir", err))
+	}
+
+	recorded := c.parts.GetUploadParts(path, size, modTime, partSize, opts.RawLeaves)
+	if len(recorded) > 0 {
+		log.Infof("Resuming chunked add of %s (%d/%d parts recorded)", path, countParts(recorded), numParts)
+	}
+
+	stats := make([]*shell.FilesStatObject, numParts)
+	for i := 0; i < numParts; i++ {
+		partPath := fmt.Sprintf("%s/part-%05d", stagingDir, i)
+		offset := int64(i) * partSize
+		length := min(partSize, size-offset)
+
+		// Reuse a recorded part if the node still has it
+		if i < len(recorded) && recorded[i] != "" {
+			if stat, err := c.stagePart(ctx, recorded[i], partPath); err == nil {
+				stats[i] = stat
+				reportProgress(opts, offset+length)
+				continue
+			}
+			log.Warnf("Recorded part %d of %s (%s) is no longer available, adding it again", i, path, recorded[i])
+		}
+
+		cid, err := c.addPart(ctx, io.NewSectionReader(r, offset, length), opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add part %d/%d of %s: %w", i+1, numParts, path, err)
+		}
+
+		stat, err := c.stagePart(ctx, cid, partPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to stage part %d/%d of %s: %w", i+1, numParts, path, err)
+		}
+		stats[i] = stat
+
+		c.parts.SetUploadPart(path, size, modTime, partSize, opts.RawLeaves, i, cid)
+		if err := c.parts.Save(); err != nil {
+			log.Warnf("Failed to save chunked add progress: %v", err)
+		}
+		log.Debugf("Added part %d/%d of %s: %s", i+1, numParts, path, cid)
+		reportProgress(opts, offset+length)
+	}
+
+	cid, err := c.concatParts(stats, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	c.parts.DeleteUpload(path)
+	if err := c.parts.Save(); err != nil {
+		log.Warnf("Failed to save chunked add progress: %v", err)
+	}
+	if err := c.shell.FilesRm(context.Background(), stagingDir, true); err != nil {
+		log.Debugf("Failed to remove MFS staging dir %s: %v", stagingDir, err)
+	}
+
+	log.Infof("Added %s in %d parts: %s", path, numParts, cid)
+	return &AddResult{
+		CID:  cid,
+		Size: uint64(size),
+		Name: path,
+	}, nil
+}
+
+// addPart adds one part, retrying up to the configured number of attempts
+func (c *ExternalClient) addPart(ctx context.Context, part *io.SectionReader, opts AddOptions) (string, error) {
+	partOpts := AddOptions{Chunker: opts.Chunker, RawLeaves: opts.RawLeaves}
+
+	var err error
+	for attempt := 1; attempt <= c.chunked.Retries; attempt++ {
+		if _, err = part.Seek(0, io.SeekStart); err != nil {
+			return "", fmt.Errorf("failed to rewind part: %w", err)
+		}
+
+		var cid string
+		cid, _, err = c.addFile(ctx, files.NewReaderFile(part), partOpts)
+		if err == nil {
+			return cid, nil
+		}
+		if IsFatal(err) || errors.Is(err, ErrCircuitOpen) {
+			return "", err
+		}
+
+		logger.Get().Warnf("Part add failed (attempt %d/%d): %v", attempt, c.chunked.Retries, err)
+		if attempt == c.chunked.Retries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(time.Duration(attempt) * 2 * time.Second):
+		}
+	}
+
+	return "", err
+}
+
+// stagePart links a part into the MFS staging directory and returns its stat
+func (c *ExternalClient) stagePart(ctx context.Context, cid, partPath string) (*shell.FilesStatObject, error) {
+	if stat, err := c.shell.FilesStat(ctx, partPath); err == nil && stat.Hash == cid {
+		return stat, nil
+	}
+
+	// Replace a stale entry left by an earlier attempt
+	_ = c.shell.FilesRm(ctx, partPath, true)
+
+	if err := c.shell.FilesCp(ctx, "/ipfs/"+cid, partPath); err != nil {
+		return nil, err
+	}
+	return c.shell.FilesStat(ctx, partPath)
+}
+
+// concatParts stores a UnixFS file node linking the parts in order and returns its CID.
+// The node is encoded locally so its CID has the version a plain add produces: kubo
+// adds without --cid-version return a CIDv0 dag-pb root, with or without raw leaves.
+// Parts added with the other leaf format are never mixed in: the part store keys
+// recorded parts by opts.RawLeaves.
+func (c *ExternalClient) concatParts(parts []*shell.FilesStatObject, opts AddOptions) (string, error) {
+	root, err := fileNode(parts)
+	if err != nil {
+		return "", err
+	}
+
+	stored, err := c.shell.BlockPut(root.RawData(), "dag-pb", "sha2-256", -1)
+	if err != nil {
+		return "", fmt.Errorf("failed to store concatenated file node: %w", translateNoSpace("block put", err))
+	}
+	storedCID, err := cid.Decode(stored)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse stored file node CID %q: %w", stored, err)
+	}
+	if !bytes.Equal(storedCID.Hash(), root.Cid().Hash()) {
+		return "", fmt.Errorf("node stored the concatenated file node as %s, expected %s", stored, root.Cid())
+	}
+
+	if opts.Pin {
+		if err := c.shell.Pin(root.Cid().String()); err != nil {
+			return "", fmt.Errorf("failed to pin concatenated file: %w", translateNoSpace("pin", err))
+		}
+	}
+
+	return root.Cid().String(), nil
+}
+
+// fileNode builds the CIDv0 dag-pb UnixFS file node linking the parts in order
+func fileNode(parts []*shell.FilesStatObject) (*merkledag.ProtoNode, error) {
+	fsNode := ft.NewFSNode(unixfs_pb.Data_File)
+	links := make([]*format.Link, 0, len(parts))
+	for _, part := range parts {
+		partCID, err := cid.Decode(part.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse part CID %q: %w", part.Hash, err)
+		}
+		fsNode.AddBlockSize(part.Size)
+		links = append(links, &format.Link{Cid: partCID, Size: part.CumulativeSize})
+	}
+
+	data, err := fsNode.GetBytes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode UnixFS file node: %w", err)
+	}
+
+	node := merkledag.NodeWithData(data)
+	for _, link := range links {
+		if err := node.AddRawLink("", link); err != nil {
+			return nil, fmt.Errorf("failed to link part %s: %w", link.Cid, err)
+		}
+	}
+	return node, nil
+}
+
+// countParts returns the number of recorded parts
+func countParts(parts []string) int {
+	n := 0
+	for _, p := range parts {
+		if p != "" {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,226 @@
+package ipfs
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/state"
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// fakeKubo serves the subset of the kubo HTTP API used by chunked adds
+type fakeKubo struct {
+	mu      sync.Mutex
+	content map[string][]byte // Added parts by CID
+	mfs     map[string]string // MFS path -> CID
+	adds    int               // Number of add requests so far
+	failAdd func(n int) bool  // Fails the n-th add request (1-based) when it returns true
+}
+
+func newFakeKubo(t *testing.T) (*fakeKubo, string) {
+	t.Helper()
+
+	k := &fakeKubo{content: make(map[string][]byte), mfs: make(map[string]string)}
+	server := httptest.NewServer(k)
+	t.Cleanup(server.Close)
+	return k, server.URL
+}
+
+func (k *fakeKubo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	args := r.URL.Query()["arg"]
+	switch strings.TrimPrefix(r.URL.Path, "/api/v0/") {
+	case "add":
+		data, err := readMultipartFile(r)
+		if err != nil {
+			kuboError(w, err.Error())
+			return
+		}
+		k.adds++
+		if k.failAdd != nil && k.failAdd(k.adds) {
+			kuboError(w, "connection reset by peer")
+			return
+		}
+		c := sumCID(v1Prefix(cid.Raw), data)
+		k.content[c] = data
+		writeJSON(w, map[string]any{"Name": "", "Hash": c, "Size": len(data)})
+
+	case "files/mkdir", "pin/add":
+		writeJSON(w, map[string]any{})
+
+	case "files/stat":
+		c, ok := k.mfs[args[0]]
+		if !ok {
+			kuboError(w, "file does not exist")
+			return
+		}
+		size := len(k.content[c])
+		writeJSON(w, map[string]any{"Hash": c, "Size": size, "CumulativeSize": size, "Blocks": 0, "Type": "file"})
+
+	case "files/cp":
+		c := strings.TrimPrefix(args[0], "/ipfs/")
+		if _, ok := k.content[c]; !ok {
+			kuboError(w, "block was not found locally")
+			return
+		}
+		k.mfs[args[1]] = c
+
+	case "files/rm":
+		for path := range k.mfs {
+			if path == args[0] || strings.HasPrefix(path, args[0]+"/") {
+				delete(k.mfs, path)
+			}
+		}
+
+	case "block/put":
+		data, err := readMultipartFile(r)
+		if err != nil {
+			kuboError(w, err.Error())
+			return
+		}
+		writeJSON(w, map[string]any{"Key": sumCID(v1Prefix(cid.DagProtobuf), data), "Size": len(data)})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// v1Prefix returns the CIDv1 sha2-256 prefix of a codec
+func v1Prefix(codec uint64) cid.Prefix {
+	return cid.Prefix{Version: 1, Codec: codec, MhType: mh.SHA2_256, MhLength: -1}
+}
+
+func sumCID(prefix cid.Prefix, data []byte) string {
+	c, err := prefix.Sum(data)
+	if err != nil {
+		panic(err)
+	}
+	return c.String()
+}
+
+// readMultipartFile returns the content of the file in a multipart request body
+func readMultipartFile(r *http.Request) ([]byte, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return nil, err
+		}
+		if part.Header.Get("Content-Type") != "application/x-directory" {
+			return io.ReadAll(part)
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func kuboError(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]any{"Message": message, "Code": 0, "Type": "error"})
+}
+
+// chunkedAdd adds path through a chunked-add client of the fake node at url
+func chunkedAdd(t *testing.T, url string, store PartStore, path string) (*AddResult, error) {
+	t.Helper()
+
+	client, err := NewExternalClient(url, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.EnableChunkedAdd(&config.ChunkedAddConfig{Threshold: 16, PartSize: 16, Retries: 1}, store)
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client.Add(context.Background(), file, path, AddOptions{Pin: true, FileInfo: info})
+}
+
+func TestChunkedAddResumesAfterFailedPart(t *testing.T) {
+	logger.Get().SetOutput(io.Discard)
+
+	// 50 bytes in parts of 16: four parts
+	path := filepath.Join(t.TempDir(), "movie.mkv")
+	if err := os.WriteFile(path, []byte(strings.Repeat("0123456789", 5)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, referenceURL := newFakeKubo(t)
+	reference, err := chunkedAdd(t, referenceURL, state.New(filepath.Join(t.TempDir(), "state.json")), path)
+	if err != nil {
+		t.Fatalf("uninterrupted add: %v", err)
+	}
+
+	// The third part fails: the first two are recorded
+	kubo, url := newFakeKubo(t)
+	kubo.failAdd = func(n int) bool { return n >= 3 }
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	if _, err := chunkedAdd(t, url, state.New(statePath), path); err == nil {
+		t.Fatal("add succeeded although a part failed")
+	}
+
+	// A restarted publisher loads the recorded parts and adds only the rest
+	store := state.New(statePath)
+	if err := store.Load(); err != nil {
+		t.Fatal(err)
+	}
+	recorded := store.GetUploadParts(path, info.Size(), info.ModTime().Unix(), 16, false)
+	if countParts(recorded) != 2 {
+		t.Fatalf("recorded parts = %q, want the first two", recorded)
+	}
+
+	kubo.failAdd = nil
+	addsBefore := kubo.adds
+	result, err := chunkedAdd(t, url, store, path)
+	if err != nil {
+		t.Fatalf("resumed add: %v", err)
+	}
+	if added := kubo.adds - addsBefore; added != 2 {
+		t.Errorf("resumed add uploaded %d parts, want 2", added)
+	}
+
+	if result.CID != reference.CID {
+		t.Errorf("resumed CID = %s, uninterrupted CID = %s", result.CID, reference.CID)
+	}
+	root, err := cid.Decode(result.CID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Version() != 0 {
+		t.Errorf("root CID %s is version %d, want 0 like a plain add", root, root.Version())
+	}
+	if parts := store.GetUploadParts(path, info.Size(), info.ModTime().Unix(), 16, false); parts != nil {
+		t.Errorf("upload record kept after completion: %q", parts)
+	}
+}
@@ -3,6 +3,9 @@ package ipfs
 import (
 	"context"
 	"io"
+	"os"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
 )
 
 // AddOptions contains options for adding files to IPFS
@@ -11,6 +14,11 @@ type AddOptions struct {
 	NoCopy    bool
 	Chunker   string
 	RawLeaves bool
+	FileInfo  os.FileInfo // Optional stat result from the scan; avoids re-stating in nocopy mode
+
+	// ProgressFn, if set, is called with the number of bytes of the file read
+	// so far whenever the add reads more of it
+	ProgressFn func(bytesWritten int64)
 }
 
 // IPNSPublishOptions contains options for IPNS publishing
@@ -21,6 +29,15 @@ type IPNSPublishOptions struct {
 	AllowOffline bool   // Allow offline publishing (local only, no DHT)
 }
 
+// NewIPNSPublishOptions builds publish options using the configured IPNS lifetime and TTL
+func NewIPNSPublishOptions(cfg *config.PublishConfig, key string) IPNSPublishOptions {
+	return IPNSPublishOptions{
+		Key:      key,
+		Lifetime: cfg.Lifetime().String(),
+		TTL:      cfg.TTL().String(),
+	}
+}
+
 // AddResult contains the result of adding a file to IPFS
 type AddResult struct {
 	CID  string
@@ -28,6 +45,38 @@ type AddResult struct {
 	Name string
 }
 
+// IndexFileName is the name of the index inside the collection root directory,
+// reachable as /ipns/<name>/collection.ndjson
+const IndexFileName = "collection.ndjson"
+
+// IndexUploadResult contains the CIDs of an uploaded collection index
+type IndexUploadResult struct {
+	RootCID     string // Directory CID that IPNS points at, unless the collection tree is published
+	IndexCID    string // CID of the index file inside the directory
+	ManifestCID string // CID of the manifest inside the directory, empty without one
+}
+
+// Content types reported by Stat
+const (
+	StatTypeFile = "file"
+	StatTypeDir  = "dir"
+)
+
+// StatResult describes content by CID without its data
+type StatResult struct {
+	CID      string
+	Size     uint64 // File size, or the cumulative DAG size of a directory
+	NumLinks int    // Links of the root node: chunks of a file, entries of a directory
+	Type     string // StatTypeFile or StatTypeDir
+}
+
+// Pin types reported by ListPins
+const (
+	PinTypeRecursive = "recursive"
+	PinTypeDirect    = "direct"
+	PinTypeIndirect  = "indirect" // Pinned through a recursive pin of a parent
+)
+
 // IPNSPublishResult contains the result of IPNS publish
 type IPNSPublishResult struct {
 	Name  string // IPNS name (hash)
@@ -39,21 +88,68 @@ type Client interface {
 	// Add uploads a file to IPFS and returns its CID
 	Add(ctx context.Context, reader io.Reader, filename string, opts AddOptions) (*AddResult, error)
 
+	// AddDirectory uploads a local directory recursively as a UnixFS directory,
+	// e.g. an album folder with its artwork. The result carries the directory's
+	// base name and the total size of its files. Hidden entries are left out.
+	AddDirectory(ctx context.Context, dirPath string, opts AddOptions) (*AddResult, error)
+
+	// PreflightAdd checks that the repository can hold bytes more data before a batch of adds.
+	// It returns a FatalError wrapping ErrNoSpace if there is not enough room.
+	PreflightAdd(ctx context.Context, bytes uint64) error
+
+	// AddIndex uploads the collection index wrapped in a directory so it is
+	// reachable at a stable path under the root CID, next to the manifest
+	// unless manifest is nil
+	AddIndex(ctx context.Context, data, manifest []byte, opts AddOptions) (*IndexUploadResult, error)
+
 	// Cat retrieves content from IPFS by CID
 	Cat(ctx context.Context, cid string) (io.ReadCloser, error)
 
+	// CatRange retrieves length bytes starting at offset from content by CID
+	CatRange(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error)
+
+	// Stat returns the size and type of the content of cid from its root block,
+	// without downloading the rest of it
+	Stat(ctx context.Context, cid string) (*StatResult, error)
+
+	// HasLocal reports whether the node holds the complete DAG of cid locally:
+	// it is pinned recursively, or every block is present. Nothing is fetched
+	// from the network.
+	HasLocal(ctx context.Context, cid string) (bool, error)
+
 	// Pin pins content in IPFS
 	Pin(ctx context.Context, cid string) error
 
+	// PinMany pins several CIDs recursively in bulk. On error some of them may
+	// be pinned already; pinning them again is harmless.
+	PinMany(ctx context.Context, cids []string) error
+
 	// Unpin unpins content from IPFS
 	Unpin(ctx context.Context, cid string) error
 
+	// ListPins returns every pinned CID with its pin type: PinTypeRecursive,
+	// PinTypeDirect or PinTypeIndirect
+	ListPins(ctx context.Context) (map[string]string, error)
+
 	// PublishIPNS publishes a CID to IPNS
 	PublishIPNS(ctx context.Context, cid string, opts IPNSPublishOptions) (*IPNSPublishResult, error)
 
+	// EnsureKey returns the IPNS name of the named key, generating the key if it does not exist
+	EnsureKey(ctx context.Context, name string) (string, error)
+
 	// ResolveIPNS resolves an IPNS name to a CID
 	ResolveIPNS(ctx context.Context, name string) (string, error)
 
+	// RepoStat returns repository size, object count and storage limit
+	RepoStat(ctx context.Context) (*RepoStats, error)
+
+	// SwarmConnect connects the node to the peer at peerAddr, a multiaddr ending
+	// in /p2p/<peer ID>, e.g. a pinning cluster node or a private network peer
+	SwarmConnect(ctx context.Context, peerAddr string) error
+
+	// SwarmDisconnect closes every connection of the node to the peer peerID
+	SwarmDisconnect(ctx context.Context, peerID string) error
+
 	// IsAvailable checks if the IPFS node is reachable
 	IsAvailable(ctx context.Context) error
 
@@ -0,0 +1,100 @@
+package ipfs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/boxo/files"
+)
+
+// isHiddenEntry reports whether a directory entry is left out of directory adds,
+// as the RPC API's AddDir leaves out hidden files
+func isHiddenEntry(name string) bool {
+	return strings.HasPrefix(name, ".")
+}
+
+// directoryNode builds the files.Node tree of a local directory for a UnixFS
+// add, with a map directory per directory and a serial file per other entry,
+// and returns it with the total size of its regular files. Hidden entries are
+// left out. The caller closes the node.
+func directoryNode(dirPath string) (files.Node, uint64, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read directory %s: %w", dirPath, err)
+	}
+
+	children := make(map[string]files.Node, len(entries))
+	closeChildren := func() {
+		for _, child := range children {
+			child.Close()
+		}
+	}
+
+	var total uint64
+	for _, entry := range entries {
+		if isHiddenEntry(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(dirPath, entry.Name())
+
+		if entry.IsDir() {
+			child, size, err := directoryNode(path)
+			if err != nil {
+				closeChildren()
+				return nil, 0, err
+			}
+			children[entry.Name()] = child
+			total += size
+			continue
+		}
+
+		info, err := os.Lstat(path)
+		if err != nil {
+			closeChildren()
+			return nil, 0, fmt.Errorf("failed to stat %s: %w", path, err)
+		}
+		child, err := files.NewSerialFile(path, false, info)
+		if err != nil {
+			closeChildren()
+			return nil, 0, fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		children[entry.Name()] = child
+		if info.Mode().IsRegular() {
+			total += uint64(info.Size())
+		}
+	}
+
+	return files.NewMapDirectory(children), total, nil
+}
+
+// DirectorySize returns the total size of the regular files below a local
+// directory, leaving out hidden entries as directory adds do
+func DirectorySize(dirPath string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(dirPath, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dirPath && isHiddenEntry(entry.Name()) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to walk directory %s: %w", dirPath, err)
+	}
+	return total, nil
+}
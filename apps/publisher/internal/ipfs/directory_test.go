@@ -0,0 +1,83 @@
+package ipfs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/ipfs/boxo/files"
+)
+
+// writeTree creates files with the given contents below dir
+func writeTree(t *testing.T, dir string, contents map[string]string) {
+	t.Helper()
+	for name, content := range contents {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// nodePaths lists the paths of the files and directories in a node tree
+func nodePaths(t *testing.T, prefix string, node files.Node) []string {
+	t.Helper()
+	dir, ok := node.(files.Directory)
+	if !ok {
+		return nil
+	}
+
+	var paths []string
+	it := dir.Entries()
+	for it.Next() {
+		path := prefix + it.Name()
+		paths = append(paths, path)
+		paths = append(paths, nodePaths(t, path+"/", it.Node())...)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestDirectoryNode(t *testing.T) {
+	album := t.TempDir()
+	writeTree(t, album, map[string]string{
+		"01 Intro.flac":        "intro",
+		"cover.jpg":            "art",
+		".DS_Store":            "hidden",
+		".git/config":          "hidden dir",
+		"Disc 2/01 Outro.flac": "outro",
+	})
+
+	node, size, err := directoryNode(album)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+
+	want := []string{"01 Intro.flac", "Disc 2", "Disc 2/01 Outro.flac", "cover.jpg"}
+	got := nodePaths(t, "", node)
+	if len(got) != len(want) {
+		t.Fatalf("tree = %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("tree = %q, want %q", got, want)
+			break
+		}
+	}
+
+	wantSize := len("intro") + len("art") + len("outro")
+	if size != uint64(wantSize) {
+		t.Errorf("size = %d, want %d", size, wantSize)
+	}
+	if total, err := DirectorySize(album); err != nil || total != int64(wantSize) {
+		t.Errorf("DirectorySize = %d, %v, want %d", total, err, wantSize)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd)
+
+package ipfs
+
+import "errors"
+
+// errDiskSpaceUnsupported is returned where free space cannot be determined
+var errDiskSpaceUnsupported = errors.New("free disk space check not supported on this platform")
+
+// freeDiskSpace is not implemented on this platform; the preflight check is skipped
+func freeDiskSpace(path string) (uint64, error) {
+	return 0, errDiskSpaceUnsupported
+}
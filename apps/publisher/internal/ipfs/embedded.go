@@ -2,22 +2,31 @@ package ipfs
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/atregu/ipfs-common/manifest"
 	config "github.com/atregu/ipfs-publisher/internal/config"
 	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/utils"
 
 	"github.com/ipfs/boxo/files"
+	"github.com/ipfs/boxo/ipld/merkledag"
+	"github.com/ipfs/boxo/mfs"
 	"github.com/ipfs/boxo/path"
+	gocid "github.com/ipfs/go-cid"
 	"github.com/ipfs/kubo/core"
 	"github.com/ipfs/kubo/core/coreapi"
 	iface "github.com/ipfs/kubo/core/coreiface"
 	"github.com/ipfs/kubo/core/coreiface/options"
+	"github.com/ipfs/kubo/core/corerepo"
 	"github.com/ipfs/kubo/core/node/libp2p"
 	"github.com/ipfs/kubo/plugin/loader"
 	"github.com/ipfs/kubo/repo"
@@ -37,6 +46,9 @@ type EmbeddedClient struct {
 	ctx     context.Context
 	cancel  context.CancelFunc
 	started bool
+	gcDone  chan struct{} // Closed when the GC loop returns; nil without one
+
+	spaceCheckFailures atomic.Uint64
 }
 
 var initPluginsOnce sync.Once
@@ -114,6 +126,17 @@ func (c *EmbeddedClient) Start() error {
 	}
 	c.repo = repo
 
+	// Apply memory and connection limits (e.g. for small devices)
+	res := c.cfg.Resources
+	if err := ApplyResourceLimits(repo, res.MaxMemory, res.ConnLowWater, res.ConnHighWater); err != nil {
+		CloseRepo(repo)
+		return err
+	}
+	if err := ApplyBootstrapPeers(repo, c.cfg.BootstrapPeers); err != nil {
+		CloseRepo(repo)
+		return err
+	}
+
 	// Build the IPFS node
 	nodeOptions := &core.BuildCfg{
 		Online:  true,
@@ -161,15 +184,105 @@ func (c *EmbeddedClient) Start() error {
 		log.Infof("Listening on %d addresses", len(addrs))
 	}
 
+	if c.cfg.GC.Enabled && c.cfg.GC.Interval > 0 {
+		c.startGCLoop()
+	}
+
 	return nil
 }
 
-// Add uploads a file to IPFS
+// startGCLoop checks the free space on the repo volume every gc.interval
+// seconds and collects garbage when it is below gc.min_free_space, or on every
+// tick without a reserve. The loop stops, interrupting a collection, when the
+// client is closed.
+func (c *EmbeddedClient) startGCLoop() {
+	interval := time.Duration(c.cfg.GC.Interval) * time.Second
+	logger.Get().Infof("Garbage collection checks every %s", interval)
+
+	c.gcDone = make(chan struct{})
+	go func() {
+		defer close(c.gcDone)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-ticker.C:
+				c.scheduledGC(c.ctx)
+			}
+		}
+	}()
+}
+
+// scheduledGC runs a garbage collection if the repo volume is short of
+// gc.min_free_space. If free space cannot be determined, it collects anyway.
+func (c *EmbeddedClient) scheduledGC(ctx context.Context) {
+	log := logger.Get()
+
+	reserve := c.cfg.GC.MinFreeSpace
+	free, err := freeDiskSpace(c.cfg.RepoPath)
+	switch {
+	case err != nil:
+		log.Debugf("Free space unknown (%v), collecting garbage", err)
+	case reserve > 0 && free >= uint64(reserve):
+		log.Debugf("Skipping garbage collection: %s free on the repo volume", utils.FormatBytes(int64(free)))
+		return
+	case reserve > 0:
+		log.Infof("Free space on the repo volume below %s (%s free), collecting garbage",
+			utils.FormatBytes(reserve), utils.FormatBytes(int64(free)))
+	}
+
+	removed, freed, err := c.collectGarbage(ctx)
+	if ctx.Err() != nil {
+		log.Info("Garbage collection interrupted by shutdown")
+		return
+	}
+	if err != nil {
+		log.Errorf("Garbage collection failed after removing %d blocks: %v", removed, err)
+		return
+	}
+	log.Infof("Garbage collection removed %d blocks, freed %s", removed, utils.FormatBytes(freed))
+}
+
+// collectGarbage removes the unpinned blocks from the repo and returns the number
+// of blocks removed and the bytes by which the repo shrank
+func (c *EmbeddedClient) collectGarbage(ctx context.Context) (removed int, freed int64, err error) {
+	before, statErr := c.RepoStat(ctx)
+
+	var errs []error
+	for result := range corerepo.GarbageCollectAsync(c.node, ctx) {
+		if result.Error != nil {
+			errs = append(errs, result.Error)
+			continue
+		}
+		removed++
+	}
+	if err := errors.Join(errs...); err != nil {
+		return removed, 0, err
+	}
+
+	if statErr == nil {
+		if after, err := c.RepoStat(ctx); err == nil && before.RepoSize > after.RepoSize {
+			freed = int64(before.RepoSize - after.RepoSize)
+		}
+	}
+	return removed, freed, nil
+}
+
+// Add uploads a file to IPFS. The data is streamed from reader into the DAG
+// builder chunk by chunk, so files larger than memory can be added. The reader
+// stays open; closing it is up to the caller. The result's Size is the
+// cumulative size of the added DAG, the file plus its UnixFS overhead.
 func (c *EmbeddedClient) Add(ctx context.Context, reader io.Reader, filename string, opts AddOptions) (*AddResult, error) {
 	if !c.started {
 		return nil, fmt.Errorf("node not started")
 	}
 
+	reader = withProgress(reader, opts)
+
 	// Build add options
 	pinName := ""
 	if opts.Pin {
@@ -192,56 +305,233 @@ func (c *EmbeddedClient) Add(ctx context.Context, reader io.Reader, filename str
 	}
 
 	var fileNode files.Node
-	var fileSize uint64
 
-	// For nocopy mode, we need to provide a path, not a reader
+	// For nocopy mode, the node needs the file's path besides its content
 	if opts.NoCopy {
 		// NoCopy requires a file path, which should be in the filename parameter
-		// The reader should be nil or we read to get size then use the path
 		if filename == "" {
 			return nil, fmt.Errorf("nocopy mode requires a file path in filename parameter")
 		}
 
-		// Check if file exists
-		fileInfo, err := os.Stat(filename)
-		if err != nil {
-			return nil, fmt.Errorf("nocopy mode: failed to stat file: %w", err)
+		// Reuse the scan's stat result if provided, otherwise check the file exists
+		var err error
+		fileInfo := opts.FileInfo
+		if fileInfo == nil {
+			fileInfo, err = os.Stat(filename)
+			if err != nil {
+				return nil, fmt.Errorf("nocopy mode: failed to stat file: %w", err)
+			}
 		}
-		fileSize = uint64(fileInfo.Size())
 
-		// Create a file node from the path
-		fileNode, err = files.NewSerialFile(filename, false, fileInfo)
+		// The filestore references the blocks by offset into the file at its path
+		fileNode, err = files.NewReaderPathFile(filename, io.NopCloser(reader), fileInfo)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create file node from path: %w", err)
 		}
 	} else {
-		// Read all data from reader into memory
-		// This is necessary because files.NewReaderFile expects a ReadSeeker
-		data, err := io.ReadAll(reader)
-		if err != nil {
-			return nil, fmt.Errorf("failed to read data: %w", err)
-		}
-		fileSize = uint64(len(data))
-
-		// Create a files.Node from the data
-		fileNode = files.NewBytesFile(data)
+		// The adder closes the node when done; the NopCloser keeps the caller's reader open
+		fileNode = files.NewReaderStatFile(io.NopCloser(reader), opts.FileInfo)
 	}
 
 	// Add the file
 	p, err := c.api.Unixfs().Add(ctx, fileNode, addOpts...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to add file: %w", err)
+		return nil, fmt.Errorf("failed to add file: %w", translateNoSpace("add", err))
+	}
+
+	size, err := c.dagSize(ctx, p)
+	if err != nil {
+		return nil, err
 	}
 
 	result := &AddResult{
 		CID:  p.RootCid().String(),
 		Name: filename,
-		Size: fileSize,
+		Size: size,
 	}
 
 	return result, nil
 }
 
+// dagSize returns the cumulative size of the DAG below an added root, read
+// from the root node, which the add just stored locally
+func (c *EmbeddedClient) dagSize(ctx context.Context, p path.Path) (uint64, error) {
+	node, err := c.api.ResolveNode(ctx, p)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read added root %s: %w", p, err)
+	}
+	size, err := node.Size()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get size of %s: %w", p, err)
+	}
+	return size, nil
+}
+
+// AddDirectory uploads a local directory as a UnixFS directory node
+func (c *EmbeddedClient) AddDirectory(ctx context.Context, dirPath string, opts AddOptions) (*AddResult, error) {
+	if !c.started {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	// Filestore references need absolute paths
+	absPath, err := filepath.Abs(dirPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", dirPath, err)
+	}
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dirPath)
+	}
+
+	node, size, err := directoryNode(absPath)
+	if err != nil {
+		return nil, err
+	}
+	defer node.Close()
+
+	name := filepath.Base(absPath)
+	pinName := ""
+	if opts.Pin {
+		pinName = name
+	}
+	addOpts := []options.UnixfsAddOption{
+		options.Unixfs.Pin(opts.Pin, pinName),
+		options.Unixfs.RawLeaves(opts.RawLeaves),
+	}
+	if opts.Chunker != "" {
+		addOpts = append(addOpts, options.Unixfs.Chunker(opts.Chunker))
+	}
+	if opts.NoCopy {
+		addOpts = append(addOpts, options.Unixfs.Nocopy(true))
+	}
+
+	p, err := c.api.Unixfs().Add(ctx, node, addOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add directory: %w", translateNoSpace("add", err))
+	}
+
+	return &AddResult{
+		CID:  p.RootCid().String(),
+		Name: name,
+		Size: size,
+	}, nil
+}
+
+// PreflightAdd checks free space on the repo volume against bytes plus gc.min_free_space.
+// If space is short and GC is enabled, it runs a garbage collection and checks again.
+func (c *EmbeddedClient) PreflightAdd(ctx context.Context, bytes uint64) error {
+	if !c.started {
+		return fmt.Errorf("node not started")
+	}
+
+	log := logger.Get()
+
+	reserve := uint64(0)
+	if c.cfg.GC.MinFreeSpace > 0 {
+		reserve = uint64(c.cfg.GC.MinFreeSpace)
+	}
+	required := bytes + reserve
+
+	free, err := freeDiskSpace(c.cfg.RepoPath)
+	if err != nil {
+		log.Debugf("Skipping disk space preflight: %v", err)
+		return nil
+	}
+	if free >= required {
+		return nil
+	}
+
+	if c.cfg.GC.Enabled {
+		log.Warnf("Low disk space on repo volume (%s free, %s required), running garbage collection...",
+			utils.FormatBytes(int64(free)), utils.FormatBytes(int64(required)))
+		removed, freed, err := c.collectGarbage(ctx)
+		if err != nil {
+			log.Errorf("Garbage collection failed: %v", err)
+		} else {
+			log.Infof("Garbage collection removed %d blocks, freed %s", removed, utils.FormatBytes(freed))
+		}
+		if free, err = freeDiskSpace(c.cfg.RepoPath); err == nil && free >= required {
+			log.Infof("Garbage collection freed enough space (%s free)", utils.FormatBytes(int64(free)))
+			return nil
+		}
+	}
+
+	c.spaceCheckFailures.Add(1)
+	return &FatalError{
+		Op: "preflight",
+		Err: fmt.Errorf("%w: %s free on %s, need %s (%s to upload + %s reserve)",
+			ErrNoSpace, utils.FormatBytes(int64(free)), c.cfg.RepoPath, utils.FormatBytes(int64(required)),
+			utils.FormatBytes(int64(bytes)), utils.FormatBytes(int64(reserve))),
+	}
+}
+
+// SpaceCheckFailures returns how many preflight checks failed for lack of disk space
+func (c *EmbeddedClient) SpaceCheckFailures() uint64 {
+	return c.spaceCheckFailures.Load()
+}
+
+// AddIndex uploads the index as IndexFileName inside a UnixFS directory, with
+// the manifest as manifest.FileName if there is one
+func (c *EmbeddedClient) AddIndex(ctx context.Context, data, manifestData []byte, opts AddOptions) (*IndexUploadResult, error) {
+	if !c.started {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	entries := map[string]files.Node{
+		IndexFileName: files.NewBytesFile(data),
+	}
+	if manifestData != nil {
+		entries[manifest.FileName] = files.NewBytesFile(manifestData)
+	}
+	dir := files.NewMapDirectory(entries)
+
+	addOpts := []options.UnixfsAddOption{
+		options.Unixfs.Pin(opts.Pin, IndexFileName),
+		options.Unixfs.RawLeaves(opts.RawLeaves),
+	}
+	if opts.Chunker != "" {
+		addOpts = append(addOpts, options.Unixfs.Chunker(opts.Chunker))
+	}
+
+	root, err := c.api.Unixfs().Add(ctx, dir, addOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add index directory: %w", translateNoSpace("add", err))
+	}
+
+	indexCID, err := c.resolveEntry(ctx, root, IndexFileName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve index file: %w", err)
+	}
+
+	result := &IndexUploadResult{
+		RootCID:  root.RootCid().String(),
+		IndexCID: indexCID,
+	}
+	if manifestData != nil {
+		if result.ManifestCID, err = c.resolveEntry(ctx, root, manifest.FileName); err != nil {
+			return nil, fmt.Errorf("failed to resolve manifest: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// resolveEntry returns the CID of the entry name of the directory at root
+func (c *EmbeddedClient) resolveEntry(ctx context.Context, root path.ImmutablePath, name string) (string, error) {
+	entryPath, err := path.Join(root, name)
+	if err != nil {
+		return "", err
+	}
+
+	resolved, _, err := c.api.ResolvePath(ctx, entryPath)
+	if err != nil {
+		return "", err
+	}
+	return resolved.RootCid().String(), nil
+}
+
 // Cat retrieves file content from IPFS
 func (c *EmbeddedClient) Cat(ctx context.Context, cid string) (io.ReadCloser, error) {
 	if !c.started {
@@ -269,6 +559,28 @@ func (c *EmbeddedClient) Cat(ctx context.Context, cid string) (io.ReadCloser, er
 	return file, nil
 }
 
+// CatRange retrieves length bytes starting at offset from a file
+func (c *EmbeddedClient) CatRange(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error) {
+	reader, err := c.Cat(ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+
+	file := reader.(files.File)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to seek to offset %d: %w", offset, err)
+	}
+
+	return &limitedReadCloser{Reader: io.LimitReader(file, length), Closer: file}, nil
+}
+
+// limitedReadCloser closes the underlying file of a limited reader
+type limitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
 // Pin pins content by CID
 func (c *EmbeddedClient) Pin(ctx context.Context, cid string) error {
 	if !c.started {
@@ -289,6 +601,287 @@ func (c *EmbeddedClient) Pin(ctx context.Context, cid string) error {
 	return nil
 }
 
+// pinWorkers is the number of concurrent pins of PinMany
+const pinWorkers = 8
+
+// PinMany pins CIDs with a pool of pinWorkers concurrent pins and returns the first error
+func (c *EmbeddedClient) PinMany(ctx context.Context, cids []string) error {
+	if !c.started {
+		return fmt.Errorf("node not started")
+	}
+
+	jobs := make(chan string)
+	errs := make(chan error, len(cids))
+
+	var wg sync.WaitGroup
+	for i := 0; i < min(pinWorkers, len(cids)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for cid := range jobs {
+				if err := c.Pin(ctx, cid); err != nil {
+					errs <- fmt.Errorf("failed to pin CID %s: %w", cid, err)
+				}
+			}
+		}()
+	}
+
+	for _, cid := range cids {
+		jobs <- cid
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return fmt.Errorf("failed to pin %d of %d CIDs: %w", len(errs)+1, len(cids), err)
+	}
+	return nil
+}
+
+// Stat resolves the root node of cid and describes the content from it. Only
+// the root block is fetched. kubo no longer offers Object().Stat.
+func (c *EmbeddedClient) Stat(ctx context.Context, cid string) (*StatResult, error) {
+	if !c.started {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	p, err := path.NewPath("/ipfs/" + cid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse path: %w", err)
+	}
+
+	node, err := c.api.ResolveNode(ctx, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get root node of %s: %w", cid, err)
+	}
+	return statNode(node)
+}
+
+// HasLocal reports whether the complete DAG of cid is local: it is pinned
+// recursively, or every block is in the blockstore
+func (c *EmbeddedClient) HasLocal(ctx context.Context, cid string) (bool, error) {
+	if !c.started {
+		return false, fmt.Errorf("node not started")
+	}
+
+	p, err := path.NewPath("/ipfs/" + cid)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse path: %w", err)
+	}
+
+	if _, pinned, err := c.api.Pin().IsPinned(ctx, p, options.Pin.IsPinned.WithType("recursive")); err == nil && pinned {
+		return true, nil
+	}
+
+	root, err := gocid.Decode(cid)
+	if err != nil {
+		return false, fmt.Errorf("failed to parse CID: %w", err)
+	}
+
+	// An offline API fails instead of fetching missing blocks from peers
+	offline, err := c.api.WithOptions(options.Api.Offline(true))
+	if err != nil {
+		return false, fmt.Errorf("failed to create offline API: %w", err)
+	}
+
+	if err := merkledag.FetchGraph(ctx, root, offline.Dag()); err != nil {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// ExportCAR walks the DAG of each root through the offline DAG service and
+// writes its blocks to w as a CARv1 archive
+func (c *EmbeddedClient) ExportCAR(ctx context.Context, roots []string, w io.Writer) (*CARResult, error) {
+	if !c.started {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	cids, err := parseRoots(roots)
+	if err != nil {
+		return nil, err
+	}
+
+	offline, err := c.api.WithOptions(options.Api.Offline(true))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create offline API: %w", err)
+	}
+	dag := offline.Dag()
+
+	car, err := newCARWriter(w, cids)
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range cids {
+		// Depth-first, so the blocks of a file follow each other in the archive
+		stack := []gocid.Cid{root}
+		for len(stack) > 0 {
+			next := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if car.has(next) {
+				continue
+			}
+
+			node, err := dag.Get(ctx, next)
+			if err != nil {
+				return nil, fmt.Errorf("failed to get block %s of %s: %w", next, root, err)
+			}
+			if err := car.writeBlock(next, node.RawData()); err != nil {
+				return nil, err
+			}
+			links := node.Links()
+			for i := len(links) - 1; i >= 0; i-- {
+				stack = append(stack, links[i].Cid)
+			}
+		}
+	}
+	return car.Close()
+}
+
+// TreeRoot returns the CID of the MFS directory dir, or "" if it does not exist
+func (c *EmbeddedClient) TreeRoot(ctx context.Context, dir string) (string, error) {
+	if !c.started {
+		return "", fmt.Errorf("node not started")
+	}
+
+	node, err := mfs.Lookup(c.node.FilesRoot, dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up MFS directory %s: %w", dir, err)
+	}
+	nd, err := node.GetNode()
+	if err != nil {
+		return "", fmt.Errorf("failed to read MFS directory %s: %w", dir, err)
+	}
+	return nd.Cid().String(), nil
+}
+
+// UpdateTree applies changes to the node's MFS and flushes dir once at the end,
+// so each changed directory is written once. Linked content is read offline.
+func (c *EmbeddedClient) UpdateTree(ctx context.Context, dir string, changes TreeChanges) (string, error) {
+	if !c.started {
+		return "", fmt.Errorf("node not started")
+	}
+	root := c.node.FilesRoot
+
+	if err := mfs.Mkdir(root, dir, mfs.MkdirOpts{Mkparents: true}); err != nil {
+		return "", fmt.Errorf("failed to create MFS directory: %w", translateNoSpace("mkdir", err))
+	}
+
+	for _, p := range changes.Unlink {
+		if err := mfsUnlink(root, dir+"/"+p); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to unlink %s: %w", p, err)
+		}
+	}
+	for _, parent := range changes.Parents() {
+		node, err := mfs.Lookup(root, dir+"/"+parent)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to look up directory %s: %w", parent, err)
+		}
+		sub, ok := node.(*mfs.Directory)
+		if !ok {
+			continue
+		}
+		names, err := sub.ListNames(ctx)
+		if err != nil {
+			return "", fmt.Errorf("failed to list directory %s: %w", parent, err)
+		}
+		if len(names) == 0 {
+			if err := mfsUnlink(root, dir+"/"+parent); err != nil {
+				return "", fmt.Errorf("failed to remove empty directory %s: %w", parent, err)
+			}
+		}
+	}
+
+	offline, err := c.api.WithOptions(options.Api.Offline(true))
+	if err != nil {
+		return "", fmt.Errorf("failed to create offline API: %w", err)
+	}
+	dag := offline.Dag()
+	for _, p := range changes.LinkPaths() {
+		id, err := gocid.Decode(changes.Link[p])
+		if err != nil {
+			return "", fmt.Errorf("invalid CID %q for %s: %w", changes.Link[p], p, err)
+		}
+		nd, err := dag.Get(ctx, id)
+		if err != nil {
+			return "", fmt.Errorf("failed to get %s for %s: %w", id, p, err)
+		}
+
+		target := dir + "/" + p
+		parent, _ := splitMFSPath(target)
+		if err := mfs.Mkdir(root, parent, mfs.MkdirOpts{Mkparents: true}); err != nil {
+			return "", fmt.Errorf("failed to create directory for %s: %w", p, translateNoSpace("mkdir", err))
+		}
+		// A changed path: replace what it linked before
+		if err := mfsUnlink(root, target); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return "", fmt.Errorf("failed to unlink %s: %w", p, err)
+		}
+		if err := mfs.PutNode(root, target, nd); err != nil {
+			return "", fmt.Errorf("failed to link %s at %s: %w", id, p, translateNoSpace("link", err))
+		}
+	}
+
+	nd, err := mfs.FlushPath(ctx, root, dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to flush MFS directory %s: %w", dir, translateNoSpace("flush", err))
+	}
+	return nd.Cid().String(), nil
+}
+
+// RemoveTree removes the MFS directory dir if it exists
+func (c *EmbeddedClient) RemoveTree(ctx context.Context, dir string) error {
+	if !c.started {
+		return fmt.Errorf("node not started")
+	}
+
+	err := mfsUnlink(c.node.FilesRoot, dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err == nil {
+		parent, _ := splitMFSPath(dir)
+		_, err = mfs.FlushPath(ctx, c.node.FilesRoot, parent)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove MFS directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// mfsUnlink removes the entry at the MFS path p from its parent directory
+func mfsUnlink(root *mfs.Root, p string) error {
+	parent, name := splitMFSPath(p)
+	node, err := mfs.Lookup(root, parent)
+	if err != nil {
+		return err
+	}
+	dir, ok := node.(*mfs.Directory)
+	if !ok {
+		return fmt.Errorf("%s is not a directory", parent)
+	}
+	return dir.Unlink(name)
+}
+
+// splitMFSPath splits an absolute MFS path into its parent directory and name
+func splitMFSPath(p string) (parent, name string) {
+	i := strings.LastIndex(p, "/")
+	if i <= 0 {
+		return "/", p[i+1:]
+	}
+	return p[:i], p[i+1:]
+}
+
 // Unpin unpins content by CID
 func (c *EmbeddedClient) Unpin(ctx context.Context, cid string) error {
 	if !c.started {
@@ -309,6 +902,29 @@ func (c *EmbeddedClient) Unpin(ctx context.Context, cid string) error {
 	return nil
 }
 
+// ListPins lists every pin of the node, walking recursive pins for the indirect ones
+func (c *EmbeddedClient) ListPins(ctx context.Context) (map[string]string, error) {
+	if !c.started {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	// Ls sends the pins and closes the channel when done
+	ch := make(chan iface.Pin)
+	lsErr := make(chan error, 1)
+	go func() {
+		lsErr <- c.api.Pin().Ls(ctx, ch)
+	}()
+
+	pins := make(map[string]string)
+	for pin := range ch {
+		pins[pin.Path().RootCid().String()] = pin.Type()
+	}
+	if err := <-lsErr; err != nil {
+		return nil, fmt.Errorf("failed to list pins: %w", err)
+	}
+	return pins, nil
+}
+
 // PublishIPNS publishes an IPFS path to IPNS
 func (c *EmbeddedClient) PublishIPNS(ctx context.Context, cid string, opts IPNSPublishOptions) (*IPNSPublishResult, error) {
 	if !c.started {
@@ -359,6 +975,32 @@ func (c *EmbeddedClient) PublishIPNS(ctx context.Context, cid string, opts IPNSP
 	return result, nil
 }
 
+// EnsureKey returns the IPNS name of the named key, generating an Ed25519 key if needed
+func (c *EmbeddedClient) EnsureKey(ctx context.Context, name string) (string, error) {
+	if !c.started {
+		return "", fmt.Errorf("node not started")
+	}
+
+	keys, err := c.api.Key().List(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	for _, k := range keys {
+		if k.Name() == name {
+			return strings.TrimPrefix(k.Path().String(), "/ipns/"), nil
+		}
+	}
+
+	k, err := c.api.Key().Generate(ctx, name, options.Key.Type(options.Ed25519Key))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate key %s: %w", name, err)
+	}
+
+	logger.Get().Infof("Generated IPNS key %s", name)
+	return strings.TrimPrefix(k.Path().String(), "/ipns/"), nil
+}
+
 // ResolveIPNS resolves an IPNS name to an IPFS path
 func (c *EmbeddedClient) ResolveIPNS(ctx context.Context, name string) (string, error) {
 	if !c.started {
@@ -391,6 +1033,47 @@ func (c *EmbeddedClient) ResolveIPNS(ctx context.Context, name string) (string,
 	return resolvedPath, nil
 }
 
+// FindProviders looks up providers of cid with the node's routing system until
+// max providers are found or ctx ends
+func (c *EmbeddedClient) FindProviders(ctx context.Context, cid string, max int) ([]string, error) {
+	if !c.started {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	p, err := path.NewPath("/ipfs/" + cid)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse path: %w", err)
+	}
+
+	found, err := c.api.Routing().FindProviders(ctx, p, options.Routing.NumProviders(max))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find providers of %s: %w", cid, err)
+	}
+
+	var providers []string
+	for info := range found {
+		providers = append(providers, info.ID.String())
+	}
+	return providers, nil
+}
+
+// Provide announces cid to the node's routing system
+func (c *EmbeddedClient) Provide(ctx context.Context, cid string) error {
+	if !c.started {
+		return fmt.Errorf("node not started")
+	}
+
+	p, err := path.NewPath("/ipfs/" + cid)
+	if err != nil {
+		return fmt.Errorf("failed to parse path: %w", err)
+	}
+
+	if err := c.api.Routing().Provide(ctx, p); err != nil {
+		return fmt.Errorf("failed to provide %s: %w", cid, err)
+	}
+	return nil
+}
+
 // PublishToPubSub publishes a message to a PubSub topic using the embedded IPFS node's PubSub
 func (c *EmbeddedClient) PublishToPubSub(ctx context.Context, topic string, data []byte) error {
 	if !c.started {
@@ -414,6 +1097,77 @@ func (c *EmbeddedClient) PublishToPubSub(ctx context.Context, topic string, data
 	return nil
 }
 
+// CountTopicPeers returns the number of peers subscribed to a PubSub topic on the embedded node
+func (c *EmbeddedClient) CountTopicPeers(ctx context.Context, topic string) (int, error) {
+	if !c.started {
+		return 0, fmt.Errorf("node not started")
+	}
+
+	peers, err := c.ListTopicPeers(ctx, topic)
+	if err != nil {
+		return 0, err
+	}
+	return len(peers), nil
+}
+
+// ListTopicPeers returns the IDs of the peers subscribed to a PubSub topic on the embedded node
+func (c *EmbeddedClient) ListTopicPeers(ctx context.Context, topic string) ([]string, error) {
+	if !c.started {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	peers, err := c.api.PubSub().Peers(ctx, options.PubSub.Topic(topic))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list peers of topic %s: %w", topic, err)
+	}
+	ids := make([]string, len(peers))
+	for i, id := range peers {
+		ids[i] = id.String()
+	}
+	return ids, nil
+}
+
+// topicQueueSize is the number of received messages buffered per SubscribeTopic subscription
+const topicQueueSize = 32
+
+// SubscribeTopic subscribes to a PubSub topic using the embedded IPFS node's PubSub and
+// delivers the messages other peers publish to it until ctx is done
+func (c *EmbeddedClient) SubscribeTopic(ctx context.Context, topic string) (<-chan []byte, error) {
+	if !c.started {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	sub, err := c.api.PubSub().Subscribe(ctx, topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to topic %s: %w", topic, err)
+	}
+
+	self := c.node.Identity
+	messages := make(chan []byte, topicQueueSize)
+	go func() {
+		defer close(messages)
+		defer sub.Close()
+
+		for {
+			msg, err := sub.Next(ctx)
+			if err != nil {
+				return
+			}
+			if msg.From() == self {
+				continue
+			}
+
+			select {
+			case messages <- msg.Data():
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return messages, nil
+}
+
 // GetPeerAddresses returns the multiaddresses this node is listening on
 func (c *EmbeddedClient) GetPeerAddresses(ctx context.Context) ([]string, error) {
 	if !c.started {
@@ -439,6 +1193,67 @@ func (c *EmbeddedClient) GetPeerAddresses(ctx context.Context) ([]string, error)
 	return multiaddrs, nil
 }
 
+// PeerCount returns the number of peers the embedded node is connected to, 0
+// before it is started
+func (c *EmbeddedClient) PeerCount() int {
+	if !c.started || c.node == nil || c.node.PeerHost == nil {
+		return 0
+	}
+	return len(c.node.PeerHost.Network().Peers())
+}
+
+// RepoStat returns statistics of the embedded node's repository
+func (c *EmbeddedClient) RepoStat(ctx context.Context) (*RepoStats, error) {
+	if !c.started || c.node == nil {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	stat, err := corerepo.RepoStat(ctx, c.node)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repo stats: %w", err)
+	}
+
+	return &RepoStats{
+		RepoSize:   stat.RepoSize,
+		StorageMax: stat.StorageMax,
+		NumObjects: stat.NumObjects,
+		RepoPath:   stat.RepoPath,
+		Version:    stat.Version,
+	}, nil
+}
+
+// SwarmConnect connects the embedded node to the peer at peerAddr
+func (c *EmbeddedClient) SwarmConnect(ctx context.Context, peerAddr string) error {
+	if !c.started {
+		return fmt.Errorf("node not started")
+	}
+
+	info, err := parsePeerAddr(peerAddr)
+	if err != nil {
+		return err
+	}
+	if err := c.api.Swarm().Connect(ctx, *info); err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", peerAddr, err)
+	}
+	return nil
+}
+
+// SwarmDisconnect closes the embedded node's connections to the peer peerID
+func (c *EmbeddedClient) SwarmDisconnect(ctx context.Context, peerID string) error {
+	if !c.started {
+		return fmt.Errorf("node not started")
+	}
+
+	addr, err := peerOnlyAddr(peerID)
+	if err != nil {
+		return err
+	}
+	if err := c.api.Swarm().Disconnect(ctx, addr); err != nil {
+		return fmt.Errorf("failed to disconnect from %s: %w", peerID, err)
+	}
+	return nil
+}
+
 // IsAvailable checks if the embedded node is running
 func (c *EmbeddedClient) IsAvailable(ctx context.Context) error {
 	if !c.started || c.node == nil {
@@ -489,6 +1304,11 @@ func (c *EmbeddedClient) Close() error {
 		c.cancel()
 	}
 
+	// A collection in progress must not race the node shutdown
+	if c.gcDone != nil {
+		<-c.gcDone
+	}
+
 	// Close the node
 	if c.node != nil {
 		if err := c.node.Close(); err != nil {
@@ -0,0 +1,245 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ipfs/boxo/path"
+	gocid "github.com/ipfs/go-cid"
+	"github.com/ipfs/kubo/core"
+	"github.com/ipfs/kubo/core/coreapi"
+)
+
+// newOfflineClient returns an embedded client over an offline node with an
+// in-memory repo
+func newOfflineClient(t testing.TB) *EmbeddedClient {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	node, err := core.NewNode(ctx, &core.BuildCfg{Online: false})
+	if err != nil {
+		cancel()
+		t.Fatalf("failed to create node: %v", err)
+	}
+	api, err := coreapi.NewCoreAPI(node)
+	if err != nil {
+		node.Close()
+		cancel()
+		t.Fatalf("failed to create core API: %v", err)
+	}
+	t.Cleanup(func() {
+		node.Close()
+		cancel()
+	})
+	return &EmbeddedClient{node: node, api: api, ctx: ctx, cancel: cancel, started: true}
+}
+
+// sparseFile creates a file of size bytes that takes no disk space and reads as
+// zeros, and returns it opened at its start
+func sparseFile(tb testing.TB, size int64) (*os.File, os.FileInfo) {
+	tb.Helper()
+
+	filePath := filepath.Join(tb.TempDir(), "large.mkv")
+	f, err := os.Create(filePath)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { f.Close() })
+	if err := f.Truncate(size); err != nil {
+		tb.Skipf("sparse files not supported: %v", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return f, info
+}
+
+// peakHeap calls add while sampling the heap and returns the highest HeapInuse seen
+func peakHeap(add func()) uint64 {
+	runtime.GC()
+
+	var peak uint64
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(20 * time.Millisecond)
+		defer ticker.Stop()
+		var stats runtime.MemStats
+		for {
+			runtime.ReadMemStats(&stats)
+			peak = max(peak, stats.HeapInuse)
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	add()
+	close(done)
+	wg.Wait()
+	return peak
+}
+
+func TestEmbeddedAddStreamsLargeFile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("adds a multi-gigabyte file")
+	}
+	const fileSize = 3 << 30
+	const maxHeap = 256 << 20 // Far below the file size
+
+	f, info := sparseFile(t, fileSize)
+	c := newOfflineClient(t)
+
+	var result *AddResult
+	var err error
+	peak := peakHeap(func() {
+		result, err = c.Add(context.Background(), f, f.Name(), AddOptions{RawLeaves: true, FileInfo: info})
+	})
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	if peak > maxHeap {
+		t.Errorf("heap peaked at %d MiB while adding a %d MiB file, want below %d MiB", peak>>20, fileSize>>20, maxHeap>>20)
+	}
+	if result.Size < fileSize {
+		t.Errorf("Size = %d, want the DAG size, at least the %d bytes of the file", result.Size, fileSize)
+	}
+
+	// The reader stays open for the caller
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		t.Errorf("reader closed by Add: %v", err)
+	}
+}
+
+// BenchmarkEmbeddedAddPeakHeap reports the peak heap while adding a 4 GB file,
+// streamed as Add reads it and buffered in memory first, as Add used to. The
+// buffered run needs more than 4 GB of free memory.
+func BenchmarkEmbeddedAddPeakHeap(b *testing.B) {
+	const fileSize = 4 << 30
+
+	for _, buffered := range []bool{false, true} {
+		name := "streamed"
+		if buffered {
+			name = "buffered"
+		}
+		b.Run(name, func(b *testing.B) {
+			f, info := sparseFile(b, fileSize)
+			c := newOfflineClient(b)
+			b.SetBytes(fileSize)
+
+			var peak uint64
+			for b.Loop() {
+				if _, err := f.Seek(0, io.SeekStart); err != nil {
+					b.Fatal(err)
+				}
+				peak = max(peak, peakHeap(func() {
+					var reader io.Reader = f
+					if buffered {
+						data, err := io.ReadAll(f)
+						if err != nil {
+							b.Fatal(err)
+						}
+						reader = bytes.NewReader(data)
+					}
+					if _, err := c.Add(context.Background(), reader, f.Name(), AddOptions{RawLeaves: true, FileInfo: info}); err != nil {
+						b.Fatalf("Add: %v", err)
+					}
+				}))
+			}
+			b.ReportMetric(float64(peak>>20), "peak-heap-MiB")
+		})
+	}
+}
+
+func TestEmbeddedAddAppliesChunkerAndRawLeaves(t *testing.T) {
+	c := newOfflineClient(t)
+	ctx := context.Background()
+
+	data := make([]byte, 4096)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rawLeaves bool
+		codec     uint64
+	}{
+		{true, gocid.Raw},
+		{false, gocid.DagProtobuf},
+	}
+	for _, tt := range tests {
+		result, err := c.Add(ctx, bytes.NewReader(data), "file.bin", AddOptions{Chunker: "size-1024", RawLeaves: tt.rawLeaves})
+		if err != nil {
+			t.Fatalf("Add(rawLeaves=%t): %v", tt.rawLeaves, err)
+		}
+
+		root, err := gocid.Decode(result.CID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		node, err := c.api.ResolveNode(ctx, path.FromCid(root))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		links := node.Links()
+		if len(links) != 4 {
+			t.Errorf("rawLeaves=%t: root has %d links, want 4 chunks of 1024 bytes", tt.rawLeaves, len(links))
+		}
+		for _, link := range links {
+			if codec := link.Cid.Prefix().Codec; codec != tt.codec {
+				t.Errorf("rawLeaves=%t: leaf %s has codec %#x, want %#x", tt.rawLeaves, link.Cid, codec, tt.codec)
+			}
+		}
+		if result.Size <= uint64(len(data)) {
+			t.Errorf("rawLeaves=%t: Size = %d, want the DAG size above the %d data bytes", tt.rawLeaves, result.Size, len(data))
+		}
+	}
+}
+
+func TestEmbeddedCollectGarbage(t *testing.T) {
+	c := newOfflineClient(t)
+	ctx := context.Background()
+
+	add := func(pin bool) string {
+		data := make([]byte, 4096)
+		if _, err := rand.Read(data); err != nil {
+			t.Fatal(err)
+		}
+		result, err := c.Add(ctx, bytes.NewReader(data), "file.bin", AddOptions{Pin: pin, Chunker: "size-1024", RawLeaves: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result.CID
+	}
+	unpinned, pinned := add(false), add(true)
+
+	removed, _, err := c.collectGarbage(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The root and the four chunks of the unpinned file
+	if removed < 5 {
+		t.Errorf("removed %d blocks, want at least 5", removed)
+	}
+	if local, err := c.HasLocal(ctx, unpinned); err != nil || local {
+		t.Errorf("unpinned file still local after GC (%v)", err)
+	}
+	if local, err := c.HasLocal(ctx, pinned); err != nil || !local {
+		t.Errorf("pinned file collected (%v)", err)
+	}
+}
@@ -0,0 +1,109 @@
+package ipfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// ErrNoSpace indicates that the repository volume has no room for more data
+var ErrNoSpace = errors.New("insufficient disk space for IPFS repository")
+
+// ErrFilestoreDisabled indicates that nocopy adds were requested from a node
+// without the filestore
+var ErrFilestoreDisabled = errors.New("add_options.nocopy requires Experimental.FilestoreEnabled on the IPFS node (ipfs config --json Experimental.FilestoreEnabled true)")
+
+// FatalError marks errors that will not go away by retrying the same operation
+// (e.g. a full disk). Callers should pause uploads instead of hammering the node.
+type FatalError struct {
+	Op  string
+	Err error
+}
+
+func (e *FatalError) Error() string {
+	return e.Op + ": " + e.Err.Error()
+}
+
+func (e *FatalError) Unwrap() error {
+	return e.Err
+}
+
+// IsFatal reports whether err is (or wraps) a FatalError
+func IsFatal(err error) bool {
+	var fatal *FatalError
+	return errors.As(err, &fatal)
+}
+
+// noSpaceMessages are substrings of daemon errors caused by a full disk
+var noSpaceMessages = []string{
+	"no space left on device",
+	"not enough space",
+	"disk full",
+}
+
+// translateNoSpace converts "no space" errors into a FatalError wrapping ErrNoSpace
+func translateNoSpace(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, m := range noSpaceMessages {
+		if strings.Contains(msg, m) {
+			return &FatalError{Op: op, Err: errors.Join(ErrNoSpace, err)}
+		}
+	}
+
+	return err
+}
+
+// filestoreDisabledMessage is the daemon error for nocopy adds without the filestore
+const filestoreDisabledMessage = "filestore is not enabled"
+
+// translateAddError converts add errors that every further add would repeat,
+// a full disk or a disabled filestore, into a FatalError
+func translateAddError(err error) error {
+	if err != nil && strings.Contains(err.Error(), filestoreDisabledMessage) {
+		return &FatalError{Op: "add", Err: errors.Join(ErrFilestoreDisabled, err)}
+	}
+	return translateNoSpace("add", err)
+}
+
+// transientMessages are substrings of errors reaching the node that may not
+// repeat, for clients that flatten the underlying error into text
+var transientMessages = []string{
+	"connection refused",
+	"connection reset",
+	"broken pipe",
+	"i/o timeout",
+	"unexpected eof",
+}
+
+// IsTransient reports whether err is a failure to reach the node that may go
+// away when the operation is tried again: a refused or reset connection, a
+// timeout or a response cut off. A cancelled context is not transient, nor is
+// a request failed fast by an open circuit breaker.
+func IsTransient(err error) bool {
+	if err == nil || IsFatal(err) || errors.Is(err, context.Canceled) || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, m := range transientMessages {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package ipfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"connection refused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, true},
+		{"deadline", fmt.Errorf("add: %w", context.DeadlineExceeded), true},
+		{"flattened", errors.New(`Post "http://127.0.0.1:5001/api/v0/add": read: connection reset by peer`), true},
+		{"cancelled", fmt.Errorf("add: %w", context.Canceled), false},
+		{"file vanished", &fs.PathError{Op: "open", Path: "/music/a.mp3", Err: fs.ErrNotExist}, false},
+		{"permission denied", &fs.PathError{Op: "open", Path: "/music/a.mp3", Err: fs.ErrPermission}, false},
+		{"no space", translateNoSpace("add", errors.New("write: no space left on device")), false},
+		{"circuit open", fmt.Errorf("add: %w", &CircuitOpenError{Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), false},
+		{"nil", nil, false},
+	}
+	for _, tt := range tests {
+		if got := IsTransient(tt.err); got != tt.want {
+			t.Errorf("%s: IsTransient(%v) = %v, want %v", tt.name, tt.err, got, tt.want)
+		}
+	}
+}
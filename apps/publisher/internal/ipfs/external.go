@@ -1,12 +1,25 @@
 package ipfs
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/atregu/ipfs-common/manifest"
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/ipfs/boxo/files"
+	gocid "github.com/ipfs/go-cid"
 	shell "github.com/ipfs/go-ipfs-api"
+	"github.com/multiformats/go-multibase"
 )
 
 // ExternalClient implements the Client interface for external IPFS nodes via HTTP API
@@ -14,6 +27,9 @@ type ExternalClient struct {
 	shell   *shell.Shell
 	apiURL  string
 	timeout time.Duration
+	chunked *config.ChunkedAddConfig // Set by EnableChunkedAdd
+	parts   PartStore
+	breaker *Breaker // Set by EnableCircuitBreaker
 }
 
 // NewExternalClient creates a new external IPFS client
@@ -30,29 +46,237 @@ func NewExternalClient(apiURL string, timeout time.Duration) (*ExternalClient, e
 	}, nil
 }
 
-// Add uploads a file to IPFS and returns its CID
+// EnableCircuitBreaker sends every request through a Breaker that fails fast
+// with ErrCircuitOpen once cfg.FailureThreshold consecutive requests could not
+// reach the node, and returns it. It returns nil if the threshold is 0, or for
+// an API address that is a Unix socket multiaddr.
+func (c *ExternalClient) EnableCircuitBreaker(cfg *config.CircuitBreakerConfig) *Breaker {
+	if cfg.FailureThreshold <= 0 {
+		return nil
+	}
+	// go-ipfs-api dials Unix sockets through its own transport only
+	if strings.HasPrefix(c.apiURL, "/unix/") {
+		logger.Get().Warn("ipfs.external.circuit_breaker is not supported with a Unix socket api_url and is disabled")
+		return nil
+	}
+
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment, DisableKeepAlives: true}
+	probe := shell.NewShellWithClient(c.apiURL, &http.Client{Transport: transport})
+	c.breaker = NewBreaker(transport, func(ctx context.Context) error {
+		return probe.Request("version").Exec(ctx, nil)
+	}, cfg.FailureThreshold, cfg.CooldownDuration())
+
+	c.shell = shell.NewShellWithClient(c.apiURL, &http.Client{Transport: c.breaker})
+	c.shell.SetTimeout(c.timeout)
+	return c.breaker
+}
+
+// Breaker returns the circuit breaker set by EnableCircuitBreaker, or nil
+func (c *ExternalClient) Breaker() *Breaker {
+	return c.breaker
+}
+
+// untimedShell returns a shell without ipfs.external.timeout, for requests
+// that may outlast it, going through the circuit breaker if it is enabled
+func (c *ExternalClient) untimedShell() *shell.Shell {
+	if c.breaker == nil {
+		return shell.NewShell(c.apiURL)
+	}
+	return shell.NewShellWithClient(c.apiURL, &http.Client{Transport: c.breaker})
+}
+
+// Add uploads a file to IPFS and returns its CID and DAG size. With nocopy,
+// filename must be the path of the file on the node's host.
 func (c *ExternalClient) Add(ctx context.Context, reader io.Reader, filename string, opts AddOptions) (*AddResult, error) {
-	// Build add options
+	// Large files are added in resumable parts when the reader allows random
+	// access. Filestore references cannot be made through parts.
+	if !opts.NoCopy && c.useChunkedAdd(opts.FileInfo) {
+		if r, ok := reader.(io.ReaderAt); ok {
+			return c.addChunked(ctx, r, filename, opts.FileInfo, opts)
+		}
+	}
+
+	reader = withProgress(reader, opts)
+
+	// The filestore references the file by its absolute path, which the node
+	// reads from its own filesystem
+	var file files.Node = files.NewReaderStatFile(io.NopCloser(reader), opts.FileInfo)
+	if opts.NoCopy {
+		var err error
+		if file, err = files.NewReaderPathFile(filename, io.NopCloser(reader), opts.FileInfo); err != nil {
+			return nil, fmt.Errorf("nocopy mode: failed to resolve file path: %w", err)
+		}
+	}
+
+	cid, size, err := c.addFile(ctx, file, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add file to IPFS: %w", err)
+	}
+
+	return &AddResult{
+		CID:  cid,
+		Name: filename,
+		Size: size,
+	}, nil
+}
+
+// addFile sends a single file to /api/v0/add with all add options and returns
+// its CID and DAG size
+func (c *ExternalClient) addFile(ctx context.Context, file files.Node, opts AddOptions) (string, uint64, error) {
+	body := files.NewMultiFileReader(files.NewSliceDirectory([]files.DirEntry{
+		files.FileEntry("", file),
+	}), true, false)
+
+	req := c.shell.Request("add").Option("progress", false)
+	for _, option := range addOptions(opts) {
+		if err := option(req); err != nil {
+			return "", 0, err
+		}
+	}
+
+	var out struct {
+		Hash string
+		Size json.Number // Cumulative DAG size, sent as a string
+	}
+	if err := req.Body(body).Exec(ctx, &out); err != nil {
+		return "", 0, translateAddError(err)
+	}
+
+	// Older daemons do not report the size
+	size, _ := strconv.ParseUint(out.Size.String(), 10, 64)
+	return out.Hash, size, nil
+}
+
+// addOptions converts add options into /api/v0/add options, including those
+// go-ipfs-api has no helper for
+func addOptions(opts AddOptions) []shell.AddOpts {
 	addOpts := []shell.AddOpts{
 		shell.Pin(opts.Pin), // Explicitly set pin option
 	}
-
 	if opts.RawLeaves {
 		addOpts = append(addOpts, shell.RawLeaves(true))
 	}
+	if opts.Chunker != "" {
+		addOpts = append(addOpts, addOption("chunker", opts.Chunker))
+	}
+	if opts.NoCopy {
+		addOpts = append(addOpts, addOption("nocopy", true))
+	}
+	return addOpts
+}
+
+// addOption sets an /api/v0/add option by name
+func addOption(key string, value any) shell.AddOpts {
+	return func(rb *shell.RequestBuilder) error {
+		rb.Option(key, value)
+		return nil
+	}
+}
 
-	// Note: NoCopy and Chunker options are not exposed in go-ipfs-api v0.7.0
-	// They would need to be added via the underlying HTTP request if needed
+// CheckFilestore returns ErrFilestoreDisabled unless the node has
+// Experimental.FilestoreEnabled set, which nocopy adds require
+func (c *ExternalClient) CheckFilestore(ctx context.Context) error {
+	var out struct {
+		Value any
+	}
+	if err := c.shell.Request("config", "Experimental.FilestoreEnabled").Exec(ctx, &out); err != nil {
+		return fmt.Errorf("failed to read Experimental.FilestoreEnabled: %w", err)
+	}
+	if enabled, _ := out.Value.(bool); !enabled {
+		return ErrFilestoreDisabled
+	}
+	return nil
+}
 
-	// Add file to IPFS
-	cid, err := c.shell.Add(reader, addOpts...)
+// AddDirectory uploads a local directory recursively through the RPC API's AddDir
+func (c *ExternalClient) AddDirectory(ctx context.Context, dirPath string, opts AddOptions) (*AddResult, error) {
+	info, err := os.Stat(dirPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to add file to IPFS: %w", err)
+		return nil, fmt.Errorf("failed to stat directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dirPath)
+	}
+
+	// The daemon only returns the CID
+	size, err := DirectorySize(dirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	cid, err := c.shell.AddDir(dirPath, addOptions(opts)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to add directory to IPFS: %w", translateAddError(err))
 	}
 
 	return &AddResult{
 		CID:  cid,
-		Name: filename,
+		Name: filepath.Base(filepath.Clean(dirPath)),
+		Size: uint64(size),
+	}, nil
+}
+
+// PreflightAdd is a no-op for external nodes because the remote disk is unknown.
+// "No space" errors from the daemon are translated into FatalError by Add.
+func (c *ExternalClient) PreflightAdd(ctx context.Context, bytes uint64) error {
+	return nil
+}
+
+// AddIndex uploads the index and the manifest, if any, and wraps them in a
+// directory assembled in MFS, since the HTTP API has no way to add an in-memory
+// directory
+func (c *ExternalClient) AddIndex(ctx context.Context, data, manifestData []byte, opts AddOptions) (*IndexUploadResult, error) {
+	fileRes, err := c.Add(ctx, bytes.NewReader(data), IndexFileName, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifestCID string
+	if manifestData != nil {
+		manifestRes, err := c.Add(ctx, bytes.NewReader(manifestData), manifest.FileName, opts)
+		if err != nil {
+			return nil, err
+		}
+		manifestCID = manifestRes.CID
+	}
+
+	// Stage the directory under a unique MFS path and remove it afterwards
+	stagingDir := fmt.Sprintf("/.ipfs-publisher/index-%d", time.Now().UnixNano())
+	defer func() {
+		if err := c.shell.FilesRm(context.Background(), stagingDir, true); err != nil {
+			logger.Get().Debugf("Failed to remove MFS staging dir %s: %v", stagingDir, err)
+		}
+	}()
+
+	if err := c.shell.FilesMkdir(ctx, stagingDir, shell.FilesMkdir.Parents(true)); err != nil {
+		return nil, fmt.Errorf("failed to create MFS directory: %w", translateNoSpace("mkdir", err))
+	}
+
+	if err := c.shell.FilesCp(ctx, "/ipfs/"+fileRes.CID, stagingDir+"/"+IndexFileName); err != nil {
+		return nil, fmt.Errorf("failed to copy index into MFS directory: %w", err)
+	}
+
+	if manifestCID != "" {
+		if err := c.shell.FilesCp(ctx, "/ipfs/"+manifestCID, stagingDir+"/"+manifest.FileName); err != nil {
+			return nil, fmt.Errorf("failed to copy manifest into MFS directory: %w", err)
+		}
+	}
+
+	stat, err := c.shell.FilesStat(ctx, stagingDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat MFS directory: %w", err)
+	}
+
+	if opts.Pin {
+		if err := c.Pin(ctx, stat.Hash); err != nil {
+			return nil, fmt.Errorf("failed to pin index directory: %w", err)
+		}
+	}
+
+	return &IndexUploadResult{
+		RootCID:     stat.Hash,
+		IndexCID:    fileRes.CID,
+		ManifestCID: manifestCID,
 	}, nil
 }
 
@@ -65,6 +289,22 @@ func (c *ExternalClient) Cat(ctx context.Context, cid string) (io.ReadCloser, er
 	return reader, nil
 }
 
+// CatRange retrieves length bytes starting at offset via /api/v0/cat
+func (c *ExternalClient) CatRange(ctx context.Context, cid string, offset, length int64) (io.ReadCloser, error) {
+	resp, err := c.shell.Request("cat", cid).
+		Option("offset", offset).
+		Option("length", length).
+		Send(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to cat CID %s: %w", cid, err)
+	}
+	if resp.Error != nil {
+		resp.Close()
+		return nil, fmt.Errorf("failed to cat CID %s: %w", cid, resp.Error)
+	}
+	return resp.Output, nil
+}
+
 // Pin pins content in IPFS
 func (c *ExternalClient) Pin(ctx context.Context, cid string) error {
 	if err := c.shell.Pin(cid); err != nil {
@@ -73,6 +313,233 @@ func (c *ExternalClient) Pin(ctx context.Context, cid string) error {
 	return nil
 }
 
+// ListPins lists every pin of the daemon via /api/v0/pin/ls. It does what
+// shell.Pins does, but can be cancelled through ctx.
+func (c *ExternalClient) ListPins(ctx context.Context) (map[string]string, error) {
+	var ls struct {
+		Keys map[string]struct{ Type string }
+	}
+	if err := c.shell.Request("pin/ls").Option("type", "all").Exec(ctx, &ls); err != nil {
+		return nil, fmt.Errorf("failed to list pins: %w", err)
+	}
+
+	pins := make(map[string]string, len(ls.Keys))
+	for cid, pin := range ls.Keys {
+		pins[cid] = pin.Type
+	}
+	return pins, nil
+}
+
+// pinManyChunk is the number of CIDs pinned per /api/v0/pin/add call
+const pinManyChunk = 100
+
+// PinMany pins CIDs recursively with one /api/v0/pin/add call per pinManyChunk CIDs
+func (c *ExternalClient) PinMany(ctx context.Context, cids []string) error {
+	for start := 0; start < len(cids); start += pinManyChunk {
+		chunk := cids[start:min(start+pinManyChunk, len(cids))]
+		err := c.shell.Request("pin/add", chunk...).
+			Option("recursive", true).
+			Option("progress", false).
+			Exec(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to pin %d CIDs: %w", len(chunk), err)
+		}
+	}
+	return nil
+}
+
+// Stat describes the content of cid via /api/v0/files/stat; the daemon no
+// longer serves object/stat, which shell.ObjectStat calls
+func (c *ExternalClient) Stat(ctx context.Context, cid string) (*StatResult, error) {
+	var stat struct {
+		Hash           string
+		Size           uint64
+		CumulativeSize uint64
+		Blocks         int
+		Type           string
+	}
+	if err := c.shell.Request("files/stat", "/ipfs/"+cid).Exec(ctx, &stat); err != nil {
+		return nil, fmt.Errorf("failed to stat CID %s: %w", cid, err)
+	}
+
+	result := &StatResult{CID: stat.Hash, Size: stat.Size, NumLinks: stat.Blocks, Type: StatTypeFile}
+	switch stat.Type {
+	case "file":
+	case "directory":
+		result.Size, result.Type = stat.CumulativeSize, StatTypeDir
+	default:
+		return nil, fmt.Errorf("unsupported content type %q of %s", stat.Type, cid)
+	}
+	return result, nil
+}
+
+// HasLocal reports whether the daemon holds the complete DAG of cid. A recursive
+// pin is checked first; unpinned content counts if dag/stat walks every block with
+// --offline, so missing blocks are not fetched from peers.
+func (c *ExternalClient) HasLocal(ctx context.Context, cid string) (bool, error) {
+	var pins struct {
+		Keys map[string]struct{ Type string }
+	}
+	err := c.shell.Request("pin/ls", cid).Option("type", "recursive").Option("offline", true).Exec(ctx, &pins)
+	if err == nil {
+		return true, nil
+	}
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	if !strings.Contains(err.Error(), "not pinned") {
+		return false, fmt.Errorf("failed to list pins of %s: %w", cid, err)
+	}
+
+	var stat struct {
+		TotalSize uint64
+	}
+	err = c.shell.Request("dag/stat", cid).Option("progress", false).Option("offline", true).Exec(ctx, &stat)
+	if err == nil {
+		return true, nil
+	}
+	if ctx.Err() != nil {
+		return false, ctx.Err()
+	}
+	if strings.Contains(err.Error(), "not found") {
+		return false, nil
+	}
+	return false, fmt.Errorf("failed to stat DAG %s: %w", cid, err)
+}
+
+// ExportCAR exports each root with /api/v0/dag/export and merges the archives
+// into one, leaving out blocks the DAGs share. --offline keeps the daemon from
+// fetching missing blocks from peers.
+func (c *ExternalClient) ExportCAR(ctx context.Context, roots []string, w io.Writer) (*CARResult, error) {
+	cids, err := parseRoots(roots)
+	if err != nil {
+		return nil, err
+	}
+
+	car, err := newCARWriter(w, cids)
+	if err != nil {
+		return nil, err
+	}
+	for _, root := range roots {
+		if err := c.exportDAG(ctx, root, car); err != nil {
+			return nil, err
+		}
+	}
+	return car.Close()
+}
+
+// exportDAG copies the blocks of the DAG export of root into car. The export
+// of a large file outlasts ipfs.external.timeout, so it runs on a shell without
+// one and only ctx bounds it. A daemon that fails mid-export may end the stream
+// between two blocks, so callers check with HasLocal that the DAG is complete.
+func (c *ExternalClient) exportDAG(ctx context.Context, root string, car *carWriter) error {
+	resp, err := c.untimedShell().Request("dag/export", root).Option("progress", false).Option("offline", true).Send(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to export %s: %w", root, err)
+	}
+	defer resp.Close()
+	if resp.Error != nil {
+		return fmt.Errorf("failed to export %s: %w", root, resp.Error)
+	}
+
+	err = readCAR(resp.Output, func(block gocid.Cid, data []byte) error {
+		return car.writeBlock(block, data)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export %s: %w", root, err)
+	}
+	return nil
+}
+
+// mfsNotExist reports whether a files API error is about a missing path
+func mfsNotExist(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "does not exist")
+}
+
+// TreeRoot returns the CID of the MFS directory dir, or "" if it does not exist
+func (c *ExternalClient) TreeRoot(ctx context.Context, dir string) (string, error) {
+	stat, err := c.shell.FilesStat(ctx, dir)
+	if mfsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to stat MFS directory %s: %w", dir, err)
+	}
+	return stat.Hash, nil
+}
+
+// UpdateTree applies changes with one files API call per path. Nothing is
+// flushed until the end, so the daemon rewrites each changed directory once.
+func (c *ExternalClient) UpdateTree(ctx context.Context, dir string, changes TreeChanges) (string, error) {
+	if err := c.shell.FilesMkdir(ctx, dir, shell.FilesMkdir.Parents(true)); err != nil {
+		return "", fmt.Errorf("failed to create MFS directory: %w", translateNoSpace("mkdir", err))
+	}
+
+	for _, p := range changes.Unlink {
+		if err := c.filesRemove(ctx, dir+"/"+p); err != nil && !mfsNotExist(err) {
+			return "", fmt.Errorf("failed to unlink %s: %w", p, err)
+		}
+	}
+	for _, parent := range changes.Parents() {
+		entries, err := c.shell.FilesLs(ctx, dir+"/"+parent)
+		if mfsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to list directory %s: %w", parent, err)
+		}
+		if len(entries) == 0 {
+			if err := c.filesRemove(ctx, dir+"/"+parent); err != nil {
+				return "", fmt.Errorf("failed to remove empty directory %s: %w", parent, err)
+			}
+		}
+	}
+
+	for _, p := range changes.LinkPaths() {
+		cid := changes.Link[p]
+		err := c.filesLink(ctx, cid, dir+"/"+p)
+		if err != nil && strings.Contains(err.Error(), "already has entry") {
+			// A changed path: replace what it linked before
+			if err = c.filesRemove(ctx, dir+"/"+p); err == nil {
+				err = c.filesLink(ctx, cid, dir+"/"+p)
+			}
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to link %s at %s: %w", cid, p, translateNoSpace("cp", err))
+		}
+	}
+
+	if _, err := c.shell.FilesFlush(ctx, dir); err != nil {
+		return "", fmt.Errorf("failed to flush MFS directory %s: %w", dir, translateNoSpace("flush", err))
+	}
+	return c.TreeRoot(ctx, dir)
+}
+
+// filesLink copies cid to the MFS path target, creating its parent directories
+func (c *ExternalClient) filesLink(ctx context.Context, cid, target string) error {
+	return c.shell.Request("files/cp", "/ipfs/"+cid, target).
+		Option("parents", true).
+		Option("flush", false).
+		Exec(ctx, nil)
+}
+
+// filesRemove removes the MFS path target without flushing
+func (c *ExternalClient) filesRemove(ctx context.Context, target string) error {
+	return c.shell.Request("files/rm", target).
+		Option("recursive", true).
+		Option("force", true).
+		Option("flush", false).
+		Exec(ctx, nil)
+}
+
+// RemoveTree removes the MFS directory dir if it exists
+func (c *ExternalClient) RemoveTree(ctx context.Context, dir string) error {
+	if err := c.shell.FilesRm(ctx, dir, true); err != nil && !mfsNotExist(err) {
+		return fmt.Errorf("failed to remove MFS directory %s: %w", dir, err)
+	}
+	return nil
+}
+
 // Unpin unpins content from IPFS
 func (c *ExternalClient) Unpin(ctx context.Context, cid string) error {
 	if err := c.shell.Unpin(cid); err != nil {
@@ -84,8 +551,8 @@ func (c *ExternalClient) Unpin(ctx context.Context, cid string) error {
 // PublishIPNS publishes a CID to IPNS
 func (c *ExternalClient) PublishIPNS(ctx context.Context, cid string, opts IPNSPublishOptions) (*IPNSPublishResult, error) {
 	// Use PublishWithDetails for more control
-	// Default lifetime: 24h, TTL: 0 (use default), resolve: true
-	lifetime := 24 * time.Hour
+	// Default lifetime: config.DefaultIPNSLifetime, TTL: 0 (use default), resolve: true
+	lifetime := config.DefaultIPNSLifetime
 	if opts.Lifetime != "" {
 		if d, err := time.ParseDuration(opts.Lifetime); err == nil {
 			lifetime = d
@@ -110,6 +577,28 @@ func (c *ExternalClient) PublishIPNS(ctx context.Context, cid string, opts IPNSP
 	}, nil
 }
 
+// EnsureKey returns the IPNS name of the named key, generating an Ed25519 key if needed
+func (c *ExternalClient) EnsureKey(ctx context.Context, name string) (string, error) {
+	keys, err := c.shell.KeyList(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list keys: %w", err)
+	}
+
+	for _, k := range keys {
+		if k.Name == name {
+			return k.Id, nil
+		}
+	}
+
+	k, err := c.shell.KeyGen(ctx, name, shell.KeyGen.Type("ed25519"))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate key %s: %w", name, err)
+	}
+
+	logger.Get().Infof("Generated IPNS key %s", name)
+	return k.Id, nil
+}
+
 // ResolveIPNS resolves an IPNS name to a CID
 func (c *ExternalClient) ResolveIPNS(ctx context.Context, name string) (string, error) {
 	path, err := c.shell.Resolve(name)
@@ -119,6 +608,133 @@ func (c *ExternalClient) ResolveIPNS(ctx context.Context, name string) (string,
 	return path, nil
 }
 
+// RepoStat returns repository statistics via /api/v0/repo/stat
+func (c *ExternalClient) RepoStat(ctx context.Context) (*RepoStats, error) {
+	var out struct {
+		RepoSize   uint64
+		StorageMax uint64
+		NumObjects uint64
+		RepoPath   string
+		Version    string
+	}
+
+	if err := c.shell.Request("repo/stat").Exec(ctx, &out); err != nil {
+		return nil, fmt.Errorf("failed to get repo stats: %w", err)
+	}
+
+	return &RepoStats{
+		RepoSize:   out.RepoSize,
+		StorageMax: out.StorageMax,
+		NumObjects: out.NumObjects,
+		RepoPath:   out.RepoPath,
+		Version:    out.Version,
+	}, nil
+}
+
+// SupportsPubSub probes the daemon's PubSub by listing its subscribed topics via
+// /api/v0/pubsub/ls. Daemons started without PubSub enabled reject the command,
+// so any error is treated as unsupported.
+func (c *ExternalClient) SupportsPubSub(ctx context.Context) bool {
+	var out struct {
+		Strings []string
+	}
+	if err := c.shell.Request("pubsub/ls").Exec(ctx, &out); err != nil {
+		logger.Get().Debugf("Daemon PubSub probe failed: %v", err)
+		return false
+	}
+	return true
+}
+
+// PublishToPubSub publishes a message to a PubSub topic via /api/v0/pubsub/pub.
+// The topic is sent multibase-encoded and the message as a file, as kubo expects.
+func (c *ExternalClient) PublishToPubSub(ctx context.Context, topic string, data []byte) error {
+	encodedTopic, err := multibase.Encode(multibase.Base64url, []byte(topic))
+	if err != nil {
+		return fmt.Errorf("failed to encode topic %s: %w", topic, err)
+	}
+
+	body := files.NewMultiFileReader(files.NewSliceDirectory([]files.DirEntry{
+		files.FileEntry("", files.NewBytesFile(data)),
+	}), true, false)
+
+	if err := c.shell.Request("pubsub/pub", encodedTopic).Body(body).Exec(ctx, nil); err != nil {
+		return fmt.Errorf("failed to publish to topic %s: %w", topic, err)
+	}
+	return nil
+}
+
+// routingProviderEvent is the type of /api/v0/routing/findprovs events that carry providers
+const routingProviderEvent = 4
+
+// FindProviders streams provider records via /api/v0/routing/findprovs until max
+// providers are found or ctx ends
+func (c *ExternalClient) FindProviders(ctx context.Context, cid string, max int) ([]string, error) {
+	resp, err := c.shell.Request("routing/findprovs", cid).Option("num-providers", max).Send(ctx)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to find providers of %s: %w", cid, err)
+	}
+	defer resp.Close()
+	if resp.Error != nil {
+		return nil, fmt.Errorf("failed to find providers of %s: %w", cid, resp.Error)
+	}
+
+	var providers []string
+	decoder := json.NewDecoder(resp.Output)
+	for {
+		var event struct {
+			Type      int
+			Responses []struct{ ID string }
+		}
+		if err := decoder.Decode(&event); err != nil {
+			if err == io.EOF || ctx.Err() != nil {
+				return providers, nil
+			}
+			return providers, fmt.Errorf("failed to read providers of %s: %w", cid, err)
+		}
+		if event.Type != routingProviderEvent {
+			continue
+		}
+		for _, r := range event.Responses {
+			providers = append(providers, r.ID)
+		}
+	}
+}
+
+// Provide announces cid to the routing system via /api/v0/routing/provide
+func (c *ExternalClient) Provide(ctx context.Context, cid string) error {
+	if err := c.shell.Request("routing/provide", cid).Exec(ctx, nil); err != nil {
+		return fmt.Errorf("failed to provide %s: %w", cid, err)
+	}
+	return nil
+}
+
+// SwarmConnect connects the daemon to the peer at peerAddr
+func (c *ExternalClient) SwarmConnect(ctx context.Context, peerAddr string) error {
+	if _, err := parsePeerAddr(peerAddr); err != nil {
+		return err
+	}
+	if err := c.shell.SwarmConnect(ctx, peerAddr); err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", peerAddr, err)
+	}
+	return nil
+}
+
+// SwarmDisconnect closes the daemon's connections to the peer peerID.
+// go-ipfs-api has no wrapper for swarm/disconnect.
+func (c *ExternalClient) SwarmDisconnect(ctx context.Context, peerID string) error {
+	addr, err := peerOnlyAddr(peerID)
+	if err != nil {
+		return err
+	}
+	if err := c.shell.Request("swarm/disconnect", addr.String()).Exec(ctx, nil); err != nil {
+		return fmt.Errorf("failed to disconnect from %s: %w", peerID, err)
+	}
+	return nil
+}
+
 // IsAvailable checks if the IPFS node is reachable
 func (c *ExternalClient) IsAvailable(ctx context.Context) error {
 	// Try to get node ID as a health check
@@ -0,0 +1,439 @@
+package ipfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addRequest is what the fake node saw of an /api/v0/add request
+type addRequest struct {
+	query   url.Values
+	abspath string
+	data    string
+}
+
+// newAddServer returns a client of a fake node that records add requests and
+// answers them with message as error if it is set
+func newAddServer(t *testing.T, message string) (*ExternalClient, *[]addRequest) {
+	t.Helper()
+
+	var requests []addRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v0/add" {
+			http.NotFound(w, r)
+			return
+		}
+		mr, err := r.MultipartReader()
+		if err != nil {
+			kuboError(w, err.Error())
+			return
+		}
+		part, err := mr.NextPart()
+		if err != nil {
+			kuboError(w, err.Error())
+			return
+		}
+		abspath, _ := url.QueryUnescape(part.Header.Get("abspath-encoded"))
+		data, err := io.ReadAll(part)
+		if err != nil {
+			kuboError(w, err.Error())
+			return
+		}
+		requests = append(requests, addRequest{query: r.URL.Query(), abspath: abspath, data: string(data)})
+
+		if message != "" {
+			kuboError(w, message)
+			return
+		}
+		writeJSON(w, map[string]any{"Name": "", "Hash": "QmAdded", "Size": "1234"})
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := NewExternalClient(server.URL, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client, &requests
+}
+
+func TestExternalAddSendsAllOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.mp3")
+	if err := os.WriteFile(path, []byte("audio"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	client, requests := newAddServer(t, "")
+	opts := AddOptions{Pin: true, NoCopy: true, Chunker: "size-1048576", RawLeaves: true}
+	result, err := client.Add(context.Background(), file, path, opts)
+	if err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if result.CID != "QmAdded" || result.Size != 1234 {
+		t.Errorf("result = %s with size %d, want QmAdded with the reported DAG size 1234", result.CID, result.Size)
+	}
+
+	if len(*requests) != 1 {
+		t.Fatalf("%d add requests, want 1", len(*requests))
+	}
+	req := (*requests)[0]
+	want := map[string]string{"pin": "true", "nocopy": "true", "chunker": "size-1048576", "raw-leaves": "true"}
+	for key, value := range want {
+		if got := req.query.Get(key); got != value {
+			t.Errorf("option %s = %q, want %q", key, got, value)
+		}
+	}
+	if req.abspath != path {
+		t.Errorf("abspath = %q, want %q for the filestore", req.abspath, path)
+	}
+	if req.data != "audio" {
+		t.Errorf("sent %q, want the file content", req.data)
+	}
+}
+
+func TestExternalAddWithoutNoCopySendsNoPath(t *testing.T) {
+	client, requests := newAddServer(t, "")
+	if _, err := client.Add(context.Background(), strings.NewReader("audio"), "song.mp3", AddOptions{Pin: true}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	req := (*requests)[0]
+	for _, key := range []string{"nocopy", "chunker", "raw-leaves"} {
+		if req.query.Has(key) {
+			t.Errorf("option %s = %q sent, want it unset", key, req.query.Get(key))
+		}
+	}
+	if req.abspath != "" {
+		t.Errorf("abspath = %q sent without nocopy", req.abspath)
+	}
+}
+
+func TestExternalAddFilestoreDisabled(t *testing.T) {
+	client, _ := newAddServer(t, "filestore is not enabled, see https://git.io/vNItf")
+	_, err := client.Add(context.Background(), strings.NewReader("audio"), "/media/song.mp3", AddOptions{NoCopy: true})
+	if !errors.Is(err, ErrFilestoreDisabled) {
+		t.Fatalf("Add error = %v, want ErrFilestoreDisabled", err)
+	}
+	if !IsFatal(err) {
+		t.Errorf("Add error is not fatal: every further add would fail the same way")
+	}
+}
+
+func TestExternalCheckFilestore(t *testing.T) {
+	tests := []struct {
+		value any
+		want  error
+	}{
+		{true, nil},
+		{false, ErrFilestoreDisabled},
+		{nil, ErrFilestoreDisabled},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/api/v0/config" || r.URL.Query().Get("arg") != "Experimental.FilestoreEnabled" {
+				http.NotFound(w, r)
+				return
+			}
+			writeJSON(w, map[string]any{"Key": "Experimental.FilestoreEnabled", "Value": tt.value})
+		}))
+		client, err := NewExternalClient(server.URL, 10*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := client.CheckFilestore(context.Background()); !errors.Is(err, tt.want) {
+			t.Errorf("CheckFilestore with %v = %v, want %v", tt.value, err, tt.want)
+		}
+		server.Close()
+	}
+}
+
+func TestExternalStat(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v0/files/stat" {
+			http.NotFound(w, r)
+			return
+		}
+		switch r.URL.Query().Get("arg") {
+		case "/ipfs/QmFile":
+			writeJSON(w, map[string]any{"Hash": "QmFile", "Size": 5000, "CumulativeSize": 5120, "Blocks": 2, "Type": "file"})
+		case "/ipfs/QmDir":
+			writeJSON(w, map[string]any{"Hash": "QmDir", "Size": 0, "CumulativeSize": 9000, "Blocks": 3, "Type": "directory"})
+		default:
+			kuboError(w, "block was not found locally (offline): ipld: could not find QmMissing")
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewExternalClient(server.URL, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	file, err := client.Stat(ctx, "QmFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (StatResult{CID: "QmFile", Size: 5000, NumLinks: 2, Type: StatTypeFile}); *file != want {
+		t.Errorf("file stat = %+v, want %+v", *file, want)
+	}
+
+	// A directory's size is that of everything below it
+	dir, err := client.Stat(ctx, "QmDir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (StatResult{CID: "QmDir", Size: 9000, NumLinks: 3, Type: StatTypeDir}); *dir != want {
+		t.Errorf("directory stat = %+v, want %+v", *dir, want)
+	}
+
+	if _, err := client.Stat(ctx, "QmMissing"); err == nil {
+		t.Error("Stat of missing content succeeded")
+	}
+}
+
+func TestExternalListPins(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v0/pin/ls" || r.URL.Query().Get("type") != "all" {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, map[string]any{"Keys": map[string]any{
+			"QmRoot":  map[string]any{"Type": "recursive"},
+			"QmChild": map[string]any{"Type": "indirect"},
+			"QmBlock": map[string]any{"Type": "direct"},
+		}})
+	}))
+	defer server.Close()
+
+	client, err := NewExternalClient(server.URL, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pins, err := client.ListPins(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{"QmRoot": PinTypeRecursive, "QmChild": PinTypeIndirect, "QmBlock": PinTypeDirect}
+	if !maps.Equal(pins, want) {
+		t.Errorf("pins = %v, want %v", pins, want)
+	}
+}
+
+func TestExternalSwarm(t *testing.T) {
+	const peerID = "12D3KooWNZ9Ma5sMmcr3brheC685dgrKJaM9SdhZrHojpKfywjg4"
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path+" "+r.URL.Query().Get("arg"))
+		switch r.URL.Path {
+		case "/api/v0/swarm/connect", "/api/v0/swarm/disconnect":
+			writeJSON(w, map[string]any{"Strings": []string{"success"}})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client, err := NewExternalClient(server.URL, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := client.SwarmConnect(ctx, "/ip4/10.0.0.2/tcp/4001/p2p/"+peerID); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SwarmDisconnect(ctx, peerID); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"/api/v0/swarm/connect /ip4/10.0.0.2/tcp/4001/p2p/" + peerID,
+		"/api/v0/swarm/disconnect /p2p/" + peerID,
+	}
+	if !slices.Equal(requests, want) {
+		t.Errorf("requests = %q, want %q", requests, want)
+	}
+
+	// Invalid addresses and IDs never reach the daemon
+	if err := client.SwarmConnect(ctx, "/ip4/10.0.0.2/tcp/4001"); err == nil {
+		t.Error("SwarmConnect accepted an address without a peer ID")
+	}
+	if err := client.SwarmDisconnect(ctx, "not-a-peer"); err == nil {
+		t.Error("SwarmDisconnect accepted an invalid peer ID")
+	}
+	if len(requests) != len(want) {
+		t.Errorf("%d requests sent, want %d", len(requests), len(want))
+	}
+}
+
+// fakeMFS is a node answering the files API from paths to CIDs, with "dir" for
+// directories. The CID of a directory lists the entries below it.
+type fakeMFS struct {
+	entries   map[string]string
+	unflushed int // Changes requested with flush=false
+}
+
+func (m *fakeMFS) exists(p string) bool {
+	_, ok := m.entries[p]
+	return ok
+}
+
+func (m *fakeMFS) mkdirs(p string) {
+	for ; p != "/" && p != "."; p = filepath.Dir(p) {
+		m.entries[p] = "dir"
+	}
+}
+
+func (m *fakeMFS) children(dir string) []string {
+	var names []string
+	for p := range m.entries {
+		if filepath.Dir(p) == dir {
+			names = append(names, filepath.Base(p))
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (m *fakeMFS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	args := r.URL.Query()["arg"]
+	if r.URL.Query().Get("flush") == "false" {
+		m.unflushed++
+	}
+	switch strings.TrimPrefix(r.URL.Path, "/api/v0/") {
+	case "files/mkdir":
+		m.mkdirs(args[0])
+		writeJSON(w, map[string]any{})
+
+	case "files/cp":
+		switch {
+		case m.exists(args[1]):
+			kuboError(w, "cp: cannot put node in path "+args[1]+": directory already has entry by that name")
+		case !m.exists(filepath.Dir(args[1])) && r.URL.Query().Get("parents") != "true":
+			kuboError(w, "cp: file does not exist")
+		default:
+			m.mkdirs(filepath.Dir(args[1]))
+			m.entries[args[1]] = strings.TrimPrefix(args[0], "/ipfs/")
+			writeJSON(w, map[string]any{})
+		}
+
+	case "files/rm":
+		if !m.exists(args[0]) {
+			kuboError(w, args[0]+": file does not exist")
+			return
+		}
+		for p := range m.entries {
+			if p == args[0] || strings.HasPrefix(p, args[0]+"/") {
+				delete(m.entries, p)
+			}
+		}
+		writeJSON(w, map[string]any{})
+
+	case "files/ls":
+		if !m.exists(args[0]) {
+			kuboError(w, "file does not exist")
+			return
+		}
+		var entries []map[string]any
+		for _, name := range m.children(args[0]) {
+			entries = append(entries, map[string]any{"Name": name})
+		}
+		writeJSON(w, map[string]any{"Entries": entries})
+
+	case "files/flush":
+		m.unflushed = 0
+		writeJSON(w, map[string]any{"Cid": ""})
+
+	case "files/stat":
+		if !m.exists(args[0]) {
+			kuboError(w, "file does not exist")
+			return
+		}
+		var listing []string
+		for p, c := range m.entries {
+			if strings.HasPrefix(p, args[0]+"/") {
+				listing = append(listing, strings.TrimPrefix(p, args[0]+"/")+"="+c)
+			}
+		}
+		slices.Sort(listing)
+		writeJSON(w, map[string]any{"Hash": strings.Join(listing, ","), "Type": "directory"})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestExternalUpdateTree(t *testing.T) {
+	node := &fakeMFS{entries: make(map[string]string)}
+	server := httptest.NewServer(node)
+	defer server.Close()
+
+	client, err := NewExternalClient(server.URL, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	const dir = "/.ipfs-publisher/tree/self"
+
+	if root, err := client.TreeRoot(ctx, dir); err != nil || root != "" {
+		t.Fatalf("TreeRoot of a missing tree = %q, %v, want nothing", root, err)
+	}
+
+	first := map[string]string{
+		"collection.ndjson":        "QmIndex1",
+		"Artist/Album/01.flac":     "QmA",
+		"Artist/Live/Disc 1/1.mp3": "QmB",
+	}
+	root, err := client.UpdateTree(ctx, dir, DiffTree(nil, first))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Artist=dir,Artist/Album=dir,Artist/Album/01.flac=QmA,Artist/Live=dir,Artist/Live/Disc 1=dir,Artist/Live/Disc 1/1.mp3=QmB,collection.ndjson=QmIndex1"; root != want {
+		t.Fatalf("first tree = %s, want %s", root, want)
+	}
+	if node.unflushed != 0 {
+		t.Errorf("%d changes left unflushed", node.unflushed)
+	}
+
+	// A changed path is replaced and directories left empty are removed
+	second := map[string]string{
+		"collection.ndjson":    "QmIndex2",
+		"Artist/Album/01.flac": "QmA",
+	}
+	root, err = client.UpdateTree(ctx, dir, DiffTree(first, second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Artist=dir,Artist/Album=dir,Artist/Album/01.flac=QmA,collection.ndjson=QmIndex2"; root != want {
+		t.Errorf("second tree = %s, want %s", root, want)
+	}
+	if current, err := client.TreeRoot(ctx, dir); err != nil || current != root {
+		t.Errorf("TreeRoot = %q, %v, want %s", current, err, root)
+	}
+
+	if err := client.RemoveTree(ctx, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.RemoveTree(ctx, dir); err != nil {
+		t.Errorf("removing a missing tree: %v", err)
+	}
+	if root, err := client.TreeRoot(ctx, dir); err != nil || root != "" {
+		t.Errorf("TreeRoot after RemoveTree = %q, %v, want nothing", root, err)
+	}
+}
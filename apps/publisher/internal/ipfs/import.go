@@ -0,0 +1,195 @@
+package ipfs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// Placeholder path prefixes of imported files, which have no local file.
+// They never collide with scanned paths, which are absolute.
+const (
+	ImportPinPrefix = "pin:" // pin:<pinned CID>/<path inside the pin>
+	ImportMFSPrefix = "mfs:" // mfs:<MFS path>
+	ImportDirPrefix = "dir:" // dir:<absolute local path> of a directory uploaded as a whole
+)
+
+// ImportEntry is a file already held by the node that can be adopted into the collection
+type ImportEntry struct {
+	Path  string // Placeholder path recorded in state
+	Name  string // Filename
+	Group string // Parent directory inside the pin or below the MFS root; "" at the top
+	CID   string
+	Size  int64
+}
+
+// Importer lists content that already exists on the node (implemented by ExternalClient)
+type Importer interface {
+	// ListPinnedFiles lists the files of all recursive pins. Directory pins are
+	// walked; a file pin is listed only if it was pinned with a name.
+	ListPinnedFiles(ctx context.Context) ([]ImportEntry, error)
+
+	// ListMFSFiles lists the files below an MFS directory
+	ListMFSFiles(ctx context.Context, root string) ([]ImportEntry, error)
+}
+
+// UnixFS types of links in /api/v0/ls output; files/ls uses unixfsDirectory too
+const (
+	unixfsDirectory = 1
+	unixfsSymlink   = 4
+	unixfsHAMTShard = 5 // Sharded large directory
+)
+
+// ListPinnedFiles lists the files of all recursive pins via /api/v0/pin/ls
+func (c *ExternalClient) ListPinnedFiles(ctx context.Context) ([]ImportEntry, error) {
+	var pins struct {
+		Keys map[string]struct {
+			Type string
+			Name string
+		}
+	}
+	if err := c.shell.Request("pin/ls").Option("type", "recursive").Option("names", true).Exec(ctx, &pins); err != nil {
+		return nil, fmt.Errorf("failed to list pins: %w", err)
+	}
+
+	roots := make([]string, 0, len(pins.Keys))
+	for cid := range pins.Keys {
+		roots = append(roots, cid)
+	}
+	sort.Strings(roots)
+
+	var entries []ImportEntry
+	unnamed := 0
+	for _, cid := range roots {
+		var stat struct {
+			Type string
+			Size int64
+		}
+		if err := c.shell.Request("files/stat", "/ipfs/"+cid).Exec(ctx, &stat); err != nil {
+			return nil, fmt.Errorf("failed to stat pin %s: %w", cid, err)
+		}
+
+		if stat.Type != "directory" {
+			name := pins.Keys[cid].Name
+			if name == "" {
+				unnamed++
+				continue
+			}
+			entries = append(entries, ImportEntry{
+				Path: ImportPinPrefix + cid + "/" + name,
+				Name: name,
+				CID:  cid,
+				Size: stat.Size,
+			})
+			continue
+		}
+
+		found, err := c.walkDirectory(ctx, cid, "", ImportPinPrefix+cid)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, found...)
+	}
+
+	if unnamed > 0 {
+		logger.Get().Warnf("Skipped %d file pins without a name; pin them with a name (ipfs pin add --name) or wrap them in a directory to import them", unnamed)
+	}
+	return entries, nil
+}
+
+// walkDirectory lists the files below the UnixFS directory cid via /api/v0/ls.
+// group is the directory's path inside the pin and prefix the placeholder path of the pin.
+func (c *ExternalClient) walkDirectory(ctx context.Context, cid, group, prefix string) ([]ImportEntry, error) {
+	var out struct {
+		Objects []struct {
+			Links []struct {
+				Name string
+				Hash string
+				Size int64
+				Type int
+			}
+		}
+	}
+	if err := c.shell.Request("ls", "/ipfs/"+cid).Option("resolve-type", true).Option("size", true).Exec(ctx, &out); err != nil {
+		return nil, fmt.Errorf("failed to list directory %s: %w", cid, err)
+	}
+
+	var entries []ImportEntry
+	for _, object := range out.Objects {
+		for _, link := range object.Links {
+			linkPath := path.Join(group, link.Name)
+			if link.Type == unixfsSymlink {
+				continue
+			}
+			if link.Type == unixfsDirectory || link.Type == unixfsHAMTShard {
+				found, err := c.walkDirectory(ctx, link.Hash, linkPath, prefix)
+				if err != nil {
+					return nil, err
+				}
+				entries = append(entries, found...)
+				continue
+			}
+			entries = append(entries, ImportEntry{
+				Path:  prefix + "/" + linkPath,
+				Name:  link.Name,
+				Group: group,
+				CID:   link.Hash,
+				Size:  link.Size,
+			})
+		}
+	}
+	return entries, nil
+}
+
+// ListMFSFiles lists the files below an MFS directory via /api/v0/files/ls
+func (c *ExternalClient) ListMFSFiles(ctx context.Context, root string) ([]ImportEntry, error) {
+	root = path.Clean("/" + root)
+	return c.walkMFS(ctx, root, "")
+}
+
+// walkMFS lists the files of the MFS directory root/group and its subdirectories
+func (c *ExternalClient) walkMFS(ctx context.Context, root, group string) ([]ImportEntry, error) {
+	dir := path.Join(root, group)
+
+	var out struct {
+		Entries []struct {
+			Name string
+			Type int
+			Size int64
+			Hash string
+		}
+	}
+	if err := c.shell.Request("files/ls", dir).Option("long", true).Exec(ctx, &out); err != nil {
+		return nil, fmt.Errorf("failed to list MFS directory %s: %w", dir, err)
+	}
+
+	var entries []ImportEntry
+	for _, entry := range out.Entries {
+		entryGroup := path.Join(group, entry.Name)
+		if entry.Type == unixfsDirectory {
+			found, err := c.walkMFS(ctx, root, entryGroup)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, found...)
+			continue
+		}
+		entries = append(entries, ImportEntry{
+			Path:  ImportMFSPrefix + path.Join(dir, entry.Name),
+			Name:  entry.Name,
+			Group: group,
+			CID:   entry.Hash,
+			Size:  entry.Size,
+		})
+	}
+	return entries, nil
+}
+
+// IsImportPath reports whether path is the placeholder path of an imported file
+func IsImportPath(p string) bool {
+	return strings.HasPrefix(p, ImportPinPrefix) || strings.HasPrefix(p, ImportMFSPrefix)
+}
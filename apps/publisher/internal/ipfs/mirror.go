@@ -0,0 +1,74 @@
+package ipfs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// MirrorPublishResult contains the IPNS names updated by PublishWithMirrors
+type MirrorPublishResult struct {
+	Primary *IPNSPublishResult
+	Mirrors []string         // IPNS names of mirror keys that were updated
+	Failed  map[string]error // Mirror key name -> publish error
+}
+
+// Names returns the primary IPNS name followed by the updated mirror names
+func (r *MirrorPublishResult) Names() []string {
+	names := []string{r.Primary.Name}
+	return append(names, r.Mirrors...)
+}
+
+// PublishWithMirrors publishes cid under the primary key and, in parallel, under every
+// publish.mirror_keys key. The primary publish must succeed; mirror failures are
+// logged and reported in Failed without failing the whole publish.
+func PublishWithMirrors(ctx context.Context, client Client, cid string, cfg *config.PublishConfig, primaryKey string) (*MirrorPublishResult, error) {
+	log := logger.Get()
+
+	result := &MirrorPublishResult{Failed: make(map[string]error)}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	mirrorNames := make(map[string]string, len(cfg.MirrorKeys))
+
+	for _, key := range cfg.MirrorKeys {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+
+			name, err := client.EnsureKey(ctx, key)
+			if err == nil {
+				_, err = client.PublishIPNS(ctx, cid, NewIPNSPublishOptions(cfg, key))
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				log.Warnf("Failed to publish mirror key %s: %v", key, err)
+				result.Failed[key] = err
+				return
+			}
+			log.Infof("✓ Published mirror key %s (%s)", key, name)
+			mirrorNames[key] = name
+		}(key)
+	}
+
+	primary, err := client.PublishIPNS(ctx, cid, NewIPNSPublishOptions(cfg, primaryKey))
+	wg.Wait()
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish primary IPNS: %w", err)
+	}
+	result.Primary = primary
+
+	// Keep mirror names in configured order
+	for _, key := range cfg.MirrorKeys {
+		if name, ok := mirrorNames[key]; ok {
+			result.Mirrors = append(result.Mirrors, name)
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,203 @@
+package ipfs
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/state"
+)
+
+// PinLossWarnRatio is the fraction of sampled CIDs that must be missing from the
+// node before the pin check warns that the node's content was likely wiped
+const PinLossWarnRatio = 0.2
+
+// PinCheckReport is the result of checking recorded CIDs against the node
+type PinCheckReport struct {
+	Checked int
+	Missing map[string]string // File path -> recorded CID absent from the node
+}
+
+// MissingRatio returns the fraction of checked CIDs that were missing
+func (r *PinCheckReport) MissingRatio() float64 {
+	if r.Checked == 0 {
+		return 0
+	}
+	return float64(len(r.Missing)) / float64(r.Checked)
+}
+
+// Severe reports whether enough CIDs are missing to suspect a wiped node
+func (r *PinCheckReport) Severe() bool {
+	return len(r.Missing) > 0 && r.MissingRatio() >= PinLossWarnRatio
+}
+
+// SampleCIDs returns the recorded CIDs of files by path. If sample is positive,
+// only that many randomly chosen files are returned.
+func SampleCIDs(files map[string]*state.FileState, sample int) map[string]string {
+	paths := make([]string, 0, len(files))
+	for path, fs := range files {
+		if fs.CID != "" {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	if sample > 0 && sample < len(paths) {
+		rand.Shuffle(len(paths), func(i, j int) { paths[i], paths[j] = paths[j], paths[i] })
+		paths = paths[:sample]
+	}
+
+	cids := make(map[string]string, len(paths))
+	for _, path := range paths {
+		cids[path] = files[path].CID
+	}
+	return cids
+}
+
+// CheckPins checks that the node still holds the CIDs recorded in state.
+// If sample is positive, only that many randomly chosen files are checked.
+func CheckPins(ctx context.Context, client Client, files map[string]*state.FileState, sample int) (*PinCheckReport, error) {
+	cids := SampleCIDs(files, sample)
+	paths := make([]string, 0, len(cids))
+	for path := range cids {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	report := &PinCheckReport{Missing: make(map[string]string)}
+	for _, path := range paths {
+		cid := cids[path]
+		ok, err := client.HasLocal(ctx, cid)
+		if err != nil {
+			return nil, fmt.Errorf("failed to check CID %s: %w", cid, err)
+		}
+		report.Checked++
+		if !ok {
+			report.Missing[path] = cid
+		}
+	}
+
+	return report, nil
+}
+
+// VerifyPins returns the CIDs recorded in stateManager that are not pinned on the
+// node in any way. Unlike CheckPins it asks the node for its pins once instead of
+// once per file, and content that is held but unpinned counts as missing, since
+// garbage collection may remove it.
+func VerifyPins(ctx context.Context, client Client, stateManager *state.Manager) ([]string, error) {
+	pins, err := client.ListPins(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return UnpinnedCIDs(pins, stateManager.GetAllFiles()), nil
+}
+
+// UnpinnedCIDs returns the recorded CIDs of files that are missing from pins,
+// sorted. Files imported from MFS are left out: MFS keeps them without a pin.
+func UnpinnedCIDs(pins map[string]string, files map[string]*state.FileState) []string {
+	seen := make(map[string]bool)
+	var missing []string
+	for path, fs := range files {
+		if fs.CID == "" || seen[fs.CID] || strings.HasPrefix(path, ImportMFSPrefix) {
+			continue
+		}
+		seen[fs.CID] = true
+		if _, ok := pins[fs.CID]; !ok {
+			missing = append(missing, fs.CID)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// fileChanged reports whether a file differs from its recorded state. The mtime is
+// compared with full precision when it was recorded, so a same-size rewrite within
+// the second of the upload is not mistaken for a changed add option.
+func fileChanged(fs *state.FileState, info os.FileInfo) bool {
+	if info.Size() != fs.Size {
+		return true
+	}
+	if fs.ModTimeNs != 0 {
+		return info.ModTime().UnixNano() != fs.ModTimeNs
+	}
+	return info.ModTime().Unix() != fs.ModTime
+}
+
+// RepairResult summarizes a RepairMissing run
+type RepairResult struct {
+	Readded  int      // Files re-added with the recorded CID
+	Skipped  int      // Files changed or deleted since they were recorded; left to the next scan
+	Imported int      // Imported files, which have no local file to re-add
+	Mismatch []string // Files whose re-add produced a different CID than recorded
+	Failed   int      // Files that could not be re-added
+}
+
+// RepairMissing re-adds every file whose recorded CID is absent from the node.
+// A re-add must reproduce the recorded CID; a different CID means the add options
+// changed since the file was published and is reported in Mismatch.
+func RepairMissing(ctx context.Context, client Client, files map[string]*state.FileState, opts AddOptions) (*RepairResult, error) {
+	log := logger.Get()
+
+	report, err := CheckPins(ctx, client, files, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Infof("Repair: %d of %d recorded CIDs missing from the node", len(report.Missing), report.Checked)
+
+	result := &RepairResult{}
+	for path, cid := range report.Missing {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+
+		fs := files[path]
+		if fs.Imported {
+			log.Warnf("Repair: %s was imported from the node and has no local file to re-add", path)
+			result.Imported++
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil || fileChanged(fs, info) {
+			log.Warnf("Repair: %s changed since it was published, leaving it to the next scan", path)
+			result.Skipped++
+			continue
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			log.Errorf("Repair: failed to open %s: %v", path, err)
+			result.Failed++
+			continue
+		}
+
+		addOpts := opts
+		addOpts.FileInfo = info
+		res, err := client.Add(ctx, f, path, addOpts)
+		f.Close()
+		if err != nil {
+			if IsFatal(err) {
+				return result, err
+			}
+			log.Errorf("Repair: failed to re-add %s: %v", path, err)
+			result.Failed++
+			continue
+		}
+
+		if res.CID != cid {
+			log.Errorf("Repair: re-adding %s produced %s instead of recorded %s (add options changed?)", path, res.CID, cid)
+			result.Mismatch = append(result.Mismatch, path)
+			continue
+		}
+
+		log.Infof("Repair: re-added %s (%s)", path, cid)
+		result.Readded++
+	}
+
+	return result, nil
+}
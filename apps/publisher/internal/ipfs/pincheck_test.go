@@ -0,0 +1,121 @@
+package ipfs
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/state"
+)
+
+// wipedClient is a Client whose node holds nothing and re-adds every file as readdCID.
+// Methods the tests do not use panic through the nil embedded interface.
+type wipedClient struct {
+	Client
+	readdCID string
+}
+
+func (c *wipedClient) HasLocal(ctx context.Context, cid string) (bool, error) {
+	return false, nil
+}
+
+func (c *wipedClient) Add(ctx context.Context, reader io.Reader, filename string, opts AddOptions) (*AddResult, error) {
+	return &AddResult{CID: c.readdCID, Name: filename}, nil
+}
+
+// pinnedClient is a Client whose node has the given pins
+type pinnedClient struct {
+	Client
+	pins map[string]string
+}
+
+func (c *pinnedClient) ListPins(ctx context.Context) (map[string]string, error) {
+	return c.pins, nil
+}
+
+func TestVerifyPins(t *testing.T) {
+	m := state.New(filepath.Join(t.TempDir(), "state.json"))
+	m.SetFile("/media/a.mkv", &state.FileState{CID: "QmA"})
+	m.SetFile("/media/a-copy.mkv", &state.FileState{CID: "QmA"})
+	m.SetFile("/media/b.mkv", &state.FileState{CID: "QmB"})
+	m.SetFile("/media/c.mkv", &state.FileState{CID: "QmC"})
+	m.SetFile("/media/d.mkv", &state.FileState{CID: "QmD"})
+	m.SetFile("/media/e.mkv", &state.FileState{CID: "QmE"})
+	m.SetFile(ImportMFSPrefix+"/music/f.mp3", &state.FileState{CID: "QmF", Imported: true})
+
+	client := &pinnedClient{pins: map[string]string{
+		"QmB":    PinTypeRecursive,
+		"QmC":    PinTypeDirect,
+		"QmD":    PinTypeIndirect,
+		"QmRoot": PinTypeRecursive,
+	}}
+	missing, err := VerifyPins(context.Background(), client, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each unpinned CID is listed once; MFS imports are never pinned
+	if want := []string{"QmA", "QmE"}; !slices.Equal(missing, want) {
+		t.Errorf("missing = %v, want %v", missing, want)
+	}
+}
+
+func TestRepairMissing(t *testing.T) {
+	logger.Get().SetOutput(io.Discard)
+
+	// recorded writes a file and returns its state as recorded after an upload
+	recorded := func(t *testing.T, content string) (string, *state.FileState) {
+		path := filepath.Join(t.TempDir(), "song.mp3")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return path, &state.FileState{
+			CID:       "QmRecorded",
+			ModTime:   info.ModTime().Unix(),
+			ModTimeNs: info.ModTime().UnixNano(),
+			Size:      info.Size(),
+		}
+	}
+
+	t.Run("readded", func(t *testing.T) {
+		path, fs := recorded(t, "audio")
+		result, err := RepairMissing(context.Background(), &wipedClient{readdCID: "QmRecorded"}, map[string]*state.FileState{path: fs}, AddOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Readded != 1 || result.Skipped != 0 || len(result.Mismatch) != 0 {
+			t.Errorf("result = %+v, want one re-added file", result)
+		}
+	})
+
+	t.Run("rewritten within the same second", func(t *testing.T) {
+		path, fs := recorded(t, "audio")
+		if err := os.WriteFile(path, []byte("AUDIO"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Unix(fs.ModTime, 0).Add(time.Second - time.Nanosecond)
+		if mtime.UnixNano() == fs.ModTimeNs {
+			mtime = mtime.Add(-time.Millisecond)
+		}
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+
+		result, err := RepairMissing(context.Background(), &wipedClient{readdCID: "QmOther"}, map[string]*state.FileState{path: fs}, AddOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Skipped != 1 || len(result.Mismatch) != 0 {
+			t.Errorf("result = %+v, want the rewritten file skipped rather than a mismatch", result)
+		}
+	})
+}
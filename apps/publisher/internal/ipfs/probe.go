@@ -0,0 +1,117 @@
+package ipfs
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// ProbeTimeout bounds the provider lookup of a single CID. A lookup that finds
+// nothing runs until the deadline, so it is kept short.
+const ProbeTimeout = 15 * time.Second
+
+// provideTimeout bounds the re-announcement of a single CID to the routing system
+const provideTimeout = time.Minute
+
+// probeProviders is the number of providers a lookup waits for. The node itself
+// is usually the first, so one more is enough to show the CID is discoverable.
+const probeProviders = 3
+
+// Prober looks up and announces provider records (implemented by both clients)
+type Prober interface {
+	// FindProviders returns the peer IDs of up to max providers of cid found
+	// before ctx ends. Running out of time is not an error.
+	FindProviders(ctx context.Context, cid string, max int) ([]string, error)
+
+	// Provide announces to the routing system that the node provides cid
+	Provide(ctx context.Context, cid string) error
+
+	// GetID returns the peer ID of the node
+	GetID() (string, error)
+}
+
+// ProviderReport is the result of looking up providers of published CIDs
+type ProviderReport struct {
+	Checked     int
+	OK          int               // CIDs with at least one provider besides the node itself
+	Unreachable map[string]string // File path -> CID without an external provider
+	Reprovided  int               // Unreachable CIDs announced again
+	Failed      int               // Unreachable CIDs whose announcement failed
+}
+
+// OKRatio returns the fraction of checked CIDs that have an external provider
+func (r *ProviderReport) OKRatio() float64 {
+	if r.Checked == 0 {
+		return 0
+	}
+	return float64(r.OK) / float64(r.Checked)
+}
+
+// ProbeProviders looks up the providers of every CID in cids (file path -> CID),
+// each bounded by timeout. Providers are counted only if they are not the node
+// itself. With reprovide, CIDs without an external provider are announced again.
+func ProbeProviders(ctx context.Context, prober Prober, cids map[string]string, timeout time.Duration, reprovide bool) (*ProviderReport, error) {
+	self, err := prober.GetID()
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(cids))
+	for path := range cids {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	report := &ProviderReport{Unreachable: make(map[string]string)}
+	for _, path := range paths {
+		cid := cids[path]
+
+		lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+		providers, err := prober.FindProviders(lookupCtx, cid, probeProviders)
+		cancel()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to find providers of %s: %w", cid, err)
+		}
+
+		report.Checked++
+		if hasExternalProvider(providers, self) {
+			report.OK++
+			continue
+		}
+		report.Unreachable[path] = cid
+
+		if !reprovide {
+			continue
+		}
+		provideCtx, cancel := context.WithTimeout(ctx, provideTimeout)
+		err = prober.Provide(provideCtx, cid)
+		cancel()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			logger.Get().Warnf("Failed to provide %s: %v", cid, err)
+			report.Failed++
+			continue
+		}
+		report.Reprovided++
+	}
+
+	return report, nil
+}
+
+// hasExternalProvider reports whether providers contains a peer other than self
+func hasExternalProvider(providers []string, self string) bool {
+	for _, id := range providers {
+		if id != self {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package ipfs
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/state"
+)
+
+// routingProber answers lookups from a fixed provider table. A CID without an
+// entry blocks until the lookup times out, as a DHT query that finds nothing does.
+type routingProber struct {
+	providers  map[string][]string
+	provideErr error
+	provided   []string
+}
+
+func (p *routingProber) FindProviders(ctx context.Context, cid string, max int) ([]string, error) {
+	if providers, ok := p.providers[cid]; ok {
+		return providers, nil
+	}
+	<-ctx.Done()
+	return nil, nil
+}
+
+func (p *routingProber) Provide(ctx context.Context, cid string) error {
+	if p.provideErr != nil {
+		return p.provideErr
+	}
+	p.provided = append(p.provided, cid)
+	return nil
+}
+
+func (p *routingProber) GetID() (string, error) {
+	return "12D3Self", nil
+}
+
+func TestProbeProviders(t *testing.T) {
+	logger.Get().SetOutput(io.Discard)
+
+	cids := map[string]string{
+		"/music/a.mp3": "QmShared",
+		"/music/b.mp3": "QmOnlySelf",
+		"/music/c.mp3": "QmLost",
+	}
+	providers := map[string][]string{
+		"QmShared":   {"12D3Self", "12D3Friend"},
+		"QmOnlySelf": {"12D3Self"},
+	}
+
+	t.Run("report", func(t *testing.T) {
+		prober := &routingProber{providers: providers}
+		report, err := ProbeProviders(context.Background(), prober, cids, 20*time.Millisecond, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Checked != 3 || report.OK != 1 || len(report.Unreachable) != 2 {
+			t.Errorf("report = %+v, want 1 of 3 reachable", report)
+		}
+		if report.Unreachable["/music/b.mp3"] != "QmOnlySelf" || report.Unreachable["/music/c.mp3"] != "QmLost" {
+			t.Errorf("unreachable = %v, want the CIDs only this node provides", report.Unreachable)
+		}
+		if len(prober.provided) != 0 {
+			t.Errorf("provided %v without reprovide", prober.provided)
+		}
+	})
+
+	t.Run("reprovide", func(t *testing.T) {
+		prober := &routingProber{providers: providers}
+		report, err := ProbeProviders(context.Background(), prober, cids, 20*time.Millisecond, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Reprovided != 2 || report.Failed != 0 || len(prober.provided) != 2 {
+			t.Errorf("report = %+v, provided %v, want the two unreachable CIDs provided", report, prober.provided)
+		}
+	})
+
+	t.Run("reprovide failure", func(t *testing.T) {
+		prober := &routingProber{providers: providers, provideErr: errors.New("no routing")}
+		report, err := ProbeProviders(context.Background(), prober, cids, 20*time.Millisecond, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Reprovided != 0 || report.Failed != 2 {
+			t.Errorf("report = %+v, want two failed announcements", report)
+		}
+	})
+}
+
+func TestSampleCIDs(t *testing.T) {
+	files := map[string]*state.FileState{
+		"/a.mp3": {CID: "QmA"},
+		"/b.mp3": {CID: "QmB"},
+		"/c.mp3": {CID: "QmC"},
+		"/d.mp3": {}, // Not uploaded yet
+	}
+
+	if all := SampleCIDs(files, 0); len(all) != 3 || all["/b.mp3"] != "QmB" {
+		t.Errorf("SampleCIDs(0) = %v, want the three uploaded files", all)
+	}
+	sample := SampleCIDs(files, 2)
+	if len(sample) != 2 {
+		t.Fatalf("SampleCIDs(2) = %v, want two files", sample)
+	}
+	for path, cid := range sample {
+		if files[path].CID != cid {
+			t.Errorf("sampled %s as %s, recorded %s", path, cid, files[path].CID)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package ipfs
+
+import "io"
+
+// progressReader reports the bytes read so far to fn after every read
+type progressReader struct {
+	r  io.Reader
+	n  int64
+	fn func(int64)
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.n += int64(n)
+		p.fn(p.n)
+	}
+	return n, err
+}
+
+// withProgress wraps reader so that reads are reported to opts.ProgressFn, if set
+func withProgress(reader io.Reader, opts AddOptions) io.Reader {
+	if opts.ProgressFn == nil {
+		return reader
+	}
+	return &progressReader{r: reader, fn: opts.ProgressFn}
+}
+
+// reportProgress reports bytes read so far to opts.ProgressFn, if set
+func reportProgress(opts AddOptions, bytes int64) {
+	if opts.ProgressFn != nil {
+		opts.ProgressFn(bytes)
+	}
+}
@@ -0,0 +1,85 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+// progressRecorder collects the values passed to a ProgressFn
+type progressRecorder struct {
+	values []int64
+}
+
+func (r *progressRecorder) record(n int64) {
+	r.values = append(r.values, n)
+}
+
+// check fails unless the recorded values increase strictly and end at total
+func (r *progressRecorder) check(t *testing.T, total int64) {
+	t.Helper()
+
+	if len(r.values) == 0 {
+		t.Fatal("ProgressFn never called")
+	}
+	for i := 1; i < len(r.values); i++ {
+		if r.values[i] <= r.values[i-1] {
+			t.Fatalf("progress went from %d to %d", r.values[i-1], r.values[i])
+		}
+	}
+	if last := r.values[len(r.values)-1]; last != total {
+		t.Errorf("progress ended at %d, want the file size %d", last, total)
+	}
+}
+
+func TestWithProgress(t *testing.T) {
+	data := make([]byte, 10000)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	var rec progressRecorder
+	reader := withProgress(iotest.HalfReader(bytes.NewReader(data)), AddOptions{ProgressFn: rec.record})
+	got, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("content changed by the progress reader")
+	}
+	rec.check(t, int64(len(data)))
+
+	// Without a ProgressFn the reader is passed through
+	plain := bytes.NewReader(data)
+	if withProgress(plain, AddOptions{}) != io.Reader(plain) {
+		t.Error("reader wrapped without a ProgressFn")
+	}
+}
+
+func TestExternalAddReportsProgress(t *testing.T) {
+	data := bytes.Repeat([]byte("audio"), 100000)
+
+	var rec progressRecorder
+	client, _ := newAddServer(t, "")
+	if _, err := client.Add(context.Background(), bytes.NewReader(data), "song.mp3", AddOptions{ProgressFn: rec.record}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	rec.check(t, int64(len(data)))
+}
+
+func TestEmbeddedAddReportsProgress(t *testing.T) {
+	c := newOfflineClient(t)
+	data := make([]byte, 1<<20)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	var rec progressRecorder
+	if _, err := c.Add(context.Background(), bytes.NewReader(data), "song.mp3", AddOptions{ProgressFn: rec.record}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	rec.check(t, int64(len(data)))
+}
@@ -65,6 +65,57 @@ func InitializeRepo(repoPath string, swarmPort, apiPort, gatewayPort int) error
 	return nil
 }
 
+// ApplyResourceLimits writes the libp2p resource manager memory ceiling and the
+// connection manager water marks into the repo config before the node is built.
+// Empty or zero values leave kubo's defaults in place.
+func ApplyResourceLimits(r repo.Repo, maxMemory string, lowWater, highWater int) error {
+	if maxMemory == "" && lowWater == 0 && highWater == 0 {
+		return nil
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return fmt.Errorf("failed to read repo config: %w", err)
+	}
+
+	if maxMemory != "" {
+		cfg.Swarm.ResourceMgr.MaxMemory = config.NewOptionalString(maxMemory)
+	}
+	if lowWater > 0 {
+		cfg.Swarm.ConnMgr.LowWater = config.NewOptionalInteger(int64(lowWater))
+	}
+	if highWater > 0 {
+		cfg.Swarm.ConnMgr.HighWater = config.NewOptionalInteger(int64(highWater))
+	}
+
+	if err := r.SetConfig(cfg); err != nil {
+		return fmt.Errorf("failed to write repo config: %w", err)
+	}
+
+	return nil
+}
+
+// ApplyBootstrapPeers replaces the bootstrap list in the repo config with peers,
+// the multiaddrs normalized by config validation. An empty list keeps the list
+// the repo was initialized with.
+func ApplyBootstrapPeers(r repo.Repo, peers []string) error {
+	if len(peers) == 0 {
+		return nil
+	}
+
+	cfg, err := r.Config()
+	if err != nil {
+		return fmt.Errorf("failed to read repo config: %w", err)
+	}
+
+	cfg.Bootstrap = peers
+	if err := r.SetConfig(cfg); err != nil {
+		return fmt.Errorf("failed to write repo config: %w", err)
+	}
+
+	return nil
+}
+
 // CheckPortAvailable checks if a TCP port is available for use
 func CheckPortAvailable(port int) error {
 	// Try to listen on the port
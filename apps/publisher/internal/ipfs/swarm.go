@@ -0,0 +1,34 @@
+package ipfs
+
+import (
+	"fmt"
+
+	"github.com/atregu/ipfs-common/bootstrap"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	ma "github.com/multiformats/go-multiaddr"
+)
+
+// parsePeerAddr parses the address of SwarmConnect, a multiaddr ending in
+// /p2p/<peer ID> such as "/ip4/1.2.3.4/tcp/4001/p2p/12D3Koo..."
+func parsePeerAddr(addr string) (*peer.AddrInfo, error) {
+	maddr, err := bootstrap.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer address %q: %w", addr, err)
+	}
+	info, err := peer.AddrInfoFromP2pAddr(maddr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer address %q: %w", addr, err)
+	}
+	return info, nil
+}
+
+// peerOnlyAddr returns the multiaddr /p2p/<peerID>, which names every
+// connection to the peer rather than one of its addresses
+func peerOnlyAddr(peerID string) (ma.Multiaddr, error) {
+	id, err := peer.Decode(peerID)
+	if err != nil {
+		return nil, fmt.Errorf("invalid peer ID %q: %w", peerID, err)
+	}
+	return ma.NewMultiaddr("/p2p/" + id.String())
+}
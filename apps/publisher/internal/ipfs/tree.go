@@ -0,0 +1,84 @@
+package ipfs
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+)
+
+// TreeBuilder keeps directory trees of existing CIDs in the node's MFS
+// (implemented by both clients). Content is linked by CID, never added again,
+// and a change only re-creates the directories on its path.
+type TreeBuilder interface {
+	// TreeRoot returns the CID of the MFS directory dir, or "" if it does not exist
+	TreeRoot(ctx context.Context, dir string) (string, error)
+
+	// UpdateTree applies changes to the MFS directory dir, creating it if it
+	// does not exist, and returns its new CID
+	UpdateTree(ctx context.Context, dir string, changes TreeChanges) (string, error)
+
+	// RemoveTree removes the MFS directory dir and everything below it, if it exists
+	RemoveTree(ctx context.Context, dir string) error
+}
+
+// TreeChanges turns one version of a directory tree into the next. Paths are
+// slash-separated and relative to the root of the tree.
+type TreeChanges struct {
+	Link   map[string]string // CID to link at each new or changed path
+	Unlink []string          // Paths to remove; directories left empty are removed too
+}
+
+// DiffTree returns the changes turning the tree with the entries from (CID by
+// path) into the tree with the entries to
+func DiffTree(from, to map[string]string) TreeChanges {
+	changes := TreeChanges{Link: make(map[string]string)}
+	for p, cid := range to {
+		if from[p] != cid {
+			changes.Link[p] = cid
+		}
+	}
+	for p := range from {
+		if _, ok := to[p]; !ok {
+			changes.Unlink = append(changes.Unlink, p)
+		}
+	}
+	sort.Strings(changes.Unlink)
+	return changes
+}
+
+// Empty reports whether there is nothing to change
+func (c TreeChanges) Empty() bool {
+	return len(c.Link) == 0 && len(c.Unlink) == 0
+}
+
+// LinkPaths returns the paths of Link in order
+func (c TreeChanges) LinkPaths() []string {
+	paths := make([]string, 0, len(c.Link))
+	for p := range c.Link {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Parents returns the directories that removing Unlink may leave empty, every
+// ancestor of an unlinked path, deepest first so emptied parents follow their
+// emptied children
+func (c TreeChanges) Parents() []string {
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, p := range c.Unlink {
+		for dir := path.Dir(p); dir != "." && !seen[dir]; dir = path.Dir(dir) {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	sort.Slice(dirs, func(i, j int) bool {
+		if di, dj := strings.Count(dirs[i], "/"), strings.Count(dirs[j], "/"); di != dj {
+			return di > dj
+		}
+		return dirs[i] < dirs[j]
+	})
+	return dirs
+}
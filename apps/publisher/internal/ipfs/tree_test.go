@@ -0,0 +1,46 @@
+package ipfs
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDiffTree(t *testing.T) {
+	from := map[string]string{
+		"collection.ndjson":        "QmIndex1",
+		"Artist/Album/01.flac":     "QmA",
+		"Artist/Album/02.flac":     "QmB",
+		"Artist/Live/Disc 1/1.mp3": "QmC",
+		"loose.mp3":                "QmD",
+	}
+	to := map[string]string{
+		"collection.ndjson":    "QmIndex2",
+		"Artist/Album/01.flac": "QmA",
+		"Artist/Album/02.flac": "QmB2",
+		"Other/03.flac":        "QmE",
+		"renamed.mp3":          "QmD",
+	}
+
+	changes := DiffTree(from, to)
+	if got, want := changes.LinkPaths(), []string{"Artist/Album/02.flac", "Other/03.flac", "collection.ndjson", "renamed.mp3"}; !slices.Equal(got, want) {
+		t.Errorf("linked %v, want %v", got, want)
+	}
+	if changes.Link["renamed.mp3"] != "QmD" || changes.Link["Artist/Album/02.flac"] != "QmB2" {
+		t.Errorf("Link = %v, want the new CIDs", changes.Link)
+	}
+	if want := []string{"Artist/Live/Disc 1/1.mp3", "loose.mp3"}; !slices.Equal(changes.Unlink, want) {
+		t.Errorf("unlinked %v, want %v", changes.Unlink, want)
+	}
+
+	// Emptied directories are checked from the deepest up
+	if got, want := changes.Parents(), []string{"Artist/Live/Disc 1", "Artist/Live", "Artist"}; !slices.Equal(got, want) {
+		t.Errorf("Parents() = %v, want %v", got, want)
+	}
+
+	if !DiffTree(to, to).Empty() {
+		t.Error("diff of a tree with itself is not empty")
+	}
+	if full := DiffTree(nil, to); len(full.Link) != len(to) || len(full.Unlink) != 0 {
+		t.Errorf("diff from nothing = %+v, want every entry linked", full)
+	}
+}
@@ -0,0 +1,138 @@
+package ipfs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/atregu/ipfs-publisher/internal/config"
+)
+
+// ErrVerificationFailed indicates that content read back from IPFS differs from the local file
+var ErrVerificationFailed = errors.New("uploaded content does not match local file")
+
+// verifyChunkSize is the buffer size used when comparing full content
+const verifyChunkSize = 256 * 1024
+
+// Verifier re-reads uploaded content from the node and compares it with the local file
+type Verifier struct {
+	client     Client
+	mode       string
+	sampleSize int64
+	verified   atomic.Int64
+	failed     atomic.Int64
+}
+
+// NewVerifier creates a verifier for behavior.verify_uploads. With noCopy the
+// node serves content from the local file itself, so reading it back proves
+// nothing and verification is disabled.
+func NewVerifier(client Client, cfg *config.BehaviorConfig, noCopy bool) *Verifier {
+	mode := cfg.VerifyUploads
+	if noCopy {
+		mode = config.VerifyUploadsOff
+	}
+
+	return &Verifier{
+		client:     client,
+		mode:       mode,
+		sampleSize: cfg.VerifySampleSize,
+	}
+}
+
+// Enabled reports whether uploads are verified
+func (v *Verifier) Enabled() bool {
+	return v.mode != "" && v.mode != config.VerifyUploadsOff
+}
+
+// Verify compares the content behind cid with the local file at path. In sample
+// mode only the first and last sample_size bytes are compared. A mismatch returns
+// an error wrapping ErrVerificationFailed; the upload should not be recorded.
+func (v *Verifier) Verify(ctx context.Context, cid, path string, size int64) error {
+	if !v.Enabled() {
+		return nil
+	}
+
+	var err error
+	if v.mode == config.VerifyUploadsFull || size <= 2*v.sampleSize {
+		err = v.compareRange(ctx, cid, path, 0, size, true)
+	} else {
+		err = v.compareRange(ctx, cid, path, 0, v.sampleSize, false)
+		if err == nil {
+			err = v.compareRange(ctx, cid, path, size-v.sampleSize, v.sampleSize, true)
+		}
+	}
+
+	if err != nil {
+		v.failed.Add(1)
+		return err
+	}
+
+	v.verified.Add(1)
+	return nil
+}
+
+// Counts returns the number of verified and failed uploads
+func (v *Verifier) Counts() (verified, failed int64) {
+	return v.verified.Load(), v.failed.Load()
+}
+
+// compareRange compares length bytes at offset between the node and the local file.
+// If atEnd is set, the range ends at the end of the file and the node must not return more data.
+func (v *Verifier) compareRange(ctx context.Context, cid, path string, offset, length int64, atEnd bool) error {
+	local, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer local.Close()
+
+	if _, err := local.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek %s: %w", path, err)
+	}
+
+	remoteLength := length
+	if atEnd {
+		remoteLength++
+	}
+
+	remote, err := v.client.CatRange(ctx, cid, offset, remoteLength)
+	if err != nil {
+		return fmt.Errorf("failed to read back %s: %w", cid, err)
+	}
+	defer remote.Close()
+
+	localBuf := make([]byte, verifyChunkSize)
+	remoteBuf := make([]byte, verifyChunkSize)
+	localReader := io.LimitReader(local, length)
+
+	for pos := offset; ; {
+		n, lerr := io.ReadFull(localReader, localBuf)
+		m, rerr := io.ReadFull(remote, remoteBuf[:n])
+		if m != n || !bytes.Equal(localBuf[:n], remoteBuf[:m]) {
+			return fmt.Errorf("%w: %s differs from %s near offset %d", ErrVerificationFailed, cid, path, pos)
+		}
+		pos += int64(n)
+
+		if lerr == io.EOF || lerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if lerr != nil {
+			return fmt.Errorf("failed to read %s: %w", path, lerr)
+		}
+		if rerr != nil {
+			return fmt.Errorf("failed to read back %s: %w", cid, rerr)
+		}
+	}
+
+	// The node must not return more data than the local file has
+	if atEnd {
+		if extra, _ := io.ReadFull(remote, remoteBuf[:1]); extra > 0 {
+			return fmt.Errorf("%w: %s is longer than %s", ErrVerificationFailed, cid, path)
+		}
+	}
+
+	return nil
+}
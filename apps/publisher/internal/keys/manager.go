@@ -4,146 +4,509 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/atregu/ipfs-publisher/internal/logger"
 )
 
-// Manager handles Ed25519 key pair management
+// DefaultName is the name of the publisher's primary identity. Installations with
+// a single keypair directly in the keys directory are migrated to this name.
+const DefaultName = "default"
+
+// manifestFile lists the keys of the keys directory
+const manifestFile = "manifest.json"
+
+// Key status values
+const (
+	StatusActive  = "active"
+	StatusRetired = "retired"
+)
+
+// namePattern matches valid key names, which are used as directory names
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ErrNotFound is returned for key names that are not in the manifest
+var ErrNotFound = errors.New("key not found")
+
+// KeyInfo describes a key in the manifest
+type KeyInfo struct {
+	Name      string     `json:"name"`
+	PublicKey string     `json:"publicKey"` // Hex-encoded Ed25519 public key
+	Status    string     `json:"status"`
+	CreatedAt time.Time  `json:"createdAt"`
+	RetiredAt *time.Time `json:"retiredAt,omitempty"`
+}
+
+// manifest is the on-disk list of keys
+type manifest struct {
+	Keys []KeyInfo `json:"keys"`
+}
+
+// Manager handles named Ed25519 key pairs stored as keys/<name>/private.key and
+// public.key. Retired keys keep their public key so that signatures made with
+// them remain verifiable; their private key is deleted.
 type Manager struct {
-	keysDir    string
-	privateKey ed25519.PrivateKey
-	publicKey  ed25519.PublicKey
+	keysDir  string
+	mu       sync.Mutex
+	manifest manifest
+	private  map[string]ed25519.PrivateKey
 }
 
 // New creates a new key manager
 func New(keysDir string) *Manager {
 	return &Manager{
 		keysDir: expandPath(keysDir),
+		private: make(map[string]ed25519.PrivateKey),
 	}
 }
 
-// Initialize loads or generates keys
+// ValidateName checks that name can be used as a key name
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid key name %q: use up to 64 lowercase letters, digits, '-' and '_'", name)
+	}
+	return nil
+}
+
+// Initialize loads the manifest, migrating a single-key installation to the
+// default key, and generates the default key if the directory has no keys
 func (m *Manager) Initialize() error {
 	log := logger.Get()
 
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	// Create keys directory with secure permissions
 	if err := os.MkdirAll(m.keysDir, 0700); err != nil {
 		return fmt.Errorf("failed to create keys directory: %w", err)
 	}
 
-	privateKeyPath := filepath.Join(m.keysDir, "private.key")
-
-	// Check if keys exist
-	if _, err := os.Stat(privateKeyPath); err == nil {
-		// Load existing keys
-		log.Info("Loading existing IPNS keypair...")
-		if err := m.loadKeys(); err != nil {
-			return fmt.Errorf("failed to load keys: %w", err)
+	data, err := os.ReadFile(m.manifestPath())
+	if err == nil {
+		if err := json.Unmarshal(data, &m.manifest); err != nil {
+			return fmt.Errorf("failed to parse key manifest: %w", err)
 		}
-		log.Info("✓ IPNS keypair loaded successfully")
+		log.Infof("Loaded %d keys", len(m.manifest.Keys))
+		if m.findLocked(DefaultName) != nil {
+			return nil
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read key manifest: %w", err)
+	} else if err := m.adoptLegacy(); err != nil {
+		return fmt.Errorf("failed to migrate keys: %w", err)
+	}
+
+	if m.findLocked(DefaultName) != nil {
+		log.Infof("✓ Existing IPNS keypair migrated to key %q", DefaultName)
 		return nil
 	}
 
-	// Generate new keys
 	log.Info("Generating new Ed25519 keypair for IPNS...")
-	if err := m.generateKeys(); err != nil {
-		return fmt.Errorf("failed to generate keys: %w", err)
+	if _, err := m.createLocked(DefaultName); err != nil {
+		return err
 	}
+	log.Info("✓ IPNS keypair generated and saved")
+	return nil
+}
 
-	// Save keys
-	if err := m.saveKeys(); err != nil {
-		return fmt.Errorf("failed to save keys: %w", err)
+// adoptLegacy moves a keypair stored directly in the keys directory into the
+// directory of the default key and records it in the manifest. A default key
+// directory left by an interrupted migration is adopted as well.
+func (m *Manager) adoptLegacy() error {
+	dir := m.keyDir(DefaultName)
+	for _, name := range []string{"private.key", "public.key"} {
+		legacy := filepath.Join(m.keysDir, name)
+		if _, err := os.Stat(legacy); os.IsNotExist(err) {
+			continue
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create key directory: %w", err)
+		}
+		if err := os.Rename(legacy, filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("failed to move %s: %w", name, err)
+		}
 	}
 
-	log.Info("✓ IPNS keypair generated and saved")
-	return nil
+	privatePath := filepath.Join(dir, "private.key")
+	info, err := os.Stat(privatePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// An existing private key must never be replaced by a generated one
+	privateKey, err := readPrivateKey(dir)
+	if err != nil {
+		return err
+	}
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	if err := os.WriteFile(filepath.Join(dir, "public.key"), []byte(hex.EncodeToString(publicKey)), 0644); err != nil {
+		return fmt.Errorf("failed to write public key: %w", err)
+	}
+
+	m.manifest.Keys = append(m.manifest.Keys, KeyInfo{
+		Name:      DefaultName,
+		PublicKey: hex.EncodeToString(publicKey),
+		Status:    StatusActive,
+		CreatedAt: info.ModTime().UTC(),
+	})
+	return m.saveManifest()
 }
 
-// generateKeys generates a new Ed25519 key pair
-func (m *Manager) generateKeys() error {
+// Create generates and stores a new active key. Names of retired keys cannot be reused.
+func (m *Manager) Create(name string) (ed25519.PublicKey, error) {
+	if err := ValidateName(name); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.findLocked(name) != nil {
+		return nil, fmt.Errorf("key %q already exists", name)
+	}
+	return m.createLocked(name)
+}
+
+// createLocked generates, saves and records a key. mu must be held.
+func (m *Manager) createLocked(name string) (ed25519.PublicKey, error) {
 	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
-		return fmt.Errorf("failed to generate Ed25519 key: %w", err)
+		return nil, fmt.Errorf("failed to generate Ed25519 key: %w", err)
 	}
 
-	m.privateKey = privateKey
-	m.publicKey = publicKey
-	return nil
+	dir := m.keyDir(name)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+
+	// Save private key with 0600 permissions, never replacing a key missing from the manifest
+	file, err := os.OpenFile(filepath.Join(dir, "private.key"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create private key: %w", err)
+	}
+	_, err = file.WriteString(hex.EncodeToString(privateKey))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write private key: %w", err)
+	}
+
+	// Save public key with 0644 permissions
+	if err := os.WriteFile(filepath.Join(dir, "public.key"), []byte(hex.EncodeToString(publicKey)), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write public key: %w", err)
+	}
+
+	m.manifest.Keys = append(m.manifest.Keys, KeyInfo{
+		Name:      name,
+		PublicKey: hex.EncodeToString(publicKey),
+		Status:    StatusActive,
+		CreatedAt: time.Now().UTC(),
+	})
+	if err := m.saveManifest(); err != nil {
+		return nil, err
+	}
+
+	m.private[name] = privateKey
+	return publicKey, nil
 }
 
-// saveKeys saves keys to disk with secure permissions
-func (m *Manager) saveKeys() error {
-	privateKeyPath := filepath.Join(m.keysDir, "private.key")
-	publicKeyPath := filepath.Join(m.keysDir, "public.key")
+// Get returns the private key of an active key
+func (m *Manager) Get(name string) (ed25519.PrivateKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	// Save private key with 0600 permissions
-	privateKeyHex := hex.EncodeToString(m.privateKey)
-	if err := os.WriteFile(privateKeyPath, []byte(privateKeyHex), 0600); err != nil {
-		return fmt.Errorf("failed to write private key: %w", err)
+	info := m.findLocked(name)
+	if info == nil {
+		return nil, fmt.Errorf("key %q: %w", name, ErrNotFound)
+	}
+	if info.Status != StatusActive {
+		return nil, fmt.Errorf("key %q is retired", name)
 	}
 
-	// Save public key with 0644 permissions
-	publicKeyHex := hex.EncodeToString(m.publicKey)
-	if err := os.WriteFile(publicKeyPath, []byte(publicKeyHex), 0644); err != nil {
-		return fmt.Errorf("failed to write public key: %w", err)
+	if privateKey, ok := m.private[name]; ok {
+		return privateKey, nil
+	}
+
+	privateKey, err := readPrivateKey(m.keyDir(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load key %q: %w", name, err)
 	}
+	if hex.EncodeToString(privateKey.Public().(ed25519.PublicKey)) != info.PublicKey {
+		return nil, fmt.Errorf("key %q does not match the public key in the manifest", name)
+	}
+
+	m.private[name] = privateKey
+	return privateKey, nil
+}
+
+// PublicKey returns the public key of an active or retired key
+func (m *Manager) PublicKey(name string) (ed25519.PublicKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	info := m.findLocked(name)
+	if info == nil {
+		return nil, fmt.Errorf("key %q: %w", name, ErrNotFound)
+	}
+	publicKey, err := hex.DecodeString(info.PublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key of %q in the manifest", name)
+	}
+	return ed25519.PublicKey(publicKey), nil
+}
+
+// List returns all keys, active and retired, ordered by creation time
+func (m *Manager) List() []KeyInfo {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
+	keys := append([]KeyInfo(nil), m.manifest.Keys...)
+	sort.SliceStable(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys
+}
+
+// Retire marks a key as retired and deletes its private key. The public key stays
+// in the manifest and in keys/<name>/public.key.
+func (m *Manager) Retire(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	info := m.findLocked(name)
+	if info == nil {
+		return fmt.Errorf("key %q: %w", name, ErrNotFound)
+	}
+	if info.Status == StatusRetired {
+		return fmt.Errorf("key %q is already retired", name)
+	}
+
+	now := time.Now().UTC()
+	info.Status = StatusRetired
+	info.RetiredAt = &now
+	if err := m.saveManifest(); err != nil {
+		return err
+	}
+
+	delete(m.private, name)
+	if err := os.Remove(filepath.Join(m.keyDir(name), "private.key")); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete private key of %q: %w", name, err)
+	}
 	return nil
 }
 
-// loadKeys loads keys from disk
-func (m *Manager) loadKeys() error {
-	privateKeyPath := filepath.Join(m.keysDir, "private.key")
-	publicKeyPath := filepath.Join(m.keysDir, "public.key")
+// Rotate replaces an active key with a new keypair, e.g. after the private key
+// leaked. The old pair is archived first as private.key.<unix-time> and
+// public.key.<unix-time> in the key's directory, then the new pair replaces the
+// files by rename and the manifest records its public key. Signatures made with
+// the old key stay verifiable with LoadHistoric.
+func (m *Manager) Rotate(name string) (ed25519.PublicKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-	// Load private key
-	privateKeyHex, err := os.ReadFile(privateKeyPath)
+	info := m.findLocked(name)
+	if info == nil {
+		return nil, fmt.Errorf("key %q: %w", name, ErrNotFound)
+	}
+	if info.Status != StatusActive {
+		return nil, fmt.Errorf("key %q is retired", name)
+	}
+
+	dir := m.keyDir(name)
+	oldPrivate, err := readPrivateKey(dir)
 	if err != nil {
-		return fmt.Errorf("failed to read private key: %w", err)
+		return nil, fmt.Errorf("failed to load key %q: %w", name, err)
+	}
+	oldPublic := oldPrivate.Public().(ed25519.PublicKey)
+	if hex.EncodeToString(oldPublic) != info.PublicKey {
+		return nil, fmt.Errorf("key %q does not match the public key in the manifest", name)
 	}
 
-	privateKey, err := hex.DecodeString(string(privateKeyHex))
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
 	if err != nil {
-		return fmt.Errorf("failed to decode private key: %w", err)
+		return nil, fmt.Errorf("failed to generate Ed25519 key: %w", err)
 	}
 
-	if len(privateKey) != ed25519.PrivateKeySize {
-		return fmt.Errorf("invalid private key size: expected %d, got %d", ed25519.PrivateKeySize, len(privateKey))
+	// The old pair is archived before anything is replaced, so no key is lost
+	suffix := fmt.Sprintf(".%d", time.Now().Unix())
+	if err := writeKeyFile(filepath.Join(dir, "private.key"+suffix), oldPrivate, 0600); err != nil {
+		return nil, fmt.Errorf("failed to archive private key: %w", err)
+	}
+	if err := writeKeyFile(filepath.Join(dir, "public.key"+suffix), oldPublic, 0644); err != nil {
+		return nil, fmt.Errorf("failed to archive public key: %w", err)
+	}
+
+	for _, file := range []struct {
+		name string
+		key  []byte
+		perm os.FileMode
+	}{
+		{"private.key", privateKey, 0600},
+		{"public.key", publicKey, 0644},
+	} {
+		path := filepath.Join(dir, file.name)
+		os.Remove(path + ".tmp") // Left by an interrupted rotation
+		if err := writeKeyFile(path+".tmp", file.key, file.perm); err != nil {
+			return nil, fmt.Errorf("failed to write new %s: %w", file.name, err)
+		}
+		if err := os.Rename(path+".tmp", path); err != nil {
+			os.Remove(path + ".tmp")
+			return nil, fmt.Errorf("failed to replace %s: %w", file.name, err)
+		}
 	}
 
-	m.privateKey = ed25519.PrivateKey(privateKey)
+	info.PublicKey = hex.EncodeToString(publicKey)
+	if err := m.saveManifest(); err != nil {
+		return nil, err
+	}
+	m.private[name] = privateKey
+	return publicKey, nil
+}
 
-	// Load public key
-	publicKeyHex, err := os.ReadFile(publicKeyPath)
+// LoadHistoric returns the archived public keys of a key, rotated away by
+// Rotate, oldest first
+func (m *Manager) LoadHistoric(name string) ([]ed25519.PublicKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.findLocked(name) == nil {
+		return nil, fmt.Errorf("key %q: %w", name, ErrNotFound)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(m.keyDir(name), "public.key.*"))
 	if err != nil {
-		return fmt.Errorf("failed to read public key: %w", err)
+		return nil, fmt.Errorf("failed to list archived keys: %w", err)
 	}
 
-	publicKey, err := hex.DecodeString(string(publicKeyHex))
+	type archived struct {
+		rotatedAt int64
+		path      string
+	}
+	var keys []archived
+	for _, path := range paths {
+		rotatedAt, err := strconv.ParseInt(strings.TrimPrefix(filepath.Ext(path), "."), 10, 64)
+		if err != nil {
+			continue // Not an archive, e.g. a temporary file
+		}
+		keys = append(keys, archived{rotatedAt, path})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].rotatedAt < keys[j].rotatedAt })
+
+	historic := make([]ed25519.PublicKey, 0, len(keys))
+	for _, key := range keys {
+		publicKey, err := readPublicKeyFile(key.path)
+		if err != nil {
+			return nil, err
+		}
+		historic = append(historic, publicKey)
+	}
+	return historic, nil
+}
+
+// writeKeyFile writes a hex-encoded key to a new file
+func writeKeyFile(path string, key []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
 	if err != nil {
-		return fmt.Errorf("failed to decode public key: %w", err)
+		return err
+	}
+	_, err = file.WriteString(hex.EncodeToString(key))
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
 	}
+	return err
+}
 
-	if len(publicKey) != ed25519.PublicKeySize {
-		return fmt.Errorf("invalid public key size: expected %d, got %d", ed25519.PublicKeySize, len(publicKey))
+// findLocked returns the manifest entry of name, or nil. mu must be held.
+func (m *Manager) findLocked(name string) *KeyInfo {
+	for i := range m.manifest.Keys {
+		if m.manifest.Keys[i].Name == name {
+			return &m.manifest.Keys[i]
+		}
 	}
+	return nil
+}
 
-	m.publicKey = ed25519.PublicKey(publicKey)
+// saveManifest writes the manifest atomically. mu must be held.
+func (m *Manager) saveManifest() error {
+	data, err := json.MarshalIndent(m.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal key manifest: %w", err)
+	}
 
+	tmpPath := m.manifestPath() + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write key manifest: %w", err)
+	}
+	if err := os.Rename(tmpPath, m.manifestPath()); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename key manifest: %w", err)
+	}
 	return nil
 }
 
-// GetPrivateKey returns the private key
-func (m *Manager) GetPrivateKey() ed25519.PrivateKey {
-	return m.privateKey
+// manifestPath returns the path of the manifest
+func (m *Manager) manifestPath() string {
+	return filepath.Join(m.keysDir, manifestFile)
 }
 
-// GetPublicKey returns the public key
-func (m *Manager) GetPublicKey() ed25519.PublicKey {
-	return m.publicKey
+// keyDir returns the directory of a named key
+func (m *Manager) keyDir(name string) string {
+	return filepath.Join(m.keysDir, name)
+}
+
+// readPrivateKey loads the hex-encoded private key of a key directory
+func readPrivateKey(dir string) (ed25519.PrivateKey, error) {
+	privateKeyHex, err := os.ReadFile(filepath.Join(dir, "private.key"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	privateKey, err := hex.DecodeString(string(privateKeyHex))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode private key: %w", err)
+	}
+
+	if len(privateKey) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key size: expected %d, got %d", ed25519.PrivateKeySize, len(privateKey))
+	}
+
+	return ed25519.PrivateKey(privateKey), nil
+}
+
+// readPublicKey loads the hex-encoded public key of a key directory
+func readPublicKey(dir string) (ed25519.PublicKey, error) {
+	return readPublicKeyFile(filepath.Join(dir, "public.key"))
+}
+
+// readPublicKeyFile loads a hex-encoded public key
+func readPublicKeyFile(path string) (ed25519.PublicKey, error) {
+	publicKeyHex, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public key: %w", err)
+	}
+
+	publicKey, err := hex.DecodeString(string(publicKeyHex))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode public key: %w", err)
+	}
+
+	if len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key size: expected %d, got %d", ed25519.PublicKeySize, len(publicKey))
+	}
+
+	return ed25519.PublicKey(publicKey), nil
 }
 
 func expandPath(path string) string {
@@ -0,0 +1,208 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeLegacyKeys stores a keypair in the single-key layout and returns it
+func writeLegacyKeys(t *testing.T, dir string) ed25519.PrivateKey {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "private.key"), []byte(hex.EncodeToString(privateKey)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "public.key"), []byte(hex.EncodeToString(publicKey)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return privateKey
+}
+
+// initManager returns an initialized manager of dir
+func initManager(t *testing.T, dir string) *Manager {
+	t.Helper()
+
+	m := New(dir)
+	if err := m.Initialize(); err != nil {
+		t.Fatalf("Initialize: %v", err)
+	}
+	return m
+}
+
+func TestInitializeGeneratesDefaultKey(t *testing.T) {
+	dir := t.TempDir()
+	key, err := initManager(t, dir).Get(DefaultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The key survives a restart
+	reloaded, err := initManager(t, dir).Get(DefaultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(reloaded) {
+		t.Error("default key changed after reloading")
+	}
+}
+
+func TestInitializeMigratesSingleKey(t *testing.T) {
+	dir := t.TempDir()
+	legacy := writeLegacyKeys(t, dir)
+
+	m := initManager(t, dir)
+	key, err := m.Get(DefaultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(legacy) {
+		t.Error("migrated default key differs from the single key")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "private.key")); !os.IsNotExist(err) {
+		t.Error("single-key private.key left in place after migration")
+	}
+	if _, err := os.Stat(filepath.Join(dir, DefaultName, "private.key")); err != nil {
+		t.Errorf("migrated private key missing: %v", err)
+	}
+}
+
+func TestInitializeResumesInterruptedMigration(t *testing.T) {
+	dir := t.TempDir()
+	legacy := writeLegacyKeys(t, dir)
+
+	// Only the private key was moved before the process stopped
+	if err := os.MkdirAll(filepath.Join(dir, DefaultName), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(dir, "private.key"), filepath.Join(dir, DefaultName, "private.key")); err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := initManager(t, dir).Get(DefaultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Equal(legacy) {
+		t.Error("interrupted migration replaced the key")
+	}
+}
+
+func TestCreateListRetire(t *testing.T) {
+	dir := t.TempDir()
+	m := initManager(t, dir)
+
+	publicKey, err := m.Create("mirror-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Create("mirror-1"); err == nil {
+		t.Error("created a key with an existing name")
+	}
+	for _, name := range []string{"", "Upper", "../escape", "a/b"} {
+		if _, err := m.Create(name); err == nil {
+			t.Errorf("created a key named %q", name)
+		}
+	}
+
+	if err := m.Retire("mirror-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Retire("mirror-1"); err == nil {
+		t.Error("retired a key twice")
+	}
+	if err := m.Retire("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Retire(missing) = %v, want ErrNotFound", err)
+	}
+
+	// After a restart the retired key is listed with its public key but cannot sign
+	m = initManager(t, dir)
+	list := m.List()
+	if len(list) != 2 || list[0].Name != DefaultName || list[1].Name != "mirror-1" {
+		t.Fatalf("List = %+v, want default and mirror-1", list)
+	}
+	if list[1].Status != StatusRetired || list[1].RetiredAt == nil {
+		t.Errorf("mirror-1 = %+v, want retired with a timestamp", list[1])
+	}
+	if _, err := m.Get("mirror-1"); err == nil {
+		t.Error("Get returned a retired key")
+	}
+	retained, err := m.PublicKey("mirror-1")
+	if err != nil || !retained.Equal(publicKey) {
+		t.Errorf("PublicKey(mirror-1) = %x, %v; want the retired public key", retained, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "mirror-1", "private.key")); !os.IsNotExist(err) {
+		t.Error("private key of the retired key was not deleted")
+	}
+	if _, err := m.Create("mirror-1"); err == nil {
+		t.Error("reused the name of a retired key")
+	}
+}
+
+func TestRotate(t *testing.T) {
+	dir := t.TempDir()
+	m := initManager(t, dir)
+
+	old, err := m.Get(DefaultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An archive of an earlier rotation
+	earlier, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, DefaultName, "public.key.1000"), []byte(hex.EncodeToString(earlier)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := m.Rotate(DefaultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if publicKey.Equal(old.Public()) {
+		t.Fatal("Rotate kept the old key")
+	}
+
+	// After a restart the new key signs and the old pair is archived
+	m = initManager(t, dir)
+	current, err := m.Get(DefaultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !publicKey.Equal(current.Public()) {
+		t.Errorf("Get after Rotate = %x, want the new key", current.Public())
+	}
+	historic, err := m.LoadHistoric(DefaultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(historic) != 2 || !historic[0].Equal(earlier) || !historic[1].Equal(old.Public()) {
+		t.Errorf("LoadHistoric = %x, want the earlier and the rotated key, oldest first", historic)
+	}
+	archives, _ := filepath.Glob(filepath.Join(dir, DefaultName, "private.key.*"))
+	if len(archives) != 1 {
+		t.Fatalf("archived private keys = %v, want 1", archives)
+	}
+	if info, err := os.Stat(archives[0]); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("archived private key mode = %v, %v; want 0600", info.Mode().Perm(), err)
+	}
+
+	if err := m.Retire(DefaultName); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Rotate(DefaultName); err == nil {
+		t.Error("rotated a retired key")
+	}
+	if _, err := m.Rotate("missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Rotate(missing) = %v, want ErrNotFound", err)
+	}
+}
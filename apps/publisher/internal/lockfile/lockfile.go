@@ -9,7 +9,14 @@ import (
 	"syscall"
 )
 
-const defaultLockFile = ".ipfs_publisher.lock"
+const (
+	// defaultLockFile is the lock file name of the default instance
+	defaultLockFile = ".ipfs_publisher.lock"
+	// lockFilePattern is the lock file name of a named instance
+	lockFilePattern = ".ipfs_publisher-%s.lock"
+	// defaultInstanceID mirrors config.DefaultInstanceID
+	defaultInstanceID = "default"
+)
 
 // Lockfile represents a process lock file
 type Lockfile struct {
@@ -17,15 +24,47 @@ type Lockfile struct {
 	file *os.File
 }
 
-// New creates a new lockfile instance
-func New(baseDir string) *Lockfile {
-	lockPath := filepath.Join(baseDir, defaultLockFile)
-	return &Lockfile{path: lockPath}
+// Holder is the running process that holds a lock
+type Holder struct {
+	PID   int
+	Owner string // Command that took the lock; "" in lock files of older versions
+}
+
+// HeldError is returned by Acquire when a running process holds the lock
+type HeldError struct {
+	Holder
+}
+
+func (e *HeldError) Error() string {
+	if e.Owner == "" {
+		return fmt.Sprintf("another instance is already running (PID: %d)", e.PID)
+	}
+	return fmt.Sprintf("%s is already running (PID: %d)", e.Owner, e.PID)
+}
+
+// New creates a new lockfile instance for the given instance ID. The default
+// instance keeps the historical .ipfs_publisher.lock name so that upgraded and
+// older binaries still exclude each other.
+func New(baseDir, instanceID string) (*Lockfile, error) {
+	if instanceID == "" {
+		return nil, fmt.Errorf("instance ID must not be empty")
+	}
+
+	name := defaultLockFile
+	if instanceID != defaultInstanceID {
+		name = fmt.Sprintf(lockFilePattern, instanceID)
+	}
+
+	return &Lockfile{path: filepath.Join(baseDir, name)}, nil
+}
+
+// GetPath returns the lock file path
+func (l *Lockfile) GetPath() string {
+	return l.path
 }
 
-// Acquire attempts to acquire the lock
-func (l *Lockfile) Acquire() error {
-	// Expand tilde in path
+// expandPath expands a leading tilde in the lock file path
+func (l *Lockfile) expandPath() error {
 	if strings.HasPrefix(l.path, "~") {
 		home, err := os.UserHomeDir()
 		if err != nil {
@@ -33,6 +72,36 @@ func (l *Lockfile) Acquire() error {
 		}
 		l.path = filepath.Join(home, l.path[1:])
 	}
+	return nil
+}
+
+// Holder returns the running process that holds the lock, or nil if the lock is
+// free or stale. Read-only commands use it to report a running publisher without
+// taking the lock themselves.
+func (l *Lockfile) Holder() (*Holder, error) {
+	if err := l.expandPath(); err != nil {
+		return nil, err
+	}
+
+	holder, err := l.readHolder()
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !l.isProcessRunning(holder.PID) {
+		return nil, nil
+	}
+	return holder, nil
+}
+
+// Acquire attempts to acquire the lock for owner, the command that takes it.
+// If a running process holds the lock, the error is a *HeldError.
+func (l *Lockfile) Acquire(owner string) error {
+	if err := l.expandPath(); err != nil {
+		return err
+	}
 
 	// Create directory if it doesn't exist
 	dir := filepath.Dir(l.path)
@@ -43,10 +112,10 @@ func (l *Lockfile) Acquire() error {
 	// Check if lock file exists
 	if _, err := os.Stat(l.path); err == nil {
 		// Lock file exists, check if process is still running
-		pid, err := l.readPID()
+		holder, err := l.readHolder()
 		if err == nil {
-			if l.isProcessRunning(pid) {
-				return fmt.Errorf("another instance is already running (PID: %d)", pid)
+			if l.isProcessRunning(holder.PID) {
+				return &HeldError{Holder: *holder}
 			}
 			// Process not running, remove stale lock file
 			if err := os.Remove(l.path); err != nil {
@@ -66,9 +135,9 @@ func (l *Lockfile) Acquire() error {
 
 	l.file = file
 
-	// Write current PID to lock file
+	// Write current PID and owner to lock file
 	pid := os.Getpid()
-	if _, err := file.WriteString(fmt.Sprintf("%d\n", pid)); err != nil {
+	if _, err := file.WriteString(fmt.Sprintf("%d\n%s\n", pid, owner)); err != nil {
 		file.Close()
 		os.Remove(l.path)
 		return fmt.Errorf("failed to write PID to lock file: %w", err)
@@ -98,20 +167,21 @@ func (l *Lockfile) Release() error {
 	return nil
 }
 
-// readPID reads the PID from the lock file
-func (l *Lockfile) readPID() (int, error) {
+// readHolder reads the PID and owner from the lock file. Lock files of older
+// versions contain only the PID.
+func (l *Lockfile) readHolder() (*Holder, error) {
 	data, err := os.ReadFile(l.path)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
 
-	pidStr := strings.TrimSpace(string(data))
-	pid, err := strconv.Atoi(pidStr)
+	pidStr, owner, _ := strings.Cut(strings.TrimSpace(string(data)), "\n")
+	pid, err := strconv.Atoi(strings.TrimSpace(pidStr))
 	if err != nil {
-		return 0, fmt.Errorf("invalid PID in lock file: %w", err)
+		return nil, fmt.Errorf("invalid PID in lock file: %w", err)
 	}
 
-	return pid, nil
+	return &Holder{PID: pid, Owner: strings.TrimSpace(owner)}, nil
 }
 
 // isProcessRunning checks if a process with the given PID is running
@@ -0,0 +1,144 @@
+package lockfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewLockPath(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		instanceID string
+		want       string
+	}{
+		{"default", ".ipfs_publisher.lock"},
+		{"music", ".ipfs_publisher-music.lock"},
+	}
+
+	for _, tt := range tests {
+		l, err := New(dir, tt.instanceID)
+		if err != nil {
+			t.Fatalf("New(%q) failed: %v", tt.instanceID, err)
+		}
+		if got := l.GetPath(); got != filepath.Join(dir, tt.want) {
+			t.Errorf("New(%q) path = %s, want %s", tt.instanceID, got, tt.want)
+		}
+	}
+}
+
+func TestNewRejectsEmptyInstanceID(t *testing.T) {
+	if _, err := New(t.TempDir(), ""); err == nil {
+		t.Fatal("expected error for empty instance ID")
+	}
+}
+
+func TestInstancesLockIndependently(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := New(dir, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Acquire("publisher"); err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	defer first.Release()
+
+	same, err := New(dir, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := same.Acquire("publisher"); err == nil {
+		same.Release()
+		t.Fatal("expected second lock of the same instance to fail")
+	}
+
+	other, err := New(dir, "music")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := other.Acquire("publisher"); err != nil {
+		t.Fatalf("Acquire of another instance failed: %v", err)
+	}
+	other.Release()
+}
+
+func TestHolder(t *testing.T) {
+	dir := t.TempDir()
+
+	lock, err := New(dir, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder, err := lock.Holder(); err != nil || holder != nil {
+		t.Fatalf("Holder of a free lock = %+v, %v, want nil", holder, err)
+	}
+
+	if err := lock.Acquire("import"); err != nil {
+		t.Fatal(err)
+	}
+	holder, err := lock.Holder()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder == nil || holder.PID != os.Getpid() || holder.Owner != "import" {
+		t.Errorf("Holder = %+v, want this process as import", holder)
+	}
+
+	same, err := New(dir, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = same.Acquire("publisher")
+	var held *HeldError
+	if !errors.As(err, &held) || held.Owner != "import" || held.PID != os.Getpid() {
+		t.Errorf("Acquire of a held lock = %v, want HeldError naming import", err)
+	}
+
+	if err := lock.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if holder, err := lock.Holder(); err != nil || holder != nil {
+		t.Errorf("Holder after release = %+v, %v, want nil", holder, err)
+	}
+}
+
+func TestLegacyAndStaleLockFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	lock, err := New(dir, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Older versions write only the PID
+	if err := os.WriteFile(lock.GetPath(), []byte(fmt.Sprintf("%d\n", os.Getpid())), 0644); err != nil {
+		t.Fatal(err)
+	}
+	holder, err := lock.Holder()
+	if err != nil || holder == nil || holder.Owner != "" {
+		t.Errorf("Holder of a legacy lock = %+v, %v, want this process without owner", holder, err)
+	}
+
+	// A lock left behind by a process that exited is taken over
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("cannot run a short-lived process: %v", err)
+	}
+	stale := fmt.Sprintf("%d\npublisher\n", cmd.Process.Pid)
+	if err := os.WriteFile(lock.GetPath(), []byte(stale), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if holder, err := lock.Holder(); err != nil || holder != nil {
+		t.Errorf("Holder of a stale lock = %+v, %v, want nil", holder, err)
+	}
+	if err := lock.Acquire("--repair"); err != nil {
+		t.Fatalf("Acquire over a stale lock failed: %v", err)
+	}
+	lock.Release()
+}
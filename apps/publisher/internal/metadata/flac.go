@@ -0,0 +1,67 @@
+package metadata
+
+import (
+	"io"
+)
+
+// FLAC metadata block types
+const (
+	flacStreamInfo    = 0
+	flacVorbisComment = 4
+)
+
+// maxCommentSize bounds a Vorbis comment block read into memory; larger blocks
+// embed artwork and are skipped
+const maxCommentSize = 1 << 20
+
+// readFLAC reads the stream info and Vorbis comments of a FLAC file
+func readFLAC(r *io.SectionReader) (Metadata, error) {
+	var md Metadata
+
+	// An ID3v2 tag some taggers write in front of the stream is skipped
+	off, _, err := readID3v2(r, &Metadata{})
+	if err != nil {
+		return Metadata{}, err
+	}
+	magic, err := readAt(r, off, 4)
+	if err != nil || string(magic) != "fLaC" {
+		return Metadata{}, errMalformed
+	}
+	off += 4
+
+	for {
+		header, err := readAt(r, off, 4)
+		if err != nil {
+			return Metadata{}, errMalformed
+		}
+		last, blockType := header[0]&0x80 != 0, header[0]&0x7f
+		size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		off += 4
+
+		switch {
+		case blockType == flacStreamInfo && size >= 18:
+			info, err := readAt(r, off, 18)
+			if err != nil {
+				return Metadata{}, errMalformed
+			}
+			rate := uint64(info[10])<<12 | uint64(info[11])<<4 | uint64(info[12])>>4
+			samples := uint64(info[13]&0x0f)<<32 | uint64(info[14])<<24 | uint64(info[15])<<16 | uint64(info[16])<<8 | uint64(info[17])
+			if rate > 0 {
+				md.Duration = float64(samples) / float64(rate)
+			}
+		case blockType == flacVorbisComment && size <= maxCommentSize:
+			comments, err := readAt(r, off, size)
+			if err != nil {
+				return Metadata{}, errMalformed
+			}
+			if err := readVorbisComments(comments, &md); err != nil {
+				return Metadata{}, err
+			}
+		}
+
+		off += int64(size)
+		if last {
+			return md, nil
+		}
+	}
+}
@@ -0,0 +1,271 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Matroska element IDs, with their length marker bits
+const (
+	mkvEBML          = 0x1a45dfa3
+	mkvSegment       = 0x18538067
+	mkvInfo          = 0x1549a966
+	mkvTimecodeScale = 0x2ad7b1
+	mkvDuration      = 0x4489
+	mkvTitle         = 0x7ba9
+	mkvTracks        = 0x1654ae6b
+	mkvTrackEntry    = 0xae
+	mkvVideo         = 0xe0
+	mkvPixelWidth    = 0xb0
+	mkvPixelHeight   = 0xba
+	mkvTags          = 0x1254c367
+	mkvTag           = 0x7373
+	mkvSimpleTag     = 0x67c8
+	mkvTagName       = 0x45a3
+	mkvTagString     = 0x4487
+)
+
+// mkvDefaultScale is the default timecode scale: durations count milliseconds
+const mkvDefaultScale = 1000000
+
+// unknownSize marks an element of unknown size, e.g. a live stream segment
+const unknownSize = -1
+
+// mkvElement is an EBML element: its ID and the offset and size of its content
+type mkvElement struct {
+	id   uint32
+	off  int64
+	size int64 // unknownSize if not known
+}
+
+// readMKVElement reads the element header at off
+func readMKVElement(r *io.SectionReader, off int64) (mkvElement, error) {
+	b, err := readAt(r, off, int(min(12, r.Size()-off)))
+	if err != nil || len(b) == 0 {
+		return mkvElement{}, errMalformed
+	}
+
+	idLen := vintLength(b[0])
+	if idLen == 0 || idLen > 4 || idLen >= len(b) {
+		return mkvElement{}, errMalformed
+	}
+	var id uint32
+	for _, c := range b[:idLen] {
+		id = id<<8 | uint32(c)
+	}
+
+	sizeLen := vintLength(b[idLen])
+	if sizeLen == 0 || idLen+sizeLen > len(b) {
+		return mkvElement{}, errMalformed
+	}
+	size := uint64(b[idLen]) & (0xff >> sizeLen)
+	allOnes := size == 0xff>>sizeLen
+	for _, c := range b[idLen+1 : idLen+sizeLen] {
+		size = size<<8 | uint64(c)
+		allOnes = allOnes && c == 0xff
+	}
+
+	e := mkvElement{id: id, off: off + int64(idLen+sizeLen), size: int64(size)}
+	if allOnes {
+		e.size = unknownSize
+	} else if size > uint64(r.Size()-e.off) {
+		return mkvElement{}, errMalformed
+	}
+	return e, nil
+}
+
+// vintLength returns the length of the variable-length integer starting with b,
+// or 0 if b does not start one
+func vintLength(b byte) int {
+	for n := 1; n <= 8; n++ {
+		if b&(0x80>>(n-1)) != 0 {
+			return n
+		}
+	}
+	return 0
+}
+
+// mkvChildren calls fn for each child of the element between off and end,
+// stopping early if fn returns false. A child of unknown size ends the walk
+// after fn.
+func mkvChildren(r *io.SectionReader, off, end int64, fn func(mkvElement) bool) error {
+	for off < end {
+		e, err := readMKVElement(r, off)
+		if err != nil {
+			return err
+		}
+		if !fn(e) || e.size == unknownSize {
+			return nil
+		}
+		off = e.off + e.size
+	}
+	return nil
+}
+
+// readMatroska reads the duration, the frame size of the first video track and
+// the title, artist and album tags of a Matroska or WebM file. Clusters are
+// skipped by their size; a live stream of clusters with unknown size ends the
+// search.
+func readMatroska(r *io.SectionReader) (Metadata, error) {
+	header, err := readMKVElement(r, 0)
+	if err != nil || header.id != mkvEBML || header.size == unknownSize {
+		return Metadata{}, errMalformed
+	}
+	segment, err := readMKVElement(r, header.off+header.size)
+	if err != nil || segment.id != mkvSegment {
+		return Metadata{}, errMalformed
+	}
+	end := r.Size()
+	if segment.size != unknownSize {
+		end = segment.off + segment.size
+	}
+
+	var md Metadata
+	var title string
+	var walkErr error
+	err = mkvChildren(r, segment.off, end, func(e mkvElement) bool {
+		switch {
+		case e.size == unknownSize:
+			return false
+		case e.id == mkvInfo:
+			walkErr = readMKVInfo(r, e, &md, &title)
+		case e.id == mkvTracks:
+			walkErr = readMKVTracks(r, e, &md)
+		case e.id == mkvTags:
+			walkErr = readMKVTags(r, e, &md)
+		}
+		return walkErr == nil
+	})
+	if err == nil {
+		err = walkErr
+	}
+	if err != nil {
+		return Metadata{}, err
+	}
+	if md.Title == "" {
+		md.Title = title
+	}
+	return md, nil
+}
+
+// readMKVInfo reads the duration and title of the segment info
+func readMKVInfo(r *io.SectionReader, info mkvElement, md *Metadata, title *string) error {
+	scale := uint64(mkvDefaultScale)
+	var duration float64
+	err := mkvChildren(r, info.off, info.off+info.size, func(e mkvElement) bool {
+		switch e.id {
+		case mkvTimecodeScale:
+			if v, ok := mkvUint(r, e); ok && v > 0 {
+				scale = v
+			}
+		case mkvDuration:
+			duration = mkvFloat(r, e)
+		case mkvTitle:
+			*title = mkvString(r, e)
+		}
+		return true
+	})
+	md.Duration = duration * float64(scale) / 1e9
+	return err
+}
+
+// readMKVTracks reads the frame size of the first video track
+func readMKVTracks(r *io.SectionReader, tracks mkvElement, md *Metadata) error {
+	return mkvChildren(r, tracks.off, tracks.off+tracks.size, func(entry mkvElement) bool {
+		if entry.id != mkvTrackEntry || entry.size == unknownSize {
+			return true
+		}
+		var err error
+		_ = mkvChildren(r, entry.off, entry.off+entry.size, func(e mkvElement) bool {
+			if e.id != mkvVideo || e.size == unknownSize {
+				return true
+			}
+			err = mkvChildren(r, e.off, e.off+e.size, func(v mkvElement) bool {
+				switch v.id {
+				case mkvPixelWidth:
+					if w, ok := mkvUint(r, v); ok {
+						md.Width = int(w)
+					}
+				case mkvPixelHeight:
+					if h, ok := mkvUint(r, v); ok {
+						md.Height = int(h)
+					}
+				}
+				return true
+			})
+			return false
+		})
+		return err == nil && md.Width == 0
+	})
+}
+
+// readMKVTags reads the TITLE, ARTIST and ALBUM simple tags
+func readMKVTags(r *io.SectionReader, tags mkvElement, md *Metadata) error {
+	return mkvChildren(r, tags.off, tags.off+tags.size, func(tag mkvElement) bool {
+		if tag.id != mkvTag || tag.size == unknownSize {
+			return true
+		}
+		_ = mkvChildren(r, tag.off, tag.off+tag.size, func(simple mkvElement) bool {
+			if simple.id != mkvSimpleTag || simple.size == unknownSize {
+				return true
+			}
+			var name, value string
+			_ = mkvChildren(r, simple.off, simple.off+simple.size, func(e mkvElement) bool {
+				switch e.id {
+				case mkvTagName:
+					name = mkvString(r, e)
+				case mkvTagString:
+					value = mkvString(r, e)
+				}
+				return true
+			})
+			setTag(md, name, value)
+			return true
+		})
+		return true
+	})
+}
+
+// mkvUint decodes an unsigned integer element
+func mkvUint(r *io.SectionReader, e mkvElement) (uint64, bool) {
+	if e.size < 1 || e.size > 8 {
+		return 0, false
+	}
+	b, err := readAt(r, e.off, int(e.size))
+	if err != nil {
+		return 0, false
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, true
+}
+
+// mkvFloat decodes a 4- or 8-byte float element
+func mkvFloat(r *io.SectionReader, e mkvElement) float64 {
+	if e.size != 4 && e.size != 8 {
+		return 0
+	}
+	b, err := readAt(r, e.off, int(e.size))
+	if err != nil {
+		return 0
+	}
+	if e.size == 4 {
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	}
+	return math.Float64frombits(binary.BigEndian.Uint64(b))
+}
+
+// mkvString decodes a string element, cut at maxTagSize
+func mkvString(r *io.SectionReader, e mkvElement) string {
+	if e.size == unknownSize {
+		return ""
+	}
+	b, err := readAt(r, e.off, int(min(e.size, maxTagSize)))
+	if err != nil {
+		return ""
+	}
+	return string(beforeNUL(b))
+}
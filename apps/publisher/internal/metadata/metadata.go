@@ -0,0 +1,200 @@
+// Package metadata reads basic tags and stream properties of media files for
+// index records: title, artist and album of audio files and the duration and
+// frame size of their container. Only the headers are read, never the media
+// data, and no external tools are needed.
+package metadata
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/atregu/ipfs-common/extensions"
+)
+
+// Metadata is the nested metadata object of an index record. Fields a file does
+// not carry are left zero and omitted.
+type Metadata struct {
+	Title    string  `json:"title,omitempty"`
+	Artist   string  `json:"artist,omitempty"`
+	Album    string  `json:"album,omitempty"`
+	Duration float64 `json:"duration,omitempty"` // Seconds, rounded to milliseconds
+	Width    int     `json:"width,omitempty"`    // Video frame size in pixels
+	Height   int     `json:"height,omitempty"`
+}
+
+// Hash returns the SHA-256 (hex) of the metadata as written to an index
+// record, or "" if there is none
+func (m Metadata) Hash() string {
+	if m == (Metadata{}) {
+		return ""
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// maxTagSize bounds a tag value read into memory
+const maxTagSize = 4 << 10
+
+// maxTextLength bounds each text field; longer tags are cut at a character boundary
+const maxTextLength = 256
+
+// errMalformed reports a file whose headers do not parse
+var errMalformed = errors.New("malformed header")
+
+// readers reads the metadata of the formats by extension
+var readers = map[string]func(r *io.SectionReader) (Metadata, error){
+	"mp3":  readMP3,
+	"flac": readFLAC,
+	"ogg":  readOgg,
+	"oga":  readOgg,
+	"opus": readOgg,
+	"m4a":  readMP4,
+	"m4b":  readMP4,
+	"mp4":  readMP4,
+	"m4v":  readMP4,
+	"mov":  readMP4,
+	"mkv":  readMatroska,
+	"mka":  readMatroska,
+	"webm": readMatroska,
+}
+
+// Supported reports whether Extract reads files with the extension ext
+func Supported(ext string) bool {
+	_, ok := readers[extensions.Normalize(ext)]
+	return ok
+}
+
+// Extract reads the metadata of the media file at path, by its extension. Files
+// of other types have none.
+func Extract(path string) (Metadata, error) {
+	read, ok := readers[extensions.Of(path)]
+	if !ok {
+		return Metadata{}, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Metadata{}, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return Metadata{}, fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+
+	md, err := read(io.NewSectionReader(f, 0, info.Size()))
+	if err != nil {
+		return Metadata{}, fmt.Errorf("failed to read metadata of %s: %w", path, err)
+	}
+	md.Title, md.Artist, md.Album = clean(md.Title), clean(md.Artist), clean(md.Album)
+	if md.Duration < 0 || math.IsNaN(md.Duration) || math.IsInf(md.Duration, 0) {
+		md.Duration = 0
+	}
+	md.Duration = math.Round(md.Duration*1000) / 1000
+	return md, nil
+}
+
+// clean trims s, drops invalid UTF-8 and control characters and bounds its length
+func clean(s string) string {
+	s = strings.ToValidUTF8(s, "")
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.TrimSpace(s)
+	if len(s) > maxTextLength {
+		cut := maxTextLength
+		for cut > 0 && !utf8.RuneStart(s[cut]) {
+			cut--
+		}
+		s = strings.TrimSpace(s[:cut])
+	}
+	return s
+}
+
+// setTag sets the field of md named by a Vorbis comment or Matroska tag name.
+// Repeated artists are joined; other fields keep their first value.
+func setTag(md *Metadata, name, value string) {
+	if value == "" {
+		return
+	}
+	switch strings.ToUpper(name) {
+	case "TITLE":
+		if md.Title == "" {
+			md.Title = value
+		}
+	case "ARTIST":
+		if md.Artist == "" {
+			md.Artist = value
+		} else {
+			md.Artist += ", " + value
+		}
+	case "ALBUM":
+		if md.Album == "" {
+			md.Album = value
+		}
+	}
+}
+
+// readVorbisComments sets the tags of a Vorbis comment block, as used by FLAC,
+// Ogg Vorbis and Opus
+func readVorbisComments(b []byte, md *Metadata) error {
+	next := func() ([]byte, bool) {
+		if len(b) < 4 {
+			return nil, false
+		}
+		n := uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24
+		b = b[4:]
+		if n > uint64(len(b)) {
+			return nil, false
+		}
+		field := b[:n]
+		b = b[n:]
+		return field, true
+	}
+
+	if _, ok := next(); !ok { // Vendor string
+		return errMalformed
+	}
+	if len(b) < 4 {
+		return errMalformed
+	}
+	count := uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+	b = b[4:]
+	for i := uint32(0); i < count; i++ {
+		comment, ok := next()
+		if !ok {
+			return errMalformed
+		}
+		if name, value, ok := strings.Cut(string(comment), "="); ok {
+			setTag(md, name, value)
+		}
+	}
+	return nil
+}
+
+// readAt reads n bytes at off, failing on a short read
+func readAt(r *io.SectionReader, off int64, n int) ([]byte, error) {
+	if off < 0 || n < 0 || off+int64(n) > r.Size() {
+		return nil, errMalformed
+	}
+	b := make([]byte, n)
+	if _, err := r.ReadAt(b, off); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,249 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf16"
+)
+
+// writeFile writes data to name in a temporary directory and returns its path
+func writeFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// id3Frame encodes an ID3v2.3 frame
+func id3Frame(id string, data []byte) []byte {
+	frame := append([]byte(id), 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(frame[4:], uint32(len(data)))
+	return append(frame, data...)
+}
+
+// id3Tag encodes an ID3v2.3 tag holding frames
+func id3Tag(frames ...[]byte) []byte {
+	body := bytes.Join(frames, nil)
+	n := len(body)
+	return append([]byte{'I', 'D', '3', 3, 0, 0, byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}, body...)
+}
+
+// utf16Frame encodes text as UTF-16 with a little-endian byte order mark
+func utf16Frame(text string) []byte {
+	b := []byte{1, 0xff, 0xfe}
+	for _, unit := range utf16.Encode([]rune(text)) {
+		b = binary.LittleEndian.AppendUint16(b, unit)
+	}
+	return b
+}
+
+// mpegFrames returns n MPEG-1 Layer III frames of 128 kbit/s at 44.1 kHz, the
+// first holding a Xing header with frames if it is above zero
+func mpegFrames(n int, frames uint32) []byte {
+	const size = 417 // 144 * 128000 / 44100
+	var b []byte
+	for i := 0; i < n; i++ {
+		frame := make([]byte, size)
+		copy(frame, []byte{0xff, 0xfb, 0x90, 0x00})
+		if i == 0 && frames > 0 {
+			copy(frame[36:], "Xing")
+			binary.BigEndian.PutUint32(frame[40:], 1)
+			binary.BigEndian.PutUint32(frame[44:], frames)
+		}
+		b = append(b, frame...)
+	}
+	return b
+}
+
+// vorbisComments encodes a Vorbis comment block
+func vorbisComments(comments ...string) []byte {
+	b := binary.LittleEndian.AppendUint32(nil, 4)
+	b = append(b, "test"...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(comments)))
+	for _, c := range comments {
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(c)))
+		b = append(b, c...)
+	}
+	return b
+}
+
+// oggPageBytes encodes a single-packet Ogg page
+func oggPageBytes(serial uint32, granule int64, packet []byte) []byte {
+	b := append([]byte("OggS"), 0, 0)
+	b = binary.LittleEndian.AppendUint64(b, uint64(granule))
+	b = binary.LittleEndian.AppendUint32(b, serial)
+	b = append(b, make([]byte, 8)...) // Sequence number and checksum
+	var segments []byte
+	n := len(packet)
+	for ; n >= 255; n -= 255 {
+		segments = append(segments, 255)
+	}
+	segments = append(segments, byte(n))
+	b = append(b, byte(len(segments)))
+	b = append(b, segments...)
+	return append(b, packet...)
+}
+
+// box encodes an MP4 box
+func box(kind string, content ...[]byte) []byte {
+	body := bytes.Join(content, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, kind...), body...)
+}
+
+// ebml encodes a Matroska element with a one-byte (or eight-byte unknown) size
+func ebml(id uint32, content ...[]byte) []byte {
+	var b []byte
+	for shift := 24; shift >= 0; shift -= 8 {
+		if c := byte(id >> shift); c != 0 || len(b) > 0 {
+			b = append(b, c)
+		}
+	}
+	body := bytes.Join(content, nil)
+	b = append(b, 0x01, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(b[len(b)-8:], uint64(len(body))|1<<56)
+	return append(b, body...)
+}
+
+// f64 encodes a big-endian float
+func f64(v float64) []byte {
+	return binary.BigEndian.AppendUint64(nil, math.Float64bits(v))
+}
+
+func TestExtract(t *testing.T) {
+	id3 := id3Tag(
+		id3Frame("TIT2", append([]byte{3}, "Intro"...)),
+		id3Frame("TPE1", utf16Frame("Björk")),
+		id3Frame("TALB", append([]byte{0}, "Debut\x00"...)),
+	)
+
+	streamInfo := make([]byte, 34)
+	// 44100 Hz, 2 channels, 16 bits, 441000 samples
+	copy(streamInfo[10:], []byte{0x0a, 0xc4, 0x42, 0xf0, 0x00, 0x06, 0xba, 0xa8})
+	comments := vorbisComments("TITLE=Intro", "artist=A", "ARTIST=B", "ALBUM=Debut")
+	flac := append([]byte("fLaC"), 0, 0, 0, 34)
+	flac = append(flac, streamInfo...)
+	flac = append(flac, 0x84, 0, 0, byte(len(comments)))
+	flac = append(flac, comments...)
+
+	vorbisIdent := append([]byte("\x01vorbis"), make([]byte, 23)...)
+	binary.LittleEndian.PutUint32(vorbisIdent[12:], 44100)
+	ogg := oggPageBytes(7, 0, vorbisIdent)
+	ogg = append(ogg, oggPageBytes(7, 0, append([]byte("\x03vorbis"), vorbisComments("TITLE=Long", "ARTIST="+string(bytes.Repeat([]byte("x"), 300)))...))...)
+	ogg = append(ogg, oggPageBytes(7, 44100*3, []byte{0})...)
+	ogg = append(ogg, oggPageBytes(9, 1<<40, []byte{0})...) // Another stream
+
+	opusHead := append([]byte("OpusHead"), 1, 2, 0x38, 0x01, 0, 0, 0, 0, 0, 0, 0)
+	opus := oggPageBytes(1, 0, opusHead)
+	opus = append(opus, oggPageBytes(1, 0, append([]byte("OpusTags"), vorbisComments("title=Song")...))...)
+	opus = append(opus, oggPageBytes(1, 48000*2+312, []byte{0})...)
+
+	mvhd := make([]byte, 100)
+	binary.BigEndian.PutUint32(mvhd[12:], 1000)
+	binary.BigEndian.PutUint32(mvhd[16:], 90500)
+	tkhd := func(width, height uint32) []byte {
+		b := make([]byte, 84)
+		binary.BigEndian.PutUint32(b[76:], width<<16)
+		binary.BigEndian.PutUint32(b[80:], height<<16)
+		return b
+	}
+	ilst := box("ilst",
+		box("\xa9nam", box("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, []byte("Trailer"))),
+		box("\xa9ART", box("data", []byte{0, 0, 0, 1, 0, 0, 0, 0}, []byte("Studio"))),
+	)
+	mp4 := append(box("ftyp", []byte("isom")),
+		box("moov",
+			box("mvhd", mvhd),
+			box("trak", box("tkhd", tkhd(0, 0))),
+			box("trak", box("tkhd", tkhd(1920, 1080)), box("mdia")),
+			box("udta", box("meta", []byte{0, 0, 0, 0}, box("hdlr", make([]byte, 25)), ilst)),
+		)...)
+	mp4 = append(mp4, box("mdat", make([]byte, 64))...)
+
+	mkv := append(ebml(mkvEBML, ebml(0x4282, []byte("webm"))),
+		ebml(mkvSegment,
+			ebml(mkvInfo, ebml(mkvTimecodeScale, []byte{0x0f, 0x42, 0x40}), ebml(mkvDuration, f64(12345)), ebml(mkvTitle, []byte("Clip\x00\x00"))),
+			ebml(mkvTracks,
+				ebml(mkvTrackEntry, ebml(0xd7, []byte{1})),
+				ebml(mkvTrackEntry, ebml(mkvVideo, ebml(mkvPixelWidth, []byte{0x05, 0x00}), ebml(mkvPixelHeight, []byte{0x02, 0xd0}))),
+			),
+			ebml(0x1f43b675, make([]byte, 32)), // Cluster
+			ebml(mkvTags, ebml(mkvTag, ebml(mkvSimpleTag, ebml(mkvTagName, []byte("ARTIST")), ebml(mkvTagString, []byte("Director"))))),
+		)...)
+
+	id3v1 := make([]byte, 128)
+	copy(id3v1, "TAG")
+	copy(id3v1[3:], "Old Title")
+	copy(id3v1[33:], "Old Artist")
+
+	tests := []struct {
+		name string
+		data []byte
+		want Metadata
+	}{
+		{"intro.mp3", append(id3, mpegFrames(10, 1000)...), Metadata{Title: "Intro", Artist: "Björk", Album: "Debut", Duration: 26.122}},
+		{"cbr.MP3", append(mpegFrames(10, 0), id3v1...), Metadata{Title: "Old Title", Artist: "Old Artist", Duration: 0.261}},
+		{"tlen.mp3", append(id3Tag(id3Frame("TLEN", append([]byte{0}, "61500"...))), mpegFrames(3, 0)...), Metadata{Duration: 61.5}},
+		{"intro.flac", flac, Metadata{Title: "Intro", Artist: "A, B", Album: "Debut", Duration: 10}},
+		{"long.ogg", ogg, Metadata{Title: "Long", Artist: string(bytes.Repeat([]byte("x"), maxTextLength)), Duration: 3}},
+		{"song.opus", opus, Metadata{Title: "Song", Duration: 2}},
+		{"trailer.mp4", mp4, Metadata{Title: "Trailer", Artist: "Studio", Duration: 90.5, Width: 1920, Height: 1080}},
+		{"clip.mkv", mkv, Metadata{Title: "Clip", Artist: "Director", Duration: 12.345, Width: 1280, Height: 720}},
+		{"notes.txt", []byte("not media"), Metadata{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Extract(writeFile(t, tt.name, tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Extract = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtractMalformed(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"truncated.flac": []byte("fLaC\x00\x00\x00\x22"),
+		"random.mp4":     []byte("\x00\x00\x10\x00moov"),
+		"text.mkv":       []byte("hello world"),
+		"empty.ogg":      nil,
+	} {
+		if md, err := Extract(writeFile(t, name, data)); err == nil {
+			t.Errorf("%s: Extract = %+v, want an error", name, md)
+		}
+	}
+
+	// Files without tags or frames have no metadata but are not malformed
+	if md, err := Extract(writeFile(t, "silence.mp3", make([]byte, 1000))); err != nil || md != (Metadata{}) {
+		t.Errorf("Extract of an MP3 without frames = %+v, %v", md, err)
+	}
+}
+
+func TestSupported(t *testing.T) {
+	for ext, want := range map[string]bool{"mp3": true, ".FLAC": true, "webm": true, "jpg": false, "": false} {
+		if got := Supported(ext); got != want {
+			t.Errorf("Supported(%q) = %v, want %v", ext, got, want)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	if h := (Metadata{}).Hash(); h != "" {
+		t.Errorf("hash of no metadata = %q, want none", h)
+	}
+	md := Metadata{Title: "Intro", Album: "Album"}
+	fixed := md
+	fixed.Album = "Album (Remastered)"
+	if md.Hash() == "" || md.Hash() != md.Hash() || md.Hash() == fixed.Hash() {
+		t.Errorf("hashes %q and %q of metadata differing in the album", md.Hash(), fixed.Hash())
+	}
+}
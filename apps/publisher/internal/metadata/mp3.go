@@ -0,0 +1,343 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// id3v1Size is the size of the ID3v1 tag at the end of a file
+const id3v1Size = 128
+
+// mpegSearchLimit bounds how far past the ID3v2 tag the first MPEG frame is searched
+const mpegSearchLimit = 64 << 10
+
+// maxID3Size bounds the ID3v2 tag read into memory; larger tags hold artwork
+// and are read up to this size only
+const maxID3Size = 1 << 20
+
+// readMP3 reads the ID3v2 tag, or else the ID3v1 tag, and the duration of an MP3 file
+func readMP3(r *io.SectionReader) (Metadata, error) {
+	var md Metadata
+	audioStart, tagLength, err := readID3v2(r, &md)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	audioEnd := r.Size()
+	if tail, err := readAt(r, r.Size()-id3v1Size, id3v1Size); err == nil && string(tail[:3]) == "TAG" {
+		audioEnd -= id3v1Size
+		if md.Title == "" && md.Artist == "" && md.Album == "" {
+			md.Title = latin1(beforeNUL(tail[3:33]))
+			md.Artist = latin1(beforeNUL(tail[33:63]))
+			md.Album = latin1(beforeNUL(tail[63:93]))
+		}
+	}
+
+	if tagLength > 0 {
+		md.Duration = tagLength
+	} else {
+		md.Duration = mpegDuration(r, audioStart, audioEnd)
+	}
+	return md, nil
+}
+
+// readID3v2 sets the tags of the ID3v2 tag at the start of r, if there is one.
+// It returns the offset of the audio after the tag and the length in seconds
+// recorded in a TLEN frame, if any.
+func readID3v2(r *io.SectionReader, md *Metadata) (int64, float64, error) {
+	header, err := readAt(r, 0, 10)
+	if err != nil || string(header[:3]) != "ID3" {
+		return 0, 0, nil
+	}
+	major, flags := header[3], header[5]
+	size := int64(syncsafe(header[6:10]))
+	end := 10 + size
+	if flags&0x10 != 0 { // Footer
+		end += 10
+	}
+	if major < 2 || major > 4 {
+		return end, 0, nil
+	}
+
+	body, err := readAt(r, 10, int(min(size, maxID3Size, r.Size()-10)))
+	if err != nil {
+		return 0, 0, errMalformed
+	}
+	if flags&0x40 != 0 && major >= 3 { // Extended header
+		if len(body) < 4 {
+			return 0, 0, errMalformed
+		}
+		skip := int(binary.BigEndian.Uint32(body))
+		if major == 3 {
+			skip += 4 // The size excludes itself in ID3v2.3
+		} else {
+			skip = int(syncsafe(body[:4]))
+		}
+		if skip > len(body) {
+			return 0, 0, errMalformed
+		}
+		body = body[skip:]
+	}
+
+	idLen, headerLen := 4, 10
+	if major == 2 {
+		idLen, headerLen = 3, 6
+	}
+	var length float64
+	for len(body) >= headerLen && body[0] != 0 {
+		id := string(body[:idLen])
+		var frameSize int
+		var frameFlags uint16
+		switch major {
+		case 2:
+			frameSize = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			frameSize = int(binary.BigEndian.Uint32(body[4:8]))
+			frameFlags = binary.BigEndian.Uint16(body[8:10])
+		case 4:
+			frameSize = int(syncsafe(body[4:8]))
+			frameFlags = binary.BigEndian.Uint16(body[8:10])
+		}
+		if frameSize < 0 || frameSize > len(body)-headerLen {
+			break // Cut off at maxID3Size or malformed: keep what was read
+		}
+		data := body[headerLen : headerLen+frameSize]
+		body = body[headerLen+frameSize:]
+
+		// Compressed and encrypted frames are skipped; a data length indicator is dropped
+		switch {
+		case major == 3 && frameFlags&0x00c0 != 0, major == 4 && frameFlags&0x000c != 0:
+			continue
+		case major == 4 && frameFlags&0x0001 != 0:
+			if len(data) < 4 {
+				continue
+			}
+			data = data[4:]
+		}
+
+		switch id {
+		case "TIT2", "TT2":
+			md.Title = id3Text(data)
+		case "TPE1", "TP1":
+			md.Artist = id3Text(data)
+		case "TALB", "TAL":
+			md.Album = id3Text(data)
+		case "TLEN", "TLE":
+			if ms, err := strconv.ParseFloat(id3Text(data), 64); err == nil && ms > 0 {
+				length = ms / 1000
+			}
+		}
+	}
+	return end, length, nil
+}
+
+// syncsafe decodes a 28-bit ID3v2 syncsafe integer
+func syncsafe(b []byte) uint32 {
+	return uint32(b[0]&0x7f)<<21 | uint32(b[1]&0x7f)<<14 | uint32(b[2]&0x7f)<<7 | uint32(b[3]&0x7f)
+}
+
+// id3Text decodes a text frame. Multiple values, separated by NUL in ID3v2.4,
+// are joined with commas.
+func id3Text(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	encoding, data := data[0], data[1:]
+
+	var text string
+	switch encoding {
+	case 0:
+		text = latin1(data)
+	case 1, 2:
+		text = utf16Text(data, encoding == 2)
+	case 3:
+		text = string(data)
+	default:
+		return ""
+	}
+
+	var values []string
+	for _, value := range strings.Split(text, "\x00") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return strings.Join(values, ", ")
+}
+
+// utf16Text decodes UTF-16 text, with a byte order mark unless bigEndian is set.
+// Each value of a multi-valued frame may start with its own mark.
+func utf16Text(data []byte, bigEndian bool) string {
+	units := make([]uint16, 0, len(data)/2)
+	order := binary.ByteOrder(binary.LittleEndian)
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	for i := 0; i+1 < len(data); i += 2 {
+		unit := order.Uint16(data[i:])
+		switch {
+		case !bigEndian && unit == 0xfeff:
+			continue
+		case !bigEndian && unit == 0xfffe:
+			order = binary.BigEndian
+			continue
+		}
+		units = append(units, unit)
+		if unit == 0 && !bigEndian {
+			order = binary.LittleEndian
+		}
+	}
+	return string(utf16.Decode(units))
+}
+
+// latin1 decodes ISO-8859-1 text
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+// beforeNUL returns b up to the first NUL, the padding of fixed-size fields
+func beforeNUL(b []byte) []byte {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return b[:i]
+	}
+	return b
+}
+
+// MPEG audio bitrates in kbit/s by version (MPEG-1 or 2/2.5), layer and index
+var mpegBitrates = [2][3][15]int{
+	{ // MPEG-1
+		{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+		{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	},
+	{ // MPEG-2 and 2.5
+		{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+		{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	},
+}
+
+// mpegFrame is a parsed MPEG audio frame header
+type mpegFrame struct {
+	mpeg1      bool
+	layer      int // 1, 2 or 3
+	bitrate    int // bit/s
+	sampleRate int
+	mono       bool
+	length     int // Bytes including the header
+}
+
+// samples returns the number of samples per channel in the frame
+func (f *mpegFrame) samples() int {
+	switch {
+	case f.layer == 1:
+		return 384
+	case f.layer == 3 && !f.mpeg1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+// parseMPEGFrame parses the 4-byte frame header h
+func parseMPEGFrame(h []byte) (mpegFrame, bool) {
+	if h[0] != 0xff || h[1]&0xe0 != 0xe0 {
+		return mpegFrame{}, false
+	}
+	version := h[1] >> 3 & 3
+	layerBits := h[1] >> 1 & 3
+	bitrateIndex := h[2] >> 4
+	rateIndex := h[2] >> 2 & 3
+	if version == 1 || layerBits == 0 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return mpegFrame{}, false
+	}
+
+	f := mpegFrame{mpeg1: version == 3, layer: int(4 - layerBits), mono: h[3]>>6 == 3}
+	table := 1
+	if f.mpeg1 {
+		table = 0
+	}
+	f.bitrate = mpegBitrates[table][f.layer-1][bitrateIndex] * 1000
+	f.sampleRate = [3]int{44100, 48000, 32000}[rateIndex]
+	switch version {
+	case 2:
+		f.sampleRate /= 2
+	case 0:
+		f.sampleRate /= 4
+	}
+
+	padding := int(h[2] >> 1 & 1)
+	switch {
+	case f.layer == 1:
+		f.length = (12*f.bitrate/f.sampleRate + padding) * 4
+	case f.layer == 3 && !f.mpeg1:
+		f.length = 72*f.bitrate/f.sampleRate + padding
+	default:
+		f.length = 144*f.bitrate/f.sampleRate + padding
+	}
+	return f, true
+}
+
+// mpegDuration returns the duration of the MPEG audio between start and end:
+// from the frame count of a Xing, Info or VBRI header in the first frame, or
+// else estimated from the bitrate of the first frame. It returns 0 if no frame
+// is found.
+func mpegDuration(r *io.SectionReader, start, end int64) float64 {
+	buf, err := readAt(r, start, int(min(mpegSearchLimit, end-start)))
+	if err != nil {
+		return 0
+	}
+
+	for i := 0; i+4 <= len(buf); i++ {
+		frame, ok := parseMPEGFrame(buf[i : i+4])
+		if !ok {
+			continue
+		}
+		// A second frame right after the first rules out a stray sync pattern
+		if next := i + frame.length; next+4 <= len(buf) {
+			if _, ok := parseMPEGFrame(buf[next : next+4]); !ok {
+				continue
+			}
+		}
+
+		if frames := vbrFrames(buf[i:], &frame); frames > 0 {
+			return float64(frames) * float64(frame.samples()) / float64(frame.sampleRate)
+		}
+		return float64(end-start-int64(i)) * 8 / float64(frame.bitrate)
+	}
+	return 0
+}
+
+// vbrFrames returns the frame count of the Xing, Info or VBRI header in the
+// frame at the start of b, or 0 if it has none
+func vbrFrames(b []byte, frame *mpegFrame) uint32 {
+	// The Xing header follows the side information
+	side := 17
+	switch {
+	case frame.mpeg1 && !frame.mono:
+		side = 32
+	case !frame.mpeg1 && frame.mono:
+		side = 9
+	}
+	if at := 4 + side; at+12 <= len(b) {
+		if tag := string(b[at : at+4]); tag == "Xing" || tag == "Info" {
+			if binary.BigEndian.Uint32(b[at+4:])&1 != 0 {
+				return binary.BigEndian.Uint32(b[at+8:])
+			}
+			return 0
+		}
+	}
+
+	if at := 36; at+18 <= len(b) && string(b[at:at+4]) == "VBRI" {
+		return binary.BigEndian.Uint32(b[at+14:])
+	}
+	return 0
+}
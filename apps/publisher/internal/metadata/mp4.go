@@ -0,0 +1,176 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// mp4Box is an ISO base media box: its type and the offset and size of its content
+type mp4Box struct {
+	kind string
+	off  int64
+	size int64
+}
+
+// mp4Boxes returns the boxes between off and end
+func mp4Boxes(r *io.SectionReader, off, end int64) ([]mp4Box, error) {
+	var boxes []mp4Box
+	for off+8 <= end {
+		header, err := readAt(r, off, 8)
+		if err != nil {
+			return nil, errMalformed
+		}
+		size, headerSize := int64(binary.BigEndian.Uint32(header)), int64(8)
+		switch size {
+		case 0: // Up to the end
+			size = end - off
+		case 1: // 64-bit size
+			large, err := readAt(r, off+8, 8)
+			if err != nil {
+				return nil, errMalformed
+			}
+			size, headerSize = int64(binary.BigEndian.Uint64(large)), 16
+		}
+		if size < headerSize || size > end-off {
+			return nil, errMalformed
+		}
+		boxes = append(boxes, mp4Box{kind: string(header[4:8]), off: off + headerSize, size: size - headerSize})
+		off += size
+	}
+	return boxes, nil
+}
+
+// child returns the first box of kind in boxes
+func child(boxes []mp4Box, kind string) (mp4Box, bool) {
+	for _, box := range boxes {
+		if box.kind == kind {
+			return box, true
+		}
+	}
+	return mp4Box{}, false
+}
+
+// readMP4 reads the duration, the frame size of the first video track and the
+// iTunes-style tags of an MP4, M4A or QuickTime file. Only box headers are read
+// on the way to them, so the sample tables of long videos are skipped.
+func readMP4(r *io.SectionReader) (Metadata, error) {
+	top, err := mp4Boxes(r, 0, r.Size())
+	if err != nil {
+		return Metadata{}, err
+	}
+	moov, ok := child(top, "moov")
+	if !ok {
+		return Metadata{}, errMalformed
+	}
+	boxes, err := mp4Boxes(r, moov.off, moov.off+moov.size)
+	if err != nil {
+		return Metadata{}, err
+	}
+
+	var md Metadata
+	if mvhd, ok := child(boxes, "mvhd"); ok {
+		md.Duration = mp4Duration(r, mvhd)
+	}
+	for _, trak := range boxes {
+		if trak.kind != "trak" || md.Width != 0 {
+			continue
+		}
+		children, err := mp4Boxes(r, trak.off, trak.off+trak.size)
+		if err != nil {
+			return Metadata{}, err
+		}
+		if tkhd, ok := child(children, "tkhd"); ok {
+			md.Width, md.Height = mp4FrameSize(r, tkhd)
+		}
+	}
+	if udta, ok := child(boxes, "udta"); ok {
+		readMP4Tags(r, udta, &md)
+	}
+	return md, nil
+}
+
+// mp4Duration returns the duration in seconds recorded in the movie header
+func mp4Duration(r *io.SectionReader, mvhd mp4Box) float64 {
+	b, err := readAt(r, mvhd.off, int(min(mvhd.size, 32)))
+	if err != nil || len(b) < 20 {
+		return 0
+	}
+	var scale, duration uint64
+	if b[0] == 1 { // 64-bit times
+		if len(b) < 32 {
+			return 0
+		}
+		scale, duration = uint64(binary.BigEndian.Uint32(b[20:])), binary.BigEndian.Uint64(b[24:])
+	} else {
+		scale, duration = uint64(binary.BigEndian.Uint32(b[12:])), uint64(binary.BigEndian.Uint32(b[16:]))
+	}
+	if scale == 0 || duration == 0xffffffff || duration == 1<<64-1 { // Unknown duration
+		return 0
+	}
+	return float64(duration) / float64(scale)
+}
+
+// mp4FrameSize returns the width and height of a track header, both zero for
+// audio tracks
+func mp4FrameSize(r *io.SectionReader, tkhd mp4Box) (int, int) {
+	at := int64(76) // Version 0: the fixed-point width and height follow the matrix
+	if b, err := readAt(r, tkhd.off, 1); err == nil && b[0] == 1 {
+		at = 88 // Version 1: creation, modification time and duration are 64-bit
+	}
+	b, err := readAt(r, tkhd.off+at, 8)
+	if err != nil {
+		return 0, 0
+	}
+	return int(binary.BigEndian.Uint32(b) >> 16), int(binary.BigEndian.Uint32(b[4:]) >> 16)
+}
+
+// readMP4Tags sets the title, artist and album of the iTunes metadata list in udta/meta/ilst
+func readMP4Tags(r *io.SectionReader, udta mp4Box, md *Metadata) {
+	boxes, err := mp4Boxes(r, udta.off, udta.off+udta.size)
+	if err != nil {
+		return
+	}
+	meta, ok := child(boxes, "meta")
+	if !ok {
+		return
+	}
+	// meta is a full box with a version and flags, except in some QuickTime files
+	if b, err := readAt(r, meta.off+4, 4); err == nil && string(b) != "hdlr" {
+		meta.off, meta.size = meta.off+4, meta.size-4
+	}
+	if boxes, err = mp4Boxes(r, meta.off, meta.off+meta.size); err != nil {
+		return
+	}
+	ilst, ok := child(boxes, "ilst")
+	if !ok {
+		return
+	}
+	items, err := mp4Boxes(r, ilst.off, ilst.off+ilst.size)
+	if err != nil {
+		return
+	}
+
+	for _, item := range items {
+		var field *string
+		switch item.kind {
+		case "\xa9nam":
+			field = &md.Title
+		case "\xa9ART":
+			field = &md.Artist
+		case "\xa9alb":
+			field = &md.Album
+		default:
+			continue
+		}
+		values, err := mp4Boxes(r, item.off, item.off+item.size)
+		if err != nil {
+			continue
+		}
+		// The data box holds a type indicator, a locale and the UTF-8 value
+		if data, ok := child(values, "data"); ok && data.size > 8 {
+			if b, err := readAt(r, data.off+8, int(min(data.size-8, maxTagSize))); err == nil {
+				*field = string(b)
+			}
+		}
+	}
+}
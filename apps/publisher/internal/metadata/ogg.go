@@ -0,0 +1,152 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+// oggTailSize is how much of the end of an Ogg file is searched for its last page
+const oggTailSize = 64 << 10
+
+// oggPageHeaderSize is the size of an Ogg page header before its segment table
+const oggPageHeaderSize = 27
+
+// opusRate is the rate of Opus granule positions, whatever the input rate
+const opusRate = 48000
+
+// oggPage is the header of an Ogg page
+type oggPage struct {
+	granule  int64
+	serial   uint32
+	segments []byte // Segment table
+	size     int64  // Header, segment table and data
+}
+
+// readOggPage reads the page header at off
+func readOggPage(r *io.SectionReader, off int64) (oggPage, error) {
+	header, err := readAt(r, off, oggPageHeaderSize)
+	if err != nil || string(header[:4]) != "OggS" {
+		return oggPage{}, errMalformed
+	}
+	segments, err := readAt(r, off+oggPageHeaderSize, int(header[26]))
+	if err != nil {
+		return oggPage{}, errMalformed
+	}
+
+	page := oggPage{
+		granule:  int64(binary.LittleEndian.Uint64(header[6:])),
+		serial:   binary.LittleEndian.Uint32(header[14:]),
+		segments: segments,
+		size:     oggPageHeaderSize + int64(len(segments)),
+	}
+	for _, n := range segments {
+		page.size += int64(n)
+	}
+	return page, nil
+}
+
+// oggPackets returns the first n packets of the first logical stream of r.
+// Packets are cut at maxCommentSize, e.g. comments embedding artwork, and cut
+// reports whether any was.
+func oggPackets(r *io.SectionReader, n int) (packets [][]byte, serial uint32, cut bool, err error) {
+	var packet []byte
+	for off, first := int64(0), true; len(packets) < n; first = false {
+		page, err := readOggPage(r, off)
+		if err != nil {
+			return nil, 0, false, err
+		}
+		if first {
+			serial = page.serial
+		}
+		if page.serial != serial { // Another multiplexed stream
+			off += page.size
+			continue
+		}
+
+		data := off + oggPageHeaderSize + int64(len(page.segments))
+		for _, size := range page.segments {
+			if len(packet)+int(size) <= maxCommentSize {
+				b, err := readAt(r, data, int(size))
+				if err != nil {
+					return nil, 0, false, errMalformed
+				}
+				packet = append(packet, b...)
+			} else {
+				cut = true
+			}
+			data += int64(size)
+			if size < 255 {
+				packets = append(packets, packet)
+				packet = nil
+				if len(packets) == n {
+					break
+				}
+			}
+		}
+		off += page.size
+	}
+	return packets, serial, cut, nil
+}
+
+// readOgg reads the comments and duration of an Ogg Vorbis or Opus file
+func readOgg(r *io.SectionReader) (Metadata, error) {
+	packets, serial, cut, err := oggPackets(r, 2)
+	if err != nil {
+		return Metadata{}, err
+	}
+	ident, comments := packets[0], packets[1]
+
+	var md Metadata
+	var rate, preSkip int64
+	switch {
+	case len(ident) >= 16 && bytes.HasPrefix(ident, []byte("\x01vorbis")):
+		rate = int64(binary.LittleEndian.Uint32(ident[12:]))
+		if !bytes.HasPrefix(comments, []byte("\x03vorbis")) {
+			return Metadata{}, errMalformed
+		}
+		comments = comments[7:]
+	case len(ident) >= 12 && bytes.HasPrefix(ident, []byte("OpusHead")):
+		rate = opusRate
+		preSkip = int64(binary.LittleEndian.Uint16(ident[10:]))
+		if !bytes.HasPrefix(comments, []byte("OpusTags")) {
+			return Metadata{}, errMalformed
+		}
+		comments = comments[8:]
+	default:
+		return Metadata{}, nil // Another codec, e.g. Theora or FLAC in Ogg
+	}
+	// Comments cut at maxCommentSize keep the tags before the cut
+	if err := readVorbisComments(comments, &md); err != nil && !cut {
+		return Metadata{}, err
+	}
+
+	if granule := lastGranule(r, serial); rate > 0 && granule > preSkip {
+		md.Duration = float64(granule-preSkip) / float64(rate)
+	}
+	return md, nil
+}
+
+// lastGranule returns the granule position of the last page of the stream
+// serial, or 0 if none is found near the end of r
+func lastGranule(r *io.SectionReader, serial uint32) int64 {
+	start := max(0, r.Size()-oggTailSize)
+	tail, err := readAt(r, start, int(r.Size()-start))
+	if err != nil {
+		return 0
+	}
+
+	for i := len(tail); ; {
+		i = bytes.LastIndex(tail[:i], []byte("OggS"))
+		if i < 0 {
+			return 0
+		}
+		if i+oggPageHeaderSize > len(tail) {
+			continue
+		}
+		granule := int64(binary.LittleEndian.Uint64(tail[i+6:]))
+		if binary.LittleEndian.Uint32(tail[i+14:]) == serial && granule > 0 {
+			return granule
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// CircuitMetrics tracks the circuit breaker around the external IPFS node.
+// It implements prometheus.Collector and ipfs.BreakerObserver.
+type CircuitMetrics struct {
+	state    prometheus.Gauge
+	opens    prometheus.Counter
+	rejected prometheus.Counter
+}
+
+// circuitStates are the values of ipfspublisher_ipfs_circuit_state by breaker state
+var circuitStates = map[string]float64{
+	"closed":    0,
+	"half-open": 1,
+	"open":      2,
+}
+
+// NewCircuitMetrics creates the circuit breaker metrics
+func NewCircuitMetrics() *CircuitMetrics {
+	return &CircuitMetrics{
+		state: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ipfspublisher_ipfs_circuit_state",
+			Help: "State of the circuit breaker around the IPFS node: 0 closed, 1 half-open (probing), 2 open.",
+		}),
+		opens: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ipfspublisher_ipfs_circuit_opens_total",
+			Help: "Times the circuit breaker opened after consecutive failures to reach the IPFS node.",
+		}),
+		rejected: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ipfspublisher_ipfs_circuit_rejected_total",
+			Help: "Requests to the IPFS node failed fast while the circuit breaker was open.",
+		}),
+	}
+}
+
+// CircuitState records a state change; opening a closed circuit is counted
+func (m *CircuitMetrics) CircuitState(from, to string) {
+	m.state.Set(circuitStates[to])
+	if from == "closed" && to == "open" {
+		m.opens.Inc()
+	}
+}
+
+// CircuitRejected counts a request failed fast
+func (m *CircuitMetrics) CircuitRejected() {
+	m.rejected.Inc()
+}
+
+// Describe implements prometheus.Collector
+func (m *CircuitMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.state.Describe(ch)
+	m.opens.Describe(ch)
+	m.rejected.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (m *CircuitMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.state.Collect(ch)
+	m.opens.Collect(ch)
+	m.rejected.Collect(ch)
+}
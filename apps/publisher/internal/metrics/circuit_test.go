@@ -0,0 +1,32 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCircuitMetrics(t *testing.T) {
+	m := NewCircuitMetrics()
+	// A failed probe reopens the circuit without counting another opening
+	m.CircuitState("closed", "open")
+	m.CircuitRejected()
+	m.CircuitState("open", "half-open")
+	m.CircuitState("half-open", "open")
+
+	if got := testutil.ToFloat64(m.opens); got != 1 {
+		t.Errorf("opens = %g, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.rejected); got != 1 {
+		t.Errorf("rejected = %g, want 1", got)
+	}
+	if got := testutil.ToFloat64(m.state); got != 2 {
+		t.Errorf("state = %g, want 2 (open)", got)
+	}
+
+	m.CircuitState("open", "half-open")
+	m.CircuitState("half-open", "closed")
+	if got := testutil.ToFloat64(m.state); got != 0 {
+		t.Errorf("state = %g, want 0 (closed)", got)
+	}
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ConnectivityMetrics tracks the peers of the embedded node and the outages
+// after which the publisher republished and announced again.
+// It implements prometheus.Collector.
+type ConnectivityMetrics struct {
+	peers      prometheus.Gauge
+	restored   prometheus.Counter
+	lastOutage prometheus.Gauge
+}
+
+// NewConnectivityMetrics creates the connectivity metrics
+func NewConnectivityMetrics() *ConnectivityMetrics {
+	return &ConnectivityMetrics{
+		peers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ipfspublisher_ipfs_peers",
+			Help: "Peers the embedded IPFS node is connected to.",
+		}),
+		restored: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ipfspublisher_connectivity_restored_total",
+			Help: "Reconnects after an outage, each followed by an IPNS republish check and an announcement.",
+		}),
+		lastOutage: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ipfspublisher_connectivity_last_outage_seconds",
+			Help: "Duration of the last outage without peers, including time the host was suspended.",
+		}),
+	}
+}
+
+// Peers records the current peer count
+func (m *ConnectivityMetrics) Peers(peers int) {
+	m.peers.Set(float64(peers))
+}
+
+// Restored counts a reconnect after an outage of the given duration
+func (m *ConnectivityMetrics) Restored(outage time.Duration) {
+	m.restored.Inc()
+	m.lastOutage.Set(outage.Seconds())
+}
+
+// Describe implements prometheus.Collector
+func (m *ConnectivityMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.peers.Describe(ch)
+	m.restored.Describe(ch)
+	m.lastOutage.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (m *ConnectivityMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.peers.Collect(ch)
+	m.restored.Collect(ch)
+	m.lastOutage.Collect(ch)
+}
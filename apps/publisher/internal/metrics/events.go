@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/atregu/ipfs-common/extensions"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// eventsDesc describes the watcher event counters exported to Prometheus
+var eventsDesc = prometheus.NewDesc(
+	"ipfspublisher_watcher_events_total",
+	"File system events seen by the watcher.",
+	[]string{"type", "extension"}, nil,
+)
+
+// EventKey identifies a file event counter by event type and file extension
+type EventKey struct {
+	EventType string
+	Extension string
+}
+
+// EventCount is a single counter value
+type EventCount struct {
+	EventType string `json:"type"`
+	Extension string `json:"extension"`
+	Count     uint64 `json:"count"`
+}
+
+// EventCounter counts file system events by type and extension.
+// It implements prometheus.Collector.
+type EventCounter struct {
+	mu     sync.Mutex
+	counts map[EventKey]uint64
+}
+
+// NewEventCounter creates an empty event counter
+func NewEventCounter() *EventCounter {
+	return &EventCounter{
+		counts: make(map[EventKey]uint64),
+	}
+}
+
+// Inc increments the counter for the given event type and extension
+func (c *EventCounter) Inc(eventType, extension string) {
+	key := EventKey{
+		EventType: strings.ToLower(eventType),
+		Extension: extensions.Normalize(extension),
+	}
+
+	c.mu.Lock()
+	c.counts[key]++
+	c.mu.Unlock()
+}
+
+// Total returns the number of events recorded for an event type across all extensions
+// (e.g. Total("create") is create_total)
+func (c *EventCounter) Total(eventType string) uint64 {
+	eventType = strings.ToLower(eventType)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var total uint64
+	for key, count := range c.counts {
+		if key.EventType == eventType {
+			total += count
+		}
+	}
+	return total
+}
+
+// Snapshot returns all counters sorted by event type and extension
+func (c *EventCounter) Snapshot() []EventCount {
+	c.mu.Lock()
+	result := make([]EventCount, 0, len(c.counts))
+	for key, count := range c.counts {
+		result = append(result, EventCount{
+			EventType: key.EventType,
+			Extension: key.Extension,
+			Count:     count,
+		})
+	}
+	c.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].EventType != result[j].EventType {
+			return result[i].EventType < result[j].EventType
+		}
+		return result[i].Extension < result[j].Extension
+	})
+	return result
+}
+
+// Describe implements prometheus.Collector
+func (c *EventCounter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- eventsDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *EventCounter) Collect(ch chan<- prometheus.Metric) {
+	for _, entry := range c.Snapshot() {
+		ch <- prometheus.MustNewConstMetric(eventsDesc, prometheus.CounterValue, float64(entry.Count), entry.EventType, entry.Extension)
+	}
+}
@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestEventCounterTotal(t *testing.T) {
+	c := NewEventCounter()
+	c.Inc("CREATE", "MP3")
+	c.Inc("create", "flac")
+	c.Inc("DELETE", "mp3")
+
+	if got := c.Total("create"); got != 2 {
+		t.Errorf("Total(create) = %d, want 2", got)
+	}
+	if got := c.Total("modify"); got != 0 {
+		t.Errorf("Total(modify) = %d, want 0", got)
+	}
+}
+
+func TestEventCounterCollect(t *testing.T) {
+	c := NewEventCounter()
+	c.Inc("CREATE", "mp3")
+	c.Inc("CREATE", "mp3")
+	c.Inc("MODIFY", "flac")
+
+	expected := `
+# HELP ipfspublisher_watcher_events_total File system events seen by the watcher.
+# TYPE ipfspublisher_watcher_events_total counter
+ipfspublisher_watcher_events_total{extension="flac",type="modify"} 1
+ipfspublisher_watcher_events_total{extension="mp3",type="create"} 2
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
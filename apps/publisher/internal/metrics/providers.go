@@ -0,0 +1,42 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// ProviderMetrics holds the result of the last provider probe.
+// It implements prometheus.Collector.
+type ProviderMetrics struct {
+	checked prometheus.Gauge
+	ok      prometheus.Gauge
+}
+
+// NewProviderMetrics creates the provider probe metrics
+func NewProviderMetrics() *ProviderMetrics {
+	return &ProviderMetrics{
+		checked: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ipfspublisher_providers_checked",
+			Help: "Published CIDs sampled by the last provider probe.",
+		}),
+		ok: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ipfspublisher_providers_ok",
+			Help: "Sampled CIDs of the last provider probe with a provider besides this node.",
+		}),
+	}
+}
+
+// Probed records the outcome of a provider probe
+func (m *ProviderMetrics) Probed(checked, ok int) {
+	m.checked.Set(float64(checked))
+	m.ok.Set(float64(ok))
+}
+
+// Describe implements prometheus.Collector
+func (m *ProviderMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.checked.Describe(ch)
+	m.ok.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (m *ProviderMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.checked.Collect(ch)
+	m.ok.Collect(ch)
+}
@@ -0,0 +1,54 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// ReachMetrics holds the announcement reach recorded at the last announcement.
+// It implements prometheus.Collector.
+type ReachMetrics struct {
+	reach     prometheus.Gauge
+	lastPeers prometheus.Gauge
+	alert     prometheus.Gauge
+}
+
+// NewReachMetrics creates the announcement reach metrics
+func NewReachMetrics() *ReachMetrics {
+	return &ReachMetrics{
+		reach: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ipfspublisher_pubsub_reach_peers",
+			Help: "Distinct topic peers present at announcements in the last 24 hours.",
+		}),
+		lastPeers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ipfspublisher_pubsub_topic_peers",
+			Help: "Topic peers present at the last announcement.",
+		}),
+		alert: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ipfspublisher_pubsub_reach_alert",
+			Help: "1 if the topic had no peers for more than pubsub.reach_alert_after announcements in a row.",
+		}),
+	}
+}
+
+// Reached records the reach after an announcement
+func (m *ReachMetrics) Reached(reach, lastPeers int, alert bool) {
+	m.reach.Set(float64(reach))
+	m.lastPeers.Set(float64(lastPeers))
+	if alert {
+		m.alert.Set(1)
+	} else {
+		m.alert.Set(0)
+	}
+}
+
+// Describe implements prometheus.Collector
+func (m *ReachMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.reach.Describe(ch)
+	m.lastPeers.Describe(ch)
+	m.alert.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (m *ReachMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.reach.Collect(ch)
+	m.lastPeers.Collect(ch)
+	m.alert.Collect(ch)
+}
@@ -0,0 +1,46 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// UploadMetrics tracks uploads paused because the IPFS repository volume was full.
+// It implements prometheus.Collector.
+type UploadMetrics struct {
+	pauses prometheus.Counter
+	paused prometheus.Gauge
+}
+
+// NewUploadMetrics creates the upload pause metrics
+func NewUploadMetrics() *UploadMetrics {
+	return &UploadMetrics{
+		pauses: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ipfspublisher_upload_space_pauses_total",
+			Help: "Times uploads were paused because the IPFS repository volume was full.",
+		}),
+		paused: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ipfspublisher_uploads_paused",
+			Help: "1 while uploads are paused for lack of disk space, 0 otherwise.",
+		}),
+	}
+}
+
+// Paused records whether uploads are paused; every pause is counted
+func (m *UploadMetrics) Paused(paused bool) {
+	if !paused {
+		m.paused.Set(0)
+		return
+	}
+	m.pauses.Inc()
+	m.paused.Set(1)
+}
+
+// Describe implements prometheus.Collector
+func (m *UploadMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.pauses.Describe(ch)
+	m.paused.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (m *UploadMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.pauses.Collect(ch)
+	m.paused.Collect(ch)
+}
@@ -0,0 +1,21 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestUploadMetricsPaused(t *testing.T) {
+	m := NewUploadMetrics()
+	m.Paused(true)
+	m.Paused(false)
+	m.Paused(true)
+
+	if got := testutil.ToFloat64(m.pauses); got != 2 {
+		t.Errorf("pauses = %g, want 2", got)
+	}
+	if got := testutil.ToFloat64(m.paused); got != 1 {
+		t.Errorf("paused = %g, want 1", got)
+	}
+}
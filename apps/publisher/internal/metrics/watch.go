@@ -0,0 +1,55 @@
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// WatchMetrics tracks how the watcher covers the directory tree: directories
+// watched through the OS and subtrees polled because watches ran out.
+// It implements prometheus.Collector.
+type WatchMetrics struct {
+	watched prometheus.Gauge
+	polled  prometheus.Gauge
+	polls   prometheus.Counter
+}
+
+// NewWatchMetrics creates the watcher coverage metrics
+func NewWatchMetrics() *WatchMetrics {
+	return &WatchMetrics{
+		watched: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ipfspublisher_watcher_watched_directories",
+			Help: "Directories watched for changes through the OS.",
+		}),
+		polled: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "ipfspublisher_watcher_polled_subtrees",
+			Help: "Directory subtrees scanned periodically instead, e.g. because the inotify watch limit was reached.",
+		}),
+		polls: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "ipfspublisher_watcher_polls_total",
+			Help: "Periodic scans of the polled subtrees.",
+		}),
+	}
+}
+
+// Covered records the number of watched directories and polled subtrees
+func (m *WatchMetrics) Covered(watched, polled int) {
+	m.watched.Set(float64(watched))
+	m.polled.Set(float64(polled))
+}
+
+// Polled counts a scan of the polled subtrees
+func (m *WatchMetrics) Polled() {
+	m.polls.Inc()
+}
+
+// Describe implements prometheus.Collector
+func (m *WatchMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.watched.Describe(ch)
+	m.polled.Describe(ch)
+	m.polls.Describe(ch)
+}
+
+// Collect implements prometheus.Collector
+func (m *WatchMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.watched.Collect(ch)
+	m.polled.Collect(ch)
+	m.polls.Collect(ch)
+}
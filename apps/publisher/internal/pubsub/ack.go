@@ -0,0 +1,106 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/atregu/ipfs-common/ack"
+	"github.com/atregu/ipfs-common/fingerprint"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// TopicSubscriber delivers the raw messages other peers publish to a topic until
+// ctx is done (implemented by the standalone node and the embedded IPFS client)
+type TopicSubscriber interface {
+	SubscribeTopic(ctx context.Context, topic string) (<-chan []byte, error)
+}
+
+// AckRecorder stores the indexers that acknowledged a version (implemented by state.Manager)
+type AckRecorder interface {
+	RecordAck(version int, indexerKey string) (count int, added bool)
+}
+
+// ListenForAcks subscribes to the ack topic and records the indexers that
+// acknowledge this publisher's announcements until ctx is done
+func (p *Publisher) ListenForAcks(ctx context.Context, subscriber TopicSubscriber, topic string, recorder AckRecorder) error {
+	messages, err := subscriber.SubscribeTopic(ctx, topic)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to ack topic %s: %w", topic, err)
+	}
+
+	p.mu.Lock()
+	p.ackRecorder = recorder
+	p.mu.Unlock()
+
+	logger.Get().Infof("Listening for indexer acks on PubSub topic %q", topic)
+
+	go func() {
+		for data := range messages {
+			p.handleAck(data)
+		}
+	}()
+
+	return nil
+}
+
+// handleAck records a valid ack of one of this publisher's versions
+func (p *Publisher) handleAck(data []byte) {
+	log := logger.Get()
+
+	msg, err := ack.Parse(data)
+	if err != nil {
+		log.Debugf("Ignoring malformed ack: %v", err)
+		return
+	}
+
+	// Acks of other publishers share the topic and are skipped before verifying
+	publicKey := base64.StdEncoding.EncodeToString(p.privateKey.Public().(ed25519.PublicKey))
+	if msg.PublisherKey != publicKey {
+		return
+	}
+
+	if err := msg.Validate(); err != nil {
+		log.Debugf("Ignoring invalid ack: %v", err)
+		return
+	}
+	if err := msg.Verify(); err != nil {
+		log.Debugf("Ignoring ack with bad signature: %v", err)
+		return
+	}
+
+	p.mu.Lock()
+	if msg.Version > p.currentVersion {
+		p.mu.Unlock()
+		log.Debugf("Ignoring ack of unannounced version %d", msg.Version)
+		return
+	}
+	p.unackedCount = 0
+	recorder := p.ackRecorder
+	p.mu.Unlock()
+
+	// The recorder may write to disk, which must not hold up announcements
+	count, added := recorder.RecordAck(msg.Version, msg.PublicKey)
+	if added {
+		log.Infof("Version %d acknowledged by %d indexers, latest %s", msg.Version, count, fingerprint.Format(msg.PublicKey))
+	}
+}
+
+// countHeartbeat counts an announcement without acks and warns once when
+// ackWarnAfter announcements passed without any
+func (p *Publisher) countHeartbeat() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.ackRecorder == nil || p.ackWarnAfter <= 0 {
+		return
+	}
+
+	p.unackedCount++
+	if p.unackedCount == p.ackWarnAfter {
+		logger.Get().Warnf("No indexer acknowledged the last %d announcements; check PubSub connectivity (bootstrap peers, firewall, topic) or whether indexers enable pubsub.ack",
+			p.ackWarnAfter)
+	}
+}
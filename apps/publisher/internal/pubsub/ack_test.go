@@ -0,0 +1,95 @@
+package pubsub
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+
+	"github.com/atregu/ipfs-common/ack"
+)
+
+// memoryRecorder counts the distinct indexers per version
+type memoryRecorder struct {
+	acks map[int]map[string]bool
+}
+
+func (r *memoryRecorder) RecordAck(version int, indexerKey string) (int, bool) {
+	if r.acks[version] == nil {
+		r.acks[version] = make(map[string]bool)
+	}
+	added := !r.acks[version][indexerKey]
+	r.acks[version][indexerKey] = true
+	return len(r.acks[version]), added
+}
+
+func newTestKey(t *testing.T) ed25519.PrivateKey {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	return key
+}
+
+// signedAck returns an ack of version for publisherKey signed by indexerKey
+func signedAck(t *testing.T, publisherKey ed25519.PrivateKey, version int, indexerKey ed25519.PrivateKey) []byte {
+	t.Helper()
+
+	msg := NewAnnouncementMessage(1, "k51", 0, 1)
+	if err := msg.Sign(publisherKey); err != nil {
+		t.Fatal(err)
+	}
+
+	a := ack.New(msg.PublicKey, version)
+	if err := a.Sign(indexerKey); err != nil {
+		t.Fatal(err)
+	}
+	data, err := a.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestHandleAck(t *testing.T) {
+	key := newTestKey(t)
+	p := NewPublisher(nil, key, &PublisherConfig{AckWarnAfter: 2})
+	p.Resume(12, "k51", 3, "", "")
+
+	recorder := &memoryRecorder{acks: make(map[int]map[string]bool)}
+	p.ackRecorder = recorder
+
+	indexerA, indexerB := newTestKey(t), newTestKey(t)
+	tampered := signedAck(t, key, 12, indexerB)
+	tampered[len(tampered)-4] ^= 1
+
+	for _, data := range [][]byte{
+		signedAck(t, key, 12, indexerA),
+		signedAck(t, key, 12, indexerA),
+		signedAck(t, key, 12, indexerB),
+		signedAck(t, newTestKey(t), 12, indexerB), // another publisher's version
+		signedAck(t, key, 13, indexerB),           // not announced yet
+		tampered,
+		[]byte("not json"),
+	} {
+		p.handleAck(data)
+	}
+
+	if got := len(recorder.acks[12]); got != 2 {
+		t.Errorf("version 12 acknowledged by %d indexers, want 2", got)
+	}
+	if len(recorder.acks) != 1 {
+		t.Errorf("acks recorded for versions %v, want only 12", recorder.acks)
+	}
+
+	// Heartbeats without acks are counted until the next ack
+	p.countHeartbeat()
+	p.countHeartbeat()
+	if p.unackedCount != 2 {
+		t.Errorf("%d unacknowledged heartbeats, want 2", p.unackedCount)
+	}
+	p.handleAck(signedAck(t, key, 12, newTestKey(t)))
+	if p.unackedCount != 0 {
+		t.Errorf("ack did not reset the unacknowledged heartbeats (%d)", p.unackedCount)
+	}
+}
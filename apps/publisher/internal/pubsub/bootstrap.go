@@ -0,0 +1,270 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+const (
+	// bootstrapDialTimeout bounds the first dial attempt so Start returns promptly when offline
+	bootstrapDialTimeout = 5 * time.Second
+
+	// bootstrapInitialBackoff is the delay before the first background retry
+	bootstrapInitialBackoff = 5 * time.Second
+
+	// bootstrapMaxBackoff caps the exponential backoff between retries
+	bootstrapMaxBackoff = 10 * time.Minute
+)
+
+// BootstrapPeerStatus records the dial outcomes for a bootstrap peer
+type BootstrapPeerStatus struct {
+	Address     string    // Configured multiaddr
+	PeerID      string    // Peer ID parsed from the address
+	Connected   bool      // Whether the last dial succeeded
+	Attempts    int       // Number of dial attempts so far
+	LastError   string    // Error of the last failed dial
+	LastAttempt time.Time // Time of the last dial
+	NextRetry   time.Time // Time of the next background retry (zero if none scheduled)
+}
+
+// bootstrapPeer is a parsed bootstrap peer, redialed whenever it is not connected
+type bootstrapPeer struct {
+	addr     string
+	info     peer.AddrInfo
+	retrying bool // A background retry loop is running
+}
+
+// connectBootstrapPeers dials bootstrap peers once in parallel with a short timeout.
+// Peers that fail are retried in the background with exponential backoff, so this
+// only returns an error to report that no peer was reachable on the first attempt.
+func (n *Node) connectBootstrapPeers(bootstrapPeers []string) error {
+	log := logger.Get()
+
+	// Use default IPFS bootstrap peers if none provided
+	if len(bootstrapPeers) == 0 {
+		// Convert default bootstrap peers to strings
+		for _, maddr := range dht.DefaultBootstrapPeers {
+			bootstrapPeers = append(bootstrapPeers, maddr.String())
+		}
+	}
+
+	n.bootstrapMu.Lock()
+	n.bootstrapPeers = make(map[string]*BootstrapPeerStatus, len(bootstrapPeers))
+	n.bootstrapOrder = nil
+	n.bootstrapByID = make(map[peer.ID]*bootstrapPeer, len(bootstrapPeers))
+	n.bootstrapMu.Unlock()
+
+	// Redial bootstrap peers that drop their connection
+	n.host.Network().Notify(&network.NotifyBundle{DisconnectedF: n.bootstrapDisconnected})
+
+	var wg sync.WaitGroup
+	successCount := 0
+	mu := sync.Mutex{}
+
+	for _, peerAddr := range bootstrapPeers {
+		peerInfo, err := parseBootstrapAddr(peerAddr)
+		n.recordBootstrapPeer(peerAddr, peerInfo)
+		if err != nil {
+			log.Debugf("Invalid bootstrap peer address %s: %v", peerAddr, err)
+			n.recordBootstrapDial(peerAddr, err)
+			continue
+		}
+
+		bp := &bootstrapPeer{addr: peerAddr, info: *peerInfo}
+		n.bootstrapMu.Lock()
+		n.bootstrapByID[peerInfo.ID] = bp
+		n.bootstrapMu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := n.dialBootstrapPeer(bp.addr, bp.info); err != nil {
+				log.Debugf("Failed to connect to bootstrap peer %s: %v", bp.info.ID, err)
+				n.startBootstrapRetry(bp)
+				return
+			}
+
+			mu.Lock()
+			successCount++
+			mu.Unlock()
+			log.Debugf("Connected to bootstrap peer: %s", bp.info.ID)
+		}()
+	}
+
+	wg.Wait()
+	log.Infof("Connected to %d bootstrap peers", successCount)
+
+	if successCount == 0 {
+		return fmt.Errorf("failed to connect to any bootstrap peers")
+	}
+
+	return nil
+}
+
+// parseBootstrapAddr parses a bootstrap multiaddr with a /p2p/ component
+func parseBootstrapAddr(addr string) (*peer.AddrInfo, error) {
+	maddr, err := multiaddr.NewMultiaddr(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multiaddr: %w", err)
+	}
+
+	peerInfo, err := peer.AddrInfoFromP2pAddr(maddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse peer info: %w", err)
+	}
+
+	return peerInfo, nil
+}
+
+// dialBootstrapPeer makes a single dial attempt bounded by bootstrapDialTimeout
+func (n *Node) dialBootstrapPeer(addr string, info peer.AddrInfo) error {
+	ctx, cancel := context.WithTimeout(n.ctx, bootstrapDialTimeout)
+	defer cancel()
+
+	err := n.host.Connect(ctx, info)
+	n.recordBootstrapDial(addr, err)
+	return err
+}
+
+// bootstrapDisconnected is called by the network when a connection closes. Once
+// the last connection to a bootstrap peer is gone, the peer is retried.
+func (n *Node) bootstrapDisconnected(net network.Network, conn network.Conn) {
+	id := conn.RemotePeer()
+	if net.Connectedness(id) == network.Connected || n.ctx.Err() != nil {
+		return
+	}
+
+	n.bootstrapMu.Lock()
+	bp, ok := n.bootstrapByID[id]
+	if ok {
+		if status, exists := n.bootstrapPeers[bp.addr]; exists {
+			status.Connected = false
+			status.LastError = "disconnected"
+		}
+	}
+	n.bootstrapMu.Unlock()
+
+	if ok {
+		logger.Get().Debugf("Bootstrap peer disconnected: %s", id)
+		n.startBootstrapRetry(bp)
+	}
+}
+
+// startBootstrapRetry starts the background retry loop of a bootstrap peer
+// unless one is already running
+func (n *Node) startBootstrapRetry(bp *bootstrapPeer) {
+	n.bootstrapMu.Lock()
+	defer n.bootstrapMu.Unlock()
+
+	if bp.retrying {
+		return
+	}
+	bp.retrying = true
+
+	go func() {
+		n.retryBootstrapPeer(bp.addr, bp.info)
+
+		n.bootstrapMu.Lock()
+		bp.retrying = false
+		n.bootstrapMu.Unlock()
+
+		// A disconnect between the successful dial and clearing retrying was ignored
+		if n.ctx.Err() == nil && n.host.Network().Connectedness(bp.info.ID) != network.Connected {
+			n.startBootstrapRetry(bp)
+		}
+	}()
+}
+
+// retryBootstrapPeer retries a failed bootstrap peer with exponential backoff until
+// it connects or the node stops
+func (n *Node) retryBootstrapPeer(addr string, info peer.AddrInfo) {
+	log := logger.Get()
+	backoff := bootstrapInitialBackoff
+
+	for {
+		n.setBootstrapNextRetry(addr, time.Now().Add(backoff))
+
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if err := n.dialBootstrapPeer(addr, info); err == nil {
+			log.Infof("Connected to bootstrap peer after retry: %s", info.ID)
+			return
+		}
+
+		backoff *= 2
+		if backoff > bootstrapMaxBackoff {
+			backoff = bootstrapMaxBackoff
+		}
+	}
+}
+
+// recordBootstrapPeer registers a bootstrap peer for status tracking
+func (n *Node) recordBootstrapPeer(addr string, info *peer.AddrInfo) {
+	n.bootstrapMu.Lock()
+	defer n.bootstrapMu.Unlock()
+
+	status := &BootstrapPeerStatus{Address: addr}
+	if info != nil {
+		status.PeerID = info.ID.String()
+	}
+	if _, exists := n.bootstrapPeers[addr]; !exists {
+		n.bootstrapOrder = append(n.bootstrapOrder, addr)
+	}
+	n.bootstrapPeers[addr] = status
+}
+
+// recordBootstrapDial records the outcome of a dial attempt
+func (n *Node) recordBootstrapDial(addr string, err error) {
+	n.bootstrapMu.Lock()
+	defer n.bootstrapMu.Unlock()
+
+	status, ok := n.bootstrapPeers[addr]
+	if !ok {
+		return
+	}
+
+	status.Attempts++
+	status.LastAttempt = time.Now()
+	status.NextRetry = time.Time{}
+	status.Connected = err == nil
+	status.LastError = ""
+	if err != nil {
+		status.LastError = err.Error()
+	}
+}
+
+// setBootstrapNextRetry records when the next background retry is scheduled
+func (n *Node) setBootstrapNextRetry(addr string, next time.Time) {
+	n.bootstrapMu.Lock()
+	defer n.bootstrapMu.Unlock()
+
+	if status, ok := n.bootstrapPeers[addr]; ok {
+		status.NextRetry = next
+	}
+}
+
+// GetBootstrapStatus returns the dial outcomes of all bootstrap peers (for --peer-info)
+func (n *Node) GetBootstrapStatus() []BootstrapPeerStatus {
+	n.bootstrapMu.Lock()
+	defer n.bootstrapMu.Unlock()
+
+	result := make([]BootstrapPeerStatus, 0, len(n.bootstrapOrder))
+	for _, addr := range n.bootstrapOrder {
+		result = append(result, *n.bootstrapPeers[addr])
+	}
+	return result
+}
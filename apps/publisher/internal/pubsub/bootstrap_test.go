@@ -0,0 +1,119 @@
+package pubsub
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+
+	"github.com/libp2p/go-libp2p"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+)
+
+// randomPeerID returns the ID of a freshly generated Ed25519 key
+func randomPeerID(t *testing.T) peer.ID {
+	t.Helper()
+
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := peer.IDFromPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// startTestNode starts a standalone node with the given bootstrap peers on a random port
+func startTestNode(t *testing.T, bootstrapPeers []string) *Node {
+	t.Helper()
+	logger.Get().SetOutput(io.Discard)
+
+	cfg := &Config{Topic: "mdn/test/announce", BootstrapPeers: bootstrapPeers}
+	node, err := NewNode(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	t.Cleanup(func() { node.Stop() })
+	return node
+}
+
+// waitForStatus polls the bootstrap status until cond holds for every peer or the deadline passes
+func waitForStatus(t *testing.T, node *Node, timeout time.Duration, cond func(BootstrapPeerStatus) bool) []BootstrapPeerStatus {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for {
+		status := node.GetBootstrapStatus()
+		ok := true
+		for _, s := range status {
+			ok = ok && cond(s)
+		}
+		if ok || time.Now().After(deadline) {
+			return status
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestStartReturnsPromptlyWithUnreachablePeers(t *testing.T) {
+	peers := []string{
+		// Nothing listens on port 1: the dial is refused
+		fmt.Sprintf("/ip4/127.0.0.1/tcp/1/p2p/%s", randomPeerID(t)),
+		// TEST-NET-1 is not routed: the dial times out
+		fmt.Sprintf("/ip4/192.0.2.1/tcp/4001/p2p/%s", randomPeerID(t)),
+	}
+
+	start := time.Now()
+	node := startTestNode(t, peers)
+	if elapsed := time.Since(start); elapsed > bootstrapDialTimeout+5*time.Second {
+		t.Errorf("Start took %s with unreachable bootstrap peers", elapsed)
+	}
+
+	status := waitForStatus(t, node, time.Second, func(s BootstrapPeerStatus) bool { return !s.NextRetry.IsZero() })
+	if len(status) != len(peers) {
+		t.Fatalf("got %d bootstrap statuses, want %d", len(status), len(peers))
+	}
+	for _, s := range status {
+		if s.Connected || s.Attempts != 1 || s.LastError == "" {
+			t.Errorf("%s: connected=%t attempts=%d error=%q, want one failed attempt", s.Address, s.Connected, s.Attempts, s.LastError)
+		}
+		if s.NextRetry.IsZero() {
+			t.Errorf("%s: no background retry scheduled", s.Address)
+		}
+	}
+}
+
+func TestBootstrapPeerRedialedAfterDisconnect(t *testing.T) {
+	remote, err := libp2p.New(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer remote.Close()
+
+	node := startTestNode(t, []string{fmt.Sprintf("%s/p2p/%s", remote.Addrs()[0], remote.ID())})
+
+	status := node.GetBootstrapStatus()
+	if len(status) != 1 || !status[0].Connected {
+		t.Fatalf("bootstrap status = %+v, want connected", status)
+	}
+
+	if err := remote.Network().ClosePeer(node.host.ID()); err != nil {
+		t.Fatal(err)
+	}
+
+	status = waitForStatus(t, node, bootstrapInitialBackoff+10*time.Second, func(s BootstrapPeerStatus) bool {
+		return s.Connected && s.Attempts >= 2
+	})
+	if !status[0].Connected || status[0].Attempts < 2 {
+		t.Errorf("bootstrap status = %+v, want redialed after disconnect", status[0])
+	}
+}
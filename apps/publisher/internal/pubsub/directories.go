@@ -0,0 +1,57 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrNoDirectoryCallbacks is returned by AddDirectory and RemoveDirectory
+// before SetDirectoryCallbacks
+var ErrNoDirectoryCallbacks = errors.New("watched directories cannot be changed at runtime")
+
+// DirectoryCallbacks add and remove a watched directory of the running
+// publisher, e.g. through watcher.Watcher. Each returns once the change is
+// queued; the publisher then rescans and publishes the collection.
+type DirectoryCallbacks struct {
+	Add    func(dir string) error
+	Remove func(dir string) error
+}
+
+// SetDirectoryCallbacks sets the callbacks AddDirectory and RemoveDirectory run
+func (p *Publisher) SetDirectoryCallbacks(callbacks DirectoryCallbacks) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.directories = callbacks
+}
+
+// AddDirectory adds dir to the watched directories, which are then scanned and
+// the collection published again
+func (p *Publisher) AddDirectory(dir string) error {
+	p.mu.RLock()
+	add := p.directories.Add
+	p.mu.RUnlock()
+
+	if add == nil {
+		return ErrNoDirectoryCallbacks
+	}
+	if err := add(dir); err != nil {
+		return fmt.Errorf("failed to add directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// RemoveDirectory removes dir from the watched directories; the next scan
+// misses its files, so behavior.remove_missing drops them from the collection
+func (p *Publisher) RemoveDirectory(dir string) error {
+	p.mu.RLock()
+	remove := p.directories.Remove
+	p.mu.RUnlock()
+
+	if remove == nil {
+		return ErrNoDirectoryCallbacks
+	}
+	if err := remove(dir); err != nil {
+		return fmt.Errorf("failed to remove directory %s: %w", dir, err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package pubsub
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestDirectoryCallbacks(t *testing.T) {
+	p := NewPublisher(nil, newTestKey(t), &PublisherConfig{AnnounceInterval: time.Hour})
+	if err := p.AddDirectory("/music"); !errors.Is(err, ErrNoDirectoryCallbacks) {
+		t.Errorf("AddDirectory without callbacks = %v, want ErrNoDirectoryCallbacks", err)
+	}
+	if err := p.RemoveDirectory("/music"); !errors.Is(err, ErrNoDirectoryCallbacks) {
+		t.Errorf("RemoveDirectory without callbacks = %v, want ErrNoDirectoryCallbacks", err)
+	}
+
+	var added, removed []string
+	notWatched := errors.New("not watched")
+	p.SetDirectoryCallbacks(DirectoryCallbacks{
+		Add: func(dir string) error {
+			added = append(added, dir)
+			return nil
+		},
+		Remove: func(dir string) error {
+			if !slices.Contains(added, dir) {
+				return notWatched
+			}
+			removed = append(removed, dir)
+			return nil
+		},
+	})
+
+	if err := p.AddDirectory("/music"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.RemoveDirectory("/books"); !errors.Is(err, notWatched) {
+		t.Errorf("RemoveDirectory of an unwatched directory = %v, want the callback's error", err)
+	}
+	if err := p.RemoveDirectory("/music"); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(added, []string{"/music"}) || !slices.Equal(removed, []string{"/music"}) {
+		t.Errorf("added %v, removed %v; want /music each", added, removed)
+	}
+}
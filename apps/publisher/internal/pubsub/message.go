@@ -2,20 +2,42 @@ package pubsub
 
 import (
 	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"time"
 )
 
+// nonceSize is the number of random bytes in the nonce of an announcement
+const nonceSize = 16
+
+// nonceSource provides the nonces; tests replace it to sign reproducibly
+var nonceSource io.Reader = rand.Reader
+
 // AnnouncementMessage represents a collection announcement in PubSub
 type AnnouncementMessage struct {
-	Version        int    `json:"version"`        // Update counter
-	IPNS           string `json:"ipns"`           // IPNS hash
-	PublicKey      string `json:"publicKey"`      // Base64-encoded Ed25519 public key
-	CollectionSize int    `json:"collectionSize"` // Number of files in collection
-	Timestamp      int64  `json:"timestamp"`      // Unix timestamp
-	Signature      string `json:"signature"`      // Base64-encoded signature
+	Version        int      `json:"version"`               // Update counter
+	IPNS           string   `json:"ipns"`                  // IPNS hash
+	PublicKey      string   `json:"publicKey"`             // Base64-encoded Ed25519 public key
+	CollectionSize int      `json:"collectionSize"`        // Number of files in collection
+	Timestamp      int64    `json:"timestamp"`             // Unix timestamp
+	RootCID        string   `json:"rootCID,omitempty"`     // Collection root directory CID
+	IndexCID       string   `json:"indexCID,omitempty"`    // Index file CID inside the root directory
+	DeltaCID       string   `json:"deltaCID,omitempty"`    // Changes since the previous version (changes-v<N>.ndjson)
+	ManifestCID    string   `json:"manifestCID,omitempty"` // Signed manifest.json inside the root directory
+	Visibility     string   `json:"visibility,omitempty"`  // "unlisted" hides the collection from public search
+	License        string   `json:"license,omitempty"`     // License of the collection content
+	Mirrors        []string `json:"mirrors,omitempty"`     // Secondary IPNS names pointing at the same index
+	Nonce          string   `json:"nonce,omitempty"`       // Random hex set by Sign, so indexers can drop repeated deliveries
+	Signature      string   `json:"signature"`             // Base64-encoded signature
+
+	// TraceParent is the W3C trace context of the publish that announced the
+	// version, joining the indexer's spans to its trace. It is a transport
+	// field: not signed, and ignored by indexers without tracing.
+	TraceParent string `json:"traceparent,omitempty"`
 }
 
 // NewAnnouncementMessage creates a new announcement message
@@ -28,12 +50,19 @@ func NewAnnouncementMessage(version int, ipns string, collectionSize int, timest
 	}
 }
 
-// Sign signs the message with the provided private key
+// Sign signs the message with the provided private key. Every signature gets
+// a new nonce, so each announcement is told apart from copies of itself.
 func (m *AnnouncementMessage) Sign(privateKey ed25519.PrivateKey) error {
 	// Extract public key from private key
 	publicKey := privateKey.Public().(ed25519.PublicKey)
 	m.PublicKey = base64.StdEncoding.EncodeToString(publicKey)
 
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(nonceSource, nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	m.Nonce = hex.EncodeToString(nonce)
+
 	// Create message without signature for signing
 	data, err := m.getBytesForSigning()
 	if err != nil {
@@ -47,8 +76,19 @@ func (m *AnnouncementMessage) Sign(privateKey ed25519.PrivateKey) error {
 	return nil
 }
 
-// Verify verifies the message signature
+// KeyHistory returns the keys a publisher rotated away, e.g. by
+// keys.Manager.Rotate, looked up by its current base64 public key
+type KeyHistory func(publicKey string) ([]ed25519.PublicKey, error)
+
+// Verify verifies the message signature with the public key it carries
 func (m *AnnouncementMessage) Verify() error {
+	return m.VerifyWithHistory(nil)
+}
+
+// VerifyWithHistory verifies the message signature with the public key it
+// carries or, if that fails, with the keys history returns for that same key,
+// until the publisher's collections are announced again
+func (m *AnnouncementMessage) VerifyWithHistory(history KeyHistory) error {
 	// Decode public key
 	publicKeyBytes, err := base64.StdEncoding.DecodeString(m.PublicKey)
 	if err != nil {
@@ -74,28 +114,54 @@ func (m *AnnouncementMessage) Verify() error {
 	}
 
 	// Verify signature
-	if !ed25519.Verify(publicKey, data, signature) {
-		return fmt.Errorf("signature verification failed")
-	}
-
-	return nil
+	if ed25519.Verify(publicKey, data, signature) {
+		return nil
+	}
+	if history != nil {
+		historic, err := history(m.PublicKey)
+		if err != nil {
+			return fmt.Errorf("failed to look up key history: %w", err)
+		}
+		for _, key := range historic {
+			if ed25519.Verify(key, data, signature) {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("signature verification failed")
 }
 
 // getBytesForSigning returns the canonical JSON representation for signing
 func (m *AnnouncementMessage) getBytesForSigning() ([]byte, error) {
 	// Create a copy without signature
 	msg := struct {
-		Version        int    `json:"version"`
-		IPNS           string `json:"ipns"`
-		PublicKey      string `json:"publicKey"`
-		CollectionSize int    `json:"collectionSize"`
-		Timestamp      int64  `json:"timestamp"`
+		Version        int      `json:"version"`
+		IPNS           string   `json:"ipns"`
+		PublicKey      string   `json:"publicKey"`
+		CollectionSize int      `json:"collectionSize"`
+		Timestamp      int64    `json:"timestamp"`
+		RootCID        string   `json:"rootCID,omitempty"`
+		IndexCID       string   `json:"indexCID,omitempty"`
+		DeltaCID       string   `json:"deltaCID,omitempty"`
+		ManifestCID    string   `json:"manifestCID,omitempty"`
+		Visibility     string   `json:"visibility,omitempty"`
+		License        string   `json:"license,omitempty"`
+		Mirrors        []string `json:"mirrors,omitempty"`
+		Nonce          string   `json:"nonce,omitempty"`
 	}{
 		Version:        m.Version,
 		IPNS:           m.IPNS,
 		PublicKey:      m.PublicKey,
 		CollectionSize: m.CollectionSize,
 		Timestamp:      m.Timestamp,
+		RootCID:        m.RootCID,
+		IndexCID:       m.IndexCID,
+		DeltaCID:       m.DeltaCID,
+		ManifestCID:    m.ManifestCID,
+		Visibility:     m.Visibility,
+		License:        m.License,
+		Mirrors:        m.Mirrors,
+		Nonce:          m.Nonce,
 	}
 
 	return json.Marshal(msg)
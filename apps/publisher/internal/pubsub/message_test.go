@@ -0,0 +1,191 @@
+package pubsub
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/atregu/ipfs-publisher/internal/keys"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/")
+
+// testdataDir is the repository-wide fixture directory shared with the indexer
+const testdataDir = "../../../../testdata"
+
+// goldenAnnouncements returns the messages behind the announcement goldens
+func goldenAnnouncements() map[string]*AnnouncementMessage {
+	v2 := NewAnnouncementMessage(4, "k2k4r8ltgwjllr3n1on4rwis0kc853wzdcyjgt5xk2lcui5xn95c5vl2", 3, 1764264109)
+	v2.RootCID = "bafybeihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"
+	v2.IndexCID = "bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku"
+	v2.DeltaCID = "bafkreie5737gdxlw5i64vzichcalba3z2v5n6icifvx5xytvske7mr3hpm"
+	v2.ManifestCID = "bafkreih7uw3rnnnfpa37peu57tfewtp55olscct72wqs2lyzpccg23yxim"
+	v2.Visibility = "unlisted"
+	v2.License = "CC-BY-4.0"
+	v2.Mirrors = []string{"k2k4r8jl0yz8qjgqbmc2cdu5hkqek5rj6flgnlkyywynci20j0iuyfuj"}
+
+	return map[string]*AnnouncementMessage{
+		"announcement-v1.json": NewAnnouncementMessage(3, "k2k4r8ltgwjllr3n1on4rwis0kc853wzdcyjgt5xk2lcui5xn95c5vl2", 3, 1764260509),
+		"announcement-v2.json": v2,
+	}
+}
+
+func TestAnnouncementGolden(t *testing.T) {
+	keyManager := keys.New(filepath.Join(testdataDir, "keys"))
+	if err := keyManager.Initialize(); err != nil {
+		t.Fatalf("failed to load test key: %v", err)
+	}
+	key, err := keyManager.Get(keys.DefaultName)
+	if err != nil {
+		t.Fatalf("failed to load test key: %v", err)
+	}
+
+	defer func(source io.Reader) { nonceSource = source }(nonceSource)
+
+	for name, msg := range goldenAnnouncements() {
+		nonceSource = strings.NewReader(name) // Fixed nonce per golden
+		if err := msg.Sign(key); err != nil {
+			t.Fatalf("%s: failed to sign: %v", name, err)
+		}
+		data, err := msg.ToJSON()
+		if err != nil {
+			t.Fatalf("%s: failed to serialize: %v", name, err)
+		}
+
+		path := filepath.Join(testdataDir, name)
+		if *update {
+			if err := os.WriteFile(path, data, 0644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+
+		golden, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, golden) {
+			t.Errorf("%s does not match the signed message; run go test -update if the format changed\ngot:  %s\nwant: %s", name, data, golden)
+		}
+
+		parsed, err := FromJSON(golden)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if err := parsed.Verify(); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestSignSetsNewNonce(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := NewAnnouncementMessage(1, "k51test", 0, 1764260509)
+	if err := msg.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	first := msg.Nonce
+	if len(first) != 2*nonceSize {
+		t.Errorf("nonce %q has %d hex digits, want %d", first, len(first), 2*nonceSize)
+	}
+	if err := msg.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Nonce == first {
+		t.Error("signing again kept the nonce")
+	}
+	if err := msg.Verify(); err != nil {
+		t.Error(err)
+	}
+
+	// The nonce is signed
+	msg.Nonce = first
+	if err := msg.Verify(); err == nil {
+		t.Error("expected message with a replaced nonce to fail verification")
+	}
+}
+
+func TestVerifyWithFallbackKeys(t *testing.T) {
+	dir := t.TempDir()
+	keyManager := keys.New(dir)
+	if err := keyManager.Initialize(); err != nil {
+		t.Fatal(err)
+	}
+	old, err := keyManager.Get(keys.DefaultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	current, err := keyManager.Rotate(keys.DefaultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Naming the publisher's current key, but signed with the rotated one
+	msg := NewAnnouncementMessage(1, "k51", 0, 1)
+	if err := msg.Sign(old); err != nil {
+		t.Fatal(err)
+	}
+	msg.PublicKey = base64.StdEncoding.EncodeToString(current)
+	data, err := msg.getBytesForSigning()
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(old, data))
+
+	if err := msg.Verify(); err == nil {
+		t.Error("expected a signature of the rotated key to fail without fallback")
+	}
+	historic, err := keyManager.LoadHistoric(keys.DefaultName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history := func(publicKey string) ([]ed25519.PublicKey, error) {
+		if publicKey != msg.PublicKey {
+			return nil, nil
+		}
+		return historic, nil
+	}
+	if err := msg.VerifyWithHistory(history); err != nil {
+		t.Errorf("VerifyWithHistory with the historic keys: %v", err)
+	}
+
+	// The same keys do not vouch for a message naming another publisher
+	other := NewAnnouncementMessage(1, "k51", 0, 1)
+	other.PublicKey = base64.StdEncoding.EncodeToString(make([]byte, ed25519.PublicKeySize))
+	data, err = other.getBytesForSigning()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(old, data))
+	if err := other.VerifyWithHistory(history); err == nil {
+		t.Error("expected the historic keys of another publisher to fail verification")
+	}
+}
+
+func TestVerifyRejectsTamperedMessage(t *testing.T) {
+	golden, err := os.ReadFile(filepath.Join(testdataDir, "announcement-v2.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := FromJSON(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg.License = "All rights reserved"
+
+	if err := msg.Verify(); err == nil {
+		t.Error("expected tampered message to fail verification")
+	}
+}
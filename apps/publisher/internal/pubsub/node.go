@@ -2,8 +2,12 @@ package pubsub
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"time"
+
+	"github.com/atregu/ipfs-common/dedupe"
 
 	"github.com/atregu/ipfs-publisher/internal/logger"
 
@@ -14,6 +18,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/p2p/discovery/routing"
 	"github.com/libp2p/go-libp2p/p2p/discovery/util"
+	rcmgr "github.com/libp2p/go-libp2p/p2p/host/resource-manager"
 	"github.com/multiformats/go-multiaddr"
 )
 
@@ -26,15 +31,31 @@ type Node struct {
 	cancel    context.CancelFunc
 	topic     *pubsub.Topic
 	topicName string
+	tracer    publishTracer
 	mu        sync.Mutex
 	started   bool
+
+	bootstrapMu    sync.Mutex
+	bootstrapPeers map[string]*BootstrapPeerStatus
+	bootstrapOrder []string
+	bootstrapByID  map[peer.ID]*bootstrapPeer
 }
 
+// ErrEmbeddedNodeActive is returned by Start when the process also runs an embedded
+// IPFS node, whose PubSub must be used instead of a second libp2p host
+var ErrEmbeddedNodeActive = errors.New("standalone PubSub node refused: an embedded IPFS node is running, publish through its PubSub instead")
+
+// standaloneMaxFDs bounds the file descriptors the standalone node's resource manager allows
+const standaloneMaxFDs = 512
+
 // Config holds PubSub node configuration
 type Config struct {
-	Topic          string   // PubSub topic name
-	ListenPort     int      // Port to listen on (0 = random)
-	BootstrapPeers []string // Bootstrap peer multiaddrs
+	Topic           string   // PubSub topic name
+	ListenPort      int      // Port of the default listen addresses (0 = random)
+	ListenAddresses []string // Multiaddrs to listen on, all of which must bind (empty = DefaultListenAddresses)
+	BootstrapPeers  []string // Bootstrap peer multiaddrs
+	MaxMemory       int64    // Resource manager memory ceiling in bytes (0 = libp2p default)
+	EmbeddedIPFS    bool     // An embedded IPFS node runs in this process; Start refuses to run
 }
 
 // NewNode creates a new PubSub node
@@ -59,25 +80,47 @@ func (n *Node) Start(cfg *Config) error {
 		return fmt.Errorf("node already started")
 	}
 
+	// Two libp2p hosts in one process exhaust memory on small devices
+	if cfg.EmbeddedIPFS {
+		return ErrEmbeddedNodeActive
+	}
+
 	log := logger.Get()
 	log.Info("Starting PubSub node...")
 
-	// Create listen address
-	listenAddr := fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", cfg.ListenPort)
-
-	// Create libp2p host
-	h, err := libp2p.New(
-		libp2p.ListenAddrStrings(listenAddr),
+	// The host binds its addresses in listen, one at a time, so a failure names the address
+	opts := []libp2p.Option{
+		libp2p.NoListenAddrs,
 		libp2p.DefaultSecurity,
 		libp2p.NATPortMap(),
-	)
+	}
+
+	// Cap the resource manager's memory if configured
+	if cfg.MaxMemory > 0 {
+		limits := rcmgr.DefaultLimits
+		libp2p.SetDefaultServiceLimits(&limits)
+		rm, err := rcmgr.NewResourceManager(rcmgr.NewFixedLimiter(limits.Scale(cfg.MaxMemory, standaloneMaxFDs)))
+		if err != nil {
+			return fmt.Errorf("failed to create resource manager: %w", err)
+		}
+		opts = append(opts, libp2p.ResourceManager(rm))
+		log.Infof("PubSub node memory limited to %d bytes", cfg.MaxMemory)
+	}
+
+	// Create libp2p host
+	h, err := libp2p.New(opts...)
 	if err != nil {
 		return fmt.Errorf("failed to create libp2p host: %w", err)
 	}
 	n.host = h
 
+	if err := n.listen(cfg); err != nil {
+		h.Close()
+		return err
+	}
+
 	log.Infof("PubSub node started with Peer ID: %s", h.ID())
-	log.Infof("Listening on: %v", h.Addrs())
+	log.Infof("Listening on: %v", h.Network().ListenAddresses())
 
 	// Create DHT for peer discovery
 	dhtInstance, err := dht.New(n.ctx, h)
@@ -93,13 +136,13 @@ func (n *Node) Start(cfg *Config) error {
 		return fmt.Errorf("failed to bootstrap DHT: %w", err)
 	}
 
-	// Connect to bootstrap peers
+	// Connect to bootstrap peers (failed peers are retried in the background)
 	if err := n.connectBootstrapPeers(cfg.BootstrapPeers); err != nil {
-		log.Warnf("Failed to connect to some bootstrap peers: %v", err)
+		log.Warnf("Failed to connect to bootstrap peers, will keep retrying: %v", err)
 	}
 
 	// Create PubSub instance with GossipSub
-	ps, err := pubsub.NewGossipSub(n.ctx, h)
+	ps, err := pubsub.NewGossipSub(n.ctx, h, pubsub.WithEventTracer(&n.tracer))
 	if err != nil {
 		h.Close()
 		return fmt.Errorf("failed to create GossipSub: %w", err)
@@ -123,61 +166,6 @@ func (n *Node) Start(cfg *Config) error {
 	return nil
 }
 
-// connectBootstrapPeers connects to bootstrap peers
-func (n *Node) connectBootstrapPeers(bootstrapPeers []string) error {
-	log := logger.Get()
-
-	// Use default IPFS bootstrap peers if none provided
-	if len(bootstrapPeers) == 0 {
-		// Convert default bootstrap peers to strings
-		for _, maddr := range dht.DefaultBootstrapPeers {
-			bootstrapPeers = append(bootstrapPeers, maddr.String())
-		}
-	}
-
-	var wg sync.WaitGroup
-	successCount := 0
-	mu := sync.Mutex{}
-
-	for _, peerAddr := range bootstrapPeers {
-		wg.Add(1)
-		go func(addr string) {
-			defer wg.Done()
-
-			maddr, err := multiaddr.NewMultiaddr(addr)
-			if err != nil {
-				log.Debugf("Invalid bootstrap peer address %s: %v", addr, err)
-				return
-			}
-
-			peerInfo, err := peer.AddrInfoFromP2pAddr(maddr)
-			if err != nil {
-				log.Debugf("Failed to parse peer info from %s: %v", addr, err)
-				return
-			}
-
-			if err := n.host.Connect(n.ctx, *peerInfo); err != nil {
-				log.Debugf("Failed to connect to bootstrap peer %s: %v", peerInfo.ID, err)
-				return
-			}
-
-			mu.Lock()
-			successCount++
-			mu.Unlock()
-			log.Debugf("Connected to bootstrap peer: %s", peerInfo.ID)
-		}(peerAddr)
-	}
-
-	wg.Wait()
-	log.Infof("Connected to %d bootstrap peers", successCount)
-
-	if successCount == 0 {
-		return fmt.Errorf("failed to connect to any bootstrap peers")
-	}
-
-	return nil
-}
-
 // discoverPeers continuously discovers peers on the topic
 func (n *Node) discoverPeers() {
 	log := logger.Get()
@@ -211,24 +199,31 @@ func (n *Node) discoverPeers() {
 	}
 }
 
-// Publish publishes a message to the topic
-func (n *Node) Publish(data []byte) error {
+// Name returns the transport name of the standalone node
+func (n *Node) Name() string {
+	return "standalone"
+}
+
+// Publish publishes a message to the topic and returns its gossipsub message ID (hex)
+func (n *Node) Publish(data []byte) (string, error) {
 	n.mu.Lock()
 	defer n.mu.Unlock()
 
 	if !n.started {
-		return fmt.Errorf("node not started")
+		return "", fmt.Errorf("node not started")
 	}
 
 	if n.topic == nil {
-		return fmt.Errorf("topic not joined")
+		return "", fmt.Errorf("topic not joined")
 	}
 
+	// A publish failing before it is traced must not report the previous message's ID
+	n.tracer.take(n.topicName)
 	if err := n.topic.Publish(n.ctx, data); err != nil {
-		return fmt.Errorf("failed to publish message: %w", err)
+		return "", fmt.Errorf("failed to publish message: %w", err)
 	}
 
-	return nil
+	return n.tracer.take(n.topicName), nil
 }
 
 // Subscribe subscribes to the topic and returns a subscription
@@ -252,6 +247,89 @@ func (n *Node) Subscribe() (*pubsub.Subscription, error) {
 	return sub, nil
 }
 
+// SubscribeDeduped subscribes to the topic and delivers the payload of each
+// message once: copies with the same content within window after the first,
+// e.g. forwarded by several mesh peers, are dropped. Messages are delivered
+// until the node stops.
+func (n *Node) SubscribeDeduped(window time.Duration) (<-chan []byte, error) {
+	sub, err := n.Subscribe()
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make(chan []byte, topicQueueSize)
+	go func() {
+		defer close(messages)
+		defer sub.Cancel()
+
+		for {
+			msg, err := sub.Next(n.ctx)
+			if err != nil {
+				return
+			}
+
+			select {
+			case messages <- msg.Data:
+			case <-n.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return dedupe.Filter(n.ctx, messages, window), nil
+}
+
+// topicQueueSize is the number of received messages buffered per SubscribeTopic subscription
+const topicQueueSize = 32
+
+// SubscribeTopic joins another topic and delivers the messages other peers publish
+// to it until ctx is done or the node stops
+func (n *Node) SubscribeTopic(ctx context.Context, topicName string) (<-chan []byte, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if !n.started {
+		return nil, fmt.Errorf("node not started")
+	}
+
+	topic, err := n.ps.Join(topicName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to join topic %s: %w", topicName, err)
+	}
+
+	sub, err := topic.Subscribe()
+	if err != nil {
+		topic.Close()
+		return nil, fmt.Errorf("failed to subscribe to topic %s: %w", topicName, err)
+	}
+
+	self := n.host.ID()
+	messages := make(chan []byte, topicQueueSize)
+	go func() {
+		defer close(messages)
+		defer topic.Close()
+		defer sub.Cancel()
+
+		for {
+			msg, err := sub.Next(ctx)
+			if err != nil {
+				return
+			}
+			if msg.ReceivedFrom == self {
+				continue
+			}
+
+			select {
+			case messages <- msg.Data:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return messages, nil
+}
+
 // GetPeerCount returns the number of connected peers
 func (n *Node) GetPeerCount() int {
 	if n.host == nil {
@@ -271,6 +349,22 @@ func (n *Node) GetTopicPeerCount() int {
 	return len(n.topic.ListPeers())
 }
 
+// GetTopicPeers returns the IDs of the peers on the topic
+func (n *Node) GetTopicPeers() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.topic == nil {
+		return nil
+	}
+	peers := n.topic.ListPeers()
+	ids := make([]string, len(peers))
+	for i, id := range peers {
+		ids[i] = id.String()
+	}
+	return ids
+}
+
 // GetPeerID returns the node's peer ID
 func (n *Node) GetPeerID() string {
 	if n.host == nil {
@@ -279,13 +373,60 @@ func (n *Node) GetPeerID() string {
 	return n.host.ID().String()
 }
 
-// GetListenAddresses returns the node's listen addresses
+// DefaultListenAddresses returns the listen addresses of a node without
+// configured ones: every IPv4 and IPv6 interface on port
+func DefaultListenAddresses(port int) []string {
+	return []string{
+		fmt.Sprintf("/ip4/0.0.0.0/tcp/%d", port),
+		fmt.Sprintf("/ip6/::/tcp/%d", port),
+	}
+}
+
+// listen binds the host to the configured listen addresses, all of which must
+// bind. Of the default addresses one is enough, so hosts without IPv6 still start.
+func (n *Node) listen(cfg *Config) error {
+	log := logger.Get()
+
+	addrs, required := cfg.ListenAddresses, true
+	if len(addrs) == 0 {
+		addrs, required = DefaultListenAddresses(cfg.ListenPort), false
+	}
+
+	var errs []error
+	for _, s := range addrs {
+		addr, err := multiaddr.NewMultiaddr(s)
+		if err == nil {
+			err = n.host.Network().Listen(addr)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to listen on %s: %w", s, err)
+			if required {
+				return err
+			}
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == len(addrs) {
+		return errors.Join(errs...)
+	}
+	for _, err := range errs {
+		log.Warnf("PubSub node: %v", err)
+	}
+	return nil
+}
+
+// GetListenAddresses returns the addresses the node is bound to, with
+// unspecified IPs expanded to the interface addresses
 func (n *Node) GetListenAddresses() []string {
 	if n.host == nil {
 		return nil
 	}
 
-	addrs := n.host.Addrs()
+	addrs, err := n.host.Network().InterfaceListenAddresses()
+	if err != nil {
+		addrs = n.host.Network().ListenAddresses()
+	}
 	result := make([]string, 0, len(addrs))
 
 	for _, addr := range addrs {
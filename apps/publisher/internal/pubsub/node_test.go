@@ -0,0 +1,98 @@
+package pubsub
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+func TestListenAddressesBound(t *testing.T) {
+	logger.Get().SetOutput(io.Discard)
+
+	cfg := &Config{Topic: "mdn/test/announce", ListenAddresses: []string{"/ip4/127.0.0.1/tcp/0"}}
+	node, err := NewNode(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := node.Start(cfg); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	defer node.Stop()
+
+	addrs := node.GetListenAddresses()
+	if len(addrs) != 1 || !strings.HasPrefix(addrs[0], "/ip4/127.0.0.1/tcp/") || strings.HasPrefix(addrs[0], "/ip4/127.0.0.1/tcp/0/") {
+		t.Errorf("listen addresses = %v, want the bound loopback port only", addrs)
+	}
+}
+
+func TestListenFailureNamesAddress(t *testing.T) {
+	logger.Get().SetOutput(io.Discard)
+
+	// 192.0.2.0/24 is reserved for documentation and assigned to no interface
+	const unbindable = "/ip4/192.0.2.1/tcp/0"
+	cfg := &Config{Topic: "mdn/test/announce", ListenAddresses: []string{"/ip4/127.0.0.1/tcp/0", unbindable}}
+	node, err := NewNode(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = node.Start(cfg)
+	if err == nil {
+		node.Stop()
+		t.Fatal("Start succeeded with an unbindable address")
+	}
+	if !strings.Contains(err.Error(), unbindable) {
+		t.Errorf("Start = %v, want the failing address named", err)
+	}
+}
+
+func TestSubscribeDedupedDeliversOnce(t *testing.T) {
+	sender := startTestNode(t, nil)
+	var addr string
+	for _, a := range sender.GetListenAddresses() {
+		if strings.HasPrefix(a, "/ip4/127.0.0.1/") {
+			addr = a
+		}
+	}
+	receiver := startTestNode(t, []string{addr})
+	messages, err := receiver.SubscribeDeduped(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !waitFor(20*time.Second, func() bool { return sender.GetTopicPeerCount() > 0 }) {
+		t.Fatal("receiver did not join the topic mesh")
+	}
+
+	// Two goroutines publish the same payload, then a different one follows
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := sender.Publish([]byte("announcement")); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := sender.Publish([]byte("last")); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	timeout := time.After(20 * time.Second)
+	for len(got) == 0 || got[len(got)-1] != "last" {
+		select {
+		case data := <-messages:
+			got = append(got, string(data))
+		case <-timeout:
+			t.Fatalf("received %q before timing out, want the last payload", got)
+		}
+	}
+	if len(got) != 2 || got[0] != "announcement" {
+		t.Errorf("received %q, want the repeated payload once", got)
+	}
+}
@@ -12,33 +12,111 @@ import (
 // Publisher handles publishing announcements to PubSub
 type Publisher struct {
 	node             *Node
+	transports       []Transport
 	privateKey       ed25519.PrivateKey
 	currentVersion   int
 	currentIPNS      string
-	collectionSize   int
+	rootCID          string
+	indexCID         string
+	deltaCID         string
+	manifestCID      string
+	visibility       string
+	license          string
+	mirrors          []string
+	collectionSize   int // Records in the announced index, as counted when it was published
 	lastTimestamp    int64
+	traceParent      string // Trace context of the publish that announced the current version
 	announceInterval time.Duration
 	ticker           *time.Ticker
 	stopChan         chan struct{}
+	ackRecorder      AckRecorder // nil until ListenForAcks
+	ackWarnAfter     int
+	unackedCount     int
+	retryInterval    time.Duration
+	queuedVersion    int         // Announcement waiting for topic peers; 0 = none
+	queueRetries     int         // Retries of the queued announcement so far
+	queueTimer       *time.Timer // Pending retry of the queued announcement
+	answerDelay      time.Duration
+	answerTimer      *time.Timer // Pending answer to an indexer query
+	lastAnswer       time.Time
+	reach            *ReachTracker // nil unless SetReachTracker was called
+	sends            SendLog       // Recent sends on every channel
+	directories      DirectoryCallbacks
 	mu               sync.RWMutex
 	started          bool
 }
 
+// An announcement published while the topic has no peers (e.g. right after startup,
+// before discovery completed) is retried every queueRetryInterval until a peer
+// is present, up to queueMaxRetries times; after that the next periodic
+// announcement tries again.
+const (
+	queueRetryInterval = 30 * time.Second
+	queueMaxRetries    = 20
+)
+
 // PublisherConfig holds publisher configuration
 type PublisherConfig struct {
 	AnnounceInterval time.Duration // How often to repeat announcements
+	AckWarnAfter     int           // Periodic announcements without any ack before warning; 0 = never
 }
 
-// NewPublisher creates a new publisher
+// NewPublisher creates a new publisher. node may be nil when announcements are
+// only published through transports, e.g. the embedded IPFS node's PubSub.
 func NewPublisher(node *Node, privateKey ed25519.PrivateKey, cfg *PublisherConfig) *Publisher {
 	return &Publisher{
 		node:             node,
 		privateKey:       privateKey,
 		announceInterval: cfg.AnnounceInterval,
+		ackWarnAfter:     cfg.AckWarnAfter,
+		retryInterval:    queueRetryInterval,
+		answerDelay:      queryMaxDelay,
 		stopChan:         make(chan struct{}),
 	}
 }
 
+// SetCollectionMeta sets the visibility and license carried in every announcement
+func (p *Publisher) SetCollectionMeta(visibility, license string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Public is the default and is left out to keep messages compact
+	if visibility == "public" {
+		visibility = ""
+	}
+	p.visibility = visibility
+	p.license = license
+}
+
+// SetMirrors sets the mirror IPNS names carried in every announcement
+func (p *Publisher) SetMirrors(names []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.mirrors = names
+}
+
+// SetManifestCID sets the manifest CID carried in every announcement; empty sends none
+func (p *Publisher) SetManifestCID(cid string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.manifestCID = cid
+}
+
+// SetReachTracker records the topic peers present at every announcement in tracker
+func (p *Publisher) SetReachTracker(tracker *ReachTracker) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reach = tracker
+}
+
+// AddTransport registers an additional channel every announcement is published through
+// (e.g. the external daemon's PubSub alongside the standalone node)
+func (p *Publisher) AddTransport(t Transport) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.transports = append(p.transports, t)
+}
+
 // Start starts the periodic announcement loop
 func (p *Publisher) Start() error {
 	p.mu.Lock()
@@ -66,15 +144,19 @@ func (p *Publisher) announceLoop() {
 	for {
 		select {
 		case <-p.ticker.C:
+			// Announce if we have either IPNS or just a version/collection.
+			// The read lock is released first, publishCurrent takes the write lock.
 			p.mu.RLock()
-			// Announce if we have either IPNS or just a version/collection
-			if p.currentIPNS != "" || p.currentVersion > 0 {
+			ready := p.currentIPNS != "" || p.currentVersion > 0
+			p.mu.RUnlock()
+			if ready {
 				log.Debug("Periodic announcement triggered")
 				if err := p.publishCurrent(); err != nil {
 					log.Errorf("Failed to publish periodic announcement: %v", err)
+				} else {
+					p.countHeartbeat()
 				}
 			}
-			p.mu.RUnlock()
 
 		case <-p.stopChan:
 			log.Debug("Announcement loop stopped")
@@ -85,6 +167,18 @@ func (p *Publisher) announceLoop() {
 
 // Announce publishes a new announcement (increments version)
 func (p *Publisher) Announce(ipns string, collectionSize int) error {
+	return p.AnnounceIndex(ipns, collectionSize, "", "")
+}
+
+// AnnounceIndex publishes a new announcement that also carries the collection
+// root directory CID and the index file CID (increments version)
+func (p *Publisher) AnnounceIndex(ipns string, collectionSize int, rootCID, indexCID string) error {
+	return p.AnnounceIndexDelta(ipns, collectionSize, rootCID, indexCID, "")
+}
+
+// AnnounceIndexDelta is AnnounceIndex with the CID of the delta file describing
+// the changes since the previous version (increments version)
+func (p *Publisher) AnnounceIndexDelta(ipns string, collectionSize int, rootCID, indexCID, deltaCID string) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -94,6 +188,9 @@ func (p *Publisher) Announce(ipns string, collectionSize int) error {
 	p.currentVersion++
 	p.currentIPNS = ipns
 	p.collectionSize = collectionSize
+	p.rootCID = rootCID
+	p.indexCID = indexCID
+	p.deltaCID = deltaCID
 	p.lastTimestamp = time.Now().Unix()
 
 	log.Infof("Publishing announcement: version=%d, IPNS=%s, size=%d",
@@ -102,6 +199,35 @@ func (p *Publisher) Announce(ipns string, collectionSize int) error {
 	return p.publishCurrentLocked()
 }
 
+// SetTraceParent sets the W3C trace context sent with the next new version and
+// its repetitions (see tracing.Inject); empty sends none
+func (p *Publisher) SetTraceParent(traceParent string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.traceParent = traceParent
+}
+
+// Resume restores the announced collection from a previous run without publishing,
+// so versions continue from the persisted state instead of restarting at 1
+func (p *Publisher) Resume(version int, ipns string, collectionSize int, rootCID, indexCID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.currentVersion = version
+	p.currentIPNS = ipns
+	p.collectionSize = collectionSize
+	p.rootCID = rootCID
+	p.indexCID = indexCID
+	p.deltaCID = ""
+	p.lastTimestamp = time.Now().Unix()
+	p.traceParent = ""
+}
+
+// AnnounceCurrent publishes the current announcement again without changing version
+func (p *Publisher) AnnounceCurrent() error {
+	return p.publishCurrent()
+}
+
 // publishCurrent publishes the current state without changing version
 func (p *Publisher) publishCurrent() error {
 	p.mu.Lock()
@@ -109,8 +235,29 @@ func (p *Publisher) publishCurrent() error {
 	return p.publishCurrentLocked()
 }
 
-// publishCurrentLocked publishes without locking (caller must hold lock)
+// publishCurrentLocked publishes without locking (caller must hold lock) and
+// queues the announcement for retry if the topic has no peers
 func (p *Publisher) publishCurrentLocked() error {
+	if err := p.sendCurrentLocked(); err != nil {
+		return err
+	}
+	p.queueIfNoPeersLocked()
+	p.recordReachLocked()
+	return nil
+}
+
+// recordReachLocked records the topic peers the announcement just published reached
+func (p *Publisher) recordReachLocked() {
+	if p.reach == nil {
+		return
+	}
+	if peers, known := p.topicPeerIDsLocked(); known {
+		p.reach.Record(peers, time.Now())
+	}
+}
+
+// sendCurrentLocked signs the current announcement and publishes it on every channel
+func (p *Publisher) sendCurrentLocked() error {
 	// Require IPNS before publishing
 	if p.currentVersion == 0 {
 		return fmt.Errorf("no announcement to publish (version 0)")
@@ -128,6 +275,14 @@ func (p *Publisher) publishCurrentLocked() error {
 		p.collectionSize,
 		p.lastTimestamp,
 	)
+	msg.RootCID = p.rootCID
+	msg.IndexCID = p.indexCID
+	msg.DeltaCID = p.deltaCID
+	msg.ManifestCID = p.manifestCID
+	msg.Visibility = p.visibility
+	msg.License = p.license
+	msg.Mirrors = p.mirrors
+	msg.TraceParent = p.traceParent
 
 	// Sign message
 	if err := msg.Sign(p.privateKey); err != nil {
@@ -140,18 +295,203 @@ func (p *Publisher) publishCurrentLocked() error {
 		return fmt.Errorf("failed to serialize message: %w", err)
 	}
 
+	hash := messageHash(data)
+
+	// Without a standalone node only the transports are used
+	if p.node == nil {
+		if len(p.transports) == 0 {
+			return fmt.Errorf("no PubSub node or transport configured")
+		}
+		return p.publishTransportsLocked(data, hash, false)
+	}
+
 	// Publish to PubSub
-	if err := p.node.Publish(data); err != nil {
-		return fmt.Errorf("failed to publish to PubSub: %w", err)
+	id, err := p.node.Publish(data)
+	if err != nil {
+		p.recordSendLocked(Send{Channel: p.node.Name(), Hash: hash, Error: err.Error()})
+		if len(p.transports) == 0 {
+			return fmt.Errorf("failed to publish to PubSub: %w", err)
+		}
+		log.Warnf("Failed to publish announcement via %s: %v", p.node.Name(), err)
+	} else {
+		peerCount := p.node.GetTopicPeerCount()
+		p.recordSendLocked(Send{Channel: p.node.Name(), TopicPeers: &peerCount, MessageID: id, Hash: hash})
+		log.Infof("✓ Published announcement (version %d) via %s to %d peers on topic: message %s, hash %s",
+			p.currentVersion, p.node.Name(), peerCount, id, hash)
+		return p.publishTransportsLocked(data, hash, true)
 	}
 
-	peerCount := p.node.GetTopicPeerCount()
-	log.Infof("✓ Published announcement (version %d) to %d peers on topic",
-		p.currentVersion, peerCount)
+	return p.publishTransportsLocked(data, hash, false)
+}
+
+// publishTransportsLocked publishes data through the additional transports.
+// A failure on one channel never suppresses the others; an error is returned
+// only if no channel (including the node, per delivered) succeeded.
+func (p *Publisher) publishTransportsLocked(data []byte, hash string, delivered bool) error {
+	log := logger.Get()
 
+	var lastErr error
+	for _, t := range p.transports {
+		if err := t.Publish(data); err != nil {
+			p.recordSendLocked(Send{Channel: t.Name(), Hash: hash, Error: err.Error()})
+			log.Warnf("Failed to publish announcement via %s: %v", t.Name(), err)
+			lastErr = err
+			continue
+		}
+
+		send := Send{Channel: t.Name(), Hash: hash}
+		peers := "unknown"
+		if counter, ok := t.(PeerCounter); ok {
+			if count, ok := counter.TopicPeerCount(); ok {
+				send.TopicPeers = &count
+				peers = fmt.Sprint(count)
+			}
+		}
+		p.recordSendLocked(send)
+		log.Infof("✓ Published announcement (version %d) via %s to %s peers on topic: hash %s", p.currentVersion, t.Name(), peers, hash)
+		delivered = true
+	}
+
+	if !delivered {
+		return fmt.Errorf("failed to publish announcement on any transport: %w", lastErr)
+	}
 	return nil
 }
 
+// recordSendLocked adds a send of the current announcement to the send log
+func (p *Publisher) recordSendLocked(send Send) {
+	send.Time = time.Now()
+	send.Version = p.currentVersion
+	p.sends.Record(send)
+}
+
+// SendLog returns the log of recent sends
+func (p *Publisher) SendLog() *SendLog {
+	return &p.sends
+}
+
+// topicPeersLocked returns the number of topic peers seen by the node and the
+// transports that can count them; known is false if none of them can
+func (p *Publisher) topicPeersLocked() (peers int, known bool) {
+	if p.node != nil {
+		peers += p.node.GetTopicPeerCount()
+		known = true
+	}
+	for _, t := range p.transports {
+		counter, ok := t.(PeerCounter)
+		if !ok {
+			continue
+		}
+		if count, ok := counter.TopicPeerCount(); ok {
+			peers += count
+			known = true
+		}
+	}
+	return peers, known
+}
+
+// topicPeerIDsLocked returns the distinct topic peers seen by the node and the
+// transports that can list them; known is false if none of them can
+func (p *Publisher) topicPeerIDsLocked() (peers []string, known bool) {
+	seen := make(map[string]bool)
+	add := func(ids []string) {
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				peers = append(peers, id)
+			}
+		}
+	}
+
+	if p.node != nil {
+		add(p.node.GetTopicPeers())
+		known = true
+	}
+	for _, t := range p.transports {
+		lister, ok := t.(PeerLister)
+		if !ok {
+			continue
+		}
+		if ids, ok := lister.TopicPeers(); ok {
+			add(ids)
+			known = true
+		}
+	}
+	return peers, known
+}
+
+// queueIfNoPeersLocked queues the just published announcement for retry if the
+// topic has no peers, and clears the queue once it has
+func (p *Publisher) queueIfNoPeersLocked() {
+	log := logger.Get()
+
+	peers, known := p.topicPeersLocked()
+	if !known {
+		return
+	}
+	if peers > 0 {
+		if p.queuedVersion != 0 {
+			log.Infof("✓ Topic has %d peers; queued announcement version %d delivered", peers, p.currentVersion)
+		}
+		p.clearQueueLocked()
+		return
+	}
+
+	if p.queuedVersion != p.currentVersion {
+		if p.queuedVersion != 0 {
+			log.Infof("Queued announcement version %d superseded by version %d", p.queuedVersion, p.currentVersion)
+		} else {
+			log.Warnf("No peers on topic yet; announcement version %d queued, retrying every %v", p.currentVersion, p.retryInterval)
+		}
+		p.queuedVersion = p.currentVersion
+		p.queueRetries = 0
+	}
+	if p.queueTimer == nil {
+		p.queueTimer = time.AfterFunc(p.retryInterval, p.retryQueued)
+	}
+}
+
+// retryQueued publishes the queued announcement again once the topic has peers
+func (p *Publisher) retryQueued() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.queueTimer = nil
+	if !p.started || p.queuedVersion == 0 {
+		return
+	}
+
+	log := logger.Get()
+
+	// The newest announcement is published, superseding the queued one
+	if peers, _ := p.topicPeersLocked(); peers > 0 {
+		err := p.publishCurrentLocked()
+		if err == nil {
+			return
+		}
+		log.Warnf("Failed to publish queued announcement version %d: %v", p.currentVersion, err)
+	}
+
+	p.queueRetries++
+	if p.queueRetries >= queueMaxRetries {
+		log.Warnf("Topic still has no peers after %d retries; announcement version %d waits for the next periodic announcement",
+			p.queueRetries, p.queuedVersion)
+		p.clearQueueLocked()
+		return
+	}
+	p.queueTimer = time.AfterFunc(p.retryInterval, p.retryQueued)
+}
+
+// clearQueueLocked drops the queued announcement and its pending retry
+func (p *Publisher) clearQueueLocked() {
+	if p.queueTimer != nil {
+		p.queueTimer.Stop()
+		p.queueTimer = nil
+	}
+	p.queuedVersion = 0
+	p.queueRetries = 0
+}
+
 // GetCurrentVersion returns the current version number
 func (p *Publisher) GetCurrentVersion() int {
 	p.mu.RLock()
@@ -166,6 +506,13 @@ func (p *Publisher) GetCurrentIPNS() string {
 	return p.currentIPNS
 }
 
+// GetCollectionSize returns the collection size carried by the current announcement
+func (p *Publisher) GetCollectionSize() int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.collectionSize
+}
+
 // Stop stops the publisher
 func (p *Publisher) Stop() error {
 	p.mu.Lock()
@@ -182,6 +529,11 @@ func (p *Publisher) Stop() error {
 		p.ticker.Stop()
 	}
 
+	p.clearQueueLocked()
+	if p.answerTimer != nil {
+		p.answerTimer.Stop()
+		p.answerTimer = nil
+	}
 	close(p.stopChan)
 	p.started = false
 
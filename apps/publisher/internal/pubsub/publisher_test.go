@@ -0,0 +1,242 @@
+package pubsub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingTransport records published announcements and reports a settable topic peer count
+type countingTransport struct {
+	mu        sync.Mutex
+	peers     int
+	published []*AnnouncementMessage
+	data      [][]byte // Serialized form of published
+}
+
+func (t *countingTransport) Name() string {
+	return "counting"
+}
+
+func (t *countingTransport) Publish(data []byte) error {
+	msg, err := FromJSON(data)
+	if err != nil {
+		return err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.published = append(t.published, msg)
+	t.data = append(t.data, data)
+	return nil
+}
+
+func (t *countingTransport) TopicPeerCount() (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.peers, true
+}
+
+func (t *countingTransport) TopicPeers() ([]string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	peers := make([]string, t.peers)
+	for i := range peers {
+		peers[i] = fmt.Sprintf("12D3Peer%d", i)
+	}
+	return peers, true
+}
+
+func (t *countingTransport) setPeers(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.peers = n
+}
+
+func (t *countingTransport) versions() []int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	versions := make([]int, len(t.published))
+	for i, msg := range t.published {
+		versions[i] = msg.Version
+	}
+	return versions
+}
+
+// startTestPublisher starts a publisher that retries queued announcements every 50ms
+func startTestPublisher(t *testing.T, node *Node) *Publisher {
+	t.Helper()
+
+	p := NewPublisher(node, newTestKey(t), &PublisherConfig{AnnounceInterval: time.Hour})
+	p.retryInterval = 50 * time.Millisecond
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { p.Stop() })
+	return p
+}
+
+// waitFor polls cond until it holds or the timeout passes
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+func TestAnnouncementQueuedUntilTopicHasPeers(t *testing.T) {
+	transport := &countingTransport{}
+	p := startTestPublisher(t, nil)
+	p.AddTransport(transport)
+
+	if err := p.Announce("k51test", 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Announce("k51test", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Retries wait for a peer; nothing is published in the meantime
+	time.Sleep(200 * time.Millisecond)
+	if got := transport.versions(); len(got) != 2 {
+		t.Fatalf("published versions without peers = %v, want only the two announcements", got)
+	}
+
+	// Once a peer is present only the newest announcement is published again
+	transport.setPeers(1)
+	if !waitFor(5*time.Second, func() bool { return len(transport.versions()) == 3 }) {
+		t.Fatalf("queued announcement not retried, published %v", transport.versions())
+	}
+	if got := transport.versions(); got[2] != 2 {
+		t.Errorf("retried version %d, want 2", got[2])
+	}
+
+	p.mu.RLock()
+	queued := p.queuedVersion
+	p.mu.RUnlock()
+	if queued != 0 {
+		t.Errorf("version %d still queued after delivery", queued)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if got := transport.versions(); len(got) != 3 {
+		t.Errorf("published versions = %v, want no retries after delivery", got)
+	}
+}
+
+func TestQueuedAnnouncementDeliveredToLatePeer(t *testing.T) {
+	node := startTestNode(t, nil)
+	p := startTestPublisher(t, node)
+
+	if err := p.Announce("k51test", 3); err != nil {
+		t.Fatal(err)
+	}
+
+	var addr string
+	for _, a := range node.GetListenAddresses() {
+		if strings.HasPrefix(a, "/ip4/127.0.0.1/") {
+			addr = a
+		}
+	}
+	if addr == "" {
+		t.Fatalf("no loopback address in %v", node.GetListenAddresses())
+	}
+
+	late := startTestNode(t, []string{addr})
+	sub, err := late.Subscribe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	msg, err := sub.Next(ctx)
+	if err != nil {
+		t.Fatalf("queued announcement not delivered to the late peer: %v", err)
+	}
+
+	announcement, err := FromJSON(msg.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if announcement.Version != 1 || announcement.CollectionSize != 3 {
+		t.Errorf("received version %d size %d, want version 1 size 3", announcement.Version, announcement.CollectionSize)
+	}
+}
+
+func TestReachRecordedAtAnnouncements(t *testing.T) {
+	transport := &countingTransport{}
+	p := startTestPublisher(t, nil)
+	p.AddTransport(transport)
+	reach := NewReachTracker(filepath.Join(t.TempDir(), "reach.json"), 1, nil)
+	p.SetReachTracker(reach)
+
+	for size := 1; size <= 2; size++ {
+		if err := p.Announce("k51test", size); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status := reach.Status(time.Now()); status.ZeroStreak != 2 || !status.Alert {
+		t.Errorf("status after two announcements without peers = %+v, want an alert", status)
+	}
+
+	transport.setPeers(2)
+	if err := p.AnnounceCurrent(); err != nil {
+		t.Fatal(err)
+	}
+	status := reach.Status(time.Now())
+	if status.Reach != 2 || len(status.LastPeers) != 2 || status.Alert {
+		t.Errorf("status after reaching two peers = %+v, want reach 2 without alert", status)
+	}
+}
+
+// failingTransport is a transport whose publishes always fail
+type failingTransport struct{}
+
+func (failingTransport) Name() string {
+	return "failing"
+}
+
+func (failingTransport) Publish(data []byte) error {
+	return errors.New("daemon unreachable")
+}
+
+func TestSendLogRecordsEveryChannel(t *testing.T) {
+	transport := &countingTransport{peers: 3}
+	p := startTestPublisher(t, nil)
+	p.AddTransport(failingTransport{})
+	p.AddTransport(transport)
+
+	for i := 0; i < sendLogSize+1; i++ {
+		if err := p.Announce("k51test", 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sends := p.SendLog().Recent()
+	if len(sends) != sendLogSize {
+		t.Fatalf("%d sends kept, want %d", len(sends), sendLogSize)
+	}
+
+	newest, failed := sends[0], sends[1]
+	if newest.Channel != "counting" || newest.Version != sendLogSize+1 || newest.TopicPeers == nil || *newest.TopicPeers != 3 || newest.Error != "" {
+		t.Errorf("newest send = %+v, want version %d via counting to 3 peers", newest, sendLogSize+1)
+	}
+	if failed.Channel != "failing" || failed.Error == "" || failed.Hash != newest.Hash {
+		t.Errorf("failed send = %+v, want the error and the hash of the same message", failed)
+	}
+
+	// The hash is that of the message as received
+	if newest.Hash != messageHash(transport.data[len(transport.data)-1]) {
+		t.Errorf("hash %s does not match the published message", newest.Hash)
+	}
+}
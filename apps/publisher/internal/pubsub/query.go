@@ -0,0 +1,94 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"time"
+
+	"github.com/atregu/ipfs-common/fingerprint"
+	"github.com/atregu/ipfs-common/query"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// A valid indexer query is answered by repeating the current announcement after
+// a random delay of up to queryMaxDelay, at most once per queryAnswerInterval,
+// so that a fleet of restarting indexers does not make every publisher
+// announce at the same moment.
+const (
+	queryAnswerInterval = time.Minute
+	queryMaxDelay       = 10 * time.Second
+)
+
+// ListenForQueries subscribes to the query topic and answers indexer queries
+// with the current announcement until ctx is done
+func (p *Publisher) ListenForQueries(ctx context.Context, subscriber TopicSubscriber, topic string) error {
+	messages, err := subscriber.SubscribeTopic(ctx, topic)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to query topic %s: %w", topic, err)
+	}
+
+	logger.Get().Infof("Answering indexer queries on PubSub topic %q", topic)
+
+	go func() {
+		for data := range messages {
+			p.handleQuery(data)
+		}
+	}()
+
+	return nil
+}
+
+// handleQuery schedules an answer to a valid query unless one was sent or
+// scheduled within queryAnswerInterval
+func (p *Publisher) handleQuery(data []byte) {
+	log := logger.Get()
+
+	msg, err := query.Parse(data)
+	if err != nil {
+		log.Debugf("Ignoring malformed query: %v", err)
+		return
+	}
+	if err := msg.Validate(); err != nil {
+		log.Debugf("Ignoring invalid query: %v", err)
+		return
+	}
+	if err := msg.Verify(); err != nil {
+		log.Debugf("Ignoring query with bad signature: %v", err)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.started || p.currentVersion == 0 {
+		return
+	}
+	if p.answerTimer != nil || time.Since(p.lastAnswer) < queryAnswerInterval {
+		log.Debugf("Query from %s not answered: rate limited", fingerprint.Format(msg.PublicKey))
+		return
+	}
+
+	delay := time.Duration(rand.Int64N(int64(p.answerDelay) + 1))
+	log.Debugf("Answering query from %s in %v", fingerprint.Format(msg.PublicKey), delay.Round(time.Millisecond))
+	p.answerTimer = time.AfterFunc(delay, p.answerQuery)
+}
+
+// answerQuery repeats the current announcement in reply to a query
+func (p *Publisher) answerQuery() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.answerTimer == nil {
+		return // Stopped in the meantime
+	}
+	p.answerTimer = nil
+	p.lastAnswer = time.Now()
+
+	if err := p.publishCurrentLocked(); err != nil {
+		logger.Get().Warnf("Failed to answer indexer query: %v", err)
+		return
+	}
+	logger.Get().Infof("Answered indexer query with version %d", p.currentVersion)
+}
@@ -0,0 +1,66 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-common/query"
+)
+
+// signedQuery returns a query signed by a fresh indexer key, aged by age
+func signedQuery(t *testing.T, age time.Duration) []byte {
+	t.Helper()
+
+	q := query.New()
+	q.Timestamp -= int64(age / time.Second)
+	if err := q.Sign(newTestKey(t)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := q.ToJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestHandleQuery(t *testing.T) {
+	transport := &countingTransport{peers: 1}
+	p := startTestPublisher(t, nil)
+	p.answerDelay = 0
+	p.AddTransport(transport)
+
+	// Nothing is announced yet, so there is nothing to answer with
+	p.handleQuery(signedQuery(t, 0))
+	if err := p.Announce("k51test", 2); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := signedQuery(t, 0)
+	tampered[len(tampered)-4] ^= 1
+	for _, data := range [][]byte{
+		signedQuery(t, time.Hour), // replayed after query.MaxAge
+		tampered,
+		[]byte("not json"),
+	} {
+		p.handleQuery(data)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := transport.versions(); len(got) != 1 {
+		t.Fatalf("published versions = %v, want only the announcement", got)
+	}
+
+	p.handleQuery(signedQuery(t, 0))
+	if !waitFor(5*time.Second, func() bool { return len(transport.versions()) == 2 }) {
+		t.Fatalf("query not answered, published %v", transport.versions())
+	}
+	if got := transport.versions(); got[1] != 1 {
+		t.Errorf("answered with version %d, want 1", got[1])
+	}
+
+	// Further queries within queryAnswerInterval are not answered
+	p.handleQuery(signedQuery(t, 0))
+	time.Sleep(100 * time.Millisecond)
+	if got := transport.versions(); len(got) != 2 {
+		t.Errorf("published versions = %v, want one answer per interval", got)
+	}
+}
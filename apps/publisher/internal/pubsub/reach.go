@@ -0,0 +1,207 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// ReachWindow is the period the reach estimate counts distinct topic peers over
+const ReachWindow = 24 * time.Hour
+
+// ReachObserver receives the reach after each recorded announcement (implemented by metrics.ReachMetrics)
+type ReachObserver interface {
+	Reached(reach, lastPeers int, alert bool)
+}
+
+// ReachStatus is the estimated reach of the announcements
+type ReachStatus struct {
+	Reach            int       `json:"reach"`      // Distinct topic peers seen at announcements within ReachWindow
+	LastPeers        []string  `json:"last_peers"` // Topic peers at the last announcement
+	LastAnnouncement time.Time `json:"last_announcement"`
+	ZeroStreak       int       `json:"zero_streak"` // Consecutive announcements without topic peers
+	Alert            bool      `json:"alert"`       // ZeroStreak exceeds the configured limit
+}
+
+// reachSnapshot is the persisted form of a ReachTracker
+type reachSnapshot struct {
+	Peers            map[string]int64 `json:"peers"` // Peer ID → Unix time last seen at an announcement
+	LastPeers        []string         `json:"last_peers"`
+	LastAnnouncement int64            `json:"last_announcement"`
+	ZeroStreak       int              `json:"zero_streak"`
+}
+
+// ReachTracker records the topic peers present at each announcement and estimates
+// the reach as the distinct peers seen within ReachWindow. The record is saved to
+// a small snapshot file after every announcement, so a restart keeps the estimate.
+type ReachTracker struct {
+	mu         sync.Mutex
+	path       string
+	alertAfter int // Announcements without topic peers before alerting; 0 = never
+	observer   ReachObserver
+	snapshot   reachSnapshot
+}
+
+// NewReachTracker creates a tracker saving to path that alerts after more than
+// alertAfter consecutive announcements without topic peers. observer may be nil.
+func NewReachTracker(path string, alertAfter int, observer ReachObserver) *ReachTracker {
+	return &ReachTracker{
+		path:       path,
+		alertAfter: alertAfter,
+		observer:   observer,
+		snapshot:   reachSnapshot{Peers: make(map[string]int64)},
+	}
+}
+
+// Load reads the snapshot file; a missing file leaves the tracker empty
+func (t *ReachTracker) Load() error {
+	data, err := os.ReadFile(t.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read reach snapshot: %w", err)
+	}
+
+	var snapshot reachSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to parse reach snapshot: %w", err)
+	}
+	if snapshot.Peers == nil {
+		snapshot.Peers = make(map[string]int64)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.snapshot = snapshot
+	return nil
+}
+
+// Record records the topic peers present at an announcement made at now, warns
+// once the topic had no peers for more than alertAfter announcements in a row,
+// and saves the snapshot
+func (t *ReachTracker) Record(peers []string, now time.Time) ReachStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	log := logger.Get()
+
+	for _, peer := range peers {
+		t.snapshot.Peers[peer] = now.Unix()
+	}
+	wasAlert := t.alertLocked()
+	if len(peers) == 0 {
+		t.snapshot.ZeroStreak++
+	} else {
+		t.snapshot.ZeroStreak = 0
+	}
+	t.snapshot.LastPeers = append([]string(nil), peers...)
+	sort.Strings(t.snapshot.LastPeers)
+	t.snapshot.LastAnnouncement = now.Unix()
+
+	status := t.statusLocked(now)
+	switch {
+	case status.Alert && !wasAlert:
+		log.Warnf("No topic peers at the last %d announcements; nobody receives them. Check PubSub connectivity (bootstrap peers, firewall, topic)",
+			status.ZeroStreak)
+	case !status.Alert && wasAlert:
+		log.Infof("✓ Topic has %d peers again; announcements are delivered", len(peers))
+	}
+
+	if err := t.saveLocked(); err != nil {
+		log.Warnf("Failed to save reach snapshot: %v", err)
+	}
+	if t.observer != nil {
+		t.observer.Reached(status.Reach, len(status.LastPeers), status.Alert)
+	}
+	return status
+}
+
+// Status returns the reach estimate at now
+func (t *ReachTracker) Status(now time.Time) ReachStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.statusLocked(now)
+}
+
+// statusLocked drops peers last seen before ReachWindow and returns the status
+func (t *ReachTracker) statusLocked(now time.Time) ReachStatus {
+	cutoff := now.Add(-ReachWindow).Unix()
+	for peer, seen := range t.snapshot.Peers {
+		if seen < cutoff {
+			delete(t.snapshot.Peers, peer)
+		}
+	}
+
+	status := ReachStatus{
+		Reach:      len(t.snapshot.Peers),
+		LastPeers:  append([]string{}, t.snapshot.LastPeers...),
+		ZeroStreak: t.snapshot.ZeroStreak,
+		Alert:      t.alertLocked(),
+	}
+	if t.snapshot.LastAnnouncement > 0 {
+		status.LastAnnouncement = time.Unix(t.snapshot.LastAnnouncement, 0)
+	}
+	return status
+}
+
+// alertLocked reports whether the topic had no peers for more than alertAfter announcements
+func (t *ReachTracker) alertLocked() bool {
+	return t.alertAfter > 0 && t.snapshot.ZeroStreak > t.alertAfter
+}
+
+// saveLocked writes the snapshot atomically
+func (t *ReachTracker) saveLocked() error {
+	data, err := json.Marshal(&t.snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to marshal reach snapshot: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(t.path), 0755); err != nil {
+		return fmt.Errorf("failed to create reach snapshot directory: %w", err)
+	}
+	tmpPath := t.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write temp reach snapshot: %w", err)
+	}
+	if err := os.Rename(tmpPath, t.path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to rename temp reach snapshot: %w", err)
+	}
+	return nil
+}
+
+// Summary returns the status line describing the reach at now
+func (t *ReachTracker) Summary(now time.Time) string {
+	status := t.Status(now)
+	if status.LastAnnouncement.IsZero() {
+		return "reach: no announcement recorded yet"
+	}
+
+	line := fmt.Sprintf("reach: %d topic peers in the last 24h, %d at the last announcement (%s)",
+		status.Reach, len(status.LastPeers), status.LastAnnouncement.Format(time.RFC3339))
+	if status.Alert {
+		line += fmt.Sprintf("; no peers for %d announcements in a row", status.ZeroStreak)
+	}
+	return line
+}
+
+// Handler returns an HTTP handler for GET /api/v1/pubsub/reach
+func (t *ReachTracker) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(t.Status(time.Now()))
+	})
+}
@@ -0,0 +1,103 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// reachObserver records the last reach reported by a tracker
+type reachObserver struct {
+	reach, lastPeers int
+	alert            bool
+}
+
+func (o *reachObserver) Reached(reach, lastPeers int, alert bool) {
+	o.reach, o.lastPeers, o.alert = reach, lastPeers, alert
+}
+
+func TestReachTracker(t *testing.T) {
+	logger.Get().SetOutput(io.Discard)
+
+	path := filepath.Join(t.TempDir(), "reach.json")
+	observer := &reachObserver{}
+	tracker := NewReachTracker(path, 2, observer)
+	start := time.Unix(1700000000, 0)
+
+	tracker.Record([]string{"12D3A", "12D3B"}, start)
+	tracker.Record([]string{"12D3B", "12D3C"}, start.Add(time.Hour))
+	if observer.reach != 3 || observer.lastPeers != 2 || observer.alert {
+		t.Errorf("observed %+v, want reach 3 with 2 peers at the last announcement", observer)
+	}
+
+	// The alert is raised once the topic had no peers for more than 2 announcements
+	for i := 1; i <= 3; i++ {
+		status := tracker.Record(nil, start.Add(time.Duration(i+1)*time.Hour))
+		if status.Alert != (i == 3) {
+			t.Errorf("alert after %d announcements without peers = %v", i, status.Alert)
+		}
+	}
+	if !observer.alert || observer.lastPeers != 0 {
+		t.Errorf("observed %+v, want the alert with no peers", observer)
+	}
+
+	// The snapshot restores the record after a restart
+	restored := NewReachTracker(path, 2, nil)
+	if err := restored.Load(); err != nil {
+		t.Fatal(err)
+	}
+	status := restored.Status(start.Add(5 * time.Hour))
+	if status.Reach != 3 || status.ZeroStreak != 3 || !status.Alert {
+		t.Errorf("restored status = %+v, want reach 3 and the alert", status)
+	}
+
+	status = restored.Record([]string{"12D3A"}, start.Add(6*time.Hour))
+	if status.Alert || status.ZeroStreak != 0 {
+		t.Errorf("status after a peer returned = %+v, want the alert cleared", status)
+	}
+
+	// Peers not seen again within the window no longer count
+	status = restored.Status(start.Add(time.Hour + ReachWindow + time.Minute))
+	if status.Reach != 1 {
+		t.Errorf("reach after the window = %d, want 1", status.Reach)
+	}
+}
+
+func TestReachTrackerWithoutSnapshot(t *testing.T) {
+	tracker := NewReachTracker(filepath.Join(t.TempDir(), "reach.json"), 5, nil)
+	if err := tracker.Load(); err != nil {
+		t.Fatalf("Load without a snapshot: %v", err)
+	}
+	if summary := tracker.Summary(time.Now()); summary != "reach: no announcement recorded yet" {
+		t.Errorf("Summary = %q", summary)
+	}
+}
+
+func TestReachHandler(t *testing.T) {
+	logger.Get().SetOutput(io.Discard)
+
+	tracker := NewReachTracker(filepath.Join(t.TempDir(), "reach.json"), 5, nil)
+	tracker.Record([]string{"12D3A"}, time.Now())
+
+	rec := httptest.NewRecorder()
+	tracker.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/pubsub/reach", nil))
+	var status ReachStatus
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	if status.Reach != 1 || len(status.LastPeers) != 1 || status.LastPeers[0] != "12D3A" {
+		t.Errorf("GET /api/v1/pubsub/reach = %+v, want the recorded peer", status)
+	}
+
+	rec = httptest.NewRecorder()
+	tracker.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/pubsub/reach", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want %d", rec.Code, http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,110 @@
+package pubsub
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+)
+
+// sendLogSize is the number of sends kept for GET /api/v1/pubsub/sent
+const sendLogSize = 20
+
+// Send is the metadata of an announcement sent on one channel. Indexers record
+// the message ID and hash of the announcements they store, so a send can be
+// matched with its receipt.
+type Send struct {
+	Time       time.Time `json:"time"`
+	Version    int       `json:"version"`
+	Channel    string    `json:"channel"`               // standalone, embedded-ipfs or external-daemon
+	TopicPeers *int      `json:"topic_peers,omitempty"` // Topic peers of the channel at send time, if it can count them
+	MessageID  string    `json:"message_id,omitempty"`  // Gossipsub message ID (hex), known for the standalone node only
+	Hash       string    `json:"hash"`                  // SHA-256 of the message (hex), the same on every channel
+	Error      string    `json:"error,omitempty"`       // Why the send failed
+}
+
+// SendLog keeps the most recent sends, newest last
+type SendLog struct {
+	mu    sync.Mutex
+	sends []Send
+}
+
+// Record adds a send, dropping the oldest beyond sendLogSize
+func (l *SendLog) Record(send Send) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sends = append(l.sends, send)
+	if len(l.sends) > sendLogSize {
+		l.sends = append([]Send(nil), l.sends[len(l.sends)-sendLogSize:]...)
+	}
+}
+
+// Recent returns the kept sends, newest first
+func (l *SendLog) Recent() []Send {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	sends := make([]Send, len(l.sends))
+	for i, send := range l.sends {
+		sends[len(sends)-1-i] = send
+	}
+	return sends
+}
+
+// Handler returns an HTTP handler for GET /api/v1/pubsub/sent
+func (l *SendLog) Handler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(l.Recent())
+	})
+}
+
+// messageHash returns the hex SHA-256 of a serialized announcement. Indexers hash
+// the data they receive the same way.
+func messageHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// publishTracer is a gossipsub event tracer that records the ID of the last
+// message the node published to each topic. Gossipsub traces a local publish
+// before Topic.Publish returns.
+type publishTracer struct {
+	mu   sync.Mutex
+	last map[string]string // Topic → hex message ID
+}
+
+// Trace implements pubsub.EventTracer
+func (t *publishTracer) Trace(evt *pb.TraceEvent) {
+	if evt.GetType() != pb.TraceEvent_PUBLISH_MESSAGE {
+		return
+	}
+
+	msg := evt.GetPublishMessage()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.last == nil {
+		t.last = make(map[string]string)
+	}
+	t.last[msg.GetTopic()] = hex.EncodeToString(msg.GetMessageID())
+}
+
+// take returns and forgets the ID of the last message published to topic
+func (t *publishTracer) take(topic string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := t.last[topic]
+	delete(t.last, topic)
+	return id
+}
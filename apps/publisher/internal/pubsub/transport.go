@@ -0,0 +1,130 @@
+package pubsub
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Transport is a channel announcements can be published through
+type Transport interface {
+	// Name identifies the transport in logs
+	Name() string
+
+	// Publish sends a serialized announcement
+	Publish(data []byte) error
+}
+
+// PeerCounter is a Transport that can count the peers subscribed to its topic
+type PeerCounter interface {
+	// TopicPeerCount returns the number of topic peers; ok is false if they cannot be counted
+	TopicPeerCount() (count int, ok bool)
+}
+
+// PeerLister is a Transport that can list the peers subscribed to its topic
+type PeerLister interface {
+	// TopicPeers returns the IDs of the topic peers; ok is false if they cannot be listed
+	TopicPeers() (peers []string, ok bool)
+}
+
+// TopicPeerCounter counts the peers subscribed to a PubSub topic (implemented by the embedded IPFS client)
+type TopicPeerCounter interface {
+	CountTopicPeers(ctx context.Context, topic string) (int, error)
+}
+
+// TopicPeerLister lists the peers subscribed to a PubSub topic (implemented by the embedded IPFS client)
+type TopicPeerLister interface {
+	ListTopicPeers(ctx context.Context, topic string) ([]string, error)
+}
+
+// TopicPublisher publishes raw messages to a PubSub topic (implemented by the IPFS clients)
+type TopicPublisher interface {
+	PublishToPubSub(ctx context.Context, topic string, data []byte) error
+}
+
+// DaemonTransport publishes announcements through an IPFS node's own PubSub
+type DaemonTransport struct {
+	name    string
+	client  TopicPublisher
+	topic   string
+	timeout time.Duration
+}
+
+// NewDaemonTransport creates a transport that publishes to topic via client
+func NewDaemonTransport(name string, client TopicPublisher, topic string, timeout time.Duration) *DaemonTransport {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	return &DaemonTransport{
+		name:    name,
+		client:  client,
+		topic:   topic,
+		timeout: timeout,
+	}
+}
+
+// Name returns the transport name
+func (t *DaemonTransport) Name() string {
+	return t.name
+}
+
+// Publish publishes data to the configured topic
+func (t *DaemonTransport) Publish(data []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	if err := t.client.PublishToPubSub(ctx, t.topic, data); err != nil {
+		return fmt.Errorf("failed to publish via %s: %w", t.name, err)
+	}
+	return nil
+}
+
+// TopicPeerCount returns the number of peers subscribed to the topic if the client can count them
+func (t *DaemonTransport) TopicPeerCount() (int, bool) {
+	counter, ok := t.client.(TopicPeerCounter)
+	if !ok {
+		return 0, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	count, err := counter.CountTopicPeers(ctx, t.topic)
+	if err != nil {
+		return 0, false
+	}
+	return count, true
+}
+
+// TopicPeers returns the IDs of the peers subscribed to the topic if the client can list them
+func (t *DaemonTransport) TopicPeers() ([]string, bool) {
+	lister, ok := t.client.(TopicPeerLister)
+	if !ok {
+		return nil, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+
+	peers, err := lister.ListTopicPeers(ctx, t.topic)
+	if err != nil {
+		return nil, false
+	}
+	return peers, true
+}
+
+// PubSubDaemon is an IPFS daemon that may or may not have PubSub enabled
+type PubSubDaemon interface {
+	TopicPublisher
+	SupportsPubSub(ctx context.Context) bool
+}
+
+// DetectDaemonTransport checks once whether the daemon supports PubSub and returns
+// a transport for it, or nil if the daemon has PubSub disabled or cannot be probed.
+func DetectDaemonTransport(ctx context.Context, daemon PubSubDaemon, topic string, timeout time.Duration) *DaemonTransport {
+	if !daemon.SupportsPubSub(ctx) {
+		return nil
+	}
+	return NewDaemonTransport("external-daemon", daemon, topic, timeout)
+}
@@ -0,0 +1,37 @@
+package scanner
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// matchGlob reports whether name matches pattern. Both are split at "/"; each
+// element is matched with filepath.Match, and a "**" element matches any number
+// of elements, including none, so "**/Thumbs.db" matches Thumbs.db in every
+// directory and also the bare file name.
+func matchGlob(pattern, name string) bool {
+	return matchElements(strings.Split(pattern, "/"), strings.Split(filepath.ToSlash(name), "/"))
+}
+
+// matchElements matches the path elements of a name against those of a pattern
+func matchElements(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for skip := 0; skip <= len(name); skip++ {
+				if matchElements(pattern[1:], name[skip:]) {
+					return true
+				}
+			}
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := filepath.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
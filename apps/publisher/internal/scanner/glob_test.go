@@ -0,0 +1,76 @@
+package scanner
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"**/Thumbs.db", "/media/music/a/b/Thumbs.db", true},
+		{"**/Thumbs.db", "Thumbs.db", true},
+		{"**/Thumbs.db", "/media/music/Thumbs.db.mp3", false},
+		{"*.nfo", "movie.nfo", true},
+		{"*.nfo", "/media/films/movie.nfo", false}, // "*" does not cross directories
+		{"**/*.nfo", "/media/films/movie.nfo", true},
+		{"**/extras/**", "/media/films/A/extras/trailer.mkv", true},
+		{"**/extras/**", "/media/films/A/extras/deleted/scene.mkv", true},
+		{"**/extras/**", "/media/films/A/extra/trailer.mkv", false},
+		{"/media/*/cover.jpg", "/media/music/cover.jpg", true},
+		{"/media/*/cover.jpg", "/media/music/a/cover.jpg", false},
+		{"/media/**/cover.jpg", "/media/music/a/cover.jpg", true},
+		{"[a-", "a", false}, // Malformed patterns match nothing
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestScanSkipsExcludedFiles(t *testing.T) {
+	logger.Get().SetOutput(io.Discard)
+
+	dir := t.TempDir()
+	for _, name := range []string{
+		"Artist/Album/01.mp3", "Artist/Album/cover.jpg.mp3", "Artist/Album/Thumbs.db", "Artist/info.nfo.mp3",
+		"Artist/Album/extras/demo.mp3", "Artist/Album/extras/live/take.mp3", "Other/02.mp3", "Other/sample.mp3",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s := New([]string{dir}, []string{"mp3", "db"})
+	s.ExcludePatterns = []string{"**/Thumbs.db", "*.nfo.mp3", "**/extras/**", "**/cover.*", dir + "/Other/sample.mp3"}
+
+	want := []string{filepath.Join(dir, "Artist/Album/01.mp3"), filepath.Join(dir, "Other/02.mp3")}
+	files, err := s.Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := paths(files); !reflect.DeepEqual(got, want) {
+		t.Errorf("Scan = %q, want %q", got, want)
+	}
+
+	files, err = s.ScanParallel(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := paths(files); !reflect.DeepEqual(got, want) {
+		t.Errorf("ScanParallel = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,169 @@
+package scanner
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// walkTask is a directory to list, below the configured directory root
+type walkTask struct {
+	root string
+	dir  string
+}
+
+// walkQueue hands directories to the walking workers. Workers add the
+// subdirectories they find, so the queue is done once it is empty and no
+// directory is being listed.
+type walkQueue struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	tasks   []walkTask
+	pending int // Directories queued or being listed
+}
+
+func newWalkQueue() *walkQueue {
+	q := &walkQueue{}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// push queues a directory
+func (q *walkQueue) push(task walkTask) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.tasks = append(q.tasks, task)
+	q.pending++
+	q.cond.Signal()
+}
+
+// pop waits for a directory to list. It returns false once every directory
+// has been listed.
+func (q *walkQueue) pop() (walkTask, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.tasks) == 0 && q.pending > 0 {
+		q.cond.Wait()
+	}
+	if len(q.tasks) == 0 {
+		return walkTask{}, false
+	}
+
+	task := q.tasks[len(q.tasks)-1]
+	q.tasks = q.tasks[:len(q.tasks)-1]
+	return task, true
+}
+
+// done marks a popped directory as listed
+func (q *walkQueue) done() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending--
+	if q.pending == 0 {
+		q.cond.Broadcast()
+	}
+}
+
+// ScanParallel scans like Scan, but lists directories with a pool of workers
+// goroutines, at least one per configured directory, which helps on slow or
+// network filesystems. The files are returned sorted by Path.
+func (s *Scanner) ScanParallel(workers int) ([]FileInfo, error) {
+	log := logger.Get()
+	s.processed.Store(0)
+
+	queue := newWalkQueue()
+	roots := 0
+	for _, dir := range s.directories {
+		expandedDir, ok, err := s.root(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		// Like Walk, a symlinked directory is not followed, even as the root
+		if info, err := os.Lstat(expandedDir); err == nil && info.Mode()&os.ModeSymlink != 0 {
+			s.match(expandedDir, expandedDir, info)
+			continue
+		}
+		queue.push(walkTask{root: expandedDir, dir: expandedDir})
+		roots++
+	}
+	if workers < roots {
+		workers = roots
+	}
+
+	found := make(chan FileInfo, 64)
+	var collected []FileInfo
+	collectorDone := make(chan struct{})
+	go func() {
+		defer close(collectorDone)
+		for file := range found {
+			collected = append(collected, file)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				task, ok := queue.pop()
+				if !ok {
+					return
+				}
+				s.listDir(task, queue, found)
+				queue.done()
+			}
+		}()
+	}
+	wg.Wait()
+	close(found)
+	<-collectorDone
+
+	sort.Slice(collected, func(i, j int) bool { return collected[i].Path < collected[j].Path })
+
+	log.Infof("Found %d files matching criteria (%d files examined by %d workers)", len(collected), s.processed.Load(), workers)
+	return collected, nil
+}
+
+// listDir sends the matching files of a directory to found and queues its
+// subdirectories. Like filepath.Walk it does not follow symbolic links, and it
+// skips unreadable entries with a warning.
+func (s *Scanner) listDir(task walkTask, queue *walkQueue, found chan<- FileInfo) {
+	log := logger.Get()
+
+	entries, err := os.ReadDir(task.dir)
+	if err != nil {
+		if os.IsPermission(err) {
+			log.Warnf("Permission denied: %s (skipping)", task.dir)
+		} else {
+			log.Warnf("Error accessing path %s: %v", task.dir, err)
+		}
+		return
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(task.dir, entry.Name())
+		info, err := os.Lstat(path)
+		if err != nil {
+			log.Warnf("Error accessing path %s: %v", path, err)
+			continue
+		}
+
+		if info.IsDir() {
+			queue.push(walkTask{root: task.root, dir: path})
+			continue
+		}
+		if file, ok := s.match(task.root, path, info); ok {
+			found <- file
+		}
+	}
+}
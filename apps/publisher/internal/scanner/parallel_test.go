@@ -0,0 +1,75 @@
+package scanner
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// paths returns the paths of scanned files
+func paths(files []FileInfo) []string {
+	var out []string
+	for _, file := range files {
+		out = append(out, file.Path)
+	}
+	return out
+}
+
+func TestScanParallelMatchesScan(t *testing.T) {
+	logger.Get().SetOutput(io.Discard)
+
+	music, films := t.TempDir(), t.TempDir()
+	for _, name := range []string{
+		"a/one.mp3", "a/b/two.MP3", "a/b/c/three.mp3", "a/.hidden.mp3", "a/notes.txt", "four.mp3",
+	} {
+		path := filepath.Join(music, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(films, "film.mkv"), []byte("film"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(music, "four.mp3"), filepath.Join(films, "link.mp3")); err != nil {
+		t.Fatal(err)
+	}
+
+	dirs := []string{music, films, filepath.Join(music, "missing")}
+	sequential, err := New(dirs, []string{"mp3", "mkv"}).Scan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := paths(sequential)
+	sort.Strings(want)
+	if len(want) != 5 {
+		t.Fatalf("Scan found %v, want 5 files", want)
+	}
+
+	for _, workers := range []int{0, 1, 8} {
+		s := New(dirs, []string{"mp3", "mkv"})
+		files, err := s.ScanParallel(workers)
+		if err != nil {
+			t.Fatalf("ScanParallel(%d): %v", workers, err)
+		}
+		if got := paths(files); !reflect.DeepEqual(got, want) {
+			t.Errorf("ScanParallel(%d) = %v, want %v", workers, got, want)
+		}
+		if s.Processed() != 8 {
+			t.Errorf("ScanParallel(%d) examined %d files, want 8", workers, s.Processed())
+		}
+
+		for _, file := range files {
+			if file.Path == filepath.Join(music, "a", "b", "two.MP3") && file.Group != "a/b" {
+				t.Errorf("two.MP3 in group %q, want a/b", file.Group)
+			}
+		}
+	}
+}
@@ -1,15 +1,24 @@
 package scanner
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/atregu/ipfs-common/extensions"
 
 	"github.com/atregu/ipfs-publisher/internal/logger"
 	"github.com/atregu/ipfs-publisher/internal/utils"
 )
 
+// ErrFileChanged is returned by Verify when a file was modified after it was scanned
+var ErrFileChanged = errors.New("file changed since scan")
+
 // FileInfo represents information about a scanned file
 type FileInfo struct {
 	Path      string
@@ -17,47 +26,122 @@ type FileInfo struct {
 	Extension string
 	Size      int64
 	ModTime   int64
+	Group     string      // Parent directory relative to the scanned root, see utils.GroupForPath
+	Info      os.FileInfo // Stat result from the scan, reused by the upload step
+}
+
+// Open opens the scanned file for upload. It does not stat the file again; the
+// scan's Info is passed to the add instead, and Verify checks it afterwards.
+func (f *FileInfo) Open() (*os.File, error) {
+	file, err := os.Open(f.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", f.Path, err)
+	}
+	return file, nil
+}
+
+// Verify re-checks size and modification time just before the upload is recorded
+// in state. It returns ErrFileChanged if the file was modified since the scan; the
+// caller should then skip recording it so the next scan uploads it again instead of
+// storing a CID under stale size/mtime values.
+func (f *FileInfo) Verify() error {
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", f.Path, err)
+	}
+
+	// Compare the full-precision mtime of the scan when available, so a rewrite
+	// within the same second is caught too
+	changed := info.Size() != f.Size || info.ModTime().Unix() != f.ModTime
+	if f.Info != nil {
+		changed = info.Size() != f.Info.Size() || !info.ModTime().Equal(f.Info.ModTime())
+	}
+	if changed {
+		return fmt.Errorf("%w: %s (size %d -> %d, mtime %s -> %s)", ErrFileChanged, f.Path, f.Size, info.Size(),
+			time.Unix(f.ModTime, 0).Format(time.RFC3339), info.ModTime().Format(time.RFC3339Nano))
+	}
+
+	return nil
+}
+
+// sniffLength is the number of bytes MimeType and ContentMismatch read from the
+// start of a file
+const sniffLength = 512
+
+// MimeType returns the MIME type of the file: by its extension for common media
+// files, or else detected from its first bytes. It returns "" if the file cannot
+// be read or its type is not recognized.
+func (f *FileInfo) MimeType() string {
+	if mimeType := extensions.MimeType(f.Extension); mimeType != "" {
+		return mimeType
+	}
+
+	head, err := readHead(f.Path)
+	if err != nil || len(head) == 0 {
+		return ""
+	}
+	if mimeType := http.DetectContentType(head); mimeType != "application/octet-stream" {
+		return mimeType
+	}
+	return ""
 }
 
 // Scanner scans directories for media files
 type Scanner struct {
+	// ExcludePatterns are globs of files to skip, matched against the absolute
+	// path and the file name; see ShouldIgnoreFile
+	ExcludePatterns []string
+
 	directories []string
-	extensions  map[string]bool
+	extensions  extensions.Set
+	processed   atomic.Int64 // Files examined by the current or last scan
 }
 
 // New creates a new Scanner
-func New(directories []string, extensions []string) *Scanner {
-	extMap := make(map[string]bool)
-	for _, ext := range extensions {
-		extMap[strings.ToLower(ext)] = true
-	}
-
+func New(directories []string, exts []string) *Scanner {
 	return &Scanner{
 		directories: directories,
-		extensions:  extMap,
+		extensions:  extensions.NewSet(exts),
+	}
+}
+
+// ShouldIgnoreFile reports whether the file at path is skipped regardless of its
+// extension: hidden and temporary files (see utils.ShouldIgnoreFile), and files
+// whose absolute path or name matches one of ExcludePatterns. In the patterns
+// "*" does not cross directories, while a "**" element matches any number of
+// them, e.g. "**/extras/**" or "*.nfo".
+func (s *Scanner) ShouldIgnoreFile(path string) bool {
+	if utils.ShouldIgnoreFile(path) {
+		return true
+	}
+
+	name := filepath.Base(path)
+	for _, pattern := range s.ExcludePatterns {
+		if matchGlob(pattern, path) || matchGlob(pattern, name) {
+			return true
+		}
 	}
+	return false
+}
+
+// Processed returns the number of files examined by the current or last scan,
+// matching or not. It is safe to call while a scan runs.
+func (s *Scanner) Processed() int64 {
+	return s.processed.Load()
 }
 
 // Scan recursively scans all configured directories
 func (s *Scanner) Scan() ([]FileInfo, error) {
 	log := logger.Get()
 	var files []FileInfo
+	s.processed.Store(0)
 
 	for _, dir := range s.directories {
-		expandedDir := expandPath(dir)
-		log.Infof("Scanning directory: %s", expandedDir)
-
-		info, err := os.Stat(expandedDir)
+		expandedDir, ok, err := s.root(dir)
 		if err != nil {
-			if os.IsNotExist(err) {
-				log.Warnf("Directory does not exist: %s", expandedDir)
-				continue
-			}
-			return nil, fmt.Errorf("failed to stat directory %s: %w", expandedDir, err)
+			return nil, err
 		}
-
-		if !info.IsDir() {
-			log.Warnf("Path is not a directory: %s", expandedDir)
+		if !ok {
 			continue
 		}
 
@@ -76,63 +160,96 @@ func (s *Scanner) Scan() ([]FileInfo, error) {
 				return nil
 			}
 
-			// Check for symlinks (skip linking files; symlinked directories are handled by Walk)
-			if info.Mode()&os.ModeSymlink != 0 {
-				log.Debugf("Skipping symbolic link: %s", path)
-				return nil
+			if file, ok := s.match(expandedDir, path, info); ok {
+				files = append(files, file)
 			}
+			return nil
+		})
 
-			// Use utility function to check if file should be ignored
-			if utils.ShouldIgnoreFile(info.Name()) {
-				log.Debugf("Skipping ignored file: %s", path)
-				return nil
-			}
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk directory %s: %w", expandedDir, err)
+		}
+	}
 
-			ext := filepath.Ext(info.Name())
-			if ext == "" {
-				log.Debugf("Skipping file without extension: %s", path)
-				return nil
-			}
+	log.Infof("Found %d files matching criteria", len(files))
+	return files, nil
+}
 
-			ext = strings.ToLower(strings.TrimPrefix(ext, "."))
+// root expands a configured directory and checks that it can be scanned. It
+// reports false for a missing directory or a file, which are skipped with a warning.
+func (s *Scanner) root(dir string) (string, bool, error) {
+	log := logger.Get()
+	expandedDir := expandPath(dir)
+	log.Infof("Scanning directory: %s", expandedDir)
 
-			if !s.extensions[ext] {
-				log.Debugf("Skipping file with non-matching extension: %s", path)
-				return nil
-			}
+	info, err := os.Stat(expandedDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Warnf("Directory does not exist: %s", expandedDir)
+			return "", false, nil
+		}
+		return "", false, fmt.Errorf("failed to stat directory %s: %w", expandedDir, err)
+	}
 
-			// Check filename length
-			if len(info.Name()) > utils.MaxFilenameLength {
-				log.Warnf("Filename too long (%d chars), skipping: %s", len(info.Name()), path)
-				return nil
-			}
+	if !info.IsDir() {
+		log.Warnf("Path is not a directory: %s", expandedDir)
+		return "", false, nil
+	}
+	return expandedDir, true, nil
+}
 
-			// Use cleaned absolute path for consistency
-			absPath := path
-			if p, err := filepath.Abs(path); err == nil {
-				absPath = filepath.Clean(p)
-			} else {
-				absPath = filepath.Clean(path)
-			}
+// match filters a file found below root and returns its scan result if it is
+// published. info is the Lstat result of path.
+func (s *Scanner) match(root, path string, info os.FileInfo) (FileInfo, bool) {
+	log := logger.Get()
+	s.processed.Add(1)
 
-			files = append(files, FileInfo{
-				Path:      absPath,
-				Name:      info.Name(),
-				Extension: ext,
-				Size:      info.Size(),
-				ModTime:   info.ModTime().Unix(),
-			})
+	// Check for symlinks (skip linking files; symlinked directories are not followed)
+	if info.Mode()&os.ModeSymlink != 0 {
+		log.Debugf("Skipping symbolic link: %s", path)
+		return FileInfo{}, false
+	}
 
-			return nil
-		})
+	// Use cleaned absolute path for consistency
+	absPath := path
+	if p, err := filepath.Abs(path); err == nil {
+		absPath = filepath.Clean(p)
+	} else {
+		absPath = filepath.Clean(path)
+	}
 
-		if err != nil {
-			return nil, fmt.Errorf("failed to walk directory %s: %w", expandedDir, err)
-		}
+	// Excluded files are expected, so they are only logged at debug level
+	if s.ShouldIgnoreFile(absPath) {
+		log.Debugf("Skipping ignored file: %s", path)
+		return FileInfo{}, false
 	}
 
-	log.Infof("Found %d files matching criteria", len(files))
-	return files, nil
+	ext := extensions.Of(info.Name())
+	if ext == "" {
+		log.Debugf("Skipping file without extension: %s", path)
+		return FileInfo{}, false
+	}
+
+	if !s.extensions[ext] {
+		log.Debugf("Skipping file with non-matching extension: %s", path)
+		return FileInfo{}, false
+	}
+
+	// Check filename length
+	if len(info.Name()) > utils.MaxFilenameLength {
+		log.Warnf("Filename too long (%d chars), skipping: %s", len(info.Name()), path)
+		return FileInfo{}, false
+	}
+
+	return FileInfo{
+		Path:      absPath,
+		Name:      info.Name(),
+		Extension: ext,
+		Size:      info.Size(),
+		ModTime:   info.ModTime().Unix(),
+		Group:     utils.GroupForPath(root, absPath),
+		Info:      info,
+	}, true
 }
 
 func expandPath(path string) string {
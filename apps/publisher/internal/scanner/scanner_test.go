@@ -0,0 +1,133 @@
+package scanner
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// scanOne writes content to a single .mp3 file and returns its scan result
+func scanOne(t *testing.T, content string) FileInfo {
+	t.Helper()
+	logger.Get().SetOutput(io.Discard)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "song.mp3"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := New([]string{dir}, []string{"mp3"}).Scan()
+	if err != nil {
+		t.Fatalf("Scan: %v", err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Scan found %d files, want 1", len(files))
+	}
+	return files[0]
+}
+
+func TestVerifyUnchanged(t *testing.T) {
+	file := scanOne(t, "audio")
+	if err := file.Verify(); err != nil {
+		t.Errorf("Verify of an unchanged file: %v", err)
+	}
+}
+
+func TestVerifyDetectsChanges(t *testing.T) {
+	tests := map[string]func(path string, scanned time.Time) error{
+		"size": func(path string, _ time.Time) error {
+			return os.WriteFile(path, []byte("longer audio"), 0o644)
+		},
+		// Same size, rewritten within the same second as the scan
+		"sub-second mtime": func(path string, scanned time.Time) error {
+			if err := os.WriteFile(path, []byte("AUDIO"), 0o644); err != nil {
+				return err
+			}
+			mtime := scanned.Truncate(time.Second).Add(time.Second - time.Nanosecond)
+			if mtime.Equal(scanned) {
+				mtime = mtime.Add(-time.Millisecond)
+			}
+			return os.Chtimes(path, mtime, mtime)
+		},
+		"removed": func(path string, _ time.Time) error {
+			return os.Remove(path)
+		},
+	}
+
+	for name, modify := range tests {
+		file := scanOne(t, "audio")
+		if err := modify(file.Path, file.Info.ModTime()); err != nil {
+			t.Fatal(err)
+		}
+
+		err := file.Verify()
+		if err == nil {
+			t.Errorf("%s: Verify succeeded after the file changed", name)
+			continue
+		}
+		if name != "removed" && !errors.Is(err, ErrFileChanged) {
+			t.Errorf("%s: Verify = %v, want ErrFileChanged", name, err)
+		}
+	}
+}
+
+func TestMimeType(t *testing.T) {
+	if file := scanOne(t, "audio"); file.MimeType() != "audio/mpeg" {
+		t.Errorf("MimeType of an .mp3 file = %q, want audio/mpeg", file.MimeType())
+	}
+
+	// Unknown extensions are detected from the content
+	dir := t.TempDir()
+	for name, content := range map[string]string{"page.xyz": "<html><body></body></html>", "blob.xyz": "\x00\x01\x02"} {
+		file := FileInfo{Path: filepath.Join(dir, name), Extension: "xyz"}
+		if err := os.WriteFile(file.Path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		want := ""
+		if name == "page.xyz" {
+			want = "text/html; charset=utf-8"
+		}
+		if got := file.MimeType(); got != want {
+			t.Errorf("MimeType of %s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestContentMismatch(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		want    bool
+	}{
+		{"tagged.mp3", "ID3\x04\x00\x00\x00\x00\x00\x00", false},
+		{"untagged.mp3", "\x00\x00\xff\xfb\x90\x00", false},
+		{"clip.mp4", "\x00\x00\x00\x18ftypisom", false},
+		{"clip.webm", "\x1a\x45\xdf\xa3\x9f", false},
+		{"disk.mp4", "\xebc\x90MSDOS5.0", true},
+		{"notes.flac", "just some text", true},
+		{"empty.mkv", "", false},
+		{"subtitles.srt", "1\n00:00:01,000 --> 00:00:02,000\n", false}, // Not checked
+	}
+	for _, tt := range tests {
+		file := FileInfo{Path: filepath.Join(dir, tt.name), Extension: strings.TrimPrefix(filepath.Ext(tt.name), ".")}
+		if err := os.WriteFile(file.Path, []byte(tt.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if detected, got := file.ContentMismatch(); got != tt.want {
+			t.Errorf("ContentMismatch of %s = %q, %v, want %v", tt.name, detected, got, tt.want)
+		}
+	}
+
+	// The detected type describes the content
+	file := FileInfo{Path: filepath.Join(dir, "notes.flac"), Extension: "flac"}
+	if detected, _ := file.ContentMismatch(); detected != "text/plain; charset=utf-8" {
+		t.Errorf("ContentMismatch detected %q, want text/plain; charset=utf-8", detected)
+	}
+}
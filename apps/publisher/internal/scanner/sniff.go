@@ -0,0 +1,127 @@
+package scanner
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+)
+
+// magic reports whether the first bytes of a file carry a format's signature
+type magic func(head []byte) bool
+
+// at matches pattern at offset
+func at(offset int, pattern string) magic {
+	return func(head []byte) bool {
+		return len(head) >= offset+len(pattern) && string(head[offset:offset+len(pattern)]) == pattern
+	}
+}
+
+// both matches when a and b match, e.g. a container and its form type
+func both(a, b magic) magic {
+	return func(head []byte) bool {
+		return a(head) && b(head)
+	}
+}
+
+// mpegSync matches an MPEG audio frame or ADTS header anywhere in head; MP3 and
+// AAC streams without a tag may start with junk before the first frame
+func mpegSync(head []byte) bool {
+	for i := bytes.IndexByte(head, 0xff); i >= 0 && i+1 < len(head); {
+		if head[i+1]&0xe0 == 0xe0 {
+			return true
+		}
+		next := bytes.IndexByte(head[i+1:], 0xff)
+		if next < 0 {
+			break
+		}
+		i += 1 + next
+	}
+	return false
+}
+
+var (
+	id3  = at(0, "ID3")
+	isom = []magic{at(4, "ftyp"), at(4, "moov"), at(4, "mdat"), at(4, "wide"), at(4, "free"), at(4, "skip")}
+	ebml = []magic{at(0, "\x1a\x45\xdf\xa3")}
+	ogg  = []magic{at(0, "OggS")}
+	asf  = []magic{at(0, "\x30\x26\xb2\x75\x8e\x66\xcf\x11")}
+	zip  = []magic{at(0, "PK\x03\x04")}
+)
+
+// signatures are the signatures of media extensions, one of which the first
+// bytes of a file must match. Extensions without an entry, such as text
+// formats, are not checked.
+var signatures = map[string][]magic{
+	"aac":  {id3, at(0, "ADIF"), mpegSync},
+	"aif":  {both(at(0, "FORM"), at(8, "AIFF")), both(at(0, "FORM"), at(8, "AIFC"))},
+	"aiff": {both(at(0, "FORM"), at(8, "AIFF")), both(at(0, "FORM"), at(8, "AIFC"))},
+	"ape":  {at(0, "MAC "), id3},
+	"avi":  {both(at(0, "RIFF"), at(8, "AVI "))},
+	"avif": isom,
+	"epub": zip,
+	"flac": {at(0, "fLaC"), id3},
+	"gif":  {at(0, "GIF87a"), at(0, "GIF89a")},
+	"jpeg": {at(0, "\xff\xd8\xff")},
+	"jpg":  {at(0, "\xff\xd8\xff")},
+	"m4a":  isom,
+	"m4b":  isom,
+	"m4v":  isom,
+	"mka":  ebml,
+	"mkv":  ebml,
+	"mov":  isom,
+	"mp3":  {id3, mpegSync},
+	"mp4":  isom,
+	"mpeg": {at(0, "\x00\x00\x01\xba"), at(0, "\x00\x00\x01\xb3")},
+	"mpg":  {at(0, "\x00\x00\x01\xba"), at(0, "\x00\x00\x01\xb3")},
+	"oga":  ogg,
+	"ogg":  ogg,
+	"ogv":  ogg,
+	"opus": ogg,
+	"pdf":  {at(0, "%PDF-")},
+	"png":  {at(0, "\x89PNG\r\n\x1a\n")},
+	"ts":   {both(at(0, "\x47"), at(188, "\x47"))},
+	"wav":  {both(at(0, "RIFF"), at(8, "WAVE"))},
+	"webm": ebml,
+	"webp": {both(at(0, "RIFF"), at(8, "WEBP"))},
+	"wma":  asf,
+	"wmv":  asf,
+	"zip":  zip,
+}
+
+// readHead reads up to sniffLength bytes from the start of the file at path
+func readHead(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	head := make([]byte, sniffLength)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// ContentMismatch reports whether the first bytes of the file match none of the
+// signatures of its extension, e.g. a disk image named .mp4, and returns the
+// type detected from them instead. Files of unchecked extensions, empty files
+// and files that cannot be read are not flagged; their upload reports the
+// latter.
+func (f *FileInfo) ContentMismatch() (string, bool) {
+	magics, ok := signatures[f.Extension]
+	if !ok {
+		return "", false
+	}
+	head, err := readHead(f.Path)
+	if err != nil || len(head) == 0 {
+		return "", false
+	}
+	for _, match := range magics {
+		if match(head) {
+			return "", false
+		}
+	}
+	return http.DetectContentType(head), true
+}
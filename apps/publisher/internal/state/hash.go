@@ -0,0 +1,77 @@
+package state
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// HashFile returns the hex SHA-256 of a file's content, as recorded in FileState.SHA256
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// indexHash records that path has the content of fs in the content hash index;
+// the caller holds the state lock. Imported files and directories are not
+// indexed, as their CIDs were not added from the hashed content.
+func (m *Manager) indexHash(path string, fs *FileState) {
+	if fs == nil || fs.SHA256 == "" || fs.Imported || fs.Directory {
+		return
+	}
+	if m.hashes == nil {
+		m.hashes = make(map[string]string)
+	}
+	m.hashes[fs.SHA256] = path
+}
+
+// recordedLocked returns the state of path, staged changes taking precedence,
+// or nil if it is not recorded or staged for removal; the caller holds the state lock
+func (m *Manager) recordedLocked(path string) *FileState {
+	if fs, ok := m.state.Staged[path]; ok {
+		return fs
+	}
+	return m.state.Files[path]
+}
+
+// CIDByHash returns the CID of a recorded or staged file whose content has the
+// given SHA-256, so identical content elsewhere can reuse it without being
+// added again
+func (m *Manager) CIDByHash(hash string) (string, bool) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+
+	path, ok := m.hashes[hash]
+	if !ok {
+		return "", false
+	}
+	if fs := m.recordedLocked(path); fs != nil && fs.SHA256 == hash {
+		return fs.CID, true
+	}
+
+	// The indexed file changed or was removed; another file may still have the content
+	delete(m.hashes, hash)
+	for _, files := range []map[string]*FileState{m.state.Staged, m.state.Files} {
+		for path, fs := range files {
+			if fs == nil || fs.SHA256 != hash || fs.Imported || fs.Directory {
+				continue
+			}
+			if current := m.recordedLocked(path); current == fs {
+				m.hashes[hash] = path
+				return fs.CID, true
+			}
+		}
+	}
+	return "", false
+}
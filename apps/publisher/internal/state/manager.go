@@ -1,36 +1,113 @@
 package state
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/atregu/ipfs-publisher/internal/logger"
 )
 
 // FileState represents the state of a single file
 type FileState struct {
-	CID     string `json:"cid"`
-	ModTime int64  `json:"mtime"`
-	Size    int64  `json:"size"`
-	IndexID int    `json:"indexId"`
+	CID          string `json:"cid"`
+	ModTime      int64  `json:"mtime"`
+	ModTimeNs    int64  `json:"mtimeNs,omitempty"` // Full-precision mtime; zero in states written before it was recorded
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256,omitempty"`       // Content hash (hex) of uploaded files; empty in states written before it was recorded
+	MetadataHash string `json:"metadataHash,omitempty"` // Hash of the metadata in the file's index record; empty if it has none
+	IndexID      int    `json:"indexId"`
+	Imported     bool   `json:"imported,omitempty"`  // Adopted by import; scans never upload or delete it
+	Group        string `json:"group,omitempty"`     // Index group of an imported file
+	Directory    bool   `json:"directory,omitempty"` // Imported local directory whose CID is a UnixFS directory
+}
+
+// UploadState tracks the parts of an interrupted chunked add so it can resume.
+// It is discarded when the file's size, mtime, the part size or the leaf format changes.
+type UploadState struct {
+	Size      int64    `json:"size"`
+	ModTime   int64    `json:"mtime"`
+	PartSize  int64    `json:"partSize"`
+	RawLeaves bool     `json:"rawLeaves,omitempty"`
+	Parts     []string `json:"parts"` // Part CIDs by index; empty for parts not yet added
+}
+
+// UploadFailure records a file whose upload failed permanently or after every retry.
+// It is dropped once the file uploads or is no longer found by a scan.
+type UploadFailure struct {
+	Error    string `json:"error"`
+	Attempts int    `json:"attempts"`
+	FailedAt int64  `json:"failedAt"` // Unix time of the last attempt
+}
+
+// PublishedRecord is the IPNS record last published for the collection
+type PublishedRecord struct {
+	Value       string   `json:"value"` // Root CID the record points at
+	Version     int      `json:"version"`
+	IPNS        string   `json:"ipns"`
+	Mirrors     []string `json:"mirrors,omitempty"`    // IPNS names of the mirror keys published with it
+	MirrorKeys  []string `json:"mirrorKeys,omitempty"` // Mirror keys published with it
+	PublishedAt int64    `json:"publishedAt"`          // Unix time the record was signed
+}
+
+// PublishedManifest is the manifest last published next to the index
+type PublishedManifest struct {
+	CID    string `json:"cid"`
+	SHA256 string `json:"sha256"` // Content hash (hex) of the manifest, compared to detect a change
+}
+
+// DirectoryChanges are the watched directories added and removed while the
+// publisher ran, applied over the configured directories at startup
+type DirectoryChanges struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// CollectionTree is the directory tree of the collection last built in the
+// node's MFS for publish.layout directory and both. It describes the tree in MFS,
+// published or not, so the next version only relinks the paths that changed.
+type CollectionTree struct {
+	CID     string            `json:"cid"`     // Root directory as last built
+	Entries map[string]string `json:"entries"` // CID linked at each path
 }
 
 // State represents the application state
 type State struct {
-	Version      int                   `json:"version"`
-	IPNS         string                `json:"ipns"`
-	LastIndexCID string                `json:"lastIndexCID"`
-	Files        map[string]*FileState `json:"files"`
-	mu           sync.RWMutex          `json:"-"`
+	Version      int                       `json:"version"`
+	IPNS         string                    `json:"ipns"`
+	LastIndexCID string                    `json:"lastIndexCID"`
+	LastRootCID  string                    `json:"lastRootCID,omitempty"`
+	IndexRootCID string                    `json:"indexRootCID,omitempty"` // Directory of the index and manifest when LastRootCID is the collection tree
+	Tree         *CollectionTree           `json:"tree,omitempty"`
+	Files        map[string]*FileState     `json:"files"`
+	Uploads      map[string]*UploadState   `json:"uploads,omitempty"`
+	Failed       map[string]*UploadFailure `json:"failedUploads,omitempty"`
+	Staged       map[string]*FileState     `json:"staged,omitempty"` // Uploaded but unpublished changes; nil marks a deletion
+	Acks         map[int][]string          `json:"acks,omitempty"`   // Public keys of the indexers that acknowledged each version
+	Published    *PublishedRecord          `json:"published,omitempty"`
+	Manifest     *PublishedManifest        `json:"manifest,omitempty"`
+	Directories  *DirectoryChanges         `json:"directories,omitempty"`
+	mu           sync.RWMutex              `json:"-"`
 }
 
 // Manager handles state persistence
 type Manager struct {
-	state *State
-	path  string
+	state  *State
+	path   string
+	hashes map[string]string // Content hash to a path recorded with it; see CIDByHash
+
+	saveMu       sync.Mutex    // Serializes saves, so an older snapshot never replaces a newer one
+	changes      atomic.Uint64 // Changes made so far; only changes under the state lock
+	saved        atomic.Uint64 // Value of changes in the last saved snapshot
+	unsavedFiles atomic.Int64  // File changes since the last saved snapshot
+	saveEvery    atomic.Int64  // File changes that trigger an AutoSave; 0 if none runs
+	flush        chan struct{}
 }
 
 // New creates a new state manager
@@ -40,7 +117,57 @@ func New(statePath string) *Manager {
 			Version: 0,
 			Files:   make(map[string]*FileState),
 		},
-		path: expandPath(statePath),
+		path:  expandPath(statePath),
+		flush: make(chan struct{}, 1),
+	}
+}
+
+// touch records a change; the caller holds the state lock
+func (m *Manager) touch() {
+	m.changes.Add(1)
+}
+
+// touchFile records a change of a file entry and asks a running AutoSave to
+// save after every saveEvery of them; the caller holds the state lock
+func (m *Manager) touchFile() {
+	m.touch()
+	if every := m.saveEvery.Load(); every > 0 && m.unsavedFiles.Add(1) == every {
+		select {
+		case m.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Dirty reports whether the state changed since it was last saved or loaded
+func (m *Manager) Dirty() bool {
+	return m.changes.Load() != m.saved.Load()
+}
+
+// AutoSave saves the state every interval and after every `every` file changes
+// (staged, recorded or removed files) while it has unsaved changes, until ctx is
+// done. With every <= 0 it saves on the interval only. Changes made while a save
+// runs wait only for the state to be marshalled, not for the file to be written.
+func (m *Manager) AutoSave(ctx context.Context, interval time.Duration, every int) {
+	m.saveEvery.Store(int64(max(every, 0)))
+	defer m.saveEvery.Store(0)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-m.flush:
+		}
+		if !m.Dirty() {
+			continue
+		}
+		if err := m.Save(); err != nil {
+			logger.Get().Errorf("Failed to save state: %v", err)
+		}
 	}
 }
 
@@ -75,18 +202,32 @@ func (m *Manager) Load() error {
 	if m.state.Files == nil {
 		m.state.Files = make(map[string]*FileState)
 	}
+	for path, fs := range m.state.Files {
+		m.indexHash(path, fs)
+	}
+	for path, fs := range m.state.Staged {
+		m.indexHash(path, fs)
+	}
 
 	log.Infof("Loaded state: version=%d, files=%d", m.state.Version, len(m.state.Files))
+	if len(m.state.Staged) > 0 {
+		log.Infof("Resuming staging: %d changes pending publication", len(m.state.Staged))
+	}
 	return nil
 }
 
-// Save writes state to disk
+// Save writes state to disk. It is safe to call while other goroutines change
+// the state; the snapshot written includes every change made before it.
 func (m *Manager) Save() error {
-	m.state.mu.Lock()
-	defer m.state.mu.Unlock()
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
 
-	// Marshal to JSON
+	// Changes wait for the snapshot only
+	m.state.mu.RLock()
+	changes := m.changes.Load()
+	m.unsavedFiles.Store(0)
 	data, err := json.MarshalIndent(m.state, "", "  ")
+	m.state.mu.RUnlock()
 	if err != nil {
 		return fmt.Errorf("failed to marshal state: %w", err)
 	}
@@ -103,6 +244,7 @@ func (m *Manager) Save() error {
 		return fmt.Errorf("failed to rename temp file: %w", err)
 	}
 
+	m.saved.Store(changes)
 	return nil
 }
 
@@ -119,14 +261,17 @@ func (m *Manager) GetFile(path string) (*FileState, bool) {
 func (m *Manager) SetFile(path string, fs *FileState) {
 	m.state.mu.Lock()
 	defer m.state.mu.Unlock()
+	m.touchFile()
 
 	m.state.Files[path] = fs
+	m.indexHash(path, fs)
 }
 
 // DeleteFile removes file from state
 func (m *Manager) DeleteFile(path string) {
 	m.state.mu.Lock()
 	defer m.state.mu.Unlock()
+	m.touchFile()
 
 	delete(m.state.Files, path)
 }
@@ -135,6 +280,7 @@ func (m *Manager) DeleteFile(path string) {
 func (m *Manager) IncrementVersion() int {
 	m.state.mu.Lock()
 	defer m.state.mu.Unlock()
+	m.touch()
 
 	m.state.Version++
 	return m.state.Version
@@ -152,6 +298,7 @@ func (m *Manager) GetVersion() int {
 func (m *Manager) SetIPNS(ipns string) {
 	m.state.mu.Lock()
 	defer m.state.mu.Unlock()
+	m.touch()
 
 	m.state.IPNS = ipns
 }
@@ -168,6 +315,7 @@ func (m *Manager) GetIPNS() string {
 func (m *Manager) SetLastIndexCID(cid string) {
 	m.state.mu.Lock()
 	defer m.state.mu.Unlock()
+	m.touch()
 
 	m.state.LastIndexCID = cid
 }
@@ -180,6 +328,408 @@ func (m *Manager) GetLastIndexCID() string {
 	return m.state.LastIndexCID
 }
 
+// SetLastRootCID sets the CID of the last collection root directory
+func (m *Manager) SetLastRootCID(cid string) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	m.touch()
+
+	m.state.LastRootCID = cid
+}
+
+// GetLastRootCID returns the CID of the last collection root directory, the
+// one IPNS points at: the directory of the index, or the collection tree
+func (m *Manager) GetLastRootCID() string {
+	m.state.mu.RLock()
+	defer m.state.mu.RUnlock()
+
+	return m.state.LastRootCID
+}
+
+// SetPublished records the IPNS record just published
+func (m *Manager) SetPublished(record *PublishedRecord) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	m.touch()
+
+	m.state.Published = record
+}
+
+// GetPublished returns the IPNS record last published, or nil if none was recorded
+func (m *Manager) GetPublished() *PublishedRecord {
+	m.state.mu.RLock()
+	defer m.state.mu.RUnlock()
+
+	if m.state.Published == nil {
+		return nil
+	}
+	record := *m.state.Published
+	return &record
+}
+
+// SetManifest records the manifest just published
+func (m *Manager) SetManifest(manifest *PublishedManifest) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	m.touch()
+
+	m.state.Manifest = manifest
+}
+
+// GetManifest returns the manifest last published, or nil if none was recorded
+func (m *Manager) GetManifest() *PublishedManifest {
+	m.state.mu.RLock()
+	defer m.state.mu.RUnlock()
+
+	if m.state.Manifest == nil {
+		return nil
+	}
+	manifest := *m.state.Manifest
+	return &manifest
+}
+
+// RecordDirectoryChange records dir as added to or removed from the watched
+// directories. Removing a directory added earlier only forgets the addition.
+func (m *Manager) RecordDirectoryChange(dir string, removed bool) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	m.touch()
+
+	changes := m.state.Directories
+	if changes == nil {
+		changes = &DirectoryChanges{}
+		m.state.Directories = changes
+	}
+	if removed {
+		if i := slices.Index(changes.Added, dir); i >= 0 {
+			changes.Added = slices.Delete(changes.Added, i, i+1)
+		} else if !slices.Contains(changes.Removed, dir) {
+			changes.Removed = append(changes.Removed, dir)
+		}
+	} else {
+		if i := slices.Index(changes.Removed, dir); i >= 0 {
+			changes.Removed = slices.Delete(changes.Removed, i, i+1)
+		} else if !slices.Contains(changes.Added, dir) {
+			changes.Added = append(changes.Added, dir)
+		}
+	}
+	if len(changes.Added) == 0 && len(changes.Removed) == 0 {
+		m.state.Directories = nil
+	}
+}
+
+// ApplyDirectoryChanges returns configured without the directories removed at
+// runtime and with those added, in the order they were added
+func (m *Manager) ApplyDirectoryChanges(configured []string) []string {
+	m.state.mu.RLock()
+	defer m.state.mu.RUnlock()
+
+	changes := m.state.Directories
+	if changes == nil {
+		return configured
+	}
+	dirs := slices.DeleteFunc(slices.Clone(configured), func(dir string) bool {
+		return slices.Contains(changes.Removed, dir)
+	})
+	for _, dir := range changes.Added {
+		if !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// GetIndexRootCID returns the directory holding only the index and the manifest
+// of the last version, or "" if LastRootCID is that directory
+func (m *Manager) GetIndexRootCID() string {
+	m.state.mu.RLock()
+	defer m.state.mu.RUnlock()
+
+	return m.state.IndexRootCID
+}
+
+// SetTree records the collection tree just built in MFS, or nil once it is removed
+func (m *Manager) SetTree(tree *CollectionTree) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	m.touch()
+
+	m.state.Tree = tree
+}
+
+// GetTree returns the collection tree last built, or nil if there is none.
+// Its entries are shared and must not be modified.
+func (m *Manager) GetTree() *CollectionTree {
+	m.state.mu.RLock()
+	defer m.state.mu.RUnlock()
+
+	if m.state.Tree == nil {
+		return nil
+	}
+	tree := *m.state.Tree
+	return &tree
+}
+
+// StageFile records an uploaded file as part of the pending change set.
+// It becomes visible in Files only when the change set is committed.
+func (m *Manager) StageFile(path string, fs *FileState) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	m.touchFile()
+
+	if m.state.Staged == nil {
+		m.state.Staged = make(map[string]*FileState)
+	}
+	m.state.Staged[path] = fs
+	m.indexHash(path, fs)
+}
+
+// StageDelete records a removed file as part of the pending change set
+func (m *Manager) StageDelete(path string) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	m.touchFile()
+
+	if m.state.Staged == nil {
+		m.state.Staged = make(map[string]*FileState)
+	}
+	m.state.Staged[path] = nil
+}
+
+// GetStagedFile returns the staged state of a file. A staged deletion
+// returns a nil FileState with exists set to true.
+func (m *Manager) GetStagedFile(path string) (*FileState, bool) {
+	m.state.mu.RLock()
+	defer m.state.mu.RUnlock()
+
+	fs, exists := m.state.Staged[path]
+	return fs, exists
+}
+
+// StagedCount returns the number of changes pending publication
+func (m *Manager) StagedCount() int {
+	m.state.mu.RLock()
+	defer m.state.mu.RUnlock()
+
+	return len(m.state.Staged)
+}
+
+// StagedSummary returns the status line describing the pending change set
+func (m *Manager) StagedSummary() string {
+	return fmt.Sprintf("staged changes: %d files pending publication", m.StagedCount())
+}
+
+// GetStaged returns a copy of the pending change set; nil values are deletions
+func (m *Manager) GetStaged() map[string]*FileState {
+	m.state.mu.RLock()
+	defer m.state.mu.RUnlock()
+
+	staged := make(map[string]*FileState, len(m.state.Staged))
+	for path, fs := range m.state.Staged {
+		staged[path] = fs
+	}
+	return staged
+}
+
+// CommitStaged applies a published change set to Files, drops it from the
+// staged changes and records the version it was published as. Everything
+// changes under one lock, so a Save at any time persists either the previous
+// version with the change set still staged or the new version with it applied.
+// indexRootCID is the directory of the index when rootCID is the collection
+// tree, and empty otherwise.
+func (m *Manager) CommitStaged(changes map[string]*FileState, version int, indexCID, rootCID, indexRootCID string) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	m.touch()
+
+	for path, fs := range changes {
+		if fs == nil {
+			delete(m.state.Files, path)
+		} else {
+			m.state.Files[path] = fs
+			m.indexHash(path, fs)
+		}
+		delete(m.state.Staged, path)
+	}
+	if len(m.state.Staged) == 0 {
+		m.state.Staged = nil
+	}
+
+	m.state.Version = version
+	m.state.LastIndexCID = indexCID
+	m.state.LastRootCID = rootCID
+	m.state.IndexRootCID = indexRootCID
+}
+
+// maxAckedVersions bounds the number of versions whose acks are kept;
+// the oldest version is dropped when a newer one is acknowledged
+const maxAckedVersions = 16
+
+// maxAckersPerVersion bounds the indexer keys stored per version
+const maxAckersPerVersion = 256
+
+// RecordAck records that the indexer with indexerKey acknowledged version.
+// It returns the number of distinct indexers that acknowledged the version and
+// whether indexerKey was new.
+func (m *Manager) RecordAck(version int, indexerKey string) (int, bool) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+
+	ackers, ok := m.state.Acks[version]
+	for _, key := range ackers {
+		if key == indexerKey {
+			return len(ackers), false
+		}
+	}
+	if len(ackers) >= maxAckersPerVersion {
+		return len(ackers), false
+	}
+
+	if !ok {
+		if m.state.Acks == nil {
+			m.state.Acks = make(map[int][]string)
+		}
+		if len(m.state.Acks) >= maxAckedVersions {
+			oldest := version
+			for v := range m.state.Acks {
+				if v < oldest {
+					oldest = v
+				}
+			}
+			// Acks of a version older than every kept one are not worth keeping
+			if oldest == version {
+				return 0, false
+			}
+			delete(m.state.Acks, oldest)
+		}
+	}
+
+	m.state.Acks[version] = append(ackers, indexerKey)
+	m.touch()
+	return len(ackers) + 1, true
+}
+
+// GetAckCount returns the number of distinct indexers that acknowledged version
+func (m *Manager) GetAckCount(version int) int {
+	m.state.mu.RLock()
+	defer m.state.mu.RUnlock()
+	return len(m.state.Acks[version])
+}
+
+// AckSummary returns the status line describing the acknowledgements of the current version
+func (m *Manager) AckSummary() string {
+	version := m.GetVersion()
+	count := m.GetAckCount(version)
+	if count == 1 {
+		return fmt.Sprintf("v%d acknowledged by 1 indexer", version)
+	}
+	return fmt.Sprintf("v%d acknowledged by %d indexers", version, count)
+}
+
+// GetUploadParts returns the part CIDs recorded for an unfinished chunked add of path,
+// or nil if there is none or it was for a different version of the file or leaf format
+func (m *Manager) GetUploadParts(path string, size, modTime, partSize int64, rawLeaves bool) []string {
+	m.state.mu.RLock()
+	defer m.state.mu.RUnlock()
+
+	u, exists := m.state.Uploads[path]
+	if !exists || u.Size != size || u.ModTime != modTime || u.PartSize != partSize || u.RawLeaves != rawLeaves {
+		return nil
+	}
+
+	parts := make([]string, len(u.Parts))
+	copy(parts, u.Parts)
+	return parts
+}
+
+// SetUploadPart records the CID of an added part, replacing any upload record
+// for a different version of the file
+func (m *Manager) SetUploadPart(path string, size, modTime, partSize int64, rawLeaves bool, index int, cid string) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	m.touch()
+
+	if m.state.Uploads == nil {
+		m.state.Uploads = make(map[string]*UploadState)
+	}
+
+	u, exists := m.state.Uploads[path]
+	if !exists || u.Size != size || u.ModTime != modTime || u.PartSize != partSize || u.RawLeaves != rawLeaves {
+		u = &UploadState{Size: size, ModTime: modTime, PartSize: partSize, RawLeaves: rawLeaves}
+		m.state.Uploads[path] = u
+	}
+
+	for len(u.Parts) <= index {
+		u.Parts = append(u.Parts, "")
+	}
+	u.Parts[index] = cid
+}
+
+// DeleteUpload removes the chunked add record of path
+func (m *Manager) DeleteUpload(path string) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	m.touch()
+
+	delete(m.state.Uploads, path)
+}
+
+// RecordUploadFailure records that the upload of path failed with err after attempts tries
+func (m *Manager) RecordUploadFailure(path string, err error, attempts int) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+	m.touch()
+
+	if m.state.Failed == nil {
+		m.state.Failed = make(map[string]*UploadFailure)
+	}
+	m.state.Failed[path] = &UploadFailure{Error: err.Error(), Attempts: attempts, FailedAt: time.Now().Unix()}
+}
+
+// ClearUploadFailure drops the failure record of path, if any
+func (m *Manager) ClearUploadFailure(path string) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+
+	if _, exists := m.state.Failed[path]; !exists {
+		return
+	}
+	m.touch()
+	delete(m.state.Failed, path)
+	if len(m.state.Failed) == 0 {
+		m.state.Failed = nil
+	}
+}
+
+// RetainUploadFailures drops the failure records of the paths keep rejects,
+// e.g. files a scan no longer finds
+func (m *Manager) RetainUploadFailures(keep func(path string) bool) {
+	m.state.mu.Lock()
+	defer m.state.mu.Unlock()
+
+	for path := range m.state.Failed {
+		if !keep(path) {
+			m.touch()
+			delete(m.state.Failed, path)
+		}
+	}
+	if len(m.state.Failed) == 0 {
+		m.state.Failed = nil
+	}
+}
+
+// GetUploadFailures returns a copy of the failure records by path
+func (m *Manager) GetUploadFailures() map[string]UploadFailure {
+	m.state.mu.RLock()
+	defer m.state.mu.RUnlock()
+
+	failures := make(map[string]UploadFailure, len(m.state.Failed))
+	for path, f := range m.state.Failed {
+		failures[path] = *f
+	}
+	return failures
+}
+
 // GetAllFiles returns a copy of all file states
 func (m *Manager) GetAllFiles() map[string]*FileState {
 	m.state.mu.RLock()
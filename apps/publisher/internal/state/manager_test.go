@@ -0,0 +1,322 @@
+package state
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRecordAck(t *testing.T) {
+	m := New(filepath.Join(t.TempDir(), "state.json"))
+
+	if count, added := m.RecordAck(3, "indexer-a"); count != 1 || !added {
+		t.Errorf("first ack = %d, %v, want 1, true", count, added)
+	}
+	if count, added := m.RecordAck(3, "indexer-a"); count != 1 || added {
+		t.Errorf("repeated ack = %d, %v, want 1, false", count, added)
+	}
+	if count, _ := m.RecordAck(3, "indexer-b"); count != 2 {
+		t.Errorf("second indexer counted %d, want 2", count)
+	}
+
+	// The acks of one version are capped
+	for i := 0; i < maxAckersPerVersion+5; i++ {
+		m.RecordAck(4, fmt.Sprintf("indexer-%d", i))
+	}
+	if got := m.GetAckCount(4); got != maxAckersPerVersion {
+		t.Errorf("version 4 has %d acks, want %d", got, maxAckersPerVersion)
+	}
+
+	// Only the newest versions are kept
+	for v := 5; v < 5+maxAckedVersions; v++ {
+		m.RecordAck(v, "indexer-a")
+	}
+	if m.GetAckCount(3) != 0 || m.GetAckCount(4) != 0 {
+		t.Error("acks of the oldest versions were not dropped")
+	}
+	if len(m.state.Acks) != maxAckedVersions {
+		t.Errorf("%d versions kept, want %d", len(m.state.Acks), maxAckedVersions)
+	}
+	if count, added := m.RecordAck(1, "indexer-a"); count != 0 || added {
+		t.Errorf("ack of a version older than all kept = %d, %v, want 0, false", count, added)
+	}
+}
+
+func TestAckSummaryPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	m := New(path)
+	m.CommitStaged(nil, 12, "QmIndex", "QmRoot", "")
+	m.RecordAck(12, "indexer-a")
+	m.RecordAck(12, "indexer-b")
+	m.RecordAck(12, "indexer-c")
+	if err := m.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded := New(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got, want := loaded.AckSummary(), "v12 acknowledged by 3 indexers"; got != want {
+		t.Errorf("AckSummary() = %q, want %q", got, want)
+	}
+}
+
+func TestTreePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	m := New(path)
+	m.CommitStaged(nil, 3, "QmIndex", "QmTree", "QmIndexRoot")
+	m.SetTree(&CollectionTree{CID: "QmTree", Entries: map[string]string{"Artist/a.mp3": "QmA", "collection.ndjson": "QmIndex"}})
+	if err := m.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded := New(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	tree := loaded.GetTree()
+	if tree == nil || tree.CID != "QmTree" || tree.Entries["Artist/a.mp3"] != "QmA" || len(tree.Entries) != 2 {
+		t.Fatalf("tree = %+v, want the saved tree", tree)
+	}
+	if loaded.GetLastRootCID() != "QmTree" || loaded.GetIndexRootCID() != "QmIndexRoot" {
+		t.Errorf("roots = %s, %s, want QmTree, QmIndexRoot", loaded.GetLastRootCID(), loaded.GetIndexRootCID())
+	}
+
+	// The ndjson layout commits without an index root and drops the tree
+	loaded.CommitStaged(nil, 4, "QmIndex2", "QmRoot", "")
+	loaded.SetTree(nil)
+	if loaded.GetIndexRootCID() != "" || loaded.GetTree() != nil {
+		t.Errorf("index root %q and tree %+v left after switching back", loaded.GetIndexRootCID(), loaded.GetTree())
+	}
+}
+
+func TestDirectoryChangesPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	m := New(path)
+	m.RecordDirectoryChange("/books", false)
+	m.RecordDirectoryChange("/video", false)
+	m.RecordDirectoryChange("/music", true)
+	m.RecordDirectoryChange("/video", true) // Forgets the addition
+	if err := m.Save(); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	loaded := New(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	dirs := loaded.ApplyDirectoryChanges([]string{"/music", "/podcasts"})
+	if !slices.Equal(dirs, []string{"/podcasts", "/books"}) {
+		t.Errorf("directories = %v, want [/podcasts /books]", dirs)
+	}
+
+	// Adding back a removed directory leaves the configured ones as they are
+	loaded.RecordDirectoryChange("/music", false)
+	loaded.RecordDirectoryChange("/books", true)
+	if dirs := loaded.ApplyDirectoryChanges([]string{"/music"}); !slices.Equal(dirs, []string{"/music"}) {
+		t.Errorf("directories = %v, want [/music]", dirs)
+	}
+}
+
+func TestUploadFailures(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	m := New(path)
+	m.RecordUploadFailure("/music/a.mp3", errors.New("connection refused"), 4)
+	m.RecordUploadFailure("/music/b.mp3", errors.New("permission denied"), 1)
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := New(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	failures := loaded.GetUploadFailures()
+	if f := failures["/music/a.mp3"]; len(failures) != 2 || f.Error != "connection refused" || f.Attempts != 4 || f.FailedAt == 0 {
+		t.Fatalf("loaded failures = %+v, want both with their error and attempts", failures)
+	}
+
+	loaded.ClearUploadFailure("/music/a.mp3")
+	loaded.RetainUploadFailures(func(path string) bool { return path != "/music/b.mp3" })
+	if failures := loaded.GetUploadFailures(); len(failures) != 0 {
+		t.Errorf("failures after clearing = %+v, want none", failures)
+	}
+	if !loaded.Dirty() {
+		t.Error("clearing failures did not mark the state dirty")
+	}
+}
+
+func TestHashFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "song.mp3")
+	if err := os.WriteFile(path, []byte("abc"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := HashFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"; hash != want {
+		t.Errorf("HashFile = %s, want %s", hash, want)
+	}
+
+	if _, err := HashFile(path + ".missing"); err == nil {
+		t.Error("HashFile of a missing file succeeded")
+	}
+}
+
+func TestCIDByHash(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	m := New(path)
+	m.SetFile("/music/a.mp3", &FileState{CID: "cid-a", SHA256: "hash-a"})
+	m.SetFile("/music/imported.mp3", &FileState{CID: "cid-i", SHA256: "hash-i", Imported: true})
+	m.StageFile("/music/b.mp3", &FileState{CID: "cid-b", SHA256: "hash-b"})
+
+	for hash, want := range map[string]string{"hash-a": "cid-a", "hash-b": "cid-b", "hash-i": "", "unknown": ""} {
+		if cid, _ := m.CIDByHash(hash); cid != want {
+			t.Errorf("CIDByHash(%s) = %q, want %q", hash, cid, want)
+		}
+	}
+
+	// A copy keeps the content known when the file indexed for it goes away
+	m.SetFile("/music/copy of a.mp3", &FileState{CID: "cid-a", SHA256: "hash-a"})
+	m.StageDelete("/music/copy of a.mp3")
+	if cid, ok := m.CIDByHash("hash-a"); !ok || cid != "cid-a" {
+		t.Errorf("CIDByHash after removing a copy = %q, %v, want cid-a", cid, ok)
+	}
+	m.StageDelete("/music/a.mp3")
+	if cid, ok := m.CIDByHash("hash-a"); ok {
+		t.Errorf("CIDByHash of removed content = %q, want none", cid)
+	}
+
+	// The index is rebuilt from a loaded state
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := New(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if cid, ok := loaded.CIDByHash("hash-b"); !ok || cid != "cid-b" {
+		t.Errorf("CIDByHash after Load = %q, %v, want cid-b", cid, ok)
+	}
+}
+
+func TestDirty(t *testing.T) {
+	m := New(filepath.Join(t.TempDir(), "state.json"))
+	if m.Dirty() {
+		t.Fatal("new state is dirty")
+	}
+
+	m.StageFile("/media/a.mkv", &FileState{CID: "QmA"})
+	if !m.Dirty() {
+		t.Fatal("staged file did not make the state dirty")
+	}
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if m.Dirty() {
+		t.Fatal("state is dirty after saving")
+	}
+
+	if _, added := m.RecordAck(1, "indexer-a"); !added || !m.Dirty() {
+		t.Error("new ack did not make the state dirty")
+	}
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	if _, added := m.RecordAck(1, "indexer-a"); added || m.Dirty() {
+		t.Error("repeated ack made the state dirty")
+	}
+}
+
+func TestSaveWhileChanging(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	m := New(path)
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				m.SetFile(fmt.Sprintf("/media/%d-%d.mkv", w, i), &FileState{CID: "QmA", Size: int64(i)})
+			}
+		}()
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if err := m.Save(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+
+	// The last save holds every change
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+	loaded := New(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(loaded.GetAllFiles()); got != 800 {
+		t.Errorf("saved state has %d files, want 800", got)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temp file left behind: %v", err)
+	}
+}
+
+func TestAutoSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	m := New(path)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		m.AutoSave(ctx, time.Hour, 3)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// AutoSave counts files once it runs
+	deadline := time.Now().Add(5 * time.Second)
+	for m.saveEvery.Load() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+
+	m.StageFile("/media/a.mkv", &FileState{CID: "QmA"})
+	m.StageFile("/media/b.mkv", &FileState{CID: "QmB"})
+	time.Sleep(50 * time.Millisecond)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("state saved before 3 files changed: %v", err)
+	}
+
+	// The third file triggers a save long before the interval
+	m.StageDelete("/media/c.mkv")
+	for m.Dirty() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	loaded := New(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.StagedCount(); got != 3 {
+		t.Errorf("saved state has %d staged changes, want 3", got)
+	}
+}
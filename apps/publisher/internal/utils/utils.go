@@ -5,11 +5,16 @@ import (
 	"path/filepath"
 	"strings"
 	"unicode"
+
+	"github.com/atregu/ipfs-common/extensions"
 )
 
 const (
 	// MaxFilenameLength is the maximum allowed filename length
 	MaxFilenameLength = 255
+
+	// MaxGroupLength is the maximum length of a directory group
+	MaxGroupLength = 1024
 )
 
 // SanitizeFilename sanitizes a filename by removing or replacing unsafe characters
@@ -61,6 +66,38 @@ func SanitizeFilename(filename string) string {
 	return sanitized
 }
 
+// GroupForPath returns the directory group of a file: its parent directory
+// relative to root, using "/" separators (e.g. "Artist/Album/Disc 1").
+// Control characters and backslashes are removed and "." or ".." components dropped.
+// Files directly in root, or outside it, have an empty group.
+func GroupForPath(root, path string) string {
+	rel, err := filepath.Rel(root, filepath.Dir(path))
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+
+	var parts []string
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		part = strings.Map(func(r rune) rune {
+			if unicode.IsControl(r) || r == '\\' {
+				return -1
+			}
+			return r
+		}, part)
+		part = strings.TrimSpace(part)
+		if part == "" || part == "." || part == ".." {
+			continue
+		}
+		parts = append(parts, part)
+	}
+
+	group := strings.Join(parts, "/")
+	if len(group) > MaxGroupLength {
+		group = strings.TrimRight(group[:MaxGroupLength], "/")
+	}
+	return group
+}
+
 // IsValidPath checks if a path is safe and doesn't contain path traversal attempts
 func IsValidPath(path string) bool {
 	// Check for empty path
@@ -131,17 +168,7 @@ func HasExtension(filename string, allowedExts []string) bool {
 		return true // If no extensions specified, allow all
 	}
 
-	ext := strings.TrimPrefix(filepath.Ext(filename), ".")
-	extLower := strings.ToLower(ext)
-
-	for _, allowed := range allowedExts {
-		allowedLower := strings.ToLower(strings.TrimPrefix(allowed, "."))
-		if extLower == allowedLower {
-			return true
-		}
-	}
-
-	return false
+	return extensions.NewSet(allowedExts).Matches(filename)
 }
 
 // FormatBytes formats a byte count as a human-readable string
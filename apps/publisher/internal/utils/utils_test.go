@@ -0,0 +1,27 @@
+package utils
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGroupForPath(t *testing.T) {
+	root := filepath.Join("/", "media", "music")
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{filepath.Join(root, "song.mp3"), ""},
+		{filepath.Join(root, "Artist", "song.mp3"), "Artist"},
+		{filepath.Join(root, "Artist", "Album", "Disc 1", "01 Intro.flac"), "Artist/Album/Disc 1"},
+		{filepath.Join(root, " Artist ", "Al\tbum", "track.flac"), "Artist/Album"},
+		{filepath.Join("/", "media", "other", "song.mp3"), ""},
+	}
+
+	for _, tt := range tests {
+		if got := GroupForPath(root, tt.path); got != tt.want {
+			t.Errorf("GroupForPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
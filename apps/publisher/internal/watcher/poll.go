@@ -0,0 +1,254 @@
+package watcher
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// watchLimitHint tells how to raise the inotify watch limit
+const watchLimitHint = "raise it with 'sysctl fs.inotify.max_user_watches=524288' and persist the setting in /etc/sysctl.d/ to watch everything"
+
+// WatchStatus describes how the watched directories are covered
+type WatchStatus struct {
+	WatchedDirectories int      `json:"watched_directories"` // Directories watched through the OS
+	PolledSubtrees     []string `json:"polled_subtrees"`     // Subtrees scanned every poll interval instead
+	Polls              uint64   `json:"polls"`               // Scans of the polled subtrees so far
+	PollInterval       string   `json:"poll_interval"`
+	WatchLimitReached  bool     `json:"watch_limit_reached"` // Subtrees are polled because inotify ran out of watches
+}
+
+// fileStamp is the size and modification time of a polled file
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// watchDir watches dir through fsnotify. If the OS is out of watches, dir and
+// everything below it is polled instead and watchDir reports true.
+func (w *Watcher) watchDir(dir string) (polled bool, err error) {
+	if err := w.addWatch(dir); err != nil {
+		if !errors.Is(err, syscall.ENOSPC) {
+			return false, err
+		}
+		w.pollSubtree(dir)
+		return true, nil
+	}
+
+	w.pollMu.Lock()
+	w.watched++
+	w.pollMu.Unlock()
+	w.updateCoverage()
+	return false, nil
+}
+
+// pollSubtree adds dir to the polled subtrees. The files already in it are
+// reported as created by the next poll, unless the watcher is still starting
+// up, when the initial scan covers them.
+func (w *Watcher) pollSubtree(dir string) {
+	w.pollMu.Lock()
+	w.pollRoots = append(w.pollRoots, dir)
+	if w.pollRunning {
+		w.newRoots = append(w.newRoots, dir)
+	}
+	if !w.pollAll {
+		w.limitReached = true
+	}
+	w.pollMu.Unlock()
+	w.updateCoverage()
+}
+
+// updateCoverage exports the number of watched directories and polled subtrees
+func (w *Watcher) updateCoverage() {
+	w.pollMu.Lock()
+	defer w.pollMu.Unlock()
+	w.watchMetrics.Covered(w.watched, len(w.pollRoots))
+}
+
+// startPolling takes the first snapshot of the polled subtrees and starts
+// polling them every poll interval; subtrees added later are polled as well
+func (w *Watcher) startPolling() {
+	w.pollMu.Lock()
+	roots := append([]string(nil), w.pollRoots...)
+	w.pollRunning = true
+	w.pollMu.Unlock()
+
+	w.pollFiles = w.snapshot(roots)
+	w.pollDone = make(chan struct{})
+	go w.pollLoop()
+}
+
+// pollLoop polls until the watcher stops
+func (w *Watcher) pollLoop() {
+	defer close(w.pollDone)
+
+	ticker := time.NewTicker(w.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			w.poll()
+		case <-w.stopPoll:
+			return
+		}
+	}
+}
+
+// poll scans the polled subtrees and emits an event for every file created,
+// modified or deleted since the previous poll, debounced like fsnotify events
+func (w *Watcher) poll() {
+	w.pollMu.Lock()
+	roots := append([]string(nil), w.pollRoots...)
+	added := w.newRoots
+	w.newRoots = nil
+	w.pollMu.Unlock()
+
+	if len(roots) == 0 {
+		return
+	}
+
+	current := w.snapshot(roots)
+	w.polls.Add(1)
+	w.watchMetrics.Polled()
+
+	// Files of subtrees polled since the last poll are new to the publisher, and
+	// those of subtrees no longer polled are dropped without events
+	previous := w.pollFiles
+	for path := range previous {
+		if !slices.ContainsFunc(roots, func(root string) bool { return isBelow(path, root) }) {
+			delete(previous, path)
+		}
+	}
+	for _, root := range added {
+		for path := range previous {
+			if isBelow(path, root) {
+				delete(previous, path)
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(current)+len(previous))
+	for path := range current {
+		paths = append(paths, path)
+	}
+	for path := range previous {
+		if _, ok := current[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		before, existed := previous[path]
+		after, exists := current[path]
+
+		var eventType EventType
+		switch {
+		case !existed:
+			eventType = EventCreate
+		case !exists:
+			eventType = EventDelete
+		case before != after:
+			eventType = EventModify
+		default:
+			continue
+		}
+
+		w.recordEvent(path, eventType)
+		w.debouncer.debounce(path, func() {
+			select {
+			case w.eventChan <- FileEvent{Path: path, EventType: eventType, Timestamp: time.Now()}:
+			case <-w.stopPoll:
+			}
+		})
+	}
+
+	w.pollFiles = current
+}
+
+// snapshot returns the size and modification time of the media files below roots
+func (w *Watcher) snapshot(roots []string) map[string]fileStamp {
+	files := make(map[string]fileStamp)
+	for _, root := range roots {
+		_ = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return nil // Vanished or unreadable entries are picked up by the next poll
+			}
+
+			name := info.Name()
+			if info.IsDir() {
+				if strings.HasPrefix(name, ".") && path != root {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.Mode().IsRegular() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, "~") || !w.hasValidExtension(path) {
+				return nil
+			}
+
+			files[path] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+			return nil
+		})
+	}
+	return files
+}
+
+// isBelow reports whether path is dir or inside it
+func isBelow(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+string(filepath.Separator))
+}
+
+// logCoverage reports the subtrees polled after the watches were set up
+func (w *Watcher) logCoverage() {
+	status := w.Status()
+	if len(status.PolledSubtrees) == 0 {
+		return
+	}
+
+	log := logger.Get()
+	if !status.WatchLimitReached {
+		log.Infof("Polling %d directories every %v", len(status.PolledSubtrees), w.pollInterval)
+		return
+	}
+	log.Warnf("inotify watch limit reached after %d directories: %d subtrees that could not be watched are polled every %v instead; %s",
+		status.WatchedDirectories, len(status.PolledSubtrees), w.pollInterval, watchLimitHint)
+}
+
+// Status returns how the watched directories are covered
+func (w *Watcher) Status() WatchStatus {
+	w.pollMu.Lock()
+	defer w.pollMu.Unlock()
+
+	polled := append([]string{}, w.pollRoots...)
+	sort.Strings(polled)
+	return WatchStatus{
+		WatchedDirectories: w.watched,
+		PolledSubtrees:     polled,
+		Polls:              w.polls.Load(),
+		PollInterval:       w.pollInterval.String(),
+		WatchLimitReached:  w.limitReached,
+	}
+}
+
+// StatusHandler returns an HTTP handler for GET /api/v1/watcher/status
+func (w *Watcher) StatusHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(w.Status())
+	})
+}
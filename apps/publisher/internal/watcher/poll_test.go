@@ -0,0 +1,166 @@
+package watcher
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+// startLimitedWatcher starts a watcher on root that runs out of inotify watches
+// at full, so full and everything below it is polled
+func startLimitedWatcher(t *testing.T, root, full string) *Watcher {
+	t.Helper()
+	logger.Get().SetOutput(io.Discard)
+
+	w, err := NewWatcher(&Config{Extensions: []string{"mp3"}, PollInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := w.addWatch
+	w.addWatch = func(path string) error {
+		if path == full {
+			return syscall.ENOSPC
+		}
+		return add(path)
+	}
+
+	if err := w.Start([]string{root}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Stop() })
+	return w
+}
+
+// nextEvent returns the next event of the watcher for path, skipping others
+func nextEvent(t *testing.T, w *Watcher, path string) FileEvent {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-w.Events():
+			if event.Path == path {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("no event for %s", path)
+		}
+	}
+}
+
+func TestSubtreeBeyondWatchLimitIsPolled(t *testing.T) {
+	root := t.TempDir()
+	full := filepath.Join(root, "full")
+	deep := filepath.Join(full, "deep")
+	if err := os.MkdirAll(deep, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	existing := filepath.Join(deep, "existing.mp3")
+	if err := os.WriteFile(existing, []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	w := startLimitedWatcher(t, root, full)
+
+	status := w.Status()
+	if status.WatchedDirectories != 1 || len(status.PolledSubtrees) != 1 || status.PolledSubtrees[0] != full {
+		t.Errorf("status = %+v, want root watched and %s polled", status, full)
+	}
+	if !status.WatchLimitReached {
+		t.Error("watch limit not reported")
+	}
+
+	created := filepath.Join(deep, "new.mp3")
+	if err := os.WriteFile(created, []byte("b"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(t, w, created); event.EventType != EventCreate {
+		t.Errorf("%s: %s event, want create", created, event.EventType)
+	}
+
+	if err := os.WriteFile(existing, []byte("longer"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(t, w, existing); event.EventType != EventModify {
+		t.Errorf("%s: %s event, want modify", existing, event.EventType)
+	}
+
+	if err := os.Remove(created); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(t, w, created); event.EventType != EventDelete {
+		t.Errorf("%s: %s event, want delete", created, event.EventType)
+	}
+
+	if w.Status().Polls == 0 {
+		t.Error("polls not counted")
+	}
+}
+
+func TestPollAllWatchesNothing(t *testing.T) {
+	logger.Get().SetOutput(io.Discard)
+	root := t.TempDir()
+
+	w, err := NewWatcher(&Config{Extensions: []string{"mp3"}, PollAll: true, PollInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w.watcher != nil {
+		t.Error("fsnotify watcher created in poll mode")
+	}
+	w.addWatch = func(path string) error {
+		t.Errorf("%s watched in poll mode", path)
+		return nil
+	}
+	if err := w.Start([]string{root}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Stop() })
+
+	status := w.Status()
+	if status.WatchedDirectories != 0 || len(status.PolledSubtrees) != 1 || status.WatchLimitReached {
+		t.Errorf("status = %+v, want %s polled without the watch limit", status, root)
+	}
+
+	created := filepath.Join(root, "new.mp3")
+	if err := os.WriteFile(created, []byte("b"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(t, w, created); event.EventType != EventCreate {
+		t.Errorf("%s: %s event, want create", created, event.EventType)
+	}
+}
+
+func TestPolledEventsAreDebounced(t *testing.T) {
+	logger.Get().SetOutput(io.Discard)
+	root := t.TempDir()
+
+	w, err := NewWatcher(&Config{Extensions: []string{"mp3"}, PollAll: true, PollInterval: 10 * time.Millisecond, DebounceDelay: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Start([]string{root}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Stop() })
+
+	// A file removed again within the debounce delay only reports its deletion
+	brief := filepath.Join(root, "brief.mp3")
+	if err := os.WriteFile(brief, []byte("a"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for w.eventCounter.Total("CREATE") == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if err := os.Remove(brief); err != nil {
+		t.Fatal(err)
+	}
+	if event := nextEvent(t, w, brief); event.EventType != EventDelete {
+		t.Errorf("%s: %s event, want only the delete", brief, event.EventType)
+	}
+}
@@ -1,14 +1,23 @@
 package watcher
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/atregu/ipfs-common/extensions"
+
 	"github.com/atregu/ipfs-publisher/internal/logger"
+	"github.com/atregu/ipfs-publisher/internal/metrics"
 	"github.com/fsnotify/fsnotify"
 )
 
@@ -47,11 +56,50 @@ func (e EventType) String() string {
 // Watcher monitors directories for file changes
 type Watcher struct {
 	watcher    *fsnotify.Watcher
-	extensions map[string]bool
+	extensions extensions.Set
 	debouncer  *debouncer
 	eventChan  chan FileEvent
 	mu         sync.RWMutex
 	started    bool
+	roots      []string // Directories given to Start and AddDirectory
+
+	eventCounter *metrics.EventCounter
+	dirEvents    map[string]*dirActivity
+	dirMu        sync.Mutex
+
+	addWatch     func(path string) error // Adds an fsnotify watch, replaced by tests
+	pollAll      bool
+	pollInterval time.Duration
+	pollFiles    map[string]fileStamp // Files of the polled subtrees at the last poll
+	pollDone     chan struct{}        // Closed when the poll loop exits
+	stopPoll     chan struct{}
+	polls        atomic.Uint64
+	watchMetrics *metrics.WatchMetrics
+
+	pollMu       sync.Mutex
+	watched      int      // Directories watched through fsnotify
+	pollRoots    []string // Subtrees polled instead of watched
+	newRoots     []string // Subtrees polled since the last poll
+	pollRunning  bool
+	limitReached bool
+}
+
+// maxHotspotDirs bounds the number of directories tracked for hotspot statistics.
+// When a new directory exceeds it, the least recently active one is dropped.
+const maxHotspotDirs = 1000
+
+// dirActivity holds the hotspot statistics of a single directory
+type dirActivity struct {
+	events    uint64
+	firstSeen time.Time
+	lastSeen  time.Time
+}
+
+// HotspotEntry describes a directory and how many file events it generated
+type HotspotEntry struct {
+	Directory       string  `json:"directory"`
+	Events          uint64  `json:"events"`
+	EventsPerMinute float64 `json:"events_per_minute"`
 }
 
 // Config holds watcher configuration
@@ -60,19 +108,24 @@ type Config struct {
 	Extensions     []string
 	DebounceDelay  time.Duration
 	EventQueueSize int
+	PollAll        bool          // Poll every directory instead of watching it, without using fsnotify at all
+	PollInterval   time.Duration // Interval of polled subtrees; also used when inotify runs out of watches
 }
 
-// NewWatcher creates a new file watcher
-func NewWatcher(cfg *Config) (*Watcher, error) {
-	fsWatcher, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create fsnotify watcher: %w", err)
-	}
+// defaultPollInterval is the poll interval when Config.PollInterval is zero
+const defaultPollInterval = 10 * time.Second
 
-	// Build extension map
-	extMap := make(map[string]bool)
-	for _, ext := range cfg.Extensions {
-		extMap[strings.ToLower(ext)] = true
+// NewWatcher creates a new file watcher. The polling mode of Config.PollAll
+// applies to this watcher only, so network filesystems can be polled by one
+// watcher while local directories are watched by another.
+func NewWatcher(cfg *Config) (*Watcher, error) {
+	var fsWatcher *fsnotify.Watcher
+	if !cfg.PollAll {
+		var err error
+		fsWatcher, err = fsnotify.NewWatcher()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create fsnotify watcher: %w", err)
+		}
 	}
 
 	debounceDelay := cfg.DebounceDelay
@@ -85,17 +138,35 @@ func NewWatcher(cfg *Config) (*Watcher, error) {
 		eventQueueSize = 100
 	}
 
+	pollInterval := cfg.PollInterval
+	if pollInterval == 0 {
+		pollInterval = defaultPollInterval
+	}
+
 	w := &Watcher{
 		watcher:    fsWatcher,
-		extensions: extMap,
+		extensions: extensions.NewSet(cfg.Extensions),
 		debouncer:  newDebouncer(debounceDelay),
 		eventChan:  make(chan FileEvent, eventQueueSize),
+
+		eventCounter: metrics.NewEventCounter(),
+		dirEvents:    make(map[string]*dirActivity),
+
+		pollAll:      cfg.PollAll,
+		pollInterval: pollInterval,
+		stopPoll:     make(chan struct{}),
+		watchMetrics: metrics.NewWatchMetrics(),
+	}
+	if fsWatcher != nil {
+		w.addWatch = fsWatcher.Add
 	}
 
 	return w, nil
 }
 
-// Start starts watching directories
+// Start starts watching directories. A subtree that cannot be watched because
+// the OS ran out of inotify watches is polled instead, as is everything with
+// Config.PollAll.
 func (w *Watcher) Start(directories []string) error {
 	w.mu.Lock()
 	defer w.mu.Unlock()
@@ -104,46 +175,143 @@ func (w *Watcher) Start(directories []string) error {
 		return fmt.Errorf("watcher already started")
 	}
 
-	log := logger.Get()
-
 	// Add directories to watch
 	for _, dir := range directories {
-		// Expand ~ in path and make absolute/clean
-		expandedDir := expandPath(dir)
+		if err := w.addDirectory(expandPath(dir)); err != nil {
+			return err
+		}
+	}
 
-		// Walk directory tree and add all subdirectories
-		err := filepath.Walk(expandedDir, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				log.Warnf("Failed to access path %s: %v", path, err)
-				return nil // Continue walking
-			}
+	w.logCoverage()
+	w.startPolling()
 
-			if info.IsDir() {
-				// Skip hidden directories
-				if strings.HasPrefix(info.Name(), ".") && path != expandedDir {
-					return filepath.SkipDir
-				}
+	// Start event processing; a polling watcher has no fsnotify events
+	if w.watcher != nil {
+		go w.processEvents()
+	}
 
-				if err := w.watcher.Add(path); err != nil {
-					log.Warnf("Failed to watch directory %s: %v", path, err)
-					return nil
-				}
-				log.Debugf("Watching directory: %s", path)
+	w.started = true
+	return nil
+}
+
+// AddDirectory starts watching dir and its subdirectories while the watcher
+// runs, exactly as Start does. Files already in dir produce no events, except
+// in poll mode, where the next poll reports them as created.
+func (w *Watcher) AddDirectory(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.started {
+		return fmt.Errorf("watcher not started")
+	}
+
+	dir = expandPath(dir)
+	for _, root := range w.roots {
+		if isBelow(dir, root) || isBelow(root, dir) {
+			return fmt.Errorf("%s overlaps the watched directory %s", dir, root)
+		}
+	}
+
+	if err := w.addDirectory(dir); err != nil {
+		return err
+	}
+	w.logCoverage()
+	return nil
+}
+
+// RemoveDirectory stops watching dir, a directory given to Start or
+// AddDirectory, and everything below it. Its files produce no further events.
+func (w *Watcher) RemoveDirectory(dir string) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if !w.started {
+		return fmt.Errorf("watcher not started")
+	}
+
+	dir = expandPath(dir)
+	i := slices.Index(w.roots, dir)
+	if i < 0 {
+		return fmt.Errorf("%s is not a watched directory", dir)
+	}
+	w.roots = slices.Delete(w.roots, i, i+1)
+
+	removed := 0
+	if w.watcher != nil {
+		for _, path := range w.watcher.WatchList() {
+			if !isBelow(path, dir) {
+				continue
 			}
-			return nil
-		})
+			// A directory deleted meanwhile has lost its watch already
+			if err := w.watcher.Remove(path); err != nil {
+				logger.Get().Debugf("Failed to remove the watch of %s: %v", path, err)
+			}
+			removed++
+		}
+	}
+
+	w.pollMu.Lock()
+	w.watched -= removed
+	w.pollRoots = slices.DeleteFunc(w.pollRoots, func(root string) bool { return isBelow(root, dir) })
+	w.newRoots = slices.DeleteFunc(w.newRoots, func(root string) bool { return isBelow(root, dir) })
+	w.pollMu.Unlock()
+	w.updateCoverage()
+
+	logger.Get().Infof("Stopped watching: %s", dir)
+	return nil
+}
 
+// Directories returns the directories given to Start and AddDirectory and not removed since
+func (w *Watcher) Directories() []string {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return append([]string{}, w.roots...)
+}
+
+// addDirectory watches dir and its subdirectories, or polls it in poll mode.
+// w.mu must be held.
+func (w *Watcher) addDirectory(dir string) error {
+	log := logger.Get()
+	w.roots = append(w.roots, dir)
+
+	if w.pollAll {
+		w.pollSubtree(dir)
+		log.Infof("Started polling: %s", dir)
+		return nil
+	}
+
+	// Walk directory tree and add all subdirectories
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			return fmt.Errorf("failed to walk directory %s: %w", expandedDir, err)
+			log.Warnf("Failed to access path %s: %v", path, err)
+			return nil // Continue walking
 		}
 
-		log.Infof("Started watching: %s", expandedDir)
-	}
+		if info.IsDir() {
+			// Skip hidden directories
+			if strings.HasPrefix(info.Name(), ".") && path != dir {
+				return filepath.SkipDir
+			}
 
-	// Start event processing
-	go w.processEvents()
+			polled, err := w.watchDir(path)
+			if err != nil {
+				log.Warnf("Failed to watch directory %s: %v", path, err)
+				return nil
+			}
+			if polled {
+				log.Debugf("Polling directory: %s", path)
+				return filepath.SkipDir
+			}
+			log.Debugf("Watching directory: %s", path)
+		}
+		return nil
+	})
 
-	w.started = true
+	if err != nil {
+		return fmt.Errorf("failed to walk directory %s: %w", dir, err)
+	}
+
+	log.Infof("Started watching: %s", dir)
 	return nil
 }
 
@@ -181,8 +349,10 @@ func (w *Watcher) handleEvent(event fsnotify.Event) {
 		// New directory created - add it to watch list
 		if event.Op&fsnotify.Create == fsnotify.Create {
 			if !strings.HasPrefix(filepath.Base(event.Name), ".") {
-				if err := w.watcher.Add(event.Name); err != nil {
+				if polled, err := w.watchDir(event.Name); err != nil {
 					log.Warnf("Failed to watch new directory %s: %v", event.Name, err)
+				} else if polled {
+					log.Warnf("inotify watch limit reached: new directory %s is polled every %v instead; %s", event.Name, w.pollInterval, watchLimitHint)
 				} else {
 					log.Debugf("Started watching new directory: %s", event.Name)
 				}
@@ -221,6 +391,7 @@ func (w *Watcher) handleEvent(event fsnotify.Event) {
 	}
 
 	log.Debugf("File event: %s %s", eventType, event.Name)
+	w.recordEvent(event.Name, eventType)
 
 	// Debounce the event
 	w.debouncer.debounce(event.Name, func() {
@@ -239,11 +410,113 @@ func (w *Watcher) handleEvent(event fsnotify.Event) {
 	})
 }
 
+// recordEvent updates event counters and per-directory hotspot statistics
+func (w *Watcher) recordEvent(path string, eventType EventType) {
+	w.eventCounter.Inc(eventType.String(), extensions.Of(path))
+
+	now := time.Now()
+	dir := filepath.Dir(path)
+
+	w.dirMu.Lock()
+	defer w.dirMu.Unlock()
+
+	activity, ok := w.dirEvents[dir]
+	if !ok {
+		if len(w.dirEvents) >= maxHotspotDirs {
+			w.evictIdlestDir()
+		}
+		activity = &dirActivity{firstSeen: now}
+		w.dirEvents[dir] = activity
+	}
+	activity.events++
+	activity.lastSeen = now
+}
+
+// evictIdlestDir drops the directory with the oldest last event. dirMu must be held.
+func (w *Watcher) evictIdlestDir() {
+	var idlest string
+	var idlestSeen time.Time
+	for dir, activity := range w.dirEvents {
+		if idlest == "" || activity.lastSeen.Before(idlestSeen) {
+			idlest, idlestSeen = dir, activity.lastSeen
+		}
+	}
+	delete(w.dirEvents, idlest)
+}
+
+// EventCounter returns the counter of file events by type and extension
+func (w *Watcher) EventCounter() *metrics.EventCounter {
+	return w.eventCounter
+}
+
+// WatchMetrics returns the watched directory and polling metrics
+func (w *Watcher) WatchMetrics() *metrics.WatchMetrics {
+	return w.watchMetrics
+}
+
+// GetTopHotspots returns the n directories with the most events per minute.
+// The rate of a directory is measured from its first recorded event.
+func (w *Watcher) GetTopHotspots(n int) []HotspotEntry {
+	now := time.Now()
+
+	w.dirMu.Lock()
+	entries := make([]HotspotEntry, 0, len(w.dirEvents))
+	for dir, activity := range w.dirEvents {
+		minutes := now.Sub(activity.firstSeen).Minutes()
+		if minutes < 1 {
+			minutes = 1
+		}
+		entries = append(entries, HotspotEntry{
+			Directory:       dir,
+			Events:          activity.events,
+			EventsPerMinute: float64(activity.events) / minutes,
+		})
+	}
+	w.dirMu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].EventsPerMinute != entries[j].EventsPerMinute {
+			return entries[i].EventsPerMinute > entries[j].EventsPerMinute
+		}
+		if entries[i].Events != entries[j].Events {
+			return entries[i].Events > entries[j].Events
+		}
+		return entries[i].Directory < entries[j].Directory
+	})
+
+	if n > 0 && len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// HotspotsHandler returns an HTTP handler for GET /api/v1/watcher/hotspots.
+// The optional "n" query parameter limits the number of entries (default 10).
+func (w *Watcher) HotspotsHandler() http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		n := 10
+		if raw := r.URL.Query().Get("n"); raw != "" {
+			parsed, err := strconv.Atoi(raw)
+			if err != nil || parsed <= 0 {
+				http.Error(rw, "invalid n", http.StatusBadRequest)
+				return
+			}
+			n = parsed
+		}
+
+		rw.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(rw).Encode(w.GetTopHotspots(n))
+	})
+}
+
 // hasValidExtension checks if file has valid extension
 func (w *Watcher) hasValidExtension(path string) bool {
-	ext := strings.TrimPrefix(filepath.Ext(path), ".")
-	ext = strings.ToLower(ext)
-	return w.extensions[ext]
+	return w.extensions.Matches(path)
 }
 
 // Events returns the channel for receiving file events
@@ -263,13 +536,20 @@ func (w *Watcher) Stop() error {
 	log := logger.Get()
 	log.Info("Stopping file watcher...")
 
-	if err := w.watcher.Close(); err != nil {
-		return fmt.Errorf("failed to close watcher: %w", err)
+	if w.watcher != nil {
+		if err := w.watcher.Close(); err != nil {
+			return fmt.Errorf("failed to close watcher: %w", err)
+		}
 	}
 
-	close(w.eventChan)
+	// Debounced polled events may be waiting to be delivered, so they give up
+	// and pending ones are dropped before the channel closes
+	close(w.stopPoll)
+	<-w.pollDone
 	w.debouncer.stop()
 
+	close(w.eventChan)
+
 	w.started = false
 	log.Info("File watcher stopped")
 	return nil
@@ -0,0 +1,212 @@
+package watcher
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/atregu/ipfs-publisher/internal/logger"
+)
+
+func newTestWatcher(t *testing.T) *Watcher {
+	t.Helper()
+	logger.Get().SetOutput(io.Discard)
+
+	w, err := NewWatcher(&Config{Extensions: []string{"mp3"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Stop() })
+	return w
+}
+
+func TestRecordEventCountsByTypeAndDirectory(t *testing.T) {
+	w := newTestWatcher(t)
+
+	w.recordEvent("/music/a/one.mp3", EventCreate)
+	w.recordEvent("/music/a/two.MP3", EventModify)
+	w.recordEvent("/music/b/three.mp3", EventCreate)
+
+	if got := w.EventCounter().Total("create"); got != 2 {
+		t.Errorf("create total = %d, want 2", got)
+	}
+
+	hotspots := w.GetTopHotspots(0)
+	if len(hotspots) != 2 {
+		t.Fatalf("got %d hotspots, want 2", len(hotspots))
+	}
+	if hotspots[0].Directory != "/music/a" || hotspots[0].Events != 2 {
+		t.Errorf("top hotspot = %+v, want /music/a with 2 events", hotspots[0])
+	}
+}
+
+func TestGetTopHotspotsOrdersByRate(t *testing.T) {
+	w := newTestWatcher(t)
+
+	// /old has more events, but spread over an hour
+	for i := 0; i < 30; i++ {
+		w.recordEvent("/old/file.mp3", EventModify)
+	}
+	w.dirEvents["/old"].firstSeen = time.Now().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		w.recordEvent("/new/file.mp3", EventModify)
+	}
+
+	hotspots := w.GetTopHotspots(1)
+	if len(hotspots) != 1 {
+		t.Fatalf("got %d hotspots, want 1", len(hotspots))
+	}
+	if hotspots[0].Directory != "/new" {
+		t.Errorf("top hotspot = %s, want /new", hotspots[0].Directory)
+	}
+}
+
+func TestDirEventsAreBounded(t *testing.T) {
+	w := newTestWatcher(t)
+
+	w.recordEvent("/idle/file.mp3", EventCreate)
+	w.dirEvents["/idle"].lastSeen = time.Now().Add(-time.Hour)
+
+	for i := 0; i < maxHotspotDirs; i++ {
+		w.recordEvent(filepath.Join(fmt.Sprintf("/dir%d", i), "file.mp3"), EventCreate)
+	}
+
+	if len(w.dirEvents) != maxHotspotDirs {
+		t.Errorf("tracking %d directories, want %d", len(w.dirEvents), maxHotspotDirs)
+	}
+	if _, ok := w.dirEvents["/idle"]; ok {
+		t.Error("least recently active directory was not evicted")
+	}
+}
+
+func TestHotspotsHandler(t *testing.T) {
+	w := newTestWatcher(t)
+	w.recordEvent("/music/a/one.mp3", EventCreate)
+	w.recordEvent("/music/b/two.mp3", EventCreate)
+
+	tests := []struct {
+		method  string
+		query   string
+		status  int
+		entries int
+	}{
+		{http.MethodGet, "", http.StatusOK, 2},
+		{http.MethodGet, "?n=1", http.StatusOK, 1},
+		{http.MethodGet, "?n=0", http.StatusBadRequest, 0},
+		{http.MethodGet, "?n=abc", http.StatusBadRequest, 0},
+		{http.MethodPost, "", http.StatusMethodNotAllowed, 0},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/api/v1/watcher/hotspots"+tt.query, nil)
+		rec := httptest.NewRecorder()
+		w.HotspotsHandler().ServeHTTP(rec, req)
+
+		if rec.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.query, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+
+		var entries []HotspotEntry
+		if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if len(entries) != tt.entries {
+			t.Errorf("%s %s: got %d entries, want %d", tt.method, tt.query, len(entries), tt.entries)
+		}
+	}
+}
+
+// noEvent fails if the watcher reports an event for path within wait
+func noEvent(t *testing.T, w *Watcher, path string, wait time.Duration) {
+	t.Helper()
+	timeout := time.After(wait)
+	for {
+		select {
+		case event := <-w.Events():
+			if event.Path == path {
+				t.Errorf("unexpected %s event for %s", event.EventType, path)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func TestAddAndRemoveDirectory(t *testing.T) {
+	for _, pollAll := range []bool{false, true} {
+		t.Run(fmt.Sprintf("pollAll=%v", pollAll), func(t *testing.T) {
+			logger.Get().SetOutput(io.Discard)
+			first, second := t.TempDir(), t.TempDir()
+			sub := filepath.Join(second, "sub")
+			if err := os.Mkdir(sub, 0o755); err != nil {
+				t.Fatal(err)
+			}
+
+			w, err := NewWatcher(&Config{Extensions: []string{"mp3"}, DebounceDelay: 10 * time.Millisecond, PollAll: pollAll, PollInterval: 20 * time.Millisecond})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.AddDirectory(second); err == nil {
+				t.Error("directory added before the watcher started")
+			}
+			if err := w.Start([]string{first}); err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { w.Stop() })
+
+			if err := w.AddDirectory(second); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.AddDirectory(sub); err == nil {
+				t.Error("directory inside a watched one added twice")
+			}
+			if err := w.AddDirectory(filepath.Join(first, "..")); err == nil {
+				t.Error("directory holding a watched one added")
+			}
+
+			added := filepath.Join(sub, "added.mp3")
+			if err := os.WriteFile(added, []byte("a"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			nextEvent(t, w, added)
+
+			if err := w.RemoveDirectory(sub); err == nil {
+				t.Error("subdirectory of a watched directory removed")
+			}
+			if err := w.RemoveDirectory(second); err != nil {
+				t.Fatal(err)
+			}
+			status := w.Status()
+			if pollAll && len(status.PolledSubtrees) != 1 || !pollAll && status.WatchedDirectories != 1 {
+				t.Errorf("status = %+v after removing %s, want only %s covered", status, second, first)
+			}
+
+			// The removed directory reports neither new nor deleted files
+			ignored := filepath.Join(sub, "ignored.mp3")
+			if err := os.WriteFile(ignored, []byte("b"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Remove(added); err != nil {
+				t.Fatal(err)
+			}
+			noEvent(t, w, ignored, 200*time.Millisecond)
+			noEvent(t, w, added, 0)
+
+			kept := filepath.Join(first, "kept.mp3")
+			if err := os.WriteFile(kept, []byte("c"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			nextEvent(t, w, kept)
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package ack
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// announceSuffix ends the well-known mdn/<category>/announce topics
+const announceSuffix = "/announce"
+
+// Topic returns the companion ack topic of an announcement topic:
+// mdn/<category>/announce becomes mdn/<category>/ack, any other topic gets "/ack" appended
+func Topic(announceTopic string) string {
+	if strings.HasSuffix(announceTopic, announceSuffix) {
+		return strings.TrimSuffix(announceTopic, announceSuffix) + "/ack"
+	}
+	return announceTopic + "/ack"
+}
+
+// Ack is a signed acknowledgement an indexer publishes after storing an announcement
+type Ack struct {
+	PublisherKey string `json:"publisherKey"` // Base64-encoded Ed25519 key of the acknowledged publisher
+	Version      int    `json:"version"`      // Acknowledged collection version
+	PublicKey    string `json:"publicKey"`    // Base64-encoded Ed25519 key of the indexer
+	Timestamp    int64  `json:"timestamp"`    // Unix timestamp
+	Signature    string `json:"signature"`    // Base64-encoded signature
+}
+
+// New creates an unsigned ack of a publisher's announcement version
+func New(publisherKey string, version int) *Ack {
+	return &Ack{
+		PublisherKey: publisherKey,
+		Version:      version,
+		Timestamp:    time.Now().Unix(),
+	}
+}
+
+// Sign signs the ack with the indexer's private key
+func (a *Ack) Sign(privateKey ed25519.PrivateKey) error {
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	a.PublicKey = base64.StdEncoding.EncodeToString(publicKey)
+
+	data, err := a.getBytesForSigning()
+	if err != nil {
+		return fmt.Errorf("failed to serialize ack: %w", err)
+	}
+
+	a.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, data))
+	return nil
+}
+
+// Verify verifies the ack signature
+func (a *Ack) Verify() error {
+	publicKeyBytes, err := base64.StdEncoding.DecodeString(a.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode public key: %w", err)
+	}
+
+	if len(publicKeyBytes) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key size: expected %d, got %d", ed25519.PublicKeySize, len(publicKeyBytes))
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(a.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	data, err := a.getBytesForSigning()
+	if err != nil {
+		return fmt.Errorf("failed to serialize ack: %w", err)
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(publicKeyBytes), data, signature) {
+		return fmt.Errorf("signature verification failed")
+	}
+
+	return nil
+}
+
+// Validate validates the ack fields
+func (a *Ack) Validate() error {
+	if a.PublisherKey == "" {
+		return fmt.Errorf("publisherKey field is required")
+	}
+
+	if a.Version < 1 {
+		return fmt.Errorf("invalid version: must be >= 1")
+	}
+
+	if a.PublicKey == "" {
+		return fmt.Errorf("publicKey field is required")
+	}
+
+	if a.Timestamp <= 0 {
+		return fmt.Errorf("invalid timestamp: must be > 0")
+	}
+
+	// Allow 1 hour of clock drift, as for announcements
+	if a.Timestamp > time.Now().Unix()+3600 {
+		return fmt.Errorf("timestamp is too far in the future")
+	}
+
+	if a.Signature == "" {
+		return fmt.Errorf("signature field is required")
+	}
+
+	return nil
+}
+
+// getBytesForSigning returns the canonical JSON representation for signing
+func (a *Ack) getBytesForSigning() ([]byte, error) {
+	unsigned := *a
+	unsigned.Signature = ""
+	return json.Marshal(unsigned)
+}
+
+// ToJSON converts the ack to JSON bytes with a newline separator
+func (a *Ack) ToJSON() ([]byte, error) {
+	data, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Parse parses an ack from JSON bytes
+func Parse(data []byte) (*Ack, error) {
+	var a Ack
+	if err := json.Unmarshal(data, &a); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal ack: %w", err)
+	}
+	return &a, nil
+}
@@ -0,0 +1,93 @@
+package ack
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+)
+
+// newSignedAck returns an ack of version 12 signed by a fresh key
+func newSignedAck(t *testing.T) *Ack {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	a := New("cHVibGlzaGVy", 12)
+	if err := a.Sign(privateKey); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return a
+}
+
+func TestRoundTrip(t *testing.T) {
+	a := newSignedAck(t)
+
+	data, err := a.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON: %v", err)
+	}
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if err := parsed.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	if err := parsed.Verify(); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if *parsed != *a {
+		t.Errorf("parsed %+v, want %+v", parsed, a)
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	tests := map[string]func(a *Ack){
+		"version":   func(a *Ack) { a.Version++ },
+		"publisher": func(a *Ack) { a.PublisherKey = "b3RoZXI=" },
+		"key":       func(a *Ack) { a.PublicKey = newSignedAck(t).PublicKey },
+	}
+
+	for name, tamper := range tests {
+		a := newSignedAck(t)
+		tamper(a)
+		if err := a.Verify(); err == nil {
+			t.Errorf("%s: tampered ack verified", name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := map[string]func(a *Ack){
+		"no publisher": func(a *Ack) { a.PublisherKey = "" },
+		"version 0":    func(a *Ack) { a.Version = 0 },
+		"no key":       func(a *Ack) { a.PublicKey = "" },
+		"future":       func(a *Ack) { a.Timestamp += 7200 },
+		"unsigned":     func(a *Ack) { a.Signature = "" },
+	}
+
+	for name, breakAck := range tests {
+		a := newSignedAck(t)
+		breakAck(a)
+		if err := a.Validate(); err == nil {
+			t.Errorf("%s: invalid ack accepted", name)
+		}
+	}
+}
+
+func TestTopic(t *testing.T) {
+	tests := map[string]string{
+		"mdn/collections/announce": "mdn/collections/ack",
+		"mdn/music/announce":       "mdn/music/ack",
+		"custom-topic":             "custom-topic/ack",
+	}
+
+	for topic, want := range tests {
+		if got := Topic(topic); got != want {
+			t.Errorf("Topic(%q) = %q, want %q", topic, got, want)
+		}
+	}
+}
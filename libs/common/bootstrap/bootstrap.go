@@ -0,0 +1,127 @@
+// Package bootstrap validates the bootstrap peers configured for the publisher
+// and indexer nodes, so a typo fails at config load instead of silently costing
+// a connection at dial time.
+package bootstrap
+
+import (
+	"fmt"
+	"strings"
+
+	ma "github.com/multiformats/go-multiaddr"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// Peer IDs are the sha2-256 hash of the peer's public key, or the key itself when
+// it is short enough to inline (ed25519 and secp256k1 keys, 36 and 37 bytes)
+const (
+	minInlineKeyLength = 32
+	maxInlineKeyLength = 42
+)
+
+// Parse parses a bootstrap peer multiaddr such as
+// "/dnsaddr/bootstrap.libp2p.io/p2p/QmNnoo..." or "/ip4/1.2.3.4/tcp/4001/p2p/12D3Koo...".
+// It must end in a /p2p/ component naming the peer to dial.
+func Parse(addr string) (ma.Multiaddr, error) {
+	m, err := ma.NewMultiaddr(strings.TrimSpace(addr))
+	if err != nil {
+		return nil, err
+	}
+
+	_, last := ma.SplitLast(m)
+	if last == nil || last.Protocol().Code != ma.P_P2P {
+		return nil, fmt.Errorf("missing /p2p/<peer ID> at the end")
+	}
+	if err := checkPeerID(last); err != nil {
+		return nil, err
+	}
+	if len(m) == 1 {
+		return nil, fmt.Errorf("no address to dial before /p2p/; list the peer ID with its addresses in peer_addresses instead")
+	}
+	return m, nil
+}
+
+// NormalizeList parses and validates configured bootstrap peers and returns them as
+// canonical multiaddrs, keeping the first occurrence of each. An entry is either a
+// full multiaddr ending in /p2p/<peer ID>, or a bare peer ID whose addresses (without
+// the /p2p/ part) are listed under that ID in addresses. It also returns the entries
+// that were dropped as duplicates of an earlier one, in their original spelling.
+// Peer IDs are looked up in addresses case-insensitively, as config loaders such as
+// viper lowercase map keys; the ID is taken from peers as written.
+func NormalizeList(peers []string, addresses map[string][]string) (normalized, duplicates []string, err error) {
+	byID := make(map[string][]string, len(addresses))
+	for id, addrs := range addresses {
+		byID[strings.ToLower(id)] = addrs
+	}
+	used := make(map[string]bool, len(addresses))
+	seen := make(map[string]bool, len(peers))
+
+	add := func(raw string, m ma.Multiaddr) {
+		addr := m.String()
+		if seen[addr] {
+			duplicates = append(duplicates, raw)
+			return
+		}
+		seen[addr] = true
+		normalized = append(normalized, addr)
+	}
+
+	for _, raw := range peers {
+		entry := strings.TrimSpace(raw)
+		if strings.HasPrefix(entry, "/") {
+			m, err := Parse(entry)
+			if err != nil {
+				return nil, nil, fmt.Errorf("entry %q: %w", raw, err)
+			}
+			add(raw, m)
+			continue
+		}
+
+		// A bare peer ID is dialed at the addresses listed for it
+		id, err := ma.NewComponent("p2p", entry)
+		if err == nil {
+			err = checkPeerID(id)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("entry %q is neither a multiaddr nor a peer ID: %w", raw, err)
+		}
+		addrs := byID[strings.ToLower(entry)]
+		if len(addrs) == 0 {
+			return nil, nil, fmt.Errorf("entry %q is a bare peer ID without addresses in peer_addresses", raw)
+		}
+		used[strings.ToLower(entry)] = true
+		for _, addr := range addrs {
+			m, err := ma.NewMultiaddr(strings.TrimSpace(addr))
+			if err != nil {
+				return nil, nil, fmt.Errorf("peer_addresses of %s: address %q: %w", entry, addr, err)
+			}
+			if _, err := m.ValueForProtocol(ma.P_P2P); err == nil {
+				return nil, nil, fmt.Errorf("peer_addresses of %s: address %q must not contain /p2p/; the peer ID is added", entry, addr)
+			}
+			add(raw, m.Encapsulate(id))
+		}
+	}
+
+	for id := range addresses {
+		if !used[strings.ToLower(id)] {
+			return nil, nil, fmt.Errorf("peer_addresses lists %q, which is not a bare peer ID in bootstrap_peers", id)
+		}
+	}
+	return normalized, duplicates, nil
+}
+
+// checkPeerID checks that a /p2p/ component holds a hashed or inlined public key.
+// The multiaddr parser accepts any multihash, such as a truncated peer ID.
+func checkPeerID(c *ma.Component) error {
+	decoded, err := mh.Decode(c.RawValue())
+	if err != nil {
+		return fmt.Errorf("invalid peer ID %s: %w", c.Value(), err)
+	}
+
+	switch {
+	case decoded.Code == mh.SHA2_256 && decoded.Length == 32:
+	case decoded.Code == mh.IDENTITY && decoded.Length >= minInlineKeyLength && decoded.Length <= maxInlineKeyLength:
+	default:
+		return fmt.Errorf("invalid peer ID %s: not a public key or its hash, it may be truncated", c.Value())
+	}
+	return nil
+}
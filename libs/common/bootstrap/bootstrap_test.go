@@ -0,0 +1,115 @@
+package bootstrap
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const (
+	peerA = "QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN"
+	peerB = "12D3KooWNZ9Ma5sMmcr3brheC685dgrKJaM9SdhZrHojpKfywjg4"
+)
+
+func TestNormalizeList(t *testing.T) {
+	tests := []struct {
+		name       string
+		peers      []string
+		addresses  map[string][]string
+		want       []string
+		duplicates []string
+		wantErr    string // Part of the expected error; "" = no error
+	}{
+		{
+			name:  "ip4 and dnsaddr",
+			peers: []string{"/ip4/1.2.3.4/tcp/4001/p2p/" + peerB, " /dnsaddr/bootstrap.libp2p.io/p2p/" + peerA},
+			want:  []string{"/ip4/1.2.3.4/tcp/4001/p2p/" + peerB, "/dnsaddr/bootstrap.libp2p.io/p2p/" + peerA},
+		},
+		{
+			name:  "dns4 with quic",
+			peers: []string{"/dns4/node.example.org/udp/4001/quic-v1/p2p/" + peerB},
+			want:  []string{"/dns4/node.example.org/udp/4001/quic-v1/p2p/" + peerB},
+		},
+		{
+			name:       "duplicates",
+			peers:      []string{"/ip4/1.2.3.4/tcp/4001/p2p/" + peerB, "/ip4/1.2.3.4/tcp/4001/p2p/" + peerB},
+			want:       []string{"/ip4/1.2.3.4/tcp/4001/p2p/" + peerB},
+			duplicates: []string{"/ip4/1.2.3.4/tcp/4001/p2p/" + peerB},
+		},
+		{
+			name:      "bare peer ID with addresses",
+			peers:     []string{peerB, "/ip4/1.2.3.4/tcp/4001/p2p/" + peerB},
+			addresses: map[string][]string{peerB: {"/ip4/1.2.3.4/tcp/4001", "/dns6/node.example.org/tcp/4001"}},
+			want: []string{
+				"/ip4/1.2.3.4/tcp/4001/p2p/" + peerB,
+				"/dns6/node.example.org/tcp/4001/p2p/" + peerB,
+			},
+			duplicates: []string{"/ip4/1.2.3.4/tcp/4001/p2p/" + peerB},
+		},
+		{
+			name:      "addresses under a lowercased peer ID",
+			peers:     []string{peerB},
+			addresses: map[string][]string{strings.ToLower(peerB): {"/ip4/1.2.3.4/udp/4001/quic-v1"}},
+			want:      []string{"/ip4/1.2.3.4/udp/4001/quic-v1/p2p/" + peerB},
+		},
+		{
+			name:    "missing peer ID",
+			peers:   []string{"/ip4/1.2.3.4/tcp/4001"},
+			wantErr: `"/ip4/1.2.3.4/tcp/4001": missing /p2p/`,
+		},
+		{
+			name:    "only a peer ID component",
+			peers:   []string{"/p2p/" + peerB},
+			wantErr: "no address to dial",
+		},
+		{
+			name:    "typo in protocol",
+			peers:   []string{"/ip4/1.2.3.4/tpc/4001/p2p/" + peerB},
+			wantErr: `entry "/ip4/1.2.3.4/tpc/4001/p2p/` + peerB + `"`,
+		},
+		{
+			name:    "invalid peer ID",
+			peers:   []string{"/ip4/1.2.3.4/tcp/4001/p2p/12D3KooWtypo"},
+			wantErr: `entry "/ip4/1.2.3.4/tcp/4001/p2p/12D3KooWtypo"`,
+		},
+		{
+			name:    "bare peer ID without addresses",
+			peers:   []string{peerB},
+			wantErr: "without addresses in peer_addresses",
+		},
+		{
+			name:    "bare garbage",
+			peers:   []string{"bootstrap.example.org"},
+			wantErr: "neither a multiaddr nor a peer ID",
+		},
+		{
+			name:      "address with peer ID",
+			peers:     []string{peerB},
+			addresses: map[string][]string{peerB: {"/ip4/1.2.3.4/tcp/4001/p2p/" + peerA}},
+			wantErr:   "must not contain /p2p/",
+		},
+		{
+			name:      "unused addresses",
+			peers:     []string{"/ip4/1.2.3.4/tcp/4001/p2p/" + peerB},
+			addresses: map[string][]string{peerA: {"/ip4/5.6.7.8/tcp/4001"}},
+			wantErr:   "not a bare peer ID in bootstrap_peers",
+		},
+	}
+
+	for _, tt := range tests {
+		got, duplicates, err := NormalizeList(tt.peers, tt.addresses)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !slices.Equal(got, tt.want) || !slices.Equal(duplicates, tt.duplicates) {
+			t.Errorf("%s: NormalizeList = %q, duplicates %q; want %q, %q", tt.name, got, duplicates, tt.want, tt.duplicates)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package claim
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+func TestSignVerify(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	key := base64.StdEncoding.EncodeToString(publicKey)
+
+	sig := Sign(privateKey, "bafkreiabc", "01 Intro.flac", 1234)
+	if err := Verify(key, "bafkreiabc", "01 Intro.flac", 1234, sig); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	// Any change to the record invalidates the claim
+	tampered := []struct {
+		name     string
+		cid      string
+		filename string
+		size     int64
+	}{
+		{"cid", "bafkreixyz", "01 Intro.flac", 1234},
+		{"filename", "bafkreiabc", "01 Outro.flac", 1234},
+		{"size", "bafkreiabc", "01 Intro.flac", 1235},
+	}
+	for _, tc := range tampered {
+		if err := Verify(key, tc.cid, tc.filename, tc.size, sig); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("changed %s: Verify = %v, want ErrInvalidSignature", tc.name, err)
+		}
+	}
+
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	if err := Verify(base64.StdEncoding.EncodeToString(otherKey), "bafkreiabc", "01 Intro.flac", 1234, sig); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("other key: Verify = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestPayloadFieldsAreSeparated(t *testing.T) {
+	// Moving bytes between fields must not produce the same payload
+	a := string(Payload("bafy1", "2.mp3", 3))
+	b := string(Payload("bafy", "12.mp3", 3))
+	if a == b {
+		t.Error("payloads of different records are equal")
+	}
+}
+
+func TestVerifyRejectsMalformedInput(t *testing.T) {
+	if err := Verify("not base64!", "cid", "a.mp3", 1, "c2ln"); err == nil {
+		t.Error("Verify accepted a malformed public key")
+	}
+	if err := Verify(base64.StdEncoding.EncodeToString([]byte("short")), "cid", "a.mp3", 1, "c2ln"); err == nil {
+		t.Error("Verify accepted a short public key")
+	}
+	publicKey, _, _ := ed25519.GenerateKey(rand.Reader)
+	if err := Verify(base64.StdEncoding.EncodeToString(publicKey), "cid", "a.mp3", 1, "not base64!"); err == nil {
+		t.Error("Verify accepted a malformed signature")
+	}
+}
@@ -0,0 +1,127 @@
+// Package configschema describes the settings of the publisher and indexer
+// configuration structs, read from their mapstructure, desc and default tags,
+// for the `config schema` subcommands of both apps.
+package configschema
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Field is a setting of a configuration struct
+type Field struct {
+	Key         string // Dotted path of mapstructure tags, e.g. "pubsub.topic"
+	Type        string // Value type, e.g. "int", "[]string" or "map[string]any"
+	Default     string // The default tag, or the field's value in the struct walked
+	Description string // The desc tag
+}
+
+// Fields lists the settings of cfg, a struct or a pointer to one, in
+// declaration order. Nested structs are walked; every other exported field
+// with a mapstructure tag is a setting. A field's default is its default tag,
+// or without one its value in cfg, so cfg should hold the default settings.
+func Fields(cfg interface{}) []Field {
+	var fields []Field
+	walk(reflect.Indirect(reflect.ValueOf(cfg)), "", func(key string, f reflect.StructField, v reflect.Value) {
+		def, ok := f.Tag.Lookup("default")
+		if !ok {
+			def = format(v)
+		}
+		fields = append(fields, Field{
+			Key:         key,
+			Type:        typeName(f.Type),
+			Default:     def,
+			Description: f.Tag.Get("desc"),
+		})
+	})
+	return fields
+}
+
+// Mismatches returns the keys whose default tag differs from their value in
+// cfg, so tests can hold the tags to the defaults applied when loading
+func Mismatches(cfg interface{}) []string {
+	var keys []string
+	walk(reflect.Indirect(reflect.ValueOf(cfg)), "", func(key string, f reflect.StructField, v reflect.Value) {
+		if def, ok := f.Tag.Lookup("default"); ok && def != format(v) {
+			keys = append(keys, fmt.Sprintf("%s: tag %q, loaded %q", key, def, format(v)))
+		}
+	})
+	return keys
+}
+
+// Write prints fields as a table of key, type, default and description
+func Write(w io.Writer, fields []Field) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tTYPE\tDEFAULT\tDESCRIPTION")
+	for _, f := range fields {
+		def := f.Default
+		if def == "" {
+			def = `""`
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", f.Key, f.Type, def, f.Description)
+	}
+	return tw.Flush()
+}
+
+// walk calls fn for every setting of the struct value v below prefix
+func walk(v reflect.Value, prefix string, fn func(key string, f reflect.StructField, v reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("mapstructure"), ",")
+		if !f.IsExported() || name == "" || name == "-" {
+			continue
+		}
+
+		key := name
+		if prefix != "" {
+			key = prefix + "." + name
+		}
+		if f.Type.Kind() == reflect.Struct {
+			walk(v.Field(i), key, fn)
+			continue
+		}
+		fn(key, f, v.Field(i))
+	}
+}
+
+// format renders a value the way default tags are written: scalars as with
+// fmt.Print, slices and maps as comma-separated elements and key=value pairs
+func format(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.Slice:
+		elems := make([]string, v.Len())
+		for i := range elems {
+			elems[i] = fmt.Sprint(v.Index(i).Interface())
+		}
+		return strings.Join(elems, ",")
+	case reflect.Map:
+		pairs := make([]string, 0, v.Len())
+		for _, k := range v.MapKeys() {
+			pairs = append(pairs, fmt.Sprintf("%v=%v", k.Interface(), v.MapIndex(k).Interface()))
+		}
+		sort.Strings(pairs)
+		return strings.Join(pairs, ",")
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// typeName names a setting's type by kind, so named types such as a mode
+// string show as the type written in the config file
+func typeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Slice:
+		return "[]" + typeName(t.Elem())
+	case reflect.Map:
+		return "map[" + typeName(t.Key()) + "]" + typeName(t.Elem())
+	case reflect.Interface:
+		return "any"
+	default:
+		return t.Kind().String()
+	}
+}
@@ -0,0 +1,68 @@
+package configschema
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testMode string
+
+type testConfig struct {
+	Mode    testMode          `mapstructure:"mode" desc:"Node mode" default:"external"`
+	Sub     testSub           `mapstructure:"sub"`
+	Peers   []string          `mapstructure:"peers" desc:"Peer addresses"`
+	Options map[string]any    `mapstructure:"add_options"`
+	Hidden  string            `mapstructure:"-"`
+	Labels  map[string]string `mapstructure:"labels,omitempty"`
+	private int
+}
+
+type testSub struct {
+	Interval int  `mapstructure:"interval" desc:"Seconds between runs" default:"60"`
+	Enabled  bool `mapstructure:"enabled"`
+}
+
+func TestFields(t *testing.T) {
+	cfg := testConfig{
+		Sub:     testSub{Interval: 30, Enabled: true},
+		Peers:   []string{"a", "b"},
+		Options: map[string]any{"pin": true, "cid-version": 1},
+	}
+
+	want := []Field{
+		{Key: "mode", Type: "string", Default: "external", Description: "Node mode"},
+		{Key: "sub.interval", Type: "int", Default: "60", Description: "Seconds between runs"},
+		{Key: "sub.enabled", Type: "bool", Default: "true"},
+		{Key: "peers", Type: "[]string", Default: "a,b", Description: "Peer addresses"},
+		{Key: "add_options", Type: "map[string]any", Default: "cid-version=1,pin=true"},
+		{Key: "labels", Type: "map[string]string"},
+	}
+	if got := Fields(&cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("Fields =\n%+v\nwant\n%+v", got, want)
+	}
+
+	// The tags disagree with the mode (empty) and the interval (30)
+	mismatches := Mismatches(cfg)
+	if len(mismatches) != 2 || !strings.HasPrefix(mismatches[0], "mode:") || !strings.HasPrefix(mismatches[1], "sub.interval:") {
+		t.Errorf("Mismatches = %q, want mode and sub.interval", mismatches)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, []Field{
+		{Key: "mode", Type: "string", Default: "external", Description: "Node mode"},
+		{Key: "title", Type: "string"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "KEY    TYPE    DEFAULT   DESCRIPTION\n" +
+		"mode   string  external  Node mode\n" +
+		"title  string  \"\"        \n"
+	if buf.String() != want {
+		t.Errorf("Write =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,112 @@
+// Package dedupe drops repeated PubSub payloads. GossipSub hands a subscriber
+// every copy that arrives from a different mesh peer before the message is
+// marked seen, and a publisher may send the same announcement again; a Window
+// passes on the first copy of a payload and drops copies for a while after it.
+package dedupe
+
+import (
+	"context"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// Window remembers the SHA-256 of each payload passed on within its duration
+type Window struct {
+	duration time.Duration
+	seen     sync.Map // [sha256.Size]byte → time.Time the payload was passed on
+}
+
+// NewWindow creates a window dropping copies of a payload for duration, which
+// must be positive, after it was passed on
+func NewWindow(duration time.Duration) *Window {
+	return &Window{duration: duration}
+}
+
+// First reports whether data was not passed on within the window before now,
+// and if so remembers it as passed on at now. Of concurrent calls with the
+// same payload only one returns true.
+func (w *Window) First(data []byte, now time.Time) bool {
+	key := sha256.Sum256(data)
+	for {
+		passed, loaded := w.seen.LoadOrStore(key, now)
+		if !loaded {
+			return true
+		}
+		if now.Sub(passed.(time.Time)) < w.duration {
+			return false
+		}
+		// Expired: pass it on again unless another call just did
+		if w.seen.CompareAndSwap(key, passed, now) {
+			return true
+		}
+	}
+}
+
+// Prune forgets the payloads passed on at least the window before now
+func (w *Window) Prune(now time.Time) {
+	w.seen.Range(func(key, passed any) bool {
+		if now.Sub(passed.(time.Time)) >= w.duration {
+			w.seen.CompareAndDelete(key, passed)
+		}
+		return true
+	})
+}
+
+// Len returns the number of remembered payloads
+func (w *Window) Len() int {
+	n := 0
+	w.seen.Range(func(any, any) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// Run prunes the window every half window until ctx is done
+func (w *Window) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.duration / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			w.Prune(now)
+		}
+	}
+}
+
+// Filter passes the payloads read from in on to the returned channel, dropping
+// copies of a payload within window after it was passed on. The channel is
+// closed when in is closed or ctx is done.
+func Filter(ctx context.Context, in <-chan []byte, window time.Duration) <-chan []byte {
+	w := NewWindow(window)
+	ctx, cancel := context.WithCancel(ctx)
+	go w.Run(ctx)
+
+	out := make(chan []byte, cap(in))
+	go func() {
+		defer close(out)
+		defer cancel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case data, ok := <-in:
+				if !ok {
+					return
+				}
+				if !w.First(data, time.Now()) {
+					continue
+				}
+				select {
+				case out <- data:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,105 @@
+package dedupe
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFilterPassesConcurrentCopiesOnce(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	in := make(chan []byte)
+	out := Filter(ctx, in, time.Minute)
+	received := make(chan []string)
+	go func() {
+		var got []string
+		for data := range out {
+			got = append(got, string(data))
+		}
+		received <- got
+	}()
+
+	// Two publishers send the same payload at once, then another one
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			in <- []byte(`{"version":1}`)
+		}()
+	}
+	wg.Wait()
+	in <- []byte(`{"version":2}`)
+	close(in)
+
+	got := <-received
+	if len(got) != 2 || got[0] != `{"version":1}` || got[1] != `{"version":2}` {
+		t.Errorf("received %q, want each payload once", got)
+	}
+}
+
+func TestWindowFirst(t *testing.T) {
+	w := NewWindow(time.Minute)
+	start := time.Unix(1764260509, 0)
+	payload := []byte("announcement")
+
+	if !w.First(payload, start) {
+		t.Fatal("first copy dropped")
+	}
+	if w.First(payload, start.Add(59*time.Second)) {
+		t.Error("copy within the window passed on")
+	}
+	if !w.First([]byte("other"), start) {
+		t.Error("other payload dropped")
+	}
+
+	// After the window the payload is passed on again and remembered anew
+	if !w.First(payload, start.Add(time.Minute)) {
+		t.Error("copy after the window dropped")
+	}
+	if w.First(payload, start.Add(90*time.Second)) {
+		t.Error("copy within the renewed window passed on")
+	}
+}
+
+func TestWindowConcurrentFirst(t *testing.T) {
+	w := NewWindow(time.Minute)
+	now := time.Now()
+
+	var mu sync.Mutex
+	passed := 0
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w.First([]byte("announcement"), now) {
+				mu.Lock()
+				passed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if passed != 1 {
+		t.Errorf("%d concurrent copies passed on, want 1", passed)
+	}
+}
+
+func TestWindowPrune(t *testing.T) {
+	w := NewWindow(time.Minute)
+	start := time.Unix(1764260509, 0)
+	w.First([]byte("old"), start)
+	w.First([]byte("new"), start.Add(30*time.Second))
+
+	w.Prune(start.Add(time.Minute))
+	if w.Len() != 1 {
+		t.Errorf("%d payloads remembered after pruning, want 1", w.Len())
+	}
+	if w.First([]byte("new"), start.Add(time.Minute)) {
+		t.Error("payload within the window passed on after pruning")
+	}
+}
@@ -0,0 +1,128 @@
+package extensions
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// maxLength bounds the length of an extension; longer entries are typos or paths
+const maxLength = 16
+
+// Normalize returns ext in the canonical form used everywhere: lowercase,
+// surrounding whitespace trimmed and without a leading dot, so "MP3", ".mp3"
+// and "mp3" are the same extension
+func Normalize(ext string) string {
+	return strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+}
+
+// Of returns the normalized extension of filename, or "" if it has none
+func Of(filename string) string {
+	return Normalize(filepath.Ext(filename))
+}
+
+// Validate checks that ext is a non-empty normalized extension of letters, digits,
+// '-', '_' and '+' such as "mp3", "m4a" or "tar-gz"
+func Validate(ext string) error {
+	if ext == "" {
+		return fmt.Errorf("extension is empty")
+	}
+	if len(ext) > maxLength {
+		return fmt.Errorf("extension %q is longer than %d characters", ext, maxLength)
+	}
+	for _, r := range ext {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_', r == '+':
+		default:
+			return fmt.Errorf("extension %q contains %q; use the bare extension such as \"mp3\"", ext, r)
+		}
+	}
+	return nil
+}
+
+// NormalizeList normalizes and validates configured extensions, keeping the first
+// occurrence of each. It returns the normalized list and the entries that were
+// dropped as duplicates of an earlier one, in their original spelling.
+func NormalizeList(exts []string) (normalized, duplicates []string, err error) {
+	seen := make(map[string]bool, len(exts))
+	for _, raw := range exts {
+		ext := Normalize(raw)
+		if err := Validate(ext); err != nil {
+			return nil, nil, fmt.Errorf("entry %q: %w", raw, err)
+		}
+		if seen[ext] {
+			duplicates = append(duplicates, raw)
+			continue
+		}
+		seen[ext] = true
+		normalized = append(normalized, ext)
+	}
+	return normalized, duplicates, nil
+}
+
+// Set is a set of normalized extensions for matching filenames
+type Set map[string]bool
+
+// NewSet returns the set of the normalized forms of exts
+func NewSet(exts []string) Set {
+	set := make(Set, len(exts))
+	for _, ext := range exts {
+		set[Normalize(ext)] = true
+	}
+	return set
+}
+
+// Matches reports whether filename has one of the extensions in the set
+func (s Set) Matches(filename string) bool {
+	ext := Of(filename)
+	return ext != "" && s[ext]
+}
+
+// mimeTypes are the MIME types of common media extensions. The table is built
+// in so every publisher records the same type regardless of the system's
+// mime.types file.
+var mimeTypes = map[string]string{
+	"aac":  "audio/aac",
+	"aif":  "audio/aiff",
+	"aiff": "audio/aiff",
+	"ape":  "audio/x-ape",
+	"avi":  "video/x-msvideo",
+	"avif": "image/avif",
+	"epub": "application/epub+zip",
+	"flac": "audio/flac",
+	"gif":  "image/gif",
+	"jpeg": "image/jpeg",
+	"jpg":  "image/jpeg",
+	"m4a":  "audio/mp4",
+	"m4b":  "audio/mp4",
+	"m4v":  "video/mp4",
+	"mka":  "audio/x-matroska",
+	"mkv":  "video/x-matroska",
+	"mov":  "video/quicktime",
+	"mp3":  "audio/mpeg",
+	"mp4":  "video/mp4",
+	"mpeg": "video/mpeg",
+	"mpg":  "video/mpeg",
+	"oga":  "audio/ogg",
+	"ogg":  "audio/ogg",
+	"ogv":  "video/ogg",
+	"opus": "audio/ogg",
+	"pdf":  "application/pdf",
+	"png":  "image/png",
+	"srt":  "application/x-subrip",
+	"ts":   "video/mp2t",
+	"txt":  "text/plain",
+	"vtt":  "text/vtt",
+	"wav":  "audio/wav",
+	"webm": "video/webm",
+	"webp": "image/webp",
+	"wma":  "audio/x-ms-wma",
+	"wmv":  "video/x-ms-wmv",
+	"zip":  "application/zip",
+}
+
+// MimeType returns the MIME type of files with the extension ext, or "" if it
+// is not a known media extension
+func MimeType(ext string) string {
+	return mimeTypes[Normalize(ext)]
+}
@@ -0,0 +1,72 @@
+package extensions
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestNormalizeList(t *testing.T) {
+	tests := []struct {
+		name       string
+		input      []string
+		want       []string
+		duplicates []string
+		wantErr    bool
+	}{
+		{"clean", []string{"mp3", "flac"}, []string{"mp3", "flac"}, nil, false},
+		{"mixed case and dots", []string{"MP3", ".mp3", "mp3", ".FLAC"}, []string{"mp3", "flac"}, []string{".mp3", "mp3"}, false},
+		{"whitespace", []string{" mp3 ", "\tOgg"}, []string{"mp3", "ogg"}, nil, false},
+		{"digits and separators", []string{"m4a", "tar-gz", "c++"}, []string{"m4a", "tar-gz", "c++"}, nil, false},
+		{"empty", []string{"mp3", ""}, nil, nil, true},
+		{"only a dot", []string{"."}, nil, nil, true},
+		{"double dot", []string{"..mp3"}, nil, nil, true},
+		{"glob", []string{"*.mp3"}, nil, nil, true},
+		{"compound", []string{"tar.gz"}, nil, nil, true},
+		{"path", []string{"music/mp3"}, nil, nil, true},
+		{"comma list in one entry", []string{"mp3,flac"}, nil, nil, true},
+		{"inner space", []string{"mp 3"}, nil, nil, true},
+		{"too long", []string{"abcdefghijklmnopq"}, nil, nil, true},
+	}
+
+	for _, tt := range tests {
+		got, duplicates, err := NormalizeList(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !slices.Equal(got, tt.want) || !slices.Equal(duplicates, tt.duplicates) {
+			t.Errorf("%s: NormalizeList(%q) = %q, duplicates %q; want %q, %q", tt.name, tt.input, got, duplicates, tt.want, tt.duplicates)
+		}
+	}
+}
+
+func TestSetMatches(t *testing.T) {
+	set := NewSet([]string{"MP3", ".flac"})
+
+	tests := []struct {
+		filename string
+		want     bool
+	}{
+		{"song.mp3", true},
+		{"SONG.MP3", true},
+		{"Artist/01 Intro.Flac", true},
+		{"song.mp3.part", false},
+		{"mp3", false},
+		{".mp3", true},
+		{"cover.jpg", false},
+	}
+
+	for _, tt := range tests {
+		if got := set.Matches(tt.filename); got != tt.want {
+			t.Errorf("Matches(%q) = %v, want %v", tt.filename, got, tt.want)
+		}
+	}
+}
+
+func TestMimeType(t *testing.T) {
+	for ext, want := range map[string]string{"mp3": "audio/mpeg", ".FLAC": "audio/flac", "mkv": "video/x-matroska", "xyz": "", "": ""} {
+		if got := MimeType(ext); got != want {
+			t.Errorf("MimeType(%q) = %q, want %q", ext, got, want)
+		}
+	}
+}
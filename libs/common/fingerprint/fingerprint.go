@@ -0,0 +1,140 @@
+package fingerprint
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Prefix starts every fingerprint and names its format
+const Prefix = "mdn1-"
+
+// MinPrefixDigits is the number of hex digits a fingerprint prefix needs to be looked up
+const MinPrefixDigits = 4
+
+// digits is the number of hex digits in a full fingerprint: 8 bytes of SHA-256
+const digits = 16
+
+// ErrNotFound is returned by Resolve when no key matches the query
+var ErrNotFound = errors.New("no matching key")
+
+// Of returns the fingerprint of a public key: Prefix followed by the first 8
+// bytes of its SHA-256 in groups of four hex digits, e.g. "mdn1-3f2a-9c41-07be-d518"
+func Of(publicKey []byte) string {
+	sum := sha256.Sum256(publicKey)
+	h := hex.EncodeToString(sum[:digits/2])
+
+	var b strings.Builder
+	b.WriteString(Prefix)
+	for i := 0; i < digits; i += 4 {
+		if i > 0 {
+			b.WriteByte('-')
+		}
+		b.WriteString(h[i : i+4])
+	}
+	return b.String()
+}
+
+// Decode decodes an Ed25519 public key given in base64, as in announcements
+// and the indexer's database, or in hex, as in the publisher's key manifest
+func Decode(key string) (ed25519.PublicKey, error) {
+	if raw, err := base64.StdEncoding.DecodeString(key); err == nil && len(raw) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(raw), nil
+	}
+	if raw, err := hex.DecodeString(key); err == nil && len(raw) == ed25519.PublicKeySize {
+		return ed25519.PublicKey(raw), nil
+	}
+	return nil, fmt.Errorf("%q is not a base64 or hex Ed25519 public key", key)
+}
+
+// Format returns the fingerprint of a base64 or hex public key for display, or
+// the key itself if it cannot be decoded
+func Format(key string) string {
+	publicKey, err := Decode(key)
+	if err != nil {
+		return key
+	}
+	return Of(publicKey)
+}
+
+// ParsePrefix returns the lowercase hex digits of a fingerprint or fingerprint
+// prefix such as "mdn1-3f2a-9c". Dashes may be left out or placed anywhere, and
+// at least MinPrefixDigits digits are required.
+func ParsePrefix(s string) (string, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	if !strings.HasPrefix(lower, Prefix) {
+		return "", fmt.Errorf("%q is not a fingerprint: it must start with %q", s, Prefix)
+	}
+	h := strings.ReplaceAll(strings.TrimPrefix(lower, Prefix), "-", "")
+	if len(h) < MinPrefixDigits || len(h) > digits {
+		return "", fmt.Errorf("fingerprint %q must have %d to %d hex digits", s, MinPrefixDigits, digits)
+	}
+	if _, err := hex.DecodeString(h + strings.Repeat("0", len(h)%2)); err != nil {
+		return "", fmt.Errorf("fingerprint %q contains non-hex digits", s)
+	}
+	return h, nil
+}
+
+// IsFingerprint reports whether s is a fingerprint or a usable fingerprint prefix
+func IsFingerprint(s string) bool {
+	_, err := ParsePrefix(s)
+	return err == nil
+}
+
+// AmbiguousError is returned by Resolve when a fingerprint prefix matches more
+// than one key
+type AmbiguousError struct {
+	Query      string
+	Candidates []string // Keys as given to Resolve
+}
+
+func (e *AmbiguousError) Error() string {
+	listed := make([]string, len(e.Candidates))
+	for i, key := range e.Candidates {
+		listed[i] = fmt.Sprintf("%s (%s)", Format(key), key)
+	}
+	return fmt.Sprintf("fingerprint %q matches %d keys, use a longer prefix or the full key: %s",
+		e.Query, len(e.Candidates), strings.Join(listed, ", "))
+}
+
+// Resolve returns the key among keys that query names. The query is either a
+// full key in base64 or hex, matched by value whatever the encoding of keys, or
+// a fingerprint or prefix of one. A prefix matching several keys returns an
+// *AmbiguousError listing them, and no match returns ErrNotFound.
+func Resolve(query string, keys []string) (string, error) {
+	if publicKey, err := Decode(query); err == nil {
+		for _, key := range keys {
+			if candidate, err := Decode(key); err == nil && candidate.Equal(publicKey) {
+				return key, nil
+			}
+		}
+		return "", fmt.Errorf("key %s: %w", Of(publicKey), ErrNotFound)
+	}
+
+	prefix, err := ParsePrefix(query)
+	if err != nil {
+		return "", err
+	}
+	var candidates []string
+	for _, key := range keys {
+		publicKey, err := Decode(key)
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(strings.ReplaceAll(strings.TrimPrefix(Of(publicKey), Prefix), "-", ""), prefix) {
+			candidates = append(candidates, key)
+		}
+	}
+	switch len(candidates) {
+	case 0:
+		return "", fmt.Errorf("fingerprint %q: %w", query, ErrNotFound)
+	case 1:
+		return candidates[0], nil
+	default:
+		return "", &AmbiguousError{Query: query, Candidates: candidates}
+	}
+}
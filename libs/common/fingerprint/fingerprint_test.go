@@ -0,0 +1,145 @@
+package fingerprint
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func keyFromSeed(seed byte) ed25519.PublicKey {
+	s := make([]byte, ed25519.SeedSize)
+	s[0] = seed
+	return ed25519.NewKeyFromSeed(s).Public().(ed25519.PublicKey)
+}
+
+func TestOf(t *testing.T) {
+	key := keyFromSeed(1)
+	fp := Of(key)
+	if !regexp.MustCompile(`^mdn1-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}$`).MatchString(fp) {
+		t.Fatalf("Of = %q, want mdn1-xxxx-xxxx-xxxx-xxxx", fp)
+	}
+	if Of(key) != fp {
+		t.Error("Of is not deterministic")
+	}
+	if Of(keyFromSeed(2)) == fp {
+		t.Error("different keys have the same fingerprint")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	key := keyFromSeed(1)
+	fp := Of(key)
+
+	if got := Format(base64.StdEncoding.EncodeToString(key)); got != fp {
+		t.Errorf("Format(base64) = %q, want %q", got, fp)
+	}
+	if got := Format(hex.EncodeToString(key)); got != fp {
+		t.Errorf("Format(hex) = %q, want %q", got, fp)
+	}
+	if got := Format("not-a-key"); got != "not-a-key" {
+		t.Errorf("Format(invalid) = %q, want the input", got)
+	}
+}
+
+func TestParsePrefix(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"mdn1-3f2a-9c41-07be-d518", "3f2a9c4107bed518"},
+		{"MDN1-3F2A-9C", "3f2a9c"},
+		{"mdn1-3f2a9", "3f2a9"},
+		{" mdn1-3f2a ", "3f2a"},
+		{"mdn1-3f2", ""},                    // Too short
+		{"mdn1-3f2a-9c41-07be-d518-00", ""}, // Too long
+		{"mdn1-3g2a", ""},
+		{"3f2a-9c41", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		got, err := ParsePrefix(tt.in)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("ParsePrefix(%q) = %q, want an error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParsePrefix(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	var keys []string
+	for seed := byte(1); seed <= 50; seed++ {
+		keys = append(keys, base64.StdEncoding.EncodeToString(keyFromSeed(seed)))
+	}
+	target := keys[7]
+	fp := Format(target)
+
+	for _, query := range []string{target, hex.EncodeToString(keyFromSeed(8)), fp, strings.ToUpper(fp), fp[:len(Prefix)+9]} {
+		got, err := Resolve(query, keys)
+		if err != nil || got != target {
+			t.Errorf("Resolve(%q) = %q, %v, want %q", query, got, err, target)
+		}
+	}
+
+	if _, err := Resolve(base64.StdEncoding.EncodeToString(keyFromSeed(99)), keys); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve(unknown key) error = %v, want ErrNotFound", err)
+	}
+	if _, err := Resolve(Format(base64.StdEncoding.EncodeToString(keyFromSeed(99))), keys); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve(unknown fingerprint) error = %v, want ErrNotFound", err)
+	}
+	if _, err := Resolve("mdn1-zz", keys); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Resolve(malformed) error = %v, want a parse error", err)
+	}
+}
+
+func TestResolveAmbiguous(t *testing.T) {
+	// Find two keys sharing the first four fingerprint digits
+	seen := make(map[string]string)
+	var query string
+	var want []string
+	for seed := 0; query == "" && seed < 256; seed++ {
+		s := make([]byte, ed25519.SeedSize)
+		s[0], s[1] = byte(seed), 0xAA
+		for i := 0; i < 256; i++ {
+			s[2] = byte(i)
+			key := base64.StdEncoding.EncodeToString(ed25519.NewKeyFromSeed(s).Public().(ed25519.PublicKey))
+			short := Format(key)[:len(Prefix)+4]
+			if other, ok := seen[short]; ok {
+				query, want = short, []string{other, key}
+				break
+			}
+			seen[short] = key
+		}
+	}
+	if query == "" {
+		t.Fatal("no colliding fingerprint prefix found")
+	}
+
+	_, err := Resolve(query, want)
+	var ambiguous *AmbiguousError
+	if !errors.As(err, &ambiguous) {
+		t.Fatalf("Resolve(%q) error = %v, want *AmbiguousError", query, err)
+	}
+	if len(ambiguous.Candidates) != 2 {
+		t.Errorf("Candidates = %v, want both keys", ambiguous.Candidates)
+	}
+	for _, key := range want {
+		if !strings.Contains(err.Error(), Format(key)) || !strings.Contains(err.Error(), key) {
+			t.Errorf("error %q does not list candidate %s", err, key)
+		}
+	}
+
+	// The full fingerprint is unambiguous
+	if got, err := Resolve(Format(want[1]), want); err != nil || got != want[1] {
+		t.Errorf("Resolve(full fingerprint) = %q, %v, want %q", got, err, want[1])
+	}
+}
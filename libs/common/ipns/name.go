@@ -0,0 +1,37 @@
+package ipns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// ValidateName checks that name is an IPNS name: a libp2p-key CID such as
+// "k51..." or a base58 peer ID such as "12D3KooW..." or "Qm...". An optional
+// /ipns/ prefix is accepted.
+func ValidateName(name string) error {
+	name = strings.TrimPrefix(name, "/ipns/")
+	if name == "" {
+		return fmt.Errorf("IPNS name is empty")
+	}
+
+	// Legacy peer IDs are plain base58 multihashes; identity-hashed Ed25519 IDs are not CIDs
+	if strings.HasPrefix(name, "1") || strings.HasPrefix(name, "Qm") {
+		if _, err := mh.FromB58String(name); err != nil {
+			return fmt.Errorf("invalid IPNS name %q: %w", name, err)
+		}
+		return nil
+	}
+
+	c, err := cid.Decode(name)
+	if err != nil {
+		return fmt.Errorf("invalid IPNS name %q: %w", name, err)
+	}
+	if c.Type() != cid.Libp2pKey {
+		return fmt.Errorf("invalid IPNS name %q: CID codec is 0x%x, not libp2p-key", name, c.Type())
+	}
+
+	return nil
+}
@@ -0,0 +1,28 @@
+package ipns
+
+import "testing"
+
+func TestValidateName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8", true},
+		{"k51qzi5uqu5dkweh3vfy3ac59oobbnehs3ojsno0sog1nbvc70kt7tgbxvmqgh", true},
+		{"/ipns/k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8", true},
+		{"12D3KooWRBy97UB99e3J6hiPesre1MZeuNQvfan4gBziswrRJsNK", true},
+		{"QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN", true},
+		{"", false},
+		{"k2k4r8notreal", false},
+		{"12D3KooWnotbase58!", false},
+		// A valid CIDv1 with the raw codec is content, not a key
+		{"bafkreihdwdcefgh4dqkjv67uzcmw7ojee6xedzdetojuzjevtenxquvyku", false},
+	}
+
+	for _, tt := range tests {
+		err := ValidateName(tt.name)
+		if (err == nil) != tt.valid {
+			t.Errorf("ValidateName(%q) = %v, want valid=%v", tt.name, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// Package manifest builds, signs and verifies collection manifests: a small JSON
+// document a publisher uploads next to its index, describing the collection and
+// how it is kept up to date. Indexers prefer its fields over the announcement's.
+package manifest
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+
+	"github.com/atregu/ipfs-common/fingerprint"
+)
+
+// FormatVersion is the version of the manifest format
+const FormatVersion = 1
+
+// FileName is the name of the manifest in the published collection directory
+const FileName = "manifest.json"
+
+// MaxSize bounds the manifest size readers accept
+const MaxSize = 16 << 10
+
+// Manifest is a signed description of a collection and its update policy
+type Manifest struct {
+	Format            int    `json:"format"`                // Manifest format version
+	Name              string `json:"name,omitempty"`        // Human readable collection name
+	Description       string `json:"description,omitempty"` // Human readable description
+	PublicKey         string `json:"publicKey"`             // Base64-encoded Ed25519 public key of the publisher
+	Fingerprint       string `json:"fingerprint"`           // Fingerprint of PublicKey
+	Topic             string `json:"topic,omitempty"`       // Topic the collection is announced on
+	HeartbeatInterval int    `json:"heartbeatInterval"`     // Seconds between periodic announcements, 0 = none
+	License           string `json:"license,omitempty"`     // License string, e.g. CC-BY-4.0
+	Contact           string `json:"contact,omitempty"`     // Optional contact URI, e.g. mailto:ops@example.org
+	Signature         string `json:"signature"`             // Base64-encoded signature
+}
+
+// Sign sets the publisher key and its fingerprint and signs the manifest
+func (m *Manifest) Sign(privateKey ed25519.PrivateKey) error {
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	m.PublicKey = base64.StdEncoding.EncodeToString(publicKey)
+	m.Fingerprint = fingerprint.Of(publicKey)
+
+	data, err := m.getBytesForSigning()
+	if err != nil {
+		return fmt.Errorf("failed to serialize manifest: %w", err)
+	}
+
+	m.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, data))
+	return nil
+}
+
+// Verify verifies the manifest signature
+func (m *Manifest) Verify() error {
+	publicKeyBytes, err := base64.StdEncoding.DecodeString(m.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode public key: %w", err)
+	}
+
+	if len(publicKeyBytes) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key size: expected %d, got %d", ed25519.PublicKeySize, len(publicKeyBytes))
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	data, err := m.getBytesForSigning()
+	if err != nil {
+		return fmt.Errorf("failed to serialize manifest: %w", err)
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(publicKeyBytes), data, signature) {
+		return fmt.Errorf("signature verification failed")
+	}
+
+	return nil
+}
+
+// Validate validates the manifest fields
+func (m *Manifest) Validate() error {
+	if m.Format != FormatVersion {
+		return fmt.Errorf("unsupported format version %d", m.Format)
+	}
+
+	if m.PublicKey == "" {
+		return fmt.Errorf("publicKey field is required")
+	}
+
+	publicKeyBytes, err := base64.StdEncoding.DecodeString(m.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode public key: %w", err)
+	}
+	if m.Fingerprint != fingerprint.Of(publicKeyBytes) {
+		return fmt.Errorf("fingerprint %q does not match the public key", m.Fingerprint)
+	}
+
+	if m.HeartbeatInterval < 0 {
+		return fmt.Errorf("invalid heartbeatInterval: must be >= 0")
+	}
+
+	if err := ValidateContact(m.Contact); err != nil {
+		return err
+	}
+
+	if m.Signature == "" {
+		return fmt.Errorf("signature field is required")
+	}
+
+	return nil
+}
+
+// ValidateContact checks that a non-empty contact is an absolute URI, such as
+// mailto:ops@example.org or https://example.org/contact
+func ValidateContact(contact string) error {
+	if contact == "" {
+		return nil
+	}
+	u, err := url.Parse(contact)
+	if err != nil || u.Scheme == "" || (u.Opaque == "" && u.Host == "") {
+		return fmt.Errorf("invalid contact %q: must be an absolute URI, e.g. mailto:ops@example.org", contact)
+	}
+	return nil
+}
+
+// getBytesForSigning returns the canonical JSON representation for signing
+func (m *Manifest) getBytesForSigning() ([]byte, error) {
+	unsigned := *m
+	unsigned.Signature = ""
+	return json.Marshal(unsigned)
+}
+
+// ToJSON returns the indented JSON document as uploaded
+func (m *Manifest) ToJSON() ([]byte, error) {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Parse parses a manifest from JSON bytes no larger than MaxSize. It does not
+// validate or verify it.
+func Parse(data []byte) (*Manifest, error) {
+	if len(data) > MaxSize {
+		return nil, fmt.Errorf("manifest of %d bytes exceeds %d bytes", len(data), MaxSize)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal manifest: %w", err)
+	}
+	return &m, nil
+}
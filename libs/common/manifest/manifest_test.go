@@ -0,0 +1,119 @@
+package manifest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+// newSignedManifest returns a manifest signed by a fresh key
+func newSignedManifest(t *testing.T) *Manifest {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	m := &Manifest{
+		Format:            FormatVersion,
+		Name:              "Field recordings",
+		Description:       "Birdsong, 2019-2024",
+		Topic:             "mdn/audio/announce",
+		HeartbeatInterval: 3600,
+		License:           "CC-BY-4.0",
+		Contact:           "mailto:ops@example.org",
+	}
+	if err := m.Sign(privateKey); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return m
+}
+
+func TestRoundTrip(t *testing.T) {
+	m := newSignedManifest(t)
+
+	data, err := m.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON: %v", err)
+	}
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if err := parsed.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	if err := parsed.Verify(); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if *parsed != *m {
+		t.Errorf("parsed %+v, want %+v", parsed, m)
+	}
+
+	// The same manifest serializes to the same bytes, so its CID is stable
+	again, err := parsed.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON: %v", err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Errorf("serialized again as %s, want %s", again, data)
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	tests := map[string]func(m *Manifest){
+		"license":   func(m *Manifest) { m.License = "All rights reserved" },
+		"heartbeat": func(m *Manifest) { m.HeartbeatInterval = 0 },
+		"contact":   func(m *Manifest) { m.Contact = "mailto:attacker@example.org" },
+		"key":       func(m *Manifest) { m.PublicKey = newSignedManifest(t).PublicKey },
+	}
+
+	for name, tamper := range tests {
+		m := newSignedManifest(t)
+		tamper(m)
+		if err := m.Verify(); err == nil {
+			t.Errorf("%s: tampered manifest verified", name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := map[string]func(m *Manifest){
+		"format":      func(m *Manifest) { m.Format = 2 },
+		"fingerprint": func(m *Manifest) { m.Fingerprint = newSignedManifest(t).Fingerprint },
+		"heartbeat":   func(m *Manifest) { m.HeartbeatInterval = -1 },
+		"contact":     func(m *Manifest) { m.Contact = "ops@example.org" },
+		"signature":   func(m *Manifest) { m.Signature = "" },
+	}
+
+	for name, invalidate := range tests {
+		m := newSignedManifest(t)
+		invalidate(m)
+		if err := m.Validate(); err == nil {
+			t.Errorf("%s: invalid manifest validated", name)
+		}
+	}
+}
+
+func TestValidateContact(t *testing.T) {
+	for _, contact := range []string{"", "mailto:ops@example.org", "https://example.org/contact", "matrix:u/ops:example.org"} {
+		if err := ValidateContact(contact); err != nil {
+			t.Errorf("ValidateContact(%q): %v", contact, err)
+		}
+	}
+	for _, contact := range []string{"ops@example.org", "example.org", "https://", "/contact"} {
+		if err := ValidateContact(contact); err == nil {
+			t.Errorf("ValidateContact(%q) accepted an invalid contact", contact)
+		}
+	}
+}
+
+func TestParseRejectsOversized(t *testing.T) {
+	data := []byte(`{"format":1,"description":"` + strings.Repeat("x", MaxSize) + `"}`)
+	if _, err := Parse(data); err == nil {
+		t.Error("oversized manifest parsed")
+	}
+}
@@ -0,0 +1,133 @@
+package query
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// announceSuffix ends the well-known mdn/<category>/announce topics
+const announceSuffix = "/announce"
+
+// MaxAge is how old a query may be before publishers ignore it, so that a
+// recorded query cannot be replayed later to make publishers re-announce
+const MaxAge = 10 * time.Minute
+
+// Topic returns the companion query topic of an announcement topic:
+// mdn/<category>/announce becomes mdn/<category>/query, any other topic gets "/query" appended
+func Topic(announceTopic string) string {
+	if strings.HasSuffix(announceTopic, announceSuffix) {
+		return strings.TrimSuffix(announceTopic, announceSuffix) + "/query"
+	}
+	return announceTopic + "/query"
+}
+
+// Query is a signed request an indexer publishes to ask publishers on the
+// announcement topic to repeat their current announcement
+type Query struct {
+	PublicKey string `json:"publicKey"` // Base64-encoded Ed25519 key of the indexer
+	Timestamp int64  `json:"timestamp"` // Unix timestamp
+	Signature string `json:"signature"` // Base64-encoded signature
+}
+
+// New creates an unsigned query
+func New() *Query {
+	return &Query{Timestamp: time.Now().Unix()}
+}
+
+// Sign signs the query with the indexer's private key
+func (q *Query) Sign(privateKey ed25519.PrivateKey) error {
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	q.PublicKey = base64.StdEncoding.EncodeToString(publicKey)
+
+	data, err := q.getBytesForSigning()
+	if err != nil {
+		return fmt.Errorf("failed to serialize query: %w", err)
+	}
+
+	q.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, data))
+	return nil
+}
+
+// Verify verifies the query signature
+func (q *Query) Verify() error {
+	publicKeyBytes, err := base64.StdEncoding.DecodeString(q.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode public key: %w", err)
+	}
+
+	if len(publicKeyBytes) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key size: expected %d, got %d", ed25519.PublicKeySize, len(publicKeyBytes))
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(q.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	data, err := q.getBytesForSigning()
+	if err != nil {
+		return fmt.Errorf("failed to serialize query: %w", err)
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(publicKeyBytes), data, signature) {
+		return fmt.Errorf("signature verification failed")
+	}
+
+	return nil
+}
+
+// Validate validates the query fields and rejects queries older than MaxAge
+func (q *Query) Validate() error {
+	if q.PublicKey == "" {
+		return fmt.Errorf("publicKey field is required")
+	}
+
+	if q.Timestamp <= 0 {
+		return fmt.Errorf("invalid timestamp: must be > 0")
+	}
+
+	now := time.Now().Unix()
+	if q.Timestamp < now-int64(MaxAge/time.Second) {
+		return fmt.Errorf("query is older than %v", MaxAge)
+	}
+
+	// Allow 1 hour of clock drift, as for announcements
+	if q.Timestamp > now+3600 {
+		return fmt.Errorf("timestamp is too far in the future")
+	}
+
+	if q.Signature == "" {
+		return fmt.Errorf("signature field is required")
+	}
+
+	return nil
+}
+
+// getBytesForSigning returns the canonical JSON representation for signing
+func (q *Query) getBytesForSigning() ([]byte, error) {
+	unsigned := *q
+	unsigned.Signature = ""
+	return json.Marshal(unsigned)
+}
+
+// ToJSON converts the query to JSON bytes with a newline separator
+func (q *Query) ToJSON() ([]byte, error) {
+	data, err := json.Marshal(q)
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// Parse parses a query from JSON bytes
+func Parse(data []byte) (*Query, error) {
+	var q Query
+	if err := json.Unmarshal(data, &q); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal query: %w", err)
+	}
+	return &q, nil
+}
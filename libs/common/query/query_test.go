@@ -0,0 +1,91 @@
+package query
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+)
+
+// newSignedQuery returns a query signed by a fresh key
+func newSignedQuery(t *testing.T) *Query {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	q := New()
+	if err := q.Sign(privateKey); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return q
+}
+
+func TestRoundTrip(t *testing.T) {
+	q := newSignedQuery(t)
+
+	data, err := q.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON: %v", err)
+	}
+	parsed, err := Parse(data)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	if err := parsed.Validate(); err != nil {
+		t.Errorf("Validate: %v", err)
+	}
+	if err := parsed.Verify(); err != nil {
+		t.Errorf("Verify: %v", err)
+	}
+	if *parsed != *q {
+		t.Errorf("parsed %+v, want %+v", parsed, q)
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	tests := map[string]func(q *Query){
+		"timestamp": func(q *Query) { q.Timestamp++ },
+		"key":       func(q *Query) { q.PublicKey = newSignedQuery(t).PublicKey },
+	}
+
+	for name, tamper := range tests {
+		q := newSignedQuery(t)
+		tamper(q)
+		if err := q.Verify(); err == nil {
+			t.Errorf("%s: tampered query verified", name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := map[string]func(q *Query){
+		"no key":   func(q *Query) { q.PublicKey = "" },
+		"stale":    func(q *Query) { q.Timestamp -= 3600 },
+		"future":   func(q *Query) { q.Timestamp += 7200 },
+		"unsigned": func(q *Query) { q.Signature = "" },
+	}
+
+	for name, breakQuery := range tests {
+		q := newSignedQuery(t)
+		breakQuery(q)
+		if err := q.Validate(); err == nil {
+			t.Errorf("%s: invalid query accepted", name)
+		}
+	}
+}
+
+func TestTopic(t *testing.T) {
+	tests := map[string]string{
+		"mdn/collections/announce": "mdn/collections/query",
+		"mdn/music/announce":       "mdn/music/query",
+		"custom-topic":             "custom-topic/query",
+	}
+
+	for topic, want := range tests {
+		if got := Topic(topic); got != want {
+			t.Errorf("Topic(%q) = %q, want %q", topic, got, want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package share
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/atregu/ipfs-common/ipns"
+)
+
+// FormatVersion is the version of the identity document format
+const FormatVersion = 1
+
+// URIPrefix prefixes share URIs; the rest is the base64url-encoded JSON document
+const URIPrefix = "mdn://share/"
+
+// Collection is a published collection referenced by an identity document
+type Collection struct {
+	IPNS    string `json:"ipns"`              // IPNS name of the collection index
+	Title   string `json:"title,omitempty"`   // Human readable title
+	Version int    `json:"version,omitempty"` // Collection version when the document was created
+}
+
+// Identity is a signed document describing a publisher and its collections
+type Identity struct {
+	Format       int          `json:"format"`                 // Document format version
+	PublicKey    string       `json:"publicKey"`              // Base64-encoded Ed25519 public key
+	PreviousKeys []string     `json:"previousKeys,omitempty"` // Base64 keys the publisher rotated away, vouched for by PublicKey
+	Collections  []Collection `json:"collections"`            // Published collections
+	Topic        string       `json:"topic,omitempty"`        // Suggested announcement topic
+	Multiaddrs   []string     `json:"multiaddrs,omitempty"`   // Optional addresses to dial the publisher
+	Timestamp    int64        `json:"timestamp"`              // Unix timestamp
+	Signature    string       `json:"signature"`              // Base64-encoded signature
+}
+
+// NewIdentity creates an unsigned identity document
+func NewIdentity(collections []Collection, topic string, multiaddrs []string) *Identity {
+	return &Identity{
+		Format:      FormatVersion,
+		Collections: collections,
+		Topic:       topic,
+		Multiaddrs:  multiaddrs,
+		Timestamp:   time.Now().Unix(),
+	}
+}
+
+// Sign signs the document with the provided private key
+func (id *Identity) Sign(privateKey ed25519.PrivateKey) error {
+	publicKey := privateKey.Public().(ed25519.PublicKey)
+	id.PublicKey = base64.StdEncoding.EncodeToString(publicKey)
+
+	data, err := id.getBytesForSigning()
+	if err != nil {
+		return fmt.Errorf("failed to serialize identity: %w", err)
+	}
+
+	id.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(privateKey, data))
+	return nil
+}
+
+// Verify verifies the document signature
+func (id *Identity) Verify() error {
+	publicKeyBytes, err := base64.StdEncoding.DecodeString(id.PublicKey)
+	if err != nil {
+		return fmt.Errorf("failed to decode public key: %w", err)
+	}
+
+	if len(publicKeyBytes) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid public key size: expected %d, got %d", ed25519.PublicKeySize, len(publicKeyBytes))
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(id.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	data, err := id.getBytesForSigning()
+	if err != nil {
+		return fmt.Errorf("failed to serialize identity: %w", err)
+	}
+
+	if !ed25519.Verify(ed25519.PublicKey(publicKeyBytes), data, signature) {
+		return fmt.Errorf("signature verification failed")
+	}
+
+	return nil
+}
+
+// Validate validates the document fields
+func (id *Identity) Validate() error {
+	if id.Format != FormatVersion {
+		return fmt.Errorf("unsupported format version %d", id.Format)
+	}
+
+	if id.PublicKey == "" {
+		return fmt.Errorf("publicKey field is required")
+	}
+
+	for i, key := range id.PreviousKeys {
+		decoded, err := base64.StdEncoding.DecodeString(key)
+		if err != nil || len(decoded) != ed25519.PublicKeySize {
+			return fmt.Errorf("previous key %d: not a base64 Ed25519 public key", i)
+		}
+		if key == id.PublicKey {
+			return fmt.Errorf("previous key %d: equals publicKey", i)
+		}
+	}
+
+	if len(id.Collections) == 0 {
+		return fmt.Errorf("at least one collection is required")
+	}
+
+	for i, c := range id.Collections {
+		if c.IPNS == "" {
+			return fmt.Errorf("collection %d: ipns field is required", i)
+		}
+		if err := ipns.ValidateName(c.IPNS); err != nil {
+			return fmt.Errorf("collection %d: %w", i, err)
+		}
+		if c.Version < 0 {
+			return fmt.Errorf("collection %d: invalid version %d", i, c.Version)
+		}
+	}
+
+	if id.Signature == "" {
+		return fmt.Errorf("signature field is required")
+	}
+
+	return nil
+}
+
+// getBytesForSigning returns the canonical JSON representation for signing
+func (id *Identity) getBytesForSigning() ([]byte, error) {
+	unsigned := *id
+	unsigned.Signature = ""
+	return json.Marshal(unsigned)
+}
+
+// ToJSON returns the indented JSON document
+func (id *Identity) ToJSON() ([]byte, error) {
+	return json.MarshalIndent(id, "", "  ")
+}
+
+// ToURI returns the document as an mdn://share/ URI
+func (id *Identity) ToURI() (string, error) {
+	data, err := json.Marshal(id)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize identity: %w", err)
+	}
+	return URIPrefix + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// Parse parses an identity from either a JSON document or an mdn://share/ URI
+func Parse(data []byte) (*Identity, error) {
+	text := strings.TrimSpace(string(data))
+
+	if strings.HasPrefix(text, URIPrefix) {
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(text, URIPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode share URI: %w", err)
+		}
+		data = decoded
+	}
+
+	var id Identity
+	if err := json.Unmarshal(data, &id); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal identity: %w", err)
+	}
+	return &id, nil
+}
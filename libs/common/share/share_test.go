@@ -0,0 +1,113 @@
+package share
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+)
+
+const testIPNS = "k51qzi5uqu5dlvj2baxnqndepeb86cbk3ng7n3i46uzyxzyqj2xjonzllnv0v8"
+
+// newSignedIdentity returns a signed document with one collection
+func newSignedIdentity(t *testing.T) *Identity {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+
+	id := NewIdentity([]Collection{{IPNS: testIPNS, Title: "Music", Version: 3}}, "mdn/collections/announce",
+		[]string{"/ip4/192.0.2.1/tcp/4001"})
+	if err := id.Sign(privateKey); err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	return id
+}
+
+func TestRoundTrip(t *testing.T) {
+	id := newSignedIdentity(t)
+
+	uri, err := id.ToURI()
+	if err != nil {
+		t.Fatalf("ToURI: %v", err)
+	}
+	if !strings.HasPrefix(uri, URIPrefix) {
+		t.Fatalf("URI %q lacks prefix %q", uri, URIPrefix)
+	}
+	doc, err := id.ToJSON()
+	if err != nil {
+		t.Fatalf("ToJSON: %v", err)
+	}
+
+	for name, data := range map[string][]byte{"uri": []byte(uri + "\n"), "json": doc} {
+		parsed, err := Parse(data)
+		if err != nil {
+			t.Fatalf("%s: Parse: %v", name, err)
+		}
+		if err := parsed.Validate(); err != nil {
+			t.Errorf("%s: Validate: %v", name, err)
+		}
+		if err := parsed.Verify(); err != nil {
+			t.Errorf("%s: Verify: %v", name, err)
+		}
+		if parsed.PublicKey != id.PublicKey || parsed.Topic != id.Topic || len(parsed.Multiaddrs) != 1 {
+			t.Errorf("%s: parsed %+v, want %+v", name, parsed, id)
+		}
+		if len(parsed.Collections) != 1 || parsed.Collections[0] != id.Collections[0] {
+			t.Errorf("%s: collections = %+v, want %+v", name, parsed.Collections, id.Collections)
+		}
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := map[string]func(id *Identity){
+		"title":   func(id *Identity) { id.Collections[0].Title = "Other" },
+		"version": func(id *Identity) { id.Collections[0].Version++ },
+		"topic":   func(id *Identity) { id.Topic = "mdn/other/announce" },
+		"previous key": func(id *Identity) {
+			id.PreviousKeys = append(id.PreviousKeys, "E8WtP2ctD8iOoZ1s95xrU55a4iYaCdlUD+auyMZfPLM=")
+		},
+		"added collection": func(id *Identity) {
+			id.Collections = append(id.Collections, Collection{IPNS: testIPNS})
+		},
+	}
+
+	for name, tamper := range tests {
+		id := newSignedIdentity(t)
+		tamper(id)
+		if err := id.Verify(); err == nil {
+			t.Errorf("%s: Verify succeeded after tampering", name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := map[string]func(id *Identity){
+		"format":        func(id *Identity) { id.Format = FormatVersion + 1 },
+		"no collection": func(id *Identity) { id.Collections = nil },
+		"empty ipns":    func(id *Identity) { id.Collections[0].IPNS = "" },
+		"invalid ipns":  func(id *Identity) { id.Collections[0].IPNS = "k2k4r8notreal" },
+		"version":       func(id *Identity) { id.Collections[0].Version = -1 },
+		"signature":     func(id *Identity) { id.Signature = "" },
+		"previous key":  func(id *Identity) { id.PreviousKeys = []string{"bm90IGEga2V5"} },
+		"current key":   func(id *Identity) { id.PreviousKeys = []string{id.PublicKey} },
+	}
+
+	for name, corrupt := range tests {
+		id := newSignedIdentity(t)
+		corrupt(id)
+		if err := id.Validate(); err == nil {
+			t.Errorf("%s: Validate succeeded", name)
+		}
+	}
+}
+
+func TestParseRejectsGarbage(t *testing.T) {
+	for _, data := range []string{"", "not json", URIPrefix + "!!!"} {
+		if _, err := Parse([]byte(data)); err == nil {
+			t.Errorf("Parse(%q) succeeded", data)
+		}
+	}
+}
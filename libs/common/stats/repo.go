@@ -0,0 +1,112 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// repoStatTimeout bounds reading the repository statistics for a single scrape or request
+const repoStatTimeout = 10 * time.Second
+
+// RepoStats contains IPFS repository usage statistics
+type RepoStats struct {
+	RepoSize   uint64 `json:"repo_size"`   // Repository size in bytes
+	StorageMax uint64 `json:"storage_max"` // Configured Datastore.StorageMax in bytes
+	NumObjects uint64 `json:"num_objects"` // Number of blocks in the repository
+	RepoPath   string `json:"repo_path"`
+	Version    string `json:"version"`
+}
+
+// Utilization returns RepoSize as a fraction of StorageMax (0 when StorageMax is unset)
+func (s *RepoStats) Utilization() float64 {
+	if s.StorageMax == 0 {
+		return 0
+	}
+	return float64(s.RepoSize) / float64(s.StorageMax)
+}
+
+// RepoStatFunc reads the current repository statistics of an IPFS node
+type RepoStatFunc func(ctx context.Context) (*RepoStats, error)
+
+// RepoCollector exports repository statistics as Prometheus gauges.
+// The statistics are read from the node on every scrape.
+type RepoCollector struct {
+	stat        RepoStatFunc
+	size        *prometheus.Desc
+	storageMax  *prometheus.Desc
+	objects     *prometheus.Desc
+	utilization *prometheus.Desc
+}
+
+// NewRepoCollector creates a collector whose metric names are prefixed with namespace
+func NewRepoCollector(namespace string, stat RepoStatFunc) *RepoCollector {
+	name := func(metric string) string {
+		return prometheus.BuildFQName(namespace, "ipfs_repo", metric)
+	}
+
+	return &RepoCollector{
+		stat:        stat,
+		size:        prometheus.NewDesc(name("size_bytes"), "Size of the IPFS repository in bytes.", nil, nil),
+		storageMax:  prometheus.NewDesc(name("storage_max_bytes"), "Configured maximum IPFS repository size in bytes.", nil, nil),
+		objects:     prometheus.NewDesc(name("objects"), "Number of objects in the IPFS repository.", nil, nil),
+		utilization: prometheus.NewDesc(name("utilization_ratio"), "IPFS repository size divided by its storage maximum.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *RepoCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.size
+	ch <- c.storageMax
+	ch <- c.objects
+	ch <- c.utilization
+}
+
+// Collect implements prometheus.Collector. A failed read is reported as an
+// invalid metric, so the scrape shows the error instead of stale values.
+func (c *RepoCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), repoStatTimeout)
+	defer cancel()
+
+	s, err := c.stat(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.size, err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.size, prometheus.GaugeValue, float64(s.RepoSize))
+	ch <- prometheus.MustNewConstMetric(c.storageMax, prometheus.GaugeValue, float64(s.StorageMax))
+	ch <- prometheus.MustNewConstMetric(c.objects, prometheus.GaugeValue, float64(s.NumObjects))
+	ch <- prometheus.MustNewConstMetric(c.utilization, prometheus.GaugeValue, s.Utilization())
+}
+
+// repoStatusResponse is the JSON body served by RepoHandler
+type repoStatusResponse struct {
+	*RepoStats
+	Utilization float64 `json:"utilization"`
+}
+
+// RepoHandler serves the repository statistics as JSON. Only GET is accepted.
+func RepoHandler(stat RepoStatFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), repoStatTimeout)
+		defer cancel()
+
+		s, err := stat(ctx)
+		if err != nil {
+			http.Error(w, "failed to read repository statistics: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(&repoStatusResponse{RepoStats: s, Utilization: s.Utilization()})
+	})
+}
@@ -0,0 +1,91 @@
+package stats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func fixedRepoStat(s *RepoStats, err error) RepoStatFunc {
+	return func(ctx context.Context) (*RepoStats, error) {
+		return s, err
+	}
+}
+
+func TestUtilization(t *testing.T) {
+	if got := (&RepoStats{RepoSize: 90, StorageMax: 100}).Utilization(); got != 0.9 {
+		t.Errorf("Utilization() = %g, want 0.9", got)
+	}
+	if got := (&RepoStats{RepoSize: 90}).Utilization(); got != 0 {
+		t.Errorf("Utilization() without StorageMax = %g, want 0", got)
+	}
+}
+
+func TestRepoCollector(t *testing.T) {
+	c := NewRepoCollector("ipfspublisher", fixedRepoStat(&RepoStats{RepoSize: 50, StorageMax: 200, NumObjects: 7}, nil))
+
+	expected := `
+# HELP ipfspublisher_ipfs_repo_objects Number of objects in the IPFS repository.
+# TYPE ipfspublisher_ipfs_repo_objects gauge
+ipfspublisher_ipfs_repo_objects 7
+# HELP ipfspublisher_ipfs_repo_size_bytes Size of the IPFS repository in bytes.
+# TYPE ipfspublisher_ipfs_repo_size_bytes gauge
+ipfspublisher_ipfs_repo_size_bytes 50
+# HELP ipfspublisher_ipfs_repo_storage_max_bytes Configured maximum IPFS repository size in bytes.
+# TYPE ipfspublisher_ipfs_repo_storage_max_bytes gauge
+ipfspublisher_ipfs_repo_storage_max_bytes 200
+# HELP ipfspublisher_ipfs_repo_utilization_ratio IPFS repository size divided by its storage maximum.
+# TYPE ipfspublisher_ipfs_repo_utilization_ratio gauge
+ipfspublisher_ipfs_repo_utilization_ratio 0.25
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRepoCollectorError(t *testing.T) {
+	c := NewRepoCollector("ipfsindexer", fixedRepoStat(nil, errors.New("node not started")))
+
+	if _, err := testutil.CollectAndLint(c); err == nil {
+		t.Fatal("expected collection error when the node is unavailable")
+	}
+}
+
+func TestRepoHandler(t *testing.T) {
+	handler := RepoHandler(fixedRepoStat(&RepoStats{RepoSize: 50, StorageMax: 100, NumObjects: 7}, nil))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/ipfs/repo", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+
+	var body struct {
+		RepoSize    uint64  `json:"repo_size"`
+		Utilization float64 `json:"utilization"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.RepoSize != 50 || body.Utilization != 0.5 {
+		t.Errorf("got %+v, want repo_size 50 and utilization 0.5", body)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/ipfs/repo", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want 405", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	RepoHandler(fixedRepoStat(nil, errors.New("down"))).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/ipfs/repo", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("failing node: status %d, want 503", rec.Code)
+	}
+}
@@ -0,0 +1,55 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRuntimeCollector(t *testing.T) {
+	c := NewRuntimeCollector("ipfsindexer")
+
+	problems, err := testutil.CollectAndLint(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range problems {
+		t.Errorf("lint %s: %s", p.Metric, p.Text)
+	}
+
+	want := 3
+	if ReadRuntimeStats().OpenFDs >= 0 {
+		want = 4
+	}
+	if n := testutil.CollectAndCount(c); n != want {
+		t.Errorf("collected %d metrics, want %d", n, want)
+	}
+	if n := testutil.CollectAndCount(c, "ipfsindexer_process_goroutines"); n != 1 {
+		t.Errorf("collected %d goroutine gauges, want 1", n)
+	}
+}
+
+func TestRuntimeHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	RuntimeHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/status/runtime", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want 200", rec.Code)
+	}
+
+	var body RuntimeStats
+	if err := json.NewDecoder(rec.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Goroutines <= 0 || body.HeapAlloc == 0 {
+		t.Errorf("got %+v, want a positive goroutine count and heap size", body)
+	}
+
+	rec = httptest.NewRecorder()
+	RuntimeHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/status/runtime", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want 405", rec.Code)
+	}
+}
@@ -0,0 +1,32 @@
+// Package topic holds the rules for announcement topics shared by publishers
+// and indexers: the well-known default and the mdn/<category>/announce scheme.
+package topic
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Default is the well-known announcement topic shared by publishers and indexers
+const Default = "mdn/collections/announce"
+
+// MaxLength is the maximum allowed length of a PubSub topic
+const MaxLength = 128
+
+// pattern matches topics of the form mdn/<category>/announce
+var pattern = regexp.MustCompile(`^mdn/[a-z0-9][a-z0-9_-]*/announce$`)
+
+// Validate checks that a topic follows the mdn/<category>/announce scheme.
+// Categories may contain lowercase letters, digits, '-' and '_'.
+func Validate(topic string) error {
+	if topic == "" {
+		return fmt.Errorf("topic cannot be empty")
+	}
+	if len(topic) > MaxLength {
+		return fmt.Errorf("topic %q is longer than %d characters", topic, MaxLength)
+	}
+	if !pattern.MatchString(topic) {
+		return fmt.Errorf("topic %q does not match the scheme mdn/<category>/announce", topic)
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package topic
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		topic string
+		valid bool
+	}{
+		{Default, true},
+		{"mdn/video-2/announce", true},
+		{"mdn/collection_x/announce", true},
+		{"", false},
+		{"mdn/collections/announces", false},
+		{"mdn/Collections/announce", false},
+		{"mdn//announce", false},
+		{"other/collections/announce", false},
+		{"mdn/" + strings.Repeat("a", MaxLength) + "/announce", false},
+	}
+
+	for _, tt := range tests {
+		err := Validate(tt.topic)
+		if (err == nil) != tt.valid {
+			t.Errorf("Validate(%q) error = %v, want valid = %t", tt.topic, err, tt.valid)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Package tracing sets up the optional OpenTelemetry tracing of the publish and
+// ingest pipelines and carries trace context inside announcements, so the spans
+// of the publisher and the indexer join into one trace.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation names the tracer of both apps
+const instrumentation = "github.com/atregu/ipfs-common/tracing"
+
+// propagator encodes trace context as a W3C traceparent
+var propagator = propagation.TraceContext{}
+
+// Setup exports the spans of service over OTLP, configured by the standard
+// OTEL_EXPORTER_OTLP_* variables: OTEL_EXPORTER_OTLP_PROTOCOL selects "grpc" or
+// the default "http/protobuf", and OTEL_SERVICE_NAME and
+// OTEL_RESOURCE_ATTRIBUTES describe the process. The returned function flushes
+// and stops the exporter. Until Setup is called every span is a no-op.
+func Setup(ctx context.Context, service string) (func(context.Context) error, error) {
+	exporter, err := newExporter(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(service)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe the service: %w", err)
+	}
+	// Variables set by the operator take precedence over the service name given here
+	if env, err := resource.New(ctx, resource.WithFromEnv()); err == nil {
+		if merged, err := resource.Merge(res, env); err == nil {
+			res = merged
+		}
+	}
+
+	Use(sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res)))
+	return exporter.Shutdown, nil
+}
+
+// newExporter creates the OTLP exporter selected by OTEL_EXPORTER_OTLP_PROTOCOL
+func newExporter(ctx context.Context) (*otlptrace.Exporter, error) {
+	protocol := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_PROTOCOL")
+	if protocol == "" {
+		protocol = os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+
+	switch protocol {
+	case "", "http/protobuf":
+		return otlptracehttp.New(ctx)
+	case "grpc":
+		return otlptracegrpc.New(ctx)
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q (want grpc or http/protobuf)", protocol)
+	}
+}
+
+// Use makes provider the source of all spans, e.g. a provider with an
+// in-memory exporter in tests
+func Use(provider trace.TracerProvider) {
+	otel.SetTracerProvider(provider)
+}
+
+// Start starts a span named name as a child of the span in ctx
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentation).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends span, recording err if it is not nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		fail(span, err)
+	}
+	span.End()
+}
+
+// Fail records err on the span in ctx without ending it
+func Fail(ctx context.Context, err error) {
+	fail(trace.SpanFromContext(ctx), err)
+}
+
+// fail marks span as failed with err
+func fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Inject returns the W3C traceparent of the span in ctx, or "" if it has none
+// or tracing is off
+func Inject(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// Extract returns ctx with the remote span of traceparent as its parent, or
+// ctx itself if traceparent is empty or malformed
+func Extract(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier{"traceparent": traceparent})
+}
@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// record makes every span go to an in-memory exporter until the test ends
+func record(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	Use(provider)
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		Use(noop.NewTracerProvider())
+	})
+	return exporter
+}
+
+func TestTraceparentJoinsSpans(t *testing.T) {
+	exporter := record(t)
+
+	ctx, send := Start(context.Background(), "announce")
+	traceparent := Inject(ctx)
+	End(send, nil)
+	if traceparent == "" {
+		t.Fatal("no traceparent for a recorded span")
+	}
+
+	ctx, receive := Start(Extract(context.Background(), traceparent), "receive")
+	Fail(ctx, errors.New("parse failed"))
+	End(receive, nil)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("recorded %d spans, want 2", len(spans))
+	}
+	sent, received := spans[0], spans[1]
+	if received.Parent.TraceID() != sent.SpanContext.TraceID() || received.Parent.SpanID() != sent.SpanContext.SpanID() {
+		t.Errorf("receive span parent = %v, want the announce span %v", received.Parent, sent.SpanContext)
+	}
+	if !received.Parent.IsRemote() {
+		t.Error("receive span parent is not remote")
+	}
+	if received.Status.Code != codes.Error || len(received.Events) != 1 {
+		t.Errorf("receive span status = %v with %d events, want the recorded error", received.Status, len(received.Events))
+	}
+}
+
+func TestDisabledTracing(t *testing.T) {
+	Use(noop.NewTracerProvider())
+
+	ctx, span := Start(context.Background(), "scan")
+	defer span.End()
+	if span.IsRecording() {
+		t.Error("span recorded with tracing off")
+	}
+	if traceparent := Inject(ctx); traceparent != "" {
+		t.Errorf("traceparent %q with tracing off", traceparent)
+	}
+
+	for _, bad := range []string{"", "not-a-traceparent"} {
+		if ctx := Extract(context.Background(), bad); ctx != context.Background() {
+			if _, span := Start(ctx, "receive"); span.SpanContext().IsValid() {
+				t.Errorf("Extract(%q) gave a valid parent", bad)
+			}
+		}
+	}
+}